@@ -0,0 +1,178 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/cybrarymin/greenlight/internal/data"
+	mailer "github.com/cybrarymin/greenlight/internal/mailter"
+	"go.opentelemetry.io/otel/codes"
+)
+
+func (app *application) showRuntimeConfigHandler(w http.ResponseWriter, r *http.Request) {
+	_, span := app.startSpan(r, "showRuntimeConfig")
+	defer span.End()
+
+	err := app.writeJson(w, r, http.StatusOK, envelope{"config": app.runtimeCfg()}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateRuntimeConfigHandler(w http.ResponseWriter, r *http.Request) {
+	_, span := app.startSpan(r, "updateRuntimeConfig")
+	defer span.End()
+
+	var input struct {
+		LogLevel           *int8                   `json:"log_level"`
+		RateLimitEnabled   *bool                   `json:"rate_limit_enabled"`
+		GlobalRateLimit    *int64                  `json:"global_rate_limit"`
+		PerClientRateLimit *int64                  `json:"per_client_rate_limit"`
+		AnonymousRateLimit *int64                  `json:"anonymous_rate_limit"`
+		MaintenanceMode    *bool                   `json:"maintenance_mode"`
+		CORSTrustedOrigins *[]string               `json:"cors_trusted_origins"`
+		EmailDomains       *data.EmailDomainPolicy `json:"email_domains"`
+	}
+
+	err := app.readJson(w, r, &input)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, otelunprocessableErr)
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	app.runtimeMu.Lock()
+	defer app.runtimeMu.Unlock()
+
+	nrc := app.runtimeCfg().clone()
+	if input.LogLevel != nil {
+		nrc.LogLevel = *input.LogLevel
+	}
+	if input.RateLimitEnabled != nil {
+		nrc.RateLimitEnabled = *input.RateLimitEnabled
+	}
+	if input.GlobalRateLimit != nil {
+		nrc.GlobalRateLimit = *input.GlobalRateLimit
+	}
+	if input.PerClientRateLimit != nil {
+		nrc.PerClientRateLimit = *input.PerClientRateLimit
+	}
+	if input.AnonymousRateLimit != nil {
+		nrc.AnonymousRateLimit = *input.AnonymousRateLimit
+	}
+	if input.MaintenanceMode != nil {
+		nrc.MaintenanceMode = *input.MaintenanceMode
+	}
+	if input.CORSTrustedOrigins != nil {
+		nrc.CORSTrustedOrigins = *input.CORSTrustedOrigins
+	}
+	if input.EmailDomains != nil {
+		nrc.EmailDomains = *input.EmailDomains
+	}
+
+	nValidator := data.NewValidator()
+	nrc.validate(nValidator)
+	if !nValidator.Valid() {
+		span.RecordError(errors.New(createKeyValuePairs(nValidator.Errors)))
+		span.SetStatus(codes.Error, otelunprocessableErr)
+		app.failedValidationResponse(w, r, nValidator)
+		return
+	}
+
+	app.swapRuntimeConfig(nrc)
+	app.events(r.Context()).Info("runtime configuration updated", nil)
+
+	err = app.writeJson(w, r, http.StatusOK, envelope{"config": nrc}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// mailPreviewData holds the sample data used to render each mail template for preview
+var mailPreviewData = map[string]interface{}{
+	"user_welcome.tpl": userWelcomeMailData{
+		ID:   "3f1f1a4e-6f0a-4c4e-9c55-0c6b0f3b8f2d",
+		Code: "ABCDEFGHIJKLMNOPQRSTUVWXYZ",
+	},
+	"registration_invitation.tpl": registrationInvitationMailData{
+		Token:  "am9obkBleGFtcGxlLmNvbXwxNzAwMDAwMDAw.c2lnbmF0dXJl",
+		Expiry: "Tue, 14 Nov 2023 22:13:20 UTC",
+	},
+	"user_invitation.tpl": userInvitationMailData{
+		Name: "Jane Doe",
+		ID:   "3f1f1a4e-6f0a-4c4e-9c55-0c6b0f3b8f2d",
+		Code: "ABCDEFGHIJKLMNOPQRSTUVWXYZ",
+	},
+	"new_signin.tpl": newSignInMailData{
+		Name:        "Jane Doe",
+		Time:        "Tue, 14 Nov 2023 22:13:20 UTC",
+		RemoteAddr:  "203.0.113.7",
+		UserAgent:   "Mozilla/5.0 (X11; Linux x86_64) Firefox/119.0",
+		EventID:     42,
+		RevokeToken: "ABCDEFGHIJKLMNOPQRSTUVWXYZ",
+	},
+	"saved_search_alert.tpl": savedSearchAlertMailData{
+		Name:     "Jane Doe",
+		Search:   "new action movies",
+		SearchID: 7,
+		Movies:   []data.Movie{{ID: 1, Title: "Avengers: Endgame", Year: 2019}, {ID: 2, Title: "John Wick: Chapter 4", Year: 2023}},
+		More:     3,
+	},
+	"password_reset.tpl": passwordResetMailData{
+		Name:   "Jane Doe",
+		ID:     "3f1f1a4e-6f0a-4c4e-9c55-0c6b0f3b8f2d",
+		Token:  "ABCDEFGHIJKLMNOPQRSTUVWXYZ",
+		Expiry: "Tue, 14 Nov 2023 22:13:20 UTC",
+	},
+}
+
+// previewMailHandler renders a mail template with sample data without sending any email.
+// The rendered mail will be returned as json unless format=html query parameter is provided.
+func (app *application) previewMailHandler(w http.ResponseWriter, r *http.Request) {
+	_, span := app.startSpan(r, "previewMail")
+	defer span.End()
+
+	qs := r.URL.Query()
+	templateName := app.readString(qs, "template", "")
+	format := app.readString(qs, "format", "json")
+
+	nValidator := data.NewValidator()
+	nValidator.Check(templateName != "", "template", "must be provided")
+	nValidator.Check(data.In(format, "json", "html"), "format", "must be json or html")
+	if !nValidator.Valid() {
+		span.RecordError(errors.New(createKeyValuePairs(nValidator.Errors)))
+		span.SetStatus(codes.Error, otelunprocessableErr)
+		app.failedValidationResponse(w, r, nValidator)
+		return
+	}
+	if !strings.HasSuffix(templateName, ".tpl") {
+		templateName += ".tpl"
+	}
+
+	rendered, err := app.mailer.Render(templateName, mailPreviewData[templateName])
+	if err != nil {
+		span.RecordError(err)
+		switch {
+		case errors.Is(err, mailer.ErrTemplateNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			span.SetStatus(codes.Error, "failed to render the mail template")
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if format == "html" {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(rendered.HTMLBody))
+		return
+	}
+
+	err = app.writeJson(w, r, http.StatusOK, envelope{"preview": rendered}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,261 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/cybrarymin/greenlight/internal/data"
+	"go.opentelemetry.io/otel/codes"
+)
+
+func (app *application) createMovieAssetHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := app.startSpan(r, "createMovieAsset")
+	defer span.End()
+
+	id := app.idParam(r, "id")
+
+	var input struct {
+		Type     string `json:"type"`
+		URL      string `json:"url"`
+		BlobKey  string `json:"blob_key"`
+		Quality  string `json:"quality"`
+		Language string `json:"language"`
+	}
+	err := app.readJson(w, r, &input)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, otelunprocessableErr)
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	asset := &data.MovieAsset{
+		MovieID:  id,
+		Type:     input.Type,
+		URL:      input.URL,
+		BlobKey:  input.BlobKey,
+		Quality:  input.Quality,
+		Language: input.Language,
+	}
+	nValidator := data.NewValidator()
+	data.ValidateMovieAsset(nValidator, asset)
+	if !nValidator.Valid() {
+		span.RecordError(errors.New(createKeyValuePairs(nValidator.Errors)))
+		span.SetStatus(codes.Error, otelunprocessableErr)
+		app.failedValidationResponse(w, r, nValidator)
+		return
+	}
+
+	_, err = app.models.Movies.Select(ctx, id)
+	if err != nil {
+		span.RecordError(err)
+		switch {
+		case errors.Is(err, data.ErrorRecordNotFound):
+			span.SetStatus(codes.Ok, otelDBNotFoundInfo)
+			app.notFoundResponse(w, r)
+		default:
+			span.SetStatus(codes.Error, otelDBErr)
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.MovieAssets.Insert(ctx, asset)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, otelDBErr)
+		app.dbWriteErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/movies/%d/assets/%d", asset.MovieID, asset.ID))
+	err = app.writeJson(w, r, http.StatusCreated, envelope{"Asset": asset}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listMovieAssetsHandler lists the playable media of the movie, optionally of a single type
+func (app *application) listMovieAssetsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := app.startSpan(r, "listMovieAssets")
+	defer span.End()
+
+	id := app.idParam(r, "id")
+	nValidator := data.NewValidator()
+	assetType := app.readEnum(r.URL.Query(), "type", "", []string{data.AssetTrailer, data.AssetClip, data.AssetFull}, nValidator)
+	if !nValidator.Valid() {
+		span.RecordError(errors.New(createKeyValuePairs(nValidator.Errors)))
+		span.SetStatus(codes.Error, otelunprocessableErr)
+		app.failedValidationResponse(w, r, nValidator)
+		return
+	}
+
+	_, err := app.models.Movies.Select(ctx, id)
+	if err != nil {
+		span.RecordError(err)
+		switch {
+		case errors.Is(err, data.ErrorRecordNotFound):
+			span.SetStatus(codes.Ok, otelDBNotFoundInfo)
+			app.notFoundResponse(w, r)
+		default:
+			span.SetStatus(codes.Error, otelDBErr)
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	assets, err := app.models.MovieAssets.ListForMovie(ctx, id, assetType)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, otelDBErr)
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJson(w, r, http.StatusOK, envelope{"Assets": assets}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showMovieAssetHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := app.startSpan(r, "showMovieAsset")
+	defer span.End()
+
+	id := app.idParam(r, "id")
+	assetID := app.idParam(r, "asset_id")
+
+	asset, err := app.models.MovieAssets.Get(ctx, id, assetID)
+	if err != nil {
+		span.RecordError(err)
+		switch {
+		case errors.Is(err, data.ErrorRecordNotFound):
+			span.SetStatus(codes.Ok, otelDBNotFoundInfo)
+			app.notFoundResponse(w, r)
+		default:
+			span.SetStatus(codes.Error, otelDBErr)
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJson(w, r, http.StatusOK, envelope{"Asset": asset}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateMovieAssetHandler partially updates the asset. Setting url clears blob_key and the other way around
+// so the source of an asset can be switched in a single request.
+func (app *application) updateMovieAssetHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := app.startSpan(r, "updateMovieAsset")
+	defer span.End()
+
+	id := app.idParam(r, "id")
+	assetID := app.idParam(r, "asset_id")
+
+	var input struct {
+		Type     *string `json:"type"`
+		URL      *string `json:"url"`
+		BlobKey  *string `json:"blob_key"`
+		Quality  *string `json:"quality"`
+		Language *string `json:"language"`
+		Version  *int32  `json:"version"`
+	}
+	err := app.readJson(w, r, &input)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, otelunprocessableErr)
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	var asset *data.MovieAsset
+	nValidator := data.NewValidator()
+	errInvalidAsset := errors.New("invalid movie asset")
+	err = data.RetryOnEditConflict(func() error {
+		var err error
+		asset, err = app.models.MovieAssets.Get(ctx, id, assetID)
+		if err != nil {
+			return err
+		}
+		if input.Version != nil && *input.Version != asset.Version {
+			return data.ErrEditConflict
+		}
+		if input.Type != nil {
+			asset.Type = *input.Type
+		}
+		if input.URL != nil {
+			asset.URL, asset.BlobKey = *input.URL, ""
+		}
+		if input.BlobKey != nil {
+			asset.BlobKey = *input.BlobKey
+			if input.URL == nil {
+				asset.URL = ""
+			}
+		}
+		if input.Quality != nil {
+			asset.Quality = *input.Quality
+		}
+		if input.Language != nil {
+			asset.Language = *input.Language
+		}
+		nValidator = data.NewValidator()
+		data.ValidateMovieAsset(nValidator, asset)
+		if !nValidator.Valid() {
+			return errInvalidAsset
+		}
+		return app.models.MovieAssets.Update(ctx, asset)
+	})
+	if err != nil {
+		span.RecordError(err)
+		switch {
+		case errors.Is(err, data.ErrorRecordNotFound):
+			span.SetStatus(codes.Ok, otelDBNotFoundInfo)
+			app.notFoundResponse(w, r)
+		case errors.Is(err, errInvalidAsset):
+			span.SetStatus(codes.Error, otelunprocessableErr)
+			app.failedValidationResponse(w, r, nValidator)
+		case errors.Is(err, data.ErrEditConflict):
+			span.SetStatus(codes.Error, otelDBErr)
+			app.editConflictResponse(w, r)
+		default:
+			span.SetStatus(codes.Error, otelDBErr)
+			app.dbWriteErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJson(w, r, http.StatusOK, envelope{"Asset": asset}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteMovieAssetHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := app.startSpan(r, "deleteMovieAsset")
+	defer span.End()
+
+	id := app.idParam(r, "id")
+	assetID := app.idParam(r, "asset_id")
+
+	err := app.models.MovieAssets.Delete(ctx, id, assetID)
+	if err != nil {
+		span.RecordError(err)
+		switch {
+		case errors.Is(err, data.ErrorRecordNotFound):
+			span.SetStatus(codes.Ok, otelDBNotFoundInfo)
+			app.notFoundResponse(w, r)
+		default:
+			span.SetStatus(codes.Error, otelDBErr)
+			app.dbWriteErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJson(w, r, http.StatusOK, envelope{"message": "asset deleted successfully"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,66 @@
+package api
+
+import (
+	"sync"
+	"time"
+)
+
+var (
+	// ActivationMaxAttempts is the number of failed activations an account is allowed within ActivationAttemptWindow
+	ActivationMaxAttempts int
+	// ActivationAttemptWindow is how long the failed activations of an account are counted for
+	ActivationAttemptWindow time.Duration
+)
+
+// attemptLimiter counts the failed attempts per key, e.g. the account, within a window starting from the first failure.
+// Keys reaching the limit are locked until their window is over. The zero value is ready to use.
+type attemptLimiter struct {
+	mu       sync.Mutex
+	failures map[string]*attemptWindow
+}
+
+type attemptWindow struct {
+	count   int
+	resetAt time.Time
+}
+
+// allow reports whether the key has failed less than max times within its window
+func (a *attemptLimiter) allow(key string, max int, now time.Time) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	window, found := a.failures[key]
+	if !found || !now.Before(window.resetAt) {
+		return true
+	}
+	return window.count < max
+}
+
+// fail records a failed attempt of the key. The window of the key is removed once it's over.
+func (a *attemptLimiter) fail(key string, window time.Duration, now time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.failures == nil {
+		a.failures = make(map[string]*attemptWindow)
+	}
+	w, found := a.failures[key]
+	if found && now.Before(w.resetAt) {
+		w.count++
+		return
+	}
+	w = &attemptWindow{count: 1, resetAt: now.Add(window)}
+	a.failures[key] = w
+	time.AfterFunc(window, func() {
+		a.mu.Lock()
+		if a.failures[key] == w {
+			delete(a.failures, key)
+		}
+		a.mu.Unlock()
+	})
+}
+
+// reset forgets the failed attempts of the key, e.g. once it has succeeded
+func (a *attemptLimiter) reset(key string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.failures, key)
+}
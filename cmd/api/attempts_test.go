@@ -0,0 +1,39 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAttemptLimiter(t *testing.T) {
+	now := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		failures int
+		elapsed  time.Duration
+		reset    bool
+		allowed  bool
+	}{
+		{name: "No failures", failures: 0, allowed: true},
+		{name: "Failures below the limit", failures: 2, allowed: true},
+		{name: "Failures reaching the limit", failures: 3, allowed: false},
+		{name: "Window is over", failures: 3, elapsed: time.Minute, allowed: true},
+		{name: "Reset after a success", failures: 3, reset: true, allowed: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			limiter := &attemptLimiter{}
+			for i := 0; i < tc.failures; i++ {
+				limiter.fail("account", time.Minute, now)
+			}
+			if tc.reset {
+				limiter.reset("account")
+			}
+			assert.Equal(t, tc.allowed, limiter.allow("account", 3, now.Add(tc.elapsed)))
+			assert.True(t, limiter.allow("other account", 3, now))
+		})
+	}
+}
@@ -1,36 +1,28 @@
 package api
 
 import (
+	"context"
 	"errors"
 	"net/http"
-	"time"
 
 	"github.com/cybrarymin/greenlight/internal/data"
 	"github.com/golang-jwt/jwt/v5"
-	"github.com/google/uuid"
-	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
 )
 
 var JWTKEY string
 
+// createBearerTokenHandler is the legacy alias of POST /v1/tokens issuing the opaque tokens. It keeps its original response.
 func (app *application) createBearerTokenHandler(w http.ResponseWriter, r *http.Request) {
-	ctx, span := otel.Tracer("createBearerToken.handler.tracer").Start(r.Context(), "createBearerToken.handler.span")
+	ctx, span := app.startSpan(r, "createBearerToken")
 	defer span.End()
 
-	ok, nUser := app.BasicAuth(w, r)
+	token, ok := app.issueLegacyToken(ctx, w, r, TokenOpaque)
 	if !ok {
 		return
 	}
-	nBToken, err := app.models.Tokens.New(ctx, time.Hour*24, nUser.ID, data.AuthenticationScope)
-	if err != nil {
-		span.RecordError(err)
-		span.SetStatus(codes.Error, otelDBErr)
-		app.serverErrorResponse(w, r, err)
-		return
-	}
-	err = app.writeJson(w, http.StatusCreated, envelope{"result": nBToken}, nil)
+	err := app.writeJson(w, r, http.StatusCreated, envelope{"result": map[string]interface{}{"token": token.Token, "expiry": token.Expiry, "scope": data.AuthenticationScope}}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -40,6 +32,8 @@ func (app *application) createBearerTokenHandler(w http.ResponseWriter, r *http.
 
 type customClaims struct {
 	Email string `json:"email"`
+	// Scope narrows the token to the space separated permissions. check tokenScope
+	Scope string `json:"scope,omitempty"`
 	jwt.RegisteredClaims
 }
 
@@ -54,47 +48,41 @@ func (c *customClaims) Validate() error {
 	return nil
 }
 
-/*
-Authenticating user using basic authentication method. If user is valid it's gonna issue a JWT Token to the user
-*/
+// createJWTTokenHandler is the legacy alias of POST /v1/tokens issuing the jwts. It keeps its original response.
 func (app *application) createJWTTokenHandler(w http.ResponseWriter, r *http.Request) {
-	_, span := otel.Tracer("createJWTToken.handler.tracer").Start(r.Context(), "createJWTToken.handler.span")
+	ctx, span := app.startSpan(r, "createJWTToken")
 	defer span.End()
 
-	ok, nUser := app.BasicAuth(w, r)
+	token, ok := app.issueLegacyToken(ctx, w, r, TokenJWT)
 	if !ok {
 		return
 	}
-	claims := customClaims{
-		Email: nUser.Email,
-		RegisteredClaims: jwt.RegisteredClaims{
-			Issuer:    "greenlight.example.com",
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour * 24 * 3)),
-			Subject:   nUser.Email,
-			Audience:  []string{"greenlight.example.com"},
-			NotBefore: jwt.NewNumericDate(time.Now()),
-			ID:        uuid.New().String(),
-		},
-	}
-	span.SetAttributes(attribute.String("claims.email", claims.Email))
-	span.SetAttributes(attribute.String("claims.issuer", claims.Issuer))
-	span.SetAttributes(attribute.String("claims.subject", claims.Subject))
-	span.SetAttributes(attribute.StringSlice("claims.audience", claims.Audience))
-	span.SetAttributes(attribute.String("claims.id", claims.ID))
-
-	jToken := jwt.NewWithClaims(jwt.SigningMethodHS256, claims, func(t *jwt.Token) {})
-
-	signedToken, err := jToken.SignedString([]byte(JWTKEY))
+	err := app.writeJson(w, r, http.StatusOK, envelope{"result": map[string]string{"token": token.Token}}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
-	err = app.writeJson(w, http.StatusOK, envelope{"result": map[string]string{"token": signedToken}}, nil)
+}
+
+// issueLegacyToken authenticates the user by basic auth and issues a token of the type of the legacy route,
+// as long as --token-types still allows it. It responds on failure.
+func (app *application) issueLegacyToken(ctx context.Context, w http.ResponseWriter, r *http.Request, tokenType string) (*issuedToken, bool) {
+	ok, nUser := app.BasicAuth(w, r)
+	if !ok {
+		return nil, false
+	}
+	nVal := data.NewValidator()
+	validateTokenType(nVal, tokenType)
+	if !nVal.Valid() {
+		app.failedValidationResponse(w, r, nVal)
+		return nil, false
+	}
+	token, err := app.issueToken(ctx, r, nUser, tokenType, nil, "")
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
-		return
+		return nil, false
 	}
+	return token, true
 }
 
 /*
@@ -102,7 +90,7 @@ Authenticates the user using basic authentication method.
 in case of successfull authentication it returns ok plus userinfo
 */
 func (app *application) BasicAuth(w http.ResponseWriter, r *http.Request) (bool, *data.User) {
-	ctx, span := otel.Tracer("basicAuth.handler.tracer").Start(r.Context(), "basicAuth.handler.span")
+	ctx, span := app.startSpan(r, "basicAuth")
 	defer span.End()
 
 	email, pass, ok := r.BasicAuth()
@@ -156,6 +144,18 @@ func (app *application) BasicAuth(w http.ResponseWriter, r *http.Request) (bool,
 		app.invalidAuthenticationCredResponse(w, r)
 		return false, nil
 	}
+	// no token is issued to the suspended users. check rejectSuspendedUser
+	if nUser.Suspended() {
+		span.SetStatus(codes.Error, otelAuthFailureErr)
+		app.accountSuspendedResponse(w, r, nUser)
+		return false, nil
+	}
+	// the password has been invalidated by an administrator. check forcePasswordResetHandler
+	if nUser.PasswordResetRequired {
+		span.SetStatus(codes.Error, otelAuthFailureErr)
+		app.passwordResetRequiredResponse(w, r)
+		return false, nil
+	}
 
 	return true, nUser
 }
@@ -0,0 +1,47 @@
+package api
+
+import (
+	"context"
+
+	"github.com/cybrarymin/greenlight/internal/data"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/baggage"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// baggageUserID is the baggage member and the span attribute carrying the id of the authenticated user,
+// so the traces can be filtered by the user in jaeger
+const baggageUserID = "enduser.id"
+
+// withUserBaggage sets the id of the user on the baggage and on the spans of the request. The member sent by the client
+// is dropped for the anonymous users so it can't pass for an authenticated one.
+func withUserBaggage(ctx context.Context, u *data.User) context.Context {
+	bag := baggage.FromContext(ctx).DeleteMember(baggageUserID)
+	if !u.IsAnonymous() {
+		member, err := baggage.NewMember(baggageUserID, u.ID.String())
+		if err == nil {
+			bag, _ = bag.SetMember(member)
+		}
+		attr := attribute.String(baggageUserID, u.ID.String())
+		trace.SpanFromContext(ctx).SetAttributes(attr)
+		if span, ok := ctx.Value(serverSpanContextKey).(trace.Span); ok {
+			span.SetAttributes(attr)
+		}
+	}
+	return baggage.ContextWithBaggage(ctx, bag)
+}
+
+// userSpanProcessor sets the id of the authenticated user on every span started under the request, like the database
+// spans of otelsql. It's read from the user of the context rather than the baggage, which the clients may send.
+type userSpanProcessor struct{}
+
+func (userSpanProcessor) OnStart(parent context.Context, s sdktrace.ReadWriteSpan) {
+	if u, ok := parent.Value(userContextKey).(*data.User); ok && !u.IsAnonymous() {
+		s.SetAttributes(attribute.String(baggageUserID, u.ID.String()))
+	}
+}
+
+func (userSpanProcessor) OnEnd(sdktrace.ReadOnlySpan)      {}
+func (userSpanProcessor) Shutdown(context.Context) error   { return nil }
+func (userSpanProcessor) ForceFlush(context.Context) error { return nil }
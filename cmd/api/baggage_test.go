@@ -0,0 +1,58 @@
+package api
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cybrarymin/greenlight/internal/data"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/baggage"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestUserBaggage(t *testing.T) {
+	// the client sends a baggage claiming to be another user
+	spoofed, err := baggage.Parse(baggageUserID + "=00000000-0000-0000-0000-000000000001")
+	require.NoError(t, err)
+	user := &data.User{ID: uuid.New()}
+
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(userSpanProcessor{}), sdktrace.WithSpanProcessor(recorder))
+	tracer := tp.Tracer("test")
+
+	tests := []struct {
+		name string
+		user *data.User
+		want string
+	}{
+		{name: "Authenticated user", user: user, want: user.ID.String()},
+		{name: "Anonymous user", user: data.AnonymousUser},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, server := tracer.Start(baggage.ContextWithBaggage(context.Background(), spoofed), "server")
+			ctx = context.WithValue(ctx, serverSpanContextKey, server)
+			ctx = context.WithValue(withUserBaggage(ctx, tc.user), userContextKey, tc.user)
+			_, db := tracer.Start(ctx, "db")
+			db.End()
+			server.End()
+
+			assert.Equal(t, tc.want, baggage.FromContext(ctx).Member(baggageUserID).Value())
+			spans := recorder.Ended()
+			for _, span := range spans[len(spans)-2:] {
+				var got string
+				for _, attr := range span.Attributes() {
+					if attr.Key == attribute.Key(baggageUserID) {
+						got = attr.Value.AsString()
+					}
+				}
+				assert.Equal(t, tc.want, got, span.Name())
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package api
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+var (
+	// MaxBodySize is the size limit of the request bodies in bytes which applies when no override matches the request
+	MaxBodySize int64
+	// MaxBodySizeRoutes overrides MaxBodySize for the routes, keyed by the method and the path of the route
+	// without the version prefix, like "POST /users/import". Path parameters match any segment.
+	MaxBodySizeRoutes map[string]int64
+	// MaxBodySizeContentTypes overrides MaxBodySize for the media types of the request body, like "text/csv".
+	// The route overrides take precedence.
+	MaxBodySizeContentTypes map[string]int64
+)
+
+// bodyLimit returns the size limit of the request body
+func bodyLimit(r *http.Request) int64 {
+	path := r.URL.Path
+	if version := apiVersionPrefix(path); version != 0 {
+		path = strings.TrimPrefix(path, fmt.Sprintf("/v%d", version))
+	}
+	for route, limit := range MaxBodySizeRoutes {
+		method, pattern, found := strings.Cut(route, " ")
+		if found && method == r.Method && matchRoute(pattern, path) {
+			return limit
+		}
+	}
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err == nil {
+		if limit, ok := MaxBodySizeContentTypes[mediaType]; ok {
+			return limit
+		}
+	}
+	return MaxBodySize
+}
+
+// matchRoute reports whether the path matches the pattern of a route, where the :name segments match any segment
+func matchRoute(pattern, path string) bool {
+	patternSegments := strings.Split(strings.Trim(pattern, "/"), "/")
+	pathSegments := strings.Split(strings.Trim(path, "/"), "/")
+	if len(patternSegments) != len(pathSegments) {
+		return false
+	}
+	for i, segment := range patternSegments {
+		if !strings.HasPrefix(segment, ":") && segment != pathSegments[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// limitRequestBody enforces the size limit of the request body before the handlers run. The requests declaring a larger
+// Content-Length are rejected right away, the others fail once they read past the limit. check requestTooLargeResponse
+// The max_body_size of the route policies takes precedence over the flags.
+func (app *application) limitRequestBody(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit := bodyLimit(r)
+		if policy := app.routePolicies.match(r); policy != nil && policy.MaxBodySize > 0 {
+			limit = policy.MaxBodySize
+		}
+		if r.ContentLength > limit {
+			app.requestTooLargeResponse(w, r, limit)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,126 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"sync"
+)
+
+// buildDependencies are the modules whose versions are reported along with the build, the ones behaving differently across versions
+var buildDependencies = []string{
+	"github.com/uptrace/bun",
+	"github.com/uptrace/bun/driver/pgdriver",
+	"github.com/jackc/pgx/v5",
+	"github.com/go-chi/chi/v5",
+	"github.com/golang-jwt/jwt/v5",
+	"github.com/rs/zerolog",
+	"github.com/prometheus/client_golang",
+	"go.opentelemetry.io/otel",
+}
+
+// buildInfo describes the binary. Version and BuildTime are set by the linker flags of the Makefile,
+// the rest is read from the build info embedded by the go toolchain.
+type buildInfo struct {
+	Version      string            `json:"version"`
+	BuildTime    string            `json:"build_time"`
+	GoVersion    string            `json:"go_version"`
+	Platform     string            `json:"platform"`
+	Revision     string            `json:"revision"`
+	RevisionTime string            `json:"revision_time"`
+	Modified     bool              `json:"modified"`
+	Dependencies map[string]string `json:"dependencies"`
+}
+
+var readBuildInfo = sync.OnceValue(func() *buildInfo {
+	info := &buildInfo{
+		Version:      Version,
+		BuildTime:    BuildTime,
+		GoVersion:    runtime.Version(),
+		Platform:     runtime.GOOS + "/" + runtime.GOARCH,
+		Dependencies: map[string]string{},
+	}
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	for _, setting := range bi.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			info.Revision = setting.Value
+		case "vcs.time":
+			info.RevisionTime = setting.Value
+		case "vcs.modified":
+			info.Modified = setting.Value == "true"
+		}
+	}
+	for _, dep := range bi.Deps {
+		for _, path := range buildDependencies {
+			if dep.Path != path {
+				continue
+			}
+			if dep.Replace != nil {
+				dep = dep.Replace
+			}
+			info.Dependencies[path] = dep.Version
+		}
+	}
+	return info
+})
+
+// PrintBuildInfo writes the build info of the binary for the --version flag
+func PrintBuildInfo(w io.Writer) {
+	info := readBuildInfo()
+	fmt.Fprintf(w, "Version:       %s\n", info.Version)
+	fmt.Fprintf(w, "Build time:    %s\n", info.BuildTime)
+	fmt.Fprintf(w, "Go version:    %s\n", info.GoVersion)
+	fmt.Fprintf(w, "Platform:      %s\n", info.Platform)
+	fmt.Fprintf(w, "Revision:      %s\n", info.Revision)
+	fmt.Fprintf(w, "Revision time: %s\n", info.RevisionTime)
+	fmt.Fprintf(w, "Modified:      %t\n", info.Modified)
+	paths := make([]string, 0, len(info.Dependencies))
+	for path := range info.Dependencies {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		fmt.Fprintf(w, "Dependency:    %s %s\n", path, info.Dependencies[path])
+	}
+}
+
+// versionHandler reports the version of the server to everyone. It's limited to the version and the build time
+// so the anonymous callers can't tell the exact revision and the dependencies to look for their vulnerabilities.
+func (app *application) versionHandler(w http.ResponseWriter, r *http.Request) {
+	_, span := app.startSpan(r, "version")
+	defer span.End()
+
+	info := readBuildInfo()
+	err := app.writeJson(w, r, http.StatusOK, envelope{"version": map[string]string{
+		"version":    info.Version,
+		"build_time": info.BuildTime,
+	}}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// showBuildInfoHandler reports the whole build info along with the state of the go runtime to the admins
+func (app *application) showBuildInfoHandler(w http.ResponseWriter, r *http.Request) {
+	_, span := app.startSpan(r, "showBuildInfo")
+	defer span.End()
+
+	err := app.writeJson(w, r, http.StatusOK, envelope{
+		"build": readBuildInfo(),
+		"runtime": map[string]interface{}{
+			"goroutines": runtime.NumGoroutine(),
+			"gomaxprocs": runtime.GOMAXPROCS(0),
+			"num_cpu":    runtime.NumCPU(),
+		},
+	}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
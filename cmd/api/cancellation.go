@@ -0,0 +1,55 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// DBWriteGracePeriod is how long the statements of a write request keep running after its client hangs up.
+// Read requests are cancelled as soon as the client is gone.
+var DBWriteGracePeriod time.Duration
+
+// requestCancellation replaces the context of the write requests with one that is cancelled DBWriteGracePeriod
+// after the client hangs up, so a half-finished write gets the chance to complete instead of being rolled back.
+func (app *application) requestCancellation(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if DBWriteGracePeriod > 0 && isWriteMethod(r.Method) {
+			ctx, cancel := withGracePeriod(r.Context(), DBWriteGracePeriod)
+			defer cancel()
+			r = r.WithContext(ctx)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func isWriteMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	default:
+		return true
+	}
+}
+
+// withGracePeriod returns a context carrying the values of parent which is cancelled the grace period after parent is done.
+func withGracePeriod(parent context.Context, grace time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.WithoutCancel(parent))
+	stop := context.AfterFunc(parent, func() {
+		timer := time.AfterFunc(grace, cancel)
+		context.AfterFunc(ctx, func() { timer.Stop() })
+	})
+	return ctx, func() {
+		stop()
+		cancel()
+	}
+}
+
+// statusClientClosedRequest is the nginx convention for the requests whose client hung up before the response
+const statusClientClosedRequest = 499
+
+// clientGone reports whether the request failed because its client hung up
+func clientGone(r *http.Request, err error) bool {
+	return err != nil && errors.Is(r.Context().Err(), context.Canceled)
+}
@@ -0,0 +1,40 @@
+package api
+
+import "github.com/cybrarymin/greenlight/internal/data"
+
+// PublicCatalog exposes listing and showing the movies to the anonymous users
+var PublicCatalog bool
+
+// publicMovie is the restricted view of a movie served to the anonymous users of the public catalog.
+// The version and the external catalog ids are only of use to the clients editing the catalog.
+type publicMovie struct {
+	ID      int64        `json:"id"`
+	Title   string       `json:"title"`
+	Year    int32        `json:"year,omitempty"`
+	Runtime data.Runtime `json:"runtime,omitempty"`
+	Genres  []string     `json:"genres,omitempty"`
+	// the third party scores are public, the catalog can be sorted by them
+	IMDbRating          *float64 `json:"imdb_rating,omitempty"`
+	RottenTomatoesScore *int32   `json:"rotten_tomatoes_score,omitempty"`
+}
+
+func newPublicMovie(movie *data.Movie) publicMovie {
+	return publicMovie{
+		ID:      movie.ID,
+		Title:   movie.Title,
+		Year:    movie.Year,
+		Runtime: movie.Runtime,
+		Genres:  movie.Genres,
+
+		IMDbRating:          movie.IMDbRating,
+		RottenTomatoesScore: movie.RottenTomatoesScore,
+	}
+}
+
+func newPublicMovies(movies []data.Movie) []publicMovie {
+	pMovies := make([]publicMovie, 0, len(movies))
+	for i := range movies {
+		pMovies = append(pMovies, newPublicMovie(&movies[i]))
+	}
+	return pMovies
+}
@@ -0,0 +1,145 @@
+package api
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPublicCatalogCredentials(t *testing.T) {
+	defer func(enabled bool) { PublicCatalog = enabled }(PublicCatalog)
+	PublicCatalog = true
+
+	tests := []struct {
+		name    string
+		request func(r *http.Request)
+		want    string
+	}{
+		{name: "Anonymous", request: func(r *http.Request) {}, want: "anonymous"},
+		{name: "Bearer token", request: func(r *http.Request) { r.Header.Set("Authorization", "Bearer token") }, want: "authenticated"},
+		// the web frontend signs in by its session cookie rather than the Authorization header
+		{name: "Session cookie", request: func(r *http.Request) {
+			r.AddCookie(&http.Cookie{Name: sessionCookieName, Value: "session"})
+		}, want: "authenticated"},
+		{name: "Client certificate", request: func(r *http.Request) {
+			r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{}}}}
+		}, want: "authenticated"},
+		{name: "Other cookie", request: func(r *http.Request) { r.AddCookie(&http.Cookie{Name: "theme", Value: "dark"}) }, want: "anonymous"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			logger := zerolog.Nop()
+			app := &application{log: &logger}
+			app.runtime.Store(&runtimeConfig{})
+			var got string
+			handler := app.publicCatalog(
+				func(w http.ResponseWriter, r *http.Request) { got = "anonymous" },
+				func(w http.ResponseWriter, r *http.Request) { got = "authenticated" },
+			)
+			r := httptest.NewRequest(http.MethodGet, "/v1/movies", nil)
+			tc.request(r)
+			rec := httptest.NewRecorder()
+			handler(rec, r)
+			assert.Equal(t, tc.want, got)
+			assert.Equal(t, []string{"Authorization", "Cookie"}, rec.Header().Values("Vary"))
+		})
+	}
+}
+
+func TestPublicCatalogRateLimit(t *testing.T) {
+	defer func(enabled bool) { PublicCatalog = enabled }(PublicCatalog)
+	PublicCatalog = true
+
+	logger := zerolog.Nop()
+	app := &application{log: &logger}
+	app.runtime.Store(&runtimeConfig{RateLimitEnabled: true, AnonymousRateLimit: 1})
+	handler := app.publicCatalog(
+		func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) },
+		func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) },
+	)
+	request := func(remoteAddr string, authenticated bool) int {
+		r := httptest.NewRequest(http.MethodGet, "/v1/movies", nil)
+		r.RemoteAddr = remoteAddr
+		if authenticated {
+			r.Header.Set("Authorization", "Bearer token")
+		}
+		rec := httptest.NewRecorder()
+		handler(rec, r)
+		return rec.Code
+	}
+
+	assert.Equal(t, http.StatusOK, request("192.0.2.1:1234", false))
+	assert.Equal(t, http.StatusTooManyRequests, request("192.0.2.1:1234", false), "the anonymous limit of the client is used up")
+	assert.Equal(t, http.StatusTooManyRequests, request("192.0.2.1:5678", false), "the limit is per client address rather than per connection")
+	assert.Equal(t, http.StatusOK, request("192.0.2.2:1234", false), "each client has its own limit")
+	// the authenticated requests are limited by the per client limit of the users only
+	assert.Equal(t, http.StatusOK, request("192.0.2.1:1234", true))
+}
+
+func TestPublicCatalogRoutes(t *testing.T) {
+	defer func(enabled bool, exportMode string, bodySize int64) {
+		PublicCatalog, MetricsExportMode, MaxBodySize = enabled, exportMode, bodySize
+	}(PublicCatalog, MetricsExportMode, MaxBodySize)
+	PublicCatalog, MetricsExportMode, MaxBodySize = true, MetricsExportPrometheus, 1<<20
+
+	now := time.Now()
+	movieColumns := []string{"id", "created_at", "title", "year", "runtime", "genres", "version", "updated_at", "external_source", "external_id", "imdb_rating", "rotten_tomatoes_score"}
+	movie := stub(`^SELECT "movie"\..* WHERE \(id = 1\)`, movieColumns,
+		[]driver.Value{int64(1), now, "avengers", int64(2018), int64(149), "{action}", int64(3), now, "imdb", "tt4154756", 8.4, int64(85)})
+
+	tests := []struct {
+		name    string
+		method  string
+		target  string
+		body    string
+		queries []stubQuery
+		status  int
+	}{
+		{name: "Show", method: http.MethodGet, target: "/v1/movies/1", queries: []stubQuery{movie}, status: http.StatusOK},
+		// the writes and the other reads of the movies still need an authenticated user
+		{name: "Create", method: http.MethodPost, target: "/v1/movies", body: `{"title":"Moana","year":2016,"runtime":"107 mins","genres":["animation"]}`, status: http.StatusUnauthorized},
+		{name: "Update", method: http.MethodPatch, target: "/v1/movies/1", body: `{"title":"Moana"}`, status: http.StatusUnauthorized},
+		{name: "Delete", method: http.MethodDelete, target: "/v1/movies/1", status: http.StatusUnauthorized},
+		{name: "Upsert by external id", method: http.MethodPut, target: "/v1/movies/by-external-id/imdb/tt3521164", body: `{"title":"Moana"}`, status: http.StatusUnauthorized},
+		{name: "Progress", method: http.MethodPost, target: "/v1/movies/1/progress", body: `{"position_seconds":60}`, status: http.StatusUnauthorized},
+		{name: "Changes", method: http.MethodGet, target: "/v1/movies/changes", status: http.StatusUnauthorized},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			logger := zerolog.Nop()
+			app := &application{log: &logger, models: stubModels(t, tc.queries...)}
+			app.runtime.Store(&runtimeConfig{})
+			r := httptest.NewRequest(tc.method, tc.target, strings.NewReader(tc.body))
+			rec := httptest.NewRecorder()
+			app.routes().ServeHTTP(rec, r)
+			require.Equal(t, tc.status, rec.Code, rec.Body.String())
+			if tc.status != http.StatusOK {
+				return
+			}
+
+			// the anonymous users get the restricted fields of the movie only
+			var body struct {
+				Movie    map[string]any
+				Progress any
+			}
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+			assert.Equal(t, map[string]any{
+				"id": 1.0, "title": "avengers", "year": 2018.0, "runtime": "149 mins", "genres": []any{"action"},
+				"imdb_rating": 8.4, "rotten_tomatoes_score": 85.0,
+			}, body.Movie)
+			assert.NotContains(t, rec.Body.String(), "Progress", "the anonymous users have no progress")
+		})
+	}
+}
@@ -0,0 +1,189 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/felixge/httpsnoop"
+)
+
+// route classes sharing a cache policy
+const (
+	// cacheCatalog is the movie list and the movie details of the public catalog
+	cacheCatalog = "catalog"
+	// cacheFeeds is the rss and atom feeds and the sitemap
+	cacheFeeds = "feeds"
+)
+
+var (
+	// CatalogCacheControl and CatalogSurrogateControl are sent on the anonymous responses of the catalog. disabled when they're empty
+	CatalogCacheControl     string
+	CatalogSurrogateControl string
+	// FeedsCacheControl and FeedsSurrogateControl override the cache headers of the feeds and the sitemap when they're set
+	FeedsCacheControl     string
+	FeedsSurrogateControl string
+	// CDNPurgeURL receives the surrogate keys to purge whenever the catalog changes. purging is disabled when it's empty
+	CDNPurgeURL string
+	// CDNPurgeToken is sent as the bearer token of the purge requests
+	CDNPurgeToken string
+)
+
+// cachePolicy returns the Cache-Control and the Surrogate-Control values of the route class
+func cachePolicy(class string) (string, string) {
+	switch class {
+	case cacheCatalog:
+		return CatalogCacheControl, CatalogSurrogateControl
+	case cacheFeeds:
+		return FeedsCacheControl, FeedsSurrogateControl
+	}
+	return "", ""
+}
+
+// movieSurrogateKeys returns the surrogate keys of the catalog responses. Every response is tagged by movies and
+// the ones of a single movie by its own key as well, so a change purges the lists along with the movie.
+func movieSurrogateKeys(movieID int64) []string {
+	if movieID == 0 {
+		return []string{"movies"}
+	}
+	return []string{"movies", "movie-" + strconv.FormatInt(movieID, 10)}
+}
+
+// credentialed reports whether the request carries a credential, the Authorization header, a client certificate or the
+// session cookie. Its response may be specific to the caller, so it mustn't be shared by the caches.
+func credentialed(r *http.Request) bool {
+	if r.Header.Get("Authorization") != "" || hasClientCertificate(r) {
+		return true
+	}
+	_, err := r.Cookie(sessionCookieName)
+	return err == nil
+}
+
+// cacheable sets the cache headers of the route class on the successful GET responses of the anonymous users, so a cdn
+// can serve them. The responses of the authenticated users are marked private since they may have more fields.
+// Surrogate-Key tags the responses by the movies for the purges. check purgeCDN
+func (app *application) cacheable(class string) middleware {
+	return middleware{wrap: func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			cacheControl, surrogateControl := cachePolicy(class)
+			if (cacheControl == "" && surrogateControl == "") || (r.Method != http.MethodGet && r.Method != http.MethodHead) {
+				next(w, r)
+				return
+			}
+			anonymous := !credentialed(r)
+			written := false
+			setHeaders := func(status int) {
+				if written {
+					return
+				}
+				written = true
+				if !anonymous {
+					w.Header().Set("Cache-Control", "private")
+					return
+				}
+				if status != http.StatusOK {
+					return
+				}
+				if cacheControl != "" {
+					w.Header().Set("Cache-Control", cacheControl)
+				}
+				if surrogateControl != "" {
+					w.Header().Set("Surrogate-Control", surrogateControl)
+				}
+				movieID, _ := strconv.ParseInt(app.pathParams(r).ByName("id"), 10, 64)
+				for _, key := range movieSurrogateKeys(movieID) {
+					w.Header().Add("Surrogate-Key", key)
+				}
+			}
+			next(httpsnoop.Wrap(w, httpsnoop.Hooks{
+				WriteHeader: func(writeHeader httpsnoop.WriteHeaderFunc) httpsnoop.WriteHeaderFunc {
+					return func(code int) {
+						setHeaders(code)
+						writeHeader(code)
+					}
+				},
+				Write: func(write httpsnoop.WriteFunc) httpsnoop.WriteFunc {
+					return func(b []byte) (int, error) {
+						setHeaders(http.StatusOK)
+						return write(b)
+					}
+				},
+			}), r)
+		}
+	}}
+}
+
+// purgeRequest is sent to CDNPurgeURL. Everything is purged when there are no keys.
+type purgeRequest struct {
+	SurrogateKeys   []string `json:"surrogate_keys,omitempty"`
+	PurgeEverything bool     `json:"purge_everything,omitempty"`
+}
+
+// purgeCDN asks the cdn to drop the responses tagged by the surrogate keys, or all of them when there are no keys
+func (app *application) purgeCDN(ctx context.Context, keys []string) error {
+	body, err := json.Marshal(purgeRequest{SurrogateKeys: keys, PurgeEverything: len(keys) == 0})
+	if err != nil {
+		return err
+	}
+	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*5)
+	defer cancelFunc()
+	req, err := http.NewRequestWithContext(timeoutCtx, http.MethodPost, CDNPurgeURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if CDNPurgeToken != "" {
+		req.Header.Set("Authorization", "Bearer "+CDNPurgeToken)
+	}
+	res, err := app.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("cdn purge responded with status %d", res.StatusCode)
+	}
+	return nil
+}
+
+// purgeMovie purges the cached responses of the movie and the lists in the background once the movie has changed
+func (app *application) purgeMovie(movieID int64) {
+	if CDNPurgeURL == "" {
+		return
+	}
+	app.BackgroundJob(func() {
+		err := app.purgeCDN(context.Background(), movieSurrogateKeys(movieID))
+		if err != nil {
+			logEvents(app.log).Error(err, "failed to purge the movie from the cdn", logFields{"movie_id": movieID})
+		}
+	}, "panic happened during purging the movie from the cdn")
+}
+
+// purgeCacheHandler purges the given surrogate keys from the cdn, or everything when no key is given.
+// It's only registered when CDNPurgeURL is configured.
+func (app *application) purgeCacheHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := app.startSpan(r, "purgeCache")
+	defer span.End()
+
+	var input struct {
+		SurrogateKeys []string `json:"surrogate_keys"`
+	}
+	err := app.readJson(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	err = app.purgeCDN(ctx, input.SurrogateKeys)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJson(w, r, http.StatusAccepted, envelope{"surrogate_keys": input.SurrogateKeys, "purge_everything": len(input.SurrogateKeys) == 0}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
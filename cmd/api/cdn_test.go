@@ -0,0 +1,59 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCacheable(t *testing.T) {
+	defer func(cacheControl, surrogateControl string) {
+		CatalogCacheControl, CatalogSurrogateControl = cacheControl, surrogateControl
+	}(CatalogCacheControl, CatalogSurrogateControl)
+	CatalogCacheControl, CatalogSurrogateControl = "public, max-age=60", "max-age=3600"
+
+	app := &application{}
+	tests := []struct {
+		name          string
+		authorization string
+		session       string
+		status        int
+		cacheControl  string
+		surrogateKeys []string
+	}{
+		{name: "Anonymous", status: http.StatusOK, cacheControl: "public, max-age=60", surrogateKeys: []string{"movies", "movie-12"}},
+		{name: "Authenticated", authorization: "Bearer token", status: http.StatusOK, cacheControl: "private"},
+		{name: "Cookie session", session: "session", status: http.StatusOK, cacheControl: "private"},
+		{name: "Anonymous error", status: http.StatusNotFound},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			handler := app.cacheable(cacheCatalog).wrap(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tc.status)
+			})
+			r := httptest.NewRequest(http.MethodGet, "/v1/movies/12", nil)
+			rctx := chi.NewRouteContext()
+			rctx.URLParams.Add("id", "12")
+			r = r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, rctx))
+			if tc.authorization != "" {
+				r.Header.Set("Authorization", tc.authorization)
+			}
+			if tc.session != "" {
+				r.AddCookie(&http.Cookie{Name: sessionCookieName, Value: tc.session})
+			}
+			rec := httptest.NewRecorder()
+			handler(rec, r)
+
+			assert.Equal(t, tc.cacheControl, rec.Header().Get("Cache-Control"))
+			assert.Equal(t, tc.surrogateKeys, rec.Header().Values("Surrogate-Key"))
+			if tc.surrogateKeys != nil {
+				assert.Equal(t, "max-age=3600", rec.Header().Get("Surrogate-Control"))
+			}
+		})
+	}
+}
@@ -0,0 +1,258 @@
+package api
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math/bits"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/codes"
+)
+
+const (
+	ChallengeHCaptcha    = "hcaptcha"
+	ChallengeTurnstile   = "turnstile"
+	ChallengeProofOfWork = "pow"
+)
+
+var (
+	// ChallengeProvider is the challenge required on the registration and token endpoints. challenges are disabled when it's empty.
+	ChallengeProvider string
+	// ChallengeSecret is the captcha secret of hcaptcha and turnstile or the hmac key signing the proof of work challenges
+	ChallengeSecret  string
+	ChallengeSiteKey string
+	PoWDifficulty    int
+	ChallengeAPIKeys []string
+)
+
+var challengeVerifyURLs = map[string]string{
+	ChallengeHCaptcha:  "https://api.hcaptcha.com/siteverify",
+	ChallengeTurnstile: "https://challenges.cloudflare.com/turnstile/v0/siteverify",
+}
+
+const powChallengeTTL = 5 * time.Minute
+
+var ErrChallengeFailed = errors.New("challenge verification failed")
+
+// usedPoWChallenges remembers the solved proof of work challenges until they expire so a solution can't be replayed.
+var usedPoWChallenges = newUsedChallenges()
+
+// usedChallenges is a set of challenges whose members are kept until their expiry. The expired ones are dropped by prune
+// in the background, so the checks don't sweep the whole set.
+type usedChallenges struct {
+	mu       sync.Mutex
+	expiries map[string]time.Time
+}
+
+func newUsedChallenges() *usedChallenges {
+	return &usedChallenges{expiries: make(map[string]time.Time)}
+}
+
+// use adds the challenge to the set until its expiry and reports false when it's been used already
+func (u *usedChallenges) use(challenge string, expiry time.Time) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if _, used := u.expiries[challenge]; used {
+		return false
+	}
+	u.expiries[challenge] = expiry
+	return true
+}
+
+// prune drops the challenges expired by now. They're rejected by their expiry anyway.
+func (u *usedChallenges) prune(now time.Time) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for challenge, expiry := range u.expiries {
+		if now.After(expiry) {
+			delete(u.expiries, challenge)
+		}
+	}
+}
+
+// runPoWChallengePruner drops the expired challenges from the used ones every powChallengeTTL for the lifetime of the server
+func (app *application) runPoWChallengePruner() {
+	ticker := time.NewTicker(powChallengeTTL)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		usedPoWChallenges.prune(now)
+	}
+}
+
+// requireChallenge rejects the requests which haven't solved the configured challenge.
+// Requests carrying one of the trusted api keys in X-API-Key header skip the challenge.
+func (app *application) requireChallenge(next http.Handler) http.Handler {
+	if ChallengeProvider == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := app.startSpan(r, "requireChallenge")
+		defer span.End()
+
+		if apiKey := r.Header.Get("X-API-Key"); apiKey != "" {
+			for _, trusted := range ChallengeAPIKeys {
+				if secureCompare(apiKey, trusted) {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+		}
+
+		var err error
+		switch ChallengeProvider {
+		case ChallengeProofOfWork:
+			err = verifyPoWSolution(r.Header.Get("X-PoW-Solution"))
+		default:
+			err = app.verifyCaptcha(ctx, r.Header.Get("X-Captcha-Token"), clientIP(r))
+		}
+		if err != nil {
+			span.RecordError(err)
+			switch {
+			case errors.Is(err, ErrChallengeFailed):
+				span.SetStatus(codes.Error, "challenge verification failed")
+				app.challengeRequiredResponse(w, r)
+			default:
+				span.SetStatus(codes.Error, "challenge verification error")
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
+
+// verifyCaptcha verifies the captcha token at the siteverify endpoint of the provider. hcaptcha and turnstile share the same api.
+func (app *application) verifyCaptcha(ctx context.Context, token string, remoteIP string) error {
+	if token == "" {
+		return ErrChallengeFailed
+	}
+	form := url.Values{}
+	form.Set("secret", ChallengeSecret)
+	form.Set("response", token)
+	form.Set("remoteip", remoteIP)
+	if ChallengeSiteKey != "" {
+		form.Set("sitekey", ChallengeSiteKey)
+	}
+
+	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*5)
+	defer cancelFunc()
+	req, err := http.NewRequestWithContext(timeoutCtx, http.MethodPost, challengeVerifyURLs[ChallengeProvider], strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	res, err := app.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	var result struct {
+		Success bool `json:"success"`
+	}
+	err = json.NewDecoder(res.Body).Decode(&result)
+	if err != nil {
+		return err
+	}
+	if !result.Success {
+		return ErrChallengeFailed
+	}
+	return nil
+}
+
+// newPoWChallenge returns a random challenge carrying its expiry, signed so the server doesn't need to store it.
+func newPoWChallenge(expiry time.Time) (string, error) {
+	payload := make([]byte, 24)
+	_, err := rand.Read(payload[:16])
+	if err != nil {
+		return "", err
+	}
+	binary.BigEndian.PutUint64(payload[16:], uint64(expiry.Unix()))
+	mac := hmac.New(sha256.New, []byte(ChallengeSecret))
+	mac.Write(payload)
+	return base64.RawURLEncoding.EncodeToString(append(payload, mac.Sum(nil)...)), nil
+}
+
+// verifyPoWSolution checks a "challenge:nonce" solution. sha256 of the solution must start with PoWDifficulty zero bits.
+func verifyPoWSolution(solution string) error {
+	challenge, _, found := strings.Cut(solution, ":")
+	if !found {
+		return ErrChallengeFailed
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(challenge)
+	if err != nil || len(raw) != 24+sha256.Size {
+		return ErrChallengeFailed
+	}
+	payload, signature := raw[:24], raw[24:]
+	mac := hmac.New(sha256.New, []byte(ChallengeSecret))
+	mac.Write(payload)
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return ErrChallengeFailed
+	}
+	expiry := time.Unix(int64(binary.BigEndian.Uint64(payload[16:])), 0)
+	if time.Now().After(expiry) {
+		return ErrChallengeFailed
+	}
+	if leadingZeroBits(sha256.Sum256([]byte(solution))) < PoWDifficulty {
+		return ErrChallengeFailed
+	}
+
+	if !usedPoWChallenges.use(challenge, expiry) {
+		return ErrChallengeFailed
+	}
+	return nil
+}
+
+func leadingZeroBits(hash [sha256.Size]byte) int {
+	n := 0
+	for _, b := range hash {
+		if b != 0 {
+			return n + bits.LeadingZeros8(b)
+		}
+		n += 8
+	}
+	return n
+}
+
+// createChallengeHandler issues a proof of work challenge. The client must find a nonce where sha256("challenge:nonce")
+// starts with the given number of zero bits and send "challenge:nonce" in X-PoW-Solution header.
+func (app *application) createChallengeHandler(w http.ResponseWriter, r *http.Request) {
+	_, span := app.startSpan(r, "createChallenge")
+	defer span.End()
+
+	expiry := time.Now().Add(powChallengeTTL)
+	challenge, err := newPoWChallenge(expiry)
+	if err != nil {
+		span.RecordError(err)
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJson(w, r, http.StatusCreated, envelope{"Challenge": map[string]interface{}{
+		"challenge":  challenge,
+		"difficulty": PoWDifficulty,
+		"algorithm":  "sha256",
+		"expiry":     expiry.Truncate(time.Second),
+	}}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,125 @@
+package api
+
+import (
+	"crypto/sha256"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLeadingZeroBits(t *testing.T) {
+	tests := []struct {
+		name   string
+		prefix []byte
+		want   int
+	}{
+		{name: "None", prefix: []byte{0x80}, want: 0},
+		{name: "Within the first byte", prefix: []byte{0x10}, want: 3},
+		{name: "Whole byte", prefix: []byte{0x00, 0xff}, want: 8},
+		{name: "Across bytes", prefix: []byte{0x00, 0x00, 0x01}, want: 23},
+		{name: "All zero", want: sha256.Size * 8},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var hash [sha256.Size]byte
+			copy(hash[:], tc.prefix)
+			assert.Equal(t, tc.want, leadingZeroBits(hash))
+		})
+	}
+}
+
+// solvePoW finds the solution of the challenge whose hash does or doesn't have the difficulty zero bits
+func solvePoW(challenge string, difficulty int, solved bool) string {
+	for nonce := 0; ; nonce++ {
+		solution := challenge + ":" + strconv.Itoa(nonce)
+		if (leadingZeroBits(sha256.Sum256([]byte(solution))) >= difficulty) == solved {
+			return solution
+		}
+	}
+}
+
+func TestVerifyPoWSolution(t *testing.T) {
+	defer func(secret string, difficulty int) { ChallengeSecret, PoWDifficulty = secret, difficulty }(ChallengeSecret, PoWDifficulty)
+	ChallengeSecret, PoWDifficulty = "secret", 8
+
+	challenge := func(expiry time.Time) string {
+		c, err := newPoWChallenge(expiry)
+		require.NoError(t, err)
+		return c
+	}
+	otherSecret := func() string {
+		ChallengeSecret = "other"
+		defer func() { ChallengeSecret = "secret" }()
+		return challenge(time.Now().Add(time.Minute))
+	}()
+	valid := challenge(time.Now().Add(time.Minute))
+	// the random head of the payload is changed, so it no longer matches its signature
+	tampered := "A" + valid[1:]
+	if tampered == valid {
+		tampered = "B" + valid[1:]
+	}
+
+	tests := []struct {
+		name     string
+		solution string
+		valid    bool
+	}{
+		{name: "Solved", solution: solvePoW(valid, PoWDifficulty, true), valid: true},
+		{name: "Not solved", solution: solvePoW(challenge(time.Now().Add(time.Minute)), PoWDifficulty, false)},
+		{name: "Expired", solution: solvePoW(challenge(time.Now().Add(-time.Second)), PoWDifficulty, true)},
+		{name: "Signed by another secret", solution: solvePoW(otherSecret, PoWDifficulty, true)},
+		{name: "Tampered", solution: solvePoW(tampered, PoWDifficulty, true)},
+		{name: "Without nonce", solution: valid},
+		{name: "Malformed", solution: "not-base64!:1"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := verifyPoWSolution(tc.solution)
+			if !tc.valid {
+				assert.ErrorIs(t, err, ErrChallengeFailed)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestVerifyPoWSolutionReplay(t *testing.T) {
+	defer func(secret string, difficulty int) { ChallengeSecret, PoWDifficulty = secret, difficulty }(ChallengeSecret, PoWDifficulty)
+	ChallengeSecret, PoWDifficulty = "secret", 4
+
+	challenge, err := newPoWChallenge(time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	require.NoError(t, verifyPoWSolution(solvePoW(challenge, PoWDifficulty, true)))
+	assert.ErrorIs(t, verifyPoWSolution(solvePoW(challenge, PoWDifficulty, true)), ErrChallengeFailed, "the same solution is replayed")
+
+	// another nonce solving the challenge is a replay as well
+	var other string
+	for nonce := 0; ; nonce++ {
+		other = challenge + ":x" + strconv.Itoa(nonce)
+		if leadingZeroBits(sha256.Sum256([]byte(other))) >= PoWDifficulty {
+			break
+		}
+	}
+	assert.ErrorIs(t, verifyPoWSolution(other), ErrChallengeFailed)
+}
+
+func TestUsedChallengesPrune(t *testing.T) {
+	now := time.Now()
+	used := newUsedChallenges()
+	assert.True(t, used.use("a", now.Add(time.Minute)))
+	assert.True(t, used.use("b", now.Add(time.Hour)))
+	assert.False(t, used.use("a", now.Add(time.Minute)))
+
+	used.prune(now)
+	assert.Len(t, used.expiries, 2, "nothing has expired yet")
+	used.prune(now.Add(2 * time.Minute))
+	assert.Len(t, used.expiries, 1)
+	assert.True(t, used.use("a", now.Add(time.Minute)), "the expired challenge is dropped")
+	assert.False(t, used.use("b", now.Add(time.Hour)))
+}
@@ -0,0 +1,46 @@
+package api
+
+import (
+	"errors"
+	"math"
+	"net/http"
+
+	"github.com/cybrarymin/greenlight/internal/data"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// listMovieChangesHandler returns the movies created, updated and deleted after the since sequence number so the
+// sync clients can catch up incrementally. Clients start from 0 and pass the returned Next as since of the following
+// request, repeating it right away while HasMore is true.
+func (app *application) listMovieChangesHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := app.startSpan(r, "listMovieChanges")
+	defer span.End()
+
+	qs := r.URL.Query()
+	nValidator := data.NewValidator()
+	since := app.readIntRange(qs, "since", 0, 0, math.MaxInt, nValidator)
+	limit := app.readIntRange(qs, "limit", 500, 1, 1000, nValidator)
+	if !nValidator.Valid() {
+		span.RecordError(errors.New(createKeyValuePairs(nValidator.Errors)))
+		span.SetStatus(codes.Error, otelunprocessableErr)
+		app.failedValidationResponse(w, r, nValidator)
+		return
+	}
+
+	changes, hasMore, err := app.models.Movies.Changes(ctx, int64(since), limit)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, otelDBErr)
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	next := int64(since)
+	if len(changes) > 0 {
+		next = changes[len(changes)-1].Seq
+	}
+	err = app.writeJson(w, r, http.StatusOK, envelope{"Changes": changes, "Next": next, "HasMore": hasMore}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
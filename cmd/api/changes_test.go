@@ -0,0 +1,180 @@
+package api
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cybrarymin/greenlight/internal/data"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListMovieChanges(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	rowColumns := []string{"id", "created_at", "title", "year", "runtime", "genres", "version", "change_seq"}
+	row := func(id, version, seq int64) []driver.Value {
+		return []driver.Value{id, now, "Movie", int64(2023), int64(120), "{drama}", version, seq}
+	}
+	tombstoneColumns := []string{"movie_id", "change_seq", "deleted_at", "merged_into"}
+	tombstone := func(id, seq int64, mergedInto driver.Value) []driver.Value {
+		return []driver.Value{id, seq, now, mergedInto}
+	}
+	// rows and tombstones stub both sides of the feed written after since, each read up to one more than the limit
+	rows := func(since, limit string, values ...[]driver.Value) stubQuery {
+		return stub(`^SELECT .* FROM "movies" AS "\w+" WHERE \(change_seq > `+since+`\) ORDER BY change_seq ASC LIMIT `+limit+`$`, rowColumns, values...)
+	}
+	tombstones := func(since, limit string, values ...[]driver.Value) stubQuery {
+		return stub(`^SELECT .* FROM "movie_tombstones" AS "\w+" WHERE \(change_seq > `+since+`\) AND \(NOT EXISTS \(SELECT 1 FROM movies WHERE movies.id = "\w+".movie_id\)\) ORDER BY change_seq ASC LIMIT `+limit+`$`,
+			tombstoneColumns, values...)
+	}
+
+	type change struct {
+		Seq        int64  `json:"seq"`
+		Op         string `json:"op"`
+		MovieID    int64  `json:"movie_id"`
+		Movie      *struct{ Version int64 }
+		DeletedAt  *time.Time `json:"deleted_at"`
+		MergedInto *int64     `json:"merged_into"`
+	}
+	mergedInto := int64(1)
+	tests := []struct {
+		name    string
+		query   string
+		queries []stubQuery
+		changes []change
+		next    int64
+		hasMore bool
+	}{
+		{
+			name: "Writes and tombstones in the order of the writes",
+			queries: []stubQuery{
+				rows("0", "501", row(1, 1, 1), row(2, 3, 4)),
+				tombstones("0", "501", tombstone(3, 2, nil), tombstone(4, 3, int64(1))),
+			},
+			changes: []change{
+				{Seq: 1, Op: data.ChangeCreated, MovieID: 1, Movie: &struct{ Version int64 }{1}},
+				{Seq: 2, Op: data.ChangeDeleted, MovieID: 3, DeletedAt: &now},
+				{Seq: 3, Op: data.ChangeDeleted, MovieID: 4, DeletedAt: &now, MergedInto: &mergedInto},
+				{Seq: 4, Op: data.ChangeUpdated, MovieID: 2, Movie: &struct{ Version int64 }{3}},
+			},
+			next: 4,
+		},
+		{
+			name:  "Page after since",
+			query: "?since=5&limit=2",
+			queries: []stubQuery{
+				rows("5", "3", row(6, 1, 6), row(8, 2, 8), row(9, 1, 9)),
+				tombstones("5", "3", tombstone(7, 7, nil)),
+			},
+			changes: []change{
+				{Seq: 6, Op: data.ChangeCreated, MovieID: 6, Movie: &struct{ Version int64 }{1}},
+				{Seq: 7, Op: data.ChangeDeleted, MovieID: 7, DeletedAt: &now},
+			},
+			next:    7,
+			hasMore: true,
+		},
+		{
+			name:    "Last page",
+			query:   "?since=7&limit=2",
+			queries: []stubQuery{rows("7", "3", row(8, 2, 8), row(9, 1, 9)), tombstones("7", "3")},
+			changes: []change{
+				{Seq: 8, Op: data.ChangeUpdated, MovieID: 8, Movie: &struct{ Version int64 }{2}},
+				{Seq: 9, Op: data.ChangeCreated, MovieID: 9, Movie: &struct{ Version int64 }{1}},
+			},
+			next: 9,
+		},
+		// the clients keep their since until there's a change after it
+		{name: "Nothing new", query: "?since=9", queries: []stubQuery{rows("9", "501"), tombstones("9", "501")}, changes: []change{}, next: 9},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			db := newStubDB(t, tc.queries...)
+			logger := zerolog.Nop()
+			app := &application{log: &logger, models: db.models()}
+			rec := httptest.NewRecorder()
+			app.listMovieChangesHandler(rec, httptest.NewRequest(http.MethodGet, "/v1/movies/changes"+tc.query, nil))
+
+			require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+			var body struct {
+				Changes []change
+				Next    int64
+				HasMore bool
+			}
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+			assert.Equal(t, tc.changes, body.Changes)
+			assert.Equal(t, tc.next, body.Next)
+			assert.Equal(t, tc.hasMore, body.HasMore)
+
+			// both sides are read from one snapshot, so a write committed in between can't be skipped by the other side
+			log := db.log()
+			require.Len(t, log, 4)
+			assert.Equal(t, "BEGIN ISOLATION LEVEL REPEATABLE READ READ ONLY", log[0])
+			assert.Equal(t, "COMMIT", log[3])
+		})
+	}
+}
+
+func TestListMovieChangesValidation(t *testing.T) {
+	for _, query := range []string{"?since=-1", "?since=x", "?limit=0", "?limit=1001"} {
+		t.Run(query, func(t *testing.T) {
+			logger := zerolog.Nop()
+			app := &application{log: &logger}
+			rec := httptest.NewRecorder()
+			app.listMovieChangesHandler(rec, httptest.NewRequest(http.MethodGet, "/v1/movies/changes"+query, nil))
+			assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+		})
+	}
+}
+
+// The writes locking the rows of the movies take the lock of the change sequence numbers before any of them, so the
+// numbers are committed in the order they're handed out without deadlocking with the other writes.
+func TestMovieWritesLockChangeSeq(t *testing.T) {
+	lock := stub(`^SELECT pg_advisory_xact_lock\(hashtext\('movie_change_seq'\)\)$`, nil)
+	tests := []struct {
+		name    string
+		queries []stubQuery
+		write   func(models *data.Models) error
+	}{
+		{
+			name: "Delete",
+			queries: []stubQuery{lock,
+				stub(`^DELETE FROM "movies" AS "movie" WHERE \(id = 3\) RETURNING external_source, external_id$`, []string{"external_source", "external_id"}, []driver.Value{nil, nil}),
+				stub(`^INSERT INTO "movie_tombstones" .* ON CONFLICT \(movie_id\) DO UPDATE SET change_seq = next_movie_change_seq\(\)`, nil, []driver.Value{}),
+				stub(`^DELETE FROM "resource_redirects"`, nil),
+			},
+			write: func(models *data.Models) error { return models.Movies.Delete(context.Background(), 3) },
+		},
+		{
+			name: "Merge",
+			queries: []stubQuery{lock,
+				stub(`^SELECT .* FROM "movies" AS "movie" WHERE \(id IN \(3, 1\)\) ORDER BY id ASC FOR UPDATE$`, []string{"id"}),
+			},
+			write: func(models *data.Models) error {
+				_, _, err := models.Movies.Merge(context.Background(), 3, 1)
+				if errors.Is(err, data.ErrorRecordNotFound) {
+					return nil
+				}
+				return err
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			db := newStubDB(t, tc.queries...)
+			require.NoError(t, tc.write(db.models()))
+			log := db.log()
+			require.GreaterOrEqual(t, len(log), 2)
+			assert.Equal(t, "BEGIN", log[0])
+			assert.Regexp(t, lock.pattern, log[1])
+		})
+	}
+}
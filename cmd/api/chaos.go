@@ -0,0 +1,85 @@
+package api
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// ChaosLatency delays the request before it's served
+	ChaosLatency = "latency"
+	// ChaosError responds by 500 without serving the request
+	ChaosError = "error"
+	// ChaosDrop closes the connection without any response
+	ChaosDrop = "drop"
+)
+
+var (
+	// ChaosMode injects faults into the requests to validate the clients and their retries against a flaky server.
+	// It's only meant for the development and the test environments and rejected in production.
+	ChaosMode bool
+	// ChaosPercentage is the percentage of the requests getting a fault when no override matches the route
+	ChaosPercentage int64
+	// ChaosRoutes overrides ChaosPercentage for the routes, keyed by the method and the path of the route
+	// without the version prefix, like "GET /movies/:id". Path parameters match any segment.
+	ChaosRoutes map[string]int64
+	// ChaosFaults are the kinds of the faults which are picked randomly for the faulted requests
+	ChaosFaults []string
+	// ChaosMaxLatency is the upper bound of the injected latency
+	ChaosMaxLatency time.Duration
+)
+
+// chaosPercentage returns the percentage of the requests of the route which get a fault
+func chaosPercentage(r *http.Request) int64 {
+	path := r.URL.Path
+	if version := apiVersionPrefix(path); version != 0 {
+		path = strings.TrimPrefix(path, fmt.Sprintf("/v%d", version))
+	}
+	for route, percentage := range ChaosRoutes {
+		method, pattern, found := strings.Cut(route, " ")
+		if found && method == r.Method && matchRoute(pattern, path) {
+			return percentage
+		}
+	}
+	return ChaosPercentage
+}
+
+// chaos injects a random fault of ChaosFaults into a percentage of the requests. The faulted responses carry the
+// X-Chaos-Fault header so they can be told apart from the real failures. The probes and the metrics scrapes are exempt.
+func (app *application) chaos(next http.Handler) http.Handler {
+	if !ChaosMode || len(ChaosFaults) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/readyz" || r.URL.Path == "/metrics" || rand.Int63n(100) >= chaosPercentage(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		fault := ChaosFaults[rand.Intn(len(ChaosFaults))]
+		app.events(r.Context()).Debug("injecting chaos fault", logFields{"fault": fault})
+		w.Header().Set("X-Chaos-Fault", fault)
+		switch fault {
+		case ChaosLatency:
+			select {
+			case <-time.After(time.Duration(rand.Int63n(int64(ChaosMaxLatency) + 1))):
+			case <-r.Context().Done():
+				return
+			}
+			next.ServeHTTP(w, r)
+		case ChaosDrop:
+			conn, _, err := http.NewResponseController(w).Hijack()
+			if err != nil {
+				// the connection can't be taken over on http/2, the request fails by an error instead
+				app.errorResponse(w, r, codeServerError, "the server encountered an error to process the request")
+				return
+			}
+			conn.Close()
+		default:
+			app.errorResponse(w, r, codeServerError, "the server encountered an error to process the request")
+		}
+	})
+}
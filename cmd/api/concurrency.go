@@ -0,0 +1,110 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+)
+
+var (
+	// MaxInflightPerClient caps the requests a client address or a user can have in flight at once. zero disables it.
+	MaxInflightPerClient int
+	// MaxStreamsPerClient caps the websockets and the event streams a client address or a user can keep open at once.
+	// zero disables it.
+	MaxStreamsPerClient int
+)
+
+// concurrencyLimiter counts what each client has in flight. The clients are removed once they've got nothing in flight.
+type concurrencyLimiter struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func newConcurrencyLimiter() *concurrencyLimiter {
+	return &concurrencyLimiter{counts: make(map[string]int)}
+}
+
+// acquire takes a slot of the client unless it already has limit of them. zero limit doesn't limit the client.
+func (c *concurrencyLimiter) acquire(key string, limit int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if limit > 0 && c.counts[key] >= limit {
+		return false
+	}
+	c.counts[key]++
+	return true
+}
+
+func (c *concurrencyLimiter) release(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.counts[key]--
+	if c.counts[key] <= 0 {
+		delete(c.counts, key)
+	}
+}
+
+// isStream reports whether the request opens a websocket or an event stream, which stays open way longer than a request
+func isStream(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get("Upgrade"), "websocket") || strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+}
+
+// concurrencyLimit returns the limiter and the limit of the request. streams are counted apart from the requests
+// so a client holding its streams open can still send requests.
+func (app *application) concurrencyLimit(r *http.Request) (*concurrencyLimiter, int) {
+	if isStream(r) {
+		return app.streams, MaxStreamsPerClient
+	}
+	return app.inflight, MaxInflightPerClient
+}
+
+// limitConcurrency rejects the requests of the client addresses which have --max-inflight-per-client requests or
+// --max-streams-per-client streams in flight already, so a client sending slow requests can't take every worker.
+// The users are limited the same way by limitUserConcurrency wherever they connect from.
+func (app *application) limitConcurrency(next http.Handler) http.Handler {
+	if MaxInflightPerClient == 0 && MaxStreamsPerClient == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limiter, limit := app.concurrencyLimit(r)
+		key := "ip:" + clientIP(r)
+		if !limiter.acquire(key, limit) {
+			app.concurrencyLimited(w, r, "ip")
+			return
+		}
+		defer limiter.release(key)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// limitUserConcurrency is the limitConcurrency of the authenticated users
+func (app *application) limitUserConcurrency(next http.HandlerFunc) http.HandlerFunc {
+	if MaxInflightPerClient == 0 && MaxStreamsPerClient == 0 {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := app.GetUserContext(r)
+		if user.IsAnonymous() {
+			next(w, r)
+			return
+		}
+		limiter, limit := app.concurrencyLimit(r)
+		key := "user:" + user.ID.String()
+		if !limiter.acquire(key, limit) {
+			app.concurrencyLimited(w, r, "user")
+			return
+		}
+		defer limiter.release(key)
+		next(w, r)
+	}
+}
+
+func (app *application) concurrencyLimited(w http.ResponseWriter, r *http.Request, client string) {
+	app.events(r.Context()).Warn("too many concurrent requests of the client", logFields{
+		"event":       "concurrency_limited",
+		"client":      client,
+		"remote_addr": clientIP(r),
+		"stream":      isStream(r),
+	})
+	app.tooManyConcurrentResponse(w, r)
+}
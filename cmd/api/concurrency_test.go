@@ -0,0 +1,62 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimitConcurrency(t *testing.T) {
+	defer func(inflight, streams int) {
+		MaxInflightPerClient, MaxStreamsPerClient = inflight, streams
+	}(MaxInflightPerClient, MaxStreamsPerClient)
+	MaxInflightPerClient, MaxStreamsPerClient = 1, 1
+
+	logger := zerolog.Nop()
+	app := &application{log: &logger, inflight: newConcurrencyLimiter(), streams: newConcurrencyLimiter()}
+
+	// the handler sends a request of its own client while its request is still in flight
+	var nested func(r *http.Request) int
+	handler := app.limitConcurrency(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if nested != nil {
+			w.WriteHeader(nested(r))
+		}
+	}))
+	serve := func(remoteAddr string, stream bool) int {
+		r := httptest.NewRequest(http.MethodGet, "/v1/movies", nil)
+		r.RemoteAddr = remoteAddr
+		if stream {
+			r.Header.Set("Upgrade", "websocket")
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		stream     bool
+		want       int
+	}{
+		{name: "Same client", remoteAddr: "192.0.2.1:4000", want: http.StatusTooManyRequests},
+		{name: "Same client on another port", remoteAddr: "192.0.2.1:4001", want: http.StatusTooManyRequests},
+		{name: "Another client", remoteAddr: "192.0.2.2:4000", want: http.StatusOK},
+		{name: "Stream of the same client", remoteAddr: "192.0.2.1:4000", stream: true, want: http.StatusOK},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			nested = func(r *http.Request) int {
+				nested = nil
+				return serve(tc.remoteAddr, tc.stream)
+			}
+			assert.Equal(t, tc.want, serve("192.0.2.1:3999", false))
+			assert.Empty(t, app.inflight.counts, "the slots are released once the requests are done")
+			assert.Empty(t, app.streams.counts)
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	mailer "github.com/cybrarymin/greenlight/internal/mailter"
+	"github.com/jackc/pgx/v5"
+	"github.com/uptrace/bun/driver/pgdriver"
+)
+
+// SMTPProbe connects to the smtp server on startup to check its address and credentials
+var SMTPProbe bool
+
+// minJWTKeyBytes is the minimum size of the HS256 signing key, the size of its hash output
+const minJWTKeyBytes = 32
+
+// CheckConfig validates the configuration as a whole before the server starts. Unlike the checks of the single flags,
+// every problem is collected so they're all reported at once instead of one per restart.
+func CheckConfig() error {
+	var problems []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+
+	err := checkDSN(DBDriver, DBDSN)
+	check(err == nil, "--db-connection-string can't be parsed by the %s driver: %v", DBDriver, err)
+
+	check(len(JWTKEY) >= minJWTKeyBytes, "--jwt-key must be at least %d bytes long to sign the tokens securely, generate one by `openssl rand -base64 32`", minJWTKeyBytes)
+
+	check(ListenPort > 0 && ListenPort <= 65535, "--port must be between 1 and 65535")
+	check(MTLSListenPort >= 0 && MTLSListenPort <= 65535, "--mtls-port must be between 1 and 65535, or 0 to disable it")
+	check(MTLSListenPort == 0 || MTLSListenPort != ListenPort, "--mtls-port must be different from --port, both listeners can't share port %d", ListenPort)
+
+	for _, problem := range rateLimitProblems(EnableRateLimit, GlobalRateLimit, PerClientRateLimit, AnonymousRateLimit) {
+		check(false, "%s", problem)
+	}
+
+	for _, problem := range environmentProblems(Env, CORSTrustedOrigins, MailerDriver, SMTPUserName, SMTPPassword) {
+		check(false, "%s", problem)
+	}
+
+	for _, list := range []struct {
+		flag  string
+		cidrs []string
+	}{{"--ip-allowlist", IPAllowlist}, {"--ip-denylist", IPDenylist}, {"--admin-ip-allowlist", AdminIPAllowlist}, {"--metrics-ip-allowlist", MetricsIPAllowlist}} {
+		_, err := parsePrefixes(list.cidrs)
+		check(err == nil, "%s has an invalid cidr: %v", list.flag, err)
+	}
+	check(MinUploadRate >= 0 && MinUploadRateGrace >= 0, "--min-upload-rate and --min-upload-rate-grace can't be negative")
+	for _, route := range MinUploadRateRoutes {
+		method, pattern, found := strings.Cut(route, " ")
+		check(found && method != "" && strings.HasPrefix(pattern, "/"), "--min-upload-rate-routes must contain method and path routes like \"POST /users/import\", got %q", route)
+	}
+	_, err = loadRoutePolicies(RoutePoliciesFile)
+	check(err == nil, "--route-policies is invalid: %v", err)
+	check(LogFileMaxSize > 0, "--log-file-max-size must be greater than 0")
+	check(LogFileMaxBackups >= 0 && LogFileMaxAge >= 0 && LogFileRotateInterval >= 0, "--log-file-max-backups, --log-file-max-age and --log-file-rotate-interval can't be negative")
+	check(LogWarnBurst == 0 || LogWarnSamplePeriod > 0, "--log-warn-sample-period must be greater than 0 when --log-warn-burst is set")
+	check(MaxInflightPerClient >= 0, "--max-inflight-per-client can't be negative")
+	check(MaxStreamsPerClient >= 0, "--max-streams-per-client can't be negative")
+	check(len(GeoBlockedCountries) == 0 || GeoIPDatabase != "", "--geoip-database is required by --geo-blocked-countries")
+
+	check(!ChaosMode || Env != EnvProduction, "--chaos can't be enabled in production")
+
+	if SMTPProbe && MailerDriver == MailerSMTP {
+		err := mailer.Probe(mailer.Config{Host: SMTPServer, Port: SMTPPort, Username: SMTPUserName, Password: SMTPPassword})
+		check(err == nil, "--smtp-server-addr and --smtp-server-port can't be reached with the smtp credentials: %v", err)
+	}
+
+	if len(problems) > 0 {
+		return errors.New("invalid configuration:\n  - " + strings.Join(problems, "\n  - "))
+	}
+	return nil
+}
+
+// checkDSN parses the dsn the way the driver does when it opens the database
+func checkDSN(driver string, dsn string) (err error) {
+	if driver == DBDriverPgx {
+		_, err := pgx.ParseConfig(dsn)
+		return err
+	}
+	// pgdriver panics on an invalid dsn
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	pgdriver.NewConnector(pgdriver.WithDSN(dsn))
+	return nil
+}
+
+// rateLimitProblems reports the rate limits which would reject every request or never apply
+func rateLimitProblems(enabled bool, global int64, perClient int64, anonymous int64) []string {
+	if !enabled {
+		return nil
+	}
+	var problems []string
+	if global <= 0 || perClient <= 0 {
+		problems = append(problems, "--global-request-rate-limit and --per-client-rate-limit must be greater than 0 when --enable-rate-limit is set")
+	}
+	if perClient > global {
+		problems = append(problems, fmt.Sprintf("--per-client-rate-limit %d never applies since it's greater than --global-request-rate-limit %d", perClient, global))
+	}
+	if anonymous > perClient {
+		problems = append(problems, fmt.Sprintf("--anonymous-rate-limit %d never applies since it's greater than --per-client-rate-limit %d", anonymous, perClient))
+	}
+	return problems
+}
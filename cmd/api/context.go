@@ -3,16 +3,24 @@ package api
 import (
 	"context"
 	"net/http"
+	"slices"
 
 	"github.com/cybrarymin/greenlight/internal/data"
+	"github.com/rs/zerolog"
 )
 
 type contextKey string
 
 const userContextKey = contextKey("user")
 
+// SetUserContext stores the authenticated user of the request. The user id is added to the request logger, the baggage
+// and the spans of the request as well. check withUserBaggage
 func (app *application) SetUserContext(r *http.Request, u *data.User) *http.Request {
-	ctx := context.WithValue(r.Context(), userContextKey, u)
+	ctx := context.WithValue(withUserBaggage(r.Context(), u), userContextKey, u)
+	if !u.IsAnonymous() {
+		logger := app.logger(ctx).With().Str("user_id", u.ID.String()).Logger()
+		ctx = context.WithValue(ctx, loggerContextKey, &logger)
+	}
 	return r.WithContext(ctx)
 }
 
@@ -23,3 +31,60 @@ func (app *application) GetUserContext(r *http.Request) *data.User {
 	}
 	return user
 }
+
+const impersonationContextKey = contextKey("impersonation")
+
+// impersonation holds the real actor of a request made with an impersonation token
+type impersonation struct {
+	Actor *data.User
+	ID    string
+}
+
+func (app *application) SetImpersonationContext(r *http.Request, imp *impersonation) *http.Request {
+	ctx := context.WithValue(r.Context(), impersonationContextKey, imp)
+	return r.WithContext(ctx)
+}
+
+// GetImpersonationContext returns nil unless the request is made with an impersonation token
+func (app *application) GetImpersonationContext(r *http.Request) *impersonation {
+	imp, _ := r.Context().Value(impersonationContextKey).(*impersonation)
+	return imp
+}
+
+const tokenScopeContextKey = contextKey("tokenScope")
+
+// SetTokenScopeContext stores the permissions the token of the request is narrowed to. check requirePermission
+func (app *application) SetTokenScopeContext(r *http.Request, scope []string) *http.Request {
+	ctx := context.WithValue(r.Context(), tokenScopeContextKey, scope)
+	return r.WithContext(ctx)
+}
+
+// tokenScoped reports whether the token of the request context is narrowed to a scope
+func tokenScoped(ctx context.Context) bool {
+	scope, _ := ctx.Value(tokenScopeContextKey).([]string)
+	return scope != nil
+}
+
+// scopeAllows reports whether the token of the request context is allowed to use the permission. The unscoped tokens
+// are allowed every permission of their user.
+func scopeAllows(ctx context.Context, permission string) bool {
+	scope, _ := ctx.Value(tokenScopeContextKey).([]string)
+	return scope == nil || slices.Contains(scope, permission)
+}
+
+const loggerContextKey = contextKey("logger")
+
+func (app *application) SetLoggerContext(r *http.Request, logger *zerolog.Logger) *http.Request {
+	ctx := context.WithValue(r.Context(), loggerContextKey, logger)
+	return r.WithContext(ctx)
+}
+
+// logger returns the logger of the request carrying its request id, route, trace id and user id.
+// It falls back to app.log out of a request, e.g. in the background jobs started without a request context.
+func (app *application) logger(ctx context.Context) *zerolog.Logger {
+	logger, ok := ctx.Value(loggerContextKey).(*zerolog.Logger)
+	if !ok {
+		return app.log
+	}
+	return logger
+}
@@ -0,0 +1,244 @@
+package api
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cybrarymin/greenlight/internal/data"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect/pgdialect"
+	"github.com/uptrace/bun/driver/pgdriver"
+)
+
+// contract tracks the documented operations the responses of the api are checked for
+type contract struct {
+	schemas *responseSchemas
+
+	mu sync.Mutex
+	// covered lists the documented operations which got a response
+	covered map[string]bool
+}
+
+// validator checks every response of next against the operation of the request in the swagger document
+func (c *contract) validator(t *testing.T, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route, pathParams := c.schemas.operation(r)
+		if route == nil {
+			t.Errorf("%s %s isn't documented", r.Method, r.URL.Path)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		rec := httptest.NewRecorder()
+		next.ServeHTTP(rec, r)
+		err := c.schemas.validate(r, route, pathParams, rec.Code, rec.Header(), rec.Body.Bytes())
+		assert.NoError(t, err, "%s %s responded %d with %s", r.Method, r.URL.Path, rec.Code, rec.Body.String())
+
+		c.mu.Lock()
+		c.covered[r.Method+" "+route.Path] = true
+		c.mu.Unlock()
+
+		for key, values := range rec.Header() {
+			w.Header()[key] = values
+		}
+		w.WriteHeader(rec.Code)
+		w.Write(rec.Body.Bytes())
+	})
+}
+
+// operations lists the operations of the swagger document
+func (c *contract) operations() []string {
+	var operations []string
+	for path, item := range c.schemas.doc.Paths.Map() {
+		for method := range item.Operations() {
+			operations = append(operations, method+" "+path)
+		}
+	}
+	return operations
+}
+
+// unreachableModels fails every query, so the handlers get as far as they can without a database
+func unreachableModels() *data.Models {
+	connector := pgdriver.NewConnector(pgdriver.WithDSN("postgres://greenlight@127.0.0.1:1/greenlight?sslmode=disable"), pgdriver.WithDialTimeout(time.Second))
+	return data.NewModels(bun.NewDB(sql.OpenDB(connector), pgdialect.New()))
+}
+
+func TestContract(t *testing.T) {
+	defer func(exportMode, key, issuer, audience string, catalog bool, bodySize int64) {
+		MetricsExportMode, JWTKEY, JWTIssuer, JWTAudience, PublicCatalog, MaxBodySize = exportMode, key, issuer, audience, catalog, bodySize
+	}(MetricsExportMode, JWTKEY, JWTIssuer, JWTAudience, PublicCatalog, MaxBodySize)
+	MetricsExportMode, MaxBodySize = MetricsExportPrometheus, 1<<20
+	JWTKEY, JWTIssuer, JWTAudience = "secret", "greenlight", "greenlight-api"
+	// the public catalog lets the anonymous requests reach the movie handlers
+	PublicCatalog = true
+
+	signJWT := func(key string, expiresAt time.Time) string {
+		claims := customClaims{Email: "john@example.com", RegisteredClaims: jwt.RegisteredClaims{
+			Issuer: JWTIssuer, Audience: []string{JWTAudience}, ExpiresAt: jwt.NewNumericDate(expiresAt),
+		}}
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(key))
+		require.NoError(t, err)
+		return "Bearer " + token
+	}
+	opaqueToken := "Bearer " + strings.Repeat("A", 26)
+	movie := `{"title":"avengers","year":2018,"runtime":"75 mins","genres":["action"]}`
+
+	logger := zerolog.Nop()
+	app := &application{log: &logger, models: unreachableModels()}
+	app.runtime.Store(&runtimeConfig{RateLimitEnabled: true, GlobalRateLimit: 1000, PerClientRateLimit: 1000, AnonymousRateLimit: 1})
+	schemas, err := loadResponseSchemas()
+	require.NoError(t, err)
+	c := &contract{schemas: schemas, covered: map[string]bool{}}
+	handler := c.validator(t, app.routes())
+
+	tests := []struct {
+		name          string
+		method        string
+		target        string
+		authorization string
+		body          string
+		// repeat sends the request as many more times before the checked one
+		repeat int
+		status int
+	}{
+		{name: "List with invalid filters", method: http.MethodGet, target: "/v1/movies?page=0", status: http.StatusUnprocessableEntity},
+		{name: "List with an unknown facet", method: http.MethodGet, target: "/v1/movies?facets=genres,rating", status: http.StatusUnprocessableEntity},
+		{name: "Autocomplete a single letter", method: http.MethodGet, target: "/v1/movies/autocomplete?q=a", status: http.StatusUnprocessableEntity},
+		{name: "List without a database", method: http.MethodGet, target: "/v1/movies", status: http.StatusInternalServerError},
+		{name: "List over the anonymous rate limit", method: http.MethodGet, target: "/v1/movies?page=0", repeat: 3, status: http.StatusTooManyRequests},
+		{name: "List with an invalid jwt signature", method: http.MethodGet, target: "/v1/movies", authorization: signJWT("other", time.Now().Add(time.Hour)), status: http.StatusUnauthorized},
+		{name: "Create anonymously", method: http.MethodPost, target: "/v1/movies", body: movie, status: http.StatusUnauthorized},
+		{name: "Create with an invalid authorization header", method: http.MethodPost, target: "/v1/movies", authorization: "Basic am9objpwYXNz", body: movie, status: http.StatusUnauthorized},
+		{name: "Show with an invalid id", method: http.MethodGet, target: "/v1/movies/abc", status: http.StatusUnprocessableEntity},
+		{name: "Show without a database", method: http.MethodGet, target: "/v1/movies/1", status: http.StatusInternalServerError},
+		{name: "Show with an expired jwt", method: http.MethodGet, target: "/v1/movies/1", authorization: signJWT(JWTKEY, time.Now().Add(-time.Hour)), status: http.StatusUnauthorized},
+		{name: "Update anonymously", method: http.MethodPatch, target: "/v1/movies/1", body: movie, status: http.StatusUnauthorized},
+		{name: "Update without a database", method: http.MethodPatch, target: "/v1/movies/1", authorization: opaqueToken, body: movie, status: http.StatusInternalServerError},
+		{name: "Delete anonymously", method: http.MethodDelete, target: "/v1/movies/1", status: http.StatusUnauthorized},
+		{name: "Delete without a database", method: http.MethodDelete, target: "/v1/movies/1", authorization: signJWT(JWTKEY, time.Now().Add(time.Hour)), status: http.StatusInternalServerError},
+		{name: "Upsert anonymously", method: http.MethodPut, target: "/v1/movies/by-external-id/imdb/tt4154756", body: movie, status: http.StatusUnauthorized},
+	}
+
+	for i, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var rec *httptest.ResponseRecorder
+			for n := 0; n <= tc.repeat; n++ {
+				r := httptest.NewRequest(tc.method, tc.target, strings.NewReader(tc.body))
+				// the anonymous requests of every case are rate limited on their own
+				r.RemoteAddr = fmt.Sprintf("192.0.2.%d:1234", i+1)
+				if tc.authorization != "" {
+					r.Header.Set("Authorization", tc.authorization)
+				}
+				rec = httptest.NewRecorder()
+				handler.ServeHTTP(rec, r)
+			}
+			assert.Equal(t, tc.status, rec.Code, rec.Body.String())
+		})
+	}
+
+	for _, operation := range c.operations() {
+		assert.True(t, c.covered[operation], "%s has no contract test", operation)
+	}
+}
+
+// TestContractSuccess checks the successful responses of every documented operation, which need the handlers to get
+// past their queries, so the database is stubbed by a stubDB answering them with canned rows
+func TestContractSuccess(t *testing.T) {
+	defer func(exportMode string, catalog bool, bodySize int64) {
+		MetricsExportMode, PublicCatalog, MaxBodySize = exportMode, catalog, bodySize
+	}(MetricsExportMode, PublicCatalog, MaxBodySize)
+	MetricsExportMode, PublicCatalog, MaxBodySize = MetricsExportPrometheus, true, 1<<20
+
+	token := "Bearer " + strings.Repeat("A", 26)
+	movie := `{"title":"avengers","year":2018,"runtime":"75 mins","genres":["action"]}`
+
+	now := time.Now()
+	userID := uuid.New()
+	movieColumns := []string{"id", "created_at", "title", "year", "runtime", "genres", "version", "updated_at", "external_source",
+		"external_id", "imdb_rating", "rotten_tomatoes_score", "external_ratings_updated_at"}
+	movieRow := []driver.Value{int64(1), now, "avengers", int64(2018), int64(75), "{action}", int64(1), now, nil, nil, nil, nil, nil}
+	tokenColumns := []string{"hash", "user_id", "expiry", "scope", "used_at", "user__id", "user__name", "user__password_hash",
+		"user__created_at", "user__activated", "user__email", "user__email_status", "user__external_id", "user__version",
+		"user__updated_at", "user__suspended_until", "user__banned", "user__suspension_reason", "user__password_reset_required"}
+	userRow := []driver.Value{userID.String(), "john", []byte("password"), now, true, "john@example.com", "", nil, int64(1), now, nil, false, nil, false}
+	tokenRow := append([]driver.Value{[]byte("hash"), userID.String(), now.Add(time.Hour), data.AuthenticationScope, nil}, userRow...)
+	userColumns := make([]string, 0, len(tokenColumns))
+	for _, column := range tokenColumns {
+		if name, found := strings.CutPrefix(column, "user__"); found {
+			userColumns = append(userColumns, name)
+		}
+	}
+
+	logger := zerolog.Nop()
+	app := &application{log: &logger, models: stubModels(t,
+		stub(`FROM "tokens" AS "token"`, tokenColumns, tokenRow),
+		stub(`^SELECT "user"\..* FROM "users" AS "user"`, userColumns, userRow),
+		stub(`^INSERT INTO "request_usage"`, nil, nil),
+		stub(`FROM "permissions" AS "permission" WHERE \(id IN \(SELECT permission_id FROM user_effective_permissions WHERE user_id = '`+userID.String()+`'\)\)`,
+			[]string{"id", "code"}, []driver.Value{int64(1), "movies:read"}, []driver.Value{int64(2), "movies:write"}),
+		stub(`^SELECT "movie"."id", "movie"."title", "movie"."year" FROM "movies" AS "movie" WHERE \('av' <% title\) ORDER BY word_similarity\('av', title\) DESC`,
+			[]string{"id", "title", "year"}, []driver.Value{int64(1), "avengers", int64(2018)}),
+		stub(`^SELECT "movie"\..* FROM "movies" AS "movie"`, movieColumns, movieRow),
+		stub(`^SELECT count\(\*\) FROM "movies"`, []string{"count"}, []driver.Value{int64(1)}),
+		stub(`^SELECT COUNT\(\*\) AS count, .* FROM "movies" AS "movie"`, []string{"count", "value"}, []driver.Value{int64(1), "action"}),
+		stub(`^INSERT INTO "movies" \(`, []string{"id", "created_at", "version"}, []driver.Value{int64(1), now, int64(1)}),
+		stub(`^INSERT INTO "movies" AS "movie" .* ON CONFLICT`, []string{"id", "created_at", "version", "created"}, []driver.Value{int64(1), now, int64(1), true}),
+		stub(`^UPDATE "movies"`, []string{"created_at", "version"}, []driver.Value{now, int64(2)}),
+		stub(`pg_advisory_xact_lock`, []string{"pg_advisory_xact_lock"}, []driver.Value{nil}),
+		stub(`^DELETE FROM "movies"`, []string{"external_source", "external_id"}, []driver.Value{nil, nil}),
+		stub(`^SELECT .* FROM "movie_tombstones"`, []string{"movie_id"}),
+		stub(`^INSERT INTO "movie_tombstones"`, []string{"merged_into", "external_source", "external_id"}, []driver.Value{nil, nil, nil}),
+		stub(`^DELETE FROM "resource_redirects"`, nil),
+	), hub: newEventHub()}
+	app.runtime.Store(&runtimeConfig{})
+	schemas, err := loadResponseSchemas()
+	require.NoError(t, err)
+	c := &contract{schemas: schemas, covered: map[string]bool{}}
+	handler := c.validator(t, app.routes())
+
+	tests := []struct {
+		name          string
+		method        string
+		target        string
+		authorization string
+		body          string
+		status        int
+	}{
+		{name: "List", method: http.MethodGet, target: "/v1/movies", status: http.StatusOK},
+		{name: "List with facets", method: http.MethodGet, target: "/v1/movies?facets=genres,year_decade", status: http.StatusOK},
+		{name: "Autocomplete", method: http.MethodGet, target: "/v1/movies/autocomplete?q=av", status: http.StatusOK},
+		{name: "Show", method: http.MethodGet, target: "/v1/movies/1", status: http.StatusOK},
+		{name: "Create", method: http.MethodPost, target: "/v1/movies", authorization: token, body: movie, status: http.StatusCreated},
+		{name: "Update", method: http.MethodPatch, target: "/v1/movies/1", authorization: token, body: `{"title":"avengers: endgame"}`, status: http.StatusOK},
+		{name: "Delete", method: http.MethodDelete, target: "/v1/movies/1", authorization: token, status: http.StatusOK},
+		{name: "Upsert", method: http.MethodPut, target: "/v1/movies/by-external-id/imdb/tt4154756", authorization: token, body: movie, status: http.StatusCreated},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(tc.method, tc.target, strings.NewReader(tc.body))
+			if tc.authorization != "" {
+				r.Header.Set("Authorization", tc.authorization)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, r)
+			assert.Equal(t, tc.status, rec.Code, rec.Body.String())
+		})
+	}
+
+	for _, operation := range c.operations() {
+		assert.True(t, c.covered[operation], "%s has no successful contract test", operation)
+	}
+}
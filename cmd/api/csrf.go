@@ -0,0 +1,157 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+
+	"go.opentelemetry.io/otel/codes"
+)
+
+var (
+	// CookieSameSite is the SameSite attribute of the cookies set by the server (strict|lax|none)
+	CookieSameSite string
+	// CookieSecure restricts the cookies set by the server to https. It can only be disabled in development.
+	CookieSecure bool
+	// CSRFSigningKey is the hmac key binding the csrf tokens to the session of the cookie. It's required with the cookie sessions.
+	CSRFSigningKey string
+)
+
+// SameSite attributes of the cookies
+const (
+	SameSiteStrict = "strict"
+	SameSiteLax    = "lax"
+	SameSiteNone   = "none"
+)
+
+const (
+	// sessionCookieName is the cookie authenticating the browser sessions of the web frontend
+	sessionCookieName = "greenlight_session"
+	csrfCookieName    = "greenlight_csrf"
+	csrfHeaderName    = "X-CSRF-Token"
+)
+
+func cookieSameSite() http.SameSite {
+	switch CookieSameSite {
+	case SameSiteStrict:
+		return http.SameSiteStrictMode
+	case SameSiteNone:
+		return http.SameSiteNoneMode
+	default:
+		return http.SameSiteLaxMode
+	}
+}
+
+// csrfSafeMethod reports whether the method doesn't change anything, so it doesn't need the csrf token
+func csrfSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+// csrfToken derives the csrf token from the nonce of the csrf cookie and the session of the session cookie, which is empty
+// before signing in. The token of a session isn't valid for another one, so a token planted by other sites is useless.
+func csrfToken(nonce string, session string) string {
+	mac := hmac.New(sha256.New, []byte(CSRFSigningKey))
+	mac.Write([]byte(nonce + "." + session))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// validCSRFToken reports whether X-CSRF-Token header of the request holds the token of its csrf and session cookies
+func validCSRFToken(r *http.Request) bool {
+	nonce, err := r.Cookie(csrfCookieName)
+	token := r.Header.Get(csrfHeaderName)
+	if err != nil || nonce.Value == "" || token == "" {
+		return false
+	}
+	var session string
+	if cookie, err := r.Cookie(sessionCookieName); err == nil {
+		session = cookie.Value
+	}
+	return secureCompare(token, csrfToken(nonce.Value, session))
+}
+
+// csrfProtect requires the csrf token on the unsafe requests authenticated by the session cookie.
+// The token is derived from the csrf cookie and the session, and must be sent in X-CSRF-Token header, which other sites
+// can't do as they can't read the token. Requests with the Authorization header aren't authenticated by the cookie,
+// so they aren't exposed to csrf. Signing in is protected by csrfLogin.
+func (app *application) csrfProtect(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !CookieSessions || csrfSafeMethod(r.Method) || r.Header.Get("Authorization") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if _, err := r.Cookie(sessionCookieName); err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !validCSRFToken(r) {
+			app.invalidCSRFTokenResponse(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// csrfLogin requires the csrf token on signing in, so other sites can't sign the browser in to an account of their own.
+// The token is the one issued before signing in.
+func (app *application) csrfLogin() middleware {
+	return middleware{wrap: func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if !validCSRFToken(r) {
+				app.invalidCSRFTokenResponse(w, r)
+				return
+			}
+			next(w, r)
+		}
+	}}
+}
+
+// newCSRFToken sets a new csrf cookie and returns its token for the session
+func newCSRFToken(w http.ResponseWriter, session string) (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	nonce := base64.RawURLEncoding.EncodeToString(b)
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookieName,
+		Value:    nonce,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   CookieSecure,
+		SameSite: cookieSameSite(),
+	})
+	return csrfToken(nonce, session), nil
+}
+
+// createCSRFTokenHandler issues a csrf token for the session of the cookie, or for signing in when there's none.
+// The web frontend sends it back in X-CSRF-Token header on the unsafe requests. check csrfProtect
+func (app *application) createCSRFTokenHandler(w http.ResponseWriter, r *http.Request) {
+	_, span := app.startSpan(r, "createCSRFToken")
+	defer span.End()
+
+	var session string
+	if cookie, err := r.Cookie(sessionCookieName); err == nil {
+		session = cookie.Value
+	}
+	token, err := newCSRFToken(w, session)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to generate the csrf token")
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Cache-Control", "no-store")
+	err = app.writeJson(w, r, http.StatusOK, envelope{"CSRFToken": token}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,106 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCSRFProtect(t *testing.T) {
+	defer func(sessions bool, key string) { CookieSessions, CSRFSigningKey = sessions, key }(CookieSessions, CSRFSigningKey)
+	CookieSessions, CSRFSigningKey = true, "csrf-signing-key"
+	logger := zerolog.Nop()
+	app := &application{log: &logger}
+	app.runtime.Store(&runtimeConfig{})
+	handler := app.csrfProtect(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		name          string
+		method        string
+		session       string
+		token         string
+		authorization string
+		status        int
+	}{
+		{name: "Token of the session", method: http.MethodPost, session: "session", token: csrfToken("nonce", "session"), status: http.StatusOK},
+		{name: "Missing token", method: http.MethodPost, session: "session", status: http.StatusForbidden},
+		{name: "Token of another session", method: http.MethodPost, session: "session", token: csrfToken("nonce", "other"), status: http.StatusForbidden},
+		{name: "Token of signing in", method: http.MethodPost, session: "session", token: csrfToken("nonce", ""), status: http.StatusForbidden},
+		{name: "Token signed by another key", method: http.MethodDelete, session: "session", token: "c2lnbmVkIGJ5IGFub3RoZXIga2V5", status: http.StatusForbidden},
+		{name: "Safe method", method: http.MethodGet, session: "session", status: http.StatusOK},
+		{name: "Without the session cookie", method: http.MethodPost, status: http.StatusOK},
+		{name: "Authorization header", method: http.MethodPost, session: "session", authorization: "Bearer token", status: http.StatusOK},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(tc.method, "/v1/movies", nil)
+			r.AddCookie(&http.Cookie{Name: csrfCookieName, Value: "nonce"})
+			if tc.session != "" {
+				r.AddCookie(&http.Cookie{Name: sessionCookieName, Value: tc.session})
+			}
+			if tc.token != "" {
+				r.Header.Set(csrfHeaderName, tc.token)
+			}
+			if tc.authorization != "" {
+				r.Header.Set("Authorization", tc.authorization)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, r)
+			assert.Equal(t, tc.status, rec.Code, rec.Body.String())
+		})
+	}
+}
+
+func TestCSRFLogin(t *testing.T) {
+	defer func(key string) { CSRFSigningKey = key }(CSRFSigningKey)
+	CSRFSigningKey = "csrf-signing-key"
+	logger := zerolog.Nop()
+	app := &application{log: &logger}
+	app.runtime.Store(&runtimeConfig{})
+
+	// the token of signing in is issued before there's a session
+	rec := httptest.NewRecorder()
+	app.createCSRFTokenHandler(rec, httptest.NewRequest(http.MethodGet, "/v1/csrf", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var body struct {
+		CSRFToken string `json:"CSRFToken"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	cookies := rec.Result().Cookies()
+	require.Len(t, cookies, 1)
+
+	login := app.csrfLogin().wrap(func(w http.ResponseWriter, r *http.Request) {})
+	tests := []struct {
+		name   string
+		token  string
+		cookie *http.Cookie
+		status int
+	}{
+		{name: "Token of signing in", token: body.CSRFToken, cookie: cookies[0], status: http.StatusOK},
+		{name: "Missing token", cookie: cookies[0], status: http.StatusForbidden},
+		{name: "Missing cookie", token: body.CSRFToken, status: http.StatusForbidden},
+		{name: "Token of another cookie", token: csrfToken("other", ""), cookie: cookies[0], status: http.StatusForbidden},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/v1/sessions", nil)
+			r.SetBasicAuth("user@example.com", "password")
+			if tc.cookie != nil {
+				r.AddCookie(tc.cookie)
+			}
+			if tc.token != "" {
+				r.Header.Set(csrfHeaderName, tc.token)
+			}
+			rec := httptest.NewRecorder()
+			login(rec, r)
+			assert.Equal(t, tc.status, rec.Code, rec.Body.String())
+		})
+	}
+}
@@ -0,0 +1,185 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cybrarymin/greenlight/internal/data"
+	"github.com/cybrarymin/greenlight/migrations"
+	embeddedpostgres "github.com/fergusstrange/embedded-postgres"
+	"github.com/rs/zerolog"
+	"github.com/uptrace/bun"
+)
+
+// DemoOptions configures the sandbox run by Demo
+type DemoOptions struct {
+	// DBPort is the port of the embedded postgres
+	DBPort uint32
+	// DataDir keeps the database between the runs, the database is thrown away on exit when it's empty
+	DataDir string
+	Movies  int
+	Users   int
+	Seed    int64
+	// Password is the password of the demo admin and of the generated users
+	Password string
+	// PostgresCacheDir keeps the downloaded postgres binaries, the default cache of embedded-postgres is used when it's empty
+	PostgresCacheDir string
+	// PostgresRepositoryURL is the maven repository the postgres binaries are downloaded from, like a mirror behind a firewall
+	PostgresRepositoryURL string
+}
+
+const (
+	demoAdminEmail = "admin@demo.greenlight.local"
+	demoDBName     = "greenlight"
+	demoTokenTTL   = 30 * 24 * time.Hour
+)
+
+// Demo runs the api on an embedded postgres seeded with a catalog, so the frontend developers can run it without any
+// infrastructure. The emails are written to stdout and an admin token is issued on every run.
+func Demo(opts DemoOptions) error {
+	logger := zerolog.New(zerolog.ConsoleWriter{Out: os.Stdout, TimeFormat: time.RFC3339}).With().Timestamp().Logger()
+	events := logEvents(&logger)
+
+	runtimeDir, err := os.MkdirTemp("", "greenlight-demo-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(runtimeDir)
+	dataDir := filepath.Join(runtimeDir, "data")
+	if opts.DataDir != "" {
+		dataDir = opts.DataDir
+	}
+	_, err = os.Stat(filepath.Join(dataDir, "PG_VERSION"))
+	seeded := err == nil
+
+	dbConfig := embeddedpostgres.DefaultConfig().
+		Port(opts.DBPort).
+		Database(demoDBName).
+		Username(demoDBName).
+		Password(demoDBName).
+		RuntimePath(runtimeDir).
+		DataPath(dataDir)
+	if opts.PostgresCacheDir != "" {
+		dbConfig = dbConfig.CachePath(opts.PostgresCacheDir)
+	}
+	if opts.PostgresRepositoryURL != "" {
+		dbConfig = dbConfig.BinaryRepositoryURL(opts.PostgresRepositoryURL)
+	}
+	db := embeddedpostgres.NewDatabase(dbConfig)
+	events.Info("starting the embedded postgres, its binaries are downloaded on the first run", logFields{"repository": opts.PostgresRepositoryURL})
+	if err := db.Start(); err != nil {
+		return fmt.Errorf("failed to start the embedded postgres: %w", err)
+	}
+	defer func() {
+		if err := db.Stop(); err != nil {
+			events.Error(err, "failed to stop the embedded postgres", nil)
+		}
+	}()
+
+	DBDSN = fmt.Sprintf("postgres://%s:%s@localhost:%d/%s?sslmode=disable", demoDBName, demoDBName, opts.DBPort, demoDBName)
+	DBDriver = DBDriverPg
+	if !seeded {
+		if err := migrateDemoDB(); err != nil {
+			return err
+		}
+		events.Info("seeding the catalog", nil)
+		err := LoadGen(LoadGenOptions{Movies: opts.Movies, Users: opts.Users, ProgressPerUser: 3, Seed: opts.Seed, BatchSize: 500, Password: opts.Password})
+		if err != nil {
+			return err
+		}
+	}
+	token, err := demoAdminToken(opts.Password)
+	if err != nil {
+		return err
+	}
+
+	// the demo has no smtp server and no secrets to configure
+	Env, LogFormat, MailerDriver = EnvDevelopment, LogFormatConsole, MailerLog
+	if JWTKEY == "" {
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return err
+		}
+		JWTKEY = hex.EncodeToString(key)
+	}
+	events.Info("the demo admin is ready, the emails are written to stdout", logFields{
+		"url":           fmt.Sprintf("http://localhost:%d/v1", ListenPort),
+		"email":         demoAdminEmail,
+		"password":      opts.Password,
+		"authorization": "Bearer " + token,
+	})
+	Api()
+	return nil
+}
+
+// migrateDemoDB applies the migrations and records the version the way golang-migrate does, so the schema check passes
+func migrateDemoDB() error {
+	ups, err := migrations.Up()
+	if err != nil {
+		return err
+	}
+	return withDemoDB(func(ctx context.Context, db *bun.DB, _ *data.Models) error {
+		for _, up := range ups {
+			// the statements of a migration are run at once by the simple query protocol like golang-migrate does
+			if _, err := db.DB.ExecContext(ctx, up.SQL); err != nil {
+				return fmt.Errorf("failed to apply %s: %w", up.Name, err)
+			}
+		}
+		_, err := db.DB.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE schema_migrations (version bigint NOT NULL PRIMARY KEY, dirty boolean NOT NULL);
+			INSERT INTO schema_migrations (version, dirty) VALUES (%d, false)`, migrations.LatestVersion()))
+		return err
+	})
+}
+
+// demoAdminToken creates the activated admin of the demo once and issues an authentication token for it
+func demoAdminToken(password string) (string, error) {
+	var plaintext string
+	err := withDemoDB(func(ctx context.Context, _ *bun.DB, models *data.Models) error {
+		user, err := models.Users.GetByEmail(demoAdminEmail, ctx)
+		if errors.Is(err, data.ErrorRecordNotFound) {
+			user = &data.User{Name: "Demo Admin", Email: demoAdminEmail, Activated: true}
+			if err := user.Password.Set(password); err != nil {
+				return err
+			}
+			if err := models.Users.Insert(ctx, user); err != nil {
+				return err
+			}
+			err = models.Permissions.AddPermForUser(ctx, user.ID, "movies:read", "movies:write", "admin")
+		}
+		if err != nil {
+			return err
+		}
+		token, err := models.Tokens.New(ctx, demoTokenTTL, user.ID, data.AuthenticationScope)
+		if err != nil {
+			return err
+		}
+		plaintext = token.PlainText
+		return nil
+	})
+	return plaintext, err
+}
+
+// withDemoDB runs fn on a connection to the embedded postgres
+func withDemoDB(fn func(ctx context.Context, db *bun.DB, models *data.Models) error) error {
+	cfg := config{}
+	cfg.db.dbDsn = DBDSN
+	cfg.db.driver = DBDriver
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	connector, err := dbConnector(&cfg)
+	if err != nil {
+		return err
+	}
+	db, err := openDB(ctx, connector)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return fn(ctx, db, data.NewModels(db))
+}
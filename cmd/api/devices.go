@@ -0,0 +1,70 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/cybrarymin/greenlight/internal/data"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// listDevicesHandler lists the devices holding the live tokens of the user. The devices are recorded when the
+// tokens are issued and named by device_name of POST /v1/tokens.
+func (app *application) listDevicesHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := app.startSpan(r, "listDevices")
+	defer span.End()
+
+	userID, ok := app.userIDParam(ctx, w, r, span)
+	if !ok {
+		return
+	}
+
+	devices, err := app.models.Devices.ListForUser(ctx, userID)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, otelDBErr)
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJson(w, r, http.StatusOK, envelope{"Devices": devices}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteDeviceHandler signs the device out by revoking its token
+func (app *application) deleteDeviceHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := app.startSpan(r, "deleteDevice")
+	defer span.End()
+
+	userID, ok := app.userIDParam(ctx, w, r, span)
+	if !ok {
+		return
+	}
+	id, err := app.pathParams(r).Int64("device_id")
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, otelunprocessableErr)
+		app.invalidParamResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Devices.Revoke(ctx, userID, id)
+	if err != nil {
+		span.RecordError(err)
+		switch {
+		case errors.Is(err, data.ErrorRecordNotFound):
+			span.SetStatus(codes.Ok, otelDBNotFoundInfo)
+			app.notFoundResponse(w, r)
+		default:
+			span.SetStatus(codes.Error, otelDBErr)
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJson(w, r, http.StatusOK, envelope{"message": "device signed out"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,190 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cybrarymin/greenlight/internal/data"
+	mailer "github.com/cybrarymin/greenlight/internal/mailter"
+	"go.opentelemetry.io/otel/codes"
+)
+
+var EmailWebhookSecret string
+
+// onMailSend is called by the mailer after each send attempt to record the metrics and the email log.
+func (app *application) onMailSend(result mailer.SendResult) {
+	recordMailSend(result.Duration, result.Err)
+
+	nLog := &data.EmailLog{
+		MessageID: result.MessageID,
+		Recipient: result.Recipient,
+		Template:  result.TemplateFile,
+		Status:    data.EmailStatusSent,
+	}
+	if result.Err != nil {
+		nLog.Status = data.EmailStatusFailed
+		nLog.Error = result.Err.Error()
+	}
+	err := app.models.EmailLogs.Insert(context.Background(), nLog)
+	if err != nil {
+		logEvents(app.log).Error(err, fmt.Sprintf("failed to record the email log for message %s", result.MessageID), nil)
+	}
+}
+
+func (app *application) listEmailsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := app.startSpan(r, "listEmails")
+	defer span.End()
+
+	var input struct {
+		Recipient string
+		Status    string
+		data.Filters
+	}
+	nValidator := data.NewValidator()
+	qs := r.URL.Query()
+	input.Recipient = app.readString(qs, "recipient", "")
+	input.Status = app.readEnum(qs, "status", "", []string{data.EmailStatusSent, data.EmailStatusFailed, data.EmailStatusDelivered, data.EmailStatusBounced, data.EmailStatusComplained}, nValidator)
+	input.Filters.Page = app.readInt(qs, "page", 1, nValidator)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, nValidator)
+	input.Filters.Sort = app.readString(qs, "sort", "-id")
+	input.Filters.Count = app.readString(qs, "count", data.CountExact)
+	input.Filters.SortSafeList = []string{"id", "created_at", "recipient", "status", "-id", "-created_at", "-recipient", "-status"}
+	input.Filters.ValidateFilters(nValidator)
+	if !nValidator.Valid() {
+		span.RecordError(errors.New(createKeyValuePairs(nValidator.Errors)))
+		span.SetStatus(codes.Error, otelunprocessableErr)
+		app.failedValidationResponse(w, r, nValidator)
+		return
+	}
+
+	emails, count, err := app.models.EmailLogs.List(ctx, input.Recipient, input.Status, &input.Filters)
+	if err != nil {
+		span.RecordError(err)
+		switch {
+		case errors.Is(err, data.ErrorRecordNotFound):
+			span.SetStatus(codes.Ok, otelDBNotFoundInfo)
+		default:
+			span.SetStatus(codes.Error, otelDBErr)
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	pMeta := input.Filters.PaginationMetaData(ctx, count)
+	err = app.writeJson(w, r, http.StatusOK, envelope{"Metadata": pMeta, "Emails": emails}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// emailEventsWebhookHandler ingests the delivery, bounce and complaint callbacks of the mail provider.
+// The request body must be signed by hmac-sha256 using the webhook secret and the hex encoded signature provided in X-Webhook-Signature header.
+func (app *application) emailEventsWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := app.startSpan(r, "emailEventsWebhook")
+	defer span.End()
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, otelunprocessableErr)
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if !validWebhookSignature(body, r.Header.Get("X-Webhook-Signature"), EmailWebhookSecret) {
+		span.SetStatus(codes.Error, otelAuthFailureErr)
+		app.invalidWebhookSignatureResponse(w, r)
+		return
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	var input struct {
+		Events []struct {
+			Type      string    `json:"type"`
+			MessageID string    `json:"message_id"`
+			Recipient string    `json:"recipient"`
+			Reason    string    `json:"reason"`
+			Timestamp time.Time `json:"timestamp"`
+		} `json:"events"`
+	}
+	err = app.readJson(w, r, &input)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, otelunprocessableErr)
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	nValidator := data.NewValidator()
+	nValidator.Check(len(input.Events) >= 1, "events", "must at least have one element")
+	for _, event := range input.Events {
+		nValidator.Check(data.In(event.Type, data.EmailStatusDelivered, data.EmailStatusBounced, data.EmailStatusComplained), "type", "must be one of delivered, bounced or complained")
+		nValidator.Check(event.MessageID != "" || event.Recipient != "", "message_id", "message_id or recipient must be provided")
+	}
+	if !nValidator.Valid() {
+		span.RecordError(errors.New(createKeyValuePairs(nValidator.Errors)))
+		span.SetStatus(codes.Error, otelunprocessableErr)
+		app.failedValidationResponse(w, r, nValidator)
+		return
+	}
+
+	processed := 0
+	for _, event := range input.Events {
+		recipient := event.Recipient
+		if event.MessageID != "" {
+			logRecipient, err := app.models.EmailLogs.UpdateStatus(ctx, event.MessageID, event.Type)
+			if err != nil && !errors.Is(err, data.ErrorRecordNotFound) {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, otelDBErr)
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+			if recipient == "" {
+				recipient = logRecipient
+			}
+		}
+
+		if recipient != "" && event.Type != data.EmailStatusDelivered {
+			userStatus := data.UserEmailStatusBounced
+			if event.Type == data.EmailStatusComplained {
+				userStatus = data.UserEmailStatusComplained
+			}
+			err := app.models.Users.SetEmailStatus(ctx, recipient, userStatus)
+			if err != nil && !errors.Is(err, data.ErrorRecordNotFound) {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, otelDBErr)
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+			app.events(r.Context()).Warn(fmt.Sprintf("email address flagged as %s", userStatus), logFields{
+				"recipient": recipient,
+				"reason":    event.Reason,
+			})
+		}
+		processed++
+	}
+
+	err = app.writeJson(w, r, http.StatusOK, envelope{"result": map[string]int{"processed": processed}}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func validWebhookSignature(body []byte, signature string, secret string) bool {
+	signature = strings.TrimPrefix(signature, "sha256=")
+	given, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(given, mac.Sum(nil))
+}
@@ -0,0 +1,99 @@
+package api
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+)
+
+const (
+	EnvDevelopment = "development"
+	EnvStaging     = "staging"
+	EnvProduction  = "production"
+)
+
+const (
+	LogFormatJSON    = "json"
+	LogFormatConsole = "console"
+)
+
+const (
+	// MailerSMTP sends the emails through the smtp server
+	MailerSMTP = "smtp"
+	// MailerLog writes the emails to the standard output instead of sending them
+	MailerLog = "log"
+)
+
+var (
+	LogFormat    string
+	MailerDriver string
+	// RequireTLS rejects the plain http requests which weren't forwarded from https by the proxy in front of the server
+	RequireTLS bool
+)
+
+// EnvProfiles are the flag defaults of each environment. They're only applied to the flags which aren't set explicitly,
+// so any of them can still be overridden.
+var EnvProfiles = map[string]map[string]string{
+	EnvDevelopment: {
+		"log-format":           LogFormatConsole,
+		"mailer":               MailerLog,
+		"cors-trusted-origins": "*",
+		"enable-rate-limit":    "false",
+		"cookie-secure":        "false",
+		"require-tls":          "false",
+		"validate-responses":   "true",
+	},
+	EnvStaging: {
+		"log-format":         LogFormatJSON,
+		"mailer":             MailerSMTP,
+		"enable-rate-limit":  "true",
+		"cookie-secure":      "true",
+		"require-tls":        "false",
+		"validate-responses": "false",
+	},
+	EnvProduction: {
+		"log-format":           LogFormatJSON,
+		"mailer":               MailerSMTP,
+		"cors-trusted-origins": "",
+		"enable-rate-limit":    "true",
+		"cookie-secure":        "true",
+		"require-tls":          "true",
+		"validate-responses":   "false",
+	},
+}
+
+// environmentProblems reports the settings which production doesn't accept even when they're set explicitly
+func environmentProblems(env string, corsOrigins []string, mailerDriver string, smtpUsername string, smtpPassword string) []string {
+	if env != EnvProduction {
+		return nil
+	}
+	var problems []string
+	if slices.Contains(corsOrigins, "*") {
+		problems = append(problems, "--cors-trusted-origins can't allow every origin by * in production, list the trusted origins")
+	}
+	if mailerDriver != MailerSMTP {
+		problems = append(problems, "--mailer must be smtp in production, the emails of the log mailer never reach the users")
+	}
+	if smtpUsername == "" || smtpPassword == "" {
+		problems = append(problems, "--smtp-username and --smtp-password are required in production")
+	}
+	return problems
+}
+
+// requireTLS rejects the requests which didn't arrive over https. The requests terminated by a proxy are accepted
+// when it reports https by X-Forwarded-Proto. The probes and the metrics scrapes are exempt since they're sent
+// directly to the pod over plain http.
+func (app *application) requireTLS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !RequireTLS || r.URL.Path == "/readyz" || r.URL.Path == "/metrics" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if r.TLS == nil && !strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https") {
+			app.tlsRequiredResponse(w, r)
+			return
+		}
+		w.Header().Set("Strict-Transport-Security", "max-age=63072000; includeSubDomains")
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,33 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEnvironmentProblems(t *testing.T) {
+	tests := []struct {
+		name     string
+		env      string
+		origins  []string
+		mailer   string
+		username string
+		password string
+		problems int
+	}{
+		{name: "Development accepts everything", env: EnvDevelopment, origins: []string{"*"}, mailer: MailerLog, problems: 0},
+		{name: "Staging accepts everything", env: EnvStaging, origins: []string{"*"}, mailer: MailerLog, problems: 0},
+		{name: "Production with strict settings", env: EnvProduction, origins: []string{"https://example.com"}, mailer: MailerSMTP, username: "user", password: "pass", problems: 0},
+		{name: "Production without cross origin access", env: EnvProduction, origins: []string{}, mailer: MailerSMTP, username: "user", password: "pass", problems: 0},
+		{name: "Production allowing every origin", env: EnvProduction, origins: []string{"*"}, mailer: MailerSMTP, username: "user", password: "pass", problems: 1},
+		{name: "Production with log mailer", env: EnvProduction, origins: []string{}, mailer: MailerLog, username: "user", password: "pass", problems: 1},
+		{name: "Production without smtp credentials", env: EnvProduction, origins: []string{}, mailer: MailerSMTP, username: "user", problems: 1},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Len(t, environmentProblems(tc.env, tc.origins, tc.mailer, tc.username, tc.password), tc.problems)
+		})
+	}
+}
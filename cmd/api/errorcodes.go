@@ -0,0 +1,115 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+)
+
+// ErrorDocsURL is the page documenting the error codes. The documentation url of an error is the page with the code as its anchor.
+var ErrorDocsURL string
+
+// errorCode is the stable machine readable code sent along with every error response, so the clients can branch on it
+// instead of parsing the messages which may change. The codes are documented in docs/errors.md and must not be renamed.
+type errorCode string
+
+const (
+	codeServerError             errorCode = "server_error"
+	codeNotFound                errorCode = "not_found"
+	codeBadRequest              errorCode = "bad_request"
+	codeRequestTooLarge         errorCode = "request_too_large"
+	codeMethodNotAllowed        errorCode = "method_not_allowed"
+	codeFailedValidation        errorCode = "failed_validation"
+	codeEditConflict            errorCode = "edit_conflict"
+	codeDuplicateRecord         errorCode = "duplicate_record"
+	codeInvalidReference        errorCode = "invalid_reference"
+	codeInvalidValue            errorCode = "invalid_value"
+	codeRateLimited             errorCode = "rate_limited"
+	codeTooManyConcurrent       errorCode = "too_many_concurrent_requests"
+	codeQuotaExceeded           errorCode = "quota_exceeded"
+	codeInvalidActivationToken  errorCode = "invalid_activation_token"
+	codeInvalidResetToken       errorCode = "invalid_password_reset_token"
+	codeInvalidCredentials      errorCode = "invalid_credentials"
+	codeInvalidTokenSignature   errorCode = "invalid_token_signature"
+	codeTokenExpired            errorCode = "token_expired"
+	codeTokenNotValidYet        errorCode = "token_not_valid_yet"
+	codeInvalidTokenIssuer      errorCode = "invalid_token_issuer"
+	codeInvalidTokenAudience    errorCode = "invalid_token_audience"
+	codeAuthenticationRequired  errorCode = "authentication_required"
+	codeInvalidWebhookSignature errorCode = "invalid_webhook_signature"
+	codeInvalidShareLink        errorCode = "invalid_share_link"
+	codeChallengeRequired       errorCode = "challenge_required"
+	codeRegistrationClosed      errorCode = "registration_closed"
+	codeInvitationRequired      errorCode = "invitation_required"
+	codeInvalidCSRFToken        errorCode = "invalid_csrf_token"
+	codeAccountSuspended        errorCode = "account_suspended"
+	codeAccountBanned           errorCode = "account_banned"
+	codePasswordResetRequired   errorCode = "password_reset_required"
+	codeInactiveUser            errorCode = "inactive_user"
+	codeNotPermitted            errorCode = "not_permitted"
+	codeMaintenance             errorCode = "maintenance"
+	codeSitemapNotReady         errorCode = "sitemap_not_ready"
+	codeUnsupportedVersion      errorCode = "unsupported_version"
+	codeTLSRequired             errorCode = "tls_required"
+	codeIPBlocked               errorCode = "ip_blocked"
+	codeResponseSchemaMismatch  errorCode = "response_schema_mismatch"
+)
+
+// errorCodes is the registry of the error codes along with the status code of their responses
+var errorCodes = map[errorCode]int{
+	codeServerError:             http.StatusInternalServerError,
+	codeNotFound:                http.StatusNotFound,
+	codeBadRequest:              http.StatusBadRequest,
+	codeRequestTooLarge:         http.StatusRequestEntityTooLarge,
+	codeMethodNotAllowed:        http.StatusMethodNotAllowed,
+	codeFailedValidation:        http.StatusUnprocessableEntity,
+	codeEditConflict:            http.StatusConflict,
+	codeDuplicateRecord:         http.StatusConflict,
+	codeInvalidReference:        http.StatusUnprocessableEntity,
+	codeInvalidValue:            http.StatusUnprocessableEntity,
+	codeRateLimited:             http.StatusTooManyRequests,
+	codeTooManyConcurrent:       http.StatusTooManyRequests,
+	codeQuotaExceeded:           http.StatusTooManyRequests,
+	codeInvalidActivationToken:  http.StatusUnauthorized,
+	codeInvalidResetToken:       http.StatusUnauthorized,
+	codeInvalidCredentials:      http.StatusUnauthorized,
+	codeInvalidTokenSignature:   http.StatusUnauthorized,
+	codeTokenExpired:            http.StatusUnauthorized,
+	codeTokenNotValidYet:        http.StatusUnauthorized,
+	codeInvalidTokenIssuer:      http.StatusUnauthorized,
+	codeInvalidTokenAudience:    http.StatusUnauthorized,
+	codeAuthenticationRequired:  http.StatusUnauthorized,
+	codeInvalidWebhookSignature: http.StatusUnauthorized,
+	codeInvalidShareLink:        http.StatusForbidden,
+	codeChallengeRequired:       http.StatusForbidden,
+	codeRegistrationClosed:      http.StatusForbidden,
+	codeInvitationRequired:      http.StatusForbidden,
+	codeInvalidCSRFToken:        http.StatusForbidden,
+	codeAccountSuspended:        http.StatusForbidden,
+	codeAccountBanned:           http.StatusForbidden,
+	codePasswordResetRequired:   http.StatusForbidden,
+	codeInactiveUser:            http.StatusForbidden,
+	codeNotPermitted:            http.StatusForbidden,
+	codeIPBlocked:               http.StatusForbidden,
+	codeMaintenance:             http.StatusServiceUnavailable,
+	codeSitemapNotReady:         http.StatusServiceUnavailable,
+	codeUnsupportedVersion:      http.StatusNotAcceptable,
+	codeTLSRequired:             http.StatusForbidden,
+	codeResponseSchemaMismatch:  http.StatusInternalServerError,
+}
+
+// status returns the status code of the responses of the error code
+func (c errorCode) status() int {
+	status, ok := errorCodes[c]
+	if !ok {
+		return http.StatusInternalServerError
+	}
+	return status
+}
+
+// documentationURL returns the link to the documentation of the code. It's empty when ErrorDocsURL isn't set.
+func (c errorCode) documentationURL() string {
+	if ErrorDocsURL == "" {
+		return ""
+	}
+	return strings.TrimSuffix(ErrorDocsURL, "#") + "#" + string(c)
+}
@@ -0,0 +1,30 @@
+package api
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorCodesDocumented(t *testing.T) {
+	docs, err := os.ReadFile("../../docs/errors.md")
+	assert.NoError(t, err)
+
+	for code := range errorCodes {
+		t.Run(string(code), func(t *testing.T) {
+			assert.True(t, strings.Contains(string(docs), "\n### "+string(code)+"\n"), "code isn't documented in docs/errors.md")
+		})
+	}
+}
+
+func TestErrorCodeDocumentationURL(t *testing.T) {
+	defer func(docsURL string) { ErrorDocsURL = docsURL }(ErrorDocsURL)
+
+	ErrorDocsURL = ""
+	assert.Equal(t, "", codeEditConflict.documentationURL())
+
+	ErrorDocsURL = "https://example.com/errors"
+	assert.Equal(t, "https://example.com/errors#edit_conflict", codeEditConflict.documentationURL())
+}
@@ -1,96 +1,360 @@
 package api
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cybrarymin/greenlight/internal/data"
+	"github.com/golang-jwt/jwt/v5"
+	pkgerrors "github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Envelope map[string]interface{}
 
 // logError is the method we use to log the errors happens on the server side for the application.
-func (app *application) logError(err error) {
-	app.log.Error().Err(err).Send()
+func (app *application) logError(r *http.Request, err error) {
+	app.events(r.Context()).Error(err, "", nil)
+}
+
+// logServerError logs the error of a 500 response along with its stack and the request, and records it on the span
+// of the request as an exception so the log entry and the trace can be found from each other.
+func (app *application) logServerError(r *http.Request, err error) {
+	stack := errorStack(err)
+	span := trace.SpanFromContext(r.Context())
+	span.RecordError(err, trace.WithAttributes(attribute.String("exception.stacktrace", stack)))
+	span.SetStatus(codes.Error, "server error")
+	app.events(r.Context()).Error(err, "server error", logFields{
+		"method": r.Method,
+		"route":  routeLabel(r.URL.Path),
+		"stack":  stack,
+	})
+}
+
+// stackTracer is implemented by the errors of github.com/pkg/errors
+type stackTracer interface {
+	StackTrace() pkgerrors.StackTrace
+}
+
+// errorStack returns the stack of the error where it was created when it's a github.com/pkg/errors one,
+// otherwise the stack of the call to serverErrorResponse
+func errorStack(err error) string {
+	var st stackTracer
+	if errors.As(err, &st) {
+		return strings.TrimPrefix(fmt.Sprintf("%+v", st.StackTrace()), "\n")
+	}
+	pcs := make([]uintptr, 32)
+	// skips runtime.Callers, errorStack, logServerError and serverErrorResponse
+	n := runtime.Callers(4, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	var b strings.Builder
+	for {
+		frame, more := frames.Next()
+		fmt.Fprintf(&b, "%s\n\t%s:%d\n", frame.Function, frame.File, frame.Line)
+		if !more {
+			break
+		}
+	}
+	return b.String()
 }
 
-// errorResponse is the method we use to send a json formatted error to the client in case of any error
-func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, status int, message interface{}) {
+// errorResponse is the method we use to send a json formatted error to the client in case of any error.
+// The status of the response is the one registered for the code in errorCodes.
+func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, code errorCode, message interface{}) {
+	app.errorResponseWithLinks(w, r, code, message, nil)
+}
+
+// errorResponseWithLinks sends the error along with the _links block pointing the client to the endpoints resolving it
+func (app *application) errorResponseWithLinks(w http.ResponseWriter, r *http.Request, code errorCode, message interface{}, links envelope) {
 	e := envelope{
 		"error": message,
+		"code":  code,
+	}
+	if docsURL := code.documentationURL(); docsURL != "" {
+		e["documentation_url"] = docsURL
 	}
-	err := app.writeJson(w, status, e, nil)
+	if links != nil {
+		e["_links"] = links
+	}
+	err := app.writeJson(w, r, code.status(), e, nil)
 
 	if err != nil {
-		app.logError(err)
+		app.logError(r, err)
 		w.WriteHeader(http.StatusInternalServerError)
 	}
 }
 
 // serverErrorResponse uses the two other methods to log the details of the error and send internal server error to the client
 func (app *application) serverErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
-	app.logError(err)
+	// there is no one left to respond to when the client hung up and cancelled the request
+	if clientGone(r, err) {
+		app.events(r.Context()).Debug("client closed the request before it was processed", logFields{"error": err})
+		w.WriteHeader(statusClientClosedRequest)
+		return
+	}
+	app.logServerError(r, err)
 	message := "the server encountered an error to process the request"
-	app.errorResponse(w, r, http.StatusInternalServerError, message)
+	app.errorResponse(w, r, codeServerError, message)
 }
 
 // notFoundResponse method will be used to send notFound 404 status error json response to the client
 func (app *application) notFoundResponse(w http.ResponseWriter, r *http.Request) {
 	message := "the requested resource couldn't be found"
-	app.errorResponse(w, r, http.StatusNotFound, message)
+	app.errorResponse(w, r, codeNotFound, message)
 }
 
+// invalidParamResponse sends 422 with the offending field for malformed path parameters and 404 for everything else
+func (app *application) invalidParamResponse(w http.ResponseWriter, r *http.Request, err error) {
+	var paramErr *invalidParamError
+	switch {
+	case errors.As(err, &paramErr):
+		v := data.NewValidator()
+		v.AddError(paramErr.Key, paramErr.Message)
+		app.failedValidationResponse(w, r, v)
+	default:
+		app.notFoundResponse(w, r)
+	}
+}
+
+// badRequestResponse sends 413 instead when the request body exceeded its size limit. check limitRequestBody
 func (app *application) badRequestResponse(w http.ResponseWriter, r *http.Request, err error) {
-	app.errorResponse(w, r, http.StatusBadRequest, err.Error())
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		app.requestTooLargeResponse(w, r, maxBytesErr.Limit)
+		return
+	}
+	app.errorResponse(w, r, codeBadRequest, err.Error())
+}
+
+// requestTooLargeResponse carries a machine readable code and the limit so the clients can tell how much they can send
+func (app *application) requestTooLargeResponse(w http.ResponseWriter, r *http.Request, limit int64) {
+	message := map[string]interface{}{
+		"code":    codeRequestTooLarge,
+		"message": fmt.Sprintf("request body must not be larger than %d bytes", limit),
+		"limit":   limit,
+	}
+	app.errorResponse(w, r, codeRequestTooLarge, message)
 }
 
 // methodNotAllowed method will be used to send notFound 404 status error json response to the client
 func (app *application) methodNotAllowedResponse(w http.ResponseWriter, r *http.Request) {
 	message := fmt.Sprintf("the %s method is not supported for this resource", r.Method)
-	app.errorResponse(w, r, http.StatusMethodNotAllowed, message)
+	app.errorResponse(w, r, codeMethodNotAllowed, message)
 }
 
-func (app *application) failedValidationResponse(w http.ResponseWriter, r *http.Request, errors map[string]string) {
-	app.errorResponse(w, r, http.StatusUnprocessableEntity, errors)
+// failedValidationResponse sends the messages of the invalid fields. v1 is frozen with the messages only,
+// the later versions send the code of each error along with its message.
+func (app *application) failedValidationResponse(w http.ResponseWriter, r *http.Request, v *data.Validator) {
+	if app.GetAPIVersionContext(r) >= apiV2 {
+		app.errorResponse(w, r, codeFailedValidation, v.FieldErrors())
+		return
+	}
+	app.errorResponse(w, r, codeFailedValidation, v.Errors)
 }
 
 func (app *application) editConflictResponse(w http.ResponseWriter, r *http.Request) {
 	message := "unable to update the record due to an edit conflict, please try again"
-	app.errorResponse(w, r, http.StatusConflict, message)
+	app.errorResponse(w, r, codeEditConflict, message)
+}
+
+// dbWriteErrorResponse answers the database errors of a write which the validation can't catch beforehand,
+// like a concurrent insert of the same record or a reference to a record deleted in the meantime, instead of a generic 500.
+func (app *application) dbWriteErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, data.ErrUniqueViolation):
+		app.errorResponse(w, r, codeDuplicateRecord, "the record conflicts with an existing record")
+	case errors.Is(err, data.ErrSerializationFailure):
+		app.editConflictResponse(w, r)
+	case errors.Is(err, data.ErrForeignKeyViolation):
+		app.errorResponse(w, r, codeInvalidReference, "the record references a record which doesn't exist")
+	case errors.Is(err, data.ErrCheckViolation):
+		app.errorResponse(w, r, codeInvalidValue, "the record contains a value which isn't allowed")
+	default:
+		app.serverErrorResponse(w, r, err)
+	}
 }
 
 func (app *application) rateLimitExceedResponse(w http.ResponseWriter, r *http.Request) {
 	message := "request rate limit reached, please try again later"
-	app.errorResponse(w, r, http.StatusTooManyRequests, message)
+	app.errorResponse(w, r, codeRateLimited, message)
+}
+
+func (app *application) tooManyConcurrentResponse(w http.ResponseWriter, r *http.Request) {
+	message := "too many concurrent requests, wait for the previous ones to finish"
+	app.errorResponse(w, r, codeTooManyConcurrent, message)
+}
+
+// quotaExceededResponse carries a machine readable code so the clients can tell the monthly quota apart from the rate limit
+func (app *application) quotaExceededResponse(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())))
+	message := map[string]interface{}{
+		"code":    codeQuotaExceeded,
+		"message": "monthly request quota exceeded, please try again next month",
+	}
+	app.errorResponse(w, r, codeQuotaExceeded, message)
 }
 
 func (app *application) invalidActivationTokenResponse(w http.ResponseWriter, r *http.Request) {
 	message := "invalid or expired activation token"
-	app.errorResponse(w, r, http.StatusUnauthorized, message)
+	app.errorResponse(w, r, codeInvalidActivationToken, message)
+}
+
+func (app *application) invalidResetTokenResponse(w http.ResponseWriter, r *http.Request) {
+	message := "invalid, expired or already used password reset token"
+	app.errorResponse(w, r, codeInvalidResetToken, message)
 }
 
 func (app *application) invalidAuthenticationCredResponse(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("WWW-Authenticate", "Bearer Jwt")
 	message := "invalid authentication creds or token"
-	app.errorResponse(w, r, http.StatusUnauthorized, message)
+	app.errorResponseWithLinks(w, r, codeInvalidCredentials, message, app.authenticationLinks(r))
 }
 
-func (app *application) invalidJWTTokenSignatureResponse(w http.ResponseWriter, r *http.Request) {
+// invalidJWTResponse responds with the code of the check the jwt has failed, so the clients can tell an expired token
+// apart from a token issued for another service
+func (app *application) invalidJWTResponse(w http.ResponseWriter, r *http.Request, err error) {
 	w.Header().Set("WWW-Authenticate", "Bearer Jwt")
-	message := "invalid jwt token signature."
-	app.errorResponse(w, r, http.StatusUnauthorized, message)
+	switch {
+	case errors.Is(err, jwt.ErrTokenSignatureInvalid):
+		app.errorResponse(w, r, codeInvalidTokenSignature, "invalid jwt token signature.")
+	case errors.Is(err, jwt.ErrTokenExpired):
+		app.errorResponse(w, r, codeTokenExpired, "the jwt has expired")
+	case errors.Is(err, jwt.ErrTokenNotValidYet), errors.Is(err, jwt.ErrTokenUsedBeforeIssued):
+		app.errorResponse(w, r, codeTokenNotValidYet, "the jwt isn't valid yet")
+	case errors.Is(err, jwt.ErrTokenInvalidIssuer):
+		app.errorResponse(w, r, codeInvalidTokenIssuer, "the jwt is issued by an unknown issuer")
+	case errors.Is(err, jwt.ErrTokenInvalidAudience):
+		app.errorResponse(w, r, codeInvalidTokenAudience, "the jwt isn't issued for this audience")
+	default:
+		app.errorResponse(w, r, codeInvalidCredentials, "invalid authentication creds or token")
+	}
 }
 
+// authenticationRequiredResposne is sent to the anonymous users on the routes outside of the anonymous tier. check routeInfo
 func (app *application) authenticationRequiredResposne(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("WWW-Authenticate", "Bearer Jwt")
 	message := "authentication required"
-	app.errorResponse(w, r, http.StatusUnauthorized, message)
+	app.errorResponseWithLinks(w, r, codeAuthenticationRequired, message, app.authenticationLinks(r))
+}
+
+// authenticationLinks points the client to the endpoints it gets a credential from, so the first request of a developer
+// without a token tells them where to sign up. The registration is left out when it's closed.
+func (app *application) authenticationLinks(r *http.Request) envelope {
+	prefix := fmt.Sprintf("/v%d", app.GetAPIVersionContext(r))
+	links := envelope{
+		"token": envelope{"href": prefix + "/tokens", "method": http.MethodPost},
+	}
+	if RegistrationMode != RegistrationClosed {
+		links["register"] = envelope{"href": prefix + "/users", "method": http.MethodPost}
+	}
+	return links
+}
+
+func (app *application) metricsAuthenticationRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	if MetricsBasicAuthUsername != "" {
+		w.Header().Set("WWW-Authenticate", `Basic realm="metrics", charset="UTF-8"`)
+	} else {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+	}
+	message := "authentication required"
+	app.errorResponse(w, r, codeAuthenticationRequired, message)
+}
+
+func (app *application) invalidWebhookSignatureResponse(w http.ResponseWriter, r *http.Request) {
+	message := "invalid webhook signature"
+	app.errorResponse(w, r, codeInvalidWebhookSignature, message)
+}
+
+func (app *application) invalidShareLinkResponse(w http.ResponseWriter, r *http.Request) {
+	message := "invalid, expired or revoked share link"
+	app.errorResponse(w, r, codeInvalidShareLink, message)
+}
+
+func (app *application) challengeRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	message := "a valid captcha or proof of work solution is required to access this resource"
+	app.errorResponse(w, r, codeChallengeRequired, message)
+}
+
+func (app *application) registrationClosedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "self registration is disabled"
+	app.errorResponse(w, r, codeRegistrationClosed, message)
+}
+
+func (app *application) invalidInvitationResponse(w http.ResponseWriter, r *http.Request) {
+	message := "a valid invitation for the email is required to register"
+	app.errorResponse(w, r, codeInvitationRequired, message)
+}
+
+func (app *application) invalidCSRFTokenResponse(w http.ResponseWriter, r *http.Request) {
+	message := map[string]interface{}{
+		"code":    codeInvalidCSRFToken,
+		"message": "a valid csrf token is required in X-CSRF-Token header, get one from /v1/csrf",
+	}
+	app.errorResponse(w, r, codeInvalidCSRFToken, message)
+}
+
+// accountSuspendedResponse carries a machine readable code and the reason so the clients can tell the user why they can't sign in
+func (app *application) accountSuspendedResponse(w http.ResponseWriter, r *http.Request, user *data.User) {
+	code := codeAccountSuspended
+	message := map[string]interface{}{
+		"message": "your user account is suspended",
+		"reason":  user.SuspensionReason,
+	}
+	if user.Banned {
+		code = codeAccountBanned
+		message["message"] = "your user account is banned"
+	} else {
+		message["suspended_until"] = user.SuspendedUntil
+	}
+	message["code"] = code
+	app.errorResponse(w, r, code, message)
+}
+
+func (app *application) passwordResetRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your password must be reset by the link emailed to you"
+	app.errorResponse(w, r, codePasswordResetRequired, message)
+}
+
+func (app *application) ipBlockedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "access from your address isn't allowed"
+	app.errorResponse(w, r, codeIPBlocked, message)
 }
 
 func (app *application) unauthorizedAccessInactiveUserResponse(w http.ResponseWriter, r *http.Request) {
 	message := "user must be activated to access this resource"
-	app.errorResponse(w, r, http.StatusForbidden, message)
+	app.errorResponse(w, r, codeInactiveUser, message)
 }
 
 func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Request) {
 	message := "your user account doesn't have the necessary permissions to access this resource"
-	app.errorResponse(w, r, http.StatusForbidden, message)
+	app.errorResponse(w, r, codeNotPermitted, message)
+}
+
+func (app *application) maintenanceModeResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Retry-After", "300")
+	message := "the server is under maintenance, please try again later"
+	app.errorResponse(w, r, codeMaintenance, message)
+}
+
+func (app *application) sitemapNotReadyResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Retry-After", "30")
+	message := "the sitemap is being generated, please try again later"
+	app.errorResponse(w, r, codeSitemapNotReady, message)
+}
+
+func (app *application) notAcceptableVersionResponse(w http.ResponseWriter, r *http.Request) {
+	message := fmt.Sprintf("unsupported api version in the Accept header, supported versions are %d to %d", apiV1, latestEnabledVersion())
+	app.errorResponse(w, r, codeUnsupportedVersion, message)
+}
+
+func (app *application) tlsRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the server only accepts requests over https"
+	app.errorResponse(w, r, codeTLSRequired, message)
 }
@@ -0,0 +1,103 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	pkgerrors "github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func failingQuery() error {
+	return pkgerrors.New("connection reset")
+}
+
+func TestServerErrorResponseLogsStack(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		wantFrame string
+	}{
+		// the stack of the pkg/errors errors is where they were created
+		{name: "Stack of the error", err: failingQuery(), wantFrame: "api.failingQuery"},
+		{name: "Stack of the response", err: errors.New("connection reset"), wantFrame: "api.TestServerErrorResponseLogsStack"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			logger := zerolog.New(&buf)
+			app := &application{log: &logger}
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/v1/movies/7", nil)
+
+			app.serverErrorResponse(w, r, tc.err)
+
+			assert.Equal(t, http.StatusInternalServerError, w.Code)
+			var entry map[string]string
+			require.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+			assert.Equal(t, "server error", entry["message"])
+			assert.Equal(t, "/v1/movies/:id", entry["route"])
+			assert.Contains(t, entry["stack"], tc.wantFrame)
+		})
+	}
+}
+
+func TestAuthenticationRequiredLinks(t *testing.T) {
+	defer func(registration string) { RegistrationMode = registration }(RegistrationMode)
+
+	tests := []struct {
+		name         string
+		registration string
+		version      int
+		want         map[string]any
+	}{
+		{
+			name: "Open registration", registration: RegistrationOpen, version: apiV1,
+			want: map[string]any{
+				"token":    map[string]any{"href": "/v1/tokens", "method": "POST"},
+				"register": map[string]any{"href": "/v1/users", "method": "POST"},
+			},
+		},
+		{
+			name: "Closed registration", registration: RegistrationClosed, version: apiV1,
+			want: map[string]any{
+				"token": map[string]any{"href": "/v1/tokens", "method": "POST"},
+			},
+		},
+		{
+			name: "Version of the request", registration: RegistrationInvite, version: apiV2,
+			want: map[string]any{
+				"token":    map[string]any{"href": "/v2/tokens", "method": "POST"},
+				"register": map[string]any{"href": "/v2/users", "method": "POST"},
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			RegistrationMode = tc.registration
+			logger := zerolog.Nop()
+			app := &application{log: &logger}
+			w := httptest.NewRecorder()
+			r := app.SetAPIVersionContext(httptest.NewRequest(http.MethodGet, "/v1/movies", nil), tc.version)
+
+			app.authenticationRequiredResposne(w, r)
+
+			assert.Equal(t, http.StatusUnauthorized, w.Code)
+			var body struct {
+				Code  errorCode      `json:"code"`
+				Links map[string]any `json:"_links"`
+			}
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+			assert.Equal(t, codeAuthenticationRequired, body.Code)
+			assert.Equal(t, tc.want, body.Links)
+		})
+	}
+}
@@ -0,0 +1,214 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/cybrarymin/greenlight/internal/data"
+	"go.opentelemetry.io/otel/codes"
+)
+
+const (
+	feedRSS  = "feed.rss"
+	feedAtom = "feed.atom"
+	// feedMaxAge is how long the feed readers and proxies may cache the feed
+	feedMaxAge = 5 * time.Minute
+)
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string   `xml:"title"`
+	Link        string   `xml:"link"`
+	GUID        string   `xml:"guid"`
+	PubDate     string   `xml:"pubDate"`
+	Description string   `xml:"description"`
+	Categories  []string `xml:"category"`
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+}
+
+type atomEntry struct {
+	Title      string         `xml:"title"`
+	ID         string         `xml:"id"`
+	Link       atomLink       `xml:"link"`
+	Published  string         `xml:"published"`
+	Updated    string         `xml:"updated"`
+	Summary    string         `xml:"summary"`
+	Categories []atomCategory `xml:"category"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+// requestBaseURL returns the scheme and host the request has been sent to
+func requestBaseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// feedMovieLink links the feed entries to the public website when it's configured, otherwise to the movie api
+func feedMovieLink(r *http.Request, movieID int64) string {
+	if PublicBaseURL != "" {
+		return movieDeepLink(movieID)
+	}
+	return fmt.Sprintf("%s/v1/movies/%d", requestBaseURL(r), movieID)
+}
+
+func feedSummary(movie *data.Movie) string {
+	return fmt.Sprintf("%s (%d), %d mins", movie.Title, movie.Year, movie.Runtime)
+}
+
+// movieFeedHandler renders the most recently added movies as an rss or atom feed, optionally of a single genre
+func (app *application) movieFeedHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := app.startSpan(r, "movieFeed")
+	defer span.End()
+
+	format := path.Base(r.URL.Path)
+	qs := r.URL.Query()
+	nValidator := data.NewValidator()
+	genre := app.readString(qs, "genre", "")
+	limit := app.readInt(qs, "limit", 20, nValidator)
+	nValidator.Check(limit > 0 && limit <= 100, "limit", "must be between 1 and 100")
+	if !nValidator.Valid() {
+		span.RecordError(errors.New(createKeyValuePairs(nValidator.Errors)))
+		span.SetStatus(codes.Error, otelunprocessableErr)
+		app.failedValidationResponse(w, r, nValidator)
+		return
+	}
+
+	movies, err := app.models.Movies.Recent(ctx, genre, limit)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, otelDBErr)
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	title := "Greenlight - recently added movies"
+	if genre != "" {
+		title = fmt.Sprintf("Greenlight - recently added %s movies", genre)
+	}
+	selfURL := requestBaseURL(r) + r.URL.RequestURI()
+	// the feed changes only when a movie is added or removed, so the newest movie dates the whole feed
+	var updated time.Time
+	if len(movies) > 0 {
+		updated = movies[0].CreatedAt
+	}
+
+	var feed interface{}
+	contentType := "application/rss+xml; charset=utf-8"
+	switch format {
+	case feedRSS:
+		channel := rssChannel{
+			Title:       title,
+			Link:        selfURL,
+			Description: "The most recently added movies of the catalog",
+			Items:       make([]rssItem, 0, len(movies)),
+		}
+		if !updated.IsZero() {
+			channel.LastBuildDate = updated.UTC().Format(time.RFC1123Z)
+		}
+		for i := range movies {
+			link := feedMovieLink(r, movies[i].ID)
+			channel.Items = append(channel.Items, rssItem{
+				Title:       movies[i].Title,
+				Link:        link,
+				GUID:        link,
+				PubDate:     movies[i].CreatedAt.UTC().Format(time.RFC1123Z),
+				Description: feedSummary(&movies[i]),
+				Categories:  movies[i].Genres,
+			})
+		}
+		feed = rssFeed{Version: "2.0", Channel: channel}
+	case feedAtom:
+		contentType = "application/atom+xml; charset=utf-8"
+		atom := atomFeed{
+			Title:   title,
+			ID:      selfURL,
+			Updated: updated.UTC().Format(time.RFC3339),
+			Links:   []atomLink{{Href: selfURL, Rel: "self"}},
+			Entries: make([]atomEntry, 0, len(movies)),
+		}
+		for i := range movies {
+			link := feedMovieLink(r, movies[i].ID)
+			categories := make([]atomCategory, 0, len(movies[i].Genres))
+			for _, g := range movies[i].Genres {
+				categories = append(categories, atomCategory{Term: g})
+			}
+			created := movies[i].CreatedAt.UTC().Format(time.RFC3339)
+			atom.Entries = append(atom.Entries, atomEntry{
+				Title:      movies[i].Title,
+				ID:         link,
+				Link:       atomLink{Href: link},
+				Published:  created,
+				Updated:    created,
+				Summary:    feedSummary(&movies[i]),
+				Categories: categories,
+			})
+		}
+		feed = atom
+	}
+
+	nBuffer := bytes.Buffer{}
+	nBuffer.WriteString(xml.Header)
+	err = xml.NewEncoder(&nBuffer).Encode(feed)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, otelunprocessableErr)
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	sum := sha256.Sum256(nBuffer.Bytes())
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(feedMaxAge.Seconds())))
+	w.Header().Set("ETag", etag)
+	if !updated.IsZero() {
+		w.Header().Set("Last-Modified", updated.UTC().Format(http.TimeFormat))
+	}
+	// readers poll the feeds, so unchanged feeds are answered without the body
+	if match := r.Header.Get("If-None-Match"); match != "" && strings.Contains(match, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(nBuffer.Bytes())
+}
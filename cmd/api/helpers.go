@@ -5,16 +5,17 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 
 	"github.com/cybrarymin/greenlight/internal/data"
 	"github.com/google/uuid"
-	"github.com/julienschmidt/httprouter"
 )
 
 type envelope map[string]interface{}
@@ -34,25 +35,6 @@ func (app *application) BackgroundJob(nfunc func(), PanicErrMsg string) {
 	}()
 }
 
-func (app *application) readIDParam(r *http.Request) (int64, error) {
-	params := httprouter.ParamsFromContext(r.Context())
-	id, err := strconv.ParseInt(params.ByName("id"), 10, 64)
-	if err != nil || id < 1 {
-		return 0, err
-	}
-	return id, nil
-}
-
-func (app *application) readUUIDParam(r *http.Request) (uuid.UUID, error) {
-	params := httprouter.ParamsFromContext(r.Context())
-	uuidParam := params.ByName("id")
-	cuuid, err := uuid.Parse(uuidParam)
-	if err != nil {
-		return uuid.Nil, err
-	}
-	return cuuid, nil
-}
-
 // readString function reads the query strings then extracts the the value of the specified key.
 // If the key doesn't exist it will return default value
 func (app *application) readString(qs url.Values, key string, defaultValue string) string {
@@ -90,26 +72,135 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 	return num
 }
 
-func (app *application) writeJson(w http.ResponseWriter, status int, data envelope, headers http.Header) error {
-	nBuffer := bytes.Buffer{}
-	err := json.NewEncoder(&nBuffer).Encode(data)
+// The readIntRange() helper works like readInt() and also checks the value is between min and max inclusive.
+// The default value isn't checked, so it can be outside the range to tell the key doesn't exist.
+func (app *application) readIntRange(qs url.Values, key string, defaultValue int, min int, max int, v *data.Validator) int {
+	numString := qs.Get(key)
+	if numString == "" {
+		return defaultValue
+	}
+	num, err := strconv.Atoi(numString)
 	if err != nil {
-		return err
+		v.AddError(key, "must be an integer type")
+		return defaultValue
 	}
-	for key, value := range headers {
-		w.Header()[key] = value
+	if fieldErr := data.Between(num, min, max)(); fieldErr != nil {
+		v.AddErrorCode(key, fieldErr.Code, fieldErr.Message)
+		return defaultValue
 	}
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(status)
-	w.Write(nBuffer.Bytes())
+	return num
+}
 
-	return nil
+// The readFloatRange() helper works like readIntRange() for the decimal numbers
+func (app *application) readFloatRange(qs url.Values, key string, defaultValue float64, min float64, max float64, v *data.Validator) float64 {
+	numString := qs.Get(key)
+	if numString == "" {
+		return defaultValue
+	}
+	num, err := strconv.ParseFloat(numString, 64)
+	if err != nil || math.IsNaN(num) {
+		v.AddError(key, "must be a number")
+		return defaultValue
+	}
+	if num < min || num > max {
+		v.AddErrorCode(key, data.CodeOutOfRange, fmt.Sprintf("must be between %g and %g", min, max))
+		return defaultValue
+	}
+	return num
+}
+
+// The readEnum() helper works like readString() and also checks the value is one of the allowed values.
+func (app *application) readEnum(qs url.Values, key string, defaultValue string, allowed []string, v *data.Validator) string {
+	value := qs.Get(key)
+	if value == "" {
+		return defaultValue
+	}
+	if fieldErr := data.OneOf(value, allowed...)(); fieldErr != nil {
+		v.AddErrorCode(key, fieldErr.Code, fieldErr.Message)
+		return defaultValue
+	}
+	return value
+}
+
+// The readUUIDQuery() helper works like readInt() for the uuids. uuid.Nil is returned when the key doesn't exist.
+func (app *application) readUUIDQuery(qs url.Values, key string, v *data.Validator) uuid.UUID {
+	idString := qs.Get(key)
+	if idString == "" {
+		return uuid.Nil
+	}
+	id, err := uuid.Parse(idString)
+	if err != nil {
+		v.AddError(key, "must be a valid uuid")
+		return uuid.Nil
+	}
+	return id
+}
+
+// The readDate() helper works like readInt() for the dates like 2024-01-02. The zero time is returned when the key doesn't exist.
+func (app *application) readDate(qs url.Values, key string, v *data.Validator) time.Time {
+	dateString := qs.Get(key)
+	if dateString == "" {
+		return time.Time{}
+	}
+	t, err := time.Parse(time.DateOnly, dateString)
+	if err != nil {
+		v.AddError(key, "must be a date like 2024-01-02")
+		return time.Time{}
+	}
+	return t
+}
+
+// The readTime() helper works like readInt() for the RFC 3339 timestamps. The zero time is returned when the key doesn't exist.
+func (app *application) readTime(qs url.Values, key string, v *data.Validator) time.Time {
+	timeString := qs.Get(key)
+	if timeString == "" {
+		return time.Time{}
+	}
+	t, err := time.Parse(time.RFC3339, timeString)
+	if err != nil {
+		v.AddError(key, "must be an RFC 3339 timestamp like 2024-01-02T15:04:05Z")
+		return time.Time{}
+	}
+	return t
+}
+
+// The readBool() helper works like readInt() for the boolean query string values.
+func (app *application) readBool(qs url.Values, key string, defaultValue bool, v *data.Validator) bool {
+	boolString := qs.Get(key)
+	if boolString == "" {
+		return defaultValue
+	}
+	b, err := strconv.ParseBool(boolString)
+	if err != nil {
+		v.AddError(key, "must be a boolean type")
+		return defaultValue
+	}
+	return b
+}
+
+func (app *application) writeJson(w http.ResponseWriter, r *http.Request, status int, data envelope, headers http.Header) error {
+	// the caches must tell the plain responses apart from the enveloped ones
+	w.Header().Add("Vary", "X-Plain-Response")
+	plain := plainResponse(r)
+	if meta := data.paginationMeta(); meta != nil {
+		headers = headers.Clone()
+		if headers == nil {
+			headers = http.Header{}
+		}
+		paginationHeaders(headers, r.URL, *meta, plain)
+	}
+	if plain {
+		return writeJSONBody(w, status, "application/json", headers, data.plainBody())
+	}
+	// v1 envelopes are frozen, the later versions share the data, metadata and error envelope
+	if app.GetAPIVersionContext(r) >= apiV2 {
+		data = data.v2Envelope()
+	}
+	return writeJSONBody(w, status, "application/json", headers, data)
 }
 
 func (app *application) readJson(w http.ResponseWriter, r *http.Request, dst interface{}) error {
-	// Limit the amount of bytes accepted as post request body
-	maxBytes := 1_048_576 // _ here is only for visual separator purpose and for int values go's compiler will ignore it.
-	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
+	// the size of the body is limited by limitRequestBody
 	dec := json.NewDecoder(r.Body)
 	// Initialize the json.Decoder, and call the DisallowUnknownFields() method on it
 	// before decoding. This means that if the JSON from the client now includes any
@@ -121,6 +212,7 @@ func (app *application) readJson(w http.ResponseWriter, r *http.Request, dst int
 		var syntaxError *json.SyntaxError
 		var unmarshalTypeError *json.UnmarshalTypeError
 		var invalidUnmarshalError *json.InvalidUnmarshalError
+		var maxBytesError *http.MaxBytesError
 		switch {
 		// This happens if we json syntax errors. having wrong commas or indentation or missing quotes
 		case errors.As(err, &syntaxError):
@@ -144,11 +236,10 @@ func (app *application) readJson(w http.ResponseWriter, r *http.Request, dst int
 			fieldName := strings.TrimPrefix(err.Error(), "json: unknown field")
 			return fmt.Errorf("body contains unknown field %s", fieldName)
 
-		// If the request body exceeds 1MB in size the decode will now fail with the
-		// error "http: request body too large". There is an open issue about turning
-		// this into a distinct error type at https://github.com/golang/go/issues/30715.
-		case err.Error() == "http: request body too large":
-			return fmt.Errorf("body must not be larger than %d bytes", maxBytes)
+		// If the request body exceeds its size limit the decode will fail with http.MaxBytesError.
+		// It's returned as is so badRequestResponse responds with 413.
+		case errors.As(err, &maxBytesError):
+			return err
 
 		// Error will happen if we pass invalid type to json.Decode function. we should always pass a pointer otherwise it will give us error
 		case errors.As(err, &invalidUnmarshalError):
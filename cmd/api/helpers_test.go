@@ -0,0 +1,94 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cybrarymin/greenlight/internal/data"
+)
+
+func BenchmarkReadJson(b *testing.B) {
+	app := &application{}
+	body := []byte(`{"title":"the last kingdom","year":2015,"runtime":"115 mins","genres":["drama","history","war"]}`)
+	var input struct {
+		Title   string
+		Year    int32
+		Runtime data.Runtime
+		Genres  []string
+	}
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		r := httptest.NewRequest(http.MethodPost, "/v1/movies", bytes.NewReader(body))
+		if err := app.readJson(nil, r, &input); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkWriteJson(b *testing.B) {
+	app := &application{}
+	movie := benchmarkMovies(1)[0]
+
+	for _, version := range []int{apiV1, apiV2} {
+		b.Run(fmt.Sprintf("v%d", version), func(b *testing.B) {
+			r := httptest.NewRequest(http.MethodGet, "/movies/1", nil)
+			r = r.WithContext(context.WithValue(r.Context(), apiVersionContextKey, version))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if err := app.writeJson(httptest.NewRecorder(), r, http.StatusOK, envelope{"movie": movie}, nil); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkMovieListSerialization(b *testing.B) {
+	app := &application{}
+	r := httptest.NewRequest(http.MethodGet, "/v1/movies", nil)
+
+	for _, size := range []int{20, 100, 1000} {
+		movies := benchmarkMovies(size)
+		total, last := size*10, 10
+		meta := data.PaginationMeta{FirstPage: 1, LastPage: &last, TotalRecords: &total, PageSize: size, CurrentPage: 1}
+		b.Run(fmt.Sprintf("%d movies", size), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if err := app.writeJson(httptest.NewRecorder(), r, http.StatusOK, envelope{"Metadata": meta, "Movies": movies}, nil); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("%d public movies", size), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if err := app.writeJson(httptest.NewRecorder(), r, http.StatusOK, envelope{"Metadata": meta, "Movies": newPublicMovies(movies)}, nil); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func benchmarkMovies(n int) []data.Movie {
+	movies := make([]data.Movie, n)
+	for i := range movies {
+		movies[i] = data.Movie{
+			ID:        int64(i + 1),
+			CreatedAt: time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC),
+			Title:     fmt.Sprintf("the last kingdom %d", i),
+			Year:      2015,
+			Runtime:   115,
+			Genres:    []string{"drama", "history", "war"},
+			Version:   1,
+			UpdatedAt: time.Date(2024, time.January, 2, 0, 0, 0, 0, time.UTC),
+		}
+	}
+	return movies
+}
@@ -0,0 +1,149 @@
+package api
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// topics of the live events pushed to the admin dashboards
+const (
+	topicCounters = "counters"
+	topicMovies   = "movies"
+)
+
+var liveTopics = []string{topicCounters, topicMovies}
+
+const (
+	// subscriberBuffer is the number of events queued for a subscriber before its events are dropped
+	subscriberBuffer = 64
+	// maxDroppedEvents disconnects the subscribers which keep falling behind instead of queueing events for them forever
+	maxDroppedEvents = 256
+	// countersInterval is how often the live counters are pushed
+	countersInterval = 5 * time.Second
+)
+
+// liveEvent is a message pushed to the subscribers of its topic
+type liveEvent struct {
+	Topic string      `json:"topic"`
+	Type  string      `json:"type"`
+	Time  time.Time   `json:"time"`
+	Data  interface{} `json:"data"`
+}
+
+// subscriber receives the events of its topics on send. send is closed once the subscriber is removed from the hub.
+type subscriber struct {
+	topics  map[string]bool
+	send    chan liveEvent
+	dropped int
+}
+
+// eventHub fans the live events out to the subscribers of their topic. Publishing never blocks: events are dropped
+// for the subscribers whose buffer is full and the subscribers dropping too many events are disconnected.
+type eventHub struct {
+	mu          sync.Mutex
+	subscribers map[*subscriber]struct{}
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{subscribers: make(map[*subscriber]struct{})}
+}
+
+func (h *eventHub) subscribe(topics []string) *subscriber {
+	s := &subscriber{topics: make(map[string]bool), send: make(chan liveEvent, subscriberBuffer)}
+	for _, topic := range topics {
+		s.topics[topic] = true
+	}
+	h.mu.Lock()
+	h.subscribers[s] = struct{}{}
+	h.mu.Unlock()
+	return s
+}
+
+// setTopics replaces the topics of the subscriber
+func (h *eventHub) setTopics(s *subscriber, topics []string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s.topics = make(map[string]bool)
+	for _, topic := range topics {
+		s.topics[topic] = true
+	}
+}
+
+func (h *eventHub) unsubscribe(s *subscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.remove(s)
+}
+
+// remove must be called with mu held
+func (h *eventHub) remove(s *subscriber) {
+	if _, found := h.subscribers[s]; found {
+		delete(h.subscribers, s)
+		close(s.send)
+	}
+}
+
+func (h *eventHub) publish(topic string, eventType string, data interface{}) {
+	event := liveEvent{Topic: topic, Type: eventType, Time: time.Now().UTC(), Data: data}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for s := range h.subscribers {
+		if !s.topics[topic] {
+			continue
+		}
+		select {
+		case s.send <- event:
+			s.dropped = 0
+		default:
+			s.dropped++
+			if s.dropped >= maxDroppedEvents {
+				h.remove(s)
+			}
+		}
+	}
+}
+
+func (h *eventHub) hasSubscribers() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.subscribers) > 0
+}
+
+// liveCounters counts the activity between two pushes of the counters
+type liveCounters struct {
+	requests       atomic.Int64
+	registrations  atomic.Int64
+	catalogChanges atomic.Int64
+}
+
+// runLiveCounters pushes the request rate and the number of registrations and catalog changes of the last interval
+// to the subscribers of the counters. It runs for the lifetime of the server.
+func (app *application) runLiveCounters() {
+	ticker := time.NewTicker(countersInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		requests := app.counters.requests.Swap(0)
+		registrations := app.counters.registrations.Swap(0)
+		catalogChanges := app.counters.catalogChanges.Swap(0)
+		if !app.hub.hasSubscribers() {
+			continue
+		}
+		app.hub.publish(topicCounters, "counters", map[string]interface{}{
+			"interval_seconds":    countersInterval.Seconds(),
+			"requests_per_second": float64(requests) / countersInterval.Seconds(),
+			"registrations":       registrations,
+			"catalog_changes":     catalogChanges,
+		})
+	}
+}
+
+// movieChanged counts the change of the catalog, purges it from the cdn and pushes it to the subscribers of the movie events
+func (app *application) movieChanged(op string, movieID int64, title string) {
+	app.counters.catalogChanges.Add(1)
+	app.purgeMovie(movieID)
+	app.hub.publish(topicMovies, op, map[string]interface{}{
+		"movie_id": movieID,
+		"title":    title,
+	})
+}
@@ -0,0 +1,288 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/cybrarymin/greenlight/internal/data"
+	"github.com/felixge/httpsnoop"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+const (
+	impersonationPermission = "users:impersonate"
+	impersonationAudience   = "greenlight.example.com/impersonation"
+	impersonationTTL        = 15 * time.Minute
+	maxImpersonationTTL     = time.Hour
+)
+
+var ErrImpersonationNotAllowed = errors.New("impersonation isn't allowed")
+
+// impersonationClaims carries the impersonated user in sub and the real actor in the act claim (RFC 8693)
+type impersonationClaims struct {
+	Actor impersonationActor `json:"act"`
+	jwt.RegisteredClaims
+}
+
+type impersonationActor struct {
+	Subject string `json:"sub"`
+	Email   string `json:"email"`
+}
+
+func (c *impersonationClaims) Validate() error {
+	if c.Actor.Subject == "" || c.Actor.Subject == c.Subject {
+		return errors.New("invalid act claim on impersonation token")
+	}
+	return nil
+}
+
+// createImpersonationTokenHandler issues a short-lived token which authenticates the support staff as the user.
+// Users holding the admin or impersonation permission can't be impersonated and impersonation can't be chained.
+func (app *application) createImpersonationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := app.startSpan(r, "createImpersonationToken")
+	defer span.End()
+
+	actor := app.GetUserContext(r)
+	if app.GetImpersonationContext(r) != nil {
+		span.SetStatus(codes.Error, "impersonation can't be chained")
+		app.notPermittedResponse(w, r)
+		return
+	}
+	subjectID := app.uuidParam(r, "user_id")
+
+	var input struct {
+		TTL string `json:"ttl"`
+	}
+	// the body is optional
+	if r.ContentLength != 0 {
+		err := app.readJson(w, r, &input)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, otelunprocessableErr)
+			app.badRequestResponse(w, r, err)
+			return
+		}
+	}
+	ttl := impersonationTTL
+	nValidator := data.NewValidator()
+	if input.TTL != "" {
+		var err error
+		ttl, err = time.ParseDuration(input.TTL)
+		nValidator.Check(err == nil, "ttl", "must be a valid duration like 15m")
+	}
+	nValidator.Check(ttl > 0 && ttl <= maxImpersonationTTL, "ttl", "must be between 1s and 1h")
+	nValidator.Check(subjectID != actor.ID, "user_id", "can't impersonate yourself")
+	if !nValidator.Valid() {
+		span.RecordError(errors.New(createKeyValuePairs(nValidator.Errors)))
+		span.SetStatus(codes.Error, otelunprocessableErr)
+		app.failedValidationResponse(w, r, nValidator)
+		return
+	}
+
+	subject := &data.User{}
+	err := app.models.Users.GetByID(subjectID, ctx, subject)
+	if err != nil {
+		span.RecordError(err)
+		switch {
+		case errors.Is(err, data.ErrorRecordNotFound):
+			span.SetStatus(codes.Ok, otelDBNotFoundInfo)
+			app.notFoundResponse(w, r)
+		default:
+			span.SetStatus(codes.Error, otelDBErr)
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	perms, err := app.models.Permissions.GetAllPermsForUser(ctx, subject.ID)
+	if err != nil && !errors.Is(err, data.ErrorRecordNotFound) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, otelDBErr)
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if perms != nil && (perms.IncludesPrem("admin") || perms.IncludesPrem(impersonationPermission)) {
+		span.SetStatus(codes.Error, "privileged users can't be impersonated")
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	now := time.Now()
+	claims := impersonationClaims{
+		Actor: impersonationActor{
+			Subject: actor.ID.String(),
+			Email:   actor.Email,
+		},
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    JWTIssuer,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+			Subject:   subject.ID.String(),
+			Audience:  []string{impersonationAudience},
+			NotBefore: jwt.NewNumericDate(now),
+			ID:        uuid.New().String(),
+		},
+	}
+	span.SetAttributes(attribute.String("claims.act", claims.Actor.Subject))
+	span.SetAttributes(attribute.String("claims.subject", claims.Subject))
+	span.SetAttributes(attribute.String("claims.id", claims.ID))
+
+	signedToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(jwtKey())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.AuditLogs.Insert(ctx, &data.AuditLog{
+		ActorID:         actor.ID,
+		SubjectID:       subject.ID,
+		ImpersonationID: claims.ID,
+		Action:          data.AuditActionImpersonationStart,
+		Status:          http.StatusCreated,
+		RemoteAddr:      clientIP(r),
+	})
+	if err != nil {
+		// impersonation without its audit trail isn't allowed
+		span.RecordError(err)
+		span.SetStatus(codes.Error, otelDBErr)
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	app.events(r.Context()).Warn("impersonation token issued", logFields{
+		"event":            "impersonation_start",
+		"actor":            actor.Email,
+		"subject":          subject.Email,
+		"impersonation_id": claims.ID,
+	})
+
+	err = app.writeJson(w, r, http.StatusCreated, envelope{"result": map[string]interface{}{
+		"token":  signedToken,
+		"expiry": claims.ExpiresAt.Time,
+	}}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// isImpersonationToken reports whether the jwt is issued for impersonation by its audience. The token isn't verified here.
+func isImpersonationToken(token string) bool {
+	claims := &impersonationClaims{}
+	_, _, err := jwt.NewParser().ParseUnverified(token, claims)
+	return err == nil && slices.Contains(claims.Audience, impersonationAudience)
+}
+
+// impersonatedUser verifies an impersonation token and returns the impersonated user and the real actor.
+// The actor must still hold the impersonation permission when the token is used.
+func (app *application) impersonatedUser(ctx context.Context, token string) (*data.User, *impersonation, error) {
+	verifiedToken, err := jwt.ParseWithClaims(token, &impersonationClaims{}, func(t *jwt.Token) (interface{}, error) {
+		return jwtKey(), nil
+	}, jwt.WithAudience(impersonationAudience), jwt.WithIssuer(JWTIssuer), jwt.WithLeeway(JWTLeeway), jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil || !verifiedToken.Valid {
+		return nil, nil, ErrImpersonationNotAllowed
+	}
+	claims := verifiedToken.Claims.(*impersonationClaims)
+	subjectID, errSubject := uuid.Parse(claims.Subject)
+	actorID, errActor := uuid.Parse(claims.Actor.Subject)
+	if errSubject != nil || errActor != nil {
+		return nil, nil, ErrImpersonationNotAllowed
+	}
+
+	actor := &data.User{}
+	err = app.models.Users.GetByID(actorID, ctx, actor)
+	if err != nil {
+		return nil, nil, err
+	}
+	perms, err := app.models.Permissions.GetAllPermsForUser(ctx, actor.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !perms.IncludesPrem(impersonationPermission) {
+		return nil, nil, ErrImpersonationNotAllowed
+	}
+
+	subject := &data.User{}
+	err = app.models.Users.GetByID(subjectID, ctx, subject)
+	if err != nil {
+		return nil, nil, err
+	}
+	return subject, &impersonation{Actor: actor, ID: claims.ID}, nil
+}
+
+// auditImpersonation records every request made with an impersonation token in the audit log under its real actor.
+func (app *application) auditImpersonation(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		imp := app.GetImpersonationContext(r)
+		if imp == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		subject := app.GetUserContext(r)
+		snoopMetrics := httpsnoop.CaptureMetrics(next, w, r)
+
+		err := app.models.AuditLogs.Insert(context.Background(), &data.AuditLog{
+			ActorID:         imp.Actor.ID,
+			SubjectID:       subject.ID,
+			ImpersonationID: imp.ID,
+			Action:          r.Method + " " + r.URL.Path,
+			Status:          snoopMetrics.Code,
+			RemoteAddr:      clientIP(r),
+		})
+		if err != nil {
+			app.events(r.Context()).Error(err, "failed to record the impersonated request in the audit log", logFields{
+				"actor":   imp.Actor.Email,
+				"subject": subject.Email,
+			})
+		}
+	}
+}
+
+func (app *application) listAuditLogsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := app.startSpan(r, "listAuditLogs")
+	defer span.End()
+
+	var input struct {
+		ActorID   uuid.UUID
+		SubjectID uuid.UUID
+		data.Filters
+	}
+	nValidator := data.NewValidator()
+	qs := r.URL.Query()
+	input.ActorID = app.readUUIDQuery(qs, "actor_id", nValidator)
+	input.SubjectID = app.readUUIDQuery(qs, "subject_id", nValidator)
+	input.Filters.Page = app.readInt(qs, "page", 1, nValidator)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, nValidator)
+	input.Filters.Sort = app.readString(qs, "sort", "-id")
+	input.Filters.Count = app.readString(qs, "count", data.CountExact)
+	input.Filters.SortSafeList = []string{"id", "created_at", "-id", "-created_at"}
+	input.Filters.ValidateFilters(nValidator)
+	if !nValidator.Valid() {
+		span.RecordError(errors.New(createKeyValuePairs(nValidator.Errors)))
+		span.SetStatus(codes.Error, otelunprocessableErr)
+		app.failedValidationResponse(w, r, nValidator)
+		return
+	}
+
+	entries, count, err := app.models.AuditLogs.List(ctx, input.ActorID, input.SubjectID, &input.Filters)
+	if err != nil {
+		span.RecordError(err)
+		switch {
+		case errors.Is(err, data.ErrorRecordNotFound):
+			span.SetStatus(codes.Ok, otelDBNotFoundInfo)
+		default:
+			span.SetStatus(codes.Error, otelDBErr)
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	pMeta := input.Filters.PaginationMetaData(ctx, count)
+	err = app.writeJson(w, r, http.StatusOK, envelope{"Metadata": pMeta, "AuditLogs": entries}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
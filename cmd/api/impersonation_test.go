@@ -0,0 +1,202 @@
+package api
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cybrarymin/greenlight/internal/data"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var impersonationUserColumns = []string{"id", "name", "password_hash", "created_at", "activated", "email", "email_status", "external_id",
+	"version", "updated_at", "suspended_until", "banned", "suspension_reason", "password_reset_required"}
+
+// impersonationUser stubs the lookup of the user by its id
+func impersonationUser(id uuid.UUID, email string) stubQuery {
+	return stub(`^SELECT "user"\..* FROM "users" AS "user" WHERE \(id = '`+id.String()+`'\)`, impersonationUserColumns,
+		[]driver.Value{id.String(), email, []byte("password"), time.Now(), true, email, "ok", nil, int64(1), time.Now(), nil, false, "", false})
+}
+
+// impersonationPerms stubs the permissions of the user
+func impersonationPerms(id uuid.UUID, codes ...string) stubQuery {
+	rows := make([][]driver.Value, 0, len(codes))
+	for i, code := range codes {
+		rows = append(rows, []driver.Value{int64(i + 1), code})
+	}
+	return stub(`FROM "permissions" AS "permission" WHERE \(id IN \(SELECT permission_id FROM user_effective_permissions WHERE user_id = '`+id.String()+`'\)\)`,
+		[]string{"id", "code"}, rows...)
+}
+
+// impersonationToken signs the impersonation claims of the actor as the subject
+func impersonationToken(t *testing.T, actor, subject uuid.UUID, audience string) string {
+	now := time.Now()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, impersonationClaims{
+		Actor: impersonationActor{Subject: actor.String(), Email: "support@example.com"},
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    JWTIssuer,
+			Subject:   subject.String(),
+			Audience:  []string{audience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(impersonationTTL)),
+			ID:        "impersonation-id",
+		},
+	}).SignedString(jwtKey())
+	require.NoError(t, err)
+	return token
+}
+
+func TestIsImpersonationToken(t *testing.T) {
+	defer func(key, issuer, audience string) { JWTKEY, JWTIssuer, JWTAudience = key, issuer, audience }(JWTKEY, JWTIssuer, JWTAudience)
+	JWTKEY, JWTIssuer, JWTAudience = "secret", "greenlight", "greenlight-api"
+
+	apiToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, customClaims{Email: "john@example.com", RegisteredClaims: jwt.RegisteredClaims{
+		Issuer: JWTIssuer, Audience: []string{JWTAudience}, ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	}}).SignedString(jwtKey())
+	require.NoError(t, err)
+
+	tests := []struct {
+		name  string
+		token string
+		want  bool
+	}{
+		{name: "Impersonation audience", token: impersonationToken(t, uuid.New(), uuid.New(), impersonationAudience), want: true},
+		// the jwts issued on /v1/tokens go through jwtUser
+		{name: "API audience", token: apiToken},
+		{name: "Impersonation claims of another audience", token: impersonationToken(t, uuid.New(), uuid.New(), JWTAudience)},
+		{name: "Malformed", token: "a.b.c"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, isImpersonationToken(tc.token))
+		})
+	}
+}
+
+func TestCreateImpersonationToken(t *testing.T) {
+	defer func(key, issuer string) { JWTKEY, JWTIssuer = key, issuer }(JWTKEY, JWTIssuer)
+	JWTKEY, JWTIssuer = "secret", "greenlight"
+	actorID, subjectID := uuid.New(), uuid.New()
+	audit := stub(`^INSERT INTO "audit_logs" .* VALUES \(DEFAULT, '`+actorID.String()+`', '`+subjectID.String()+`', '[^']+', 'impersonation.start', 201, `,
+		[]string{"id", "created_at"}, []driver.Value{int64(1), time.Now()})
+
+	tests := []struct {
+		name          string
+		subject       uuid.UUID
+		impersonating bool
+		queries       []stubQuery
+		status        int
+	}{
+		{name: "Regular user", subject: subjectID, queries: []stubQuery{
+			impersonationUser(subjectID, "john@example.com"), impersonationPerms(subjectID, "movies:read"), audit,
+		}, status: http.StatusCreated},
+		{name: "Admin", subject: subjectID, queries: []stubQuery{
+			impersonationUser(subjectID, "john@example.com"), impersonationPerms(subjectID, "admin"),
+		}, status: http.StatusForbidden},
+		{name: "Support staff", subject: subjectID, queries: []stubQuery{
+			impersonationUser(subjectID, "john@example.com"), impersonationPerms(subjectID, impersonationPermission),
+		}, status: http.StatusForbidden},
+		// a token can't be issued by a request which is impersonating already
+		{name: "Chained", subject: subjectID, impersonating: true, status: http.StatusForbidden},
+		{name: "Yourself", subject: actorID, status: http.StatusUnprocessableEntity},
+		{name: "Unknown user", subject: subjectID, queries: []stubQuery{
+			stub(`^SELECT "user"\..* FROM "users" AS "user"`, impersonationUserColumns),
+		}, status: http.StatusNotFound},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			logger := zerolog.Nop()
+			app := &application{log: &logger, models: stubModels(t, tc.queries...)}
+			r := httptest.NewRequest(http.MethodPost, "/v1/admin/users/"+tc.subject.String()+"/impersonate", nil)
+			r = r.WithContext(context.WithValue(r.Context(), uuidParamsContextKey, map[string]uuid.UUID{"user_id": tc.subject}))
+			r = app.SetUserContext(r, &data.User{ID: actorID, Email: "support@example.com"})
+			if tc.impersonating {
+				r = app.SetImpersonationContext(r, &impersonation{Actor: &data.User{ID: uuid.New()}, ID: "impersonation-id"})
+			}
+			rec := httptest.NewRecorder()
+			app.createImpersonationTokenHandler(rec, r)
+			require.Equal(t, tc.status, rec.Code, rec.Body.String())
+			if tc.status != http.StatusCreated {
+				return
+			}
+
+			var body struct {
+				Result struct {
+					Token string `json:"token"`
+				} `json:"result"`
+			}
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+			claims := &impersonationClaims{}
+			_, _, err := jwt.NewParser().ParseUnverified(body.Result.Token, claims)
+			require.NoError(t, err)
+			assert.Equal(t, subjectID.String(), claims.Subject)
+			assert.Equal(t, actorID.String(), claims.Actor.Subject)
+			assert.True(t, isImpersonationToken(body.Result.Token))
+		})
+	}
+}
+
+func TestAuthImpersonation(t *testing.T) {
+	defer func(key, issuer, audience string) { JWTKEY, JWTIssuer, JWTAudience = key, issuer, audience }(JWTKEY, JWTIssuer, JWTAudience)
+	JWTKEY, JWTIssuer, JWTAudience = "secret", "greenlight", "greenlight-api"
+	actorID, subjectID := uuid.New(), uuid.New()
+	users := []stubQuery{impersonationUser(actorID, "support@example.com"), impersonationUser(subjectID, "john@example.com")}
+	// every impersonated request is audited under the real actor
+	audit := stub(`^INSERT INTO "audit_logs" .* VALUES \(DEFAULT, '`+actorID.String()+`', '`+subjectID.String()+`', 'impersonation-id', 'GET /v1/movies', 200, `,
+		[]string{"id", "created_at"}, []driver.Value{int64(1), time.Now()})
+	usage := stub(`^INSERT INTO "request_usage"`, nil)
+
+	// the act claim is required, so the jwts of /v1/tokens can't be replayed with the impersonation audience
+	withoutActor, err := jwt.NewWithClaims(jwt.SigningMethodHS256, customClaims{Email: "john@example.com", RegisteredClaims: jwt.RegisteredClaims{
+		Issuer: JWTIssuer, Subject: subjectID.String(), Audience: []string{impersonationAudience}, ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	}}).SignedString(jwtKey())
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		token   string
+		queries []stubQuery
+		status  int
+	}{
+		{name: "Actor holding the permission", token: impersonationToken(t, actorID, subjectID, impersonationAudience),
+			queries: append([]stubQuery{impersonationPerms(actorID, impersonationPermission), usage, audit}, users...), status: http.StatusOK},
+		{name: "Actor lost the permission", token: impersonationToken(t, actorID, subjectID, impersonationAudience),
+			queries: append([]stubQuery{impersonationPerms(actorID, "movies:read")}, users...), status: http.StatusUnauthorized},
+		{name: "Without act claim", token: withoutActor, status: http.StatusUnauthorized},
+		{name: "Impersonating the actor", token: impersonationToken(t, actorID, actorID, impersonationAudience), status: http.StatusUnauthorized},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			logger := zerolog.Nop()
+			app := &application{log: &logger, models: stubModels(t, tc.queries...)}
+			r := httptest.NewRequest(http.MethodGet, "/v1/movies", nil)
+			r.Header.Set("Authorization", "Bearer "+tc.token)
+			rec := httptest.NewRecorder()
+			var user *data.User
+			var imp *impersonation
+			app.Auth(func(w http.ResponseWriter, r *http.Request) {
+				user, imp = app.GetUserContext(r), app.GetImpersonationContext(r)
+			})(rec, r)
+			require.Equal(t, tc.status, rec.Code, rec.Body.String())
+			if tc.status != http.StatusOK {
+				assert.Nil(t, user)
+				return
+			}
+			assert.Equal(t, subjectID, user.ID)
+			require.NotNil(t, imp)
+			assert.Equal(t, actorID, imp.Actor.ID)
+			assert.Equal(t, "impersonation-id", imp.ID)
+		})
+	}
+}
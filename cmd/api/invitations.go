@@ -0,0 +1,163 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cybrarymin/greenlight/internal/data"
+	mailer "github.com/cybrarymin/greenlight/internal/mailter"
+	"go.opentelemetry.io/otel/codes"
+)
+
+const (
+	RegistrationOpen   = "open"
+	RegistrationInvite = "invite"
+	RegistrationClosed = "closed"
+)
+
+var (
+	RegistrationMode = RegistrationOpen
+	// InvitationSigningKey is the hmac key used to sign the invitation tokens in invite registration mode
+	InvitationSigningKey string
+)
+
+var ErrInvalidInvitation = errors.New("invalid or expired invitation")
+
+// registrationInvitationMailData is the data of registration_invitation.tpl mail template
+type registrationInvitationMailData struct {
+	Token  string
+	Expiry string
+}
+
+// signInvitation returns an invitation token of the email which is valid until the expiry.
+// The token is stateless, it can't be used twice since the email of a registered user is unique.
+func signInvitation(email string, expiry time.Time) string {
+	payload := strings.ToLower(email) + "|" + strconv.FormatInt(expiry.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(InvitationSigningKey))
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verifyInvitation checks the signature and the expiry of the invitation token and returns the invited email.
+func verifyInvitation(token string) (string, error) {
+	encodedPayload, encodedSignature, found := strings.Cut(token, ".")
+	if !found {
+		return "", ErrInvalidInvitation
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return "", ErrInvalidInvitation
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(encodedSignature)
+	if err != nil {
+		return "", ErrInvalidInvitation
+	}
+	mac := hmac.New(sha256.New, []byte(InvitationSigningKey))
+	mac.Write(payload)
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return "", ErrInvalidInvitation
+	}
+
+	// the quoted local part of an email might have the separator, the expiry never has it
+	sep := strings.LastIndexByte(string(payload), '|')
+	if sep < 1 {
+		return "", ErrInvalidInvitation
+	}
+	email, expires := string(payload[:sep]), string(payload[sep+1:])
+	expiry, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() > expiry {
+		return "", ErrInvalidInvitation
+	}
+	return email, nil
+}
+
+// checkRegistrationMode enforces the registration mode on a new registration and reports whether it's allowed.
+func (app *application) checkRegistrationMode(w http.ResponseWriter, r *http.Request, email string, invitation string) bool {
+	switch RegistrationMode {
+	case RegistrationClosed:
+		app.registrationClosedResponse(w, r)
+		return false
+	case RegistrationInvite:
+		invitedEmail, err := verifyInvitation(invitation)
+		if err != nil || !strings.EqualFold(invitedEmail, email) {
+			app.invalidInvitationResponse(w, r)
+			return false
+		}
+	}
+	return true
+}
+
+// createInvitationHandler emails a signed sign-up invitation to the email address. It's only available in invite registration mode.
+func (app *application) createInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := app.startSpan(r, "createInvitation")
+	defer span.End()
+
+	var input struct {
+		Email string `json:"email"`
+		TTL   string `json:"ttl"`
+	}
+	err := app.readJson(w, r, &input)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, otelunprocessableErr)
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	ttl := invitationTTL
+	nValidator := data.NewValidator()
+	data.ValidateEmail(nValidator, input.Email)
+	emailDomains := app.runtimeCfg().EmailDomains
+	data.ValidateEmailDomain(nValidator, input.Email, &emailDomains)
+	if input.TTL != "" {
+		ttl, err = time.ParseDuration(input.TTL)
+		nValidator.Check(err == nil, "ttl", "must be a valid duration like 72h")
+	}
+	nValidator.Check(ttl > 0 && ttl <= 30*24*time.Hour, "ttl", "must be between 1s and 720h")
+	if !nValidator.Valid() {
+		span.RecordError(errors.New(createKeyValuePairs(nValidator.Errors)))
+		span.SetStatus(codes.Error, otelunprocessableErr)
+		app.failedValidationResponse(w, r, nValidator)
+		return
+	}
+
+	_, err = app.models.Users.GetByEmail(input.Email, ctx)
+	switch {
+	case err == nil:
+		nValidator.AddError("email", "user with current email already exists")
+		span.SetStatus(codes.Error, otelunprocessableErr)
+		app.failedValidationResponse(w, r, nValidator)
+		return
+	case !errors.Is(err, data.ErrorRecordNotFound):
+		span.RecordError(err)
+		span.SetStatus(codes.Error, otelDBErr)
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	expiry := time.Now().Add(ttl)
+	token := signInvitation(input.Email, expiry)
+	app.sendMailInBackground(ctx, mailer.MailMessage{
+		To:           []string{input.Email},
+		TemplateFile: "registration_invitation.tpl",
+		Data: registrationInvitationMailData{
+			Token:  token,
+			Expiry: expiry.UTC().Format(time.RFC1123),
+		},
+	}, "panic happened during sending the registration invitation email")
+
+	invitation := map[string]interface{}{
+		"email":  input.Email,
+		"expiry": expiry.Truncate(time.Second),
+	}
+	err = app.writeJson(w, r, http.StatusAccepted, envelope{"Invitation": invitation}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
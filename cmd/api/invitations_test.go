@@ -0,0 +1,95 @@
+package api
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyInvitation(t *testing.T) {
+	defer func(key string) { InvitationSigningKey = key }(InvitationSigningKey)
+	InvitationSigningKey = "secret"
+	expiry := time.Now().Add(time.Hour)
+	valid := signInvitation("John@Example.com", expiry)
+	payload, signature, _ := strings.Cut(valid, ".")
+	_, otherSignature, _ := strings.Cut(signInvitation("jane@example.com", expiry), ".")
+	tamperedPayload := base64.RawURLEncoding.EncodeToString([]byte("jane@example.com|" + strconv.FormatInt(expiry.Unix(), 10)))
+	otherKey := func() string {
+		InvitationSigningKey = "other"
+		defer func() { InvitationSigningKey = "secret" }()
+		return signInvitation("john@example.com", time.Now().Add(time.Hour))
+	}()
+
+	tests := []struct {
+		name  string
+		token string
+		email string
+		err   error
+	}{
+		{name: "Valid", token: valid, email: "john@example.com"},
+		// the quoted local part of an email might have the separator of the payload
+		{name: "Separator in the email", token: signInvitation(`"john|doe"@example.com`, time.Now().Add(time.Hour)), email: `"john|doe"@example.com`},
+		{name: "Expired", token: signInvitation("john@example.com", time.Now().Add(-time.Second)), err: ErrInvalidInvitation},
+		{name: "Tampered signature", token: payload + "." + otherSignature, err: ErrInvalidInvitation},
+		{name: "Tampered email", token: tamperedPayload + "." + signature, err: ErrInvalidInvitation},
+		{name: "Signed by another key", token: otherKey, err: ErrInvalidInvitation},
+		{name: "Without signature", token: payload, err: ErrInvalidInvitation},
+		{name: "Malformed", token: "not.base64!", err: ErrInvalidInvitation},
+		{name: "Empty", err: ErrInvalidInvitation},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			email, err := verifyInvitation(tc.token)
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.email, email)
+		})
+	}
+}
+
+func TestCheckRegistrationMode(t *testing.T) {
+	defer func(mode, key string) { RegistrationMode, InvitationSigningKey = mode, key }(RegistrationMode, InvitationSigningKey)
+	InvitationSigningKey = "secret"
+	invitation := signInvitation("john@example.com", time.Now().Add(time.Hour))
+
+	tests := []struct {
+		name       string
+		mode       string
+		email      string
+		invitation string
+		allowed    bool
+		code       errorCode
+	}{
+		{name: "Open", mode: RegistrationOpen, email: "john@example.com", allowed: true},
+		{name: "Closed", mode: RegistrationClosed, email: "john@example.com", invitation: invitation, code: codeRegistrationClosed},
+		{name: "Invited", mode: RegistrationInvite, email: "JOHN@example.com", invitation: invitation, allowed: true},
+		{name: "Invitation of another email", mode: RegistrationInvite, email: "jane@example.com", invitation: invitation, code: codeInvitationRequired},
+		{name: "Without invitation", mode: RegistrationInvite, email: "john@example.com", code: codeInvitationRequired},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			RegistrationMode = tc.mode
+			logger := zerolog.Nop()
+			app := &application{log: &logger}
+			rec := httptest.NewRecorder()
+			allowed := app.checkRegistrationMode(rec, httptest.NewRequest(http.MethodPost, "/v1/users", nil), tc.email, tc.invitation)
+			assert.Equal(t, tc.allowed, allowed)
+			if !tc.allowed {
+				assert.Contains(t, rec.Body.String(), string(tc.code))
+			}
+		})
+	}
+}
@@ -0,0 +1,226 @@
+package api
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+var (
+	// IPAllowlist lets only the addresses in the cidrs reach the api. Every address is allowed when it's empty.
+	IPAllowlist []string
+	// IPDenylist keeps the addresses in the cidrs from reaching the api
+	IPDenylist []string
+	// AdminIPAllowlist and MetricsIPAllowlist lock the admin routes and /metrics to the addresses in the cidrs, e.g. the office ranges
+	AdminIPAllowlist   []string
+	MetricsIPAllowlist []string
+	// GeoIPDatabase is the path of a MaxMind country database, e.g. GeoLite2-Country.mmdb
+	GeoIPDatabase string
+	// GeoBlockedCountries are the ISO 3166-1 alpha-2 codes of the countries kept from reaching the api
+	GeoBlockedCountries []string
+)
+
+// route classes with their own ip allowlist
+const (
+	routeClassAdmin   = "admin"
+	routeClassMetrics = "metrics"
+)
+
+// reasons of the rejected addresses
+const (
+	ipRejectedDenylist  = "denylist"
+	ipRejectedAllowlist = "allowlist"
+	ipRejectedCountry   = "country"
+	// the client address couldn't be parsed while an allowlist applies
+	ipRejectedUnparsable = "unparsable"
+)
+
+// ipFilter decides which client addresses can reach the api. The denylist wins over the allowlists.
+type ipFilter struct {
+	allow   []netip.Prefix
+	deny    []netip.Prefix
+	classes map[string][]netip.Prefix
+	// blockedCountries is only checked when country is set
+	blockedCountries map[string]bool
+	country          func(addr netip.Addr) (string, error)
+}
+
+// newIPFilter builds the filter of the flags. It returns nil when no filter is configured.
+func newIPFilter() (*ipFilter, error) {
+	f := &ipFilter{classes: make(map[string][]netip.Prefix)}
+	var err error
+	if f.allow, err = parsePrefixes(IPAllowlist); err != nil {
+		return nil, fmt.Errorf("--ip-allowlist: %w", err)
+	}
+	if f.deny, err = parsePrefixes(IPDenylist); err != nil {
+		return nil, fmt.Errorf("--ip-denylist: %w", err)
+	}
+	if f.classes[routeClassAdmin], err = parsePrefixes(AdminIPAllowlist); err != nil {
+		return nil, fmt.Errorf("--admin-ip-allowlist: %w", err)
+	}
+	if f.classes[routeClassMetrics], err = parsePrefixes(MetricsIPAllowlist); err != nil {
+		return nil, fmt.Errorf("--metrics-ip-allowlist: %w", err)
+	}
+	if len(GeoBlockedCountries) > 0 {
+		if GeoIPDatabase == "" {
+			return nil, fmt.Errorf("--geoip-database is required to block the countries")
+		}
+		reader, err := maxminddb.Open(GeoIPDatabase)
+		if err != nil {
+			return nil, fmt.Errorf("--geoip-database: %w", err)
+		}
+		f.country = func(addr netip.Addr) (string, error) {
+			var record struct {
+				Country struct {
+					ISOCode string `maxminddb:"iso_code"`
+				} `maxminddb:"country"`
+			}
+			err := reader.Lookup(net.IP(addr.AsSlice()), &record)
+			return record.Country.ISOCode, err
+		}
+		f.blockedCountries = make(map[string]bool, len(GeoBlockedCountries))
+		for _, code := range GeoBlockedCountries {
+			f.blockedCountries[strings.ToUpper(code)] = true
+		}
+	}
+	if len(f.allow) == 0 && len(f.deny) == 0 && len(f.classes[routeClassAdmin]) == 0 && len(f.classes[routeClassMetrics]) == 0 && f.country == nil {
+		return nil, nil
+	}
+	return f, nil
+}
+
+// parsePrefixes parses the cidrs. A single address is taken as the cidr of its own.
+func parsePrefixes(cidrs []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		cidr = strings.TrimSpace(cidr)
+		if !strings.Contains(cidr, "/") {
+			addr, err := netip.ParseAddr(cidr)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// routeClass returns the class of the route whose allowlist applies on top of the global one. The routes requiring
+// the admin permission are of the admin class wherever they're registered, like /v1/ws or DELETE /v1/users/:id, along
+// with every path under /admin/ so the unknown ones can't be probed either.
+func (app *application) routeClass(method, path string) string {
+	if path == "/metrics" {
+		return routeClassMetrics
+	}
+	// every version prefix is matched, including the disabled ones, so the class can't be dodged by the version
+	versioned := false
+	if version, rest, found := strings.Cut(strings.TrimPrefix(path, "/v"), "/"); found && strings.HasPrefix(path, "/v") {
+		if _, err := strconv.Atoi(version); err == nil {
+			path, versioned = "/"+rest, true
+		}
+	}
+	if versioned && strings.HasPrefix(path, "/admin/") {
+		return routeClassAdmin
+	}
+	if method == http.MethodHead {
+		method = http.MethodGet
+	}
+	// the static segments take precedence over the parameters, like the router does, so /users/me isn't taken for /users/:id
+	var match *routeInfo
+	for i, route := range app.routeTable {
+		if route.Method != method || route.Versioned != versioned || !matchRoute(route.Path, path) {
+			continue
+		}
+		if match == nil || strings.Count(route.Path, ":") < strings.Count(match.Path, ":") {
+			match = &app.routeTable[i]
+		}
+	}
+	if match != nil && match.Permission == "admin" {
+		return routeClassAdmin
+	}
+	return ""
+}
+
+// check returns why the address can't reach the routes of the class, or an empty reason when it can. The country is
+// returned when it's been looked up.
+func (f *ipFilter) check(addr netip.Addr, class string) (reason string, country string) {
+	addr = addr.Unmap()
+	if containsAddr(f.deny, addr) {
+		return ipRejectedDenylist, ""
+	}
+	if len(f.allow) > 0 && !containsAddr(f.allow, addr) {
+		return ipRejectedAllowlist, ""
+	}
+	if class != "" {
+		if allow := f.classes[class]; len(allow) > 0 && !containsAddr(allow, addr) {
+			return class + "_" + ipRejectedAllowlist, ""
+		}
+	}
+	if f.country != nil {
+		// the addresses missing from the database, like the private ones, aren't blocked
+		country, err := f.country(addr)
+		if err == nil && f.blockedCountries[country] {
+			return ipRejectedCountry, country
+		}
+	}
+	return "", ""
+}
+
+// allowlisted reports whether an allowlist applies to the routes of the class
+func (f *ipFilter) allowlisted(class string) bool {
+	return len(f.allow) > 0 || (class != "" && len(f.classes[class]) > 0)
+}
+
+// filterIPs rejects the clients whose address isn't allowed by --ip-allowlist, --ip-denylist, the allowlists of the
+// route classes or --geo-blocked-countries. It runs before the authentication so the rejected clients can't even try
+// their credentials. The clients whose address can't be parsed are rejected whenever an allowlist applies to the route.
+// Every rejection is logged as an ip_rejected event.
+func (app *application) filterIPs(next http.Handler) http.Handler {
+	if app.ipFilter == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var reason, country string
+		addr, err := netip.ParseAddr(clientIP(r))
+		switch {
+		case err == nil:
+			reason, country = app.ipFilter.check(addr, app.routeClass(r.Method, r.URL.Path))
+		case app.ipFilter.allowlisted(app.routeClass(r.Method, r.URL.Path)):
+			// the address can't be matched against the allowlists, so it can't be let in by them either
+			reason = ipRejectedUnparsable
+		}
+		if reason == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		app.events(r.Context()).Warn("request rejected by the ip filter", logFields{
+			"event":       "ip_rejected",
+			"remote_addr": clientIP(r),
+			"path":        r.URL.Path,
+			"reason":      reason,
+			"country":     country,
+		})
+		recordIPRejection(r.Context(), reason)
+		app.ipBlockedResponse(w, r)
+	})
+}
@@ -0,0 +1,134 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIPFilterCheck(t *testing.T) {
+	mustPrefixes := func(cidrs ...string) []netip.Prefix {
+		prefixes, err := parsePrefixes(cidrs)
+		require.NoError(t, err)
+		return prefixes
+	}
+	countries := map[string]string{"203.0.113.7": "RU", "198.51.100.7": "DE"}
+	f := &ipFilter{
+		allow: mustPrefixes("203.0.113.0/24", "198.51.100.0/24", "10.0.0.0/8", "::ffff:192.0.2.1"),
+		deny:  mustPrefixes("10.1.0.0/16"),
+		classes: map[string][]netip.Prefix{
+			routeClassAdmin:   mustPrefixes("10.0.0.0/24"),
+			routeClassMetrics: mustPrefixes("10.0.0.5"),
+		},
+		blockedCountries: map[string]bool{"RU": true},
+		country: func(addr netip.Addr) (string, error) {
+			country, found := countries[addr.String()]
+			if !found {
+				return "", errors.New("not found")
+			}
+			return country, nil
+		},
+	}
+
+	tests := []struct {
+		name        string
+		addr        string
+		class       string
+		wantReason  string
+		wantCountry string
+	}{
+		{name: "Allowed", addr: "198.51.100.7"},
+		{name: "Missing from the allowlist", addr: "192.0.2.9", wantReason: ipRejectedAllowlist},
+		{name: "Denied wins over allowed", addr: "10.1.2.3", wantReason: ipRejectedDenylist},
+		{name: "Mapped ipv4 address", addr: "::ffff:10.0.0.9"},
+		{name: "Admin from the office", addr: "10.0.0.9", class: routeClassAdmin},
+		{name: "Admin from outside the office", addr: "10.2.0.9", class: routeClassAdmin, wantReason: routeClassAdmin + "_" + ipRejectedAllowlist},
+		{name: "Metrics from the scraper", addr: "10.0.0.5", class: routeClassMetrics},
+		{name: "Metrics from elsewhere", addr: "10.0.0.6", class: routeClassMetrics, wantReason: routeClassMetrics + "_" + ipRejectedAllowlist},
+		{name: "Blocked country", addr: "203.0.113.7", wantReason: ipRejectedCountry, wantCountry: "RU"},
+		{name: "Unknown country", addr: "203.0.113.8"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			reason, country := f.check(netip.MustParseAddr(tc.addr), tc.class)
+			assert.Equal(t, tc.wantReason, reason)
+			assert.Equal(t, tc.wantCountry, country)
+		})
+	}
+}
+
+func TestRouteClass(t *testing.T) {
+	logger := zerolog.Nop()
+	app := &application{log: &logger}
+	app.runtime.Store(&runtimeConfig{})
+	app.routes()
+
+	tests := []struct {
+		method string
+		path   string
+		want   string
+	}{
+		{method: http.MethodGet, path: "/v1/movies", want: ""},
+		{method: http.MethodGet, path: "/v1/admin/stats", want: routeClassAdmin},
+		{method: http.MethodGet, path: "/v9/admin/unknown", want: routeClassAdmin},
+		{method: http.MethodGet, path: "/v1/ws", want: routeClassAdmin},
+		{method: http.MethodGet, path: "/v1/users", want: routeClassAdmin},
+		{method: http.MethodPost, path: "/v1/users/import", want: routeClassAdmin},
+		{method: http.MethodDelete, path: "/v1/users/0b5ef7c5-54c1-4b8e-a9cd-1a4c6c3c9c77", want: routeClassAdmin},
+		{method: http.MethodPost, path: "/v1/users", want: ""},
+		{method: http.MethodGet, path: "/ws", want: ""},
+		{method: http.MethodGet, path: "/metrics", want: routeClassMetrics},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.method+" "+tc.path, func(t *testing.T) {
+			assert.Equal(t, tc.want, app.routeClass(tc.method, tc.path))
+		})
+	}
+}
+
+func TestFilterIPsUnparsableAddress(t *testing.T) {
+	defer func(exportMode string) { MetricsExportMode = exportMode }(MetricsExportMode)
+	MetricsExportMode = MetricsExportPrometheus
+	logger := zerolog.Nop()
+	app := &application{log: &logger}
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+
+	app.ipFilter = &ipFilter{deny: []netip.Prefix{netip.MustParsePrefix("10.1.0.0/16")}}
+	r := httptest.NewRequest(http.MethodGet, "/v1/movies", nil)
+	r.RemoteAddr = "@"
+	w := httptest.NewRecorder()
+	app.filterIPs(next).ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code, "only a denylist can't keep the address out")
+
+	app.ipFilter = &ipFilter{allow: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}}
+	w = httptest.NewRecorder()
+	app.filterIPs(next).ServeHTTP(w, r)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestNewIPFilter(t *testing.T) {
+	defer func(allow, countries []string, db string) {
+		IPAllowlist, GeoBlockedCountries, GeoIPDatabase = allow, countries, db
+	}(IPAllowlist, GeoBlockedCountries, GeoIPDatabase)
+
+	IPAllowlist, GeoBlockedCountries, GeoIPDatabase = nil, nil, ""
+	f, err := newIPFilter()
+	require.NoError(t, err)
+	assert.Nil(t, f)
+
+	IPAllowlist = []string{"10.0.0.0/33"}
+	_, err = newIPFilter()
+	assert.Error(t, err)
+
+	IPAllowlist, GeoBlockedCountries = nil, []string{"ru"}
+	_, err = newIPFilter()
+	assert.Error(t, err)
+}
@@ -0,0 +1,196 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/cybrarymin/greenlight/internal/data"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// jobProgress is handed to the operation of a job to report the items it has processed
+type jobProgress struct {
+	app *application
+	ctx context.Context
+	id  int64
+}
+
+// report adds the processed items, the failed ones among them and their errors to the job.
+// Failing to record the progress doesn't fail the job.
+func (p *jobProgress) report(processed int, failed int, itemErrors []data.JobItemError) {
+	err := p.app.models.Jobs.Progress(p.ctx, p.id, processed, failed, itemErrors)
+	if err != nil {
+		p.app.events(p.ctx).Error(err, "failed to record the progress of the job", nil)
+	}
+}
+
+// startJob creates a job of total items and runs the operation in the background. The result returned by the operation
+// is stored on the job once it's over. The operation outlives the request, so it gets a context which isn't cancelled with it.
+func (app *application) startJob(ctx context.Context, jobType string, createdBy uuid.UUID, total int, run func(ctx context.Context, progress *jobProgress) (interface{}, error)) (*data.Job, error) {
+	job := &data.Job{Type: jobType, CreatedBy: createdBy, Total: total}
+	err := app.models.Jobs.Insert(ctx, job)
+	if err != nil {
+		return nil, err
+	}
+
+	// the logs of the job carry its id along with the fields of the request which has started it
+	logger := app.logger(ctx).With().Int64("job_id", job.ID).Str("job_type", jobType).Logger()
+	events := logEvents(&logger)
+	bgCtx := context.WithValue(context.WithoutCancel(ctx), loggerContextKey, &logger)
+	app.BackgroundJob(func() {
+		finish := func(result interface{}, jobErr error) {
+			err := app.models.Jobs.Finish(bgCtx, job.ID, result, jobErr)
+			if err != nil {
+				events.Error(err, "failed to record the end of the job", nil)
+			}
+		}
+		// a panicking operation fails the job before the panic is logged by BackgroundJob
+		defer func() {
+			if panicErr := recover(); panicErr != nil {
+				finish(nil, fmt.Errorf("job panicked: %v", panicErr))
+				panic(panicErr)
+			}
+		}()
+
+		err := app.models.Jobs.Start(bgCtx, job.ID, total)
+		if err != nil {
+			finish(nil, err)
+			return
+		}
+		result, err := run(bgCtx, &jobProgress{app: app, ctx: bgCtx, id: job.ID})
+		if err != nil {
+			events.Error(err, "job failed", nil)
+		}
+		finish(result, err)
+	}, fmt.Sprintf("panic happened during running the %s job", jobType))
+	return job, nil
+}
+
+// jobAcceptedResponse tells the client where to follow the job it has started
+func (app *application) jobAcceptedResponse(w http.ResponseWriter, r *http.Request, job *data.Job) {
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/jobs/%d", job.ID))
+	err := app.writeJson(w, r, http.StatusAccepted, envelope{"JobID": job.ID, "Status": job.Status}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) isAdmin(ctx context.Context, user *data.User) (bool, error) {
+	perms, err := app.models.Permissions.GetAllPermsForUser(ctx, user.ID)
+	if err != nil && !errors.Is(err, data.ErrorRecordNotFound) {
+		return false, err
+	}
+	return perms != nil && perms.IncludesPrem("admin") && scopeAllows(ctx, "admin"), nil
+}
+
+// showJobHandler shows the progress, the item errors and the result of a job. Users can only see their own jobs unless they're admin.
+func (app *application) showJobHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := app.startSpan(r, "showJob")
+	defer span.End()
+
+	id := app.idParam(r, "id")
+
+	job, err := app.models.Jobs.Get(ctx, id)
+	if err != nil {
+		span.RecordError(err)
+		switch {
+		case errors.Is(err, data.ErrorRecordNotFound):
+			span.SetStatus(codes.Ok, otelDBNotFoundInfo)
+			app.notFoundResponse(w, r)
+		default:
+			span.SetStatus(codes.Error, otelDBErr)
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user := app.GetUserContext(r)
+	if job.CreatedBy != user.ID {
+		admin, err := app.isAdmin(ctx, user)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, otelDBErr)
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		// the jobs of the other users aren't revealed to exist
+		if !admin {
+			app.notFoundResponse(w, r)
+			return
+		}
+	}
+
+	err = app.writeJson(w, r, http.StatusOK, envelope{"result": job}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listJobsHandler lists the jobs of the user. Admins see the jobs of every user and can filter them by created_by.
+func (app *application) listJobsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := app.startSpan(r, "listJobs")
+	defer span.End()
+
+	var input struct {
+		CreatedBy uuid.UUID
+		Type      string
+		Status    string
+		data.Filters
+	}
+	nValidator := data.NewValidator()
+	qs := r.URL.Query()
+	input.CreatedBy = app.readUUIDQuery(qs, "created_by", nValidator)
+	input.Type = app.readEnum(qs, "type", "", data.JobTypes, nValidator)
+	input.Status = app.readEnum(qs, "status", "", data.JobStatuses, nValidator)
+	input.Filters.Page = app.readInt(qs, "page", 1, nValidator)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, nValidator)
+	input.Filters.Sort = app.readString(qs, "sort", "-id")
+	input.Filters.Count = app.readString(qs, "count", data.CountExact)
+	input.Filters.SortSafeList = []string{"id", "created_at", "status", "-id", "-created_at", "-status"}
+	input.Filters.ValidateFilters(nValidator)
+	if !nValidator.Valid() {
+		span.RecordError(errors.New(createKeyValuePairs(nValidator.Errors)))
+		span.SetStatus(codes.Error, otelunprocessableErr)
+		app.failedValidationResponse(w, r, nValidator)
+		return
+	}
+
+	user := app.GetUserContext(r)
+	admin, err := app.isAdmin(ctx, user)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, otelDBErr)
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !admin {
+		if input.CreatedBy != uuid.Nil && input.CreatedBy != user.ID {
+			app.notPermittedResponse(w, r)
+			return
+		}
+		input.CreatedBy = user.ID
+	}
+
+	jobs, count, err := app.models.Jobs.List(ctx, input.CreatedBy, input.Type, input.Status, &input.Filters)
+	if err != nil {
+		span.RecordError(err)
+		switch {
+		case errors.Is(err, data.ErrorRecordNotFound):
+			span.SetStatus(codes.Ok, otelDBNotFoundInfo)
+		default:
+			span.SetStatus(codes.Error, otelDBErr)
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	pMeta := input.Filters.PaginationMetaData(ctx, count)
+	err = app.writeJson(w, r, http.StatusOK, envelope{"Metadata": pMeta, "Jobs": jobs}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,79 @@
+package api
+
+import (
+	"bytes"
+	"net/http"
+	"sync"
+)
+
+// jsonStreamThreshold is the size of the response bodies which are buffered before they're written. The larger bodies
+// are written directly to the response writer so they aren't copied into a buffer first.
+const jsonStreamThreshold = 64 << 10
+
+// jsonEncoder encodes the response bodies. It's encoding/json by default, check jsonencoder_jsoniter.go for the faster backend.
+type jsonEncoder interface {
+	Encode(v interface{}) error
+}
+
+var jsonBufferPool = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+// jsonBodyWriter buffers the body in a pooled buffer until it grows past jsonStreamThreshold. The headers and the status
+// are written along with the first write to the response writer, so an encoding error can still be responded before that.
+type jsonBodyWriter struct {
+	w           http.ResponseWriter
+	status      int
+	contentType string
+	headers     http.Header
+	buf         *bytes.Buffer
+	started     bool
+}
+
+func (jw *jsonBodyWriter) writeHeader() {
+	jw.started = true
+	for key, value := range jw.headers {
+		jw.w.Header()[key] = value
+	}
+	jw.w.Header().Set("Content-Type", jw.contentType)
+	jw.w.WriteHeader(jw.status)
+}
+
+func (jw *jsonBodyWriter) Write(p []byte) (int, error) {
+	if !jw.started && jw.buf.Len()+len(p) <= jsonStreamThreshold {
+		return jw.buf.Write(p)
+	}
+	if !jw.started {
+		jw.writeHeader()
+		if _, err := jw.w.Write(jw.buf.Bytes()); err != nil {
+			return 0, err
+		}
+		jw.buf.Reset()
+	}
+	return jw.w.Write(p)
+}
+
+// flush writes the status and the buffered body when nothing has been written yet
+func (jw *jsonBodyWriter) flush() {
+	if jw.started {
+		return
+	}
+	jw.writeHeader()
+	jw.w.Write(jw.buf.Bytes())
+}
+
+// writeJSONBody writes the response with v encoded as its body. The encoding error is only returned when nothing has been written yet, once the body is streamed the status
+// has been sent and the response can't be turned into an error anymore.
+func writeJSONBody(w http.ResponseWriter, status int, contentType string, headers http.Header, v interface{}) error {
+	buf := jsonBufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	defer jsonBufferPool.Put(buf)
+
+	jw := &jsonBodyWriter{w: w, status: status, contentType: contentType, headers: headers, buf: buf}
+	err := newJSONEncoder(jw).Encode(v)
+	if err != nil && !jw.started {
+		return err
+	}
+	jw.flush()
+	return nil
+}
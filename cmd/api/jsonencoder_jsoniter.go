@@ -0,0 +1,17 @@
+//go:build jsoniter
+
+package api
+
+import (
+	"io"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+// jsoniterAPI is configured to produce the same output as encoding/json, so the responses don't change with the backend.
+// Build with -tags jsoniter to use it.
+var jsoniterAPI = jsoniter.ConfigCompatibleWithStandardLibrary
+
+func newJSONEncoder(w io.Writer) jsonEncoder {
+	return jsoniterAPI.NewEncoder(w)
+}
@@ -0,0 +1,12 @@
+//go:build !jsoniter
+
+package api
+
+import (
+	"encoding/json"
+	"io"
+)
+
+func newJSONEncoder(w io.Writer) jsonEncoder {
+	return json.NewEncoder(w)
+}
@@ -0,0 +1,75 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteJSONBody(t *testing.T) {
+	tests := []struct {
+		name   string
+		movies int
+	}{
+		{name: "Buffered body", movies: 1},
+		{name: "Streamed body", movies: 1000},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			body := envelope{"movies": benchmarkMovies(tc.movies)}
+			expected, err := json.Marshal(body)
+			assert.NoError(t, err)
+
+			rec := httptest.NewRecorder()
+			err = writeJSONBody(rec, http.StatusCreated, "application/json", http.Header{"Location": {"/v1/movies/1"}}, body)
+			assert.NoError(t, err)
+			assert.Equal(t, http.StatusCreated, rec.Code)
+			assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+			assert.Equal(t, "/v1/movies/1", rec.Header().Get("Location"))
+			assert.Equal(t, string(expected)+"\n", rec.Body.String())
+		})
+	}
+}
+
+func TestWriteJSONBodyEncodingError(t *testing.T) {
+	rec := httptest.NewRecorder()
+	err := writeJSONBody(rec, http.StatusOK, "application/json", http.Header{"Location": {"/v1/movies/1"}}, envelope{"invalid": make(chan int)})
+	assert.Error(t, err)
+	// nothing is written so the error can still be responded
+	assert.Empty(t, rec.Header())
+	assert.Zero(t, rec.Body.Len())
+}
+
+// BenchmarkJSONBody compares writeJSONBody to encoding every body into a fresh buffer
+func BenchmarkJSONBody(b *testing.B) {
+	for _, size := range []int{1, 100, 1000} {
+		body := envelope{"movies": benchmarkMovies(size)}
+		b.Run(fmt.Sprintf("pooled %d movies", size), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if err := writeJSONBody(httptest.NewRecorder(), http.StatusOK, "application/json", nil, body); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("fresh buffer %d movies", size), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				w := httptest.NewRecorder()
+				buf := bytes.Buffer{}
+				if err := json.NewEncoder(&buf).Encode(body); err != nil {
+					b.Fatal(err)
+				}
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusOK)
+				w.Write(buf.Bytes())
+			}
+		})
+	}
+}
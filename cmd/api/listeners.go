@@ -0,0 +1,221 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+var (
+	// GracefulRestart makes SIGHUP start a new process of the server which takes over the listeners once it's ready
+	// and then stops this one, so the deploys on bare VMs don't drop any request.
+	// Under systemd the new process tells it to track the new process as the main one before stopping the old one,
+	// otherwise the exit of the old one stops the service along with the new process. The unit needs NotifyAccess=all
+	// for systemd to take the main pid from a process other than the main one, and ExecReload=/bin/kill -HUP $MAINPID.
+	GracefulRestart bool
+	// ReusePort sets SO_REUSEPORT on the listeners so a new server can listen on the same ports while the old one is draining
+	ReusePort bool
+)
+
+// names of the listeners passed between the processes
+const (
+	listenerHTTP = "http"
+	listenerMTLS = "mtls"
+)
+
+const (
+	// listenFDsEnv passes the names of the listeners inherited by the new process of a graceful restart.
+	// Like systemd socket activation their file descriptors start at 3 in the same order.
+	listenFDsEnv = "GREENLIGHT_LISTEN_FDS"
+	// restartParentEnv passes the pid of the process the new process of a graceful restart takes over from
+	restartParentEnv = "GREENLIGHT_RESTART_PARENT"
+	// restartReadyTimeout is how long the new process waits to get ready before giving up the restart
+	restartReadyTimeout = 30 * time.Second
+)
+
+var errNotReady = errors.New("server didn't get ready in time")
+
+// inheritedListeners returns the listeners passed by systemd socket activation or by the old process of a graceful restart,
+// keyed by their name. Without FileDescriptorName= in the systemd socket unit the first socket is the http listener
+// and the second one the mtls listener.
+func inheritedListeners() (map[string]net.Listener, error) {
+	listeners := make(map[string]net.Listener)
+	var names []string
+	switch {
+	case os.Getenv("LISTEN_PID") == strconv.Itoa(os.Getpid()):
+		count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid LISTEN_FDS: %w", err)
+		}
+		names = strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+		if len(names) != count {
+			names = make([]string, count)
+			for i := range names {
+				names[i] = fmt.Sprintf("fd%d", i)
+			}
+			names[0] = listenerHTTP
+			if count > 1 {
+				names[1] = listenerMTLS
+			}
+		}
+	case os.Getenv(listenFDsEnv) != "":
+		names = strings.Split(os.Getenv(listenFDsEnv), ",")
+	default:
+		return listeners, nil
+	}
+	// the processes started by this one must not take the variables for their own
+	for _, env := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES", listenFDsEnv} {
+		os.Unsetenv(env)
+	}
+
+	for i, name := range names {
+		f := os.NewFile(uintptr(3+i), name)
+		ln, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to use the inherited %s listener: %w", name, err)
+		}
+		listeners[name] = ln
+	}
+	return listeners, nil
+}
+
+// listen returns the inherited listener of the name or listens on the port
+func listen(inherited map[string]net.Listener, name string, port int) (net.Listener, error) {
+	if ln, ok := inherited[name]; ok {
+		return ln, nil
+	}
+	lc := net.ListenConfig{}
+	if ReusePort {
+		lc.Control = reusePortControl
+	}
+	return lc.Listen(context.Background(), "tcp", fmt.Sprintf(":%d", port))
+}
+
+// restartParent returns the pid of the old process when this process is started by a graceful restart, 0 otherwise
+func restartParent() int {
+	pid, err := strconv.Atoi(os.Getenv(restartParentEnv))
+	os.Unsetenv(restartParentEnv)
+	// the old process may have died and this one adopted by init in the meantime
+	if err != nil || pid != os.Getppid() {
+		return 0
+	}
+	return pid
+}
+
+// sdNotify sends the state to systemd, like READY=1, when the server runs as a service with a notify socket.
+// It does nothing otherwise.
+func sdNotify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// waitUntilReady checks the readiness of the server until it's ready or the timeout passes.
+// The new process of a graceful restart doesn't serve any request before it's ready.
+func (app *application) waitUntilReady(timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		report, err := app.checkSchema(ctx)
+		if err == nil && !report.Drifted() {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return errNotReady
+		case <-ticker.C:
+		}
+	}
+}
+
+// handleRestarts starts a new process of the server on every SIGHUP, handing the listeners over to it.
+// The new process stops this one with SIGTERM once it's ready, which drains it by the graceful shutdown.
+// Restarts are ignored while a new process is getting ready.
+func (app *application) handleRestarts(listeners map[string]net.Listener) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	var restarting atomic.Bool
+	for range hup {
+		if !restarting.CompareAndSwap(false, true) {
+			logEvents(app.log).Warn("ignored the restart signal while the new process is getting ready", nil)
+			continue
+		}
+		cmd, err := startNewProcess(listeners)
+		if err != nil {
+			logEvents(app.log).Error(err, "failed to start the new process of the graceful restart", nil)
+			restarting.Store(false)
+			continue
+		}
+		logEvents(app.log).Info("started the new process of the graceful restart", logFields{"pid": cmd.Process.Pid})
+		go func() {
+			// the new process only exits before this one when it couldn't get ready, this one keeps serving then
+			err := cmd.Wait()
+			logEvents(app.log).Error(err, "the new process of the graceful restart exited before taking over", logFields{"pid": cmd.Process.Pid})
+			restarting.Store(false)
+		}()
+	}
+}
+
+func startNewProcess(listeners map[string]net.Listener) (*exec.Cmd, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	files := []*os.File{}
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	for _, name := range []string{listenerHTTP, listenerMTLS} {
+		ln, ok := listeners[name]
+		if !ok {
+			continue
+		}
+		tcpLn, ok := ln.(*net.TCPListener)
+		if !ok {
+			return nil, fmt.Errorf("the %s listener can't be handed over", name)
+		}
+		// File duplicates the socket, closing the duplicate doesn't close the listener
+		f, err := tcpLn.File()
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+		files = append(files, f)
+	}
+
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.Env = append(os.Environ(),
+		listenFDsEnv+"="+strings.Join(names, ","),
+		restartParentEnv+"="+strconv.Itoa(os.Getpid()),
+	)
+	cmd.ExtraFiles = files
+	err = cmd.Start()
+	if err != nil {
+		return nil, err
+	}
+	return cmd, nil
+}
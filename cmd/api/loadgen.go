@@ -0,0 +1,186 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/cybrarymin/greenlight/internal/data"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+)
+
+// LoadGenOptions sets the amount of the data generated by LoadGen
+type LoadGenOptions struct {
+	Movies int
+	Users  int
+	// ProgressPerUser is the number of the movies each user has watched or started watching
+	ProgressPerUser int
+	// Seed makes the generated data the same on every run with the same options
+	Seed int64
+	// BatchSize is the number of the rows inserted in each transaction
+	BatchSize int
+	// Password is the password of every generated user, so the load tests can sign in as any of them
+	Password string
+}
+
+// loadGenEpoch is the latest time of the generated data. It's fixed so the data doesn't depend on the day of the run.
+var loadGenEpoch = time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+var (
+	loadGenTitleWords = []string{
+		"last", "dark", "silent", "golden", "broken", "lost", "midnight", "red", "hidden", "eternal",
+		"city", "river", "kingdom", "shadow", "storm", "garden", "empire", "island", "mirror", "road",
+		"night", "summer", "winter", "echo", "fire", "star", "dream", "ghost", "heart", "war",
+	}
+	loadGenGenres = []string{
+		"action", "adventure", "animation", "comedy", "crime", "documentary", "drama", "family",
+		"fantasy", "history", "horror", "music", "mystery", "romance", "sci-fi", "thriller", "war", "western",
+	}
+	loadGenFirstNames = []string{
+		"alice", "bob", "carol", "dave", "erin", "frank", "grace", "heidi", "ivan", "judy",
+		"mallory", "nina", "oscar", "peggy", "rupert", "sybil", "trent", "victor", "walter", "zoe",
+	}
+	loadGenLastNames = []string{
+		"smith", "jones", "brown", "taylor", "wilson", "davies", "evans", "thomas", "johnson", "roberts",
+		"walker", "wright", "robinson", "thompson", "white", "hughes", "edwards", "green", "hall", "wood",
+	}
+)
+
+// LoadGen fills the database with fake movies, users and watch progress for the performance tests of the pagination,
+// the search and the indexes. The rows are inserted through the data models in batches, each batch in its own transaction.
+// The users share a single password hash since hashing a password per user would take longer than the inserts.
+func LoadGen(opts LoadGenOptions) error {
+	logger := zerolog.New(zerolog.ConsoleWriter{Out: os.Stdout, TimeFormat: time.RFC3339}).With().Timestamp().Logger()
+	events := logEvents(&logger)
+	rnd := rand.New(rand.NewSource(opts.Seed))
+
+	cfg := config{}
+	cfg.db.dbDsn = DBDSN
+	cfg.db.driver = DBDriver
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	connector, err := dbConnector(&cfg)
+	if err != nil {
+		return err
+	}
+	db, err := openDB(ctx, connector)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	models := data.NewModels(db)
+	ctx = context.Background()
+	bulkOpts := data.BulkInsertOptions{OnConflict: data.ConflictSkip}
+
+	movies := make([]loadGenMovieRef, 0, opts.Movies)
+	for start := 0; start < opts.Movies; start += opts.BatchSize {
+		batch := make([]*data.Movie, 0, min(opts.BatchSize, opts.Movies-start))
+		for i := start; i < start+cap(batch); i++ {
+			batch = append(batch, loadGenMovie(rnd))
+		}
+		if _, err := models.Movies.InsertMany(ctx, batch, bulkOpts); err != nil {
+			return fmt.Errorf("failed to insert the movies: %w", err)
+		}
+		for _, movie := range batch {
+			movies = append(movies, loadGenMovieRef{id: movie.ID, runtime: movie.Runtime})
+		}
+		events.Info(fmt.Sprintf("inserted %d of %d movies", start+len(batch), opts.Movies), nil)
+	}
+
+	password := data.Password{}
+	if err := password.Set(opts.Password); err != nil {
+		return err
+	}
+	written := 0
+	for start := 0; start < opts.Users; start += opts.BatchSize {
+		batch := make([]*data.User, 0, min(opts.BatchSize, opts.Users-start))
+		for i := start; i < start+cap(batch); i++ {
+			batch = append(batch, loadGenUser(rnd, i, password.Hash))
+		}
+		// the users of the former runs keep their email and are skipped
+		if _, err := models.Users.InsertMany(ctx, batch, bulkOpts); err != nil {
+			return fmt.Errorf("failed to insert the users: %w", err)
+		}
+		if len(movies) > 0 {
+			progress := make([]*data.WatchProgress, 0, len(batch)*opts.ProgressPerUser)
+			for _, user := range batch {
+				if user.ID == uuid.Nil {
+					continue
+				}
+				progress = append(progress, loadGenProgress(rnd, user, movies, opts.ProgressPerUser)...)
+			}
+			n, err := models.Progress.InsertMany(ctx, progress, bulkOpts)
+			if err != nil {
+				return fmt.Errorf("failed to insert the watch progress: %w", err)
+			}
+			written += n
+		}
+		events.Info(fmt.Sprintf("inserted %d of %d users", start+len(batch), opts.Users), nil)
+	}
+	events.Info(fmt.Sprintf("inserted %d watch progress records", written), nil)
+	return nil
+}
+
+// loadGenMovieRef keeps what the watch progress needs from the inserted movies
+type loadGenMovieRef struct {
+	id      int64
+	runtime data.Runtime
+}
+
+func loadGenMovie(rnd *rand.Rand) *data.Movie {
+	words := make([]string, 1+rnd.Intn(4))
+	for i := range words {
+		words[i] = loadGenTitleWords[rnd.Intn(len(loadGenTitleWords))]
+	}
+	if rnd.Intn(2) == 0 {
+		words = append([]string{"the"}, words...)
+	}
+	genres := make([]string, 0, 3)
+	for _, i := range rnd.Perm(len(loadGenGenres))[:1+rnd.Intn(3)] {
+		genres = append(genres, loadGenGenres[i])
+	}
+	return &data.Movie{
+		Title:   strings.Join(words, " "),
+		Year:    int32(1930 + rnd.Intn(loadGenEpoch.Year()-1930)),
+		Runtime: data.Runtime(70 + rnd.Intn(110)),
+		Genres:  genres,
+	}
+}
+
+// loadGenUser returns the user of the index. The email is derived from the index to keep it unique.
+func loadGenUser(rnd *rand.Rand, index int, passwordHash []byte) *data.User {
+	first := loadGenFirstNames[rnd.Intn(len(loadGenFirstNames))]
+	last := loadGenLastNames[rnd.Intn(len(loadGenLastNames))]
+	return &data.User{
+		Name:      first + " " + last,
+		Email:     fmt.Sprintf("%s.%s.%d@loadgen.example.com", first, last, index),
+		Password:  data.Password{Hash: passwordHash},
+		Activated: rnd.Intn(10) != 0,
+	}
+}
+
+// loadGenProgress returns the positions of up to count random movies for the user. Duplicates are dropped.
+func loadGenProgress(rnd *rand.Rand, user *data.User, movies []loadGenMovieRef, count int) []*data.WatchProgress {
+	progress := make([]*data.WatchProgress, 0, count)
+	seen := make(map[int64]bool, count)
+	for i := 0; i < count; i++ {
+		movie := movies[rnd.Intn(len(movies))]
+		if seen[movie.id] {
+			continue
+		}
+		seen[movie.id] = true
+		position := rnd.Int31n(int32(movie.runtime)*60 + 1)
+		progress = append(progress, &data.WatchProgress{
+			UserID:          user.ID,
+			MovieID:         movie.id,
+			PositionSeconds: position,
+			Completed:       data.Watched(position, movie.runtime),
+			UpdatedAt:       loadGenEpoch.Add(-time.Duration(rnd.Int63n(int64(365 * 24 * time.Hour)))).Truncate(time.Second),
+		})
+	}
+	return progress
+}
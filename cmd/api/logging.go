@@ -0,0 +1,141 @@
+package api
+
+import (
+	"context"
+	"io"
+	"os"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/pkgerrors"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+var (
+	// LogFile writes the logs to the file instead of stdout, rotated by its size and LogFileRotateInterval
+	LogFile string
+	// LogFileMaxSize is the size in megabytes the log file is rotated at
+	LogFileMaxSize int
+	// LogFileMaxBackups and LogFileMaxAge limit the rotated files which are kept. zero keeps all of them.
+	LogFileMaxBackups int
+	LogFileMaxAge     int
+	// LogFileRotateInterval rotates the log file periodically on top of its size, e.g. daily. zero disables it.
+	LogFileRotateInterval time.Duration
+	// LogWarnBurst is the number of warnings logged per LogWarnSamplePeriod, the rest of them are dropped.
+	// The security events like ip_rejected are logged as warnings and may flood the logs under an attack. zero disables it.
+	LogWarnBurst        uint32
+	LogWarnSamplePeriod time.Duration
+)
+
+// newLogger builds the logger of the flags. The returned function stops the rotation and closes the log file.
+func newLogger() (zerolog.Logger, func() error) {
+	zerolog.ErrorStackMarshaler = pkgerrors.MarshalStack
+	var out io.Writer = os.Stdout
+	closeFn := func() error { return nil }
+	if LogFile != "" {
+		file := &lumberjack.Logger{
+			Filename:   LogFile,
+			MaxSize:    LogFileMaxSize,
+			MaxBackups: LogFileMaxBackups,
+			MaxAge:     LogFileMaxAge,
+		}
+		out = file
+		closeFn = file.Close
+		if LogFileRotateInterval > 0 {
+			done := make(chan struct{})
+			stopped := make(chan struct{})
+			go func() {
+				defer close(stopped)
+				rotateLogFile(file, LogFileRotateInterval, done)
+			}()
+			closeFn = func() error {
+				close(done)
+				<-stopped
+				return file.Close()
+			}
+		}
+	}
+	if LogFormat == LogFormatConsole {
+		out = zerolog.ConsoleWriter{Out: out, TimeFormat: time.RFC3339, NoColor: LogFile != ""}
+	}
+
+	ctx := zerolog.New(out).With().Timestamp()
+	if zerolog.Level(LogLevel).String() == zerolog.LevelTraceValue {
+		ctx = ctx.Stack()
+	}
+	logger := ctx.Logger()
+	if LogWarnBurst > 0 {
+		logger = logger.Sample(&zerolog.LevelSampler{
+			WarnSampler: &zerolog.BurstSampler{Burst: LogWarnBurst, Period: LogWarnSamplePeriod},
+		})
+	}
+	// log level is applied globally so it can be changed at runtime. check swapRuntimeConfig
+	zerolog.SetGlobalLevel(zerolog.Level(LogLevel))
+	return logger, closeFn
+}
+
+// rotateLogFile rotates the log file every interval until done is closed. The file is rotated by its size in between.
+func rotateLogFile(file *lumberjack.Logger, interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := file.Rotate(); err != nil {
+				// the logger writes to the file which can't be rotated
+				os.Stderr.WriteString("failed to rotate the log file: " + err.Error() + "\n")
+			}
+		}
+	}
+}
+
+// logFields are the fields of a log event, like logFields{"event_id": event.ID}
+type logFields map[string]any
+
+// eventLogger is how the application logs. Every event is written by a single call, unlike the zerolog events which
+// are dropped silently when they aren't ended by Msg or Send, like app.log.Error().Err(err).
+type eventLogger interface {
+	Debug(msg string, fields logFields)
+	Info(msg string, fields logFields)
+	Warn(msg string, fields logFields)
+	Error(err error, msg string, fields logFields)
+	// Fatal exits the process once the event is written
+	Fatal(err error, msg string, fields logFields)
+}
+
+// zerologEvents writes the events by the zerolog logger, so they're sampled and leveled by its settings
+type zerologEvents struct {
+	log *zerolog.Logger
+}
+
+// logEvents returns the eventLogger writing to the logger
+func logEvents(log *zerolog.Logger) eventLogger {
+	return zerologEvents{log: log}
+}
+
+// events returns the eventLogger of the request or the background job of the context. check logger
+func (app *application) events(ctx context.Context) eventLogger {
+	return logEvents(app.logger(ctx))
+}
+
+func (l zerologEvents) Debug(msg string, fields logFields) {
+	l.log.Debug().Fields(map[string]any(fields)).Msg(msg)
+}
+
+func (l zerologEvents) Info(msg string, fields logFields) {
+	l.log.Info().Fields(map[string]any(fields)).Msg(msg)
+}
+
+func (l zerologEvents) Warn(msg string, fields logFields) {
+	l.log.Warn().Fields(map[string]any(fields)).Msg(msg)
+}
+
+func (l zerologEvents) Error(err error, msg string, fields logFields) {
+	l.log.Error().Err(err).Fields(map[string]any(fields)).Msg(msg)
+}
+
+func (l zerologEvents) Fatal(err error, msg string, fields logFields) {
+	l.log.Fatal().Err(err).Fields(map[string]any(fields)).Msg(msg)
+}
@@ -0,0 +1,126 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewLoggerFileAndWarnSampling(t *testing.T) {
+	defer func(file, format string, level int8, burst uint32, period time.Duration, size int) {
+		LogFile, LogFormat, LogLevel, LogWarnBurst, LogWarnSamplePeriod, LogFileMaxSize = file, format, level, burst, period, size
+		zerolog.SetGlobalLevel(zerolog.Level(level))
+	}(LogFile, LogFormat, LogLevel, LogWarnBurst, LogWarnSamplePeriod, LogFileMaxSize)
+	LogFile = filepath.Join(t.TempDir(), "greenlight.log")
+	LogFormat, LogLevel, LogWarnBurst, LogWarnSamplePeriod, LogFileMaxSize = LogFormatJSON, int8(zerolog.InfoLevel), 2, time.Hour, 1
+
+	logger, closeLog := newLogger()
+	for range 5 {
+		logger.Warn().Str("event", "ip_rejected").Msg("request rejected by the ip filter")
+	}
+	// the other levels aren't sampled
+	for range 3 {
+		logger.Error().Msg("server error")
+	}
+	require.NoError(t, closeLog())
+
+	b, err := os.ReadFile(LogFile)
+	require.NoError(t, err)
+	assert.Equal(t, 2, bytes.Count(b, []byte("ip_rejected")))
+	assert.Equal(t, 3, bytes.Count(b, []byte("server error")))
+}
+
+func TestEventLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := zerolog.New(&buf)
+	events := logEvents(&logger)
+
+	events.Error(errors.New("connection refused"), "failed to purge the movie from the cdn", logFields{"movie_id": int64(7)})
+	events.Warn("request rejected by the ip filter", logFields{"event": "ip_rejected"})
+	events.Info("seeding the catalog", nil)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 3)
+	assert.JSONEq(t, `{"level":"error","error":"connection refused","movie_id":7,"message":"failed to purge the movie from the cdn"}`, lines[0])
+	assert.JSONEq(t, `{"level":"warn","event":"ip_rejected","message":"request rejected by the ip filter"}`, lines[1])
+	assert.JSONEq(t, `{"level":"info","message":"seeding the catalog"}`, lines[2])
+}
+
+func TestCloseLogStopsRotation(t *testing.T) {
+	defer func(file string, interval time.Duration) {
+		LogFile, LogFileRotateInterval = file, interval
+	}(LogFile, LogFileRotateInterval)
+	LogFile, LogFileRotateInterval = filepath.Join(t.TempDir(), "greenlight.log"), time.Millisecond
+
+	before := runtime.NumGoroutine()
+	_, closeLog := newLogger()
+	require.NoError(t, closeLog())
+	assert.LessOrEqual(t, runtime.NumGoroutine(), before)
+}
+
+// TestLogEventsAreSent fails on the zerolog events which are never sent, like app.log.Error().Err(err) without
+// Msg, Msgf or Send. zerolog drops them silently. It keeps the few chains which don't go through eventLogger honest.
+func TestLogEventsAreSent(t *testing.T) {
+	levels := map[string]bool{"Trace": true, "Debug": true, "Info": true, "Warn": true, "Error": true, "Fatal": true, "Panic": true, "Err": true, "WithLevel": true, "Log": true}
+	senders := map[string]bool{"Msg": true, "Msgf": true, "MsgFunc": true, "Send": true}
+
+	fset := token.NewFileSet()
+	for _, root := range []string{"..", "../../internal"} {
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() || !strings.HasSuffix(path, ".go") || strings.HasSuffix(path, "_test.go") {
+				return err
+			}
+			file, err := parser.ParseFile(fset, path, nil, 0)
+			if err != nil {
+				return err
+			}
+			ast.Inspect(file, func(n ast.Node) bool {
+				stmt, ok := n.(*ast.ExprStmt)
+				if !ok {
+					return true
+				}
+				call, ok := stmt.X.(*ast.CallExpr)
+				if !ok {
+					return true
+				}
+				sel, ok := call.Fun.(*ast.SelectorExpr)
+				if !ok || senders[sel.Sel.Name] {
+					return true
+				}
+				// the chain is an event when it starts by a level method without arguments, like Error()
+				for expr := ast.Expr(call); ; {
+					c, ok := expr.(*ast.CallExpr)
+					if !ok {
+						return true
+					}
+					s, ok := c.Fun.(*ast.SelectorExpr)
+					if !ok {
+						return true
+					}
+					if levels[s.Sel.Name] && len(c.Args) == 0 {
+						t.Errorf("%s: the log event is never sent, end it by Msg, Msgf or Send", fset.Position(stmt.Pos()))
+						return true
+					}
+					expr = s.X
+				}
+			})
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
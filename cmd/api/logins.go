@@ -0,0 +1,151 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/cybrarymin/greenlight/internal/data"
+	mailer "github.com/cybrarymin/greenlight/internal/mailter"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// newSignInMailData is the data of new_signin.tpl mail template
+type newSignInMailData struct {
+	Name        string
+	Time        string
+	RemoteAddr  string
+	UserAgent   string
+	EventID     int64
+	RevokeToken string
+}
+
+// recordLogin captures the session metadata of a token issuance. Logins from a new address or user agent
+// are emitted as login_anomaly events and the user is notified by an email carrying the revoke link.
+// Failing to record the login doesn't fail the token issuance.
+func (app *application) recordLogin(ctx context.Context, r *http.Request, user *data.User, tokenType string) {
+	event := &data.LoginEvent{
+		UserID:     user.ID,
+		TokenType:  tokenType,
+		RemoteAddr: clientIP(r),
+		UserAgent:  r.UserAgent(),
+	}
+	err := app.models.LoginEvents.Record(ctx, event)
+	if err != nil {
+		app.events(ctx).Error(err, "failed to record the login event", logFields{"user": user.Email})
+		return
+	}
+	if !event.Anomalous() {
+		return
+	}
+
+	app.events(ctx).Warn("sign-in from a new address or user agent", logFields{
+		"event":           "login_anomaly",
+		"login_event_id":  event.ID,
+		"user":            user.Email,
+		"token_type":      event.TokenType,
+		"remote_addr":     event.RemoteAddr,
+		"user_agent":      event.UserAgent,
+		"new_remote_addr": event.NewRemoteAddr,
+		"new_user_agent":  event.NewUserAgent,
+	})
+
+	app.sendMailInBackground(ctx, mailer.MailMessage{
+		To:           []string{user.Email},
+		TemplateFile: "new_signin.tpl",
+		Data: newSignInMailData{
+			Name:        user.Name,
+			Time:        event.CreatedAt.UTC().Format(time.RFC1123),
+			RemoteAddr:  event.RemoteAddr,
+			UserAgent:   event.UserAgent,
+			EventID:     event.ID,
+			RevokeToken: event.RevokeToken,
+		},
+	}, "panic happened during sending the new sign-in email")
+}
+
+// revokeLoginHandler is the target of the revoke link in the new sign-in email.
+// It's authenticated by the revoke token and signs the user out of every bearer token session.
+func (app *application) revokeLoginHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := app.startSpan(r, "revokeLogin")
+	defer span.End()
+
+	id := app.idParam(r, "id")
+	token := app.readString(r.URL.Query(), "token", "")
+	nValidator := data.NewValidator()
+	data.ValidateTokenPlaintext(nValidator, token)
+	if !nValidator.Valid() {
+		span.RecordError(errors.New(createKeyValuePairs(nValidator.Errors)))
+		span.SetStatus(codes.Error, otelunprocessableErr)
+		app.failedValidationResponse(w, r, nValidator)
+		return
+	}
+
+	err := app.models.LoginEvents.Revoke(ctx, id, token)
+	if err != nil {
+		span.RecordError(err)
+		switch {
+		case errors.Is(err, data.ErrorRecordNotFound):
+			span.SetStatus(codes.Ok, otelDBNotFoundInfo)
+			app.notFoundResponse(w, r)
+		default:
+			span.SetStatus(codes.Error, otelDBErr)
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	app.events(r.Context()).Warn("sign-in revoked by the user", logFields{"event": "login_revoked", "login_event_id": id})
+
+	err = app.writeJson(w, r, http.StatusOK, envelope{"message": "sign-in revoked and all the sessions are signed out"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listLoginEventsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := app.startSpan(r, "listLoginEvents")
+	defer span.End()
+
+	var input struct {
+		UserID    uuid.UUID
+		Anomalous bool
+		data.Filters
+	}
+	nValidator := data.NewValidator()
+	qs := r.URL.Query()
+	input.UserID = app.readUUIDQuery(qs, "user_id", nValidator)
+	input.Anomalous = app.readBool(qs, "anomalous", false, nValidator)
+	input.Filters.Page = app.readInt(qs, "page", 1, nValidator)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, nValidator)
+	input.Filters.Sort = app.readString(qs, "sort", "-id")
+	input.Filters.Count = app.readString(qs, "count", data.CountExact)
+	input.Filters.SortSafeList = []string{"id", "created_at", "remote_addr", "-id", "-created_at", "-remote_addr"}
+	input.Filters.ValidateFilters(nValidator)
+	if !nValidator.Valid() {
+		span.RecordError(errors.New(createKeyValuePairs(nValidator.Errors)))
+		span.SetStatus(codes.Error, otelunprocessableErr)
+		app.failedValidationResponse(w, r, nValidator)
+		return
+	}
+
+	events, count, err := app.models.LoginEvents.List(ctx, input.UserID, input.Anomalous, &input.Filters)
+	if err != nil {
+		span.RecordError(err)
+		switch {
+		case errors.Is(err, data.ErrorRecordNotFound):
+			span.SetStatus(codes.Ok, otelDBNotFoundInfo)
+		default:
+			span.SetStatus(codes.Error, otelDBErr)
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	pMeta := input.Filters.PaginationMetaData(ctx, count)
+	err = app.writeJson(w, r, http.StatusOK, envelope{"Metadata": pMeta, "Logins": events}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cybrarymin/greenlight/internal/data"
+	mailer "github.com/cybrarymin/greenlight/internal/mailter"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordLogin(t *testing.T) {
+	user := &data.User{ID: uuid.New(), Name: "john", Email: "john@example.com"}
+	// the logins of the user are serialized by a lock held until the login is stored
+	lock := stub(`^SELECT pg_advisory_xact_lock\(hashtext\('login_events:`+user.ID.String()+`'\)\)$`, nil)
+	seen := func(total int64, remoteAddr, userAgent bool) stubQuery {
+		return stub(`^SELECT COUNT\(\*\) AS total, COALESCE\(BOOL_OR\(remote_addr = '192\.0\.2\.1'\), FALSE\) AS remote_addr, `+
+			`COALESCE\(BOOL_OR\(user_agent = 'greenlight-cli/1\.0'\), FALSE\) AS user_agent FROM "login_events" AS "login_event" WHERE \(user_id = '`+user.ID.String()+`'\)`,
+			[]string{"total", "remote_addr", "user_agent"}, []driver.Value{total, remoteAddr, userAgent})
+	}
+	insert := func(newRemoteAddr, newUserAgent string) stubQuery {
+		return stub(`^INSERT INTO "login_events" .* VALUES \(DEFAULT, '`+user.ID.String()+`', 'authentication', '192\.0\.2\.1', 'greenlight-cli/1\.0', `+
+			newRemoteAddr+`, `+newUserAgent+`, .* RETURNING id, created_at`, []string{"id", "created_at"}, []driver.Value{int64(42), time.Now()})
+	}
+
+	tests := []struct {
+		name    string
+		queries []stubQuery
+		mailed  bool
+	}{
+		{name: "First login", queries: []stubQuery{lock, seen(0, false, false), insert("FALSE", "FALSE")}},
+		{name: "Known device", queries: []stubQuery{lock, seen(3, true, true), insert("FALSE", "FALSE")}},
+		{name: "New address", queries: []stubQuery{lock, seen(3, false, true), insert("TRUE", "FALSE")}, mailed: true},
+		{name: "New user agent", queries: []stubQuery{lock, seen(3, true, false), insert("FALSE", "TRUE")}, mailed: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var mails bytes.Buffer
+			nMailer, err := mailer.New(mailer.Config{Sender: "no-reply@greenlight.com", MaxInFlight: 1, LogWriter: &mails})
+			require.NoError(t, err)
+			logger := zerolog.Nop()
+			app := &application{log: &logger, mailer: nMailer, models: stubModels(t, tc.queries...)}
+			r := httptest.NewRequest(http.MethodPost, "/v1/tokens/authentication", nil)
+			r.RemoteAddr = "192.0.2.1:52000"
+			r.Header.Set("User-Agent", "greenlight-cli/1.0")
+
+			app.recordLogin(context.Background(), r, user, "authentication")
+			app.wg.Wait()
+			if !tc.mailed {
+				assert.Empty(t, mails.String())
+				return
+			}
+			assert.Contains(t, mails.String(), "john@example.com")
+			assert.Contains(t, mails.String(), "192.0.2.1")
+			assert.Contains(t, mails.String(), "/v1/logins/42/revoke?token=", "the email carries the revoke link")
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+
+	mailer "github.com/cybrarymin/greenlight/internal/mailter"
+)
+
+var (
+	// MailMaxRetries is the number of retries after the first attempt of the emails sent in the background
+	MailMaxRetries int
+	// MailRetryBackoff is the base delay between the retries of an email. it doubles on each retry with added jitter
+	MailRetryBackoff time.Duration
+	// MailMaxPending is the number of background emails which can be sending or waiting for a retry at once.
+	// The emails beyond it are dropped, so a failing smtp server doesn't pile up the goroutines. zero means no limit
+	MailMaxPending int64
+)
+
+// mailMaxRetryBackoff caps the delay between the retries of an email
+const mailMaxRetryBackoff = time.Minute
+
+// errMailShutdown is returned for the emails whose retries are abandoned by the shutdown
+var errMailShutdown = errors.New("the server is shutting down")
+
+// sendMailInBackground sends the email in a background job retrying the failed attempts up to MailMaxRetries.
+// The email outlives the request, so only the values of ctx are kept for the logs and the traces, not its cancellation.
+// The waits between the retries are cut short by the shutdown, which is then only held up by the attempts in flight.
+func (app *application) sendMailInBackground(ctx context.Context, message mailer.MailMessage, panicErrMsg string) {
+	ctx = context.WithoutCancel(ctx)
+	if MailMaxPending > 0 && app.pendingMails.Add(1) > MailMaxPending {
+		app.pendingMails.Add(-1)
+		app.events(ctx).Error(nil, "dropped the email as too many emails are pending", logFields{"to": message.To, "template": message.TemplateFile})
+		return
+	}
+	app.BackgroundJob(func() {
+		if MailMaxPending > 0 {
+			defer app.pendingMails.Add(-1)
+		}
+		err := app.sendMailWithRetries(ctx, message)
+		if err != nil {
+			app.events(ctx).Error(err, "failed to send the email", logFields{"to": message.To, "template": message.TemplateFile})
+		}
+	}, panicErrMsg)
+}
+
+// sendMailWithRetries sends the email retrying the transient failures with a jittered exponential backoff.
+// check sendMailInBackground
+func (app *application) sendMailWithRetries(ctx context.Context, message mailer.MailMessage) error {
+	var err error
+	for retry := 0; ; retry++ {
+		err = app.mailer.Send(ctx, message)
+		if err == nil || !retryableMailErr(err) || retry >= MailMaxRetries {
+			return err
+		}
+		backoff := mailBackoff(retry + 1)
+		app.events(ctx).Warn("retrying the email", logFields{"error": err, "to": message.To, "retry": retry + 1, "backoff": backoff})
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-app.stopBackground:
+			timer.Stop()
+			return errors.Join(err, errMailShutdown)
+		}
+	}
+}
+
+// retryableMailErr tells the failures which won't go away by retrying the same email
+func retryableMailErr(err error) bool {
+	return !errors.Is(err, mailer.ErrTemplateNotFound) &&
+		!errors.Is(err, mailer.ErrNoRecipient) &&
+		!errors.Is(err, mailer.ErrAttachmentTooLarge)
+}
+
+// mailBackoff returns the delay before the retry of an email. It doubles on every retry starting from MailRetryBackoff,
+// up to mailMaxRetryBackoff, and it's jittered by half of it so the emails failed together aren't retried together.
+func mailBackoff(retry int) time.Duration {
+	d := MailRetryBackoff
+	for i := 1; i < retry && d < mailMaxRetryBackoff; i++ {
+		d *= 2
+	}
+	return jitter(min(d, mailMaxRetryBackoff))
+}
+
+// jitter spreads the delay randomly between half and one and a half of it
+func jitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d)))
+}
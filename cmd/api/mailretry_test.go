@@ -0,0 +1,90 @@
+package api
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	mailer "github.com/cybrarymin/greenlight/internal/mailter"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMailBackoff(t *testing.T) {
+	defer func(backoff time.Duration) { MailRetryBackoff = backoff }(MailRetryBackoff)
+	MailRetryBackoff = 2 * time.Second
+
+	tests := []struct {
+		name  string
+		retry int
+		base  time.Duration
+	}{
+		{name: "First retry", retry: 1, base: 2 * time.Second},
+		{name: "Third retry", retry: 3, base: 8 * time.Second},
+		{name: "Capped", retry: 20, base: mailMaxRetryBackoff},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			for i := 0; i < 100; i++ {
+				backoff := mailBackoff(tc.retry)
+				assert.GreaterOrEqual(t, backoff, tc.base/2)
+				assert.Less(t, backoff, tc.base*3/2)
+			}
+		})
+	}
+}
+
+func TestSendMailWithRetries(t *testing.T) {
+	defer func(retries int, backoff time.Duration) {
+		MailMaxRetries, MailRetryBackoff = retries, backoff
+	}(MailMaxRetries, MailRetryBackoff)
+
+	invitation := mailer.MailMessage{
+		To:           []string{"john@example.com"},
+		TemplateFile: "registration_invitation.tpl",
+		Data:         registrationInvitationMailData{Token: "token", Expiry: "never"},
+	}
+
+	tests := []struct {
+		name       string
+		message    mailer.MailMessage
+		maxRetries int
+		backoff    time.Duration
+		// shutdown closes the stop channel of the background jobs while the email is waiting for its retry
+		shutdown     bool
+		wantAttempts int64
+		wantErr      error
+	}{
+		{name: "Retried up to the limit", message: invitation, maxRetries: 2, backoff: time.Millisecond, wantAttempts: 3},
+		{name: "Not retried", message: invitation, maxRetries: 0, backoff: time.Millisecond, wantAttempts: 1},
+		{name: "Permanent failure", message: mailer.MailMessage{TemplateFile: "registration_invitation.tpl"}, maxRetries: 2, backoff: time.Millisecond, wantAttempts: 0, wantErr: mailer.ErrNoRecipient},
+		{name: "Abandoned by the shutdown", message: invitation, maxRetries: 2, backoff: time.Hour, shutdown: true, wantAttempts: 1, wantErr: errMailShutdown},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			MailMaxRetries, MailRetryBackoff = tc.maxRetries, tc.backoff
+			// nothing listens on the port, so every attempt fails
+			nMailer, err := mailer.New(mailer.Config{Host: "127.0.0.1", Port: 1, Sender: "no-reply@greenlight.com", MaxInFlight: 1})
+			require.NoError(t, err)
+			var attempts atomic.Int64
+			nMailer.OnSend = func(mailer.SendResult) { attempts.Add(1) }
+
+			logger := zerolog.Nop()
+			app := &application{log: &logger, mailer: nMailer, stopBackground: make(chan struct{})}
+			if tc.shutdown {
+				close(app.stopBackground)
+			}
+
+			err = app.sendMailWithRetries(context.Background(), tc.message)
+			require.Error(t, err)
+			if tc.wantErr != nil {
+				assert.ErrorIs(t, err, tc.wantErr)
+			}
+			assert.Equal(t, tc.wantAttempts, attempts.Load())
+		})
+	}
+}
@@ -2,19 +2,26 @@ package api
 
 import (
 	"context"
+	"database/sql/driver"
+	"errors"
 	"fmt"
-	"log"
+	"io"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/cybrarymin/greenlight/internal/data"
+	"github.com/cybrarymin/greenlight/internal/httpclient"
 	mailer "github.com/cybrarymin/greenlight/internal/mailter"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
 	"github.com/rs/zerolog"
-	"github.com/rs/zerolog/pkgerrors"
 	"github.com/uptrace/bun"
 	"github.com/uptrace/bun/dialect/pgdialect"
 	"github.com/uptrace/bun/driver/pgdriver"
@@ -27,23 +34,33 @@ var Version = "local"
 var BuildTime string
 
 var (
-	ListenPort           int
-	Env                  string
-	DBDSN                string
-	DBMaxConnCount       int
-	DBMaxIdleConnCount   int
-	DBMaxIdleConnTimeout time.Duration
-	LogLevel             int8
-	DBLogs               bool
-	GlobalRateLimit      int64
-	PerClientRateLimit   int64
-	EnableRateLimit      bool
-	SMTPServer           string
-	SMTPPort             int
-	SMTPUserName         string
-	SMTPPassword         string
-	EmailSender          string
-	VersionDisplay       bool
+	ListenPort            int
+	Env                   string
+	DBDSN                 string
+	DBMaxConnCount        int
+	DBMaxIdleConnCount    int
+	DBMaxIdleConnTimeout  time.Duration
+	LogLevel              int8
+	DBLogs                bool
+	GlobalRateLimit       int64
+	PerClientRateLimit    int64
+	AnonymousRateLimit    int64
+	EnableRateLimit       bool
+	SMTPServer            string
+	SMTPPort              int
+	SMTPUserName          string
+	SMTPPassword          string
+	EmailSender           string
+	MailTemplateDir       string
+	SMTPMaxInFlight       int
+	SMTPIdleTimeout       time.Duration
+	SMTPMaxAttachment     int
+	VersionDisplay        bool
+	CORSTrustedOrigins    []string
+	MaintenanceMode       bool
+	EmailDomainAllowlist  []string
+	EmailDomainDenylist   []string
+	BlockDisposableEmails bool
 )
 
 type config struct {
@@ -51,6 +68,7 @@ type config struct {
 	env  string
 	db   struct {
 		dbDsn                string
+		driver               string
 		dbMaxConnCount       int
 		DBMaxIdleConnCount   int
 		DBMaxIdleConnTimeout time.Duration
@@ -62,11 +80,18 @@ type config struct {
 		enabled            bool
 	}
 	smtp struct {
-		SMTPServer   string
-		SMTPPort     int
-		SMTPUserName string
-		SMTPPassword string
-		EmailSender  string
+		SMTPServer    string
+		SMTPPort      int
+		SMTPUserName  string
+		SMTPPassword  string
+		EmailSender   string
+		TemplateDir   string
+		MaxInFlight   int
+		IdleTimeout   time.Duration
+		MaxAttachment int
+	}
+	cors struct {
+		trustedOrigins []string
 	}
 }
 
@@ -75,29 +100,58 @@ type application struct {
 	log    *zerolog.Logger
 	models *data.Models
 	mailer *mailer.Mailer
-	wg     sync.WaitGroup
+	// httpClient should be used for all the outgoing calls to the external integrations
+	httpClient *httpclient.Client
+	wg         sync.WaitGroup
+	// runtime holds the settings which can be changed through the admin api without restarting the server.
+	runtime   atomic.Pointer[runtimeConfig]
+	runtimeMu sync.Mutex // serializes the writers of runtime
+	// sitemap is nil when the public base url isn't configured
+	sitemap *sitemap
+	// hub pushes the live events to the admin dashboards connected to the websocket
+	hub      *eventHub
+	counters liveCounters
+	// panicAlerts throttles the alerts of the recovered panics. check PanicAlertWebhook
+	panicAlerts panicAlerts
+	// activationAttempts locks the accounts out of the activation after too many invalid tokens
+	activationAttempts attemptLimiter
+	// draining is set once the server is shutting down
+	draining atomic.Bool
+	// stopBackground is closed once the servers are shut down to cut short the waits of the background jobs
+	stopBackground chan struct{}
+	// pendingMails counts the emails sending in the background. check MailMaxPending
+	pendingMails atomic.Int64
+	// ipFilter is nil when no ip filter is configured. check filterIPs
+	ipFilter *ipFilter
+	// inflight and streams count the requests and the streams of each client. check limitConcurrency
+	inflight *concurrencyLimiter
+	streams  *concurrencyLimiter
+	// routePolicies is nil when no --route-policies file is configured
+	routePolicies *routePolicies
+	// responseSchemas is nil unless --validate-responses is set. check validateResponses
+	responseSchemas *responseSchemas
+	// routeTable lists the registered routes along with the access they require. check routeGroup
+	routeTable []routeInfo
 }
 
 func Api() {
-	var logger zerolog.Logger
-	zerolog.ErrorStackMarshaler = pkgerrors.MarshalStack
-	if zerolog.Level(LogLevel).String() == zerolog.LevelTraceValue {
-		logger = zerolog.New(os.Stdout).With().Stack().Timestamp().Logger().Level(zerolog.Level(LogLevel))
-	} else {
-		logger = zerolog.New(os.Stdout).With().Timestamp().Logger().Level(zerolog.Level(LogLevel))
-	}
+	logger, closeLog := newLogger()
+	defer closeLog()
+	events := logEvents(&logger)
 
 	cfg := config{
 		port: ListenPort,
 		env:  Env,
 		db: struct {
 			dbDsn                string
+			driver               string
 			dbMaxConnCount       int
 			DBMaxIdleConnCount   int
 			DBMaxIdleConnTimeout time.Duration
 			DBLogs               bool
 		}{
 			DBDSN,
+			DBDriver,
 			DBMaxConnCount,
 			DBMaxIdleConnCount,
 			DBMaxIdleConnTimeout,
@@ -113,78 +167,241 @@ func Api() {
 			enabled:            EnableRateLimit,
 		},
 		smtp: struct {
-			SMTPServer   string
-			SMTPPort     int
-			SMTPUserName string
-			SMTPPassword string
-			EmailSender  string
+			SMTPServer    string
+			SMTPPort      int
+			SMTPUserName  string
+			SMTPPassword  string
+			EmailSender   string
+			TemplateDir   string
+			MaxInFlight   int
+			IdleTimeout   time.Duration
+			MaxAttachment int
+		}{
+			SMTPServer:    SMTPServer,
+			SMTPPort:      SMTPPort,
+			SMTPUserName:  SMTPUserName,
+			SMTPPassword:  SMTPPassword,
+			EmailSender:   EmailSender,
+			TemplateDir:   MailTemplateDir,
+			MaxInFlight:   SMTPMaxInFlight,
+			IdleTimeout:   SMTPIdleTimeout,
+			MaxAttachment: SMTPMaxAttachment,
+		},
+		cors: struct {
+			trustedOrigins []string
 		}{
-			SMTPServer:   SMTPServer,
-			SMTPPort:     SMTPPort,
-			SMTPUserName: SMTPUserName,
-			SMTPPassword: SMTPPassword,
-			EmailSender:  EmailSender,
+			trustedOrigins: CORSTrustedOrigins,
 		},
 	}
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
 	defer cancel()
 
-	db, err := openDB(ctx, &cfg)
+	connector, err := newRotatingConnector(&cfg)
 	if err != nil {
-		logger.Fatal().Err(err)
+		events.Fatal(err, "failed to configure the database driver", nil)
+	}
+	db, err := openDB(ctx, connector)
+	if err != nil {
+		events.Fatal(err, "failed to connect to the database", nil)
 	}
 	defer db.Close()
 
+	// the spans of the queries must be started before the other hooks so the query plans are attached to them
+	db.AddQueryHook(data.QueryTracer{})
 	if cfg.db.DBLogs {
 		db.AddQueryHook(bunzerolog.NewQueryHook(
 			bunzerolog.WithLogger(&logger),
 			bunzerolog.WithQueryLogLevel(zerolog.DebugLevel),      // Show database interaction logs by debug tag
 			bunzerolog.WithSlowQueryLogLevel(zerolog.WarnLevel),   // Show database slow queries as warnings tag
 			bunzerolog.WithErrorQueryLogLevel(zerolog.ErrorLevel), // Show database slow queries as error tag
-			bunzerolog.WithSlowQueryThreshold(3*time.Second),
+			bunzerolog.WithSlowQueryThreshold(DBSlowQueryThreshold),
 		))
 	}
+	if DBExplainSampleRate > 0 {
+		db.AddQueryHook(newQueryPlanHook(&logger, DBSlowQueryThreshold, DBExplainSampleRate))
+	}
+
+	var mailLog io.Writer
+	if MailerDriver == MailerLog {
+		mailLog = os.Stdout
+	}
+	nMailer, err := mailer.New(mailer.Config{
+		Host:               cfg.smtp.SMTPServer,
+		Port:               cfg.smtp.SMTPPort,
+		Username:           cfg.smtp.SMTPUserName,
+		Password:           cfg.smtp.SMTPPassword,
+		Sender:             "greenlight <no-reply@greenlight.net>", // TODO: Flags should be provided for the input arguments
+		TemplateDir:        cfg.smtp.TemplateDir,
+		MaxInFlight:        cfg.smtp.MaxInFlight,
+		IdleTimeout:        cfg.smtp.IdleTimeout,
+		MaxAttachmentBytes: cfg.smtp.MaxAttachment,
+		LogWriter:          mailLog,
+	})
+	if err != nil {
+		events.Fatal(err, "failed to load the mail templates", nil)
+	}
 
 	app := &application{
-		config: cfg,
-		log:    &logger,
-		models: data.NewModels(db),
-		mailer: mailer.New(cfg.smtp.SMTPServer, cfg.smtp.SMTPPort, cfg.smtp.SMTPUserName, cfg.smtp.SMTPPassword, "greenlight <no-reply@greenlight.net>"), // TODO: Flags should be provided for the input arguments
-		wg:     sync.WaitGroup{},
+		config:     cfg,
+		log:        &logger,
+		models:     data.NewModels(db),
+		mailer:     nMailer,
+		httpClient: httpclient.New(httpclient.DefaultConfig()),
+		wg:         sync.WaitGroup{},
+		hub:        newEventHub(),
+		inflight:   newConcurrencyLimiter(),
+		streams:    newConcurrencyLimiter(),
+
+		stopBackground: make(chan struct{}),
+	}
+	app.swapRuntimeConfig(newRuntimeConfig(&cfg))
+	app.ipFilter, err = newIPFilter()
+	if err != nil {
+		events.Fatal(err, "failed to configure the ip filter", nil)
+	}
+	app.routePolicies, err = loadRoutePolicies(RoutePoliciesFile)
+	if err != nil {
+		events.Fatal(err, "failed to load the route policies", nil)
+	}
+	if ValidateResponses {
+		app.responseSchemas, err = loadResponseSchemas()
+		if err != nil {
+			events.Fatal(err, "failed to load the response schemas", nil)
+		}
+	}
+	if PublicBaseURL != "" {
+		app.sitemap = newSitemap()
+		go app.runSitemapGenerator()
+	}
+	go app.runLiveCounters()
+	if ChallengeProvider == ChallengeProofOfWork {
+		go app.runPoWChallengePruner()
+	}
+	go app.runOutboxDispatcher()
+	if RetentionInterval > 0 {
+		go app.runRetentionScheduler()
 	}
+	if RatingsAPIKey != "" && RatingsRefreshInterval > 0 {
+		go app.runRatingsScheduler()
+	}
+	if SearchAlertInterval > 0 {
+		go app.runSearchAlertScheduler()
+	}
+	if SecretsBackend != "" && SecretsRefreshInterval > 0 {
+		go app.runSecretsRefresher(db, connector)
+	}
+	if SecretFilesWatchInterval > 0 {
+		go app.runSecretFilesWatcher(db, connector)
+	}
+	app.mailer.OnSend = app.onMailSend
 
-	srv := &http.Server{
-		Addr:         fmt.Sprintf(":%d", cfg.port),
-		Handler:      app.routes(),
-		IdleTimeout:  time.Minute,
-		ErrorLog:     log.New(logger, "", 0),
-		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 30 * time.Second,
+	handler := app.routes()
+	srv, err := newHTTPServer(handler, logger)
+	if err != nil {
+		events.Fatal(err, "failed to configure the http server", nil)
 	}
 
-	promInit(db)
+	if promMetricsEnabled() {
+		promInit(db)
+	}
 	otelShutdown, err := setupOTelSDK(ctx, db)
 	if err != nil {
-		app.log.Error().Err(err)
+		logEvents(app.log).Error(err, "failed to set up opentelemetry", nil)
+	}
+	// metrics must be initialized before the schema check records its result
+	app.logSchemaDrift(context.Background())
+
+	// the listeners are inherited from systemd or from the old process of a graceful restart when there are any
+	inherited, err := inheritedListeners()
+	if err != nil {
+		events.Fatal(err, "failed to inherit the listeners", nil)
+	}
+	listeners := make(map[string]net.Listener)
+	listeners[listenerHTTP], err = listen(inherited, listenerHTTP, cfg.port)
+	if err != nil {
+		events.Fatal(err, "failed to listen on the http port", nil)
+	}
+
+	// the new process of a graceful restart takes over only once it's ready, the old one keeps serving until then
+	parentPID := restartParent()
+	if parentPID != 0 {
+		logEvents(app.log).Info("waiting to get ready before taking over from the old process", logFields{"parent_pid": parentPID})
+		err := app.waitUntilReady(restartReadyTimeout)
+		if err != nil {
+			events.Fatal(err, "giving up the graceful restart", nil)
+		}
+	}
+
+	servers := []*http.Server{srv}
+	if MTLSListenPort != 0 {
+		mtlsSrv, err := newMTLSServer(srv, handler)
+		if err != nil {
+			events.Fatal(err, "failed to configure the mtls listener", nil)
+		}
+		mtlsLn, err := listen(inherited, listenerMTLS, MTLSListenPort)
+		if err != nil {
+			events.Fatal(err, "failed to listen on the mtls port", nil)
+		}
+		listeners[listenerMTLS] = mtlsLn
+		servers = append(servers, mtlsSrv)
+		go func() {
+			logEvents(app.log).Info(fmt.Sprintf("starting the mtls server on port %d .....", MTLSListenPort), nil)
+			err := mtlsSrv.ServeTLS(mtlsLn, TLSCertFile, TLSKeyFile)
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				logEvents(app.log).Fatal(err, "mtls server failed", nil)
+			}
+		}()
 	}
 
 	shutdownErr := make(chan error)
-	go app.gracefulShutdown(srv, shutdownErr, otelShutdown)
+	go app.gracefulShutdown(servers, shutdownErr, otelShutdown)
+	if GracefulRestart {
+		go app.handleRestarts(listeners)
+	}
+	if parentPID != 0 {
+		// systemd has to track this process as the main one before the old one exits, or it stops the whole service
+		err := sdNotify("MAINPID=" + strconv.Itoa(os.Getpid()) + "\nREADY=1")
+		if err != nil {
+			logEvents(app.log).Error(err, "failed to notify systemd of the new main process", nil)
+		}
+		// the old process drains its requests by its graceful shutdown
+		parent, err := os.FindProcess(parentPID)
+		if err == nil {
+			err = parent.Signal(syscall.SIGTERM)
+		}
+		if err != nil {
+			logEvents(app.log).Error(err, "failed to stop the old process", logFields{"parent_pid": parentPID})
+		}
+	}
 
-	app.log.Info().Msg("starting the http server .....")
-	err = srv.ListenAndServe()
-	if err != nil {
-		app.log.Error().Err(err)
+	if parentPID == 0 {
+		err = sdNotify("READY=1")
+		if err != nil {
+			logEvents(app.log).Error(err, "failed to notify systemd of the readiness", nil)
+		}
+	}
+	logEvents(app.log).Info("starting the http server .....", nil)
+	err = srv.Serve(trackedListener{listeners[listenerHTTP]})
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logEvents(app.log).Error(err, "http server failed", nil)
 	}
 
 	err = <-shutdownErr // This channel will block main appliction not to finish until shutdown method return it's errors.
 	if err != nil {
-		app.log.Error().Err(err)
+		logEvents(app.log).Error(err, "graceful shutdown failed", nil)
 	}
 }
 
-func openDB(ctx context.Context, cfg *config) (*bun.DB, error) {
-	sqldb := otelsql.OpenDB(pgdriver.NewConnector(pgdriver.WithDSN(cfg.db.dbDsn)),
+// database drivers. pgx runs every query in a single round trip over the extended protocol without preparing it
+const (
+	DBDriverPg  = "pgdriver"
+	DBDriverPgx = "pgx"
+)
+
+var DBDriver string
+
+func openDB(ctx context.Context, connector driver.Connector) (*bun.DB, error) {
+	sqldb := otelsql.OpenDB(connector,
 		otelsql.WithAttributes(semconv.DBSystemPostgreSQL),
 	)
 	db := bun.NewDB(sqldb, pgdialect.New(), bun.WithDiscardUnknownColumns())
@@ -198,7 +415,20 @@ func openDB(ctx context.Context, cfg *config) (*bun.DB, error) {
 	return db, nil
 }
 
-func (app *application) gracefulShutdown(srv *http.Server, shutdownErr chan error, otelShutdown func(context.Context) error) {
+func dbConnector(cfg *config) (driver.Connector, error) {
+	if cfg.db.driver != DBDriverPgx {
+		return pgdriver.NewConnector(pgdriver.WithDSN(cfg.db.dbDsn)), nil
+	}
+	pgxCfg, err := pgx.ParseConfig(cfg.db.dbDsn)
+	if err != nil {
+		return nil, err
+	}
+	// bun inlines the arguments into the queries, so caching their statements would only fill the caches of the connections
+	pgxCfg.DefaultQueryExecMode = pgx.QueryExecModeExec
+	return stdlib.GetConnector(*pgxCfg), nil
+}
+
+func (app *application) gracefulShutdown(servers []*http.Server, shutdownErr chan error, otelShutdown func(context.Context) error) {
 
 	// Create a channel to redirect signal to it.
 	quit := make(chan os.Signal, 1)
@@ -209,25 +439,40 @@ func (app *application) gracefulShutdown(srv *http.Server, shutdownErr chan erro
 
 	s := <-quit
 	// Log that the signal has been catched.
-	app.log.Info().Msgf("catched signal %s", s.String())
+	logEvents(app.log).Info(fmt.Sprintf("catched signal %s", s.String()), nil)
+	// the load balancers stop sending the requests once the readiness check fails
+	app.draining.Store(true)
+	if ShutdownDrainDelay > 0 {
+		logEvents(app.log).Info(fmt.Sprintf("draining for %s before closing the listeners", ShutdownDrainDelay), nil)
+		// the clients reusing their connections are told to close them on their next response
+		for _, srv := range servers {
+			srv.SetKeepAlivesEnabled(false)
+		}
+		time.Sleep(ShutdownDrainDelay)
+	}
 
 	// Shutdown method is waiting for all the requests to be processed and gracefully shuts down the http server without interrupting any active connection.
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*20)
+	ctx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
 	defer cancel()
-	err := srv.Shutdown(ctx) // Shutdown here will block unitl it shutdown everything. we use channel to read in the main function
-	if err != nil {
-		shutdownErr <- err
+	for _, srv := range servers {
+		err := srv.Shutdown(ctx) // Shutdown here will block unitl it shutdown everything. we use channel to read in the main function
+		if err != nil {
+			shutdownErr <- err
+		}
 	}
 
-	err = otelShutdown(ctx)
+	err := otelShutdown(ctx)
 	if err != nil {
 		shutdownErr <- err
 	}
 
 	// Exit the application with success status code
-	app.log.Info().Msg("waiting for background tasks to finish")
+	// no more background jobs are started by the requests, the waiting ones give up
+	logEvents(app.log).Info("waiting for background tasks to finish", nil)
+	close(app.stopBackground)
 	app.wg.Wait()
+	app.mailer.Close()
 	shutdownErr <- nil
 
-	app.log.Info().Msg("stopped server")
+	logEvents(app.log).Info("stopped server", nil)
 }
@@ -0,0 +1,74 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/cybrarymin/greenlight/internal/data"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// mergeMoviesHandler merges a duplicate movie of the catalog into its canonical movie. The watch progress, the assets,
+// the shares and the short link of the duplicate are moved over and its old id redirects to the canonical movie. check MovieModel.Merge
+func (app *application) mergeMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := app.startSpan(r, "mergeMovies")
+	defer span.End()
+
+	var input struct {
+		DuplicateID int64 `json:"duplicate_id"`
+		CanonicalID int64 `json:"canonical_id"`
+	}
+	err := app.readJson(w, r, &input)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, otelunprocessableErr)
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	nValidator := data.NewValidator()
+	nValidator.CheckCode(input.DuplicateID != 0, "duplicate_id", data.CodeRequired, "must be provided")
+	nValidator.CheckCode(input.CanonicalID != 0, "canonical_id", data.CodeRequired, "must be provided")
+	nValidator.Check(input.DuplicateID >= 0, "duplicate_id", "must be a positive integer")
+	nValidator.Check(input.CanonicalID >= 0, "canonical_id", "must be a positive integer")
+	nValidator.Check(input.DuplicateID == 0 || input.DuplicateID != input.CanonicalID, "canonical_id", "must be different from duplicate_id")
+	if !nValidator.Valid() {
+		span.RecordError(errors.New(createKeyValuePairs(nValidator.Errors)))
+		span.SetStatus(codes.Error, otelunprocessableErr)
+		app.failedValidationResponse(w, r, nValidator)
+		return
+	}
+
+	span.AddEvent("merging the movies", trace.WithAttributes(
+		attribute.Int64("movie.duplicate_id", input.DuplicateID),
+		attribute.Int64("movie.canonical_id", input.CanonicalID),
+	))
+	movie, merge, err := app.models.Movies.Merge(ctx, input.DuplicateID, input.CanonicalID)
+	if err != nil {
+		span.RecordError(err)
+		switch {
+		case errors.Is(err, data.ErrorRecordNotFound):
+			span.SetStatus(codes.Ok, otelDBNotFoundInfo)
+			app.notFoundResponse(w, r)
+		default:
+			span.SetStatus(codes.Error, otelDBErr)
+			app.dbWriteErrorResponse(w, r, err)
+		}
+		return
+	}
+	app.catalogChanged()
+	app.movieChanged(data.ChangeDeleted, merge.DuplicateID, "")
+	app.movieChanged(data.ChangeUpdated, movie.ID, movie.Title)
+	app.events(ctx).Info("movies merged", logFields{
+		"duplicate_id": merge.DuplicateID,
+		"canonical_id": merge.CanonicalID,
+		"actor":        app.GetUserContext(r).Email,
+	})
+
+	err = app.writeJson(w, r, http.StatusOK, envelope{"result": movie, "merge": merge}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
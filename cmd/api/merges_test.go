@@ -0,0 +1,50 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMergeMoviesValidation(t *testing.T) {
+	defer func(bodySize int64) { MaxBodySize = bodySize }(MaxBodySize)
+	MaxBodySize = 1 << 20
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		field  string
+	}{
+		{name: "Malformed body", body: `{"duplicate_id":"2"}`, status: http.StatusBadRequest},
+		{name: "Missing duplicate", body: `{"canonical_id":1}`, status: http.StatusUnprocessableEntity, field: "duplicate_id"},
+		{name: "Missing canonical", body: `{"duplicate_id":2}`, status: http.StatusUnprocessableEntity, field: "canonical_id"},
+		{name: "Negative id", body: `{"duplicate_id":-2,"canonical_id":1}`, status: http.StatusUnprocessableEntity, field: "duplicate_id"},
+		{name: "Merged into itself", body: `{"duplicate_id":2,"canonical_id":2}`, status: http.StatusUnprocessableEntity, field: "canonical_id"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			logger := zerolog.Nop()
+			app := &application{log: &logger}
+			rec := httptest.NewRecorder()
+			app.mergeMoviesHandler(rec, httptest.NewRequest(http.MethodPost, "/v1/admin/movies/merge", strings.NewReader(tc.body)))
+
+			assert.Equal(t, tc.status, rec.Code, rec.Body.String())
+			if tc.field == "" {
+				return
+			}
+			var body struct {
+				Error map[string]string `json:"error"`
+			}
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+			assert.Contains(t, body.Error, tc.field)
+		})
+	}
+}
@@ -1,10 +1,30 @@
 package api
 
 import (
+	"context"
+	"strconv"
 	"time"
 
+	"github.com/cybrarymin/greenlight/internal/data"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/uptrace/bun"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// metric export modes. prometheus exposes the metrics on /metrics, otlp pushes them to the otlp metric endpoint
+const (
+	MetricsExportPrometheus = "prometheus"
+	MetricsExportOTLP       = "otlp"
+	MetricsExportBoth       = "both"
+)
+
+var (
+	MetricsExportMode        string
+	MetricsBasicAuthUsername string
+	MetricsBasicAuthPassword string
+	MetricsBearerToken       string
 )
 
 var (
@@ -43,35 +63,260 @@ var (
 		Help:      "Application binary version",
 	}, []string{"version"})
 
-	promDbStatus = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	promBuildInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "build_info",
+		Help: "Build of the binary, always 1",
+	}, []string{"version", "revision", "go_version", "build_time"})
+
+	promMailSendDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "mail",
+		Name:      "send_duration_seconds",
+		Help:      "Duration of email sends.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"status"})
+
+	promPanicsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "panics_total",
+		Help: "Number of panics recovered while serving the requests by route",
+	}, []string{"route"})
+
+	promRetentionRows = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "retention_rows_total",
+		Help: "Number of rows anonymized or deleted by the data retention, or which would be in dry run",
+	}, []string{"target", "action", "dry_run"})
+
+	promIPRejections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ip_rejections_total",
+		Help: "Number of requests rejected by the ip filter by reason",
+	}, []string{"reason"})
+
+	promServerErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_server_errors_total",
+		Help: "Number of 5xx responses by route",
+	}, []string{"route"})
+
+	promMalformedRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "malformed_requests_total",
+		Help: "Number of requests closed before they're complete, sent too slowly or rejected as malformed by reason",
+	}, []string{"reason"})
+
+	promDbSchemaStatus = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Namespace: "database",
-		Name:      "connection_status",
-	}, []string{"type"})
+		Name:      "schema_status",
+		Help:      "Migration version and drift of the database schema as of the last schema check",
+	}, []string{"stat_name"})
 )
 
+// recordMailSend records the duration and the result of an email send for the enabled metric exporters
+func recordMailSend(duration time.Duration, err error) {
+	status := "success"
+	if err != nil {
+		status = "failure"
+	}
+	if promMetricsEnabled() {
+		promMailSendDuration.WithLabelValues(status).Observe(duration.Seconds())
+	}
+	if otelMetricsEnabled() {
+		otelMetricMailSendDuration.Record(context.Background(), float64(duration)/float64(time.Millisecond),
+			metric.WithAttributes(attribute.String("status", status)),
+		)
+	}
+}
+
+// recordPanic counts a panic recovered while serving the route for the enabled metric exporters
+func recordPanic(ctx context.Context, route string) {
+	if promMetricsEnabled() {
+		promPanicsTotal.WithLabelValues(route).Inc()
+	}
+	if otelMetricsEnabled() {
+		otelMetricPanicsTotal.Add(ctx, 1, metric.WithAttributes(attribute.String("route", route)))
+	}
+}
+
+// recordServerError counts a 5xx response of the route for the enabled metric exporters
+func recordServerError(ctx context.Context, route string) {
+	if promMetricsEnabled() {
+		promServerErrors.WithLabelValues(route).Inc()
+	}
+	if otelMetricsEnabled() {
+		otelMetricServerErrors.Add(ctx, 1, metric.WithAttributes(attribute.String("route", route)))
+	}
+}
+
+// recordRetention counts the rows affected by the data retention for the enabled metric exporters
+func recordRetention(ctx context.Context, target string, action string, dryRun bool, rows int64) {
+	if promMetricsEnabled() {
+		promRetentionRows.WithLabelValues(target, action, strconv.FormatBool(dryRun)).Add(float64(rows))
+	}
+	if otelMetricsEnabled() {
+		otelMetricRetentionRows.Add(ctx, rows, metric.WithAttributes(
+			attribute.String("target", target),
+			attribute.String("action", action),
+			attribute.Bool("dry_run", dryRun),
+		))
+	}
+}
+
+// recordIPRejection counts the requests rejected by the ip filter for the enabled metric exporters
+func recordIPRejection(ctx context.Context, reason string) {
+	if promMetricsEnabled() {
+		promIPRejections.WithLabelValues(reason).Inc()
+	}
+	if otelMetricsEnabled() {
+		otelMetricIPRejections.Add(ctx, 1, metric.WithAttributes(attribute.String("reason", reason)))
+	}
+}
+
+// recordMalformedRequest counts the requests closed before they're complete, sent too slowly or rejected
+// by the server as malformed for the enabled metric exporters
+func recordMalformedRequest(ctx context.Context, reason string) {
+	if promMetricsEnabled() {
+		promMalformedRequests.WithLabelValues(reason).Inc()
+	}
+	if otelMetricsEnabled() {
+		otelMetricMalformedRequests.Add(ctx, 1, metric.WithAttributes(attribute.String("reason", reason)))
+	}
+}
+
+// recordSchemaReport exposes the result of the last schema check for the enabled metric exporters
+func recordSchemaReport(report *data.SchemaReport) {
+	stats := map[string]int64{
+		"ExpectedVersion": int64(report.ExpectedVersion),
+		"Version":         int64(report.Version),
+		"Dirty":           boolToInt64(report.Dirty),
+		"MissingIndexes":  int64(len(report.MissingIndexes)),
+		"Drift":           boolToInt64(report.Drifted()),
+	}
+	for name, value := range stats {
+		if promMetricsEnabled() {
+			promDbSchemaStatus.WithLabelValues(name).Set(float64(value))
+		}
+		if otelMetricsEnabled() {
+			otelMetricDBSchemaStatus.Record(context.Background(), value,
+				metric.WithAttributes(attribute.String("stat_name", name)),
+			)
+		}
+	}
+}
+
+func boolToInt64(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func promMetricsEnabled() bool {
+	return MetricsExportMode != MetricsExportOTLP
+}
+
+func otelMetricsEnabled() bool {
+	return MetricsExportMode != MetricsExportPrometheus
+}
+
 func promInit(db *bun.DB) {
 	prometheus.MustRegister(
 		promHttpTotalRequests,
 		promHttpResponseStatus,
 		promHttpDuration,
 		promApplicationVersion,
-		promDbStatus,
+		promBuildInfo,
+		newDBStatsCollector(db),
 		promHttpTotalResponse,
+		promMailSendDuration,
+		promDbSchemaStatus,
+		promPanicsTotal,
+		promRetentionRows,
+		promIPRejections,
+		promMalformedRequests,
+		promServerErrors,
 	)
-	go func() {
-		for {
-			promDbStatus.WithLabelValues("MaxOpenConnections").Set(float64(db.Stats().MaxOpenConnections))
-			promDbStatus.WithLabelValues("OpenConnections").Set(float64(db.Stats().OpenConnections))
-			promDbStatus.WithLabelValues("Idle").Set(float64(db.Stats().Idle))
-			promDbStatus.WithLabelValues("InUse").Set(float64(db.Stats().InUse))
-			promDbStatus.WithLabelValues("MaxIdleClosed").Set(float64(db.Stats().MaxIdleClosed))
-			promDbStatus.WithLabelValues("MaxIdleTimeClosed").Set(float64(db.Stats().MaxIdleTimeClosed))
-			promDbStatus.WithLabelValues("MaxLifetimeClosed").Set(float64(db.Stats().MaxLifetimeClosed))
-			promDbStatus.WithLabelValues("WaitCount").Set(float64(db.Stats().WaitCount))
-			promDbStatus.WithLabelValues("WaitDuration").Set(float64(db.Stats().WaitDuration))
-			time.Sleep(time.Millisecond * 500)
-		}
-	}()
 
 	promApplicationVersion.WithLabelValues(Version).Set(1)
+	info := readBuildInfo()
+	promBuildInfo.WithLabelValues(info.Version, info.Revision, info.GoVersion, info.BuildTime).Set(1)
+}
+
+// dbStatsCollector reads the connection pool stats of the database when the metrics are scraped,
+// like the otel db_connection_status gauge, instead of polling them in the background.
+type dbStatsCollector struct {
+	db   *bun.DB
+	desc *prometheus.Desc
+}
+
+func newDBStatsCollector(db *bun.DB) *dbStatsCollector {
+	return &dbStatsCollector{
+		db:   db,
+		desc: prometheus.NewDesc("database_connection_status", "", []string{"type"}, nil),
+	}
+}
+
+func (c *dbStatsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *dbStatsCollector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.db.Stats()
+	for name, value := range map[string]float64{
+		"MaxOpenConnections": float64(stats.MaxOpenConnections),
+		"OpenConnections":    float64(stats.OpenConnections),
+		"Idle":               float64(stats.Idle),
+		"InUse":              float64(stats.InUse),
+		"MaxIdleClosed":      float64(stats.MaxIdleClosed),
+		"MaxIdleTimeClosed":  float64(stats.MaxIdleTimeClosed),
+		"MaxLifetimeClosed":  float64(stats.MaxLifetimeClosed),
+		"WaitCount":          float64(stats.WaitCount),
+		"WaitDuration":       float64(stats.WaitDuration),
+	} {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, value, name)
+	}
+}
+
+// observeWithTraceExemplar observes the value and attaches the trace id of the request as an exemplar in case the request is sampled.
+// exemplars are only exposed when the metrics are scraped in OpenMetrics format.
+func observeWithTraceExemplar(ctx context.Context, o prometheus.Observer, value float64) {
+	sc := trace.SpanContextFromContext(ctx)
+	eo, ok := o.(prometheus.ExemplarObserver)
+	if !ok || !sc.IsSampled() {
+		o.Observe(value)
+		return
+	}
+	eo.ObserveWithExemplar(value, prometheus.Labels{"trace_id": sc.TraceID().String()})
+}
+
+// requestStats is the number of the requests served since the server has started, read from the prometheus registry
+type requestStats struct {
+	Total    int64            `json:"total"`
+	ByStatus map[string]int64 `json:"by_status"`
+	Panics   int64            `json:"panics"`
+}
+
+// gatherRequestStats sums the request counters of the prometheus registry. It returns nil when prometheus is disabled.
+func gatherRequestStats() (*requestStats, error) {
+	if !promMetricsEnabled() {
+		return nil, nil
+	}
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		return nil, err
+	}
+	stats := &requestStats{ByStatus: map[string]int64{}}
+	for _, family := range families {
+		for _, m := range family.GetMetric() {
+			value := int64(m.GetCounter().GetValue())
+			switch family.GetName() {
+			case "http_requests_total":
+				stats.Total += value
+			case "panics_total":
+				stats.Panics += value
+			case "http_response_status_total":
+				for _, label := range m.GetLabel() {
+					if label.GetName() == "code" {
+						stats.ByStatus[label.GetValue()] += value
+					}
+				}
+			}
+		}
+	}
+	return stats, nil
 }
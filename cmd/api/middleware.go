@@ -1,23 +1,23 @@
 package api
 
 import (
-	"context"
+	"crypto/subtle"
 	"errors"
 	"fmt"
 	"net"
 	"net/http"
 	"runtime/debug"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/cybrarymin/greenlight/internal/data"
+	"github.com/cybrarymin/greenlight/internal/paseto"
 	"github.com/felixge/httpsnoop"
-	"github.com/golang-jwt/jwt/v5"
-	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog"
 	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
-	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
 	"go.opentelemetry.io/otel/metric"
@@ -38,77 +38,179 @@ func (app *application) PanicRecovery(next http.Handler) http.Handler {
 			if panicErr := recover(); panicErr != nil {
 				// Setting this header will trigger the HTTP server to close the connection after Panic happended
 				w.Header().Set("Connection", "close")
-				app.serverErrorResponse(w, r, fmt.Errorf("%s, %s", panicErr, debug.Stack()))
+				stack := debug.Stack()
+				app.recoveredPanic(r, panicErr, stack)
+				app.serverErrorResponse(w, r, fmt.Errorf("%s, %s", panicErr, stack))
 			}
 		}()
 		next.ServeHTTP(w, r)
 	})
 }
 
-func (app *application) RateLimit(next http.Handler) http.Handler {
-	if app.config.rateLimit.enabled {
-		// Global rate limiter
-		busrtSize := app.config.rateLimit.globalRateLimit + app.config.rateLimit.globalRateLimit/10
-		nRL := rate.NewLimiter(rate.Limit(app.config.rateLimit.globalRateLimit), int(busrtSize))
-		// Per IP or Per Client rate limiter
-		pcbusrtSize := app.config.rateLimit.perClientRateLimit + app.config.rateLimit.perClientRateLimit/10
-		pcnRL := make(map[string]ClientRateLimiter)
-		mu := sync.RWMutex{}
-		expirationTime := 30 * time.Second
-
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			if !nRL.Allow() { // In this code, whenever we call the Allow() method on the rate limiter exactly one token will be consumed from the bucket. And if there is no token in the bucket left Allow() will return false
-				app.rateLimitExceedResponse(w, r)
-				return
-			}
-			clientAddr, _, err := net.SplitHostPort(r.RemoteAddr)
-			if err != nil {
-				app.serverErrorResponse(w, r, err)
-				return
-			}
-			mu.RLock()
-			if _, found := pcnRL[clientAddr]; !found {
+// clientLimiters keeps a rate limiter per client address. limiters of the clients which haven't sent any
+// request for the expiration time are removed.
+type clientLimiters struct {
+	mu       sync.Mutex
+	limiters map[string]ClientRateLimiter
+	log      *zerolog.Logger
+}
 
-				pcnRL[clientAddr] = ClientRateLimiter{
-					rate.NewLimiter(rate.Limit(app.config.rateLimit.perClientRateLimit), int(pcbusrtSize)),
-					time.NewTimer(expirationTime),
-				}
-				mu.RUnlock()
-
-				go func() {
-					<-pcnRL[clientAddr].LastAccess.C
-					mu.Lock()
-					delete(pcnRL, clientAddr)
-					mu.Unlock()
-				}()
-
-			} else {
-				app.log.Debug().Msgf("renewing client %v expiry of rate limiting context", clientAddr)
-				pcnRL[clientAddr].LastAccess.Reset(expirationTime)
-			}
+func newClientLimiters(log *zerolog.Logger) *clientLimiters {
+	return &clientLimiters{limiters: make(map[string]ClientRateLimiter), log: log}
+}
 
-			mu.RLock()
-			if !pcnRL[clientAddr].Limit.Allow() {
-				app.rateLimitExceedResponse(w, r)
-				return
-			}
-			mu.RUnlock()
+// allow consumes a token from the limiter of the client, creating the limiter with the given limit on the first request
+func (c *clientLimiters) allow(clientAddr string, limit int64) bool {
+	expirationTime := 30 * time.Second
+	c.mu.Lock()
+	if _, found := c.limiters[clientAddr]; !found {
+		c.limiters[clientAddr] = ClientRateLimiter{
+			rate.NewLimiter(rate.Limit(limit), burstSize(limit)),
+			time.NewTimer(expirationTime),
+		}
+		go func(timer *time.Timer) {
+			<-timer.C
+			c.mu.Lock()
+			delete(c.limiters, clientAddr)
+			c.mu.Unlock()
+		}(c.limiters[clientAddr].LastAccess)
 
-			next.ServeHTTP(w, r)
-		})
 	} else {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logEvents(c.log).Debug(fmt.Sprintf("renewing client %v expiry of rate limiting context", clientAddr), nil)
+		c.limiters[clientAddr].LastAccess.Reset(expirationTime)
+	}
+	limiter := c.limiters[clientAddr].Limit
+	c.mu.Unlock()
+
+	syncLimiter(limiter, limit)
+	return limiter.Allow()
+}
+
+func (app *application) RateLimit(next http.Handler) http.Handler {
+	// limits are read from the runtime config on each request, so they can be changed without restarting the server.
+	rc := app.runtimeCfg()
+	// Global rate limiter
+	nRL := rate.NewLimiter(rate.Limit(rc.GlobalRateLimit), burstSize(rc.GlobalRateLimit))
+	// Per IP or Per Client rate limiter
+	pcnRL := newClientLimiters(app.log)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rc := app.runtimeCfg()
+		if !rc.RateLimitEnabled {
 			next.ServeHTTP(w, r)
-		})
+			return
+		}
+		syncLimiter(nRL, rc.GlobalRateLimit)
+		if !nRL.Allow() { // In this code, whenever we call the Allow() method on the rate limiter exactly one token will be consumed from the bucket. And if there is no token in the bucket left Allow() will return false
+			app.rateLimitExceedResponse(w, r)
+			return
+		}
+		clientAddr, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if !pcnRL.allow(clientAddr, rc.PerClientRateLimit) {
+			app.rateLimitExceedResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// burstSize returns the burst of a limiter. 10% of the specified limit will be considered as the burst on top of the limit.
+func burstSize(limit int64) int {
+	return int(limit + limit/10)
+}
+
+// syncLimiter updates the limiter in case the limit has been changed in the runtime config.
+func syncLimiter(l *rate.Limiter, limit int64) {
+	if l.Limit() != rate.Limit(limit) {
+		l.SetLimit(rate.Limit(limit))
+		l.SetBurst(burstSize(limit))
 	}
 }
 
+// maintenanceMode rejects the requests with 503 while the maintenance mode is enabled in the runtime config.
+// The admin api, healthcheck, readiness and metrics remain reachable so the maintenance mode can be switched off again.
+func (app *application) maintenanceMode(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.runtimeCfg().MaintenanceMode && !maintenanceExempt(r.URL.Path) {
+			app.maintenanceModeResponse(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func maintenanceExempt(path string) bool {
+	if version := apiVersionPrefix(path); version != 0 {
+		path = strings.TrimPrefix(path, fmt.Sprintf("/v%d", version))
+		return strings.HasPrefix(path, "/admin/") || path == "/healthcheck" || path == "/version"
+	}
+	return path == "/readyz" || path == "/metrics"
+}
+
 func (app *application) Auth(next http.HandlerFunc) http.HandlerFunc {
+	// requests are accounted once the principal is known
+	next = app.limitUserConcurrency(next)
+	next = app.usageQuota(next)
+	next = app.auditImpersonation(next)
+	next = app.rejectSuspendedUser(next)
+	next = app.rejectPasswordResetRequired(next)
 	return func(w http.ResponseWriter, r *http.Request) {
-		ctx, span := otel.Tracer("auth.handler.tracer").Start(r.Context(), "auth.handler.span")
+		ctx, span := app.startSpan(r, "auth")
 		defer span.End()
 		headerValue := r.Header.Get("Authorization")
 
+		// on the mtls listener the client certificate is the credential of the principal
+		if headerValue == "" && hasClientCertificate(r) {
+			user, err := app.certificateUser(ctx, r)
+			if err != nil {
+				switch {
+				case errors.Is(err, ErrUnmappedCertificate), errors.Is(err, data.ErrorRecordNotFound):
+					span.SetStatus(codes.Error, "client certificate isn't mapped to any user")
+					app.invalidAuthenticationCredResponse(w, r)
+					return
+				default:
+					span.RecordError(err)
+					span.SetStatus(codes.Error, otelDBErr)
+					app.serverErrorResponse(w, r, err)
+					return
+				}
+			}
+			span.AddEvent("authenticated user by client certificate")
+			r = r.WithContext(ctx)
+			r = app.SetUserContext(r, user)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// the web frontend is authenticated by its session cookie. check createSessionHandler
+		if cookie, err := r.Cookie(sessionCookieName); headerValue == "" && CookieSessions && err == nil {
+			user, err := app.sessionUser(ctx, w, cookie.Value)
+			if err != nil {
+				switch {
+				case errors.Is(err, data.ErrorRecordNotFound):
+					span.SetStatus(codes.Error, "invalid or expired session")
+					clearSessionCookie(w)
+					app.invalidAuthenticationCredResponse(w, r)
+					return
+				default:
+					span.RecordError(err)
+					span.SetStatus(codes.Error, otelDBErr)
+					app.serverErrorResponse(w, r, err)
+					return
+				}
+			}
+			span.AddEvent("authenticated user by session cookie")
+			r = r.WithContext(ctx)
+			r = app.SetUserContext(r, user)
+			next.ServeHTTP(w, r)
+			return
+		}
+
 		if headerValue == "" {
 			span.AddEvent("starting request with anonymous user")
 			r = app.SetUserContext(r, data.AnonymousUser)
@@ -125,6 +227,83 @@ func (app *application) Auth(next http.HandlerFunc) http.HandlerFunc {
 		}
 		userToken := headerValues[1]
 
+		// the paseto tokens have the dots of the jwts as well so they're told apart by their header first
+		if strings.HasPrefix(userToken, paseto.V4PublicHeader) {
+			user, scope, err := app.pasetoUser(ctx, userToken)
+			if err != nil {
+				switch {
+				case errors.Is(err, data.ErrorRecordNotFound):
+					span.SetStatus(codes.Error, "Invalid paseto token")
+					app.invalidAuthenticationCredResponse(w, r)
+					return
+				default:
+					span.RecordError(err)
+					span.SetStatus(codes.Error, otelDBErr)
+					app.serverErrorResponse(w, r, err)
+					return
+				}
+			}
+			r = r.WithContext(ctx)
+			r = app.SetUserContext(r, user)
+			r = app.SetTokenScopeContext(r, scope)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// the jwts issued on /v1/tokens are told apart from the impersonation jwts by their audience
+		if strings.Count(userToken, ".") == 2 && !isImpersonationToken(userToken) {
+			user, scope, err := app.jwtUser(ctx, userToken)
+			if err != nil {
+				switch {
+				case errors.Is(err, data.ErrorRecordNotFound):
+					span.SetStatus(codes.Error, "Invalid jwt token")
+					app.invalidAuthenticationCredResponse(w, r)
+					return
+				case errors.Is(err, errInvalidJWT):
+					span.SetStatus(codes.Error, "Invalid jwt token")
+					app.invalidJWTResponse(w, r, err)
+					return
+				default:
+					span.RecordError(err)
+					span.SetStatus(codes.Error, otelDBErr)
+					app.serverErrorResponse(w, r, err)
+					return
+				}
+			}
+			r = r.WithContext(ctx)
+			r = app.SetUserContext(r, user)
+			r = app.SetTokenScopeContext(r, scope)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// support staff authenticate as the impersonated user by an impersonation jwt
+		if strings.Count(userToken, ".") == 2 {
+			user, imp, err := app.impersonatedUser(ctx, userToken)
+			if err != nil {
+				switch {
+				case errors.Is(err, ErrImpersonationNotAllowed), errors.Is(err, data.ErrorRecordNotFound):
+					span.SetStatus(codes.Error, "Invalid impersonation token")
+					app.invalidAuthenticationCredResponse(w, r)
+					return
+				default:
+					span.RecordError(err)
+					span.SetStatus(codes.Error, otelDBErr)
+					app.serverErrorResponse(w, r, err)
+					return
+				}
+			}
+			span.AddEvent("authenticated user by impersonation token", trace.WithAttributes(
+				attribute.String("impersonation.actor", imp.Actor.Email),
+				attribute.String("impersonation.id", imp.ID),
+			))
+			r = r.WithContext(ctx)
+			r = app.SetUserContext(r, user)
+			r = app.SetImpersonationContext(r, imp)
+			next.ServeHTTP(w, r)
+			return
+		}
+
 		nValidator := data.NewValidator()
 		data.ValidateTokenPlaintext(nValidator, userToken)
 		if !nValidator.Valid() {
@@ -153,57 +332,37 @@ func (app *application) Auth(next http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
-func (app *application) JWTAuth(next http.HandlerFunc) http.HandlerFunc {
+// publicCatalog serves the catalog reads to the anonymous clients when the public catalog is enabled. Anonymous
+// requests are limited by the lower anonymous rate limit per client on top of the per client limit. Requests with
+// credentials, the session cookie of the web frontend included, still go through authenticated so the users get the
+// full movie fields.
+func (app *application) publicCatalog(handler http.HandlerFunc, authenticated http.HandlerFunc) http.HandlerFunc {
+	if !PublicCatalog {
+		return authenticated
+	}
+	anonymousRL := newClientLimiters(app.log)
 	return func(w http.ResponseWriter, r *http.Request) {
-		ctx := context.Background()
-		headerValue := r.Header.Get("Authorization")
-		if headerValue == "" {
-			r = app.SetUserContext(r, data.AnonymousUser)
-			next.ServeHTTP(w, r)
-			return
-		}
-
-		headerValues := strings.Split(headerValue, " ")
-		if len(headerValues) != 2 && headerValues[0] != "Bearer" {
-			app.invalidAuthenticationCredResponse(w, r)
+		// responses differ by the credentials so caches shouldn't serve the full fields to the anonymous users
+		w.Header().Add("Vary", "Authorization")
+		w.Header().Add("Vary", "Cookie")
+		if credentialed(r) {
+			authenticated(w, r)
 			return
 		}
-		jToken := headerValues[1]
-		// ParseWithClaims will fetch the token and keystring of the token
-		// It will verify the signature to make sure token is valid
-		// It will verify all the registered claims of jwt.Registered claims
-		verifiedToken, err := jwt.ParseWithClaims(jToken, &customClaims{}, func(t *jwt.Token) (interface{}, error) {
-			return []byte(JWTKEY), nil
-		})
-		if err != nil {
-			switch {
-			case errors.Is(err, jwt.ErrTokenSignatureInvalid):
-				app.invalidJWTTokenSignatureResponse(w, r)
-				return
-			default:
-				app.invalidAuthenticationCredResponse(w, r)
+		rc := app.runtimeCfg()
+		if rc.RateLimitEnabled {
+			clientAddr, _, err := net.SplitHostPort(r.RemoteAddr)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
 				return
 			}
-		}
-		if !verifiedToken.Valid {
-			app.invalidAuthenticationCredResponse(w, r)
-			return
-		}
-
-		user, err := app.models.Users.GetByEmail(verifiedToken.Claims.(*customClaims).Email, ctx)
-		if err != nil {
-			switch {
-			case errors.Is(err, data.ErrorRecordNotFound):
-				app.invalidAuthenticationCredResponse(w, r)
-				return
-			default:
-				app.serverErrorResponse(w, r, err)
+			if !anonymousRL.allow(clientAddr, rc.AnonymousRateLimit) {
+				app.rateLimitExceedResponse(w, r)
 				return
 			}
 		}
-		r = app.SetUserContext(r, user)
-
-		next.ServeHTTP(w, r)
+		r = app.SetUserContext(r, data.AnonymousUser)
+		handler(w, r)
 	}
 }
 
@@ -221,7 +380,7 @@ func (app *application) requiredNonAnonymousUser(next http.HandlerFunc) http.Han
 func (app *application) requireActivatedUser(next http.HandlerFunc) http.HandlerFunc {
 	// defining fn as a function
 	fn := func(w http.ResponseWriter, r *http.Request) {
-		ctx, span := otel.Tracer("requireActivatedUser.handler.tracer").Start(r.Context(), "requireActivatedUser.handler.span")
+		ctx, span := app.startSpan(r, "requireActivatedUser")
 		defer span.End()
 
 		nUser := app.GetUserContext(r)
@@ -242,7 +401,7 @@ func (app *application) requireActivatedUser(next http.HandlerFunc) http.Handler
 
 func (app *application) requirePermission(reqPermission string, next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		ctx, span := otel.Tracer("requirepermission.handler.tracer").Start(r.Context(), "requirepermission.handler.span")
+		ctx, span := app.startSpan(r, "requirePermission")
 		defer span.End()
 
 		nUser := app.GetUserContext(r)
@@ -268,55 +427,133 @@ func (app *application) requirePermission(reqPermission string, next http.Handle
 			app.notPermittedResponse(w, r)
 			return
 		}
+		// a scoped token only has the permissions of its scope even when its user has more
+		if !scopeAllows(ctx, reqPermission) {
+			span.AddEvent("permission is out of the token scope")
+			app.notPermittedResponse(w, r)
+			return
+		}
 
 		r = r.WithContext(ctx)
 		next.ServeHTTP(w, r)
 	}
 }
 
+// requireUnscopedToken rejects the scoped tokens on the routes of the activated users which don't require any permission,
+// since a scope only grants the permissions it lists. check routeGroup.record
+func (app *application) requireUnscopedToken(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if tokenScoped(r.Context()) {
+			trace.SpanFromContext(r.Context()).AddEvent("route is out of the token scope")
+			app.notPermittedResponse(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+}
+
+// metricsAuth protects the metrics endpoint by basic auth or a static bearer token in case any of them is configured.
+func (app *application) metricsAuth(next http.Handler) http.Handler {
+	if MetricsBearerToken == "" && MetricsBasicAuthUsername == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if MetricsBearerToken != "" {
+			headerValues := strings.Split(r.Header.Get("Authorization"), " ")
+			if len(headerValues) == 2 && headerValues[0] == "Bearer" && secureCompare(headerValues[1], MetricsBearerToken) {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+		if MetricsBasicAuthUsername != "" {
+			username, password, ok := r.BasicAuth()
+			// both comparisons are evaluated to keep the response time independent of which one failed
+			usernameMatch := secureCompare(username, MetricsBasicAuthUsername)
+			passwordMatch := secureCompare(password, MetricsBasicAuthPassword)
+			if ok && usernameMatch && passwordMatch {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+		app.metricsAuthenticationRequiredResponse(w, r)
+	})
+}
+
+// secureCompare compares two strings in constant time to avoid leaking the secret through timing attacks
+func secureCompare(given, expected string) bool {
+	return subtle.ConstantTimeCompare([]byte(given), []byte(expected)) == 1
+}
+
 func (app *application) enableCORS(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Api_Key, Authorization")
+		origins := app.runtimeCfg().CORSTrustedOrigins
+		origin := r.Header.Get("Origin")
+		switch {
+		case slices.Contains(origins, "*"):
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		case origin != "" && slices.Contains(origins, origin):
+			// response differs based on the origin header so caches shouldn't serve it to other origins
+			w.Header().Add("Vary", "Origin")
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			// the web frontend sends its session cookie on the cross origin requests
+			if CookieSessions {
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			}
+		}
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Api_Key, Authorization, X-CSRF-Token, X-Request-Id, X-Plain-Response")
+		w.Header().Set("Access-Control-Expose-Headers", "X-Request-Id, Link, X-Total-Count")
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTION, HEAD")
 		next.ServeHTTP(w, r)
 	})
 }
 
-func (app *application) promMetrics(path string, next http.Handler) http.HandlerFunc {
+// httpMetrics captures the metrics of the request once and records them for the enabled metric exporters.
+// The requests are labelled by the route serving them rather than their path, so the ids don't make up a label each.
+func (app *application) httpMetrics(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// This will consider a timer for histogram and summary metric times
-		// defer function will will expose the metrics sine the timer has been set.
-		pTimer := prometheus.NewTimer(promHttpDuration.WithLabelValues(path))
-		defer pTimer.ObserveDuration()
-		promHttpTotalRequests.WithLabelValues(path).Inc()
-		metrics := httpsnoop.CaptureMetrics(next, w, r)
-		promHttpTotalResponse.WithLabelValues().Inc()
-		promHttpResponseStatus.WithLabelValues(strconv.Itoa(metrics.Code)).Inc()
+		path := routePath(r)
+		app.counters.requests.Add(1)
+		if promMetricsEnabled() {
+			promHttpTotalRequests.WithLabelValues(path).Inc()
+		}
+		if otelMetricsEnabled() {
+			otelMetricHTTPTotalRequests.Add(r.Context(), 1,
+				metric.WithAttributes(attribute.String("path", path)),
+				metric.WithAttributes(attribute.String("method", r.Method)),
+			)
+		}
 
+		snoopMetrics := httpsnoop.CaptureMetrics(next, w, r)
+
+		// request context carries the span created by otelhttp so the trace id can be attached to the observations as exemplar
+		ctx := r.Context()
+		if snoopMetrics.Code >= http.StatusInternalServerError {
+			recordServerError(ctx, path)
+		}
+		if promMetricsEnabled() {
+			promHttpTotalResponse.WithLabelValues().Inc()
+			promHttpResponseStatus.WithLabelValues(strconv.Itoa(snoopMetrics.Code)).Inc()
+			observeWithTraceExemplar(ctx, promHttpDuration.WithLabelValues(path), snoopMetrics.Duration.Seconds())
+		}
+		if otelMetricsEnabled() {
+			// http response time in miliseconds
+			otelMetricHttpDuration.Record(ctx, float64(snoopMetrics.Duration)/float64(time.Millisecond),
+				metric.WithAttributes(attribute.String("path", path)),
+			)
+			// http total responses
+			otelMetricHTTPTotalResponses.Add(ctx, 1)
+			// http total responses based on code
+			otelMetricHTTPTotalResponseStatus.Add(ctx, 1,
+				metric.WithAttributes(attribute.String("status", strconv.Itoa(snoopMetrics.Code))),
+			)
+		}
 	})
 }
 
 func (app *application) otelHandler(next http.Handler) http.HandlerFunc {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// using otelhttp default package to wrap the handler instead of creating a handler ourselves from scratch
-		instrument := otelhttp.NewHandler(next, "otel.instrumented.handler")
-		otelMetricHTTPTotalRequests.Add(r.Context(), 1,
-			metric.WithAttributes(attribute.String("path", r.URL.Path)),
-			metric.WithAttributes(attribute.String("method", r.Method)),
-		)
-		snoopMetrics := httpsnoop.CaptureMetrics(instrument, w, r)
-
-		// http response time based on status codes
-		otelMetricHttpDuration.Record(r.Context(), snoopMetrics.Duration.Seconds(),
-			metric.WithAttributes(attribute.String("path", r.URL.Path)),
-		)
-
-		// http total responses
-		otelMetricHTTPTotalResponses.Add(r.Context(), 1)
-		// http total responses based on code
-		otelMetricHTTPTotalResponseStatus.Add(r.Context(), 1,
-			metric.WithAttributes(attribute.String("status", strconv.Itoa(snoopMetrics.Code))),
-		)
-	})
+	// using otelhttp default package to wrap the handler instead of creating a handler ourselves from scratch
+	instrument := otelhttp.NewHandler(withServerSpan(app.httpMetrics(app.routeLogger(next))), "otel.instrumented.handler",
+		otelhttp.WithSpanNameFormatter(serverSpanName),
+	)
+	return instrument.ServeHTTP
 }
@@ -0,0 +1,53 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTTPMetricsLabelByRoute(t *testing.T) {
+	defer func(mode string) { MetricsExportMode = mode }(MetricsExportMode)
+	MetricsExportMode = MetricsExportPrometheus
+	logger := zerolog.Nop()
+	app := &application{log: &logger}
+	router := chi.NewRouter()
+	router.Get("/movies/{id}", app.httpMetrics(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP)
+
+	before := testutil.ToFloat64(promHttpTotalRequests.WithLabelValues("/movies/:id"))
+	for _, path := range []string{"/movies/12", "/movies/13"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	assert.Equal(t, before+2, testutil.ToFloat64(promHttpTotalRequests.WithLabelValues("/movies/:id")))
+	assert.Zero(t, testutil.ToFloat64(promHttpTotalRequests.WithLabelValues("/movies/12")))
+}
+
+func BenchmarkClientLimitersParallel(b *testing.B) {
+	logger := zerolog.Nop()
+
+	// a single client contends on its own limiter, many clients contend on the map of the limiters
+	for _, clients := range []int{1, 1000} {
+		b.Run(fmt.Sprintf("%d clients", clients), func(b *testing.B) {
+			addrs := make([]string, clients)
+			for i := range addrs {
+				addrs[i] = fmt.Sprintf("10.0.%d.%d", i/256, i%256)
+			}
+			limiters := newClientLimiters(&logger)
+			var next atomic.Int64
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					limiters.allow(addrs[next.Add(1)%int64(clients)], 1000000)
+				}
+			})
+		})
+	}
+}
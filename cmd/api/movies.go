@@ -1,13 +1,15 @@
 package api
 
 import (
-	"context"
 	"errors"
 	"fmt"
+	"math"
 	"net/http"
+	"net/url"
+	"strings"
+	"time"
 
 	"github.com/cybrarymin/greenlight/internal/data"
-	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
 	"go.opentelemetry.io/otel/trace"
@@ -32,7 +34,7 @@ import (
 //	@Failure		500				{object}	SwaggerServerErrorResponse		"server couldn't process the request"
 //	@Router			/movies [post]
 func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Request) {
-	ctx, span := otel.Tracer("createMovie.handler.tracer").Start(r.Context(), "createMovie.handler.span")
+	ctx, span := app.startSpan(r, "createMovie")
 	defer span.End()
 
 	var input struct {
@@ -59,7 +61,7 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 	if len(nvalidator.Errors) > 0 {
 		span.RecordError(errors.New(createKeyValuePairs(nvalidator.Errors)))
 		span.SetStatus(codes.Error, otelunprocessableErr)
-		app.errorResponse(w, r, http.StatusUnprocessableEntity, nvalidator.Errors)
+		app.failedValidationResponse(w, r, nvalidator)
 		return
 	}
 
@@ -70,13 +72,15 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 	if err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, otelDBErr)
-		app.serverErrorResponse(w, r, err)
+		app.dbWriteErrorResponse(w, r, err)
 		return
 	}
+	app.catalogChanged()
+	app.movieChanged(data.ChangeCreated, movie.ID, movie.Title)
 
 	headers := make(http.Header)
 	headers.Set("Location", fmt.Sprintf("/v1/movies/%d", movie.ID))
-	err = app.writeJson(w, http.StatusCreated, envelope{"result": movie}, headers)
+	err = app.writeJson(w, r, http.StatusCreated, envelope{"result": movie}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -91,52 +95,61 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 //	@Tags			movie,list
 //	@Accept			json
 //	@Produce		json
-//	@Param			Authorization	header		string							true	"jwt token"
-//	@Param			title			query		string							false	"movie title"
-//	@Param			genres			query		[]string						false	"movie genres"
-//	@Param			page			query		int								false	"page number"															default(1)
-//	@Param			page_size		query		int								false	"number of elements on each page"										default(100)
-//	@Param			sort			query		string							false	"sort options: id, title, year, runtime, -id, -title, -year, -runtim"	default(id)
-//	@Success		200				{object}	SwaggerListResponse				"successfull response"
-//	@Failure		401				{object}	SwaggerUnauthorizaed			"invalid, expired or wrong token "
-//	@Failure		403				{object}	SwaggerNotPermitted				"permission denied"
-//	@Failure		404				{object}	SwaggerNotFound					"no movie found"
-//	@Failure		422				{object}	SwaggerFailedValidationResponse	"invalid input provided"
-//	@Failure		429				{object}	SwaggerRateLimitExceedResponse	"request rate limit reached"
-//	@Failure		500				{object}	SwaggerServerErrorResponse		"server couldn't process the request"
+//	@Param			Authorization				header		string							true	"jwt token"
+//	@Param			title						query		string							false	"movie title"
+//	@Param			genres						query		[]string						false	"movie genres"
+//	@Param			updated_since				query		string							false	"only the movies updated at or after the RFC 3339 timestamp"
+//	@Param			year_from					query		int								false	"only the movies made in or after the year"
+//	@Param			year_to						query		int								false	"only the movies made in or before the year"
+//	@Param			runtime_max					query		int								false	"only the movies not longer than the runtime in minutes"
+//	@Param			imdb_rating_min				query		number							false	"only the movies rated at least as much on imdb, out of 10"
+//	@Param			rotten_tomatoes_score_min	query		int								false	"only the movies with at least the rotten tomatoes score in percent"
+//	@Param			page						query		int								false	"page number"																																		default(1)
+//	@Param			page_size					query		int								false	"number of elements on each page"																													default(100)
+//	@Param			sort						query		string							false	"sort options: id, title, year, runtime, updated_at, imdb_rating, rotten_tomatoes_score. prefixed by - for descending. unrated movies come last"	default(id)
+//	@Param			count						query		string							false	"total count mode: none, estimate, exact"																											default(exact)
+//	@Param			facets						query		[]string						false	"facets to count the matching movies by: genres, year_decade"
+//	@Success		200							{object}	SwaggerListResponse				"successfull response"
+//	@Failure		401							{object}	SwaggerUnauthorizaed			"invalid, expired or wrong token "
+//	@Failure		403							{object}	SwaggerNotPermitted				"permission denied"
+//	@Failure		404							{object}	SwaggerNotFound					"no movie found"
+//	@Failure		422							{object}	SwaggerFailedValidationResponse	"invalid input provided"
+//	@Failure		429							{object}	SwaggerRateLimitExceedResponse	"request rate limit reached"
+//	@Failure		500							{object}	SwaggerServerErrorResponse		"server couldn't process the request"
 //	@Router			/movies [get]
 func (app *application) listMovieHandler(w http.ResponseWriter, r *http.Request) {
-	ctx, span := otel.Tracer("listMovie.handler.tracer").Start(r.Context(), "listMovie.handler.span")
+	ctx, span := app.startSpan(r, "listMovie")
 	defer span.End()
 
 	var input struct {
-		Title  string
-		Genres []string
+		data.MovieListFilter
 		data.Filters
+		Facets []string
 	}
 
 	span.AddEvent("reading and validating query parameters")
 	v := data.NewValidator()
 	qs := r.URL.Query()
-	input.Title = app.readString(qs, "title", "")
-	input.Genres = app.readCSV(qs, "genres", []string{})
-	input.Filters.Page = app.readInt(qs, "page", 1, v)
-	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
-	input.Filters.Sort = app.readString(qs, "sort", "id")
-	input.Filters.SortSafeList = []string{"id", "title", "year", "runtime", "-id", "-title", "-year", "-runtime"}
-	input.Filters.ValidateFilters(v)
+	input.MovieListFilter = app.readMovieListFilter(qs, v)
+	input.Filters = app.readMovieListPage(qs, v)
+	input.Facets = app.readCSV(qs, "facets", []string{})
+	for _, facet := range input.Facets {
+		v.Apply("facets", data.OneOf(facet, data.Facets...))
+	}
+	v.Apply("facets", data.UniqueItems(input.Facets))
 	if !v.Valid() {
 		span.RecordError(errors.New(createKeyValuePairs(v.Errors)))
 		span.SetStatus(codes.Error, otelunprocessableErr)
-		app.failedValidationResponse(w, r, v.Errors)
+		app.failedValidationResponse(w, r, v)
 		return
 	}
 
 	span.AddEvent("querying database to get list of movies")
-	movies, count, err := app.models.Movies.List(ctx, input.Title, input.Genres, &input.Filters)
+	movies, count, err := app.models.Movies.List(ctx, input.MovieListFilter, &input.Filters)
 	if err != nil || count == 0 {
 		switch {
-		case errors.Is(err, data.ErrorRecordNotFound) || count == 0:
+		// the failed queries count no records either, so they're told apart by the error first
+		case errors.Is(err, data.ErrorRecordNotFound) || err == nil:
 			span.RecordError(err)
 			span.SetStatus(codes.Ok, otelDBNotFoundInfo)
 			app.notFoundResponse(w, r)
@@ -149,14 +162,110 @@ func (app *application) listMovieHandler(w http.ResponseWriter, r *http.Request)
 	}
 
 	pMeta := input.Filters.PaginationMetaData(ctx, count)
+	env := envelope{"Metadata": pMeta, "Movies": movies}
 
-	err = app.writeJson(w, http.StatusOK, envelope{"Metadata": pMeta, "Movies": movies}, nil)
+	// the facets count every movie matching the filter rather than the ones of the page
+	if len(input.Facets) > 0 {
+		span.AddEvent("querying database to count the movies by the facets")
+		facets, err := app.models.Movies.Facets(ctx, input.MovieListFilter, input.Facets)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, otelDBErr)
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		env["Facets"] = facets
+	}
+
+	// anonymous users of the public catalog get the restricted fields only
+	if app.GetUserContext(r).IsAnonymous() {
+		env["Movies"] = newPublicMovies(movies)
+	}
+
+	err = app.writeJson(w, r, http.StatusOK, env, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 
 }
 
+// readMovieListFilter reads the filter of the movie list from its query parameters
+func (app *application) readMovieListFilter(qs url.Values, v *data.Validator) data.MovieListFilter {
+	var filter data.MovieListFilter
+	filter.Title = app.readString(qs, "title", "")
+	filter.Genres = app.readCSV(qs, "genres", []string{})
+	filter.UpdatedSince = app.readTime(qs, "updated_since", v)
+	filter.YearFrom = app.readIntRange(qs, "year_from", 0, 1888, time.Now().Year(), v)
+	filter.YearTo = app.readIntRange(qs, "year_to", 0, 1888, time.Now().Year(), v)
+	filter.RuntimeMax = app.readIntRange(qs, "runtime_max", 0, 1, math.MaxInt32, v)
+	filter.IMDbRatingMin = app.readFloatRange(qs, "imdb_rating_min", 0, 0, 10, v)
+	filter.RottenTomatoesScoreMin = app.readIntRange(qs, "rotten_tomatoes_score_min", 0, 0, 100, v)
+	v.Check(filter.YearTo == 0 || filter.YearFrom <= filter.YearTo, "year_to", "must not be before year_from")
+	return filter
+}
+
+// readMovieListPage reads the page and the order of the movie list from its query parameters
+func (app *application) readMovieListPage(qs url.Values, v *data.Validator) data.Filters {
+	var filters data.Filters
+	filters.Page = app.readInt(qs, "page", 1, v)
+	filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	filters.Sort = app.readString(qs, "sort", "id")
+	filters.Count = app.readString(qs, "count", data.CountExact)
+	filters.SortSafeList = []string{"id", "title", "year", "runtime", "updated_at", "imdb_rating", "rotten_tomatoes_score", "-id", "-title", "-year", "-runtime", "-updated_at", "-imdb_rating", "-rotten_tomatoes_score"}
+	// the movies which haven't been rated by the third parties come last whatever the direction
+	filters.SortNullsLast = []string{"imdb_rating", "rotten_tomatoes_score"}
+	filters.ValidateFilters(v)
+	return filters
+}
+
+// AutocompleteMovies godoc
+//
+//	@Summary		autocomplete movie titles
+//	@Description	suggest the movies whose titles are the closest to what's been typed so far.
+//	@Tags			movie,list
+//	@Accept			json
+//	@Produce		json
+//	@Param			Authorization	header		string							true	"jwt token"
+//	@Param			q				query		string							true	"start of the title, at least 2 bytes"
+//	@Param			limit			query		int								false	"number of suggestions"	default(10)	maximum(20)
+//	@Success		200				{object}	SwaggerAutocompleteResponse		"successfull response"
+//	@Failure		401				{object}	SwaggerUnauthorizaed			"invalid, expired or wrong token "
+//	@Failure		403				{object}	SwaggerNotPermitted				"permission denied"
+//	@Failure		422				{object}	SwaggerFailedValidationResponse	"invalid input provided"
+//	@Failure		429				{object}	SwaggerRateLimitExceedResponse	"request rate limit reached"
+//	@Failure		500				{object}	SwaggerServerErrorResponse		"server couldn't process the request"
+//	@Router			/movies/autocomplete [get]
+func (app *application) autocompleteMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := app.startSpan(r, "autocompleteMovies")
+	defer span.End()
+
+	v := data.NewValidator()
+	qs := r.URL.Query()
+	prefix := strings.TrimSpace(app.readString(qs, "q", ""))
+	limit := app.readIntRange(qs, "limit", 10, 1, 20, v)
+	v.Apply("q", data.Required(prefix), data.MinLen(prefix, 2), data.MaxLen(prefix, 100))
+	if !v.Valid() {
+		span.RecordError(errors.New(createKeyValuePairs(v.Errors)))
+		span.SetStatus(codes.Error, otelunprocessableErr)
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	// no suggestion isn't an error, the client keeps on typing
+	movies, err := app.models.Movies.Autocomplete(ctx, prefix, limit)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, otelDBErr)
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJson(w, r, http.StatusOK, envelope{"Movies": movies}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
 // ShowMovie godoc
 //
 //	@Summary		Get movie detail
@@ -167,29 +276,28 @@ func (app *application) listMovieHandler(w http.ResponseWriter, r *http.Request)
 //	@Param			Authorization	header		string							true	"jwt token"
 //	@Param			id				path		string							true	"movie id"
 //	@Success		200				{object}	SwaggerGetResponse				"successfull response"
+//	@Success		308				{object}	SwaggerRedirectResponse			"the movie has been merged or re-imported as the movie of the Location header"
+//	@Header			308				{string}	Location						"url of the movie which has replaced it"
 //	@Failure		401				{object}	SwaggerUnauthorizaed			"invalid, expired or wrong token "
 //	@Failure		403				{object}	SwaggerNotPermitted				"permission denied"
 //	@Failure		404				{object}	SwaggerNotFound					"no movie found"
+//	@Failure		422				{object}	SwaggerFailedValidationResponse	"invalid input provided"
 //	@Failure		429				{object}	SwaggerRateLimitExceedResponse	"request rate limit reached"
 //	@Failure		500				{object}	SwaggerServerErrorResponse		"server couldn't process the request"
 //	@Router			/movies/{id} [get]
 func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request) {
-	ctx, span := otel.Tracer("showMovie.handler.tracer").Start(r.Context(), "showMovie.handler.span")
+	ctx, span := app.startSpan(r, "showMovie")
 	defer span.End()
 
-	id, err := app.readIDParam(r)
-	if err != nil {
-		app.notFoundResponse(w, r)
-		return
-	}
+	id := app.idParam(r, "id")
 	span.AddEvent("fetching movie information from database", trace.WithAttributes(attribute.Int64("movie.id", id)))
 	movie, err := app.models.Movies.Select(ctx, id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrorRecordNotFound):
+			// the old ids of the merged and re-imported movies keep resolving to the movie which has replaced them
 			span.RecordError(err)
-			span.SetStatus(codes.Ok, otelDBNotFoundInfo)
-			app.notFoundResponse(w, r)
+			app.redirectedOrNotFoundResponse(w, r, data.RedirectMovies, "id")
 		default:
 			span.RecordError(err)
 			span.SetStatus(codes.Error, otelDBErr)
@@ -199,7 +307,25 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	err = app.writeJson(w, http.StatusOK, envelope{"Movie": movie}, nil)
+	// anonymous users of the public catalog get the restricted fields only and have no progress
+	if app.GetUserContext(r).IsAnonymous() {
+		err = app.writeJson(w, r, http.StatusOK, envelope{"Movie": newPublicMovie(movie)}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// progress is null when the user hasn't watched the movie
+	progress, err := app.models.Progress.Get(ctx, app.GetUserContext(r).ID, movie.ID)
+	if err != nil && !errors.Is(err, data.ErrorRecordNotFound) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, otelDBErr)
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJson(w, r, http.StatusOK, envelope{"Movie": movie, "Progress": progress}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -219,21 +345,18 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 //	@Failure		401				{object}	SwaggerUnauthorizaed			"invalid, expired or wrong token "
 //	@Failure		403				{object}	SwaggerNotPermitted				"permission denied"
 //	@Failure		404				{object}	SwaggerNotFound					"no movie found"
+//	@Failure		422				{object}	SwaggerFailedValidationResponse	"invalid input provided"
 //	@Failure		429				{object}	SwaggerRateLimitExceedResponse	"request rate limit reached"
 //	@Failure		500				{object}	SwaggerServerErrorResponse		"server couldn't process the request"
 //	@Router			/movies/{id} [delete]
 func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Request) {
-	ctx, span := otel.Tracer("showMovie.handler.tracer").Start(r.Context(), "showMovie.handler.span")
+	ctx, span := app.startSpan(r, "deleteMovie")
 	defer span.End()
 
-	id, err := app.readIDParam(r)
-	if err != nil {
-		app.notFoundResponse(w, r)
-		return
-	}
+	id := app.idParam(r, "id")
 
 	span.AddEvent("deleting the movie from database", trace.WithAttributes(attribute.Int64("movie.id", id)))
-	err = app.models.Movies.Delete(ctx, id)
+	err := app.models.Movies.Delete(ctx, id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrorRecordNotFound):
@@ -243,11 +366,13 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 		default:
 			span.RecordError(err)
 			span.SetStatus(codes.Error, otelDBErr)
-			app.serverErrorResponse(w, r, err)
+			app.dbWriteErrorResponse(w, r, err)
 		}
 		return
 	}
-	err = app.writeJson(w, http.StatusOK, envelope{"result": "movie deleted successfully"}, nil)
+	app.catalogChanged()
+	app.movieChanged(data.ChangeDeleted, id, "")
+	err = app.writeJson(w, r, http.StatusOK, envelope{"result": "movie deleted successfully"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -257,7 +382,7 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 // UpdateMovie godoc
 //
 //	@Summary		update movie
-//	@Description	update movie
+//	@Description	update movie. the optional version in the body makes the update fail with 409 when the movie has changed since the client read it
 //	@Tags			movie,update
 //	@Accept			json
 //	@Produce		json
@@ -270,89 +395,182 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 //	@Failure		403				{object}	SwaggerNotPermitted				"permission denied"
 //	@Failure		404				{object}	SwaggerNotFound					"no movie found"
 //	@Failure		409				{object}	SwaggerEditConflictResponse		"conflict during concurrent update"
+//	@Failure		422				{object}	SwaggerFailedValidationResponse	"invalid input provided"
 //	@Failure		429				{object}	SwaggerRateLimitExceedResponse	"request rate limit reached"
 //	@Failure		500				{object}	SwaggerServerErrorResponse		"server couldn't process the request"
 //	@Router			/movies/{id} [patch]
 func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Request) {
-	ctx, span := otel.Tracer("showMovie.handler.tracer").Start(r.Context(), "showMovie.handler.span")
+	ctx, span := app.startSpan(r, "updateMovie")
 	defer span.End()
 
-	id, err := app.readIDParam(r)
+	id := app.idParam(r, "id")
+
+	var input struct {
+		Title   *string
+		Year    *int32
+		Runtime *data.Runtime
+		Genres  *[]string
+		// Version pins the update to the version of the movie the client has read. Without it
+		// the changes are applied to the latest version of the movie
+		Version *int32
+	}
+
+	err := app.readJson(w, r, &input)
 	if err != nil {
-		app.notFoundResponse(w, r)
+		span.SetStatus(codes.Error, otelunprocessableErr)
+		app.badRequestResponse(w, r, err)
+		return
 	}
 
-	span.AddEvent("fetching the movie information from database to update", trace.WithAttributes(attribute.Int64("movie.id", id)))
-	nMovie, err := app.models.Movies.Select(ctx, id)
+	// the movie is read again and the changes are reapplied once when a concurrent update wins the race.
+	// a pinned version conflicts again on the retry since the movie has moved past it
+	var nMovie *data.Movie
+	nvalidator := data.NewValidator()
+	errInvalidMovie := errors.New("invalid movie")
+	err = data.RetryOnEditConflict(func() error {
+		span.AddEvent("fetching the movie information from database to update", trace.WithAttributes(attribute.Int64("movie.id", id)))
+		var err error
+		nMovie, err = app.models.Movies.Select(ctx, id)
+		if err != nil {
+			return err
+		}
+		if input.Version != nil && *input.Version != nMovie.Version {
+			return data.ErrEditConflict
+		}
+
+		if input.Title != nil {
+			nMovie.Title = *input.Title
+		}
+
+		if input.Year != nil {
+			nMovie.Year = *input.Year
+		}
+
+		if input.Runtime != nil {
+			nMovie.Runtime = *input.Runtime
+		}
+
+		if input.Genres != nil {
+			nMovie.Genres = *input.Genres
+		}
+		nvalidator = data.NewValidator()
+		nMovie.Validator(nvalidator)
+		if !nvalidator.Valid() {
+			return errInvalidMovie
+		}
+		span.AddEvent("updating the movie in database", trace.WithAttributes(attribute.Int64("movie.id", id), attribute.Int("movie.version", int(nMovie.Version))))
+		return app.models.Movies.Update(ctx, id, nMovie)
+	})
 	if err != nil {
+		span.RecordError(err)
 		switch {
 		case errors.Is(err, data.ErrorRecordNotFound):
-			span.RecordError(err)
 			span.SetStatus(codes.Ok, otelDBNotFoundInfo)
 			app.notFoundResponse(w, r)
+		case errors.Is(err, errInvalidMovie):
+			span.SetStatus(codes.Error, otelunprocessableErr)
+			app.failedValidationResponse(w, r, nvalidator)
+		case errors.Is(err, data.ErrEditConflict):
+			span.SetStatus(codes.Error, otelDBErr)
+			app.editConflictResponse(w, r)
 		default:
-			span.RecordError(err)
 			span.SetStatus(codes.Error, otelDBErr)
-			app.serverErrorResponse(w, r, err)
+			app.dbWriteErrorResponse(w, r, err)
 		}
 		return
 	}
+	app.movieChanged(data.ChangeUpdated, id, nMovie.Title)
 
-	var input struct {
-		Title   *string
-		Year    *int32
-		Runtime *data.Runtime
-		Genres  *[]string
-	}
-
-	err = app.readJson(w, r, &input)
+	err = app.writeJson(w, r, http.StatusOK, envelope{"result": nMovie}, nil)
 	if err != nil {
-		span.SetStatus(codes.Error, otelunprocessableErr)
-		app.badRequestResponse(w, r, err)
+		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	if input.Title != nil {
-		nMovie.Title = *input.Title
-	}
+}
 
-	if input.Year != nil {
-		nMovie.Year = *input.Year
-	}
+// UpsertMovieByExternalID godoc
+//
+//	@Summary		create or update a movie by its external id
+//	@Description	create the movie of an imdb or tmdb id or update the existing one. repeating the same request doesn't change the movie.
+//	@Tags			movie,create
+//	@Accept			json
+//	@Produce		json
+//	@Param			movie			body		SwaggerCreateMovieInput			true	"movie data as body"
+//	@Param			authorization	header		string							true	"jwt token"
+//	@Param			source			path		string							true	"external catalog: imdb or tmdb"
+//	@Param			id				path		string							true	"movie id in the external catalog"
+//	@Success		200				{object}	SwaggerCreateResponse			"movie updated"
+//	@Success		201				{object}	SwaggerCreateResponse			"movie created"
+//	@Failure		400				{object}	SwaggerBadRequestResponse		"bad requet and malformed input"
+//	@Failure		401				{object}	SwaggerUnauthorizaed			"invalid, expired or wrong token "
+//	@Failure		403				{object}	SwaggerNotPermitted				"permission denied"
+//	@Failure		422				{object}	SwaggerFailedValidationResponse	"invalid input provided"
+//	@Failure		429				{object}	SwaggerRateLimitExceedResponse	"request rate limit reached"
+//	@Failure		500				{object}	SwaggerServerErrorResponse		"server couldn't process the request"
+//	@Router			/movies/by-external-id/{source}/{id} [put]
+func (app *application) upsertMovieByExternalIDHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := app.startSpan(r, "upsertMovieByExternalID")
+	defer span.End()
 
-	if input.Runtime != nil {
-		nMovie.Runtime = *input.Runtime
+	params := app.pathParams(r)
+	var input struct {
+		Title   string
+		Year    int32
+		Runtime data.Runtime
+		Genres  []string
 	}
-
-	if input.Genres != nil {
-		nMovie.Genres = *input.Genres
+	err := app.readJson(w, r, &input)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, otelunprocessableErr)
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	movie := data.Movie{
+		Title:          input.Title,
+		Year:           input.Year,
+		Runtime:        input.Runtime,
+		Genres:         input.Genres,
+		ExternalSource: params.ByName("source"),
+		ExternalID:     params.ByName("id"),
 	}
 	nvalidator := data.NewValidator()
-	nMovie.Validator(nvalidator)
-	if len(nvalidator.Errors) > 0 {
+	data.ValidateExternalID(nvalidator, movie.ExternalSource, movie.ExternalID)
+	movie.Validator(nvalidator)
+	if !nvalidator.Valid() {
 		span.RecordError(errors.New(createKeyValuePairs(nvalidator.Errors)))
 		span.SetStatus(codes.Error, otelunprocessableErr)
-		app.failedValidationResponse(w, r, nvalidator.Errors)
+		app.failedValidationResponse(w, r, nvalidator)
 		return
 	}
-	span.AddEvent("updating the movie in database", trace.WithAttributes(attribute.Int64("movie.id", id)))
-	err = app.models.Movies.Update(context.Background(), nMovie.ID, nMovie)
+
+	span.AddEvent("upserting movie to the database", trace.WithAttributes(
+		attribute.String("movie.external_source", movie.ExternalSource),
+		attribute.String("movie.external_id", movie.ExternalID),
+	))
+	created, err := app.models.Movies.UpsertByExternalID(ctx, &movie)
 	if err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, otelDBErr)
-		switch {
-		case errors.Is(err, data.ErrEditConflict):
-			app.editConflictResponse(w, r)
-		default:
-			app.serverErrorResponse(w, r, err)
-		}
+		app.dbWriteErrorResponse(w, r, err)
 		return
 	}
+	op := data.ChangeUpdated
+	if created {
+		app.catalogChanged()
+		op = data.ChangeCreated
+	}
+	app.movieChanged(op, movie.ID, movie.Title)
 
-	err = app.writeJson(w, http.StatusOK, envelope{"result": nMovie}, nil)
+	status := http.StatusOK
+	headers := make(http.Header)
+	if created {
+		status = http.StatusCreated
+		headers.Set("Location", fmt.Sprintf("/v1/movies/%d", movie.ID))
+	}
+	err = app.writeJson(w, r, status, envelope{"result": movie}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
-		return
 	}
-
 }
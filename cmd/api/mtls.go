@@ -0,0 +1,90 @@
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/cybrarymin/greenlight/internal/data"
+)
+
+var (
+	MTLSListenPort   int
+	TLSCertFile      string
+	TLSKeyFile       string
+	MTLSClientCAFile string
+	// MTLSIdentityMap maps the common name or a dns/uri SAN of a client certificate to the email of the user or service account it acts as.
+	MTLSIdentityMap map[string]string
+)
+
+var ErrUnmappedCertificate = errors.New("client certificate isn't mapped to any user")
+
+// newMTLSServer creates a server sharing the handler and the tuning of the main server which only accepts the clients
+// presenting a certificate signed by the client CA. requests coming through it are authenticated by the certificate.
+// The handler isn't taken from the main server as it may be wrapped for h2c, HTTP/2 is negotiated by TLS here.
+func newMTLSServer(srv *http.Server, handler http.Handler) (*http.Server, error) {
+	caPEM, err := os.ReadFile(MTLSClientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	clientCAs := x509.NewCertPool()
+	if !clientCAs.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("no certificate found in the client CA file %s", MTLSClientCAFile)
+	}
+	mtlsSrv := &http.Server{
+		Addr:              fmt.Sprintf(":%d", MTLSListenPort),
+		Handler:           handler,
+		IdleTimeout:       srv.IdleTimeout,
+		ErrorLog:          srv.ErrorLog,
+		ReadTimeout:       srv.ReadTimeout,
+		ReadHeaderTimeout: srv.ReadHeaderTimeout,
+		WriteTimeout:      srv.WriteTimeout,
+		MaxHeaderBytes:    srv.MaxHeaderBytes,
+		TLSConfig: &tls.Config{
+			MinVersion: tls.VersionTLS12,
+			ClientAuth: tls.RequireAndVerifyClientCert,
+			ClientCAs:  clientCAs,
+		},
+	}
+	mtlsSrv.SetKeepAlivesEnabled(ServerKeepAlives)
+	return mtlsSrv, nil
+}
+
+// certificateUser returns the user the verified client certificate of the request is mapped to.
+// The explicit identity map is checked first for the common name and the SANs, then the email SANs are looked up directly.
+func (app *application) certificateUser(ctx context.Context, r *http.Request) (*data.User, error) {
+	cert := r.TLS.VerifiedChains[0][0]
+
+	names := []string{cert.Subject.CommonName}
+	names = append(names, cert.DNSNames...)
+	for _, uri := range cert.URIs {
+		names = append(names, uri.String())
+	}
+	names = append(names, cert.EmailAddresses...)
+	for _, name := range names {
+		if email, ok := MTLSIdentityMap[name]; ok {
+			return app.models.Users.GetByEmail(email, ctx)
+		}
+	}
+
+	for _, email := range cert.EmailAddresses {
+		user, err := app.models.Users.GetByEmail(email, ctx)
+		if err != nil {
+			if errors.Is(err, data.ErrorRecordNotFound) {
+				continue
+			}
+			return nil, err
+		}
+		return user, nil
+	}
+	return nil, ErrUnmappedCertificate
+}
+
+// hasClientCertificate reports whether the request came through the mtls listener with a verified client certificate.
+func hasClientCertificate(r *http.Request) bool {
+	return r.TLS != nil && len(r.TLS.VerifiedChains) > 0 && len(r.TLS.VerifiedChains[0]) > 0
+}
@@ -81,20 +81,24 @@ func setupOTelSDK(ctx context.Context, db *bun.DB) (shutdown func(context.Contex
 
 	// Setup prometheusOTLP exporter.
 	// Setup metric provider.
-	metricExporter, err := newMetricExporter(ctx)
-	if err != nil {
-		handleErr(err)
-		return
-	}
+	if otelMetricsEnabled() {
+		var metricExporter metric.Exporter
+		metricExporter, err = newMetricExporter(ctx)
+		if err != nil {
+			handleErr(err)
+			return
+		}
 
-	meterProvider, err := newMeterProvider(metricExporter)
-	if err != nil {
-		handleErr(err)
-		return
-	}
+		var meterProvider *metric.MeterProvider
+		meterProvider, err = newMeterProvider(metricExporter)
+		if err != nil {
+			handleErr(err)
+			return
+		}
 
-	shutdownFuncs = append(shutdownFuncs, meterProvider.Shutdown)
-	otel.SetMeterProvider(meterProvider)
+		shutdownFuncs = append(shutdownFuncs, meterProvider.Shutdown)
+		otel.SetMeterProvider(meterProvider)
+	}
 
 	// Set up logger provider.
 	loggerProvider, err := newLoggerProvider()
@@ -106,10 +110,12 @@ func setupOTelSDK(ctx context.Context, db *bun.DB) (shutdown func(context.Contex
 	global.SetLoggerProvider(loggerProvider)
 
 	// Initialize the metrics
-	err = initializeOtelMetrics(db)
-	if err != nil {
-		handleErr(err)
-		return
+	if otelMetricsEnabled() {
+		err = initializeOtelMetrics(db)
+		if err != nil {
+			handleErr(err)
+			return
+		}
 	}
 
 	return
@@ -172,6 +178,7 @@ func newTraceProvider(traceExporter trace.SpanExporter) (*trace.TracerProvider,
 			// Default is 5s. Set to 1s for demonstrative purposes.
 			trace.WithBatchTimeout(time.Second)),
 		trace.WithResource(rattr),
+		trace.WithSpanProcessor(userSpanProcessor{}),
 	)
 	return traceProvider, nil
 }
@@ -16,7 +16,15 @@ var (
 	otelMetricHTTPTotalResponseStatus metric.Int64Counter
 	otelMetricHttpDuration            metric.Float64Histogram
 	otelMetricApplicationVersion      metric.Int64Gauge
+	otelMetricBuildInfo               metric.Int64Gauge
 	otelMetricDBStatus                metric.Int64ObservableGauge
+	otelMetricDBSchemaStatus          metric.Int64Gauge
+	otelMetricMailSendDuration        metric.Float64Histogram
+	otelMetricPanicsTotal             metric.Int64Counter
+	otelMetricRetentionRows           metric.Int64Counter
+	otelMetricIPRejections            metric.Int64Counter
+	otelMetricMalformedRequests       metric.Int64Counter
+	otelMetricServerErrors            metric.Int64Counter
 )
 
 func initializeOtelMetrics(db *bun.DB) error {
@@ -56,6 +64,55 @@ func initializeOtelMetrics(db *bun.DB) error {
 		return err
 	}
 
+	otelMetricMailSendDuration, err = otelMeter.Float64Histogram("mail_send_duration",
+		metric.WithDescription("email send duration"),
+		metric.WithUnit("ms"),
+		metric.WithExplicitBucketBoundaries(50, 100, 250, 500, 1000, 2500, 5000, 10000),
+	)
+	if err != nil {
+		return err
+	}
+
+	otelMetricPanicsTotal, err = otelMeter.Int64Counter("panics",
+		metric.WithDescription("total number of panics recovered while serving the requests"),
+		metric.WithUnit("1"),
+	)
+	if err != nil {
+		return err
+	}
+
+	otelMetricRetentionRows, err = otelMeter.Int64Counter("retention_rows",
+		metric.WithDescription("number of rows anonymized or deleted by the data retention, or which would be in dry run"),
+		metric.WithUnit("1"),
+	)
+	if err != nil {
+		return err
+	}
+
+	otelMetricIPRejections, err = otelMeter.Int64Counter("ip_rejections",
+		metric.WithDescription("number of requests rejected by the ip filter"),
+		metric.WithUnit("1"),
+	)
+	if err != nil {
+		return err
+	}
+
+	otelMetricMalformedRequests, err = otelMeter.Int64Counter("malformed_requests",
+		metric.WithDescription("number of requests closed before they're complete, sent too slowly or rejected as malformed"),
+		metric.WithUnit("1"),
+	)
+	if err != nil {
+		return err
+	}
+
+	otelMetricServerErrors, err = otelMeter.Int64Counter("http_server_errors",
+		metric.WithDescription("number of 5xx responses by route"),
+		metric.WithUnit("1"),
+	)
+	if err != nil {
+		return err
+	}
+
 	otelMetricApplicationVersion, err = otelMeter.Int64Gauge("application_info",
 		metric.WithDescription("application binary version info"),
 	)
@@ -64,6 +121,28 @@ func initializeOtelMetrics(db *bun.DB) error {
 	}
 	otelMetricApplicationVersion.Record(ctx, 1, metric.WithAttributes(attribute.String("version", Version)))
 
+	otelMetricBuildInfo, err = otelMeter.Int64Gauge("build_info",
+		metric.WithDescription("build of the binary, always 1"),
+	)
+	if err != nil {
+		return err
+	}
+	info := readBuildInfo()
+	otelMetricBuildInfo.Record(ctx, 1, metric.WithAttributes(
+		attribute.String("version", info.Version),
+		attribute.String("revision", info.Revision),
+		attribute.String("go_version", info.GoVersion),
+		attribute.String("build_time", info.BuildTime),
+	))
+
+	otelMetricDBSchemaStatus, err = otelMeter.Int64Gauge("db_schema_status",
+		metric.WithDescription("migration version and drift of the database schema as of the last schema check"),
+		metric.WithUnit("1"),
+	)
+	if err != nil {
+		return err
+	}
+
 	otelMetricDBStatus, err = otelMeter.Int64ObservableGauge("db_connection_status",
 		metric.WithDescription("database connection status"),
 		metric.WithUnit("1"),
@@ -0,0 +1,139 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/cybrarymin/greenlight/internal/data"
+	mailer "github.com/cybrarymin/greenlight/internal/mailter"
+)
+
+var (
+	// OutboxPollInterval is how often the dispatcher claims the pending outbox events
+	OutboxPollInterval time.Duration
+	// OutboxMaxAttempts is the number of times an outbox event is tried before it's given up on
+	OutboxMaxAttempts int
+)
+
+const (
+	// outboxBatchSize is the number of events claimed at once
+	outboxBatchSize = 50
+	// outboxLease is how long the claimed events are hidden from the other dispatchers. It must outlast the handling of a batch.
+	outboxLease = 5 * time.Minute
+	// outboxMaxBackoff caps the delay between the attempts of an event
+	outboxMaxBackoff = time.Hour
+)
+
+// outboxHandler performs the side effect of an outbox event. It may run more than once for the same event.
+type outboxHandler func(ctx context.Context, payload json.RawMessage) error
+
+func (app *application) outboxHandlers() map[string]outboxHandler {
+	return map[string]outboxHandler{
+		data.OutboxUserWelcome: app.sendUserWelcome,
+	}
+}
+
+// outboxBackoff returns the delay before the next attempt of an event which has failed attempts times.
+// It doubles on every attempt starting from 10 seconds, up to outboxMaxBackoff.
+func outboxBackoff(attempts int) time.Duration {
+	backoff := 10 * time.Second
+	for i := 1; i < attempts && backoff < outboxMaxBackoff; i++ {
+		backoff *= 2
+	}
+	return min(backoff, outboxMaxBackoff)
+}
+
+// runOutboxDispatcher processes the outbox every OutboxPollInterval for the lifetime of the server.
+// Every replica runs it, the claims keep them from processing the same events.
+func (app *application) runOutboxDispatcher() {
+	ticker := time.NewTicker(OutboxPollInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if app.draining.Load() {
+			return
+		}
+		app.dispatchOutbox(context.Background())
+	}
+}
+
+// dispatchOutbox claims a batch of the pending events and runs their handlers. The failed events are retried
+// with a jittered exponential backoff until OutboxMaxAttempts, then they're kept with their last error for the admins to look into.
+func (app *application) dispatchOutbox(ctx context.Context) {
+	events, err := app.models.Outbox.Claim(ctx, outboxBatchSize, outboxLease)
+	if err != nil {
+		logEvents(app.log).Error(err, "failed to claim the outbox events", nil)
+		return
+	}
+	handlers := app.outboxHandlers()
+	for _, event := range events {
+		// the rest of the batch is claimed again once the lease is over, so the shutdown isn't held up by it
+		if app.draining.Load() {
+			return
+		}
+		handler, found := handlers[event.Topic]
+		if !found {
+			err = fmt.Errorf("no handler for the outbox topic %q", event.Topic)
+		} else {
+			err = handler(ctx, event.Payload)
+		}
+		if err == nil {
+			err = app.models.Outbox.MarkProcessed(ctx, event.ID)
+			if err != nil {
+				logEvents(app.log).Error(err, "failed to mark the outbox event as processed", logFields{"event_id": event.ID})
+			}
+			continue
+		}
+
+		var retryAfter time.Duration
+		if found && event.Attempts < OutboxMaxAttempts {
+			// the events failed together are spread out so they don't hit the recovering service at once
+			retryAfter = jitter(outboxBackoff(event.Attempts))
+		}
+		logEvents(app.log).Error(err, "failed to process the outbox event", logFields{
+			"event_id": event.ID,
+			"topic":    event.Topic,
+			"attempts": event.Attempts,
+		})
+		err = app.models.Outbox.MarkFailed(ctx, event.ID, err, retryAfter)
+		if err != nil {
+			logEvents(app.log).Error(err, "failed to mark the outbox event as failed", logFields{"event_id": event.ID})
+		}
+	}
+}
+
+// sendUserWelcome sends the welcome mail with a new activation token to the registered user. The users who have
+// been activated or deleted in the meantime don't need it anymore.
+func (app *application) sendUserWelcome(ctx context.Context, payload json.RawMessage) error {
+	var event data.UserWelcomePayload
+	err := json.Unmarshal(payload, &event)
+	if err != nil {
+		return err
+	}
+	nUser := &data.User{}
+	err = app.models.Users.GetByID(event.UserID, ctx, nUser)
+	if err != nil {
+		if errors.Is(err, data.ErrorRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+	if nUser.Activated {
+		return nil
+	}
+
+	nToken, err := app.models.Tokens.New(ctx, time.Hour*72, nUser.ID, data.ActivationScope)
+	if err != nil {
+		return err
+	}
+	return app.mailer.Send(ctx, mailer.MailMessage{
+		To:           []string{nUser.Email},
+		TemplateFile: "user_welcome.tpl",
+		Data: userWelcomeMailData{
+			ID:   nUser.ID.String(),
+			Code: nToken.PlainText,
+		},
+	})
+}
@@ -0,0 +1,27 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOutboxBackoff(t *testing.T) {
+	tests := []struct {
+		name     string
+		attempts int
+		backoff  time.Duration
+	}{
+		{name: "First attempt", attempts: 1, backoff: 10 * time.Second},
+		{name: "Second attempt", attempts: 2, backoff: 20 * time.Second},
+		{name: "Fifth attempt", attempts: 5, backoff: 160 * time.Second},
+		{name: "Capped", attempts: 20, backoff: outboxMaxBackoff},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.backoff, outboxBackoff(tc.attempts))
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// PanicAlertWebhook is the url the recovered panics are posted to. alerts are disabled when it's empty
+var PanicAlertWebhook string
+
+// panicAlertInterval is the minimum time between two alerts so a panicking route can't flood the webhook.
+// the panics happening in between are counted in the next alert.
+const panicAlertInterval = time.Minute
+
+// panicAlert is the json body posted to the alert webhook
+type panicAlert struct {
+	Event      string    `json:"event"`
+	Time       time.Time `json:"time"`
+	Version    string    `json:"version"`
+	Method     string    `json:"method"`
+	Route      string    `json:"route"`
+	Path       string    `json:"path"`
+	TraceID    string    `json:"trace_id,omitempty"`
+	Error      string    `json:"error"`
+	Stack      string    `json:"stack"`
+	Suppressed int       `json:"suppressed"`
+}
+
+// panicAlerts throttles the alerts sent to the webhook
+type panicAlerts struct {
+	mu         sync.Mutex
+	lastSent   time.Time
+	suppressed int
+}
+
+// allow reports whether an alert can be sent now along with the number of panics which weren't alerted since the last one
+func (p *panicAlerts) allow(now time.Time) (bool, int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if now.Sub(p.lastSent) < panicAlertInterval {
+		p.suppressed++
+		return false, 0
+	}
+	suppressed := p.suppressed
+	p.lastSent, p.suppressed = now, 0
+	return true, suppressed
+}
+
+// recoveredPanic makes a recovered panic visible. It's counted in panics_total, recorded with its stack as an exception
+// event on its own span and posted to the alert webhook when it's configured.
+func (app *application) recoveredPanic(r *http.Request, panicErr interface{}, stack []byte) {
+	route := routeLabel(r.URL.Path)
+	// the spans of the handlers have already ended while the panic was unwinding
+	_, span := app.startSpan(r, "panicRecovery")
+	span.SetAttributes(attribute.String("http.method", r.Method))
+	defer span.End()
+	err := fmt.Errorf("%v", panicErr)
+	span.RecordError(err, trace.WithAttributes(attribute.String("exception.stacktrace", string(stack))))
+	span.SetStatus(codes.Error, "panic recovered")
+	recordPanic(r.Context(), route)
+
+	if PanicAlertWebhook == "" {
+		return
+	}
+	ok, suppressed := app.panicAlerts.allow(time.Now())
+	if !ok {
+		return
+	}
+	alert := panicAlert{
+		Event:      "panic",
+		Time:       time.Now().UTC(),
+		Version:    Version,
+		Method:     r.Method,
+		Route:      route,
+		Path:       r.URL.Path,
+		Error:      err.Error(),
+		Stack:      string(stack),
+		Suppressed: suppressed,
+	}
+	if sc := span.SpanContext(); sc.HasTraceID() {
+		alert.TraceID = sc.TraceID().String()
+	}
+	app.BackgroundJob(func() {
+		err := app.sendPanicAlert(alert)
+		if err != nil {
+			app.events(r.Context()).Error(err, "failed to send the panic alert", nil)
+		}
+	}, "panic happened during sending the panic alert")
+}
+
+func (app *application) sendPanicAlert(alert panicAlert) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	timeoutCtx, cancelFunc := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancelFunc()
+	req, err := http.NewRequestWithContext(timeoutCtx, http.MethodPost, PanicAlertWebhook, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := app.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("alert webhook responded with status %d", res.StatusCode)
+	}
+	return nil
+}
+
+// routeLabel replaces the ids in the path with :id so the panics of a route share the same label
+func routeLabel(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if _, err := strconv.ParseInt(segment, 10, 64); err == nil {
+			segments[i] = ":id"
+			continue
+		}
+		if _, err := uuid.Parse(segment); err == nil {
+			segments[i] = ":id"
+		}
+	}
+	return strings.Join(segments, "/")
+}
@@ -0,0 +1,150 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ErrParamNotFound is returned when a path parameter is well-formed but can never identify an existing record (e.g. the nil uuid).
+var ErrParamNotFound = errors.New("path parameter doesn't match any resource")
+
+// invalidParamError is returned when a path parameter can't be parsed into the expected type.
+// Key and Message are reported back to the client as a field validation error.
+type invalidParamError struct {
+	Key     string
+	Message string
+}
+
+func (e *invalidParamError) Error() string {
+	return fmt.Sprintf("invalid %s path parameter: %s", e.Key, e.Message)
+}
+
+// pathParams wraps the route context of a request and provides typed accessors for its path parameters.
+type pathParams struct {
+	rctx *chi.Context
+}
+
+func (app *application) pathParams(r *http.Request) pathParams {
+	return pathParams{chi.RouteContext(r.Context())}
+}
+
+// ByName returns the value of the named path parameter. It's empty when the route has no such parameter.
+func (p pathParams) ByName(key string) string {
+	if p.rctx == nil {
+		return ""
+	}
+	return p.rctx.URLParam(key)
+}
+
+// Int64 parses the named path parameter as a positive integer id.
+// Malformed values and the ones lower than 1 return an *invalidParamError, so -1 is rejected like abc.
+func (p pathParams) Int64(key string) (int64, error) {
+	value := p.ByName(key)
+	id, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || id < 1 {
+		return 0, &invalidParamError{Key: key, Message: "must be a positive integer"}
+	}
+	return id, nil
+}
+
+// UUID parses the named path parameter as a uuid.
+// Malformed values return an *invalidParamError, the nil uuid returns ErrParamNotFound.
+func (p pathParams) UUID(key string) (uuid.UUID, error) {
+	value := p.ByName(key)
+	id, err := uuid.Parse(value)
+	if err != nil {
+		return uuid.Nil, &invalidParamError{Key: key, Message: "must be a valid uuid"}
+	}
+	if id == uuid.Nil {
+		return uuid.Nil, ErrParamNotFound
+	}
+	return id, nil
+}
+
+// readUUIDParam reads the "id" path parameter of the request as a uuid.
+func (app *application) readUUIDParam(r *http.Request) (uuid.UUID, error) {
+	return app.pathParams(r).UUID("id")
+}
+
+const (
+	idParamsContextKey   = contextKey("id_params")
+	uuidParamsContextKey = contextKey("uuid_params")
+)
+
+// routeParamKeys returns the names of the path parameters of the route, like id and asset_id of /movies/:id/assets/:asset_id
+func routeParamKeys(path string) []string {
+	var keys []string
+	for _, segment := range strings.Split(path, "/") {
+		if key, found := strings.CutPrefix(segment, ":"); found {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// withIDParams parses the path parameters of the route as positive integer ids before the handler, which reads them
+// by idParam. check handleWithID
+func (app *application) withIDParams(path string, next http.HandlerFunc) http.HandlerFunc {
+	keys := routeParamKeys(path)
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := app.pathParams(r)
+		ids := make(map[string]int64, len(keys))
+		for _, key := range keys {
+			id, err := params.Int64(key)
+			if err != nil {
+				app.pathParamErrorResponse(w, r, err)
+				return
+			}
+			ids[key] = id
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), idParamsContextKey, ids)))
+	}
+}
+
+// withUUIDParams parses the path parameters of the route as uuids before the handler, which reads them by uuidParam.
+// check handleWithUUID
+func (app *application) withUUIDParams(path string, next http.HandlerFunc) http.HandlerFunc {
+	keys := routeParamKeys(path)
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := app.pathParams(r)
+		ids := make(map[string]uuid.UUID, len(keys))
+		for _, key := range keys {
+			id, err := params.UUID(key)
+			if err != nil {
+				app.pathParamErrorResponse(w, r, err)
+				return
+			}
+			ids[key] = id
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), uuidParamsContextKey, ids)))
+	}
+}
+
+// pathParamErrorResponse rejects the request whose path parameter can't be parsed
+func (app *application) pathParamErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	span := trace.SpanFromContext(r.Context())
+	span.RecordError(err)
+	span.SetStatus(codes.Error, otelunprocessableErr)
+	app.invalidParamResponse(w, r, err)
+}
+
+// idParam returns the path parameter of the route registered by handleWithID
+func (app *application) idParam(r *http.Request, key string) int64 {
+	ids, _ := r.Context().Value(idParamsContextKey).(map[string]int64)
+	return ids[key]
+}
+
+// uuidParam returns the path parameter of the route registered by handleWithUUID
+func (app *application) uuidParam(r *http.Request, key string) uuid.UUID {
+	ids, _ := r.Context().Value(uuidParamsContextKey).(map[string]uuid.UUID)
+	return ids[key]
+}
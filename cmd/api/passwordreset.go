@@ -0,0 +1,161 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cybrarymin/greenlight/internal/data"
+	mailer "github.com/cybrarymin/greenlight/internal/mailter"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// passwordResetTokenTTL is how long the emailed password reset link is valid for
+const passwordResetTokenTTL = time.Hour * 24
+
+// passwordResetMailData is the data of password_reset.tpl mail template
+type passwordResetMailData struct {
+	Name   string
+	ID     string
+	Token  string
+	Expiry string
+}
+
+// rejectPasswordResetRequired keeps the users whose password has been invalidated from using the api until they reset it,
+// whatever credential they've authenticated by
+func (app *application) rejectPasswordResetRequired(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := app.GetUserContext(r)
+		if !user.IsAnonymous() && user.PasswordResetRequired {
+			app.passwordResetRequiredResponse(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+}
+
+// forcePasswordResetHandler invalidates the password of the user, e.g. when it's been leaked, and emails the user a link
+// to reset it. The user is signed out of every token, session and device and is rejected by rejectPasswordResetRequired
+// until the password is reset.
+func (app *application) forcePasswordResetHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := app.startSpan(r, "forcePasswordReset")
+	defer span.End()
+
+	userID := app.uuidParam(r, "id")
+	nValidator := data.NewValidator()
+	nValidator.Check(userID != app.GetUserContext(r).ID, "id", "can't force a password reset on yourself")
+	if !nValidator.Valid() {
+		span.RecordError(errors.New(createKeyValuePairs(nValidator.Errors)))
+		span.SetStatus(codes.Error, otelunprocessableErr)
+		app.failedValidationResponse(w, r, nValidator)
+		return
+	}
+
+	user, nToken, err := app.models.Users.ForcePasswordReset(ctx, userID, passwordResetTokenTTL)
+	if err != nil {
+		span.RecordError(err)
+		switch {
+		case errors.Is(err, data.ErrorRecordNotFound):
+			span.SetStatus(codes.Ok, otelDBNotFoundInfo)
+			app.notFoundResponse(w, r)
+		default:
+			span.SetStatus(codes.Error, otelDBErr)
+			app.dbWriteErrorResponse(w, r, err)
+		}
+		return
+	}
+	app.events(ctx).Warn("password reset forced on the user", logFields{
+		"event": "password_reset_forced",
+		"user":  user.Email,
+		"actor": app.GetUserContext(r).Email,
+	})
+
+	app.sendMailInBackground(ctx, mailer.MailMessage{
+		To:           []string{user.Email},
+		TemplateFile: "password_reset.tpl",
+		Data: passwordResetMailData{
+			Name:   user.Name,
+			ID:     user.ID.String(),
+			Token:  nToken.PlainText,
+			Expiry: nToken.Expiry.UTC().Format(time.RFC1123),
+		},
+	}, "panic happened during sending the password reset email")
+
+	err = app.writeJson(w, r, http.StatusAccepted, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// resetPasswordHandler sets the new password of the user by the password reset token emailed to the user
+func (app *application) resetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := app.startSpan(r, "resetPassword")
+	defer span.End()
+
+	userID := app.uuidParam(r, "id")
+	var input struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	err := app.readJson(w, r, &input)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, otelunprocessableErr)
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	nVal := data.NewValidator()
+	data.ValidateTokenPlaintext(nVal, input.Token)
+	data.ValidatePasswordPlaintext(nVal, input.Password)
+	if !nVal.Valid() {
+		span.RecordError(errors.New(createKeyValuePairs(nVal.Errors)))
+		span.SetStatus(codes.Error, otelunprocessableErr)
+		app.failedValidationResponse(w, r, nVal)
+		return
+	}
+
+	// the reset tokens are guessed the same way as the activation tokens so they share the limits of the failed attempts
+	attemptKey := data.PasswordResetScope + ":" + userID.String()
+	if !app.activationAttempts.allow(attemptKey, ActivationMaxAttempts, time.Now()) {
+		span.SetStatus(codes.Error, otelAuthFailureErr)
+		w.Header().Set("Retry-After", strconv.Itoa(int(ActivationAttemptWindow.Seconds())))
+		app.rateLimitExceedResponse(w, r)
+		return
+	}
+
+	password := &data.Password{}
+	err = password.Set(input.Password)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "error on new password setup")
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	user, err := app.models.Users.ResetPassword(ctx, userID, input.Token, password)
+	if err != nil {
+		span.RecordError(err)
+		switch {
+		case errors.Is(err, data.ErrorInvalidToken):
+			span.SetStatus(codes.Error, otelAuthFailureErr)
+			app.activationAttempts.fail(attemptKey, ActivationAttemptWindow, time.Now())
+			app.invalidResetTokenResponse(w, r)
+		case errors.Is(err, data.ErrorRecordNotFound):
+			span.SetStatus(codes.Ok, otelDBNotFoundInfo)
+			app.notFoundResponse(w, r)
+		default:
+			span.SetStatus(codes.Error, otelDBErr)
+			app.dbWriteErrorResponse(w, r, err)
+		}
+		return
+	}
+	app.activationAttempts.reset(attemptKey)
+	app.events(ctx).Info("password reset by the user", logFields{"event": "password_reset", "user": user.Email})
+
+	err = app.writeJson(w, r, http.StatusOK, envelope{"result": "password reset"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
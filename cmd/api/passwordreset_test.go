@@ -0,0 +1,46 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cybrarymin/greenlight/internal/data"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRejectPasswordResetRequired(t *testing.T) {
+	app := &application{}
+	tests := []struct {
+		name   string
+		user   *data.User
+		status int
+	}{
+		{name: "Anonymous user", user: data.AnonymousUser, status: http.StatusOK},
+		{name: "User", user: &data.User{Email: "john@example.com"}, status: http.StatusOK},
+		{name: "User must reset the password", user: &data.User{Email: "john@example.com", PasswordResetRequired: true}, status: http.StatusForbidden},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/v1/movies", nil)
+			r = r.WithContext(context.WithValue(r.Context(), userContextKey, tc.user))
+			rec := httptest.NewRecorder()
+			app.rejectPasswordResetRequired(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			})(rec, r)
+
+			assert.Equal(t, tc.status, rec.Code)
+			if tc.status == http.StatusForbidden {
+				var body struct {
+					Code errorCode `json:"code"`
+				}
+				require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+				assert.Equal(t, codePasswordResetRequired, body.Code)
+			}
+		})
+	}
+}
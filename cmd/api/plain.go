@@ -0,0 +1,59 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/cybrarymin/greenlight/internal/data"
+)
+
+// plainResponse reports whether the client asked for the resources without the envelope, by ?envelope=false
+// or the X-Plain-Response header. It's meant for the machine clients which follow the plain REST conventions.
+func plainResponse(r *http.Request) bool {
+	if plain, err := strconv.ParseBool(r.Header.Get("X-Plain-Response")); err == nil && plain {
+		return true
+	}
+	return r.URL.Query().Get("envelope") == "false"
+}
+
+// plainBody returns the resource of the envelope, unwrapped the way v2Envelope unwraps data.
+// Errors keep their envelope so the clients can still tell them apart by their code.
+func (e envelope) plainBody() interface{} {
+	ne := e.v2Envelope()
+	if _, ok := ne["error"]; ok {
+		return ne
+	}
+	return ne["data"]
+}
+
+// paginationMeta returns the pagination metadata of the list responses, nil for the others
+func (e envelope) paginationMeta() *data.PaginationMeta {
+	if meta, ok := e["Metadata"].(data.PaginationMeta); ok {
+		return &meta
+	}
+	return nil
+}
+
+// paginationHeaders sets the Link header of the list responses per RFC 8288, so the generic http clients and the crawlers
+// can paginate without reading the metadata. The plain responses get the X-Total-Count header as well since they lack the metadata.
+func paginationHeaders(headers http.Header, u *url.URL, meta data.PaginationMeta, plain bool) {
+	links := make([]string, 0, 4)
+	for _, link := range meta.Links() {
+		links = append(links, pageLink(u, link.Page, link.Rel))
+	}
+	headers.Set("Link", strings.Join(links, ", "))
+	if plain && meta.TotalRecords != nil {
+		headers.Set("X-Total-Count", strconv.Itoa(*meta.TotalRecords))
+	}
+}
+
+// pageLink returns the link of the page of the request url. The link is relative to the host the request was sent to.
+func pageLink(u *url.URL, page int, rel string) string {
+	qs := u.Query()
+	qs.Set("page", strconv.Itoa(page))
+	link := url.URL{Path: u.Path, RawQuery: qs.Encode()}
+	return fmt.Sprintf("<%s>; rel=%q", link.String(), rel)
+}
@@ -0,0 +1,109 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/cybrarymin/greenlight/internal/data"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlainResponse(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		header string
+		plain  bool
+	}{
+		{name: "Enveloped by default", target: "/v1/movies", plain: false},
+		{name: "Query parameter", target: "/v1/movies?envelope=false", plain: true},
+		{name: "Query parameter keeping the envelope", target: "/v1/movies?envelope=true", plain: false},
+		{name: "Header", target: "/v1/movies", header: "true", plain: true},
+		{name: "Invalid header", target: "/v1/movies", header: "yes", plain: false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tc.target, nil)
+			if tc.header != "" {
+				r.Header.Set("X-Plain-Response", tc.header)
+			}
+			assert.Equal(t, tc.plain, plainResponse(r))
+		})
+	}
+}
+
+func TestPlainBody(t *testing.T) {
+	total, last := 30, 3
+	meta := data.PaginationMeta{FirstPage: 1, LastPage: &last, TotalRecords: &total, PageSize: 10, CurrentPage: 1}
+
+	list := envelope{"Metadata": meta, "Movies": []int{1, 2}}
+	assert.Equal(t, []int{1, 2}, list.plainBody())
+	assert.Equal(t, &meta, list.paginationMeta())
+
+	single := envelope{"movie": 1}
+	assert.Equal(t, 1, single.plainBody())
+	assert.Nil(t, single.paginationMeta())
+
+	failure := envelope{"error": "record not found", "code": codeNotFound}
+	assert.Equal(t, envelope{"error": "record not found", "code": codeNotFound}, failure.plainBody())
+	assert.Nil(t, failure.paginationMeta())
+}
+
+func TestPaginationHeaders(t *testing.T) {
+	u, err := url.Parse("/v1/movies?genres=drama&page=2&page_size=10")
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name  string
+		meta  func() data.PaginationMeta
+		link  string
+		total string
+	}{
+		{
+			name: "Middle page",
+			meta: func() data.PaginationMeta {
+				total, last := 30, 3
+				return data.PaginationMeta{FirstPage: 1, LastPage: &last, TotalRecords: &total, PageSize: 10, CurrentPage: 2}
+			},
+			link: `</v1/movies?genres=drama&page=1&page_size=10>; rel="first", </v1/movies?genres=drama&page=1&page_size=10>; rel="prev", ` +
+				`</v1/movies?genres=drama&page=3&page_size=10>; rel="next", </v1/movies?genres=drama&page=3&page_size=10>; rel="last"`,
+			total: "30",
+		},
+		{
+			name: "Last page",
+			meta: func() data.PaginationMeta {
+				total, last := 20, 2
+				return data.PaginationMeta{FirstPage: 1, LastPage: &last, TotalRecords: &total, PageSize: 10, CurrentPage: 2}
+			},
+			link: `</v1/movies?genres=drama&page=1&page_size=10>; rel="first", </v1/movies?genres=drama&page=1&page_size=10>; rel="prev", ` +
+				`</v1/movies?genres=drama&page=2&page_size=10>; rel="last"`,
+			total: "20",
+		},
+		{
+			name: "Unknown total count",
+			meta: func() data.PaginationMeta {
+				return data.PaginationMeta{FirstPage: 1, PageSize: 10, CurrentPage: 2}
+			},
+			link: `</v1/movies?genres=drama&page=1&page_size=10>; rel="first", </v1/movies?genres=drama&page=1&page_size=10>; rel="prev", ` +
+				`</v1/movies?genres=drama&page=3&page_size=10>; rel="next"`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			headers := http.Header{}
+			paginationHeaders(headers, u, tc.meta(), true)
+			assert.Equal(t, tc.link, headers.Get("Link"))
+			assert.Equal(t, tc.total, headers.Get("X-Total-Count"))
+
+			// the enveloped responses carry the total in their metadata
+			headers = http.Header{}
+			paginationHeaders(headers, u, tc.meta(), false)
+			assert.Equal(t, tc.link, headers.Get("Link"))
+			assert.Empty(t, headers.Get("X-Total-Count"))
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/cybrarymin/greenlight/internal/data"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// updateProgressHandler stores the playback position of the movie for the current user.
+// completed defaults to whether the position is close to the end of the movie.
+func (app *application) updateProgressHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := app.startSpan(r, "updateProgress")
+	defer span.End()
+
+	id := app.idParam(r, "id")
+
+	var input struct {
+		PositionSeconds *int32 `json:"position_seconds"`
+		Completed       *bool  `json:"completed"`
+	}
+	err := app.readJson(w, r, &input)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, otelunprocessableErr)
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	movie, err := app.models.Movies.Select(ctx, id)
+	if err != nil {
+		span.RecordError(err)
+		switch {
+		case errors.Is(err, data.ErrorRecordNotFound):
+			span.SetStatus(codes.Ok, otelDBNotFoundInfo)
+			app.notFoundResponse(w, r)
+		default:
+			span.SetStatus(codes.Error, otelDBErr)
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	nValidator := data.NewValidator()
+	nValidator.Check(input.PositionSeconds != nil, "position_seconds", "must be provided")
+	if !nValidator.Valid() {
+		span.SetStatus(codes.Error, otelunprocessableErr)
+		app.failedValidationResponse(w, r, nValidator)
+		return
+	}
+	progress := &data.WatchProgress{
+		UserID:          app.GetUserContext(r).ID,
+		MovieID:         movie.ID,
+		PositionSeconds: *input.PositionSeconds,
+		Completed:       data.Watched(*input.PositionSeconds, movie.Runtime),
+	}
+	if input.Completed != nil {
+		progress.Completed = *input.Completed
+	}
+	data.ValidateWatchProgress(nValidator, progress, movie.Runtime)
+	if !nValidator.Valid() {
+		span.RecordError(errors.New(createKeyValuePairs(nValidator.Errors)))
+		span.SetStatus(codes.Error, otelunprocessableErr)
+		app.failedValidationResponse(w, r, nValidator)
+		return
+	}
+
+	err = app.models.Progress.Upsert(ctx, progress)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, otelDBErr)
+		app.dbWriteErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJson(w, r, http.StatusOK, envelope{"Progress": progress}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listHistoryHandler lists the movies the user has watched along with their progress. id is either "me" or
+// the id of another user which requires admin permission.
+func (app *application) listHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := app.startSpan(r, "listHistory")
+	defer span.End()
+
+	userID, ok := app.userIDParam(ctx, w, r, span)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		data.Filters
+	}
+	nValidator := data.NewValidator()
+	qs := r.URL.Query()
+	input.Filters.Page = app.readInt(qs, "page", 1, nValidator)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, nValidator)
+	input.Filters.Sort = app.readString(qs, "sort", "-updated_at")
+	input.Filters.Count = app.readString(qs, "count", data.CountExact)
+	input.Filters.SortSafeList = []string{"updated_at", "position_seconds", "-updated_at", "-position_seconds"}
+	input.Filters.ValidateFilters(nValidator)
+	if !nValidator.Valid() {
+		span.RecordError(errors.New(createKeyValuePairs(nValidator.Errors)))
+		span.SetStatus(codes.Error, otelunprocessableErr)
+		app.failedValidationResponse(w, r, nValidator)
+		return
+	}
+
+	history, count, err := app.models.Progress.History(ctx, userID, &input.Filters)
+	if err != nil {
+		span.RecordError(err)
+		switch {
+		case errors.Is(err, data.ErrorRecordNotFound):
+			span.SetStatus(codes.Ok, otelDBNotFoundInfo)
+		default:
+			span.SetStatus(codes.Error, otelDBErr)
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	pMeta := input.Filters.PaginationMetaData(ctx, count)
+	err = app.writeJson(w, r, http.StatusOK, envelope{"Metadata": pMeta, "History": history}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
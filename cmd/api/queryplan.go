@@ -0,0 +1,119 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"math/rand"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/uptrace/bun"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	// DBSlowQueryThreshold is the duration after which a query is logged as slow and becomes a candidate for plan sampling
+	DBSlowQueryThreshold time.Duration
+	// DBExplainSampleRate is the fraction of the slow queries which are explained. 0 disables the plan sampling
+	DBExplainSampleRate float64
+)
+
+// lockingClauseRX matches the row locks of a SELECT. explaining them from another connection would wait
+// for the lock held by the transaction which is waiting for the hook
+var lockingClauseRX = regexp.MustCompile(`(?i)\bFOR\s+(NO\s+KEY\s+UPDATE|UPDATE|KEY\s+SHARE|SHARE)\b`)
+
+// queryPlanHook runs EXPLAIN (ANALYZE, BUFFERS) for a sample of the slow queries and attaches the plan
+// to a span within the trace of the query and a debug log entry.
+// Only the SELECT queries are explained since ANALYZE executes the statement once more, and a single
+// explain runs at a time so a burst of slow queries doesn't double the load of an already struggling database.
+type queryPlanHook struct {
+	log        *zerolog.Logger
+	threshold  time.Duration
+	sampleRate float64
+	running    atomic.Bool
+}
+
+func newQueryPlanHook(log *zerolog.Logger, threshold time.Duration, sampleRate float64) *queryPlanHook {
+	return &queryPlanHook{
+		log:        log,
+		threshold:  threshold,
+		sampleRate: sampleRate,
+	}
+}
+
+func (h *queryPlanHook) BeforeQuery(ctx context.Context, _ *bun.QueryEvent) context.Context {
+	return ctx
+}
+
+func (h *queryPlanHook) AfterQuery(ctx context.Context, event *bun.QueryEvent) {
+	duration := time.Since(event.StartTime)
+	if duration < h.threshold || event.Err != nil {
+		return
+	}
+	// raw queries report SELECT as their operation whatever statement they run
+	if _, ok := event.IQuery.(*bun.SelectQuery); !ok || lockingClauseRX.MatchString(event.Query) {
+		return
+	}
+	if rand.Float64() >= h.sampleRate || !h.running.CompareAndSwap(false, true) {
+		return
+	}
+
+	// the explain runs the query once more, so it's done in the background rather than keeping the request which was
+	// already slow waiting for it. The plan is still worth having when the request is gone.
+	db, query := event.DB.DB, event.Query
+	go func() {
+		defer h.running.Store(false)
+		explainCtx, span := startBackgroundSpan(context.WithoutCancel(ctx), "explainQuery")
+		defer span.End()
+		explainCtx, cancelFunc := context.WithTimeout(explainCtx, 2*h.threshold)
+		defer cancelFunc()
+
+		plan, err := explainQuery(explainCtx, db, query)
+		if err != nil {
+			span.RecordError(err)
+			logEvents(h.log).Error(err, "failed to explain the slow query", logFields{"query": query})
+			return
+		}
+
+		span.AddEvent("db.query_plan", trace.WithAttributes(
+			attribute.String("db.statement", query),
+			attribute.String("db.query_plan", plan),
+			attribute.Int64("db.duration_ms", duration.Milliseconds()),
+		))
+		logEvents(h.log).Debug("plan of the slow query", logFields{
+			"event":    "query_plan",
+			"query":    query,
+			"duration": duration,
+			"trace_id": span.SpanContext().TraceID().String(),
+			"plan":     plan,
+		})
+	}()
+}
+
+// explainQuery runs the explain on the underlying sql.DB. The query is already formatted by bun
+// so it must not go through the bun formatter again, and it skips the query hooks as well.
+func explainQuery(ctx context.Context, db *sql.DB, query string) (string, error) {
+	rows, err := db.QueryContext(ctx, "EXPLAIN (ANALYZE, BUFFERS) "+query)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	var lines []string
+	for rows.Next() {
+		var line string
+		err = rows.Scan(&line)
+		if err != nil {
+			return "", err
+		}
+		lines = append(lines, line)
+	}
+	if err = rows.Err(); err != nil {
+		return "", err
+	}
+	return strings.Join(lines, "\n"), nil
+}
@@ -0,0 +1,76 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect/pgdialect"
+)
+
+// syncBuffer is written by the background explain while the test reads it
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestQueryPlanHook(t *testing.T) {
+	stubbed := &stubDB{t: t, queries: []stubQuery{
+		stub(`^EXPLAIN \(ANALYZE, BUFFERS\) SELECT 1`, []string{"QUERY PLAN"}, []driver.Value{"Result  (cost=0.00..0.01 rows=1 width=4)"}),
+	}}
+	db := bun.NewDB(sql.OpenDB(stubbed), pgdialect.New())
+
+	tests := []struct {
+		name      string
+		iquery    bun.Query
+		query     string
+		duration  time.Duration
+		explained bool
+	}{
+		{name: "Slow select", iquery: db.NewSelect(), query: "SELECT 1", duration: time.Second, explained: true},
+		{name: "Fast select", iquery: db.NewSelect(), query: "SELECT 1", duration: time.Millisecond},
+		{name: "Slow update", iquery: db.NewUpdate(), query: "UPDATE movies SET title = 'x'", duration: time.Second},
+		{name: "Slow locking select", iquery: db.NewSelect(), query: "SELECT 1 FOR UPDATE", duration: time.Second},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var logs syncBuffer
+			logger := zerolog.New(&logs).Level(zerolog.DebugLevel)
+			hook := newQueryPlanHook(&logger, 100*time.Millisecond, 1)
+			hook.AfterQuery(context.Background(), &bun.QueryEvent{
+				DB:        db,
+				IQuery:    tc.iquery,
+				Query:     tc.query,
+				StartTime: time.Now().Add(-tc.duration),
+			})
+			if !tc.explained {
+				assert.False(t, hook.running.Load())
+				assert.Empty(t, logs.String())
+				return
+			}
+			// the explain is done in the background once the query has returned
+			assert.Eventually(t, func() bool { return !hook.running.Load() }, time.Second, time.Millisecond)
+			assert.Contains(t, logs.String(), "Result  (cost=0.00..0.01 rows=1 width=4)")
+		})
+	}
+}
@@ -0,0 +1,173 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cybrarymin/greenlight/internal/data"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/codes"
+)
+
+var (
+	// RatingsProviderURL is the omdb compatible api the external ratings of the imdb movies are imported from
+	RatingsProviderURL string
+	// RatingsAPIKey authenticates the calls to the ratings provider. The import is disabled when it's empty
+	RatingsAPIKey string
+	// RatingsRefreshInterval is how often the ratings import runs and how old the ratings of a movie get before they're
+	// imported again. The admins can still run the import on demand when it's 0
+	RatingsRefreshInterval time.Duration
+	// RatingsImportLimit is the number of movies imported by a run, so the daily quota of the provider isn't exhausted at once
+	RatingsImportLimit int
+)
+
+// errRatingsNotFound is returned by the provider for the imdb ids it doesn't know
+var errRatingsNotFound = errors.New("the ratings provider doesn't know the movie")
+
+// providerRatings is the response of the ratings provider. The missing scores are "N/A".
+type providerRatings struct {
+	Response   string `json:"Response"`
+	Error      string `json:"Error"`
+	IMDbRating string `json:"imdbRating"`
+	Ratings    []struct {
+		Source string `json:"Source"`
+		Value  string `json:"Value"`
+	} `json:"Ratings"`
+}
+
+// externalRatings converts the scores of the provider. The scores which can't be read are left out rather than failing the movie.
+func (p *providerRatings) externalRatings() data.ExternalRatings {
+	var ratings data.ExternalRatings
+	if rating, err := strconv.ParseFloat(p.IMDbRating, 64); err == nil && rating >= 0 && rating <= 10 {
+		ratings.IMDbRating = &rating
+	}
+	for _, r := range p.Ratings {
+		if r.Source != "Rotten Tomatoes" {
+			continue
+		}
+		if score, err := strconv.ParseInt(strings.TrimSuffix(r.Value, "%"), 10, 32); err == nil && score >= 0 && score <= 100 {
+			tomatometer := int32(score)
+			ratings.RottenTomatoesScore = &tomatometer
+		}
+	}
+	return ratings
+}
+
+// fetchRatings reads the external ratings of the imdb movie from the provider
+func (app *application) fetchRatings(ctx context.Context, imdbID string) (data.ExternalRatings, error) {
+	u, err := url.Parse(RatingsProviderURL)
+	if err != nil {
+		return data.ExternalRatings{}, err
+	}
+	qs := u.Query()
+	qs.Set("i", imdbID)
+	qs.Set("apikey", RatingsAPIKey)
+	u.RawQuery = qs.Encode()
+
+	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*10)
+	defer cancelFunc()
+	res, err := app.httpClient.Get(timeoutCtx, u.String())
+	if err != nil {
+		return data.ExternalRatings{}, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return data.ExternalRatings{}, fmt.Errorf("ratings provider responded with status %d", res.StatusCode)
+	}
+
+	var ratings providerRatings
+	err = json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(&ratings)
+	if err != nil {
+		return data.ExternalRatings{}, fmt.Errorf("invalid response of the ratings provider: %w", err)
+	}
+	if ratings.Response != "True" {
+		if strings.Contains(strings.ToLower(ratings.Error), "not found") {
+			return data.ExternalRatings{}, errRatingsNotFound
+		}
+		return data.ExternalRatings{}, fmt.Errorf("ratings provider failed: %s", ratings.Error)
+	}
+	return ratings.externalRatings(), nil
+}
+
+// startRatingsImport starts the job importing the external ratings of the movies which haven't been imported
+// for RatingsRefreshInterval, at most RatingsImportLimit of them. A failing movie is reported as an item error
+// and it's tried again by the next run.
+func (app *application) startRatingsImport(ctx context.Context, createdBy uuid.UUID) (*data.Job, error) {
+	movies, err := app.models.Movies.StaleRatings(ctx, time.Now().Add(-RatingsRefreshInterval), RatingsImportLimit)
+	if err != nil {
+		return nil, err
+	}
+	return app.startJob(ctx, data.JobRatingsImport, createdBy, len(movies), func(ctx context.Context, progress *jobProgress) (interface{}, error) {
+		imported := 0
+		for i, movie := range movies {
+			if app.draining.Load() {
+				return nil, errors.New("the ratings import is interrupted by the shutdown")
+			}
+			ratings, err := app.fetchRatings(ctx, movie.ExternalID)
+			// the unknown movies are stored without ratings, so they aren't asked for again until the next refresh
+			if errors.Is(err, errRatingsNotFound) {
+				err = nil
+			}
+			if err == nil {
+				err = app.models.Movies.UpdateRatings(ctx, movie.ID, ratings)
+			}
+			if err != nil {
+				progress.report(1, 1, []data.JobItemError{{Item: i, Key: movie.ExternalID, Errors: map[string]string{"error": err.Error()}}})
+				continue
+			}
+			app.movieChanged(data.ChangeUpdated, movie.ID, movie.Title)
+			imported++
+			progress.report(1, 0, nil)
+		}
+		app.events(ctx).Info("external ratings imported", logFields{"movies": len(movies), "imported": imported})
+		return map[string]interface{}{"movies": len(movies), "imported": imported}, nil
+	})
+}
+
+// runRatingsScheduler starts the ratings import every RatingsRefreshInterval for the lifetime of the server.
+// The replicas running it at the same time only import the same ratings twice.
+func (app *application) runRatingsScheduler() {
+	ticker := time.NewTicker(RatingsRefreshInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if app.draining.Load() {
+			return
+		}
+		_, err := app.startRatingsImport(context.Background(), uuid.Nil)
+		if err != nil {
+			logEvents(app.log).Error(err, "failed to start the ratings import job", nil)
+		}
+	}
+}
+
+// importRatingsHandler imports the stale external ratings right away
+func (app *application) importRatingsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := app.startSpan(r, "importRatings")
+	defer span.End()
+
+	nValidator := data.NewValidator()
+	nValidator.Check(RatingsAPIKey != "", "ratings", "no ratings provider is configured")
+	if !nValidator.Valid() {
+		span.RecordError(errors.New(createKeyValuePairs(nValidator.Errors)))
+		span.SetStatus(codes.Error, otelunprocessableErr)
+		app.failedValidationResponse(w, r, nValidator)
+		return
+	}
+
+	job, err := app.startRatingsImport(ctx, app.GetUserContext(r).ID)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, otelDBErr)
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	app.jobAcceptedResponse(w, r, job)
+}
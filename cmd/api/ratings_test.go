@@ -0,0 +1,70 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cybrarymin/greenlight/internal/httpclient"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFetchRatings(t *testing.T) {
+	defer func(providerURL, key string) {
+		RatingsProviderURL, RatingsAPIKey = providerURL, key
+	}(RatingsProviderURL, RatingsAPIKey)
+	RatingsAPIKey = "key"
+
+	float := func(f float64) *float64 { return &f }
+	int32p := func(i int32) *int32 { return &i }
+
+	tests := []struct {
+		name       string
+		status     int
+		body       string
+		imdb       *float64
+		tomatoes   *int32
+		wantErr    bool
+		wantAbsent bool
+	}{
+		{name: "Both scores", status: http.StatusOK, body: `{"Response":"True","imdbRating":"8.4","Ratings":[{"Source":"Internet Movie Database","Value":"8.4/10"},{"Source":"Rotten Tomatoes","Value":"85%"}]}`, imdb: float(8.4), tomatoes: int32p(85)},
+		{name: "Unrated on imdb", status: http.StatusOK, body: `{"Response":"True","imdbRating":"N/A","Ratings":[{"Source":"Rotten Tomatoes","Value":"40%"}]}`, tomatoes: int32p(40)},
+		{name: "No tomatometer", status: http.StatusOK, body: `{"Response":"True","imdbRating":"6.1","Ratings":[]}`, imdb: float(6.1)},
+		{name: "Out of range scores", status: http.StatusOK, body: `{"Response":"True","imdbRating":"11","Ratings":[{"Source":"Rotten Tomatoes","Value":"120%"}]}`},
+		{name: "Unknown movie", status: http.StatusOK, body: `{"Response":"False","Error":"Incorrect IMDb ID."}`, wantErr: true},
+		{name: "Not found movie", status: http.StatusOK, body: `{"Response":"False","Error":"Movie not found!"}`, wantErr: true, wantAbsent: true},
+		{name: "Provider failure", status: http.StatusUnauthorized, body: `{"Response":"False","Error":"Invalid API key!"}`, wantErr: true},
+		{name: "Malformed response", status: http.StatusOK, body: `<html>`, wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, "tt4154756", r.URL.Query().Get("i"))
+				assert.Equal(t, "key", r.URL.Query().Get("apikey"))
+				w.WriteHeader(tc.status)
+				w.Write([]byte(tc.body))
+			}))
+			defer provider.Close()
+			RatingsProviderURL = provider.URL + "/"
+
+			logger := zerolog.Nop()
+			cfg := httpclient.DefaultConfig()
+			cfg.MaxRetries = 0
+			app := &application{log: &logger, httpClient: httpclient.New(cfg)}
+
+			ratings, err := app.fetchRatings(context.Background(), "tt4154756")
+			if tc.wantErr {
+				require.Error(t, err)
+				assert.Equal(t, tc.wantAbsent, err == errRatingsNotFound)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.imdb, ratings.IMDbRating)
+			assert.Equal(t, tc.tomatoes, ratings.RottenTomatoesScore)
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/cybrarymin/greenlight/internal/data"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// redirectedOrNotFoundResponse sends the client of a resource which doesn't exist anymore to the one which has replaced it,
+// like the canonical movie of a merged movie. key is the path parameter holding the old id of the resource.
+// It's a 404 when the resource hasn't been replaced.
+func (app *application) redirectedOrNotFoundResponse(w http.ResponseWriter, r *http.Request, resource string, key string) {
+	span := trace.SpanFromContext(r.Context())
+	newID, err := app.models.Redirects.Lookup(r.Context(), resource, app.pathParams(r).ByName(key))
+	if err != nil {
+		if !errors.Is(err, data.ErrorRecordNotFound) {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, otelDBErr)
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		span.SetStatus(codes.Ok, otelDBNotFoundInfo)
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	location := redirectLocation(r, key, newID)
+	span.SetStatus(codes.Ok, "resource has been replaced")
+	headers := make(http.Header)
+	headers.Set("Location", location)
+	// 308 keeps the method and the body of the request, and it's cached by the clients for good
+	err = app.writeJson(w, r, http.StatusPermanentRedirect, envelope{"location": location}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// redirectLocation returns the path of the request with the path parameter key replaced by newID, along with its query.
+// The route pattern is matched against the end of the path since it may lack the prefix it's mounted on.
+func redirectLocation(r *http.Request, key string, newID string) string {
+	pattern := strings.Split(routePath(r), "/")
+	segments := strings.Split(r.URL.Path, "/")
+	if offset := len(segments) - len(pattern); offset >= 0 {
+		for i, segment := range pattern {
+			if segment == ":"+key {
+				segments[offset+i] = newID
+			}
+		}
+	}
+	location := strings.Join(segments, "/")
+	if r.URL.RawQuery != "" {
+		location += "?" + r.URL.RawQuery
+	}
+	return location
+}
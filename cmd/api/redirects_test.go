@@ -0,0 +1,37 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedirectLocation(t *testing.T) {
+	tests := []struct {
+		name     string
+		pattern  string
+		path     string
+		key      string
+		location string
+	}{
+		{name: "Movie", pattern: "/v1/movies/{id}", path: "/v1/movies/12", key: "id", location: "/v1/movies/40"},
+		{name: "Query is kept", pattern: "/v1/movies/{id}", path: "/v1/movies/12?fields=title", key: "id", location: "/v1/movies/40?fields=title"},
+		{name: "Mounted without its prefix", pattern: "/movies/{id}", path: "/api/v1/movies/12", key: "id", location: "/api/v1/movies/40"},
+		{name: "Nested resource", pattern: "/v1/movies/{id}/assets", path: "/v1/movies/12/assets", key: "id", location: "/v1/movies/40/assets"},
+		{name: "Only the key is replaced", pattern: "/v1/users/{user}/movies/{id}", path: "/v1/users/12/movies/12", key: "id", location: "/v1/users/12/movies/40"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rctx := chi.NewRouteContext()
+			rctx.RoutePatterns = []string{tc.pattern}
+			r := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			r = r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, rctx))
+			assert.Equal(t, tc.location, redirectLocation(r, tc.key, "40"))
+		})
+	}
+}
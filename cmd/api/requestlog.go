@@ -0,0 +1,46 @@
+package api
+
+import (
+	"net/http"
+	"regexp"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const requestIDHeader = "X-Request-Id"
+
+// rxRequestID limits the request ids propagated from the clients or the proxies to a safe charset to keep them out of log injections
+var rxRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// requestLogger derives the logger of the request with its id, method and path. The request id is propagated from the X-Request-Id header
+// when it's sent by the client or a proxy, otherwise a new one is generated. It's sent back in the response to correlate the reports.
+func (app *application) requestLogger(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(requestIDHeader)
+		if !rxRequestID.MatchString(requestID) {
+			requestID = uuid.NewString()
+		}
+		w.Header().Set(requestIDHeader, requestID)
+
+		logger := app.log.With().
+			Str("request_id", requestID).
+			Str("method", r.Method).
+			Str("path", r.URL.Path).
+			Logger()
+		next.ServeHTTP(w, app.SetLoggerContext(r, &logger))
+	})
+}
+
+// routeLogger adds the matched route and the trace id of the request span to the request logger.
+// It runs within otelHandler since both are only known once the router has matched the request and the span is started.
+func (app *application) routeLogger(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logCtx := app.logger(r.Context()).With().Str("route", routePath(r))
+		if sc := trace.SpanContextFromContext(r.Context()); sc.HasTraceID() {
+			logCtx = logCtx.Str("trace_id", sc.TraceID().String())
+		}
+		logger := logCtx.Logger()
+		next.ServeHTTP(w, app.SetLoggerContext(r, &logger))
+	})
+}
@@ -0,0 +1,157 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/cybrarymin/greenlight/docs"
+	"github.com/felixge/httpsnoop"
+	"github.com/getkin/kin-openapi/openapi2"
+	"github.com/getkin/kin-openapi/openapi2conv"
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/gorillamux"
+)
+
+// ValidateResponses checks the responses of the documented operations against the swagger document. The mismatching
+// responses are logged and replaced by 500, so the undocumented fields are caught before the api consumers run into them.
+// It buffers the responses and it's meant for the development only.
+var ValidateResponses bool
+
+// responseSchemas holds the operations of the swagger document along with the schemas of their responses
+type responseSchemas struct {
+	doc    *openapi3.T
+	router routers.Router
+}
+
+// loadResponseSchemas loads the swagger document served by the api. The objects of the document are closed, so the
+// undocumented fields of the responses fail the validation as well as the missing or mistyped ones.
+func loadResponseSchemas() (*responseSchemas, error) {
+	var doc2 openapi2.T
+	if err := json.Unmarshal([]byte(docs.SwaggerInfo.ReadDoc()), &doc2); err != nil {
+		return nil, fmt.Errorf("failed to parse the swagger document: %w", err)
+	}
+	doc, err := openapi2conv.ToV3(&doc2)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert the swagger document: %w", err)
+	}
+	// the operations are matched by their path only, whatever host the requests are sent to
+	doc.Servers = openapi3.Servers{{URL: doc2.BasePath}}
+	for _, schema := range doc.Components.Schemas {
+		closeSchema(schema.Value)
+	}
+	if err := doc.Validate(context.Background()); err != nil {
+		return nil, fmt.Errorf("invalid swagger document: %w", err)
+	}
+	router, err := gorillamux.NewRouter(doc)
+	if err != nil {
+		return nil, err
+	}
+	return &responseSchemas{doc: doc, router: router}, nil
+}
+
+// closeSchema disallows the properties of the object schemas which aren't documented
+func closeSchema(schema *openapi3.Schema) {
+	if schema == nil {
+		return
+	}
+	if len(schema.Properties) > 0 && schema.AdditionalProperties.Has == nil && schema.AdditionalProperties.Schema == nil {
+		closed := false
+		schema.AdditionalProperties.Has = &closed
+	}
+	for _, property := range schema.Properties {
+		closeSchema(property.Value)
+	}
+	if schema.Items != nil {
+		closeSchema(schema.Items.Value)
+	}
+}
+
+// operation returns the documented operation of the request. It's nil when the request isn't documented.
+func (s *responseSchemas) operation(r *http.Request) (*routers.Route, map[string]string) {
+	route, pathParams, err := s.router.FindRoute(r)
+	if err != nil {
+		return nil, nil
+	}
+	return route, pathParams
+}
+
+// validate checks the status, the content type and the body of the response against the operation
+func (s *responseSchemas) validate(r *http.Request, route *routers.Route, pathParams map[string]string, status int, header http.Header, body []byte) error {
+	return openapi3filter.ValidateResponse(r.Context(), &openapi3filter.ResponseValidationInput{
+		RequestValidationInput: &openapi3filter.RequestValidationInput{Request: r, PathParams: pathParams, Route: route},
+		Status:                 status,
+		Header:                 header,
+		Body:                   io.NopCloser(bytes.NewReader(body)),
+		Options:                &openapi3filter.Options{IncludeResponseStatus: true, MultiError: true},
+	})
+}
+
+// validateResponses holds the responses of the documented operations back until they're checked against the swagger
+// document. check ValidateResponses
+func (app *application) validateResponses(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.responseSchemas == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		route, pathParams := app.responseSchemas.operation(r)
+		if route == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		status := 0
+		var body bytes.Buffer
+		next.ServeHTTP(httpsnoop.Wrap(w, httpsnoop.Hooks{
+			WriteHeader: func(httpsnoop.WriteHeaderFunc) httpsnoop.WriteHeaderFunc {
+				return func(code int) {
+					if status == 0 {
+						status = code
+					}
+				}
+			},
+			Write: func(httpsnoop.WriteFunc) httpsnoop.WriteFunc {
+				return func(b []byte) (int, error) {
+					if status == 0 {
+						status = http.StatusOK
+					}
+					return body.Write(b)
+				}
+			},
+			ReadFrom: func(httpsnoop.ReadFromFunc) httpsnoop.ReadFromFunc {
+				return func(src io.Reader) (int64, error) {
+					if status == 0 {
+						status = http.StatusOK
+					}
+					return body.ReadFrom(src)
+				}
+			},
+			// nothing is sent before the response is checked
+			Flush: func(httpsnoop.FlushFunc) httpsnoop.FlushFunc {
+				return func() {}
+			},
+		}), r)
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		err := app.responseSchemas.validate(r, route, pathParams, status, w.Header(), body.Bytes())
+		if err != nil {
+			app.events(r.Context()).Error(err, "response doesn't match the swagger document", logFields{
+				"status": status,
+				"route":  r.Method + " " + route.Path,
+			})
+			w.Header().Del("Content-Length")
+			app.errorResponse(w, r, codeResponseSchemaMismatch, err.Error())
+			return
+		}
+		w.WriteHeader(status)
+		w.Write(body.Bytes())
+	})
+}
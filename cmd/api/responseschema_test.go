@@ -0,0 +1,66 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateResponses(t *testing.T) {
+	schemas, err := loadResponseSchemas()
+	require.NoError(t, err)
+
+	tests := []struct {
+		name        string
+		disabled    bool
+		target      string
+		contentType string
+		status      int
+		body        string
+		wantStatus  int
+		wantCode    errorCode
+	}{
+		{name: "Documented response", target: "/v1/movies/1", contentType: "application/json", status: http.StatusNotFound, body: `{"code":"not_found","error":"the requested resource couldn't be found"}`, wantStatus: http.StatusNotFound},
+		{name: "Documented redirect", target: "/v1/movies/1", contentType: "application/json", status: http.StatusPermanentRedirect, body: `{"location":"/v1/movies/2"}`, wantStatus: http.StatusPermanentRedirect},
+		{name: "Undocumented field", target: "/v1/movies/1", contentType: "application/json", status: http.StatusOK, body: `{"Movie":{"id":1,"Title":"avengers"}}`, wantStatus: http.StatusInternalServerError, wantCode: codeResponseSchemaMismatch},
+		{name: "Mistyped field", target: "/v1/movies/1", contentType: "application/json", status: http.StatusNotFound, body: `{"code":404,"error":"the requested resource couldn't be found"}`, wantStatus: http.StatusInternalServerError, wantCode: codeResponseSchemaMismatch},
+		{name: "Undocumented status", target: "/v1/movies/1", contentType: "application/json", status: http.StatusTeapot, body: `{}`, wantStatus: http.StatusInternalServerError, wantCode: codeResponseSchemaMismatch},
+		{name: "Undocumented content type", target: "/v1/movies/1", contentType: "text/plain", status: http.StatusNotFound, body: "not found", wantStatus: http.StatusInternalServerError, wantCode: codeResponseSchemaMismatch},
+		{name: "Undocumented operation", target: "/v1/healthcheck", contentType: "application/json", status: http.StatusOK, body: `{"Status":"available"}`, wantStatus: http.StatusOK},
+		{name: "Validation disabled", disabled: true, target: "/v1/movies/1", contentType: "application/json", status: http.StatusOK, body: `{"Movie":{"id":1,"Title":"avengers"}}`, wantStatus: http.StatusOK},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			logger := zerolog.Nop()
+			app := &application{log: &logger, responseSchemas: schemas}
+			if tc.disabled {
+				app.responseSchemas = nil
+			}
+			handler := app.validateResponses(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", tc.contentType)
+				w.WriteHeader(tc.status)
+				w.Write([]byte(tc.body))
+			}))
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tc.target, nil))
+
+			assert.Equal(t, tc.wantStatus, rec.Code)
+			if tc.wantCode == "" {
+				assert.Equal(t, tc.body, rec.Body.String())
+				return
+			}
+			var body struct {
+				Code errorCode `json:"code"`
+			}
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+			assert.Equal(t, tc.wantCode, body.Code)
+		})
+	}
+}
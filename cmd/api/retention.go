@@ -0,0 +1,124 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/cybrarymin/greenlight/internal/data"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/codes"
+)
+
+var (
+	// RetentionInterval is how often the retention job runs. It's disabled when 0, the admins can still run it on demand.
+	RetentionInterval time.Duration
+	// RetentionDryRun only counts the rows the scheduled retention would affect
+	RetentionDryRun bool
+	// RetentionInactiveUserDays anonymizes or purges the users who haven't signed in for the days. disabled when 0
+	RetentionInactiveUserDays int
+	// RetentionInactiveUserAction is either data.RetentionAnonymize or data.RetentionPurge
+	RetentionInactiveUserAction string
+	// RetentionAuditLogDays deletes the audit events older than the days. disabled when 0
+	RetentionAuditLogDays int
+	// RetentionEmailLogDays deletes the email logs older than the days. disabled when 0
+	RetentionEmailLogDays int
+)
+
+// retentionResult is the number of the rows affected by the retention of a target
+type retentionResult struct {
+	Target string `json:"target"`
+	Action string `json:"action"`
+	Before string `json:"before"`
+	Rows   int64  `json:"rows"`
+}
+
+// retentionTarget is a kind of data the retention applies to after a number of days
+type retentionTarget struct {
+	name   string
+	action string
+	days   int
+	apply  func(ctx context.Context, before time.Time, dryRun bool) (int64, error)
+}
+
+func (app *application) retentionTargets() []retentionTarget {
+	targets := []retentionTarget{
+		{name: "inactive_users", action: RetentionInactiveUserAction, days: RetentionInactiveUserDays, apply: func(ctx context.Context, before time.Time, dryRun bool) (int64, error) {
+			return app.models.Retention.InactiveUsers(ctx, before, RetentionInactiveUserAction, dryRun)
+		}},
+		{name: "audit_logs", action: "delete", days: RetentionAuditLogDays, apply: app.models.Retention.AuditLogs},
+		{name: "email_logs", action: "delete", days: RetentionEmailLogDays, apply: app.models.Retention.EmailLogs},
+	}
+	enabled := targets[:0]
+	for _, target := range targets {
+		if target.days > 0 {
+			enabled = append(enabled, target)
+		}
+	}
+	return enabled
+}
+
+// startRetention starts the retention job of the enabled targets. Every target is applied independently,
+// so a failing target is reported as an item error without stopping the others.
+func (app *application) startRetention(ctx context.Context, createdBy uuid.UUID, dryRun bool) (*data.Job, error) {
+	targets := app.retentionTargets()
+	return app.startJob(ctx, data.JobRetention, createdBy, len(targets), func(ctx context.Context, progress *jobProgress) (interface{}, error) {
+		results := []retentionResult{}
+		for i, target := range targets {
+			before := time.Now().AddDate(0, 0, -target.days)
+			rows, err := target.apply(ctx, before, dryRun)
+			if err != nil {
+				app.events(ctx).Error(err, "data retention failed", logFields{"target": target.name})
+				progress.report(1, 1, []data.JobItemError{{Item: i, Key: target.name, Errors: map[string]string{"error": err.Error()}}})
+				continue
+			}
+			recordRetention(ctx, target.name, target.action, dryRun, rows)
+			app.events(ctx).Info("data retention applied", logFields{"target": target.name, "action": target.action, "dry_run": dryRun, "rows": rows})
+			results = append(results, retentionResult{Target: target.name, Action: target.action, Before: before.Format(time.DateOnly), Rows: rows})
+			progress.report(1, 0, nil)
+		}
+		return map[string]interface{}{"dry_run": dryRun, "targets": results}, nil
+	})
+}
+
+// runRetentionScheduler starts the retention job every RetentionInterval for the lifetime of the server.
+// The targets are idempotent, so the replicas running it at the same time only repeat each other's work.
+func (app *application) runRetentionScheduler() {
+	ticker := time.NewTicker(RetentionInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if app.draining.Load() {
+			return
+		}
+		_, err := app.startRetention(context.Background(), uuid.Nil, RetentionDryRun)
+		if err != nil {
+			logEvents(app.log).Error(err, "failed to start the data retention job", nil)
+		}
+	}
+}
+
+// runRetentionHandler runs the retention right away. dry_run only counts the rows it would affect, it defaults to --retention-dry-run.
+func (app *application) runRetentionHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := app.startSpan(r, "runRetention")
+	defer span.End()
+
+	nValidator := data.NewValidator()
+	dryRun := app.readBool(r.URL.Query(), "dry_run", RetentionDryRun, nValidator)
+	nValidator.Check(len(app.retentionTargets()) > 0, "targets", "no retention target is configured")
+	if !nValidator.Valid() {
+		span.RecordError(errors.New(createKeyValuePairs(nValidator.Errors)))
+		span.SetStatus(codes.Error, otelunprocessableErr)
+		app.failedValidationResponse(w, r, nValidator)
+		return
+	}
+
+	job, err := app.startRetention(ctx, app.GetUserContext(r).ID, dryRun)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, otelDBErr)
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	app.jobAcceptedResponse(w, r, job)
+}
@@ -0,0 +1,12 @@
+//go:build !unix
+
+package api
+
+import (
+	"errors"
+	"syscall"
+)
+
+func reusePortControl(network, address string, c syscall.RawConn) error {
+	return errors.New("SO_REUSEPORT isn't supported on this platform")
+}
@@ -0,0 +1,306 @@
+package api
+
+import (
+	"cmp"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// authLevel is what a route requires from the callers to serve them
+type authLevel string
+
+const (
+	// authPublic routes serve everyone without any credential
+	authPublic authLevel = "public"
+	// authOptional routes authenticate the callers who send credentials and serve the anonymous ones as well
+	authOptional authLevel = "optional"
+	// authActivated routes require an authenticated and activated user
+	authActivated authLevel = "activated"
+	// authExternal routes authenticate the callers by their own credential, like a signature or a dedicated token
+	authExternal authLevel = "external"
+)
+
+// authLevels orders the levels which add up along a chain, a later middleware can only raise the level of the route
+var authLevels = map[authLevel]int{authPublic: 1, authOptional: 2, authActivated: 3}
+
+// middleware wraps the handlers of a route group. auth and permission describe what it requires from the callers,
+// they're empty for the middlewares which don't authenticate or authorize.
+type middleware struct {
+	wrap func(next http.HandlerFunc) http.HandlerFunc
+	// bypass is set instead of wrap by the middlewares which may serve the handler without the rest of the chain.
+	// The route is reachable with the auth level of such a middleware whatever the rest requires.
+	bypass     func(handler http.HandlerFunc, next http.HandlerFunc) http.HandlerFunc
+	auth       authLevel
+	permission string
+}
+
+// routeInfo describes a registered route and the access it requires. Anonymous marks the anonymous tier, the routes
+// serving the callers without any credential. The others answer them by authentication_required along with the links
+// to sign up. check authenticationLinks
+type routeInfo struct {
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	Versioned  bool      `json:"versioned"`
+	Auth       authLevel `json:"auth"`
+	Permission string    `json:"permission,omitempty"`
+	Anonymous  bool      `json:"anonymous"`
+}
+
+// routeGroup registers routes under a common path prefix with a common middleware chain, so a route can't be added
+// without an auth layer. Every route is wrapped by otelHandler and recorded in the route table of the application.
+// The paths name their parameters like /movies/:id, the static segments take precedence over the parameters, so
+// /movies/changes and /movies/:id are separate routes.
+type routeGroup struct {
+	app         *application
+	router      chi.Router
+	prefix      string
+	versioned   bool
+	untraced    bool
+	middlewares []middleware
+}
+
+// newRouteGroup returns the root group of the api routes which are registered under every version prefix. check versionedRouter
+func (app *application) newRouteGroup(router chi.Router) *routeGroup {
+	return &routeGroup{app: app, router: router, versioned: true}
+}
+
+// group returns a sub group of the routes under the prefix sharing the middlewares of g
+func (g *routeGroup) group(prefix string) *routeGroup {
+	ng := *g
+	ng.prefix = g.prefix + prefix
+	ng.middlewares = append([]middleware(nil), g.middlewares...)
+	return &ng
+}
+
+// use returns a sub group with the middlewares appended to the chain of g. The first middleware is the outermost one.
+func (g *routeGroup) use(middlewares ...middleware) *routeGroup {
+	ng := g.group("")
+	ng.middlewares = append(ng.middlewares, middlewares...)
+	return ng
+}
+
+// unversioned returns a sub group registering the routes without the version prefixes, like the scim or the short link routes
+func (g *routeGroup) unversioned() *routeGroup {
+	ng := g.group("")
+	ng.versioned = false
+	return ng
+}
+
+// withoutTracing returns a sub group whose routes aren't wrapped by otelHandler
+func (g *routeGroup) withoutTracing() *routeGroup {
+	ng := g.group("")
+	ng.untraced = true
+	return ng
+}
+
+// chain wraps the handler by the middlewares of the group and the route specific ones, which are the outermost.
+func (g *routeGroup) chain(handler http.HandlerFunc, middlewares ...middleware) http.HandlerFunc {
+	all := append(append([]middleware(nil), middlewares...), g.middlewares...)
+	next := handler
+	for i := len(all) - 1; i >= 0; i-- {
+		if all[i].bypass != nil {
+			next = all[i].bypass(handler, next)
+			continue
+		}
+		next = all[i].wrap(next)
+	}
+	return next
+}
+
+// access returns the auth level and the permission the middlewares require. The level is empty when none of them declares one.
+func access(middlewares []middleware) (authLevel, string) {
+	var level, bypassLevel authLevel
+	var permission string
+	for _, m := range middlewares {
+		if m.permission != "" {
+			permission = m.permission
+		}
+		switch {
+		case m.auth == "":
+		case m.bypass != nil:
+			if bypassLevel == "" {
+				bypassLevel = m.auth
+			}
+		case m.auth == authExternal || authLevels[m.auth] > authLevels[level]:
+			level = m.auth
+		}
+	}
+	if bypassLevel != "" {
+		return bypassLevel, permission
+	}
+	return level, permission
+}
+
+// handle registers the handler on the path of the group. It panics when neither the group nor the route declares an auth layer,
+// the routes which don't require any credential have to use public explicitly.
+func (g *routeGroup) handle(method, path string, handler http.HandlerFunc, middlewares ...middleware) {
+	h := g.record(method, path, handler, middlewares...)
+	if !g.untraced {
+		h = g.app.otelHandler(h)
+	}
+	if g.versioned {
+		versionedRouter{g.app, g.router}.HandlerFunc(method, g.prefix+path, h)
+		return
+	}
+	g.router.MethodFunc(method, chiPattern(g.prefix+path), h)
+}
+
+// chiPattern turns the :name parameters of the path into the {name} ones of chi
+func chiPattern(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if name, found := strings.CutPrefix(segment, ":"); found {
+			segments[i] = "{" + name + "}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// routeMethods registers the routes of a single path of a group by their methods. check route
+type routeMethods struct {
+	group *routeGroup
+	path  string
+}
+
+// route returns the path of the group to register its methods on, like
+// route("/admin/config").get(app.showRuntimeConfigHandler).patch(app.updateRuntimeConfigHandler)
+func (g *routeGroup) route(path string) routeMethods {
+	return routeMethods{group: g, path: path}
+}
+
+func (rs routeMethods) get(handler http.HandlerFunc, middlewares ...middleware) routeMethods {
+	rs.group.handle(http.MethodGet, rs.path, handler, middlewares...)
+	return rs
+}
+
+func (rs routeMethods) post(handler http.HandlerFunc, middlewares ...middleware) routeMethods {
+	rs.group.handle(http.MethodPost, rs.path, handler, middlewares...)
+	return rs
+}
+
+func (rs routeMethods) put(handler http.HandlerFunc, middlewares ...middleware) routeMethods {
+	rs.group.handle(http.MethodPut, rs.path, handler, middlewares...)
+	return rs
+}
+
+func (rs routeMethods) patch(handler http.HandlerFunc, middlewares ...middleware) routeMethods {
+	rs.group.handle(http.MethodPatch, rs.path, handler, middlewares...)
+	return rs
+}
+
+func (rs routeMethods) delete(handler http.HandlerFunc, middlewares ...middleware) routeMethods {
+	rs.group.handle(http.MethodDelete, rs.path, handler, middlewares...)
+	return rs
+}
+
+// handleWithID registers a route whose path parameters are all integer ids, like /movies/:id/assets/:asset_id.
+// They're parsed once the request has passed the middlewares of the route, the malformed ones are rejected by 422
+// and the ones which can't match any record by 404. The handler reads them by idParam.
+func (g *routeGroup) handleWithID(method, path string, handler http.HandlerFunc, middlewares ...middleware) {
+	g.handle(method, path, g.app.withIDParams(path, handler), middlewares...)
+}
+
+// handleWithUUID registers a route whose path parameters are all uuids, like /users/:id, the same way as handleWithID.
+// The handler reads them by uuidParam.
+func (g *routeGroup) handleWithUUID(method, path string, handler http.HandlerFunc, middlewares ...middleware) {
+	g.handle(method, path, g.app.withUUIDParams(path, handler), middlewares...)
+}
+
+// record adds the route to the route table and returns the handler wrapped by its chain
+func (g *routeGroup) record(method, path string, handler http.HandlerFunc, middlewares ...middleware) http.HandlerFunc {
+	fullPath := g.prefix + path
+	level, permission := access(append(append([]middleware(nil), middlewares...), g.middlewares...))
+	if level == "" {
+		panic(fmt.Sprintf("route %s %s has no auth layer", method, fullPath))
+	}
+	g.app.routeTable = append(g.app.routeTable, routeInfo{
+		Method:     method,
+		Path:       fullPath,
+		Versioned:  g.versioned,
+		Auth:       level,
+		Permission: permission,
+		Anonymous:  level == authPublic || level == authOptional,
+	})
+	// the scoped tokens only reach the routes requiring a permission of their scope
+	if level == authActivated && permission == "" {
+		handler = g.app.requireUnscopedToken(handler)
+	}
+	return g.chain(handler, middlewares...)
+}
+
+// listRoutesHandler lists the registered routes along with the auth level and the permission they require,
+// so the security reviews and the capability checks of the frontend share a single source of truth.
+// The versioned routes are served under every enabled version prefix like /v1.
+func (app *application) listRoutesHandler(w http.ResponseWriter, r *http.Request) {
+	_, span := app.startSpan(r, "listRoutes")
+	defer span.End()
+
+	routes := slices.Clone(app.routeTable)
+	slices.SortStableFunc(routes, func(a, b routeInfo) int {
+		return cmp.Or(cmp.Compare(a.Path, b.Path), cmp.Compare(a.Method, b.Method))
+	})
+
+	err := app.writeJson(w, r, http.StatusOK, envelope{"routes": routes}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// public marks the routes which don't require any credential
+func (app *application) public() middleware {
+	return middleware{wrap: func(next http.HandlerFunc) http.HandlerFunc { return next }, auth: authPublic}
+}
+
+// authenticate authenticates the callers who send credentials, the others are served as the anonymous user. check Auth
+func (app *application) authenticate() middleware {
+	return middleware{wrap: app.Auth, auth: authOptional}
+}
+
+// activated requires an activated user. It must follow authenticate in the chain.
+func (app *application) activated() middleware {
+	return middleware{wrap: app.requireActivatedUser, auth: authActivated}
+}
+
+// permission requires the permission from the user. It must follow activated in the chain.
+func (app *application) permission(code string) middleware {
+	return middleware{wrap: func(next http.HandlerFunc) http.HandlerFunc {
+		return app.requirePermission(code, next)
+	}, permission: code}
+}
+
+// external marks the routes authenticating the callers by their own credential, wrap checks it when it's not done by the handler itself
+func (app *application) external(wrap func(next http.HandlerFunc) http.HandlerFunc) middleware {
+	if wrap == nil {
+		wrap = func(next http.HandlerFunc) http.HandlerFunc { return next }
+	}
+	return middleware{wrap: wrap, auth: authExternal}
+}
+
+// challenge requires a captcha or a proof of work solution. check requireChallenge
+func (app *application) challenge() middleware {
+	return middleware{wrap: func(next http.HandlerFunc) http.HandlerFunc {
+		return app.requireChallenge(next).ServeHTTP
+	}}
+}
+
+// metricsAccess requires the metrics credentials when they're configured. check metricsAuth
+func (app *application) metricsAccess() middleware {
+	if MetricsBearerToken == "" && MetricsBasicAuthUsername == "" {
+		return app.public()
+	}
+	return app.external(func(next http.HandlerFunc) http.HandlerFunc {
+		return app.metricsAuth(next).ServeHTTP
+	})
+}
+
+// publicCatalogAccess serves the handler to the anonymous users when the public catalog is enabled. check publicCatalog
+func (app *application) publicCatalogAccess() middleware {
+	if !PublicCatalog {
+		return middleware{wrap: func(next http.HandlerFunc) http.HandlerFunc { return next }}
+	}
+	return middleware{bypass: app.publicCatalog, auth: authPublic}
+}
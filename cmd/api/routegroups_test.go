@@ -0,0 +1,259 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoutesHaveAuthLayer(t *testing.T) {
+	// the optional routes are enabled so they're checked as well
+	defer func(shareKey, baseURL, registration, challenge, webhookSecret, scimToken, purgeURL string, sessions, catalog bool) {
+		ShareSigningKey, PublicBaseURL, RegistrationMode, ChallengeProvider, EmailWebhookSecret, SCIMBearerToken = shareKey, baseURL, registration, challenge, webhookSecret, scimToken
+		CDNPurgeURL, CookieSessions, PublicCatalog = purgeURL, sessions, catalog
+	}(ShareSigningKey, PublicBaseURL, RegistrationMode, ChallengeProvider, EmailWebhookSecret, SCIMBearerToken, CDNPurgeURL, CookieSessions, PublicCatalog)
+	ShareSigningKey, PublicBaseURL, RegistrationMode, ChallengeProvider = "key", "https://example.com", RegistrationInvite, ChallengeProofOfWork
+	EmailWebhookSecret, SCIMBearerToken, CDNPurgeURL = "secret", "token", "https://cdn.example.com/purge"
+	CookieSessions, PublicCatalog = true, true
+
+	logger := zerolog.Nop()
+	app := &application{log: &logger}
+	app.runtime.Store(&runtimeConfig{})
+	assert.NotPanics(t, func() { app.routes() })
+	assert.NotEmpty(t, app.routeTable)
+
+	for _, route := range app.routeTable {
+		t.Run(route.Method+" "+route.Path, func(t *testing.T) {
+			assert.Contains(t, []authLevel{authPublic, authOptional, authActivated, authExternal}, route.Auth)
+			if strings.HasPrefix(route.Path, "/admin/") {
+				assert.Equal(t, authActivated, route.Auth, "admin routes must require an activated user")
+				assert.NotEmpty(t, route.Permission, "admin routes must require a permission")
+			}
+			if route.Permission != "" && route.Auth != authPublic {
+				assert.Equal(t, authActivated, route.Auth, "permissions are only checked for the activated users")
+			}
+		})
+	}
+}
+
+func TestAnonymousTier(t *testing.T) {
+	defer func(shareKey, baseURL, registration, challenge, webhookSecret, scimToken, purgeURL string, sessions, catalog bool) {
+		ShareSigningKey, PublicBaseURL, RegistrationMode, ChallengeProvider, EmailWebhookSecret, SCIMBearerToken = shareKey, baseURL, registration, challenge, webhookSecret, scimToken
+		CDNPurgeURL, CookieSessions, PublicCatalog = purgeURL, sessions, catalog
+	}(ShareSigningKey, PublicBaseURL, RegistrationMode, ChallengeProvider, EmailWebhookSecret, SCIMBearerToken, CDNPurgeURL, CookieSessions, PublicCatalog)
+	ShareSigningKey, PublicBaseURL, RegistrationMode, ChallengeProvider = "key", "https://example.com", RegistrationInvite, ChallengeProofOfWork
+	EmailWebhookSecret, SCIMBearerToken, CDNPurgeURL = "secret", "token", "https://cdn.example.com/purge"
+	CookieSessions, PublicCatalog = true, true
+
+	logger := zerolog.Nop()
+	app := &application{log: &logger}
+	app.runtime.Store(&runtimeConfig{})
+	app.routes()
+
+	// a route joins the anonymous tier deliberately, by adding it here
+	want := []string{
+		"GET /healthcheck",
+		"GET /readyz",
+		"GET /version",
+		"GET /csrf",
+		"GET /movies",
+		"GET /movies/autocomplete",
+		"GET /movies/" + feedRSS,
+		"GET /movies/" + feedAtom,
+		"GET /movies/:id",
+		"GET /s/:code",
+		"GET /sitemap.xml",
+		"POST /users",
+		"PUT /users/:id/activate",
+		"POST /challenges",
+		"GET /metrics",
+	}
+	var got []string
+	for _, route := range app.routeTable {
+		if route.Anonymous {
+			got = append(got, route.Method+" "+route.Path)
+		}
+	}
+	assert.ElementsMatch(t, want, got)
+}
+
+func TestRouteWithoutAuthLayerPanics(t *testing.T) {
+	logger := zerolog.Nop()
+	app := &application{log: &logger}
+	g := app.newRouteGroup(nil).group("/movies")
+	assert.Panics(t, func() { g.handle(http.MethodGet, "", app.listMovieHandler) })
+	assert.Empty(t, app.routeTable)
+}
+
+func TestRouteAccess(t *testing.T) {
+	app := &application{}
+	catalog := middleware{bypass: app.publicCatalog, auth: authPublic}
+
+	tests := []struct {
+		name        string
+		middlewares []middleware
+		auth        authLevel
+		permission  string
+	}{
+		{name: "No auth layer", middlewares: []middleware{app.challenge()}, auth: ""},
+		{name: "Public", middlewares: []middleware{app.public()}, auth: authPublic},
+		{name: "Activated with permission", middlewares: []middleware{app.authenticate(), app.activated(), app.permission("movies:read")}, auth: authActivated, permission: "movies:read"},
+		{name: "Later middleware raises the level", middlewares: []middleware{app.public(), app.authenticate()}, auth: authOptional},
+		{name: "Bypass keeps its level", middlewares: []middleware{catalog, app.authenticate(), app.activated(), app.permission("movies:read")}, auth: authPublic, permission: "movies:read"},
+		{name: "External", middlewares: []middleware{app.external(nil), app.challenge()}, auth: authExternal},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			auth, permission := access(tc.middlewares)
+			assert.Equal(t, tc.auth, auth)
+			assert.Equal(t, tc.permission, permission)
+		})
+	}
+}
+
+func TestRouteTokenScope(t *testing.T) {
+	logger := zerolog.Nop()
+	app := &application{log: &logger}
+	app.runtime.Store(&runtimeConfig{})
+	router := chi.NewRouter()
+	// stands in for the authentication of a token narrowed to the scope header
+	scoped := middleware{wrap: func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if scope := r.Header.Get("Scope"); scope != "" {
+				r = app.SetTokenScopeContext(r, strings.Fields(scope))
+			}
+			next(w, r)
+		}
+	}, auth: authActivated}
+	g := app.newRouteGroup(router).unversioned().withoutTracing().use(scoped)
+	ok := func(w http.ResponseWriter, r *http.Request) { fmt.Fprint(w, "ok") }
+	g.handle(http.MethodDelete, "/users/me/devices/1", ok)
+	g.use(middleware{wrap: func(next http.HandlerFunc) http.HandlerFunc { return next }, permission: "movies:read"}).handle(http.MethodGet, "/movies", ok)
+	public := app.newRouteGroup(router).unversioned().withoutTracing().use(app.public())
+	public.handle(http.MethodGet, "/healthcheck", ok)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		scope  string
+		status int
+	}{
+		{name: "Unscoped token without a permission", method: http.MethodDelete, path: "/users/me/devices/1", status: http.StatusOK},
+		{name: "Scoped token without a permission", method: http.MethodDelete, path: "/users/me/devices/1", scope: "movies:read", status: http.StatusForbidden},
+		{name: "Scoped token with a permission", method: http.MethodGet, path: "/movies", scope: "movies:read", status: http.StatusOK},
+		{name: "Scoped token on a public route", method: http.MethodGet, path: "/healthcheck", scope: "movies:read", status: http.StatusOK},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(tc.method, tc.path, nil)
+			r.Header.Set("Scope", tc.scope)
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, r)
+			assert.Equal(t, tc.status, rr.Code, rr.Body.String())
+		})
+	}
+}
+
+func TestRoutePathParams(t *testing.T) {
+	logger := zerolog.Nop()
+	app := &application{log: &logger}
+	app.runtime.Store(&runtimeConfig{})
+	router := chi.NewRouter()
+	g := app.newRouteGroup(router).unversioned().withoutTracing().use(app.public())
+	g.handleWithID(http.MethodGet, "/movies/:id/assets/:asset_id", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%d/%d", app.idParam(r, "id"), app.idParam(r, "asset_id"))
+	})
+	g.handleWithUUID(http.MethodGet, "/users/:id", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, app.uuidParam(r, "id"))
+	})
+	id := uuid.New()
+
+	tests := []struct {
+		name   string
+		path   string
+		status int
+		body   string
+	}{
+		{name: "Ids", path: "/movies/12/assets/3", status: http.StatusOK, body: "12/3"},
+		{name: "Malformed id", path: "/movies/12/assets/abc", status: http.StatusUnprocessableEntity},
+		{name: "Zero id", path: "/movies/0/assets/3", status: http.StatusUnprocessableEntity},
+		{name: "Negative id", path: "/movies/-1/assets/3", status: http.StatusUnprocessableEntity},
+		{name: "Uuid", path: "/users/" + id.String(), status: http.StatusOK, body: id.String()},
+		{name: "Malformed uuid", path: "/users/12", status: http.StatusUnprocessableEntity},
+		{name: "Nil uuid", path: "/users/" + uuid.Nil.String(), status: http.StatusNotFound},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, tc.path, nil))
+			assert.Equal(t, tc.status, rr.Code)
+			if tc.body != "" {
+				assert.Equal(t, tc.body, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestRouter(t *testing.T) {
+	logger := zerolog.Nop()
+	app := &application{log: &logger}
+	app.runtime.Store(&runtimeConfig{})
+	router := chi.NewRouter()
+	router.NotFound(app.routeNotFound(router))
+	router.MethodNotAllowed(app.routeMethodNotAllowed(router))
+	g := app.newRouteGroup(router).unversioned().withoutTracing().use(app.public())
+	g.route("/movies").
+		get(func(w http.ResponseWriter, r *http.Request) { fmt.Fprint(w, "list") }).
+		post(func(w http.ResponseWriter, r *http.Request) { fmt.Fprint(w, "create") })
+	g.handle(http.MethodGet, "/movies/changes", func(w http.ResponseWriter, r *http.Request) { fmt.Fprint(w, "changes") })
+	g.handleWithID(http.MethodGet, "/movies/:id", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "show %d %s", app.idParam(r, "id"), routePath(r))
+	})
+	g.handle(http.MethodPut, "/movies/by-external-id/:source/:id", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "upsert %s", app.pathParams(r).ByName("source"))
+	})
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		status   int
+		body     string
+		location string
+		allow    string
+	}{
+		{name: "Static segment next to a parameter", method: http.MethodGet, path: "/movies/changes", status: http.StatusOK, body: "changes"},
+		{name: "Parameter next to a static segment", method: http.MethodGet, path: "/movies/12", status: http.StatusOK, body: "show 12 /movies/:id"},
+		{name: "Static segment of another method", method: http.MethodPut, path: "/movies/by-external-id/imdb/tt4154756", status: http.StatusOK, body: "upsert imdb"},
+		{name: "Methods of a path", method: http.MethodPost, path: "/movies", status: http.StatusOK, body: "create"},
+		{name: "Trailing slash of a GET", method: http.MethodGet, path: "/movies/?page=2", status: http.StatusMovedPermanently, location: "/movies?page=2"},
+		{name: "Trailing slash of a POST", method: http.MethodPost, path: "/movies/", status: http.StatusPermanentRedirect, location: "/movies"},
+		{name: "Unknown path", method: http.MethodGet, path: "/series/", status: http.StatusNotFound},
+		{name: "Preflight", method: http.MethodOptions, path: "/movies", status: http.StatusOK, allow: "GET, POST, OPTIONS"},
+		{name: "Method not allowed", method: http.MethodDelete, path: "/movies/12", status: http.StatusMethodNotAllowed, allow: "GET, OPTIONS"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, httptest.NewRequest(tc.method, tc.path, nil))
+			assert.Equal(t, tc.status, rr.Code)
+			if tc.body != "" {
+				assert.Equal(t, tc.body, rr.Body.String())
+			}
+			assert.Equal(t, tc.location, rr.Header().Get("Location"))
+			assert.Equal(t, tc.allow, rr.Header().Get("Allow"))
+		})
+	}
+}
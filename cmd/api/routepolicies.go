@@ -0,0 +1,188 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/felixge/httpsnoop"
+	"gopkg.in/yaml.v3"
+)
+
+// RoutePoliciesFile is the yaml file declaring the policies of the routes. check routePolicies
+var RoutePoliciesFile string
+
+// routePolicy tunes the routes matching its route, like "GET /movies/:id", without code changes. The route is given
+// without the version prefix, :name segments match any path segment and * matches any method. The zero values leave
+// the route to the flags.
+type routePolicy struct {
+	Route string `yaml:"route"`
+	// CacheTTL is the max-age of the Cache-Control header of the successful GET responses which don't set their own
+	CacheTTL time.Duration `yaml:"cache_ttl"`
+	// RateTier limits the requests of each client to the route by the rate of the tier, on top of the global limits
+	RateTier string `yaml:"rate_tier"`
+	// Timeout cancels the context of the request once it passes
+	Timeout time.Duration `yaml:"timeout"`
+	// MaxBodySize overrides the size limit of the request body. check bodyLimit
+	MaxBodySize int64 `yaml:"max_body_size"`
+}
+
+// routePolicies is the content of RoutePoliciesFile, like
+//
+//	rate_tiers:
+//	  strict: 2
+//	routes:
+//	  - route: POST /tokens/authentication
+//	    rate_tier: strict
+//	  - route: GET /movies
+//	    cache_ttl: 1m
+//	    timeout: 5s
+//
+// The first policy matching the request applies.
+type routePolicies struct {
+	// RateTiers are the per client rate limits in requests per second the policies refer to by name
+	RateTiers map[string]int64 `yaml:"rate_tiers"`
+	Routes    []routePolicy    `yaml:"routes"`
+	limiters  map[string]*clientLimiters
+}
+
+// loadRoutePolicies reads and validates the policies of the file. It returns nil when no file is configured.
+func loadRoutePolicies(path string) (*routePolicies, error) {
+	if path == "" {
+		return nil, nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var policies routePolicies
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	// a misspelled setting would be ignored silently otherwise
+	dec.KnownFields(true)
+	err = dec.Decode(&policies)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	problems := policies.problems()
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("%s: %s", path, strings.Join(problems, ", "))
+	}
+	return &policies, nil
+}
+
+// problems reports the invalid policies
+func (p *routePolicies) problems() []string {
+	var problems []string
+	for tier, limit := range p.RateTiers {
+		if limit <= 0 {
+			problems = append(problems, fmt.Sprintf("rate tier %s must be greater than 0", tier))
+		}
+	}
+	for i, policy := range p.Routes {
+		method, pattern, found := strings.Cut(policy.Route, " ")
+		if !found || method == "" || !strings.HasPrefix(pattern, "/") {
+			problems = append(problems, fmt.Sprintf("route of the policy %d must be a method and a path like \"GET /movies\", got %q", i+1, policy.Route))
+		}
+		if _, ok := p.RateTiers[policy.RateTier]; policy.RateTier != "" && !ok {
+			problems = append(problems, fmt.Sprintf("rate tier %s of %s isn't declared in rate_tiers", policy.RateTier, policy.Route))
+		}
+		if policy.CacheTTL < 0 || policy.Timeout < 0 || policy.MaxBodySize < 0 {
+			problems = append(problems, fmt.Sprintf("cache_ttl, timeout and max_body_size of %s can't be negative", policy.Route))
+		}
+	}
+	return problems
+}
+
+// match returns the first policy of the request, or nil when none matches
+func (p *routePolicies) match(r *http.Request) *routePolicy {
+	if p == nil {
+		return nil
+	}
+	path := r.URL.Path
+	if version := apiVersionPrefix(path); version != 0 {
+		path = strings.TrimPrefix(path, fmt.Sprintf("/v%d", version))
+	}
+	for i, policy := range p.Routes {
+		method, pattern, _ := strings.Cut(policy.Route, " ")
+		if (method == "*" || method == r.Method) && matchRoute(pattern, path) {
+			return &p.Routes[i]
+		}
+	}
+	return nil
+}
+
+// applyRoutePolicies applies the rate tier, the timeout and the cache ttl of the policy matching the request.
+// The body size is applied by limitRequestBody.
+func (app *application) applyRoutePolicies(next http.Handler) http.Handler {
+	if app.routePolicies == nil {
+		return next
+	}
+	app.routePolicies.limiters = make(map[string]*clientLimiters, len(app.routePolicies.RateTiers))
+	for tier := range app.routePolicies.RateTiers {
+		app.routePolicies.limiters[tier] = newClientLimiters(app.log)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		policy := app.routePolicies.match(r)
+		if policy == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if policy.RateTier != "" && app.runtimeCfg().RateLimitEnabled {
+			if !app.routePolicies.limiters[policy.RateTier].allow(clientIP(r), app.routePolicies.RateTiers[policy.RateTier]) {
+				app.rateLimitExceedResponse(w, r)
+				return
+			}
+		}
+		if policy.Timeout > 0 {
+			ctx, cancel := context.WithTimeout(r.Context(), policy.Timeout)
+			defer cancel()
+			r = r.WithContext(ctx)
+		}
+		if policy.CacheTTL > 0 && (r.Method == http.MethodGet || r.Method == http.MethodHead) {
+			w = withCacheTTL(w, r, policy.CacheTTL)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// withCacheTTL sets the max-age of the successful responses which don't set their own Cache-Control.
+// The responses of the requests carrying a credential are marked private like cacheable does. check credentialed
+func withCacheTTL(w http.ResponseWriter, r *http.Request, ttl time.Duration) http.ResponseWriter {
+	anonymous := !credentialed(r)
+	written := false
+	setHeader := func(status int) {
+		if written {
+			return
+		}
+		written = true
+		if status != http.StatusOK || w.Header().Get("Cache-Control") != "" {
+			return
+		}
+		if !anonymous {
+			w.Header().Set("Cache-Control", "private")
+			return
+		}
+		w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(int(ttl.Seconds())))
+	}
+	return httpsnoop.Wrap(w, httpsnoop.Hooks{
+		WriteHeader: func(writeHeader httpsnoop.WriteHeaderFunc) httpsnoop.WriteHeaderFunc {
+			return func(code int) {
+				setHeader(code)
+				writeHeader(code)
+			}
+		},
+		Write: func(write httpsnoop.WriteFunc) httpsnoop.WriteFunc {
+			return func(b []byte) (int, error) {
+				setHeader(http.StatusOK)
+				return write(b)
+			}
+		},
+	})
+}
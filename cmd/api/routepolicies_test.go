@@ -0,0 +1,108 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadRoutePolicies(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		err     string
+	}{
+		{name: "Valid", content: "rate_tiers:\n  strict: 2\nroutes:\n  - route: GET /movies/:id\n    cache_ttl: 1m\n    rate_tier: strict\n    timeout: 5s\n    max_body_size: 1024\n"},
+		{name: "Empty", content: ""},
+		{name: "Unknown setting", content: "routes:\n  - route: GET /movies\n    cache_tll: 1m\n", err: "field cache_tll not found"},
+		{name: "Undeclared tier", content: "routes:\n  - route: GET /movies\n    rate_tier: strict\n", err: "rate tier strict of GET /movies isn't declared"},
+		{name: "Invalid route", content: "routes:\n  - route: /movies\n", err: "must be a method and a path"},
+		{name: "Invalid tier", content: "rate_tiers:\n  strict: 0\n", err: "rate tier strict must be greater than 0"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "policies.yaml")
+			require.NoError(t, os.WriteFile(path, []byte(tc.content), 0o600))
+			policies, err := loadRoutePolicies(path)
+			if tc.err != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.NotNil(t, policies)
+		})
+	}
+}
+
+func TestApplyRoutePolicies(t *testing.T) {
+	logger := zerolog.Nop()
+	app := &application{log: &logger}
+	app.runtime.Store(&runtimeConfig{RateLimitEnabled: true})
+	app.routePolicies = &routePolicies{
+		RateTiers: map[string]int64{"strict": 1},
+		Routes: []routePolicy{
+			{Route: "POST /tokens/authentication", RateTier: "strict"},
+			{Route: "GET /movies/:id", CacheTTL: time.Minute, Timeout: time.Second},
+			{Route: "* /movies/:id", CacheTTL: time.Hour},
+		},
+	}
+	var deadline bool
+	handler := app.applyRoutePolicies(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, deadline = r.Context().Deadline()
+		w.Write([]byte("{}"))
+	}))
+
+	tests := []struct {
+		name         string
+		method       string
+		path         string
+		header       string
+		session      string
+		wantStatus   int
+		wantCache    string
+		wantDeadline bool
+	}{
+		{name: "Cache ttl and timeout", method: http.MethodGet, path: "/v1/movies/7", wantStatus: http.StatusOK, wantCache: "public, max-age=60", wantDeadline: true},
+		{name: "Authenticated user", method: http.MethodGet, path: "/v1/movies/7", header: "Bearer token", wantStatus: http.StatusOK, wantCache: "private", wantDeadline: true},
+		{name: "Cookie session", method: http.MethodGet, path: "/v1/movies/7", session: "session", wantStatus: http.StatusOK, wantCache: "private", wantDeadline: true},
+		{name: "First matching policy", method: http.MethodHead, path: "/v1/movies/7", wantStatus: http.StatusOK, wantCache: "public, max-age=3600"},
+		{name: "No policy", method: http.MethodGet, path: "/v1/movies", wantStatus: http.StatusOK},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(tc.method, tc.path, nil)
+			if tc.header != "" {
+				r.Header.Set("Authorization", tc.header)
+			}
+			if tc.session != "" {
+				r.AddCookie(&http.Cookie{Name: sessionCookieName, Value: tc.session})
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			assert.Equal(t, tc.wantStatus, w.Code)
+			assert.Equal(t, tc.wantCache, w.Header().Get("Cache-Control"))
+			assert.Equal(t, tc.wantDeadline, deadline)
+		})
+	}
+
+	t.Run("Rate tier", func(t *testing.T) {
+		codes := make([]int, 0, 3)
+		for range 3 {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/tokens/authentication", nil))
+			codes = append(codes, w.Code)
+		}
+		// the burst of the tier is its limit plus 10%
+		assert.Equal(t, []int{http.StatusOK, http.StatusTooManyRequests, http.StatusTooManyRequests}, codes)
+	})
+}
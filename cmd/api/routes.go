@@ -2,45 +2,252 @@ package api
 
 import (
 	"net/http"
+	"strings"
 
-	"github.com/julienschmidt/httprouter"
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 func (app *application) routes() http.Handler {
-	router := httprouter.New()
+	router := chi.NewRouter()
 
-	router.NotFound = http.HandlerFunc(app.notFoundResponse)
-	router.MethodNotAllowed = http.HandlerFunc(app.methodNotAllowedResponse)
-	router.HandlerFunc(http.MethodGet, "/v1/healthcheck", app.otelHandler(app.JWTAuth(app.healthcheckHandler)))
+	router.NotFound(app.routeNotFound(router))
+	router.MethodNotAllowed(app.routeMethodNotAllowed(router))
+	// the api routes are registered under every version prefix. check versionedRouter
+	app.routeTable = nil
+	root := app.newRouteGroup(router)
+	public := root.use(app.public())
+	authenticated := root.use(app.authenticate())
+	activated := authenticated.use(app.activated())
+	admin := activated.use(app.permission("admin"))
+	// the routes authenticating the callers by their own credential, like basic auth, signatures or revoke tokens
+	external := root.use(app.external(nil))
+
+	public.handle(http.MethodGet, "/healthcheck", app.healthcheckHandler)
+	public.unversioned().handle(http.MethodGet, "/readyz", app.readinessHandler)
+	public.handle(http.MethodGet, "/version", app.versionHandler)
+	// the web frontend gets the csrf token of its cookie session here. check csrfProtect
+	public.handle(http.MethodGet, "/csrf", app.createCSRFTokenHandler)
 
 	// Movies Handlers
-	router.HandlerFunc(http.MethodPost, "/v1/movies", app.otelHandler(app.Auth(app.requireActivatedUser(app.requirePermission("movies:write", app.createMovieHandler)))))
-	router.HandlerFunc(http.MethodGet, "/v1/movies", app.otelHandler(app.Auth(app.requireActivatedUser(app.requirePermission("movies:read", app.listMovieHandler)))))
-	router.HandlerFunc(http.MethodGet, "/v1/movies/:id", app.otelHandler(app.Auth(app.requireActivatedUser(app.requirePermission("movies:read", app.showMovieHandler)))))
-	router.HandlerFunc(http.MethodPatch, "/v1/movies/:id", app.otelHandler(app.Auth(app.requireActivatedUser(app.requirePermission("movies:write", app.updateMovieHandler)))))
-	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id", app.otelHandler(app.Auth(app.requireActivatedUser(app.requirePermission("movies:write", app.deleteMovieHandler)))))
+	moviesRead := activated.group("/movies").use(app.permission("movies:read"))
+	moviesWrite := activated.group("/movies").use(app.permission("movies:write"))
+	// movies are listed and shown to the anonymous users as well when the public catalog is enabled. check publicCatalog
+	catalog := root.group("/movies").use(app.publicCatalogAccess(), app.authenticate(), app.activated(), app.permission("movies:read"))
+	moviesWrite.handle(http.MethodPost, "", app.createMovieHandler)
+	catalog.handle(http.MethodGet, "", app.listMovieHandler, app.cacheable(cacheCatalog))
+	catalog.handle(http.MethodGet, "/autocomplete", app.autocompleteMoviesHandler, app.cacheable(cacheCatalog))
+	// the public rss and atom feeds
+	public.handle(http.MethodGet, "/movies/"+feedRSS, app.movieFeedHandler, app.cacheable(cacheFeeds))
+	public.handle(http.MethodGet, "/movies/"+feedAtom, app.movieFeedHandler, app.cacheable(cacheFeeds))
+	moviesRead.handle(http.MethodGet, "/changes", app.listMovieChangesHandler)
+	catalog.handleWithID(http.MethodGet, "/:id", app.showMovieHandler, app.cacheable(cacheCatalog))
+	moviesWrite.handleWithID(http.MethodPatch, "/:id", app.updateMovieHandler)
+	moviesWrite.handleWithID(http.MethodDelete, "/:id", app.deleteMovieHandler)
+	moviesRead.handleWithID(http.MethodPost, "/:id/progress", app.updateProgressHandler)
+	moviesWrite.handle(http.MethodPut, "/by-external-id/:source/:id", app.upsertMovieByExternalIDHandler)
+
+	// movie asset Handlers
+	moviesWrite.handleWithID(http.MethodPost, "/:id/assets", app.createMovieAssetHandler)
+	moviesRead.handleWithID(http.MethodGet, "/:id/assets", app.listMovieAssetsHandler)
+	moviesRead.handleWithID(http.MethodGet, "/:id/assets/:asset_id", app.showMovieAssetHandler)
+	moviesWrite.handleWithID(http.MethodPatch, "/:id/assets/:asset_id", app.updateMovieAssetHandler)
+	moviesWrite.handleWithID(http.MethodDelete, "/:id/assets/:asset_id", app.deleteMovieAssetHandler)
+
+	// movie sharing Handlers
+	// share urls are signed by the share signing key so sharing is only enabled when it's configured
+	if ShareSigningKey != "" {
+		moviesWrite.handleWithID(http.MethodPost, "/:id/share", app.createMovieShareHandler)
+		moviesWrite.handleWithID(http.MethodGet, "/:id/share", app.listMovieSharesHandler)
+		moviesWrite.handleWithID(http.MethodDelete, "/:id/share/:share_id", app.revokeMovieShareHandler)
+		moviesWrite.handleWithID(http.MethodGet, "/:id/share/:share_id/accesses", app.listMovieShareAccessesHandler)
+		// the share links are authenticated by their signature
+		external.handleWithID(http.MethodGet, "/shared/movies/:id", app.showSharedMovieHandler)
+	}
+
+	// short link Handlers
+	// short links redirect to the public website so they're only enabled when its base url is configured
+	if PublicBaseURL != "" {
+		moviesWrite.handleWithID(http.MethodPost, "/:id/shortlink", app.createShortLinkHandler)
+		public.unversioned().handle(http.MethodGet, "/s/:code", app.followShortLinkHandler)
+	}
+
+	// sitemap Handlers
+	// the sitemap lists the movie pages of the public website so it's only served when its base url is configured
+	if PublicBaseURL != "" {
+		public.unversioned().handle(http.MethodGet, "/sitemap.xml", app.sitemapHandler, app.cacheable(cacheFeeds))
+	}
+
+	// job Handlers
+	// the long running operations return a job which the clients follow here instead of waiting for the response
+	activated.handle(http.MethodGet, "/jobs", app.listJobsHandler)
+	activated.handleWithID(http.MethodGet, "/jobs/:id", app.showJobHandler)
 
 	// User Handlers
-	router.HandlerFunc(http.MethodPost, "/v1/users", app.otelHandler(app.Auth(app.registerUserHandler)))
-	router.HandlerFunc(http.MethodGet, "/v1/users", app.otelHandler(app.Auth(app.ListUserHandler)))
-	router.HandlerFunc(http.MethodDelete, "/v1/users/:id", app.otelHandler(app.Auth(app.DeleteUserHandler)))
+	// the anonymous users may only register, listing and deleting the users is left to the admins
+	authenticated.handle(http.MethodPost, "/users", app.registerUserHandler, app.challenge())
+	admin.handle(http.MethodGet, "/users", app.ListUserHandler)
+	admin.handle(http.MethodPost, "/users/import", app.importUsersHandler)
+	admin.handleWithUUID(http.MethodDelete, "/users/:id", app.DeleteUserHandler)
+	// id is either "me" or the id of the user. check showUsageHandler
+	activated.handle(http.MethodGet, "/users/:id/usage", app.showUsageHandler)
+	// id is either "me" or the id of the user. check listHistoryHandler
+	activated.use(app.permission("movies:read")).handle(http.MethodGet, "/users/:id/history", app.listHistoryHandler)
+	// id is either "me" or the id of the user. the devices hold the tokens issued to the user
+	activated.handle(http.MethodGet, "/users/:id/devices", app.listDevicesHandler)
+	activated.handle(http.MethodDelete, "/users/:id/devices/:device_id", app.deleteDeviceHandler)
+	// id is either "me" or the id of the user. the saved searches are movie list filters. check createSavedSearchHandler
+	searches := activated.use(app.permission("movies:read"))
+	searches.route("/users/:id/searches").
+		get(app.listSavedSearchesHandler).
+		post(app.createSavedSearchHandler)
+	searches.handle(http.MethodDelete, "/users/:id/searches/:search_id", app.deleteSavedSearchHandler)
+	searches.handle(http.MethodGet, "/users/:id/searches/:search_id/results", app.savedSearchResultsHandler)
+
+	// invitation Handlers
+	if RegistrationMode == RegistrationInvite {
+		admin.handle(http.MethodPost, "/invitations", app.createInvitationHandler)
+	}
 
 	// token activation Handlers
-	router.HandlerFunc(http.MethodPut, "/v1/users/:id/activate", app.otelHandler(app.Auth(app.userActivationHandler)))
+	authenticated.handleWithUUID(http.MethodPut, "/users/:id/activate", app.userActivationHandler)
+	// the password reset is authenticated by the reset token since the password of the user has been invalidated
+	external.handleWithUUID(http.MethodPut, "/users/:id/password", app.resetPasswordHandler)
 
 	// authentication token Handlers
-	// createBearerTokenHandler has basic authentication within itself
-	router.HandlerFunc(http.MethodPost, "/v1/tokens/auth", app.otelHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		app.createBearerTokenHandler(w, r)
-	})))
+	// the token handlers have basic authentication within themselves
+	external.handle(http.MethodPost, "/tokens", app.createTokenHandler, app.challenge())
+	// legacy aliases of /tokens
+	external.handle(http.MethodPost, "/tokens/auth", app.createBearerTokenHandler, app.challenge())
+	external.handle(http.MethodPost, "/tokens/jwt", app.createJWTTokenHandler, app.challenge())
+
+	// session Handlers
+	// the web frontend signs in by a cookie session instead of the tokens when it's enabled
+	if CookieSessions {
+		external.route("/sessions").
+			post(app.createSessionHandler, app.challenge(), app.csrfLogin()).
+			delete(app.deleteSessionHandler)
+	}
 
-	router.HandlerFunc(http.MethodPost, "/v1/tokens/jwt", app.otelHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		app.createJWTTokenHandler(w, r)
-	})))
+	// login Handlers
+	// revoke link of the new sign-in email is authenticated by its revoke token
+	external.handleWithID(http.MethodPost, "/logins/:id/revoke", app.revokeLoginHandler)
+
+	// proof of work challenge Handlers
+	if ChallengeProvider == ChallengeProofOfWork {
+		public.handle(http.MethodPost, "/challenges", app.createChallengeHandler)
+	}
+
+	// admin Handlers
+	admin.route("/admin/config").
+		get(app.showRuntimeConfigHandler).
+		patch(app.updateRuntimeConfigHandler)
+	admin.handle(http.MethodGet, "/admin/emails", app.listEmailsHandler)
+	admin.handle(http.MethodGet, "/admin/mail/preview", app.previewMailHandler)
+	admin.handle(http.MethodGet, "/admin/logins", app.listLoginEventsHandler)
+	admin.handle(http.MethodGet, "/admin/audit-logs", app.listAuditLogsHandler)
+	// browsers can't set the Authorization header of a websocket, the token is taken from the query. check wsQueryToken
+	admin.handle(http.MethodGet, "/ws", app.liveEventsHandler, middleware{wrap: app.wsQueryToken})
+	admin.handleWithUUID(http.MethodPut, "/admin/users/:id/suspension", app.suspendUserHandler)
+	admin.handleWithUUID(http.MethodDelete, "/admin/users/:id/suspension", app.unsuspendUserHandler)
+	admin.handleWithUUID(http.MethodPost, "/admin/users/:id/force-password-reset", app.forcePasswordResetHandler)
+	admin.handle(http.MethodPost, "/admin/retention", app.runRetentionHandler)
+	admin.handle(http.MethodPost, "/admin/ratings/import", app.importRatingsHandler)
+	admin.handle(http.MethodPost, "/admin/movies/merge", app.mergeMoviesHandler)
+	admin.handle(http.MethodGet, "/admin/stats", app.showStatsHandler)
+	admin.handle(http.MethodGet, "/admin/schema", app.showSchemaHandler)
+	admin.handle(http.MethodGet, "/admin/routes", app.listRoutesHandler)
+	admin.handle(http.MethodGet, "/admin/version", app.showBuildInfoHandler)
+	// the purges are sent to the cdn so the endpoint is only enabled when its purge api is configured
+	if CDNPurgeURL != "" {
+		admin.handle(http.MethodPost, "/admin/cache/purge", app.purgeCacheHandler)
+	}
+	// teams grant their permissions to their members on top of their own ones. check GetAllPermsForUser
+	admin.route("/admin/teams").
+		get(app.listTeamsHandler).
+		post(app.createTeamHandler)
+	admin.handleWithID(http.MethodGet, "/admin/teams/:id", app.showTeamHandler)
+	admin.handleWithID(http.MethodDelete, "/admin/teams/:id", app.deleteTeamHandler)
+	admin.handleWithID(http.MethodPut, "/admin/teams/:id/permissions", app.updateTeamPermissionsHandler)
+	admin.handleWithID(http.MethodGet, "/admin/teams/:id/members", app.listTeamMembersHandler)
+	admin.handleWithID(http.MethodPost, "/admin/teams/:id/members", app.addTeamMembersHandler)
+	admin.handle(http.MethodDelete, "/admin/teams/:id/members/:user_id", app.removeTeamMemberHandler)
+	activated.use(app.permission(impersonationPermission)).handleWithUUID(http.MethodPost, "/admin/impersonate/:user_id", app.createImpersonationTokenHandler)
+
+	// webhook Handlers
+	// webhooks are authenticated by their signature so they are only enabled when the secret is configured
+	if EmailWebhookSecret != "" {
+		external.handle(http.MethodPost, "/webhooks/email-events", app.emailEventsWebhookHandler)
+	}
+
+	// scim provisioning Handlers
+	// identity providers authenticate by the scim bearer token so the endpoints are only enabled when it's configured
+	if SCIMBearerToken != "" {
+		scim := root.unversioned().group("/scim/v2").use(app.external(app.scimAuth))
+		scim.handle(http.MethodGet, "/ServiceProviderConfig", app.scimServiceProviderConfigHandler)
+		scim.handle(http.MethodGet, "/ResourceTypes", app.scimResourceTypesHandler)
+		scim.handle(http.MethodGet, "/Schemas", app.scimSchemasHandler)
+		scim.route("/Users").
+			get(app.scimListUsersHandler).
+			post(app.scimCreateUserHandler)
+		scim.route("/Users/:id").
+			get(app.scimShowUserHandler).
+			patch(app.scimPatchUserHandler).
+			delete(app.scimDeleteUserHandler)
+	}
 
 	// application metrics Handlers
-	router.Handler(http.MethodGet, "/metrics", promhttp.Handler())
+	if promMetricsEnabled() {
+		// OpenMetrics format is required to expose the trace exemplars of the histograms
+		metrics := promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{
+			EnableOpenMetrics: true,
+		})
+		root.unversioned().withoutTracing().use(app.metricsAccess()).handle(http.MethodGet, "/metrics", metrics.ServeHTTP)
+	}
+
+	return app.requestLogger(app.PanicRecovery(app.validateResponses(app.filterIPs(app.requireTLS(app.enableCORS(app.chaos(app.csrfProtect(app.maintenanceMode(app.RateLimit(app.applyRoutePolicies(app.limitConcurrency(app.requestCancellation(app.negotiateAPIVersion(app.limitRequestBody(app.guardBody(router))))))))))))))))
+}
+
+// routeNotFound redirects the paths of the routes with or without a trailing slash to the routes, the others get 404.
+// The GET requests are redirected by 301, the rest by 308 so the clients repeat the method and the body.
+func (app *application) routeNotFound(router *chi.Mux) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		path := r.URL.Path
+		if strings.HasSuffix(path, "/") {
+			path = strings.TrimSuffix(path, "/")
+		} else {
+			path += "/"
+		}
+		if path == "" || path == r.URL.Path || !router.Match(chi.NewRouteContext(), r.Method, path) {
+			app.notFoundResponse(w, r)
+			return
+		}
+		status := http.StatusPermanentRedirect
+		if r.Method == http.MethodGet {
+			status = http.StatusMovedPermanently
+		}
+		target := *r.URL
+		target.Path, target.RawPath = path, ""
+		http.Redirect(w, r, target.RequestURI(), status)
+	}
+}
 
-	return app.PanicRecovery(app.enableCORS(app.RateLimit(router)))
+// routeMethodNotAllowed lists the methods of the route in Allow. The preflight OPTIONS requests are answered by it,
+// the CORS headers are set by enableCORS. The other methods get 405.
+func (app *application) routeMethodNotAllowed(router *chi.Mux) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var allowed []string
+		for _, method := range []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete} {
+			if router.Match(chi.NewRouteContext(), method, r.URL.Path) {
+				allowed = append(allowed, method)
+			}
+		}
+		w.Header().Set("Allow", strings.Join(append(allowed, http.MethodOptions), ", "))
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		app.methodNotAllowedResponse(w, r)
+	}
 }
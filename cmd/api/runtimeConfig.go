@@ -0,0 +1,68 @@
+package api
+
+import (
+	"slices"
+
+	"github.com/cybrarymin/greenlight/internal/data"
+	"github.com/rs/zerolog"
+)
+
+// runtimeConfig holds the settings that are safe to change while the server is running.
+// The application keeps a pointer to it which is swapped atomically, so a loaded runtimeConfig must never be modified in place.
+type runtimeConfig struct {
+	LogLevel           int8                   `json:"log_level"`
+	RateLimitEnabled   bool                   `json:"rate_limit_enabled"`
+	GlobalRateLimit    int64                  `json:"global_rate_limit"`
+	PerClientRateLimit int64                  `json:"per_client_rate_limit"`
+	AnonymousRateLimit int64                  `json:"anonymous_rate_limit"`
+	MaintenanceMode    bool                   `json:"maintenance_mode"`
+	CORSTrustedOrigins []string               `json:"cors_trusted_origins"`
+	EmailDomains       data.EmailDomainPolicy `json:"email_domains"`
+}
+
+func newRuntimeConfig(cfg *config) *runtimeConfig {
+	return &runtimeConfig{
+		LogLevel:           LogLevel,
+		RateLimitEnabled:   cfg.rateLimit.enabled,
+		GlobalRateLimit:    cfg.rateLimit.globalRateLimit,
+		PerClientRateLimit: cfg.rateLimit.perClientRateLimit,
+		AnonymousRateLimit: AnonymousRateLimit,
+		MaintenanceMode:    MaintenanceMode,
+		CORSTrustedOrigins: slices.Clone(cfg.cors.trustedOrigins),
+		EmailDomains: data.EmailDomainPolicy{
+			Allowlist:       slices.Clone(EmailDomainAllowlist),
+			Denylist:        slices.Clone(EmailDomainDenylist),
+			BlockDisposable: BlockDisposableEmails,
+		},
+	}
+}
+
+// clone returns a deep copy of the runtime config which can be modified before being swapped in.
+func (rc *runtimeConfig) clone() *runtimeConfig {
+	nrc := *rc
+	nrc.CORSTrustedOrigins = slices.Clone(rc.CORSTrustedOrigins)
+	nrc.EmailDomains = rc.EmailDomains.Clone()
+	return &nrc
+}
+
+func (rc *runtimeConfig) validate(v *data.Validator) {
+	v.Apply("log_level", data.Between(int(rc.LogLevel), int(zerolog.TraceLevel), int(zerolog.PanicLevel)))
+	v.CheckCode(rc.GlobalRateLimit > 0, "global_rate_limit", data.CodeOutOfRange, "must be a positive integer")
+	v.CheckCode(rc.PerClientRateLimit > 0, "per_client_rate_limit", data.CodeOutOfRange, "must be a positive integer")
+	v.CheckCode(rc.AnonymousRateLimit > 0, "anonymous_rate_limit", data.CodeOutOfRange, "must be a positive integer")
+	v.Apply("cors_trusted_origins", data.MinItems(1, len(rc.CORSTrustedOrigins)), data.UniqueItems(rc.CORSTrustedOrigins))
+	domains := data.NewValidator()
+	data.ValidateEmailDomainPolicy(domains, &rc.EmailDomains)
+	v.Merge("email_domains", domains)
+}
+
+// runtimeCfg returns the currently active runtime config.
+func (app *application) runtimeCfg() *runtimeConfig {
+	return app.runtime.Load()
+}
+
+// swapRuntimeConfig atomically replaces the active runtime config and applies the settings that live outside of it.
+func (app *application) swapRuntimeConfig(rc *runtimeConfig) {
+	zerolog.SetGlobalLevel(zerolog.Level(rc.LogLevel))
+	app.runtime.Store(rc)
+}
@@ -0,0 +1,103 @@
+package api
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/cybrarymin/greenlight/internal/data"
+	"github.com/cybrarymin/greenlight/migrations"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// checkSchema compares the live database with the migrations embedded in the binary and records the result in the metrics.
+func (app *application) checkSchema(ctx context.Context) (*data.SchemaReport, error) {
+	report, err := app.models.Schema.Check(ctx, migrations.LatestVersion())
+	if err != nil {
+		return nil, err
+	}
+	recordSchemaReport(report)
+	return report, nil
+}
+
+// logSchemaDrift runs the schema check on startup. The server still starts on a drift
+// so the readiness probe, which reports the same drift, decides whether it receives traffic.
+func (app *application) logSchemaDrift(ctx context.Context) {
+	report, err := app.checkSchema(ctx)
+	if err != nil {
+		logEvents(app.log).Error(err, "failed to check the database schema", nil)
+		return
+	}
+	fields := logFields{
+		"event":            "schema_check",
+		"state":            report.State,
+		"expected_version": report.ExpectedVersion,
+		"version":          report.Version,
+		"missing_indexes":  report.MissingIndexes,
+	}
+	if report.Drifted() {
+		logEvents(app.log).Warn("database schema checked", fields)
+		return
+	}
+	logEvents(app.log).Info("database schema checked", fields)
+}
+
+// readinessHandler reports whether the instance can serve traffic. The database must be reachable
+// and its schema must not drift from the migrations the binary is built against.
+func (app *application) readinessHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := app.startSpan(r, "readiness")
+	defer span.End()
+
+	readiness := map[string]interface{}{
+		"status":  "ready",
+		"version": Version,
+	}
+	status := http.StatusOK
+	report, err := app.checkSchema(ctx)
+	switch {
+	case app.draining.Load():
+		readiness["status"] = "draining"
+		status = http.StatusServiceUnavailable
+	case err != nil:
+		span.RecordError(err)
+		span.SetStatus(codes.Error, otelDBErr)
+		logEvents(app.log).Error(err, "readiness check failed to reach the database", nil)
+		readiness["status"] = "not_ready"
+		readiness["database"] = "unavailable"
+		status = http.StatusServiceUnavailable
+	case report.Drifted():
+		span.SetAttributes(attribute.String("schema.state", report.State))
+		span.SetStatus(codes.Error, "database schema drift")
+		readiness["status"] = "not_ready"
+		readiness["database"] = "available"
+		readiness["schema"] = report
+		status = http.StatusServiceUnavailable
+	default:
+		readiness["database"] = "available"
+		readiness["schema"] = report
+	}
+
+	err = app.writeJson(w, r, status, envelope{"readiness": readiness}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showSchemaHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := app.startSpan(r, "showSchema")
+	defer span.End()
+
+	report, err := app.checkSchema(ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, otelDBErr)
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	span.SetAttributes(attribute.String("schema.state", report.State))
+
+	err = app.writeJson(w, r, http.StatusOK, envelope{"schema": report}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
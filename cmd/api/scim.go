@@ -0,0 +1,601 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cybrarymin/greenlight/internal/data"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// SCIMBearerToken is the static token the identity providers use to call the scim endpoints.
+var SCIMBearerToken string
+
+const (
+	scimSchemaUser                  = "urn:ietf:params:scim:schemas:core:2.0:User"
+	scimSchemaListResponse          = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	scimSchemaError                 = "urn:ietf:params:scim:api:messages:2.0:Error"
+	scimSchemaServiceProviderConfig = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	scimSchemaResourceType          = "urn:ietf:params:scim:schemas:core:2.0:ResourceType"
+	scimSchemaSchema                = "urn:ietf:params:scim:schemas:core:2.0:Schema"
+	scimMaxResults                  = 100
+)
+
+// only equality filters on the attributes identity providers use to look up an existing account are supported
+var scimFilterRX = regexp.MustCompile(`^\s*(userName|externalId|emails\.value|emails)\s+eq\s+"([^"]*)"\s*$`)
+
+type scimName struct {
+	Formatted  string `json:"formatted,omitempty"`
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+type scimEmail struct {
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+type scimMeta struct {
+	ResourceType string    `json:"resourceType"`
+	Created      time.Time `json:"created"`
+	Location     string    `json:"location"`
+	Version      string    `json:"version,omitempty"`
+}
+
+type scimUser struct {
+	Schemas     []string    `json:"schemas"`
+	ID          string      `json:"id,omitempty"`
+	ExternalID  string      `json:"externalId,omitempty"`
+	UserName    string      `json:"userName"`
+	Name        *scimName   `json:"name,omitempty"`
+	DisplayName string      `json:"displayName,omitempty"`
+	Emails      []scimEmail `json:"emails,omitempty"`
+	Active      *bool       `json:"active,omitempty"`
+	Meta        *scimMeta   `json:"meta,omitempty"`
+}
+
+type scimListResponse struct {
+	Schemas      []string    `json:"schemas"`
+	TotalResults int         `json:"totalResults"`
+	StartIndex   int         `json:"startIndex"`
+	ItemsPerPage int         `json:"itemsPerPage"`
+	Resources    interface{} `json:"Resources"`
+}
+
+type scimError struct {
+	Schemas  []string `json:"schemas"`
+	Status   string   `json:"status"`
+	ScimType string   `json:"scimType,omitempty"`
+	Detail   string   `json:"detail"`
+}
+
+func newSCIMUser(u *data.User) scimUser {
+	active := u.Activated
+	return scimUser{
+		Schemas:     []string{scimSchemaUser},
+		ID:          u.ID.String(),
+		ExternalID:  u.ExternalID,
+		UserName:    u.Email,
+		Name:        &scimName{Formatted: u.Name},
+		DisplayName: u.Name,
+		Emails:      []scimEmail{{Value: u.Email, Type: "work", Primary: true}},
+		Active:      &active,
+		Meta: &scimMeta{
+			ResourceType: "User",
+			Created:      u.CreatedAt,
+			Location:     "/scim/v2/Users/" + u.ID.String(),
+			Version:      fmt.Sprintf(`W/"%d"`, u.Version),
+		},
+	}
+}
+
+// displayName returns the name of the user from the most specific attribute the identity provider has sent.
+func (su *scimUser) displayName() string {
+	switch {
+	case su.DisplayName != "":
+		return su.DisplayName
+	case su.Name != nil && su.Name.Formatted != "":
+		return su.Name.Formatted
+	case su.Name != nil && (su.Name.GivenName != "" || su.Name.FamilyName != ""):
+		return strings.TrimSpace(su.Name.GivenName + " " + su.Name.FamilyName)
+	default:
+		return su.UserName
+	}
+}
+
+// scimAuth authenticates the identity provider by the static scim bearer token.
+func (app *application) scimAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		headerValues := strings.Split(r.Header.Get("Authorization"), " ")
+		if len(headerValues) != 2 || headerValues[0] != "Bearer" || !secureCompare(headerValues[1], SCIMBearerToken) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			app.scimErrorResponse(w, r, http.StatusUnauthorized, "", "authentication required")
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+}
+
+// writeSCIM is the scim counterpart of writeJson. scim resources are not wrapped in an envelope and use their own media type.
+func (app *application) writeSCIM(w http.ResponseWriter, status int, resource interface{}, headers http.Header) error {
+	return writeJSONBody(w, status, "application/scim+json", headers, resource)
+}
+
+// readSCIM decodes the scim request body. Unlike readJson unknown fields are ignored since identity providers send
+// many attributes and extension schemas that greenlight doesn't store.
+func (app *application) readSCIM(w http.ResponseWriter, r *http.Request, dst interface{}) error {
+	err := json.NewDecoder(r.Body).Decode(dst)
+	if err != nil {
+		return fmt.Errorf("body contains invalid scim json: %w", err)
+	}
+	return nil
+}
+
+func (app *application) scimErrorResponse(w http.ResponseWriter, r *http.Request, status int, scimType string, detail string) {
+	err := app.writeSCIM(w, status, scimError{
+		Schemas:  []string{scimSchemaError},
+		Status:   strconv.Itoa(status),
+		ScimType: scimType,
+		Detail:   detail,
+	}, nil)
+	if err != nil {
+		app.logError(r, err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func (app *application) scimServerErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	app.logError(r, err)
+	app.scimErrorResponse(w, r, http.StatusInternalServerError, "", "the server encountered an error to process the request")
+}
+
+func (app *application) scimNotFoundResponse(w http.ResponseWriter, r *http.Request) {
+	app.scimErrorResponse(w, r, http.StatusNotFound, "", "the requested resource couldn't be found")
+}
+
+func (app *application) scimFailedValidationResponse(w http.ResponseWriter, r *http.Request, errors map[string]string) {
+	app.scimErrorResponse(w, r, http.StatusBadRequest, "invalidValue", strings.TrimSpace(createKeyValuePairs(errors)))
+}
+
+func (app *application) scimListUsersHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := app.startSpan(r, "scimListUsers")
+	defer span.End()
+
+	nValidator := data.NewValidator()
+	qs := r.URL.Query()
+	startIndex := app.readInt(qs, "startIndex", 1, nValidator)
+	count := app.readInt(qs, "count", scimMaxResults, nValidator)
+	filter := app.readString(qs, "filter", "")
+	if !nValidator.Valid() {
+		span.RecordError(errors.New(createKeyValuePairs(nValidator.Errors)))
+		span.SetStatus(codes.Error, otelunprocessableErr)
+		app.scimFailedValidationResponse(w, r, nValidator.Errors)
+		return
+	}
+	// scim clamps out of range paging values instead of rejecting them
+	startIndex = max(startIndex, 1)
+	count = min(max(count, 0), scimMaxResults)
+
+	var email, externalID string
+	if filter != "" {
+		matches := scimFilterRX.FindStringSubmatch(filter)
+		if matches == nil {
+			span.SetStatus(codes.Error, otelunprocessableErr)
+			app.scimErrorResponse(w, r, http.StatusBadRequest, "invalidFilter", "only equality filters on userName, externalId and emails are supported")
+			return
+		}
+		// nobody has an empty userName or externalId and the model ignores the empty values
+		if matches[2] == "" {
+			app.writeSCIMList(w, r, []scimUser{}, 0, startIndex)
+			return
+		}
+		if matches[1] == "externalId" {
+			externalID = matches[2]
+		} else {
+			email = matches[2]
+		}
+	}
+
+	users := &data.Users{}
+	total, err := app.models.Users.Find(ctx, users, email, externalID, startIndex-1, count)
+	if err != nil {
+		span.RecordError(err)
+		switch {
+		case errors.Is(err, data.ErrorRecordNotFound):
+			span.SetStatus(codes.Ok, otelDBNotFoundInfo)
+		default:
+			span.SetStatus(codes.Error, otelDBErr)
+			app.scimServerErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	resources := make([]scimUser, 0, len(*users))
+	for i := range *users {
+		resources = append(resources, newSCIMUser(&(*users)[i]))
+	}
+	app.writeSCIMList(w, r, resources, total, startIndex)
+}
+
+func (app *application) writeSCIMList(w http.ResponseWriter, r *http.Request, resources []scimUser, total int, startIndex int) {
+	err := app.writeSCIM(w, http.StatusOK, scimListResponse{
+		Schemas:      []string{scimSchemaListResponse},
+		TotalResults: total,
+		StartIndex:   startIndex,
+		ItemsPerPage: len(resources),
+		Resources:    resources,
+	}, nil)
+	if err != nil {
+		app.scimServerErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) scimShowUserHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := app.startSpan(r, "scimShowUser")
+	defer span.End()
+
+	user, ok := app.scimFetchUser(w, r.WithContext(ctx))
+	if !ok {
+		return
+	}
+	err := app.writeSCIM(w, http.StatusOK, newSCIMUser(user), nil)
+	if err != nil {
+		app.scimServerErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) scimCreateUserHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := app.startSpan(r, "scimCreateUser")
+	defer span.End()
+
+	var input scimUser
+	err := app.readSCIM(w, r, &input)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, otelunprocessableErr)
+		app.scimErrorResponse(w, r, http.StatusBadRequest, "invalidSyntax", err.Error())
+		return
+	}
+
+	nUser := data.User{
+		Name:       input.displayName(),
+		Email:      input.UserName,
+		ExternalID: input.ExternalID,
+		// accounts provisioned by the identity provider are already verified so they don't need the email activation
+		Activated: input.Active == nil || *input.Active,
+	}
+	// provisioned users sign in through the identity provider, so they get a random password nobody knows
+	err = nUser.Password.Set(randomPassword())
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "error on new password setup")
+		app.scimServerErrorResponse(w, r, err)
+		return
+	}
+
+	nValidator := data.NewValidator()
+	data.ValidateUser(nValidator, &nUser)
+	if !nValidator.Valid() {
+		span.RecordError(errors.New(createKeyValuePairs(nValidator.Errors)))
+		span.SetStatus(codes.Error, otelunprocessableErr)
+		app.scimFailedValidationResponse(w, r, nValidator.Errors)
+		return
+	}
+
+	err = app.models.Users.Insert(ctx, &nUser)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, otelDBErr)
+		switch {
+		case errors.Is(err, data.ErrorDuplicateEmail), errors.Is(err, data.ErrUniqueViolation):
+			app.scimErrorResponse(w, r, http.StatusConflict, "uniqueness", "user with the same userName or externalId already exists")
+		default:
+			app.scimServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.Permissions.AddPermForUser(ctx, nUser.ID, "movies:read")
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, otelDBErr)
+		app.scimServerErrorResponse(w, r, err)
+		return
+	}
+
+	resource := newSCIMUser(&nUser)
+	headers := make(http.Header)
+	headers.Set("Location", resource.Meta.Location)
+	err = app.writeSCIM(w, http.StatusCreated, resource, headers)
+	if err != nil {
+		app.scimServerErrorResponse(w, r, err)
+	}
+}
+
+// scimPatchUserHandler applies the PatchOp operations of the identity provider. Deprovisioning is done by replacing active with false.
+func (app *application) scimPatchUserHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := app.startSpan(r, "scimPatchUser")
+	defer span.End()
+	r = r.WithContext(ctx)
+
+	user, ok := app.scimFetchUser(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Schemas    []string `json:"schemas"`
+		Operations []struct {
+			Op    string          `json:"op"`
+			Path  string          `json:"path"`
+			Value json.RawMessage `json:"value"`
+		} `json:"Operations"`
+	}
+	err := app.readSCIM(w, r, &input)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, otelunprocessableErr)
+		app.scimErrorResponse(w, r, http.StatusBadRequest, "invalidSyntax", err.Error())
+		return
+	}
+
+	for _, operation := range input.Operations {
+		op := strings.ToLower(operation.Op)
+		if op != "replace" && op != "add" {
+			span.SetStatus(codes.Error, otelunprocessableErr)
+			app.scimErrorResponse(w, r, http.StatusBadRequest, "invalidValue", fmt.Sprintf("unsupported patch operation %q", operation.Op))
+			return
+		}
+		// operations without a path carry an object of the attributes to replace
+		values := map[string]json.RawMessage{operation.Path: operation.Value}
+		if operation.Path == "" {
+			values = nil
+			err = json.Unmarshal(operation.Value, &values)
+			if err != nil {
+				span.SetStatus(codes.Error, otelunprocessableErr)
+				app.scimErrorResponse(w, r, http.StatusBadRequest, "invalidValue", "patch operation without path must have an object value")
+				return
+			}
+		}
+		for path, value := range values {
+			err = applySCIMPatch(user, path, value)
+			if err != nil {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, otelunprocessableErr)
+				app.scimErrorResponse(w, r, http.StatusBadRequest, "invalidValue", err.Error())
+				return
+			}
+		}
+	}
+
+	nValidator := data.NewValidator()
+	data.ValidateUser(nValidator, user)
+	if !nValidator.Valid() {
+		span.RecordError(errors.New(createKeyValuePairs(nValidator.Errors)))
+		span.SetStatus(codes.Error, otelunprocessableErr)
+		app.scimFailedValidationResponse(w, r, nValidator.Errors)
+		return
+	}
+
+	err = app.models.Users.Update(user.ID, ctx, user)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, otelDBErr)
+		switch {
+		case errors.Is(err, data.ErrorRecordNotFound):
+			app.scimNotFoundResponse(w, r)
+		case errors.Is(err, data.ErrEditConflict), errors.Is(err, data.ErrSerializationFailure):
+			app.scimErrorResponse(w, r, http.StatusConflict, "", "unable to update the user due to an edit conflict, please try again")
+		case errors.Is(err, data.ErrorDuplicateEmail), errors.Is(err, data.ErrUniqueViolation):
+			app.scimErrorResponse(w, r, http.StatusConflict, "uniqueness", "user with the same userName or externalId already exists")
+		default:
+			app.scimServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeSCIM(w, http.StatusOK, newSCIMUser(user), nil)
+	if err != nil {
+		app.scimServerErrorResponse(w, r, err)
+	}
+}
+
+func applySCIMPatch(user *data.User, path string, value json.RawMessage) error {
+	var str string
+	switch strings.ToLower(path) {
+	case "active":
+		active, err := scimBool(value)
+		if err != nil {
+			return err
+		}
+		user.Activated = active
+	case "emails":
+		var emails []scimEmail
+		if err := json.Unmarshal(value, &emails); err != nil || len(emails) == 0 {
+			return errors.New("emails must be a non empty list")
+		}
+		user.Email = emails[0].Value
+	case "username", `emails[type eq "work"].value`:
+		if err := json.Unmarshal(value, &str); err != nil {
+			return fmt.Errorf("%s must be a string", path)
+		}
+		user.Email = str
+	case "displayname", "name.formatted":
+		if err := json.Unmarshal(value, &str); err != nil {
+			return fmt.Errorf("%s must be a string", path)
+		}
+		user.Name = str
+	case "name":
+		var name scimName
+		if err := json.Unmarshal(value, &name); err != nil {
+			return errors.New("name must be an object")
+		}
+		su := scimUser{Name: &name, UserName: user.Email}
+		user.Name = su.displayName()
+	case "externalid":
+		if err := json.Unmarshal(value, &str); err != nil {
+			return fmt.Errorf("%s must be a string", path)
+		}
+		user.ExternalID = str
+	default:
+		// attributes greenlight doesn't store are ignored so the identity provider doesn't fail the whole provisioning
+	}
+	return nil
+}
+
+// scimBool parses a boolean which some identity providers, like Azure AD, send as a string.
+func scimBool(value json.RawMessage) (bool, error) {
+	var b bool
+	if err := json.Unmarshal(value, &b); err == nil {
+		return b, nil
+	}
+	var s string
+	if err := json.Unmarshal(value, &s); err == nil {
+		if b, err := strconv.ParseBool(s); err == nil {
+			return b, nil
+		}
+	}
+	return false, errors.New("active must be a boolean")
+}
+
+// scimDeleteUserHandler removes the account of a user who has been unassigned from the application in the identity provider.
+func (app *application) scimDeleteUserHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := app.startSpan(r, "scimDeleteUser")
+	defer span.End()
+
+	id, err := app.readUUIDParam(r)
+	if err != nil {
+		span.RecordError(err)
+		app.scimNotFoundResponse(w, r)
+		return
+	}
+	err = app.models.Users.Delete(ctx, id)
+	if err != nil {
+		span.RecordError(err)
+		switch {
+		case errors.Is(err, data.ErrorRecordNotFound):
+			span.SetStatus(codes.Ok, otelDBNotFoundInfo)
+			app.scimNotFoundResponse(w, r)
+		default:
+			span.SetStatus(codes.Error, otelDBErr)
+			app.scimServerErrorResponse(w, r, err)
+		}
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// scimFetchUser loads the user of the id path parameter and writes the scim error response if it fails.
+func (app *application) scimFetchUser(w http.ResponseWriter, r *http.Request) (*data.User, bool) {
+	id, err := app.readUUIDParam(r)
+	if err != nil {
+		app.scimNotFoundResponse(w, r)
+		return nil, false
+	}
+	user := &data.User{}
+	err = app.models.Users.GetByID(id, r.Context(), user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrorRecordNotFound):
+			app.scimNotFoundResponse(w, r)
+		default:
+			app.scimServerErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+	return user, true
+}
+
+func randomPassword() string {
+	b := make([]byte, 32)
+	// crypto/rand never fails on the supported platforms
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func (app *application) scimServiceProviderConfigHandler(w http.ResponseWriter, r *http.Request) {
+	supported := func(s bool) map[string]bool { return map[string]bool{"supported": s} }
+	err := app.writeSCIM(w, http.StatusOK, map[string]interface{}{
+		"schemas":        []string{scimSchemaServiceProviderConfig},
+		"patch":          supported(true),
+		"bulk":           map[string]interface{}{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+		"filter":         map[string]interface{}{"supported": true, "maxResults": scimMaxResults},
+		"changePassword": supported(false),
+		"sort":           supported(false),
+		"etag":           supported(false),
+		"authenticationSchemes": []map[string]interface{}{{
+			"type":        "oauthbearertoken",
+			"name":        "OAuth Bearer Token",
+			"description": "Authentication using the scim bearer token of greenlight",
+			"primary":     true,
+		}},
+		"meta": map[string]string{"resourceType": "ServiceProviderConfig", "location": "/scim/v2/ServiceProviderConfig"},
+	}, nil)
+	if err != nil {
+		app.scimServerErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) scimResourceTypesHandler(w http.ResponseWriter, r *http.Request) {
+	resourceTypes := []map[string]interface{}{{
+		"schemas":     []string{scimSchemaResourceType},
+		"id":          "User",
+		"name":        "User",
+		"endpoint":    "/Users",
+		"description": "User Account",
+		"schema":      scimSchemaUser,
+		"meta":        map[string]string{"resourceType": "ResourceType", "location": "/scim/v2/ResourceTypes/User"},
+	}}
+	app.writeSCIMDiscovery(w, r, resourceTypes)
+}
+
+func (app *application) scimSchemasHandler(w http.ResponseWriter, r *http.Request) {
+	attribute := func(name, typ string, required bool, uniqueness string) map[string]interface{} {
+		return map[string]interface{}{
+			"name":        name,
+			"type":        typ,
+			"multiValued": false,
+			"required":    required,
+			"caseExact":   false,
+			"mutability":  "readWrite",
+			"returned":    "default",
+			"uniqueness":  uniqueness,
+		}
+	}
+	schemas := []map[string]interface{}{{
+		"schemas":     []string{scimSchemaSchema},
+		"id":          scimSchemaUser,
+		"name":        "User",
+		"description": "User Account",
+		"attributes": []map[string]interface{}{
+			attribute("userName", "string", true, "server"),
+			attribute("externalId", "string", false, "server"),
+			attribute("displayName", "string", false, "none"),
+			attribute("active", "boolean", false, "none"),
+		},
+		"meta": map[string]string{"resourceType": "Schema", "location": "/scim/v2/Schemas/" + scimSchemaUser},
+	}}
+	app.writeSCIMDiscovery(w, r, schemas)
+}
+
+func (app *application) writeSCIMDiscovery(w http.ResponseWriter, r *http.Request, resources []map[string]interface{}) {
+	err := app.writeSCIM(w, http.StatusOK, scimListResponse{
+		Schemas:      []string{scimSchemaListResponse},
+		TotalResults: len(resources),
+		StartIndex:   1,
+		ItemsPerPage: len(resources),
+		Resources:    resources,
+	}, nil)
+	if err != nil {
+		app.scimServerErrorResponse(w, r, err)
+	}
+}
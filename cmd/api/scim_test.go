@@ -0,0 +1,125 @@
+package api
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSCIMAuth(t *testing.T) {
+	defer func(token, exportMode string) { SCIMBearerToken, MetricsExportMode = token, exportMode }(SCIMBearerToken, MetricsExportMode)
+	SCIMBearerToken, MetricsExportMode = "scim-token", MetricsExportPrometheus
+	logger := zerolog.Nop()
+	app := &application{log: &logger}
+	app.runtime.Store(&runtimeConfig{})
+	handler := app.routes()
+
+	tests := []struct {
+		name          string
+		authorization string
+		status        int
+	}{
+		{name: "Scim token", authorization: "Bearer scim-token", status: http.StatusOK},
+		{name: "Missing token", status: http.StatusUnauthorized},
+		{name: "Wrong token", authorization: "Bearer other-token", status: http.StatusUnauthorized},
+		{name: "Token prefix", authorization: "Bearer scim", status: http.StatusUnauthorized},
+		{name: "Basic scheme", authorization: "Basic scim-token", status: http.StatusUnauthorized},
+		{name: "Extra fields", authorization: "Bearer scim-token extra", status: http.StatusUnauthorized},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/scim/v2/ServiceProviderConfig", nil)
+			if tc.authorization != "" {
+				r.Header.Set("Authorization", tc.authorization)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, r)
+			assert.Equal(t, tc.status, rec.Code, rec.Body.String())
+			if tc.status == http.StatusUnauthorized {
+				assert.Equal(t, "Bearer", rec.Header().Get("WWW-Authenticate"))
+				assert.Equal(t, "application/scim+json", rec.Header().Get("Content-Type"))
+			}
+		})
+	}
+}
+
+func TestSCIMListUsersFilter(t *testing.T) {
+	userColumns := []string{"id", "name", "email", "external_id", "created_at", "activated", "version"}
+	userRow := []driver.Value{uuid.New().String(), "john", "john@example.com", "okta-1", time.Now(), true, int64(1)}
+	count := []string{"count"}
+
+	tests := []struct {
+		name      string
+		filter    string
+		queries   []stubQuery
+		status    int
+		scimType  string
+		userNames []string
+	}{
+		{name: "No filter", queries: []stubQuery{
+			stub(`^SELECT "user"\..* WHERE \(\(email = '' OR '' = ''\)\) AND \(\(external_id = '' OR '' = ''\)\)`, userColumns, userRow),
+			stub(`^SELECT count\(\*\) FROM "users"`, count, []driver.Value{int64(1)}),
+		}, status: http.StatusOK, userNames: []string{"john@example.com"}},
+		{name: "userName", filter: `userName eq "john@example.com"`, queries: []stubQuery{
+			stub(`^SELECT "user"\..* WHERE \(\(email = 'john@example.com' OR .*\(\(external_id = '' OR`, userColumns, userRow),
+			stub(`^SELECT count\(\*\) FROM "users"`, count, []driver.Value{int64(1)}),
+		}, status: http.StatusOK, userNames: []string{"john@example.com"}},
+		{name: "emails.value", filter: `emails.value eq "john@example.com"`, queries: []stubQuery{
+			stub(`^SELECT "user"\..* WHERE \(\(email = 'john@example.com' OR .*\(\(external_id = '' OR`, userColumns, userRow),
+			stub(`^SELECT count\(\*\) FROM "users"`, count, []driver.Value{int64(1)}),
+		}, status: http.StatusOK, userNames: []string{"john@example.com"}},
+		{name: "externalId", filter: ` externalId eq "okta-1" `, queries: []stubQuery{
+			stub(`^SELECT "user"\..* WHERE \(\(email = '' OR .*\(\(external_id = 'okta-1' OR`, userColumns, userRow),
+			stub(`^SELECT count\(\*\) FROM "users"`, count, []driver.Value{int64(1)}),
+		}, status: http.StatusOK, userNames: []string{"john@example.com"}},
+		{name: "No match", filter: `externalId eq "okta-2"`, queries: []stubQuery{
+			stub(`^SELECT "user"\..* WHERE \(\(email = '' OR .*\(\(external_id = 'okta-2' OR`, userColumns),
+			stub(`^SELECT count\(\*\) FROM "users"`, count, []driver.Value{int64(0)}),
+		}, status: http.StatusOK, userNames: []string{}},
+		// an empty value matches nobody without asking the database, which would ignore it
+		{name: "Empty value", filter: `userName eq ""`, status: http.StatusOK, userNames: []string{}},
+		{name: "Unsupported operator", filter: `userName co "john"`, status: http.StatusBadRequest, scimType: "invalidFilter"},
+		{name: "Unsupported attribute", filter: `name.givenName eq "john"`, status: http.StatusBadRequest, scimType: "invalidFilter"},
+		{name: "Compound filter", filter: `userName eq "john" or externalId eq "okta-1"`, status: http.StatusBadRequest, scimType: "invalidFilter"},
+		{name: "Unquoted value", filter: `userName eq john`, status: http.StatusBadRequest, scimType: "invalidFilter"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			logger := zerolog.Nop()
+			app := &application{log: &logger, models: stubModels(t, tc.queries...)}
+			r := httptest.NewRequest(http.MethodGet, "/scim/v2/Users?"+url.Values{"filter": {tc.filter}}.Encode(), nil)
+			rec := httptest.NewRecorder()
+			app.scimListUsersHandler(rec, r)
+			require.Equal(t, tc.status, rec.Code, rec.Body.String())
+
+			if tc.scimType != "" {
+				var response scimError
+				require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+				assert.Equal(t, tc.scimType, response.ScimType)
+				return
+			}
+			var response struct {
+				TotalResults int        `json:"totalResults"`
+				Resources    []scimUser `json:"Resources"`
+			}
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+			assert.Equal(t, len(tc.userNames), response.TotalResults)
+			userNames := []string{}
+			for _, user := range response.Resources {
+				userNames = append(userNames, user.UserName)
+			}
+			assert.Equal(t, tc.userNames, userNames)
+		})
+	}
+}
@@ -0,0 +1,290 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/cybrarymin/greenlight/internal/data"
+	mailer "github.com/cybrarymin/greenlight/internal/mailter"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// SearchAlertInterval is how often the saved searches with alerts are checked for the new movies matching them. 0 disables the alerts.
+var SearchAlertInterval time.Duration
+
+// searchAlertMaxMovies is the number of the new movies an alert lists, the rest are only counted
+const searchAlertMaxMovies = 20
+
+// savedSearchParams are the movie list parameters a saved search keeps. updated_since is left out
+// since the search would stop matching the movies updated later.
+var savedSearchParams = []string{"title", "genres", "year_from", "year_to", "runtime_max", "imdb_rating_min", "rotten_tomatoes_score_min"}
+
+// savedSearchAlertMailData is the data of saved_search_alert.tpl mail template
+type savedSearchAlertMailData struct {
+	Name     string
+	Search   string
+	SearchID int64
+	Movies   []data.Movie
+	More     int
+}
+
+// createSavedSearchHandler saves a movie list filter under a name. query is the query string of the movie list,
+// e.g. "genres=action&year_from=2000". The user is emailed the new movies matching it when alert is set.
+func (app *application) createSavedSearchHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := app.startSpan(r, "createSavedSearch")
+	defer span.End()
+
+	userID, ok := app.userIDParam(ctx, w, r, span)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Name  string `json:"name"`
+		Query string `json:"query"`
+		Alert bool   `json:"alert"`
+	}
+	err := app.readJson(w, r, &input)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, otelunprocessableErr)
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	nValidator := data.NewValidator()
+	data.ValidateSavedSearchName(nValidator, input.Name)
+	qs, err := url.ParseQuery(input.Query)
+	nValidator.Check(err == nil, "query", "must be the query string of the movie list")
+	for param := range qs {
+		nValidator.Check(slices.Contains(savedSearchParams, param), "query", fmt.Sprintf("%s isn't a filter of the movie list", param))
+	}
+	search := &data.SavedSearch{
+		UserID: userID,
+		Name:   input.Name,
+		Filter: app.readMovieListFilter(qs, nValidator),
+		Alert:  input.Alert,
+	}
+	if !nValidator.Valid() {
+		span.RecordError(errors.New(createKeyValuePairs(nValidator.Errors)))
+		span.SetStatus(codes.Error, otelunprocessableErr)
+		app.failedValidationResponse(w, r, nValidator)
+		return
+	}
+
+	err = app.models.Searches.Insert(ctx, search)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, otelDBErr)
+		app.dbWriteErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/users/%s/searches/%d", app.pathParams(r).ByName("id"), search.ID))
+	err = app.writeJson(w, r, http.StatusCreated, envelope{"Search": search}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listSavedSearchesHandler lists the saved searches of the user
+func (app *application) listSavedSearchesHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := app.startSpan(r, "listSavedSearches")
+	defer span.End()
+
+	userID, ok := app.userIDParam(ctx, w, r, span)
+	if !ok {
+		return
+	}
+
+	searches, err := app.models.Searches.ListForUser(ctx, userID)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, otelDBErr)
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJson(w, r, http.StatusOK, envelope{"Searches": searches}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteSavedSearchHandler removes the saved search along with its alerts
+func (app *application) deleteSavedSearchHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := app.startSpan(r, "deleteSavedSearch")
+	defer span.End()
+
+	userID, ok := app.userIDParam(ctx, w, r, span)
+	if !ok {
+		return
+	}
+	id, err := app.pathParams(r).Int64("search_id")
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, otelunprocessableErr)
+		app.invalidParamResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Searches.Delete(ctx, userID, id)
+	if err != nil {
+		span.RecordError(err)
+		switch {
+		case errors.Is(err, data.ErrorRecordNotFound):
+			span.SetStatus(codes.Ok, otelDBNotFoundInfo)
+			app.notFoundResponse(w, r)
+		default:
+			span.SetStatus(codes.Error, otelDBErr)
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJson(w, r, http.StatusOK, envelope{"message": "search deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// savedSearchResultsHandler runs the saved search. It's paged and sorted like the movie list.
+func (app *application) savedSearchResultsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := app.startSpan(r, "savedSearchResults")
+	defer span.End()
+
+	userID, ok := app.userIDParam(ctx, w, r, span)
+	if !ok {
+		return
+	}
+	id, err := app.pathParams(r).Int64("search_id")
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, otelunprocessableErr)
+		app.invalidParamResponse(w, r, err)
+		return
+	}
+	nValidator := data.NewValidator()
+	filters := app.readMovieListPage(r.URL.Query(), nValidator)
+	if !nValidator.Valid() {
+		span.RecordError(errors.New(createKeyValuePairs(nValidator.Errors)))
+		span.SetStatus(codes.Error, otelunprocessableErr)
+		app.failedValidationResponse(w, r, nValidator)
+		return
+	}
+
+	search, err := app.models.Searches.Get(ctx, userID, id)
+	if err != nil {
+		span.RecordError(err)
+		switch {
+		case errors.Is(err, data.ErrorRecordNotFound):
+			span.SetStatus(codes.Ok, otelDBNotFoundInfo)
+			app.notFoundResponse(w, r)
+		default:
+			span.SetStatus(codes.Error, otelDBErr)
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	movies, count, err := app.models.Movies.List(ctx, search.Filter, &filters)
+	if err != nil {
+		span.RecordError(err)
+		switch {
+		case errors.Is(err, data.ErrorRecordNotFound):
+			span.SetStatus(codes.Ok, otelDBNotFoundInfo)
+		default:
+			span.SetStatus(codes.Error, otelDBErr)
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	pMeta := filters.PaginationMetaData(ctx, count)
+	err = app.writeJson(w, r, http.StatusOK, envelope{"Metadata": pMeta, "Movies": movies}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// startSearchAlerts starts the job emailing the users the movies added since the last check of their saved searches
+// with alerts. A failing search is reported as an item error without stopping the others. No job is started when
+// there's no search to check.
+func (app *application) startSearchAlerts(ctx context.Context) (*data.Job, error) {
+	// the searches are checked up to the last committed change, the movies committed later are left to the next run
+	until, err := app.models.Movies.LastChangeSeq(ctx)
+	if err != nil {
+		return nil, err
+	}
+	searches, err := app.models.Searches.ListAlerting(ctx, until)
+	if err != nil || len(searches) == 0 {
+		return nil, err
+	}
+	return app.startJob(ctx, data.JobSearchAlerts, uuid.Nil, len(searches), func(ctx context.Context, progress *jobProgress) (interface{}, error) {
+		alerted := 0
+		for i := range searches {
+			sent, err := app.alertSavedSearch(ctx, &searches[i], until)
+			if err != nil {
+				app.events(ctx).Error(err, "failed to alert the saved search", logFields{"search_id": searches[i].ID})
+				progress.report(1, 1, []data.JobItemError{{Item: i, Key: searches[i].Name, Errors: map[string]string{"error": err.Error()}}})
+				continue
+			}
+			if sent {
+				alerted++
+			}
+			progress.report(1, 0, nil)
+		}
+		return map[string]interface{}{"searches": len(searches), "alerted": alerted}, nil
+	})
+}
+
+// alertSavedSearch emails the user of the search the movies matching it which have been added since its last check,
+// up to the until change sequence number. It reports whether an email has been sent.
+func (app *application) alertSavedSearch(ctx context.Context, search *data.SavedSearch, until int64) (bool, error) {
+	movies, count, err := app.models.Movies.CreatedBetween(ctx, search.Filter, search.AlertSeq, until, searchAlertMaxMovies)
+	if err != nil {
+		return false, err
+	}
+	// the search is checked only once even when the replicas run the job at the same time. The movies of an email
+	// failing to be sent aren't sent again by the next run.
+	claimed, err := app.models.Searches.AdvanceAlert(ctx, search.ID, search.AlertSeq, until)
+	if err != nil || !claimed || count == 0 {
+		return false, err
+	}
+	err = app.sendMailWithRetries(ctx, mailer.MailMessage{
+		To:           []string{search.User.Email},
+		TemplateFile: "saved_search_alert.tpl",
+		Data: savedSearchAlertMailData{
+			Name:     search.User.Name,
+			Search:   search.Name,
+			SearchID: search.ID,
+			Movies:   movies,
+			More:     count - len(movies),
+		},
+	})
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// runSearchAlertScheduler starts the saved search alerts every SearchAlertInterval for the lifetime of the server
+func (app *application) runSearchAlertScheduler() {
+	ticker := time.NewTicker(SearchAlertInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if app.draining.Load() {
+			return
+		}
+		_, err := app.startSearchAlerts(context.Background())
+		if err != nil {
+			logEvents(app.log).Error(err, "failed to start the saved search alerts job", nil)
+		}
+	}
+}
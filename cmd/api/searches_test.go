@@ -0,0 +1,114 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cybrarymin/greenlight/internal/data"
+	mailer "github.com/cybrarymin/greenlight/internal/mailter"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateSavedSearch(t *testing.T) {
+	insert := stub(`^INSERT INTO "saved_searches" .* VALUES \(.*, 'new action', '\{"genres":\["action"\],"year_from":2000\}', TRUE, \(SELECT COALESCE\(max\(change_seq\), 0\) FROM "movies"`,
+		[]string{"id", "alert_seq", "created_at"}, []driver.Value{int64(7), int64(42), time.Now()})
+
+	tests := []struct {
+		name    string
+		body    string
+		queries []stubQuery
+		status  int
+	}{
+		{name: "Movie list filters", body: `{"name":"new action","query":"genres=action&year_from=2000","alert":true}`, queries: []stubQuery{insert}, status: http.StatusCreated},
+		{name: "Missing name", body: `{"query":"genres=action"}`, status: http.StatusUnprocessableEntity},
+		{name: "Not a filter", body: `{"name":"new action","query":"genres=action&page=2"}`, status: http.StatusUnprocessableEntity},
+		{name: "Updated since", body: `{"name":"new action","query":"updated_since=2024-01-01T00:00:00Z"}`, status: http.StatusUnprocessableEntity},
+		{name: "Invalid filter", body: `{"name":"new action","query":"year_from=2000&year_to=1990"}`, status: http.StatusUnprocessableEntity},
+		{name: "Malformed query", body: `{"name":"new action","query":"genres=%zz"}`, status: http.StatusUnprocessableEntity},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			logger := zerolog.Nop()
+			app := &application{log: &logger, models: stubModels(t, tc.queries...)}
+			rctx := chi.NewRouteContext()
+			rctx.URLParams.Add("id", "me")
+			r := httptest.NewRequest(http.MethodPost, "/v1/users/me/searches", strings.NewReader(tc.body))
+			r = app.SetUserContext(r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, rctx)), &data.User{ID: uuid.New()})
+			rec := httptest.NewRecorder()
+			app.createSavedSearchHandler(rec, r)
+			assert.Equal(t, tc.status, rec.Code, rec.Body.String())
+			if tc.status == http.StatusCreated {
+				assert.Equal(t, "/v1/users/me/searches/7", rec.Header().Get("Location"))
+			}
+		})
+	}
+}
+
+func TestAlertSavedSearch(t *testing.T) {
+	now := time.Now()
+	movieColumns := []string{"id", "created_at", "title", "year", "runtime", "genres", "version"}
+	window := `^SELECT .* FROM "movies" AS "movie" WHERE \(genres @> '\{"action"\}'\) AND \(created_seq > 10 AND created_seq <= 20\) ORDER BY created_seq ASC LIMIT 20`
+	created := stub(window, movieColumns, []driver.Value{int64(11), now, "John Wick: Chapter 4", int64(2023), int64(169), "{action}", int64(1)})
+	// the movie has been updated once created, before the search has been checked
+	updated := stub(window, movieColumns, []driver.Value{int64(11), now, "John Wick: Chapter 4", int64(2023), int64(169), "{action}", int64(2)})
+	count := func(n int64) stubQuery {
+		return stub(`^SELECT count\(\*\) FROM "movies"`, []string{"count"}, []driver.Value{n})
+	}
+	advance := func(affected int64) stubQuery {
+		q := stub(`^UPDATE "saved_searches" .* SET alert_seq = 20, alerted_at = now\(\) WHERE \(id = 7 AND alert_seq = 10\)`, nil)
+		q.affected = affected
+		return q
+	}
+
+	tests := []struct {
+		name    string
+		queries []stubQuery
+		sent    bool
+	}{
+		{name: "New movies", queries: []stubQuery{created, count(3), advance(1)}, sent: true},
+		{name: "New movie updated since", queries: []stubQuery{updated, count(3), advance(1)}, sent: true},
+		{name: "No new movie", queries: []stubQuery{stub(`^SELECT .* FROM "movies" AS "movie"`, movieColumns), count(0), advance(1)}},
+		// another replica has checked the search in the meantime
+		{name: "Checked by another replica", queries: []stubQuery{created, count(1), advance(0)}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var mails bytes.Buffer
+			nMailer, err := mailer.New(mailer.Config{Sender: "no-reply@greenlight.com", MaxInFlight: 1, LogWriter: &mails})
+			require.NoError(t, err)
+			logger := zerolog.Nop()
+			app := &application{log: &logger, mailer: nMailer, models: stubModels(t, tc.queries...), stopBackground: make(chan struct{})}
+			search := &data.SavedSearch{
+				ID:       7,
+				User:     &data.User{Name: "john", Email: "john@example.com"},
+				Name:     "new action",
+				Filter:   data.MovieListFilter{Genres: []string{"action"}},
+				Alert:    true,
+				AlertSeq: 10,
+			}
+
+			sent, err := app.alertSavedSearch(context.Background(), search, 20)
+			require.NoError(t, err)
+			assert.Equal(t, tc.sent, sent)
+			if !tc.sent {
+				assert.Empty(t, mails.String())
+				return
+			}
+			assert.Contains(t, mails.String(), "john@example.com")
+			assert.Contains(t, mails.String(), "John Wick: Chapter 4 (2023)")
+			assert.Contains(t, mails.String(), "and 2 more.")
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package api
+
+import (
+	"os"
+	"strings"
+	"time"
+
+	"github.com/cybrarymin/greenlight/internal/secrets"
+	"github.com/uptrace/bun"
+)
+
+// SecretFile is a secret flag which can be read from the file named by its environment variable instead,
+// e.g. a docker secret or a mounted kubernetes secret
+type SecretFile struct {
+	Flag string
+	Env  string
+	// Secret is the name of the secret the file is rotated as when the files are watched. The files of the other
+	// flags are only read on startup. check runSecretFilesWatcher
+	Secret string
+}
+
+// SecretFiles are the secret flags which can be read from the files
+var SecretFiles = []SecretFile{
+	{Flag: "db-connection-string", Env: "GREENLIGHT_DB_DSN_FILE", Secret: secrets.DBDSN},
+	{Flag: "smtp-username", Env: "GREENLIGHT_SMTP_USERNAME_FILE", Secret: secrets.SMTPUsername},
+	{Flag: "smtp-password", Env: "GREENLIGHT_SMTP_PASSWORD_FILE", Secret: secrets.SMTPPassword},
+	{Flag: "jwt-key", Env: "GREENLIGHT_JWT_KEY_FILE", Secret: secrets.JWTKey},
+	{Flag: "paseto-key", Env: "GREENLIGHT_PASETO_KEY_FILE", Secret: secrets.PASETOKey},
+	{Flag: "email-webhook-secret", Env: "GREENLIGHT_EMAIL_WEBHOOK_SECRET_FILE"},
+	{Flag: "scim-bearer-token", Env: "GREENLIGHT_SCIM_BEARER_TOKEN_FILE"},
+	{Flag: "challenge-secret", Env: "GREENLIGHT_CHALLENGE_SECRET_FILE"},
+	{Flag: "challenge-trusted-api-keys", Env: "GREENLIGHT_CHALLENGE_TRUSTED_API_KEYS_FILE"},
+	{Flag: "invitation-signing-key", Env: "GREENLIGHT_INVITATION_SIGNING_KEY_FILE"},
+	{Flag: "share-signing-key", Env: "GREENLIGHT_SHARE_SIGNING_KEY_FILE"},
+	{Flag: "csrf-signing-key", Env: "GREENLIGHT_CSRF_SIGNING_KEY_FILE"},
+	{Flag: "cdn-purge-token", Env: "GREENLIGHT_CDN_PURGE_TOKEN_FILE"},
+	{Flag: "metrics-basic-auth-password", Env: "GREENLIGHT_METRICS_BASIC_AUTH_PASSWORD_FILE"},
+	{Flag: "metrics-bearer-token", Env: "GREENLIGHT_METRICS_BEARER_TOKEN_FILE"},
+}
+
+// SecretFilesWatchInterval is how often the files of the rotatable secrets are read again. zero disables it.
+var SecretFilesWatchInterval time.Duration
+
+// ReadSecretFile returns the content of the secret file without its trailing newline,
+// which the editors and `kubectl create secret --from-file` leave behind
+func ReadSecretFile(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
+
+// runSecretFilesWatcher reads the files of the rotatable secrets every --secret-files-watch-interval and rotates the
+// secrets whose file has changed like runSecretsRefresher does. The kubernetes secrets are updated in their mounts
+// by swapping a symlink so the files are read again instead of being watched by inotify.
+func (app *application) runSecretFilesWatcher(db *bun.DB, connector *rotatingConnector) {
+	ticker := time.NewTicker(SecretFilesWatchInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if app.draining.Load() {
+			return
+		}
+		values := make(map[string]string)
+		for _, file := range SecretFiles {
+			path := os.Getenv(file.Env)
+			if file.Secret == "" || path == "" {
+				continue
+			}
+			value, err := ReadSecretFile(path)
+			if err != nil {
+				logEvents(app.log).Error(err, "failed to read the secret file", logFields{"env": file.Env})
+				continue
+			}
+			values[file.Secret] = value
+		}
+		app.rotateSecrets(db, connector, applySecrets(values))
+	}
+}
@@ -0,0 +1,46 @@
+package api
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadSecretFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{name: "Without newline", content: "secret", want: "secret"},
+		{name: "Trailing newline", content: "secret\n", want: "secret"},
+		{name: "Trailing crlf", content: "secret\r\n", want: "secret"},
+		{name: "Inner newline is kept", content: "line1\nline2\n", want: "line1\nline2"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "secret")
+			require.NoError(t, os.WriteFile(path, []byte(tc.content), 0o600))
+			got, err := ReadSecretFile(path)
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+
+	_, err := ReadSecretFile(filepath.Join(t.TempDir(), "missing"))
+	assert.Error(t, err)
+}
+
+func TestSecretFilesEnvs(t *testing.T) {
+	seen := make(map[string]bool)
+	for _, file := range SecretFiles {
+		assert.True(t, strings.HasPrefix(file.Env, "GREENLIGHT_") && strings.HasSuffix(file.Env, "_FILE"), file.Env)
+		assert.False(t, seen[file.Env], "%s is used by more than one flag", file.Env)
+		seen[file.Env] = true
+	}
+}
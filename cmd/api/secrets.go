@@ -0,0 +1,221 @@
+package api
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cybrarymin/greenlight/internal/httpclient"
+	"github.com/cybrarymin/greenlight/internal/secrets"
+	"github.com/uptrace/bun"
+)
+
+// secrets backends. check --secrets-backend
+const (
+	SecretsVault = "vault"
+	SecretsAWS   = "aws-secrets-manager"
+)
+
+var (
+	// SecretsBackend is the store the secrets are fetched from on startup. The secrets found there override the flags.
+	SecretsBackend string
+	// SecretsPath is the path of the secret in the vault kv mount or the name of the aws secret
+	SecretsPath string
+	// VaultMount is the mount path of the vault kv version 2 engine. The address and the token of vault are read from
+	// VAULT_ADDR and VAULT_TOKEN so the token doesn't show up in the process listings.
+	VaultMount string
+	// AWSSecretsEndpoint overrides the regional endpoint of secrets manager. The region and the credentials are read
+	// from AWS_REGION, AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN.
+	AWSSecretsEndpoint string
+	// SecretsRefreshInterval is how often the secrets are fetched again to pick up their rotation. zero disables it.
+	SecretsRefreshInterval time.Duration
+)
+
+// secretsMu guards the secrets which are rotated after startup. check runSecretsRefresher
+var secretsMu sync.RWMutex
+
+// secretTargets are the settings each secret of the store is applied to
+func secretTargets() map[string]*string {
+	return map[string]*string{
+		secrets.DBDSN:        &DBDSN,
+		secrets.SMTPUsername: &SMTPUserName,
+		secrets.SMTPPassword: &SMTPPassword,
+		secrets.JWTKey:       &JWTKEY,
+		secrets.PASETOKey:    &PASETOKey,
+	}
+}
+
+func secretsStore() (secrets.Store, error) {
+	client := httpclient.New(httpclient.DefaultConfig())
+	switch SecretsBackend {
+	case SecretsVault:
+		addr, token := os.Getenv("VAULT_ADDR"), os.Getenv("VAULT_TOKEN")
+		if addr == "" || token == "" {
+			return nil, errors.New("VAULT_ADDR and VAULT_TOKEN must be set for the vault secrets backend")
+		}
+		return &secrets.Vault{Addr: addr, Token: token, Mount: VaultMount, Path: SecretsPath, Client: client}, nil
+	case SecretsAWS:
+		creds := secrets.AWSCredentials{
+			AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		}
+		region := os.Getenv("AWS_REGION")
+		if region == "" || creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+			return nil, errors.New("AWS_REGION, AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set for the aws secrets manager backend")
+		}
+		return &secrets.AWSSecretsManager{Region: region, SecretID: SecretsPath, Credentials: creds, Endpoint: AWSSecretsEndpoint, Client: client}, nil
+	default:
+		return nil, errors.New("unknown secrets backend " + SecretsBackend)
+	}
+}
+
+// LoadSecrets fetches the secrets from --secrets-backend and applies them over the flags.
+// It runs before the configuration is validated so the fetched secrets are validated like the flags.
+func LoadSecrets(ctx context.Context) error {
+	store, err := secretsStore()
+	if err != nil {
+		return err
+	}
+	values, err := store.Fetch(ctx)
+	if err != nil {
+		return err
+	}
+	applySecrets(values)
+	return nil
+}
+
+// applySecrets sets the known secrets of values and returns the names of the ones which have changed
+func applySecrets(values map[string]string) []string {
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+	var changed []string
+	for name, target := range secretTargets() {
+		value, found := values[name]
+		if !found || value == "" || value == *target {
+			continue
+		}
+		*target = value
+		changed = append(changed, name)
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// jwtKey returns the key signing the jwts, which might be rotated by the secrets backend
+func jwtKey() []byte {
+	secretsMu.RLock()
+	defer secretsMu.RUnlock()
+	return []byte(JWTKEY)
+}
+
+// runSecretsRefresher fetches the secrets every --secrets-refresh-interval. The smtp connections and the database
+// connections are dialed again by the rotated credentials, the jwts and the pasetos are signed by the rotated keys.
+// The tokens signed by a previous key are rejected once it's rotated.
+func (app *application) runSecretsRefresher(db *bun.DB, connector *rotatingConnector) {
+	store, err := secretsStore()
+	if err != nil {
+		logEvents(app.log).Error(err, "secrets refresh is disabled", nil)
+		return
+	}
+	ticker := time.NewTicker(SecretsRefreshInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if app.draining.Load() {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		values, err := store.Fetch(ctx)
+		cancel()
+		if err != nil {
+			logEvents(app.log).Error(err, "failed to refresh the secrets", nil)
+			continue
+		}
+		app.rotateSecrets(db, connector, applySecrets(values))
+	}
+}
+
+// rotateSecrets puts the changed secrets to use once they're applied. check applySecrets
+func (app *application) rotateSecrets(db *bun.DB, connector *rotatingConnector, changed []string) {
+	if len(changed) == 0 {
+		return
+	}
+	logEvents(app.log).Info("secrets rotated", logFields{"secrets": changed})
+	for _, name := range changed {
+		switch name {
+		case secrets.DBDSN:
+			err := app.redialDB(db, connector)
+			if err != nil {
+				logEvents(app.log).Error(err, "failed to dial the database by the rotated dsn, keeping the previous one", nil)
+			}
+		case secrets.SMTPUsername, secrets.SMTPPassword:
+			secretsMu.RLock()
+			app.mailer.SetCredentials(SMTPUserName, SMTPPassword)
+			secretsMu.RUnlock()
+		}
+	}
+}
+
+// redialDB dials the new database connections by the rotated dsn. The dsn is tried before the swap so a broken one
+// doesn't take the pool down. The idle connections of the previous dsn are closed, the ones in use are closed by
+// --db-max-idle-time once they're released.
+func (app *application) redialDB(db *bun.DB, connector *rotatingConnector) error {
+	cfg := app.config
+	secretsMu.RLock()
+	cfg.db.dbDsn = DBDSN
+	secretsMu.RUnlock()
+	next, err := dbConnector(&cfg)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	conn, err := next.Connect(ctx)
+	if err != nil {
+		return err
+	}
+	conn.Close()
+
+	connector.swap(next)
+	db.SetMaxIdleConns(0)
+	db.SetMaxIdleConns(DBMaxIdleConnCount)
+	return nil
+}
+
+// rotatingConnector dials the database connections by a connector which can be swapped, e.g. when the dsn is rotated
+type rotatingConnector struct {
+	mu        sync.RWMutex
+	connector driver.Connector
+}
+
+func newRotatingConnector(cfg *config) (*rotatingConnector, error) {
+	connector, err := dbConnector(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &rotatingConnector{connector: connector}, nil
+}
+
+func (c *rotatingConnector) current() driver.Connector {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.connector
+}
+
+func (c *rotatingConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	return c.current().Connect(ctx)
+}
+
+func (c *rotatingConnector) Driver() driver.Driver {
+	return c.current().Driver()
+}
+
+func (c *rotatingConnector) swap(connector driver.Connector) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.connector = connector
+}
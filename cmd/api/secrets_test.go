@@ -0,0 +1,26 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/cybrarymin/greenlight/internal/secrets"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplySecrets(t *testing.T) {
+	defer func(dsn, username, password, key string) {
+		DBDSN, SMTPUserName, SMTPPassword, JWTKEY = dsn, username, password, key
+	}(DBDSN, SMTPUserName, SMTPPassword, JWTKEY)
+	DBDSN, SMTPUserName, SMTPPassword, JWTKEY = "postgres://localhost/greenlight", "mailer", "pass", "key"
+
+	changed := applySecrets(map[string]string{
+		secrets.DBDSN:        "postgres://localhost/greenlight",
+		secrets.SMTPPassword: "rotated",
+		secrets.JWTKey:       "",
+		"unknown":            "value",
+	})
+	assert.Equal(t, []string{secrets.SMTPPassword}, changed)
+	assert.Equal(t, "rotated", SMTPPassword)
+	assert.Equal(t, "mailer", SMTPUserName)
+	assert.Equal(t, []byte("key"), jwtKey())
+}
@@ -0,0 +1,59 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+var (
+	ServerReadTimeout       time.Duration
+	ServerReadHeaderTimeout time.Duration
+	ServerWriteTimeout      time.Duration
+	ServerIdleTimeout       time.Duration
+	ServerMaxHeaderBytes    int
+	// ServerKeepAlives can be disabled for the clients or the load balancers mishandling the reused connections
+	ServerKeepAlives bool
+	// H2C serves HTTP/2 without TLS on the http listener for the internal callers speaking it with prior knowledge or by upgrade.
+	// It must only be enabled behind a trusted network as the plaintext HTTP/2 isn't meant for the public clients.
+	H2C bool
+	// ShutdownDrainDelay keeps serving after the shutdown signal while the readiness check fails,
+	// so the load balancers stop sending new requests before the listeners are closed.
+	ShutdownDrainDelay time.Duration
+	// ShutdownTimeout is how long the shutdown waits for the active requests before closing their connections
+	ShutdownTimeout time.Duration
+)
+
+// newHTTPServer creates the server of the http listener from the tuning flags
+func newHTTPServer(handler http.Handler, logger zerolog.Logger) (*http.Server, error) {
+	srv := &http.Server{
+		Addr:              fmt.Sprintf(":%d", ListenPort),
+		Handler:           markServed(handler),
+		ErrorLog:          log.New(logger, "", 0),
+		ReadTimeout:       ServerReadTimeout,
+		ReadHeaderTimeout: ServerReadHeaderTimeout,
+		WriteTimeout:      ServerWriteTimeout,
+		IdleTimeout:       ServerIdleTimeout,
+		MaxHeaderBytes:    ServerMaxHeaderBytes,
+		// the connections of the public listener are tracked to count the requests which never reach the handlers
+		ConnContext: trackConnContext,
+		ConnState:   trackConnState,
+	}
+	srv.SetKeepAlivesEnabled(ServerKeepAlives)
+
+	if H2C {
+		h2s := &http2.Server{IdleTimeout: ServerIdleTimeout}
+		// ConfigureServer hooks the HTTP/2 connections into Shutdown, so they get a GOAWAY and drain like the others
+		err := http2.ConfigureServer(srv, h2s)
+		if err != nil {
+			return nil, err
+		}
+		srv.Handler = h2c.NewHandler(srv.Handler, h2s)
+	}
+	return srv, nil
+}
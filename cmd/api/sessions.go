@@ -0,0 +1,144 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/cybrarymin/greenlight/internal/data"
+	"go.opentelemetry.io/otel/codes"
+)
+
+var (
+	// CookieSessions enables the cookie sessions of the web frontend as an alternative to the Authorization header
+	CookieSessions bool
+	// SessionIdleTimeout is how long a session lives without any request. Every request slides its expiry.
+	SessionIdleTimeout time.Duration
+	// SessionMaxLifetime is how long a session lives at most regardless of its activity
+	SessionMaxLifetime time.Duration
+)
+
+// sessionTouchInterval limits the writes of the sliding expiry to one per session in the interval
+const sessionTouchInterval = time.Minute
+
+// sessionExpiry slides the expiry of a session created at createdAt without passing its max lifetime
+func sessionExpiry(createdAt time.Time) time.Time {
+	expiry := time.Now().Add(SessionIdleTimeout)
+	if limit := createdAt.Add(SessionMaxLifetime); expiry.After(limit) {
+		return limit
+	}
+	return expiry
+}
+
+func setSessionCookie(w http.ResponseWriter, session *data.Session) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    session.PlainText,
+		Path:     "/",
+		Expires:  session.Expiry,
+		HttpOnly: true,
+		Secure:   CookieSecure,
+		SameSite: cookieSameSite(),
+	})
+}
+
+func clearSessionCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   CookieSecure,
+		SameSite: cookieSameSite(),
+	})
+}
+
+// sessionUser returns the user of the session cookie and slides the expiry of the session.
+// It returns data.ErrorRecordNotFound when the session is invalid or expired.
+func (app *application) sessionUser(ctx context.Context, w http.ResponseWriter, token string) (*data.User, error) {
+	nValidator := data.NewValidator()
+	data.ValidateTokenPlaintext(nValidator, token)
+	if !nValidator.Valid() {
+		return nil, data.ErrorRecordNotFound
+	}
+	session, err := app.models.Sessions.GetForToken(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	if time.Since(session.LastSeenAt) >= sessionTouchInterval {
+		err := app.models.Sessions.Touch(ctx, session, sessionExpiry(session.CreatedAt))
+		if err != nil {
+			return nil, err
+		}
+		session.PlainText = token
+		setSessionCookie(w, session)
+	}
+	return session.User, nil
+}
+
+// createSessionHandler signs the user in by the basic authentication credentials and sets the session cookie.
+// The web frontend then gets authenticated by the cookie instead of keeping a token in the browser storage.
+func (app *application) createSessionHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := app.startSpan(r, "createSession")
+	defer span.End()
+
+	ok, nUser := app.BasicAuth(w, r)
+	if !ok {
+		return
+	}
+	session := &data.Session{
+		UserID:     nUser.ID,
+		RemoteAddr: clientIP(r),
+		UserAgent:  r.UserAgent(),
+		Expiry:     sessionExpiry(time.Now()),
+	}
+	err := app.models.Sessions.New(ctx, session)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, otelDBErr)
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	app.recordLogin(ctx, r, nUser, data.LoginTokenSession)
+
+	setSessionCookie(w, session)
+	// the token of signing in isn't valid for the new session, the frontend gets the one of the session along with it
+	csrfToken, err := newCSRFToken(w, session.PlainText)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to generate the csrf token")
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJson(w, r, http.StatusCreated, envelope{"result": session, "CSRFToken": csrfToken}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteSessionHandler signs the user out by ending the session of the cookie and clearing the cookie
+func (app *application) deleteSessionHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := app.startSpan(r, "deleteSession")
+	defer span.End()
+
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil {
+		app.authenticationRequiredResposne(w, r)
+		return
+	}
+	err = app.models.Sessions.Delete(ctx, cookie.Value)
+	if err != nil && !errors.Is(err, data.ErrorRecordNotFound) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, otelDBErr)
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	clearSessionCookie(w)
+	err = app.writeJson(w, r, http.StatusOK, envelope{"result": "signed out"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
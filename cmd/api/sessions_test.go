@@ -0,0 +1,105 @@
+package api
+
+import (
+	"context"
+	"database/sql/driver"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cybrarymin/greenlight/internal/data"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessionExpiry(t *testing.T) {
+	defer func(idle, lifetime time.Duration) {
+		SessionIdleTimeout, SessionMaxLifetime = idle, lifetime
+	}(SessionIdleTimeout, SessionMaxLifetime)
+	SessionIdleTimeout, SessionMaxLifetime = time.Hour, 24*time.Hour
+
+	tests := []struct {
+		name      string
+		createdAt time.Time
+		expiry    time.Time
+	}{
+		{name: "Slides by the idle timeout", createdAt: time.Now().Add(-time.Hour), expiry: time.Now().Add(time.Hour)},
+		{name: "Capped by the max lifetime", createdAt: time.Now().Add(-23*time.Hour - 30*time.Minute), expiry: time.Now().Add(30 * time.Minute)},
+		{name: "Past the max lifetime", createdAt: time.Now().Add(-48 * time.Hour), expiry: time.Now().Add(-24 * time.Hour)},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.WithinDuration(t, tc.expiry, sessionExpiry(tc.createdAt), time.Second)
+		})
+	}
+}
+
+func TestSessionUserSlidesExpiry(t *testing.T) {
+	defer func(idle, lifetime time.Duration) {
+		SessionIdleTimeout, SessionMaxLifetime = idle, lifetime
+	}(SessionIdleTimeout, SessionMaxLifetime)
+	SessionIdleTimeout, SessionMaxLifetime = time.Hour, 24*time.Hour
+
+	token := strings.Repeat("A", 26)
+	userID := uuid.New()
+	now := time.Now()
+	sessionColumns := []string{"hash", "user_id", "remote_addr", "user_agent", "created_at", "last_seen_at", "expiry",
+		"user__id", "user__name", "user__email", "user__activated", "user__version"}
+	session := func(lastSeenAt time.Time) []driver.Value {
+		return []driver.Value{[]byte("hash"), userID.String(), "127.0.0.1", "test", now.Add(-2 * time.Hour), lastSeenAt,
+			lastSeenAt.Add(time.Hour), userID.String(), "john", "john@example.com", true, int64(1)}
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		queries []stubQuery
+		err     error
+		touched bool
+	}{
+		{name: "Idle past the touch interval", token: token, queries: []stubQuery{
+			stub(`^SELECT .* FROM "sessions" AS "session" .* WHERE \(session.hash = .* AND session.expiry > now\(\)\)`, sessionColumns, session(now.Add(-5*time.Minute))),
+			stub(`^UPDATE "sessions" .* SET last_seen_at = now\(\), expiry = .* RETURNING last_seen_at`, []string{"last_seen_at"}, []driver.Value{now}),
+		}, touched: true},
+		// the requests within the touch interval don't write the session
+		{name: "Seen within the touch interval", token: token, queries: []stubQuery{
+			stub(`^SELECT .* FROM "sessions"`, sessionColumns, session(now.Add(-10*time.Second))),
+		}},
+		{name: "Expired or unknown session", token: token, queries: []stubQuery{
+			stub(`^SELECT .* FROM "sessions"`, sessionColumns),
+		}, err: data.ErrorRecordNotFound},
+		{name: "Malformed token", token: "token", err: data.ErrorRecordNotFound},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			logger := zerolog.Nop()
+			app := &application{log: &logger, models: stubModels(t, tc.queries...)}
+			rec := httptest.NewRecorder()
+			user, err := app.sessionUser(context.Background(), rec, tc.token)
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+				assert.Empty(t, rec.Result().Cookies())
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, userID, user.ID)
+
+			cookies := rec.Result().Cookies()
+			if !tc.touched {
+				assert.Empty(t, cookies)
+				return
+			}
+			require.Len(t, cookies, 1)
+			assert.Equal(t, sessionCookieName, cookies[0].Name)
+			assert.Equal(t, tc.token, cookies[0].Value)
+			assert.True(t, cookies[0].HttpOnly)
+			// the cookie carries the slid expiry, which the max lifetime of the session doesn't cap yet
+			assert.WithinDuration(t, now.Add(SessionIdleTimeout), cookies[0].Expires, 2*time.Second)
+		})
+	}
+}
@@ -0,0 +1,259 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/cybrarymin/greenlight/internal/data"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// ShareSigningKey is the hmac key used to sign the share urls of the movies. sharing is disabled when it's empty.
+var ShareSigningKey string
+
+const (
+	defaultShareTTL = 72 * time.Hour
+	maxShareTTL     = 30 * 24 * time.Hour
+)
+
+// signShare returns the hex encoded hmac-sha256 signature of a share url.
+// The movie, the share and the expiry are all signed so none of them can be changed in the url.
+func signShare(movieID, shareID, expires int64) string {
+	mac := hmac.New(sha256.New, []byte(ShareSigningKey))
+	fmt.Fprintf(mac, "%d:%d:%d", movieID, shareID, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func shareURL(share *data.MovieShare) string {
+	expires := share.Expiry.Unix()
+	qs := url.Values{}
+	qs.Set("share", strconv.FormatInt(share.ID, 10))
+	qs.Set("expires", strconv.FormatInt(expires, 10))
+	qs.Set("signature", signShare(share.MovieID, share.ID, expires))
+	return fmt.Sprintf("/v1/shared/movies/%d?%s", share.MovieID, qs.Encode())
+}
+
+func (app *application) createMovieShareHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := app.startSpan(r, "createMovieShare")
+	defer span.End()
+
+	id := app.idParam(r, "id")
+
+	var input struct {
+		TTL string `json:"ttl"`
+	}
+	// the body is optional, the default ttl is used without it
+	if r.ContentLength != 0 {
+		err := app.readJson(w, r, &input)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, otelunprocessableErr)
+			app.badRequestResponse(w, r, err)
+			return
+		}
+	}
+
+	ttl := defaultShareTTL
+	nValidator := data.NewValidator()
+	if input.TTL != "" {
+		var err error
+		ttl, err = time.ParseDuration(input.TTL)
+		nValidator.Check(err == nil, "ttl", "must be a valid duration like 72h")
+	}
+	nValidator.Check(ttl > 0 && ttl <= maxShareTTL, "ttl", "must be between 1s and 720h")
+	if !nValidator.Valid() {
+		span.RecordError(errors.New(createKeyValuePairs(nValidator.Errors)))
+		span.SetStatus(codes.Error, otelunprocessableErr)
+		app.failedValidationResponse(w, r, nValidator)
+		return
+	}
+
+	_, err := app.models.Movies.Select(ctx, id)
+	if err != nil {
+		span.RecordError(err)
+		switch {
+		case errors.Is(err, data.ErrorRecordNotFound):
+			span.SetStatus(codes.Ok, otelDBNotFoundInfo)
+			app.notFoundResponse(w, r)
+		default:
+			span.SetStatus(codes.Error, otelDBErr)
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	share := &data.MovieShare{
+		MovieID:   id,
+		CreatedBy: app.GetUserContext(r).ID,
+		// the expiry is stored in seconds since the signature uses the unix time
+		Expiry: time.Now().Add(ttl).Truncate(time.Second),
+	}
+	err = app.models.MovieShares.Insert(ctx, share)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, otelDBErr)
+		app.dbWriteErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJson(w, r, http.StatusCreated, envelope{"Share": share, "URL": shareURL(share)}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listMovieSharesHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := app.startSpan(r, "listMovieShares")
+	defer span.End()
+
+	id := app.idParam(r, "id")
+	shares, err := app.models.MovieShares.ListForMovie(ctx, id)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, otelDBErr)
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJson(w, r, http.StatusOK, envelope{"Shares": shares}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) revokeMovieShareHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := app.startSpan(r, "revokeMovieShare")
+	defer span.End()
+
+	id := app.idParam(r, "id")
+	shareID := app.idParam(r, "share_id")
+
+	err := app.models.MovieShares.Revoke(ctx, id, shareID)
+	if err != nil {
+		span.RecordError(err)
+		switch {
+		case errors.Is(err, data.ErrorRecordNotFound):
+			span.SetStatus(codes.Ok, otelDBNotFoundInfo)
+			app.notFoundResponse(w, r)
+		default:
+			span.SetStatus(codes.Error, otelDBErr)
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJson(w, r, http.StatusOK, envelope{"message": "share revoked successfully"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listMovieShareAccessesHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := app.startSpan(r, "listMovieShareAccesses")
+	defer span.End()
+
+	id := app.idParam(r, "id")
+	shareID := app.idParam(r, "share_id")
+
+	var filters data.Filters
+	nValidator := data.NewValidator()
+	qs := r.URL.Query()
+	filters.Page = app.readInt(qs, "page", 1, nValidator)
+	filters.PageSize = app.readInt(qs, "page_size", 20, nValidator)
+	filters.Sort = app.readString(qs, "sort", "-id")
+	filters.Count = app.readString(qs, "count", data.CountExact)
+	filters.SortSafeList = []string{"id", "accessed_at", "-id", "-accessed_at"}
+	filters.ValidateFilters(nValidator)
+	if !nValidator.Valid() {
+		span.RecordError(errors.New(createKeyValuePairs(nValidator.Errors)))
+		span.SetStatus(codes.Error, otelunprocessableErr)
+		app.failedValidationResponse(w, r, nValidator)
+		return
+	}
+
+	accesses, count, err := app.models.MovieShares.ListAccesses(ctx, id, shareID, &filters)
+	if err != nil {
+		span.RecordError(err)
+		switch {
+		case errors.Is(err, data.ErrorRecordNotFound):
+			span.SetStatus(codes.Ok, otelDBNotFoundInfo)
+		default:
+			span.SetStatus(codes.Error, otelDBErr)
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	pMeta := filters.PaginationMetaData(ctx, count)
+	err = app.writeJson(w, r, http.StatusOK, envelope{"Metadata": pMeta, "Accesses": accesses}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// showSharedMovieHandler serves a movie without authentication to the holders of a valid share url.
+// Every successful access is recorded in the audit log of the share.
+func (app *application) showSharedMovieHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := app.startSpan(r, "showSharedMovie")
+	defer span.End()
+
+	id := app.idParam(r, "id")
+	qs := r.URL.Query()
+	shareID, errShare := strconv.ParseInt(qs.Get("share"), 10, 64)
+	expires, errExpires := strconv.ParseInt(qs.Get("expires"), 10, 64)
+	given, errSignature := hex.DecodeString(qs.Get("signature"))
+	expected, _ := hex.DecodeString(signShare(id, shareID, expires))
+	if errShare != nil || errExpires != nil || errSignature != nil || !hmac.Equal(given, expected) {
+		span.SetStatus(codes.Error, otelAuthFailureErr)
+		app.invalidShareLinkResponse(w, r)
+		return
+	}
+	if time.Now().Unix() > expires {
+		span.SetStatus(codes.Error, otelAuthFailureErr)
+		app.invalidShareLinkResponse(w, r)
+		return
+	}
+
+	// the share of a movie merged into another one serves the movie it's been merged into
+	share, err := app.models.MovieShares.Access(ctx, id, shareID, &data.MovieShareAccess{
+		RemoteAddr: r.RemoteAddr,
+		UserAgent:  r.UserAgent(),
+	})
+	if err != nil {
+		span.RecordError(err)
+		switch {
+		case errors.Is(err, data.ErrorRecordNotFound), errors.Is(err, data.ErrShareRevoked):
+			span.SetStatus(codes.Error, otelAuthFailureErr)
+			app.invalidShareLinkResponse(w, r)
+		default:
+			span.SetStatus(codes.Error, otelDBErr)
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	movie, err := app.models.Movies.Select(ctx, share.MovieID)
+	if err != nil {
+		span.RecordError(err)
+		switch {
+		case errors.Is(err, data.ErrorRecordNotFound):
+			span.SetStatus(codes.Ok, otelDBNotFoundInfo)
+			app.notFoundResponse(w, r)
+		default:
+			span.SetStatus(codes.Error, otelDBErr)
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJson(w, r, http.StatusOK, envelope{"Movie": movie}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,78 @@
+package api
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShowSharedMovie(t *testing.T) {
+	defer func(key, exportMode string) { ShareSigningKey, MetricsExportMode = key, exportMode }(ShareSigningKey, MetricsExportMode)
+	ShareSigningKey, MetricsExportMode = "share-signing-key", MetricsExportPrometheus
+
+	now := time.Now()
+	expires := now.Add(time.Hour).Unix()
+	shareColumns := []string{"id", "movie_id", "created_by", "expiry", "revoked_at", "access_count", "created_at"}
+	shareRow := []driver.Value{int64(7), int64(1), uuid.New().String(), time.Unix(expires, 0), nil, int64(0), now}
+	revokedRow := []driver.Value{int64(7), int64(1), uuid.New().String(), time.Unix(expires, 0), now, int64(0), now}
+	mergedRow := []driver.Value{int64(7), int64(2), uuid.New().String(), time.Unix(expires, 0), nil, int64(0), now}
+	movieColumns := []string{"id", "created_at", "title", "year", "runtime", "genres", "version"}
+	access := []stubQuery{
+		stub(`^INSERT INTO "movie_share_accesses"`, []string{"id", "accessed_at"}, []driver.Value{int64(1), now}),
+		stub(`^UPDATE "movie_shares" .* SET access_count = access_count \+ 1 WHERE \(id = 7\)`, nil, nil),
+	}
+
+	target := func(movieID, shareID, expires int64, signature string) string {
+		return fmt.Sprintf("/v1/shared/movies/%d?share=%d&expires=%d&signature=%s", movieID, shareID, expires, signature)
+	}
+
+	tests := []struct {
+		name    string
+		target  string
+		queries []stubQuery
+		status  int
+	}{
+		{name: "Valid link", target: target(1, 7, expires, signShare(1, 7, expires)), queries: append([]stubQuery{
+			stub(`^SELECT .* FROM "movie_shares" AS "movie_share" WHERE \(id = 7\) AND \(movie_id = COALESCE\(\(SELECT .* old_id = '1'\)\), 1\)\) FOR UPDATE`, shareColumns, shareRow),
+			stub(`^SELECT "movie"\..* WHERE \(id = 1\)`, movieColumns, []driver.Value{int64(1), now, "avengers", int64(2018), int64(75), "{action}", int64(1)}),
+		}, access...), status: http.StatusOK},
+		// the link of a movie merged into another one keeps its signature over the old movie id
+		{name: "Link of a merged movie", target: target(1, 7, expires, signShare(1, 7, expires)), queries: append([]stubQuery{
+			stub(`^SELECT .* FROM "movie_shares" AS "movie_share" WHERE \(id = 7\) AND \(movie_id = COALESCE\(\(SELECT .* old_id = '1'\)\), 1\)\) FOR UPDATE`, shareColumns, mergedRow),
+			stub(`^SELECT "movie"\..* WHERE \(id = 2\)`, movieColumns, []driver.Value{int64(2), now, "avengers", int64(2018), int64(75), "{action}", int64(1)}),
+		}, access...), status: http.StatusOK},
+		{name: "Revoked share", target: target(1, 7, expires, signShare(1, 7, expires)), queries: []stubQuery{
+			stub(`^SELECT .* FROM "movie_shares"`, shareColumns, revokedRow),
+		}, status: http.StatusForbidden},
+		{name: "Unknown share", target: target(1, 7, expires, signShare(1, 7, expires)), queries: []stubQuery{
+			stub(`^SELECT .* FROM "movie_shares"`, shareColumns),
+		}, status: http.StatusForbidden},
+		// the links failing the signature or the expiry are rejected before any query
+		{name: "Expired link", target: target(1, 7, now.Add(-time.Second).Unix(), signShare(1, 7, now.Add(-time.Second).Unix())), status: http.StatusForbidden},
+		{name: "Extended expiry", target: target(1, 7, expires+3600, signShare(1, 7, expires)), status: http.StatusForbidden},
+		{name: "Other movie", target: target(2, 7, expires, signShare(1, 7, expires)), status: http.StatusForbidden},
+		{name: "Other share", target: target(1, 8, expires, signShare(1, 7, expires)), status: http.StatusForbidden},
+		{name: "Tampered signature", target: target(1, 7, expires, signShare(1, 7, expires)[2:]+"00"), status: http.StatusForbidden},
+		{name: "Missing signature", target: fmt.Sprintf("/v1/shared/movies/1?share=7&expires=%d", expires), status: http.StatusForbidden},
+		{name: "Malformed expiry", target: fmt.Sprintf("/v1/shared/movies/1?share=7&expires=soon&signature=%s", signShare(1, 7, 0)), status: http.StatusForbidden},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			logger := zerolog.Nop()
+			app := &application{log: &logger, models: stubModels(t, tc.queries...)}
+			app.runtime.Store(&runtimeConfig{})
+			r := httptest.NewRequest(http.MethodGet, tc.target, nil)
+			rec := httptest.NewRecorder()
+			app.routes().ServeHTTP(rec, r)
+			assert.Equal(t, tc.status, rec.Code, rec.Body.String())
+		})
+	}
+}
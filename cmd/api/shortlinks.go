@@ -0,0 +1,103 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/cybrarymin/greenlight/internal/data"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// PublicBaseURL is the base url of the public website showing the movies. short links are disabled when it's empty.
+var PublicBaseURL string
+
+func shortLinkURL(code string) string {
+	return "/s/" + code
+}
+
+// movieDeepLink returns the url of the movie on the public website
+func movieDeepLink(movieID int64) string {
+	return fmt.Sprintf("%s/movies/%d", strings.TrimRight(PublicBaseURL, "/"), movieID)
+}
+
+// createShortLinkHandler returns the short link of the movie. The link is created on the first request
+// and the same link is returned afterwards so every movie has a single code.
+func (app *application) createShortLinkHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := app.startSpan(r, "createShortLink")
+	defer span.End()
+
+	id := app.idParam(r, "id")
+
+	_, err := app.models.Movies.Select(ctx, id)
+	if err != nil {
+		span.RecordError(err)
+		switch {
+		case errors.Is(err, data.ErrorRecordNotFound):
+			span.SetStatus(codes.Ok, otelDBNotFoundInfo)
+			app.notFoundResponse(w, r)
+		default:
+			span.SetStatus(codes.Error, otelDBErr)
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	link := &data.ShortLink{
+		MovieID:   id,
+		CreatedBy: app.GetUserContext(r).ID,
+	}
+	created, err := app.models.ShortLinks.GetOrCreate(ctx, link)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, otelDBErr)
+		app.dbWriteErrorResponse(w, r, err)
+		return
+	}
+
+	status := http.StatusOK
+	headers := make(http.Header)
+	if created {
+		status = http.StatusCreated
+		headers.Set("Location", shortLinkURL(link.Code))
+	}
+	err = app.writeJson(w, r, status, envelope{"ShortLink": link, "URL": shortLinkURL(link.Code)}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// followShortLinkHandler counts the visit of the short link and redirects to the movie on the public website
+func (app *application) followShortLinkHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := app.startSpan(r, "followShortLink")
+	defer span.End()
+
+	code := app.pathParams(r).ByName("code")
+	nValidator := data.NewValidator()
+	data.ValidateShortCode(nValidator, code)
+	if !nValidator.Valid() {
+		// malformed codes can't exist so they're answered the same as the unknown ones
+		span.SetStatus(codes.Ok, otelDBNotFoundInfo)
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	movieID, err := app.models.ShortLinks.Hit(ctx, code)
+	if err != nil {
+		span.RecordError(err)
+		switch {
+		case errors.Is(err, data.ErrorRecordNotFound):
+			span.SetStatus(codes.Ok, otelDBNotFoundInfo)
+			app.notFoundResponse(w, r)
+		default:
+			span.SetStatus(codes.Error, otelDBErr)
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// the redirect isn't cached so every visit reaches the server and gets counted
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, movieDeepLink(movieID), http.StatusFound)
+}
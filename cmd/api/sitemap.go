@@ -0,0 +1,134 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/codes"
+)
+
+const (
+	// sitemapMaxURLs is the limit of the urls in a single sitemap file defined by the sitemap protocol
+	sitemapMaxURLs = 50_000
+	// sitemapDebounce coalesces the catalog changes of a bulk of writes into a single regeneration
+	sitemapDebounce = 30 * time.Second
+	// sitemapRefreshInterval regenerates the sitemap even without changes, so the writes of the other instances
+	// are picked up eventually
+	sitemapRefreshInterval = time.Hour
+)
+
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 urlset"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapURL struct {
+	Loc string `xml:"loc"`
+}
+
+// sitemap holds the latest generated sitemap.xml. It's generated in the background and served from memory.
+type sitemap struct {
+	current atomic.Pointer[sitemapSnapshot]
+	changed chan struct{}
+}
+
+type sitemapSnapshot struct {
+	body      []byte
+	generated time.Time
+}
+
+func newSitemap() *sitemap {
+	return &sitemap{changed: make(chan struct{}, 1)}
+}
+
+// catalogChanged schedules the regeneration of the sitemap. It never blocks the request changing the catalog.
+func (app *application) catalogChanged() {
+	if app.sitemap == nil {
+		return
+	}
+	select {
+	case app.sitemap.changed <- struct{}{}:
+	default:
+	}
+}
+
+// generateSitemap renders the public urls of all the movies
+func (app *application) generateSitemap(ctx context.Context) error {
+	ctx, span := startBackgroundSpan(ctx, "generateSitemap")
+	defer span.End()
+
+	// one more id than allowed tells whether the catalog has outgrown a single sitemap
+	ids, err := app.models.Movies.IDs(ctx, sitemapMaxURLs+1)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, otelDBErr)
+		return err
+	}
+	if len(ids) > sitemapMaxURLs {
+		logEvents(app.log).Warn("the catalog has more movies than a sitemap can list, the newest movies are left out", logFields{
+			"limit": sitemapMaxURLs,
+		})
+		ids = ids[:sitemapMaxURLs]
+	}
+
+	urlSet := sitemapURLSet{URLs: make([]sitemapURL, 0, len(ids))}
+	for _, id := range ids {
+		urlSet.URLs = append(urlSet.URLs, sitemapURL{Loc: movieDeepLink(id)})
+	}
+	nBuffer := bytes.Buffer{}
+	nBuffer.WriteString(xml.Header)
+	err = xml.NewEncoder(&nBuffer).Encode(urlSet)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, otelunprocessableErr)
+		return err
+	}
+
+	app.sitemap.current.Store(&sitemapSnapshot{
+		body:      nBuffer.Bytes(),
+		generated: time.Now().UTC().Truncate(time.Second),
+	})
+	logEvents(app.log).Debug("sitemap generated", logFields{"urls": len(ids)})
+	return nil
+}
+
+// runSitemapGenerator generates the sitemap on start, after the catalog changes and periodically.
+// It runs for the lifetime of the server.
+func (app *application) runSitemapGenerator() {
+	ticker := time.NewTicker(sitemapRefreshInterval)
+	defer ticker.Stop()
+	for {
+		err := app.generateSitemap(context.Background())
+		if err != nil {
+			logEvents(app.log).Error(err, "failed to generate the sitemap", nil)
+		}
+		select {
+		case <-app.sitemap.changed:
+			time.Sleep(sitemapDebounce)
+			// the changes made during the debounce are covered by this regeneration
+			select {
+			case <-app.sitemap.changed:
+			default:
+			}
+		case <-ticker.C:
+		}
+	}
+}
+
+func (app *application) sitemapHandler(w http.ResponseWriter, r *http.Request) {
+	snapshot := app.sitemap.current.Load()
+	if snapshot == nil {
+		app.sitemapNotReadyResponse(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(int(sitemapDebounce.Seconds())))
+	// ServeContent answers the If-Modified-Since requests of the crawlers by the generation time
+	http.ServeContent(w, r, "sitemap.xml", snapshot.generated, bytes.NewReader(snapshot.body))
+}
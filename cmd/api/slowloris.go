@@ -0,0 +1,196 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// MinUploadRate is the minimum rate in bytes per second the bodies of MinUploadRateRoutes must be sent at. zero disables it.
+	MinUploadRate int64
+	// MinUploadRateGrace is how long the body may be sent slower before MinUploadRate applies, e.g. while tcp ramps up
+	MinUploadRateGrace time.Duration
+	// MinUploadRateRoutes are the upload routes MinUploadRate applies to, like "POST /users/import". check bodyLimit for the format
+	MinUploadRateRoutes []string
+)
+
+// reasons of the malformed requests
+const (
+	malformedHeaderTimeout = "header_timeout"
+	malformedClosedEarly   = "closed_early"
+	malformedRequest       = "malformed"
+	malformedBodyTruncated = "body_truncated"
+	malformedSlowBody      = "slow_body"
+)
+
+var errSlowBody = errors.New("request body is sent slower than the minimum upload rate")
+
+// trackedListener wraps the connections of the public listener to count the requests which never reach the handlers
+type trackedListener struct {
+	net.Listener
+}
+
+func (l trackedListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &trackedConn{Conn: conn}, nil
+}
+
+// trackedConn remembers what happened to the current request of the connection. It's reset whenever a new request starts.
+type trackedConn struct {
+	net.Conn
+	// served is set once the request reaches the handlers
+	served atomic.Bool
+	// wrote is set once anything is written, which is the error response of the server before the request is served
+	wrote   atomic.Bool
+	timeout atomic.Bool
+}
+
+func (c *trackedConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		c.timeout.Store(true)
+	}
+	return n, err
+}
+
+func (c *trackedConn) Write(p []byte) (int, error) {
+	c.wrote.Store(true)
+	return c.Conn.Write(p)
+}
+
+type trackedConnKey struct{}
+
+// trackConnContext passes the connection to markServed
+func trackConnContext(ctx context.Context, conn net.Conn) context.Context {
+	if tc, ok := conn.(*trackedConn); ok {
+		return context.WithValue(ctx, trackedConnKey{}, tc)
+	}
+	return ctx
+}
+
+// markServed marks the request of the connection as served once it reaches the handlers
+func markServed(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if tc, ok := r.Context().Value(trackedConnKey{}).(*trackedConn); ok {
+			tc.served.Store(true)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// trackConnState counts the connections closed before their request is served. The idle keep-alive connections
+// closed between the requests aren't counted.
+func trackConnState(conn net.Conn, state http.ConnState) {
+	tc, ok := conn.(*trackedConn)
+	if !ok {
+		return
+	}
+	switch state {
+	case http.StateActive:
+		tc.served.Store(false)
+		tc.wrote.Store(false)
+		tc.timeout.Store(false)
+	case http.StateClosed:
+		if tc.served.Load() {
+			return
+		}
+		switch {
+		case tc.wrote.Load():
+			// the server has answered the request by 400 or 431 without serving it
+			recordMalformedRequest(context.Background(), malformedRequest)
+		case tc.timeout.Load():
+			recordMalformedRequest(context.Background(), malformedHeaderTimeout)
+		default:
+			recordMalformedRequest(context.Background(), malformedClosedEarly)
+		}
+	}
+}
+
+// uploadRoute reports whether MinUploadRate applies to the request
+func uploadRoute(r *http.Request) bool {
+	path := r.URL.Path
+	if version := apiVersionPrefix(path); version != 0 {
+		path = strings.TrimPrefix(path, fmt.Sprintf("/v%d", version))
+	}
+	for _, route := range MinUploadRateRoutes {
+		method, pattern, found := strings.Cut(route, " ")
+		if found && method == r.Method && matchRoute(pattern, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// guardBody counts the request bodies closed before they're complete and enforces MinUploadRate on the upload routes,
+// so a client trickling its upload can't hold a worker for --server-read-timeout. The read deadline of the connection
+// is moved along with the bytes received, which lets the uploads sent fast enough outlive --server-read-timeout.
+func (app *application) guardBody(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Body == nil || r.Body == http.NoBody {
+			next.ServeHTTP(w, r)
+			return
+		}
+		body := &guardedBody{ReadCloser: r.Body, ctx: r.Context(), start: time.Now()}
+		if MinUploadRate > 0 && uploadRoute(r) {
+			body.rate = MinUploadRate
+			body.rc = http.NewResponseController(w)
+		}
+		r.Body = body
+		next.ServeHTTP(w, r)
+	})
+}
+
+type guardedBody struct {
+	io.ReadCloser
+	ctx   context.Context
+	start time.Time
+	n     int64
+	// rate is zero when the minimum upload rate doesn't apply
+	rate     int64
+	rc       *http.ResponseController
+	reported bool
+}
+
+// due returns when the bytes read so far must have been received by
+func (b *guardedBody) due() time.Time {
+	return b.start.Add(MinUploadRateGrace + time.Duration(float64(b.n)/float64(b.rate)*float64(time.Second)))
+}
+
+func (b *guardedBody) Read(p []byte) (int, error) {
+	if b.rate > 0 && b.rc != nil {
+		// the connections which don't support the deadlines, like the http/2 streams, are checked after each read instead
+		if err := b.rc.SetReadDeadline(b.due()); errors.Is(err, http.ErrNotSupported) {
+			b.rc = nil
+		}
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	if b.rate > 0 && (errors.Is(err, os.ErrDeadlineExceeded) || (err == nil && time.Now().After(b.due().Add(time.Second)))) {
+		b.report(malformedSlowBody)
+		return n, errSlowBody
+	}
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		b.report(malformedBodyTruncated)
+	}
+	return n, err
+}
+
+func (b *guardedBody) report(reason string) {
+	if b.reported {
+		return
+	}
+	b.reported = true
+	recordMalformedRequest(b.ctx, reason)
+}
@@ -0,0 +1,82 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGuardBodyMinUploadRate(t *testing.T) {
+	defer func(rate int64, grace time.Duration, routes []string, exportMode string) {
+		MinUploadRate, MinUploadRateGrace, MinUploadRateRoutes, MetricsExportMode = rate, grace, routes, exportMode
+	}(MinUploadRate, MinUploadRateGrace, MinUploadRateRoutes, MetricsExportMode)
+	MinUploadRate, MinUploadRateGrace, MinUploadRateRoutes = 1000, 100*time.Millisecond, []string{"POST /users/import"}
+	// the otel instruments aren't set up in the tests
+	MetricsExportMode = MetricsExportPrometheus
+
+	app := &application{}
+	readErr := make(chan error, 1)
+	srv := httptest.NewServer(app.guardBody(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := io.ReadAll(r.Body)
+		readErr <- err
+	})))
+	defer srv.Close()
+
+	tests := []struct {
+		name string
+		path string
+		want error
+	}{
+		{name: "Upload route", path: "/v1/users/import", want: errSlowBody},
+		{name: "Other route", path: "/v1/movies"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+			require.NoError(t, err)
+			defer conn.Close()
+			// only a part of the declared body is sent within the grace period, the rest is trickled afterwards
+			fmt.Fprintf(conn, "POST %s HTTP/1.1\r\nHost: localhost\r\nContent-Length: 20\r\n\r\n0123456789", tc.path)
+			time.Sleep(300 * time.Millisecond)
+			fmt.Fprint(conn, "0123456789")
+
+			select {
+			case err := <-readErr:
+				assert.ErrorIs(t, err, tc.want)
+			case <-time.After(5 * time.Second):
+				t.Fatal("the body wasn't read")
+			}
+		})
+	}
+}
+
+func TestUploadRoute(t *testing.T) {
+	defer func(routes []string) { MinUploadRateRoutes = routes }(MinUploadRateRoutes)
+	MinUploadRateRoutes = []string{"POST /users/import", "PUT /movies/:id/poster"}
+
+	tests := []struct {
+		method string
+		path   string
+		want   bool
+	}{
+		{method: http.MethodPost, path: "/v1/users/import", want: true},
+		{method: http.MethodPut, path: "/v1/movies/7/poster", want: true},
+		{method: http.MethodGet, path: "/v1/users/import"},
+		{method: http.MethodPost, path: "/v1/users"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.method+" "+tc.path, func(t *testing.T) {
+			r := httptest.NewRequest(tc.method, tc.path, nil)
+			assert.Equal(t, tc.want, uploadRoute(r))
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package api
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/felixge/httpsnoop"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	serverSpanContextKey     = contextKey("server_span")
+	responseStatusContextKey = contextKey("response_status")
+)
+
+// responseStatus is the status code of the response once it's written
+type responseStatus struct {
+	code int
+}
+
+// withServerSpan keeps the span of the request created by otelhttp, which the user is attached to once it's authenticated
+// and the handler spans are started under. It records the status of the response for the handler spans as well.
+func withServerSpan(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status := &responseStatus{}
+		ctx := context.WithValue(r.Context(), serverSpanContextKey, trace.SpanFromContext(r.Context()))
+		ctx = context.WithValue(ctx, responseStatusContextKey, status)
+		w = httpsnoop.Wrap(w, httpsnoop.Hooks{
+			WriteHeader: func(writeHeader httpsnoop.WriteHeaderFunc) httpsnoop.WriteHeaderFunc {
+				return func(code int) {
+					if status.code == 0 {
+						status.code = code
+					}
+					writeHeader(code)
+				}
+			},
+			Write: func(write httpsnoop.WriteFunc) httpsnoop.WriteFunc {
+				return func(b []byte) (int, error) {
+					if status.code == 0 {
+						status.code = http.StatusOK
+					}
+					return write(b)
+				}
+			},
+		})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// serverSpanName names the server span of otelhttp by the route of the request, like "GET /v1/movies/:id"
+func serverSpanName(_ string, r *http.Request) string {
+	return routePattern(r)
+}
+
+// startSpan starts the span of the handler named like "deleteMovie.handler.span". The span is a child of the server
+// span whichever middleware spans are on the context of the request, so every handler sits at the same level of the
+// trace. It carries the route, and the status of the response once it's ended.
+func (app *application) startSpan(r *http.Request, name string) (context.Context, trace.Span) {
+	ctx := r.Context()
+	if server, ok := ctx.Value(serverSpanContextKey).(trace.Span); ok {
+		ctx = trace.ContextWithSpan(ctx, server)
+	}
+	ctx, span := otel.Tracer(name+".handler.tracer").Start(ctx, name+".handler.span",
+		trace.WithAttributes(attribute.String("http.route", routePath(r))),
+	)
+	status, _ := ctx.Value(responseStatusContextKey).(*responseStatus)
+	return ctx, handlerSpan{Span: span, status: status}
+}
+
+// startBackgroundSpan starts the span of the work done outside of the requests, named like "generateSitemap.span"
+func startBackgroundSpan(ctx context.Context, name string) (context.Context, trace.Span) {
+	return otel.Tracer(name+".tracer").Start(ctx, name+".span")
+}
+
+// handlerSpan sets the status of the response on the span of the handler when it's ended
+type handlerSpan struct {
+	trace.Span
+	status *responseStatus
+}
+
+func (s handlerSpan) End(options ...trace.SpanEndOption) {
+	if s.status != nil && s.status.code != 0 {
+		s.SetAttributes(attribute.Int("http.response.status_code", s.status.code))
+	}
+	s.Span.End(options...)
+}
@@ -0,0 +1,56 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestStartSpan(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+	defer func(exportMode string) { MetricsExportMode = exportMode }(MetricsExportMode)
+	MetricsExportMode = MetricsExportPrometheus
+
+	logger := zerolog.Nop()
+	app := &application{log: &logger}
+	app.runtime.Store(&runtimeConfig{})
+
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		// a middleware span left on the context of the request
+		ctx, middleware := otel.Tracer("test").Start(r.Context(), "middleware.span")
+		defer middleware.End()
+		_, span := app.startSpan(r.WithContext(ctx), "deleteMovie")
+		defer span.End()
+		w.WriteHeader(http.StatusNotFound)
+	}
+	router := chi.NewRouter()
+	router.MethodFunc(http.MethodDelete, "/v1/movies/{id}", app.otelHandler(http.HandlerFunc(handler)))
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodDelete, "/v1/movies/12", nil))
+	require.Equal(t, http.StatusNotFound, rr.Code)
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+	require.Contains(t, spans, "DELETE /v1/movies/:id")
+	require.Contains(t, spans, "deleteMovie.handler.span")
+	server, span := spans["DELETE /v1/movies/:id"], spans["deleteMovie.handler.span"]
+
+	assert.Equal(t, server.SpanContext().SpanID(), span.Parent().SpanID())
+	assert.Contains(t, span.Attributes(), attribute.String("http.route", "/v1/movies/:id"))
+	assert.Contains(t, span.Attributes(), attribute.Int("http.response.status_code", http.StatusNotFound))
+}
@@ -0,0 +1,75 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/cybrarymin/greenlight/internal/data"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// showStatsHandler aggregates the users, the issued tokens, the catalog growth and the served requests for the ops dashboard.
+// The request counts are read from the prometheus registry since the server has started, they're null when prometheus is disabled.
+func (app *application) showStatsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := app.startSpan(r, "showStats")
+	defer span.End()
+
+	nValidator := data.NewValidator()
+	qs := r.URL.Query()
+	days := app.readIntRange(qs, "days", 30, 1, 90, nValidator)
+	weeks := app.readIntRange(qs, "weeks", 12, 1, 52, nValidator)
+	top := app.readIntRange(qs, "top_genres", 10, 1, 50, nValidator)
+	if !nValidator.Valid() {
+		span.RecordError(errors.New(createKeyValuePairs(nValidator.Errors)))
+		span.SetStatus(codes.Error, otelunprocessableErr)
+		app.failedValidationResponse(w, r, nValidator)
+		return
+	}
+
+	users, err := app.models.Stats.Users(ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, otelDBErr)
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	tokens, err := app.models.Stats.TokensPerDay(ctx, days)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, otelDBErr)
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	movies, err := app.models.Stats.MoviesPerWeek(ctx, weeks)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, otelDBErr)
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	genres, err := app.models.Stats.TopGenres(ctx, top)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, otelDBErr)
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	requests, err := gatherRequestStats()
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to gather the request metrics")
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJson(w, r, http.StatusOK, envelope{"stats": map[string]interface{}{
+		"users":           users,
+		"tokens_per_day":  tokens,
+		"movies_per_week": movies,
+		"top_genres":      genres,
+		"requests":        requests,
+	}}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,157 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/cybrarymin/greenlight/internal/data"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect/pgdialect"
+)
+
+// stubQuery answers the queries matching the pattern by the rows, or by the number of the affected rows for the
+// statements which don't return any
+type stubQuery struct {
+	pattern  *regexp.Regexp
+	columns  []string
+	rows     [][]driver.Value
+	affected int64
+}
+
+// stubDB is a database answering the queries of the models by canned rows, so the handlers can be run past their
+// queries without a postgres. bun inlines the arguments so the patterns match the whole statements. The queries none
+// of the stubs match fail the test. The statements are logged along with the transactions they run in.
+type stubDB struct {
+	t          *testing.T
+	mu         sync.Mutex
+	queries    []stubQuery
+	statements []string
+}
+
+// newStubDB returns the stubDB answering the queries
+func newStubDB(t *testing.T, queries ...stubQuery) *stubDB {
+	return &stubDB{t: t, queries: queries}
+}
+
+// stubModels returns the models on top of a stubDB answering the queries
+func stubModels(t *testing.T, queries ...stubQuery) *data.Models {
+	return newStubDB(t, queries...).models()
+}
+
+// models returns the models on top of the stubDB
+func (db *stubDB) models() *data.Models {
+	return data.NewModels(bun.NewDB(sql.OpenDB(db), pgdialect.New()))
+}
+
+// log returns the statements run so far in their order, with BEGIN, COMMIT and ROLLBACK around the transactions
+func (db *stubDB) log() []string {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return append([]string(nil), db.statements...)
+}
+
+func (db *stubDB) record(statement string) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.statements = append(db.statements, statement)
+}
+
+// stub returns the stubQuery answering the queries matching the pattern by the rows of the columns
+func stub(pattern string, columns []string, rows ...[]driver.Value) stubQuery {
+	return stubQuery{pattern: regexp.MustCompile(pattern), columns: columns, rows: rows, affected: int64(len(rows))}
+}
+
+func (db *stubDB) answer(query string) (stubQuery, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.statements = append(db.statements, query)
+	for _, q := range db.queries {
+		if q.pattern.MatchString(query) {
+			return q, nil
+		}
+	}
+	db.t.Errorf("unexpected query: %s", query)
+	return stubQuery{}, errors.New("unexpected query")
+}
+
+func (db *stubDB) Connect(context.Context) (driver.Conn, error) { return stubConn{db}, nil }
+func (db *stubDB) Driver() driver.Driver                        { return nil }
+
+type stubConn struct {
+	db *stubDB
+}
+
+func (c stubConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("prepared statements aren't stubbed")
+}
+func (c stubConn) Close() error { return nil }
+
+func (c stubConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c stubConn) BeginTx(_ context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	begin := "BEGIN"
+	if opts.Isolation != driver.IsolationLevel(sql.LevelDefault) {
+		begin += " ISOLATION LEVEL " + strings.ToUpper(sql.IsolationLevel(opts.Isolation).String())
+	}
+	if opts.ReadOnly {
+		begin += " READ ONLY"
+	}
+	c.db.record(begin)
+	return stubTx{c.db}, nil
+}
+
+func (c stubConn) QueryContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Rows, error) {
+	q, err := c.db.answer(query)
+	if err != nil {
+		return nil, err
+	}
+	return &stubRows{columns: q.columns, rows: q.rows}, nil
+}
+
+func (c stubConn) ExecContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Result, error) {
+	q, err := c.db.answer(query)
+	if err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(q.affected), nil
+}
+
+type stubTx struct {
+	db *stubDB
+}
+
+func (tx stubTx) Commit() error {
+	tx.db.record("COMMIT")
+	return nil
+}
+
+func (tx stubTx) Rollback() error {
+	tx.db.record("ROLLBACK")
+	return nil
+}
+
+type stubRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *stubRows) Columns() []string { return r.columns }
+func (r *stubRows) Close() error      { return nil }
+
+func (r *stubRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
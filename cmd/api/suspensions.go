@@ -0,0 +1,142 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/cybrarymin/greenlight/internal/data"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// rejectSuspendedUser keeps the banned and the suspended users from using the api, whatever credential they've authenticated by
+func (app *application) rejectSuspendedUser(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := app.GetUserContext(r)
+		if !user.IsAnonymous() && user.Suspended() {
+			app.accountSuspendedResponse(w, r, user)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+}
+
+// suspendUserHandler bans the user or suspends it until the given time. The authentication tokens and the sessions of the user
+// are revoked, the jwts are rejected by rejectSuspendedUser until they expire.
+func (app *application) suspendUserHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := app.startSpan(r, "suspendUser")
+	defer span.End()
+
+	userID := app.uuidParam(r, "id")
+
+	var input struct {
+		Until  *time.Time `json:"until"`
+		Banned bool       `json:"banned"`
+		Reason string     `json:"reason"`
+	}
+	err := app.readJson(w, r, &input)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, otelunprocessableErr)
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	nValidator := data.NewValidator()
+	nValidator.Apply("reason", data.Required(input.Reason), data.MaxLen(input.Reason, 500))
+	if input.Banned {
+		nValidator.Check(input.Until == nil, "until", "must not be provided for a ban")
+	} else {
+		nValidator.CheckCode(input.Until != nil, "until", data.CodeRequired, "must be provided unless the user is banned")
+		nValidator.Check(input.Until == nil || input.Until.After(time.Now()), "until", "must be in the future")
+	}
+	nValidator.Check(userID != app.GetUserContext(r).ID, "id", "can't suspend yourself")
+	if !nValidator.Valid() {
+		span.RecordError(errors.New(createKeyValuePairs(nValidator.Errors)))
+		span.SetStatus(codes.Error, otelunprocessableErr)
+		app.failedValidationResponse(w, r, nValidator)
+		return
+	}
+
+	user, ok := app.updateSuspension(w, r, userID, func(user *data.User) {
+		user.Banned, user.SuspendedUntil, user.SuspensionReason = input.Banned, input.Until, input.Reason
+	})
+	if !ok {
+		return
+	}
+
+	err = app.models.Tokens.DeleteAllForUser(ctx, user.ID, data.AuthenticationScope)
+	if err != nil && !errors.Is(err, data.ErrorRecordNotFound) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, otelDBErr)
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.models.Sessions.DeleteAllForUser(ctx, user.ID)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, otelDBErr)
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	app.events(ctx).Info("user suspended", logFields{"user": user.Email, "banned": user.Banned, "reason": user.SuspensionReason})
+
+	err = app.writeJson(w, r, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// unsuspendUserHandler lifts the ban or the suspension of the user. The revoked tokens aren't restored.
+func (app *application) unsuspendUserHandler(w http.ResponseWriter, r *http.Request) {
+	_, span := app.startSpan(r, "unsuspendUser")
+	defer span.End()
+
+	userID := app.uuidParam(r, "id")
+
+	user, ok := app.updateSuspension(w, r, userID, func(user *data.User) {
+		user.Banned, user.SuspendedUntil, user.SuspensionReason = false, nil, ""
+	})
+	if !ok {
+		return
+	}
+	app.events(r.Context()).Info("user unsuspended", logFields{"user": user.Email})
+
+	err := app.writeJson(w, r, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateSuspension applies the change to the user, reading it again on an edit conflict. The error response is sent when it fails.
+func (app *application) updateSuspension(w http.ResponseWriter, r *http.Request, userID uuid.UUID, change func(user *data.User)) (*data.User, bool) {
+	ctx, span := app.startSpan(r, "updateSuspension")
+	defer span.End()
+
+	user := &data.User{}
+	err := data.RetryOnEditConflict(func() error {
+		err := app.models.Users.GetByID(userID, ctx, user)
+		if err != nil {
+			return err
+		}
+		change(user)
+		return app.models.Users.Update(userID, ctx, user)
+	})
+	if err != nil {
+		span.RecordError(err)
+		switch {
+		case errors.Is(err, data.ErrorRecordNotFound):
+			span.SetStatus(codes.Ok, otelDBNotFoundInfo)
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrEditConflict):
+			span.SetStatus(codes.Error, otelDBErr)
+			app.editConflictResponse(w, r)
+		default:
+			span.SetStatus(codes.Error, otelDBErr)
+			app.dbWriteErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+	return user, true
+}
@@ -14,7 +14,12 @@ import (
 // @host			127.0.0.1:8080
 // @basepath		/v1
 type SwaggerGetResponse struct {
-	Movie data.Movie `json:"Movie"`
+	Movie    data.Movie          `json:"Movie"`
+	Progress *data.WatchProgress `json:"Progress"`
+}
+
+type SwaggerRedirectResponse struct {
+	Location string `json:"location" example:"/v1/movies/2"`
 }
 
 type SwaggerCreateMovieInput struct {
@@ -33,38 +38,71 @@ type SwaggerDeleteResponse struct {
 }
 
 type SwaggerListResponse struct {
-	Metadata data.PaginationMeta
-	Movies   []data.Movie
+	Metadata data.PaginationMeta `json:"Metadata"`
+	Movies   []data.Movie        `json:"Movies"`
+	Facets   *data.MovieFacets   `json:"Facets,omitempty"`
+}
+
+type SwaggerAutocompleteResponse struct {
+	Movies []data.MovieSuggestion `json:"Movies"`
 }
 
 type SwaggerNotFound struct {
-	Error string `json:"error" example:"the requested resource couldn't be found"`
+	Error            string `json:"error" example:"the requested resource couldn't be found"`
+	Code             string `json:"code" example:"not_found"`
+	DocumentationURL string `json:"documentation_url" example:"https://github.com/cybrarymin/greenlight/blob/main/docs/errors.md#not_found"`
 }
 
 type SwaggerServerErrorResponse struct {
-	Error string `json:"error" example:"the server encountered an error to process the request"`
+	Error            string `json:"error" example:"the server encountered an error to process the request"`
+	Code             string `json:"code" example:"server_error"`
+	DocumentationURL string `json:"documentation_url" example:"https://github.com/cybrarymin/greenlight/blob/main/docs/errors.md#server_error"`
 }
 
 type SwaggerBadRequestResponse struct {
-	Error string `json:"error" example:"bad request error"`
+	Error            string `json:"error" example:"bad request error"`
+	Code             string `json:"code" example:"bad_request"`
+	DocumentationURL string `json:"documentation_url" example:"https://github.com/cybrarymin/greenlight/blob/main/docs/errors.md#bad_request"`
 }
 
 type SwaggerFailedValidationResponse struct {
-	Error string `json:"error" example:"unprocessable input error"`
+	// Error maps the invalid fields to their error messages
+	Error            map[string]string `json:"error" example:"title:must be provided"`
+	Code             string            `json:"code" example:"failed_validation"`
+	DocumentationURL string            `json:"documentation_url" example:"https://github.com/cybrarymin/greenlight/blob/main/docs/errors.md#failed_validation"`
 }
 
 type SwaggerEditConflictResponse struct {
-	Error string `json:"error" example:"unable to update the record due to an edit conflict, please try again"`
+	Error            string `json:"error" example:"unable to update the record due to an edit conflict, please try again"`
+	Code             string `json:"code" example:"edit_conflict"`
+	DocumentationURL string `json:"documentation_url" example:"https://github.com/cybrarymin/greenlight/blob/main/docs/errors.md#edit_conflict"`
 }
 
 type SwaggerRateLimitExceedResponse struct {
-	Error string `json:"error" example:"request rate limit reached, please try again later"`
+	Error            string `json:"error" example:"request rate limit reached, please try again later"`
+	Code             string `json:"code" example:"rate_limited"`
+	DocumentationURL string `json:"documentation_url" example:"https://github.com/cybrarymin/greenlight/blob/main/docs/errors.md#rate_limited"`
 }
 
 type SwaggerUnauthorizaed struct {
-	Error string `json:"error" example:"unauthorized request"`
+	Error            string           `json:"error" example:"unauthorized request"`
+	Code             string           `json:"code" example:"authentication_required"`
+	DocumentationURL string           `json:"documentation_url" example:"https://github.com/cybrarymin/greenlight/blob/main/docs/errors.md#authentication_required"`
+	Links            SwaggerAuthLinks `json:"_links"`
+}
+
+type SwaggerAuthLinks struct {
+	Register SwaggerLink `json:"register"`
+	Token    SwaggerLink `json:"token"`
+}
+
+type SwaggerLink struct {
+	Href   string `json:"href" example:"/v1/users"`
+	Method string `json:"method" example:"POST"`
 }
 
 type SwaggerNotPermitted struct {
-	Error string `json:"error" example:"permission denied"`
+	Error            string `json:"error" example:"permission denied"`
+	Code             string `json:"code" example:"not_permitted"`
+	DocumentationURL string `json:"documentation_url" example:"https://github.com/cybrarymin/greenlight/blob/main/docs/errors.md#not_permitted"`
 }
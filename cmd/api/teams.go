@@ -0,0 +1,322 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/cybrarymin/greenlight/internal/data"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// maxTeamMembersPerRequest bounds the users added to a team at once
+const maxTeamMembersPerRequest = 1000
+
+// readTeamPermissions looks the permission codes up, the unknown ones are reported to the validator
+func (app *application) readTeamPermissions(ctx context.Context, v *data.Validator, codes []string) (*data.Permissions, error) {
+	perms := &data.Permissions{}
+	if len(codes) == 0 {
+		return perms, nil
+	}
+	perms, err := app.models.Permissions.GetPermID(ctx, codes)
+	if err != nil {
+		return nil, err
+	}
+	for _, code := range codes {
+		v.Check(perms.IncludesPrem(code), "permissions", fmt.Sprintf("%s isn't a permission", code))
+	}
+	return perms, nil
+}
+
+// createTeamHandler creates a team granting its permissions to the users added to it. check addTeamMembersHandler
+func (app *application) createTeamHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := app.startSpan(r, "createTeam")
+	defer span.End()
+
+	var input struct {
+		Name        string   `json:"name"`
+		Permissions []string `json:"permissions"`
+	}
+	err := app.readJson(w, r, &input)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, otelunprocessableErr)
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	team := &data.Team{Name: input.Name, Permissions: input.Permissions}
+	if team.Permissions == nil {
+		team.Permissions = []string{}
+	}
+	nValidator := data.NewValidator()
+	data.ValidateTeam(nValidator, team)
+	perms, err := app.readTeamPermissions(ctx, nValidator, team.Permissions)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, otelDBErr)
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !nValidator.Valid() {
+		span.RecordError(errors.New(createKeyValuePairs(nValidator.Errors)))
+		span.SetStatus(codes.Error, otelunprocessableErr)
+		app.failedValidationResponse(w, r, nValidator)
+		return
+	}
+
+	err = app.models.Teams.Insert(ctx, team, perms)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, otelDBErr)
+		app.dbWriteErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/admin/teams/%d", team.ID))
+	err = app.writeJson(w, r, http.StatusCreated, envelope{"Team": team}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listTeamsHandler lists the teams by their names along with their permissions and their number of members
+func (app *application) listTeamsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := app.startSpan(r, "listTeams")
+	defer span.End()
+
+	teams, err := app.models.Teams.List(ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, otelDBErr)
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJson(w, r, http.StatusOK, envelope{"Teams": teams}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showTeamHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := app.startSpan(r, "showTeam")
+	defer span.End()
+
+	team, err := app.models.Teams.Get(ctx, app.idParam(r, "id"))
+	if err != nil {
+		span.RecordError(err)
+		switch {
+		case errors.Is(err, data.ErrorRecordNotFound):
+			span.SetStatus(codes.Ok, otelDBNotFoundInfo)
+			app.notFoundResponse(w, r)
+		default:
+			span.SetStatus(codes.Error, otelDBErr)
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJson(w, r, http.StatusOK, envelope{"Team": team}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateTeamPermissionsHandler replaces the permissions of the team. Its members gain and lose them right away.
+func (app *application) updateTeamPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := app.startSpan(r, "updateTeamPermissions")
+	defer span.End()
+
+	id := app.idParam(r, "id")
+	var input struct {
+		Permissions []string `json:"permissions"`
+	}
+	err := app.readJson(w, r, &input)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, otelunprocessableErr)
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	nValidator := data.NewValidator()
+	nValidator.Check(input.Permissions != nil, "permissions", "must be provided")
+	nValidator.Apply("permissions", data.UniqueItems(input.Permissions))
+	perms, err := app.readTeamPermissions(ctx, nValidator, input.Permissions)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, otelDBErr)
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !nValidator.Valid() {
+		span.RecordError(errors.New(createKeyValuePairs(nValidator.Errors)))
+		span.SetStatus(codes.Error, otelunprocessableErr)
+		app.failedValidationResponse(w, r, nValidator)
+		return
+	}
+
+	err = app.models.Teams.SetPermissions(ctx, id, perms)
+	if err != nil {
+		span.RecordError(err)
+		switch {
+		case errors.Is(err, data.ErrorRecordNotFound):
+			span.SetStatus(codes.Ok, otelDBNotFoundInfo)
+			app.notFoundResponse(w, r)
+		default:
+			span.SetStatus(codes.Error, otelDBErr)
+			app.dbWriteErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	team, err := app.models.Teams.Get(ctx, id)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, otelDBErr)
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJson(w, r, http.StatusOK, envelope{"Team": team}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteTeamHandler removes the team, its members keep their own permissions only
+func (app *application) deleteTeamHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := app.startSpan(r, "deleteTeam")
+	defer span.End()
+
+	err := app.models.Teams.Delete(ctx, app.idParam(r, "id"))
+	if err != nil {
+		span.RecordError(err)
+		switch {
+		case errors.Is(err, data.ErrorRecordNotFound):
+			span.SetStatus(codes.Ok, otelDBNotFoundInfo)
+			app.notFoundResponse(w, r)
+		default:
+			span.SetStatus(codes.Error, otelDBErr)
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJson(w, r, http.StatusOK, envelope{"message": "team deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listTeamMembersHandler lists the users of the team, the latest added first
+func (app *application) listTeamMembersHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := app.startSpan(r, "listTeamMembers")
+	defer span.End()
+
+	id := app.idParam(r, "id")
+	// an unknown team is told apart from a team without members
+	_, err := app.models.Teams.Get(ctx, id)
+	if err != nil {
+		span.RecordError(err)
+		switch {
+		case errors.Is(err, data.ErrorRecordNotFound):
+			span.SetStatus(codes.Ok, otelDBNotFoundInfo)
+			app.notFoundResponse(w, r)
+		default:
+			span.SetStatus(codes.Error, otelDBErr)
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	members, err := app.models.Teams.ListMembers(ctx, id)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, otelDBErr)
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJson(w, r, http.StatusOK, envelope{"Members": members}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// addTeamMembersHandler adds the users to the team at once. The users already in the team are left as they are.
+func (app *application) addTeamMembersHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := app.startSpan(r, "addTeamMembers")
+	defer span.End()
+
+	id := app.idParam(r, "id")
+	var input struct {
+		UserIDs []uuid.UUID `json:"user_ids"`
+	}
+	err := app.readJson(w, r, &input)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, otelunprocessableErr)
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	nValidator := data.NewValidator()
+	nValidator.Apply("user_ids", data.MinItems(1, len(input.UserIDs)), data.MaxItems(maxTeamMembersPerRequest, len(input.UserIDs)))
+	if !nValidator.Valid() {
+		span.RecordError(errors.New(createKeyValuePairs(nValidator.Errors)))
+		span.SetStatus(codes.Error, otelunprocessableErr)
+		app.failedValidationResponse(w, r, nValidator)
+		return
+	}
+
+	// the unknown teams and users fail the foreign keys of the members
+	added, err := app.models.Teams.AddMembers(ctx, id, input.UserIDs)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, otelDBErr)
+		app.dbWriteErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJson(w, r, http.StatusOK, envelope{"added": added}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// removeTeamMemberHandler removes the user from the team along with the permissions they've inherited from it
+func (app *application) removeTeamMemberHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := app.startSpan(r, "removeTeamMember")
+	defer span.End()
+
+	id, err := app.pathParams(r).Int64("id")
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, otelunprocessableErr)
+		app.invalidParamResponse(w, r, err)
+		return
+	}
+	userID, err := app.pathParams(r).UUID("user_id")
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, otelunprocessableErr)
+		app.invalidParamResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Teams.RemoveMember(ctx, id, userID)
+	if err != nil {
+		span.RecordError(err)
+		switch {
+		case errors.Is(err, data.ErrorRecordNotFound):
+			span.SetStatus(codes.Ok, otelDBNotFoundInfo)
+			app.notFoundResponse(w, r)
+		default:
+			span.SetStatus(codes.Error, otelDBErr)
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJson(w, r, http.StatusOK, envelope{"message": "member removed"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,108 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cybrarymin/greenlight/internal/data"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect/pgdialect"
+)
+
+func TestCreateTeam(t *testing.T) {
+	now := time.Now()
+	permissions := stub(`^SELECT .* FROM "permissions" AS "permission" WHERE \(code IN \('movies:read', 'movies:write'\)\)`,
+		[]string{"id", "code"}, []driver.Value{int64(1), "movies:read"}, []driver.Value{int64(2), "movies:write"})
+	insert := []stubQuery{
+		stub(`^INSERT INTO "teams" \("name"\) VALUES \('editors'\) RETURNING id, created_at`, []string{"id", "created_at"}, []driver.Value{int64(3), now}),
+		stub(`^INSERT INTO "team_permissions" \("team_id", "permission_id"\) VALUES \(3, 1\), \(3, 2\)`, nil),
+	}
+
+	tests := []struct {
+		name    string
+		body    string
+		queries []stubQuery
+		status  int
+	}{
+		{name: "With permissions", body: `{"name":"editors","permissions":["movies:read","movies:write"]}`, queries: append([]stubQuery{permissions}, insert...), status: http.StatusCreated},
+		{name: "Without permissions", body: `{"name":"editors"}`, queries: insert[:1], status: http.StatusCreated},
+		{name: "Unknown permission", body: `{"name":"editors","permissions":["movies:read","movies:delete"]}`, queries: []stubQuery{
+			stub(`^SELECT .* FROM "permissions"`, []string{"id", "code"}, []driver.Value{int64(1), "movies:read"}),
+		}, status: http.StatusUnprocessableEntity},
+		{name: "Duplicate permissions", body: `{"name":"editors","permissions":["movies:read","movies:read"]}`, queries: []stubQuery{
+			stub(`^SELECT .* FROM "permissions"`, []string{"id", "code"}, []driver.Value{int64(1), "movies:read"}),
+		}, status: http.StatusUnprocessableEntity},
+		{name: "Missing name", body: `{"permissions":[]}`, status: http.StatusUnprocessableEntity},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			logger := zerolog.Nop()
+			app := &application{log: &logger, models: stubModels(t, tc.queries...)}
+			r := httptest.NewRequest(http.MethodPost, "/v1/admin/teams", strings.NewReader(tc.body))
+			rec := httptest.NewRecorder()
+			app.createTeamHandler(rec, r)
+			assert.Equal(t, tc.status, rec.Code, rec.Body.String())
+			if tc.status == http.StatusCreated {
+				assert.Equal(t, "/v1/admin/teams/3", rec.Header().Get("Location"))
+			}
+		})
+	}
+}
+
+func TestTeamPermissionsCache(t *testing.T) {
+	userID := uuid.New()
+	perms := func(codes ...string) stubQuery {
+		rows := make([][]driver.Value, 0, len(codes))
+		for i, code := range codes {
+			rows = append(rows, []driver.Value{int64(i + 1), code})
+		}
+		return stub(`FROM "permissions" AS "permission" WHERE \(id IN \(SELECT permission_id FROM user_effective_permissions WHERE user_id = '`+userID.String()+`'\)\)`,
+			[]string{"id", "code"}, rows...)
+	}
+	db := &stubDB{t: t, queries: []stubQuery{
+		perms("movies:read"),
+		stub(`^INSERT INTO "team_members"`, nil, []driver.Value{}),
+		stub(`^SELECT "team"."id" FROM "teams" AS "team" WHERE \(id = 3\) FOR UPDATE`, []string{"id"}, []driver.Value{int64(3)}),
+		stub(`^DELETE FROM "team_permissions" AS "team_permission" WHERE \(team_id = 3\)`, nil),
+	}}
+	models := data.NewModels(bun.NewDB(sql.OpenDB(db), pgdialect.New()))
+	// grant changes the permissions stored for the user, the cached ones are served until the teams drop them
+	grant := func(codes ...string) {
+		db.mu.Lock()
+		defer db.mu.Unlock()
+		db.queries[0] = perms(codes...)
+	}
+	codes := func() []string {
+		p, err := models.Permissions.GetAllPermsForUser(context.Background(), userID)
+		require.NoError(t, err)
+		var codes []string
+		for _, perm := range *p {
+			codes = append(codes, perm.Code)
+		}
+		return codes
+	}
+
+	assert.Equal(t, []string{"movies:read"}, codes())
+	grant("movies:read", "movies:write")
+	assert.Equal(t, []string{"movies:read"}, codes(), "served from the cache")
+
+	_, err := models.Teams.AddMembers(context.Background(), 3, []uuid.UUID{userID})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"movies:read", "movies:write"}, codes(), "dropped by the new membership")
+
+	grant("movies:read")
+	err = models.Teams.SetPermissions(context.Background(), 3, &data.Permissions{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"movies:read"}, codes(), "dropped by the permissions of the team")
+}
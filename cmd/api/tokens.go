@@ -3,29 +3,25 @@ package api
 import (
 	"errors"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/cybrarymin/greenlight/internal/data"
-	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/codes"
 )
 
 func (app *application) userActivationHandler(w http.ResponseWriter, r *http.Request) {
-	ctx, span := otel.Tracer("userActivation.handler.tracer").Start(r.Context(), "userActivation.handler.span")
+	ctx, span := app.startSpan(r, "userActivation")
 	defer span.End()
 
-	userID, err := app.readUUIDParam(r)
-	if err != nil {
-		span.RecordError(err)
-		span.SetStatus(codes.Error, otelUserActivationFailureErr)
-		app.failedValidationResponse(w, r, map[string]string{"uuid": "invalid uuid"})
-		return
-	}
+	userID := app.uuidParam(r, "id")
 	var input struct {
 		UserToken string `json:"token"`
+		// Password is required for the invited users whose account has been created without their password
+		Password *string `json:"password"`
 	}
 
-	err = app.readJson(w, r, &input)
+	err := app.readJson(w, r, &input)
 	if err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, otelunprocessableErr)
@@ -34,70 +30,60 @@ func (app *application) userActivationHandler(w http.ResponseWriter, r *http.Req
 	}
 
 	nVal := data.NewValidator()
-	if data.ValidateTokenPlaintext(nVal, input.UserToken); !nVal.Valid() {
+	data.ValidateTokenPlaintext(nVal, input.UserToken)
+	if input.Password != nil {
+		data.ValidatePasswordPlaintext(nVal, *input.Password)
+	}
+	if !nVal.Valid() {
 		span.RecordError(errors.New(createKeyValuePairs(nVal.Errors)))
 		span.SetStatus(codes.Error, otelunprocessableErr)
-		app.failedValidationResponse(w, r, nVal.Errors)
+		app.failedValidationResponse(w, r, nVal)
 		return
 	}
 
-	nTokens, err := app.models.Tokens.GetTokensOfUserID(ctx, userID, data.ActivationScope)
-	if err != nil {
-		span.RecordError(err)
-		switch {
-		case errors.Is(err, data.ErrorRecordNotFound):
-			span.SetStatus(codes.Ok, otelDBNotFoundInfo)
-			app.notFoundResponse(w, r)
-			return
-		default:
-			span.SetStatus(codes.Ok, otelDBErr)
-			app.serverErrorResponse(w, r, err)
-			return
-		}
-	}
-	matchedToken, ok := nTokens.Match(input.UserToken)
-	if time.Now().After(matchedToken.Expiry) {
-		span.RecordError(errors.New("token expired"))
+	// the failed attempts are counted per account so the tokens can't be guessed by spreading the attempts over many clients
+	attemptKey := userID.String()
+	if !app.activationAttempts.allow(attemptKey, ActivationMaxAttempts, time.Now()) {
 		span.SetStatus(codes.Error, otelUserActivationFailureErr)
-		app.invalidActivationTokenResponse(w, r)
+		w.Header().Set("Retry-After", strconv.Itoa(int(ActivationAttemptWindow.Seconds())))
+		app.rateLimitExceedResponse(w, r)
 		return
 	}
 
-	if !ok {
-		span.SetStatus(codes.Error, otelUserActivationFailureErr)
-		app.invalidActivationTokenResponse(w, r)
-		return
+	var password *data.Password
+	if input.Password != nil {
+		password = &data.Password{}
+		err = password.Set(*input.Password)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "error on new password setup")
+			app.serverErrorResponse(w, r, err)
+			return
+		}
 	}
 
-	matchedToken.User.Activated = true
-	err = app.models.Users.Update(userID, ctx, matchedToken.User)
+	_, err = app.models.Users.Activate(ctx, userID, input.UserToken, password)
 	if err != nil {
 		span.RecordError(err)
 		switch {
+		case errors.Is(err, data.ErrorInvalidToken):
+			span.SetStatus(codes.Error, otelUserActivationFailureErr)
+			app.activationAttempts.fail(attemptKey, ActivationAttemptWindow, time.Now())
+			app.invalidActivationTokenResponse(w, r)
+			return
 		case errors.Is(err, data.ErrorRecordNotFound):
 			span.SetStatus(codes.Ok, otelDBNotFoundInfo)
 			app.notFoundResponse(w, r)
 			return
-		case errors.Is(err, data.ErrEditConflict):
-			span.SetStatus(codes.Error, otelDBErr)
-			app.editConflictResponse(w, r)
-			return
 		default:
 			span.SetStatus(codes.Error, otelDBErr)
-			app.serverErrorResponse(w, r, err)
+			app.dbWriteErrorResponse(w, r, err)
 			return
 		}
 	}
+	app.activationAttempts.reset(attemptKey)
 
-	err = app.models.Tokens.DeleteAllForUser(ctx, userID, data.ActivationScope)
-	if err != nil {
-		span.RecordError(err)
-		span.SetStatus(codes.Error, otelDBErr)
-		app.serverErrorResponse(w, r, err)
-		return
-	}
-
-	err = app.writeJson(w, http.StatusOK, envelope{"result": "user activated"}, nil)
+	err = app.writeJson(w, r, http.StatusOK, envelope{"result": "user activated"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
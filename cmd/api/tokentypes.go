@@ -0,0 +1,351 @@
+package api
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/cybrarymin/greenlight/internal/data"
+	"github.com/cybrarymin/greenlight/internal/paseto"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// types of the tokens issued on /v1/tokens
+const (
+	// TokenOpaque is the random bearer token stored in the database. It can be revoked on its own.
+	TokenOpaque = "opaque"
+	// TokenJWT is the HS256 jwt signed by --jwt-key
+	TokenJWT = "jwt"
+	// TokenPASETO is the v4.public paseto signed by --paseto-key
+	TokenPASETO = "paseto"
+)
+
+var (
+	// TokenTypes are the types of the tokens the server issues. The first one is issued when the type isn't requested.
+	TokenTypes []string
+	// PASETOKey is the hex encoded Ed25519 seed signing the paseto tokens
+	PASETOKey string
+	// JWTIssuer and JWTAudience are set on the issued jwts and required from the jwts being verified
+	JWTIssuer   string
+	JWTAudience string
+	// JWTLeeway is the clock skew allowed when the times of the jwts are verified
+	JWTLeeway time.Duration
+)
+
+const (
+	opaqueTokenTTL = time.Hour * 24
+	jwtTokenTTL    = time.Hour * 24 * 3
+	pasetoTokenTTL = time.Hour * 24 * 3
+	// pasetoIssuer is the issuer and the audience of the paseto tokens
+	pasetoIssuer = "greenlight.example.com"
+)
+
+// issuedToken is the token issued on /v1/tokens
+type issuedToken struct {
+	Type   string    `json:"type"`
+	Token  string    `json:"token"`
+	Expiry time.Time `json:"expiry"`
+	Scope  []string  `json:"scope,omitempty"`
+	// DeviceID is the device holding the token. check listDevicesHandler
+	DeviceID int64 `json:"device_id,omitempty"`
+
+	// hash of the opaque tokens and the token id of the stateless ones binding them to their device
+	hash    []byte
+	tokenID string
+}
+
+// pasetoClaims are the claims of the paseto tokens. The subject is the id of the user.
+type pasetoClaims struct {
+	Email string `json:"email"`
+	// Scope narrows the token to the space separated permissions. check tokenScope
+	Scope string `json:"scope,omitempty"`
+	paseto.Claims
+}
+
+// jwtParserOptions are the checks every jwt verified by the server goes through on top of its signature
+func jwtParserOptions() []jwt.ParserOption {
+	return []jwt.ParserOption{
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithIssuer(JWTIssuer),
+		jwt.WithAudience(JWTAudience),
+		jwt.WithLeeway(JWTLeeway),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	}
+}
+
+// pasetoPrivateKey returns the key signing the paseto tokens. The key is validated on startup.
+func pasetoPrivateKey() (ed25519.PrivateKey, error) {
+	secretsMu.RLock()
+	seed, err := hex.DecodeString(PASETOKey)
+	secretsMu.RUnlock()
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, errors.New("paseto key must be a hex encoded 32 bytes seed")
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// validateTokenType checks the requested type against the types the server issues
+func validateTokenType(v *data.Validator, tokenType string) {
+	v.Check(slices.Contains(TokenTypes, tokenType), "type", "must be one of "+strings.Join(TokenTypes, ", "))
+}
+
+// validateTokenScope checks the requested scope is a subset of the permissions of the user. Only the self contained
+// tokens carry a scope, the opaque tokens always have all the permissions of their user.
+func validateTokenScope(v *data.Validator, tokenType string, scope []string, perms *data.Permissions) {
+	if len(scope) == 0 {
+		return
+	}
+	v.Check(tokenType != TokenOpaque, "scope", "isn't supported by the "+TokenOpaque+" tokens")
+	for _, code := range scope {
+		if !perms.IncludesPrem(code) {
+			v.AddError("scope", "must be a subset of the permissions of the user")
+			return
+		}
+	}
+}
+
+// tokenScope returns the permissions of the space separated scope claim, nil for the tokens without a scope
+func tokenScope(claim string) []string {
+	scope := strings.Fields(claim)
+	if len(scope) == 0 {
+		return nil
+	}
+	return scope
+}
+
+// issueToken issues a token of the type to the user and records the login along with the device holding the token.
+// The jwt and paseto tokens are narrowed to scope when it's not empty.
+func (app *application) issueToken(ctx context.Context, r *http.Request, user *data.User, tokenType string, scope []string, deviceName string) (*issuedToken, error) {
+	var token *issuedToken
+	var loginType string
+	var err error
+	switch tokenType {
+	case TokenOpaque:
+		token, err = app.issueOpaqueToken(ctx, user)
+		loginType = data.LoginTokenBearer
+	case TokenJWT:
+		token, err = issueJWTToken(user, scope)
+		loginType = data.LoginTokenJWT
+	case TokenPASETO:
+		token, err = issuePASETOToken(user, scope)
+		loginType = data.LoginTokenPASETO
+	default:
+		err = errors.New("unknown token type " + tokenType)
+	}
+	if err != nil {
+		return nil, err
+	}
+	app.recordLogin(ctx, r, user, loginType)
+
+	device := &data.Device{
+		UserID:     user.ID,
+		Name:       deviceName,
+		TokenType:  token.Type,
+		TokenHash:  token.hash,
+		TokenID:    token.tokenID,
+		RemoteAddr: clientIP(r),
+		UserAgent:  r.UserAgent(),
+		Expiry:     token.Expiry,
+	}
+	err = app.models.Devices.Insert(ctx, device)
+	if err != nil {
+		return nil, err
+	}
+	token.DeviceID = device.ID
+	return token, nil
+}
+
+func (app *application) issueOpaqueToken(ctx context.Context, user *data.User) (*issuedToken, error) {
+	nBToken, err := app.models.Tokens.New(ctx, opaqueTokenTTL, user.ID, data.AuthenticationScope)
+	if err != nil {
+		return nil, err
+	}
+	return &issuedToken{Type: TokenOpaque, Token: nBToken.PlainText, Expiry: nBToken.Expiry, hash: nBToken.Hash}, nil
+}
+
+func issueJWTToken(user *data.User, scope []string) (*issuedToken, error) {
+	now := time.Now()
+	claims := customClaims{
+		Email: user.Email,
+		Scope: strings.Join(scope, " "),
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    JWTIssuer,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(jwtTokenTTL)),
+			Subject:   user.Email,
+			Audience:  []string{JWTAudience},
+			NotBefore: jwt.NewNumericDate(now),
+			ID:        uuid.New().String(),
+		},
+	}
+	signedToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(jwtKey())
+	if err != nil {
+		return nil, err
+	}
+	return &issuedToken{Type: TokenJWT, Token: signedToken, Expiry: claims.ExpiresAt.Time, Scope: scope, tokenID: claims.ID}, nil
+}
+
+func issuePASETOToken(user *data.User, scope []string) (*issuedToken, error) {
+	key, err := pasetoPrivateKey()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	claims := pasetoClaims{
+		Email: user.Email,
+		Scope: strings.Join(scope, " "),
+		Claims: paseto.Claims{
+			Issuer:     pasetoIssuer,
+			Subject:    user.ID.String(),
+			Audience:   pasetoIssuer,
+			Expiration: now.Add(pasetoTokenTTL),
+			NotBefore:  now,
+			IssuedAt:   now,
+			TokenID:    uuid.New().String(),
+		},
+	}
+	signedToken, err := paseto.Sign(key, claims, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &issuedToken{Type: TokenPASETO, Token: signedToken, Expiry: claims.Expiration, Scope: scope, tokenID: claims.TokenID}, nil
+}
+
+// pasetoUser returns the user of the paseto token along with the scope of the token.
+// It returns ErrorRecordNotFound when the token isn't valid.
+func (app *application) pasetoUser(ctx context.Context, token string) (*data.User, []string, error) {
+	key, err := pasetoPrivateKey()
+	if err != nil {
+		return nil, nil, data.ErrorRecordNotFound
+	}
+	claims := pasetoClaims{}
+	err = paseto.Verify(key.Public().(ed25519.PublicKey), token, nil, &claims)
+	if err != nil || claims.Valid(time.Now()) != nil || claims.Issuer != pasetoIssuer || claims.Audience != pasetoIssuer {
+		return nil, nil, data.ErrorRecordNotFound
+	}
+	userID, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return nil, nil, data.ErrorRecordNotFound
+	}
+	err = app.deviceNotRevoked(ctx, claims.TokenID)
+	if err != nil {
+		return nil, nil, err
+	}
+	user := &data.User{}
+	err = app.models.Users.GetByID(userID, ctx, user)
+	if err != nil {
+		return nil, nil, err
+	}
+	return user, tokenScope(claims.Scope), nil
+}
+
+// deviceNotRevoked returns ErrorRecordNotFound when the device of the stateless token has been signed out
+func (app *application) deviceNotRevoked(ctx context.Context, tokenID string) error {
+	if tokenID == "" {
+		return nil
+	}
+	revoked, err := app.models.Devices.Revoked(ctx, tokenID)
+	if err != nil {
+		return err
+	}
+	if revoked {
+		return data.ErrorRecordNotFound
+	}
+	return nil
+}
+
+// errInvalidJWT wraps the errors of the jwts failing the verification
+var errInvalidJWT = errors.New("invalid jwt")
+
+// jwtUser verifies the jwt and returns its user along with the scope of the token. The errors of the verification
+// are wrapped in errInvalidJWT so they can be responded by invalidJWTResponse.
+func (app *application) jwtUser(ctx context.Context, token string) (*data.User, []string, error) {
+	verifiedToken, err := jwt.ParseWithClaims(token, &customClaims{}, func(t *jwt.Token) (interface{}, error) {
+		return jwtKey(), nil
+	}, jwtParserOptions()...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", errInvalidJWT, err)
+	}
+	claims := verifiedToken.Claims.(*customClaims)
+	err = app.deviceNotRevoked(ctx, claims.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	user, err := app.models.Users.GetByEmail(claims.Email, ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	return user, tokenScope(claims.Scope), nil
+}
+
+// createTokenHandler issues a token of the requested type to the user authenticated by basic auth. The types the server
+// issues are set by --token-types, the first of them is issued when no type is requested.
+func (app *application) createTokenHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := app.startSpan(r, "createToken")
+	defer span.End()
+
+	ok, nUser := app.BasicAuth(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Type string `json:"type"`
+		// Scope narrows the token to a subset of the permissions of the user, e.g. for the automations
+		Scope []string `json:"scope"`
+		// DeviceName names the device holding the token, e.g. "CI runner"
+		DeviceName string `json:"device_name"`
+	}
+	// the body is optional
+	if r.ContentLength != 0 {
+		err := app.readJson(w, r, &input)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, otelunprocessableErr)
+			app.badRequestResponse(w, r, err)
+			return
+		}
+	}
+	if input.Type == "" && len(TokenTypes) > 0 {
+		input.Type = TokenTypes[0]
+	}
+	nVal := data.NewValidator()
+	validateTokenType(nVal, input.Type)
+	data.ValidateDeviceName(nVal, input.DeviceName)
+	if len(input.Scope) > 0 {
+		perms, err := app.models.Permissions.GetAllPermsForUser(ctx, nUser.ID)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, otelDBErr)
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		validateTokenScope(nVal, input.Type, input.Scope, perms)
+	}
+	if !nVal.Valid() {
+		span.SetStatus(codes.Error, otelunprocessableErr)
+		app.failedValidationResponse(w, r, nVal)
+		return
+	}
+
+	token, err := app.issueToken(ctx, r, nUser, input.Type, input.Scope, input.DeviceName)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "token issuance failed")
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJson(w, r, http.StatusCreated, envelope{"result": token}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
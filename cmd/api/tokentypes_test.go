@@ -0,0 +1,117 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cybrarymin/greenlight/internal/data"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuthRejectedJWTClaims(t *testing.T) {
+	defer func(key, issuer, audience string, leeway time.Duration) {
+		JWTKEY, JWTIssuer, JWTAudience, JWTLeeway = key, issuer, audience, leeway
+	}(JWTKEY, JWTIssuer, JWTAudience, JWTLeeway)
+	JWTKEY, JWTIssuer, JWTAudience, JWTLeeway = "secret", "greenlight", "greenlight-api", time.Minute
+
+	app := &application{}
+	now := time.Now()
+	tests := []struct {
+		name   string
+		claims jwt.RegisteredClaims
+		key    string
+		code   errorCode
+	}{
+		{name: "Invalid signature", claims: jwt.RegisteredClaims{Issuer: "greenlight", Audience: []string{"greenlight-api"}, ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour))}, key: "other", code: codeInvalidTokenSignature},
+		{name: "Expired", claims: jwt.RegisteredClaims{Issuer: "greenlight", Audience: []string{"greenlight-api"}, ExpiresAt: jwt.NewNumericDate(now.Add(-2 * time.Minute))}, code: codeTokenExpired},
+		{name: "Not valid yet", claims: jwt.RegisteredClaims{Issuer: "greenlight", Audience: []string{"greenlight-api"}, ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)), NotBefore: jwt.NewNumericDate(now.Add(2 * time.Minute))}, code: codeTokenNotValidYet},
+		{name: "Other issuer", claims: jwt.RegisteredClaims{Issuer: "other", Audience: []string{"greenlight-api"}, ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour))}, code: codeInvalidTokenIssuer},
+		{name: "Other audience", claims: jwt.RegisteredClaims{Issuer: "greenlight", Audience: []string{"other"}, ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour))}, code: codeInvalidTokenAudience},
+		{name: "Missing expiry", claims: jwt.RegisteredClaims{Issuer: "greenlight", Audience: []string{"greenlight-api"}}, code: codeInvalidCredentials},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			key := JWTKEY
+			if tc.key != "" {
+				key = tc.key
+			}
+			token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, customClaims{Email: "john@example.com", RegisteredClaims: tc.claims}).SignedString([]byte(key))
+			require.NoError(t, err)
+
+			r := httptest.NewRequest(http.MethodGet, "/v1/healthcheck", nil)
+			r.Header.Set("Authorization", "Bearer "+token)
+			rec := httptest.NewRecorder()
+			app.Auth(func(w http.ResponseWriter, r *http.Request) {
+				t.Fatal("the token shouldn't be accepted")
+			})(rec, r)
+
+			var body struct {
+				Code errorCode `json:"code"`
+			}
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+			assert.Equal(t, http.StatusUnauthorized, rec.Code)
+			assert.Equal(t, tc.code, body.Code)
+		})
+	}
+}
+
+func TestValidateTokenScope(t *testing.T) {
+	perms := &data.Permissions{{Code: "movies:read"}, {Code: "movies:write"}, {Code: "admin"}}
+	tests := []struct {
+		name      string
+		tokenType string
+		scope     []string
+		valid     bool
+	}{
+		{name: "Unscoped", tokenType: TokenOpaque, valid: true},
+		{name: "Subset of the permissions", tokenType: TokenJWT, scope: []string{"movies:read"}, valid: true},
+		{name: "All the permissions", tokenType: TokenPASETO, scope: []string{"movies:read", "movies:write", "admin"}, valid: true},
+		{name: "Permission the user lacks", tokenType: TokenJWT, scope: []string{"movies:read", "users:impersonate"}, valid: false},
+		{name: "Scoped opaque token", tokenType: TokenOpaque, scope: []string{"movies:read"}, valid: false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			v := data.NewValidator()
+			validateTokenScope(v, tc.tokenType, tc.scope, perms)
+			assert.Equal(t, tc.valid, v.Valid())
+		})
+	}
+}
+
+func TestTokenScope(t *testing.T) {
+	assert.Nil(t, tokenScope(""))
+	assert.Nil(t, tokenScope("  "))
+	assert.Equal(t, []string{"movies:read", "movies:write"}, tokenScope("movies:read movies:write"))
+}
+
+func TestIssuedTokenDeviceBinding(t *testing.T) {
+	defer func(key, pasetoKey string) {
+		JWTKEY, PASETOKey = key, pasetoKey
+	}(JWTKEY, PASETOKey)
+	JWTKEY, PASETOKey = "secret", strings.Repeat("00", 32)
+	user := &data.User{ID: uuid.New(), Email: "john@example.com"}
+
+	jwtToken, err := issueJWTToken(user, nil)
+	require.NoError(t, err)
+	claims := &customClaims{}
+	_, _, err = jwt.NewParser().ParseUnverified(jwtToken.Token, claims)
+	require.NoError(t, err)
+	assert.NotEmpty(t, jwtToken.tokenID)
+	assert.Equal(t, claims.ID, jwtToken.tokenID)
+	assert.Nil(t, jwtToken.hash)
+
+	pasetoToken, err := issuePASETOToken(user, nil)
+	require.NoError(t, err)
+	assert.NotEmpty(t, pasetoToken.tokenID)
+	assert.NotEqual(t, jwtToken.tokenID, pasetoToken.tokenID)
+	assert.Nil(t, pasetoToken.hash)
+}
@@ -0,0 +1,183 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cybrarymin/greenlight/internal/data"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// MonthlyRequestQuota is the maximum number of requests a user can send in a calendar month. quota is disabled when it's 0.
+var MonthlyRequestQuota int64
+
+// usagePeriod returns the first and the last day of the day and the month containing t in UTC.
+func usagePeriod(t time.Time) (day time.Time, monthStart time.Time, monthEnd time.Time) {
+	t = t.UTC()
+	day = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	monthStart = time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	monthEnd = monthStart.AddDate(0, 1, -1)
+	return day, monthStart, monthEnd
+}
+
+// routePattern returns the method and the route of the request with the path parameter values replaced by their names,
+// so all the requests to the same endpoint are counted together.
+func routePattern(r *http.Request) string {
+	return r.Method + " " + routePath(r)
+}
+
+// routePath returns the path of the route serving the request, like /v1/movies/:id. It's the path of the request
+// when it hasn't been routed.
+func routePath(r *http.Request) string {
+	rctx := chi.RouteContext(r.Context())
+	if rctx == nil || rctx.RoutePattern() == "" {
+		return r.URL.Path
+	}
+	segments := strings.Split(rctx.RoutePattern(), "/")
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			segments[i] = ":" + strings.Trim(segment, "{}")
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// usageQuota counts the requests of the authenticated users per endpoint and day, and rejects them once the monthly quota is used up.
+// The request is counted against the quota before it's served, so the concurrent requests can't go past the quota together.
+// Anonymous requests are neither counted nor limited.
+func (app *application) usageQuota(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := app.startSpan(r, "usageQuota")
+		defer span.End()
+
+		user := app.GetUserContext(r)
+		if user.IsAnonymous() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		now := time.Now()
+		day, monthStart, monthEnd := usagePeriod(now)
+		if MonthlyRequestQuota > 0 {
+			used, err := app.models.Usage.Consume(ctx, user.ID, monthStart)
+			if err != nil {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, otelDBErr)
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+			w.Header().Set("X-Quota-Limit", strconv.FormatInt(MonthlyRequestQuota, 10))
+			w.Header().Set("X-Quota-Remaining", strconv.FormatInt(max(MonthlyRequestQuota-used, 0), 10))
+			if used > MonthlyRequestQuota {
+				span.SetStatus(codes.Error, "monthly request quota exceeded")
+				nextMonth := monthEnd.AddDate(0, 0, 1)
+				app.quotaExceededResponse(w, r, nextMonth.Sub(now))
+				return
+			}
+		}
+
+		err := app.models.Usage.Increment(ctx, user.ID, routePattern(r), day)
+		if err != nil {
+			// failing to count a request shouldn't fail the request itself
+			span.RecordError(err)
+			app.events(ctx).Error(err, "failed to record the request usage", nil)
+		}
+
+		r = r.WithContext(ctx)
+		next.ServeHTTP(w, r)
+	}
+}
+
+// userIDParam resolves the id path parameter which is either "me" or the id of a user. Another user
+// requires admin permission. It reports false when the error response has already been sent.
+func (app *application) userIDParam(ctx context.Context, w http.ResponseWriter, r *http.Request, span trace.Span) (uuid.UUID, bool) {
+	user := app.GetUserContext(r)
+	if app.pathParams(r).ByName("id") == "me" {
+		return user.ID, true
+	}
+	id, err := app.readUUIDParam(r)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, otelunprocessableErr)
+		app.invalidParamResponse(w, r, err)
+		return uuid.Nil, false
+	}
+	if id != user.ID {
+		perms, err := app.models.Permissions.GetAllPermsForUser(ctx, user.ID)
+		if err != nil && !errors.Is(err, data.ErrorRecordNotFound) {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, otelDBErr)
+			app.serverErrorResponse(w, r, err)
+			return uuid.Nil, false
+		}
+		if perms == nil || !perms.IncludesPrem("admin") || !scopeAllows(ctx, "admin") {
+			app.notPermittedResponse(w, r)
+			return uuid.Nil, false
+		}
+	}
+	return id, true
+}
+
+// showUsageHandler shows the request usage of the current day and month. id is either "me" or the id of another user which requires admin permission.
+func (app *application) showUsageHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := app.startSpan(r, "showUsage")
+	defer span.End()
+
+	userID, ok := app.userIDParam(ctx, w, r, span)
+	if !ok {
+		return
+	}
+
+	day, monthStart, monthEnd := usagePeriod(time.Now())
+	dayEndpoints, err := app.models.Usage.PerEndpoint(ctx, userID, day, day)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, otelDBErr)
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	monthEndpoints, err := app.models.Usage.PerEndpoint(ctx, userID, monthStart, monthEnd)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, otelDBErr)
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	month := newUsageSummary(monthStart.Format("2006-01"), monthEndpoints)
+	usage := envelope{
+		"day":   newUsageSummary(day.Format(time.DateOnly), dayEndpoints),
+		"month": month,
+	}
+	if MonthlyRequestQuota > 0 {
+		usage["quota"] = map[string]int64{
+			"monthly_limit": MonthlyRequestQuota,
+			"remaining":     max(MonthlyRequestQuota-month.Total, 0),
+		}
+	}
+	err = app.writeJson(w, r, http.StatusOK, envelope{"Usage": usage}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+type usageSummary struct {
+	Period    string               `json:"period"`
+	Total     int64                `json:"total"`
+	Endpoints []data.EndpointUsage `json:"endpoints"`
+}
+
+func newUsageSummary(period string, endpoints []data.EndpointUsage) usageSummary {
+	summary := usageSummary{Period: period, Endpoints: endpoints}
+	for _, e := range endpoints {
+		summary.Total += e.Count
+	}
+	return summary
+}
@@ -0,0 +1,74 @@
+package api
+
+import (
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cybrarymin/greenlight/internal/data"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUsagePeriod(t *testing.T) {
+	day, monthStart, monthEnd := usagePeriod(time.Date(2024, time.February, 10, 23, 30, 0, 0, time.FixedZone("UTC-2", -2*60*60)))
+	assert.Equal(t, time.Date(2024, time.February, 11, 0, 0, 0, 0, time.UTC), day, "the day is the one in UTC")
+	assert.Equal(t, time.Date(2024, time.February, 1, 0, 0, 0, 0, time.UTC), monthStart)
+	assert.Equal(t, time.Date(2024, time.February, 29, 0, 0, 0, 0, time.UTC), monthEnd)
+}
+
+func TestUsageQuota(t *testing.T) {
+	defer func(quota int64) { MonthlyRequestQuota = quota }(MonthlyRequestQuota)
+	userID := uuid.New()
+	_, monthStart, _ := usagePeriod(time.Now())
+	// consume stubs the count of the requests of the month including the current one
+	consume := func(used int64) stubQuery {
+		return stub(`^INSERT INTO "request_quota" AS "request_quota" \("user_id", "month", "count"\) VALUES \('`+userID.String()+`', '`+monthStart.Format("2006-01-02")+
+			`[^']*', 1\) ON CONFLICT \(user_id, month\) DO UPDATE SET count = request_quota.count \+ 1 RETURNING count`, []string{"count"}, []driver.Value{used})
+	}
+	increment := stub(`^INSERT INTO "request_usage" AS "request_usage" \("user_id", "day", "endpoint", "count"\) VALUES \('`+userID.String()+`', .*, 'GET /v1/movies', 1\)`, nil)
+
+	tests := []struct {
+		name      string
+		quota     int64
+		anonymous bool
+		queries   []stubQuery
+		served    bool
+		remaining string
+	}{
+		{name: "Without quota", queries: []stubQuery{increment}, served: true},
+		{name: "Within the quota", quota: 10, queries: []stubQuery{consume(4), increment}, served: true, remaining: "6"},
+		{name: "Last request of the quota", quota: 10, queries: []stubQuery{consume(10), increment}, served: true, remaining: "0"},
+		// the request isn't counted per endpoint once it's rejected
+		{name: "Quota used up", quota: 10, queries: []stubQuery{consume(11)}, remaining: "0"},
+		{name: "Anonymous", quota: 10, anonymous: true, served: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			MonthlyRequestQuota = tc.quota
+			logger := zerolog.Nop()
+			app := &application{log: &logger, models: stubModels(t, tc.queries...)}
+			r := httptest.NewRequest(http.MethodGet, "/v1/movies", nil)
+			if tc.anonymous {
+				r = app.SetUserContext(r, data.AnonymousUser)
+			} else {
+				r = app.SetUserContext(r, &data.User{ID: userID})
+			}
+			rec := httptest.NewRecorder()
+			served := false
+			app.usageQuota(func(w http.ResponseWriter, r *http.Request) { served = true })(rec, r)
+
+			require.Equal(t, tc.served, served)
+			assert.Equal(t, tc.remaining, rec.Header().Get("X-Quota-Remaining"))
+			if !tc.served {
+				assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+				assert.NotEmpty(t, rec.Header().Get("Retry-After"))
+			}
+		})
+	}
+}
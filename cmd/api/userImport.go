@@ -0,0 +1,228 @@
+package api
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cybrarymin/greenlight/internal/data"
+	mailer "github.com/cybrarymin/greenlight/internal/mailter"
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+	"go.opentelemetry.io/otel/codes"
+)
+
+const (
+	userImportMaxRows = 1000
+	invitationTTL     = 7 * 24 * time.Hour
+)
+
+// userInvitationMailData is the data of user_invitation.tpl mail template
+type userInvitationMailData struct {
+	Name string
+	ID   string
+	Code string
+}
+
+type userImportResult struct {
+	Row    int               `json:"row"`
+	Email  string            `json:"email"`
+	Status string            `json:"status"`
+	UserID string            `json:"user_id,omitempty"`
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// importUsersHandler creates deactivated accounts from a csv with name and email columns and emails them an invitation with the activation code.
+// The csv is validated right away and the accounts are created by a job, the response only returns the job to follow.
+// Rows are processed independently, so the job reports the errors of each row instead of failing the whole import.
+func (app *application) importUsersHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := app.startSpan(r, "importUsers")
+	defer span.End()
+
+	// the csv may be larger than the other bodies. check MaxBodySizeRoutes
+	reader := csv.NewReader(r.Body)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, otelunprocessableErr)
+		app.badRequestResponse(w, r, fmt.Errorf("body contains invalid csv: %w", err))
+		return
+	}
+
+	nValidator := data.NewValidator()
+	nValidator.Check(len(records) >= 2, "csv", "must contain the header row and at least one user")
+	nValidator.Check(len(records) <= userImportMaxRows+1, "csv", fmt.Sprintf("must not contain more than %d users", userImportMaxRows))
+	nameCol, emailCol := -1, -1
+	if len(records) > 0 {
+		for i, column := range records[0] {
+			switch strings.ToLower(strings.TrimSpace(column)) {
+			case "name":
+				nameCol = i
+			case "email":
+				emailCol = i
+			}
+		}
+	}
+	nValidator.Check(nameCol != -1 && emailCol != -1, "csv", "header row must contain name and email columns")
+	if !nValidator.Valid() {
+		span.RecordError(errors.New(createKeyValuePairs(nValidator.Errors)))
+		span.SetStatus(codes.Error, otelunprocessableErr)
+		app.failedValidationResponse(w, r, nValidator)
+		return
+	}
+
+	results := make([]userImportResult, 0, len(records)-1)
+	users := []*data.User{}
+	// userRows holds the index of the result of each user
+	userRows := []int{}
+	for i, record := range records[1:] {
+		result := userImportResult{Row: i + 2, Status: "failed"}
+		if nameCol >= len(record) || emailCol >= len(record) {
+			result.Errors = map[string]string{"csv": "row doesn't have the name and email columns"}
+			results = append(results, result)
+			continue
+		}
+		result.Email = strings.TrimSpace(record[emailCol])
+
+		nUser, err := newImportedUser(strings.TrimSpace(record[nameCol]), result.Email, &result)
+		if err != nil {
+			span.RecordError(err)
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if nUser != nil {
+			users = append(users, nUser)
+			userRows = append(userRows, len(results))
+		}
+		results = append(results, result)
+	}
+
+	user := app.GetUserContext(r)
+	job, err := app.startJob(ctx, data.JobUserImport, user.ID, len(results), func(ctx context.Context, progress *jobProgress) (interface{}, error) {
+		return app.importUsers(ctx, progress, results, users, userRows)
+	})
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, otelDBErr)
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	app.jobAcceptedResponse(w, r, job)
+}
+
+// importUsers creates the users of the valid rows and emails them the invitations. It runs as the job of the import,
+// the rows failing the validation are processed first and the rest once their users are committed. The result of the
+// job reports each row.
+func (app *application) importUsers(ctx context.Context, progress *jobProgress, results []userImportResult, users []*data.User, userRows []int) (interface{}, error) {
+	// the rows failing the validation are over already
+	rowErrors := []data.JobItemError{}
+	for _, result := range results {
+		if result.Errors != nil {
+			rowErrors = append(rowErrors, result.itemError())
+		}
+	}
+	progress.report(len(rowErrors), len(rowErrors), rowErrors)
+
+	// the users, their permissions and their invitation tokens are created together, so a failing import leaves
+	// no user behind which can't be activated
+	createdUsers := []*data.User{}
+	invitations := []mailer.MailMessage{}
+	err := app.models.RunInTx(ctx, func(ctx context.Context, tx bun.Tx) error {
+		// the users whose email already exists are skipped and keep the nil id
+		_, err := app.models.Users.InsertManyTx(ctx, tx, users, data.BulkInsertOptions{OnConflict: data.ConflictSkip})
+		if err != nil {
+			return err
+		}
+		createdIDs := []uuid.UUID{}
+		for _, nUser := range users {
+			if nUser.ID == uuid.Nil {
+				continue
+			}
+			createdUsers = append(createdUsers, nUser)
+			createdIDs = append(createdIDs, nUser.ID)
+		}
+		err = app.models.Permissions.AddPermForUsersTx(ctx, tx, createdIDs, "movies:read")
+		if err != nil {
+			return err
+		}
+		for _, nUser := range createdUsers {
+			nToken, err := app.models.Tokens.NewTx(ctx, tx, invitationTTL, nUser.ID, data.ActivationScope)
+			if err != nil {
+				return err
+			}
+			invitations = append(invitations, mailer.MailMessage{
+				To:           []string{nUser.Email},
+				TemplateFile: "user_invitation.tpl",
+				Data: userInvitationMailData{
+					Name: nUser.Name,
+					ID:   nUser.ID.String(),
+					Code: nToken.PlainText,
+				},
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	rowErrors = []data.JobItemError{}
+	for i, nUser := range users {
+		result := &results[userRows[i]]
+		if nUser.ID == uuid.Nil {
+			result.Errors = map[string]string{"email": "user with current email already exists"}
+			rowErrors = append(rowErrors, result.itemError())
+			continue
+		}
+		result.Status = "created"
+		result.UserID = nUser.ID.String()
+	}
+	created := len(createdUsers)
+	progress.report(len(rowErrors)+created, len(rowErrors), rowErrors)
+
+	errs := app.mailer.SendBatch(ctx, invitations)
+	for i, err := range errs {
+		if err != nil {
+			app.events(ctx).Error(err, fmt.Sprintf("failed to send the invitation email to user %v", invitations[i].To[0]), nil)
+		}
+	}
+
+	summary := map[string]interface{}{
+		"created": created,
+		"failed":  len(results) - created,
+		"rows":    results,
+	}
+	return summary, nil
+}
+
+func (result userImportResult) itemError() data.JobItemError {
+	return data.JobItemError{Item: result.Row, Key: result.Email, Errors: result.Errors}
+}
+
+// newImportedUser builds the user of a csv row. Problems of the row are reported in the result and no user is returned,
+// the returned error is only for the server failures.
+func newImportedUser(name, email string, result *userImportResult) (*data.User, error) {
+	nUser := &data.User{
+		Name:      name,
+		Email:     email,
+		Activated: false,
+	}
+	// the user chooses the password on activation
+	err := nUser.Password.Set(randomPassword())
+	if err != nil {
+		return nil, err
+	}
+	nValidator := data.NewValidator()
+	data.ValidateUser(nValidator, nUser)
+	if !nValidator.Valid() {
+		result.Errors = nValidator.Errors
+		return nil, nil
+	}
+	return nUser, nil
+}
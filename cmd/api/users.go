@@ -7,12 +7,17 @@ import (
 	"time"
 
 	"github.com/cybrarymin/greenlight/internal/data"
-	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/codes"
 )
 
+// userWelcomeMailData is the data of user_welcome.tpl mail template
+type userWelcomeMailData struct {
+	ID   string
+	Code string
+}
+
 func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Request) {
-	ctx, span := otel.Tracer("registerUser.handler.tracer").Start(r.Context(), "registerUser.handler.span")
+	ctx, span := app.startSpan(r, "registerUser")
 	span.End()
 
 	nVal := data.NewValidator()
@@ -21,6 +26,8 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		Name     string `json:"name"`
 		Password string `json:"password"`
 		Email    string `json:"email"`
+		// Invitation is the invitation token required in invite registration mode
+		Invitation string `json:"invitation"`
 	}
 
 	err := app.readJson(w, r, &nInput)
@@ -31,6 +38,11 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	if !app.checkRegistrationMode(w, r, nInput.Email, nInput.Invitation) {
+		span.SetStatus(codes.Error, "registration isn't allowed")
+		return
+	}
+
 	nUser := data.User{
 		Name:      nInput.Name,
 		Email:     nInput.Email,
@@ -52,69 +64,36 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 	}
 
 	data.ValidateUser(nVal, &nUser)
+	emailDomains := app.runtimeCfg().EmailDomains
+	data.ValidateEmailDomain(nVal, nUser.Email, &emailDomains)
 	valid := nVal.Valid()
 	if !valid {
 		span.RecordError(errors.New(createKeyValuePairs(nVal.Errors)))
 		span.SetStatus(codes.Error, otelunprocessableErr)
-		app.failedValidationResponse(w, r, nVal.Errors)
+		app.failedValidationResponse(w, r, nVal)
 		return
 	}
 
-	err = app.models.Users.Insert(ctx, &nUser)
+	// the welcome mail is sent by the outbox dispatcher once the user is committed. check sendUserWelcome
+	err = app.models.Users.Register(ctx, &nUser, "movies:read")
 	if err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, otelDBErr)
 		switch {
 		case errors.Is(err, data.ErrorDuplicateEmail):
 			nVal.AddError("email", "user with current email already exists")
-			app.failedValidationResponse(w, r, nVal.Errors)
+			app.failedValidationResponse(w, r, nVal)
 			return
 		default:
-			app.serverErrorResponse(w, r, err)
+			app.dbWriteErrorResponse(w, r, err)
 			return
 		}
 	}
-
-	err = app.models.Permissions.AddPermForUser(ctx, nUser.ID, "movies:read")
-	if err != nil {
-		span.RecordError(err)
-		span.SetStatus(codes.Error, otelDBErr)
-		app.serverErrorResponse(w, r, err)
-		return
-	}
-
-	app.BackgroundJob(func() {
-
-		nToken, err := app.models.Tokens.New(ctx, time.Hour*72, nUser.ID, data.ActivationScope)
-		if err != nil {
-			span.RecordError(err)
-			span.SetStatus(codes.Error, otelDBErr)
-			app.log.Error().Err(err).Msg(fmt.Sprintf("token creation procedure failed for user %v", nUser.Email))
-			return
-		}
-
-		mailData := struct {
-			ID   string
-			Code string
-		}{
-			ID:   nUser.ID.String(),
-			Code: nToken.PlainText,
-		}
-		// retrying email sending if it failed
-		for i := 0; i < 3; i++ {
-			err = app.mailer.Send(nUser.Email, "user_welcome.tpl", mailData)
-			if err == nil {
-				return
-			} else {
-				app.log.Error().Err(err).Msg(fmt.Sprintf("failed to send email to user %v", nUser.Email))
-				time.Sleep(500 * time.Millisecond)
-			}
-		}
-	}, "panic happened during sending email to user for activation")
+	app.counters.registrations.Add(1)
 
 	headers := make(http.Header)
 	headers.Set("Location", fmt.Sprintf("/v1/users/%d", nUser.ID))
-	err = app.writeJson(w, http.StatusAccepted, envelope{"result": nUser}, headers)
+	err = app.writeJson(w, r, http.StatusAccepted, envelope{"result": nUser}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -123,31 +102,34 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 }
 
 func (app *application) ListUserHandler(w http.ResponseWriter, r *http.Request) {
-	ctx, span := otel.Tracer("listUser.handler.tracer").Start(r.Context(), "listUser.handler.span")
+	ctx, span := app.startSpan(r, "listUser")
 	defer span.End()
 	nValidator := data.NewValidator()
 	var input struct {
-		Name  string
-		Email string
+		Name         string
+		Email        string
+		UpdatedSince time.Time
 		data.Filters
 	}
 	qs := r.URL.Query()
 	input.Filters.Page = app.readInt(qs, "page", 1, nValidator)
 	input.Filters.PageSize = app.readInt(qs, "page_size", 100, nValidator)
 	input.Filters.Sort = app.readString(qs, "sort", "id")
-	input.Filters.SortSafeList = []string{"id", "created_at", "name", "email", "-id", "-created_at", "-name", "-email"}
+	input.Filters.Count = app.readString(qs, "count", data.CountExact)
+	input.Filters.SortSafeList = []string{"id", "created_at", "updated_at", "name", "email", "-id", "-created_at", "-updated_at", "-name", "-email"}
 	input.Name = app.readString(qs, "name", "")
 	input.Email = app.readString(qs, "email", "")
+	input.UpdatedSince = app.readTime(qs, "updated_since", nValidator)
 	input.Filters.ValidateFilters(nValidator)
 	if !nValidator.Valid() {
 		span.RecordError(errors.New(createKeyValuePairs(nValidator.Errors)))
 		span.SetStatus(codes.Error, otelunprocessableErr)
-		app.failedValidationResponse(w, r, nValidator.Errors)
+		app.failedValidationResponse(w, r, nValidator)
 		return
 	}
 
 	userList := &data.Users{}
-	count, err := app.models.Users.List(ctx, userList, input.Name, input.Email, &input.Filters)
+	count, err := app.models.Users.List(ctx, userList, input.Name, input.Email, input.UpdatedSince, &input.Filters)
 	if err != nil {
 		span.RecordError(err)
 		switch {
@@ -160,7 +142,7 @@ func (app *application) ListUserHandler(w http.ResponseWriter, r *http.Request)
 		}
 	}
 	pMeta := input.Filters.PaginationMetaData(ctx, count)
-	err = app.writeJson(w, http.StatusOK, envelope{"Metadata": pMeta, "Result": userList}, nil)
+	err = app.writeJson(w, r, http.StatusOK, envelope{"Metadata": pMeta, "Result": userList}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -168,16 +150,10 @@ func (app *application) ListUserHandler(w http.ResponseWriter, r *http.Request)
 }
 
 func (app *application) DeleteUserHandler(w http.ResponseWriter, r *http.Request) {
-	ctx, span := otel.Tracer("deleteUser.handler.tracer").Start(r.Context(), "deleteUser.handler.span")
+	ctx, span := app.startSpan(r, "deleteUser")
 	defer span.End()
-	uuid, err := app.readUUIDParam(r)
-	if err != nil {
-		span.RecordError(err)
-		span.SetStatus(codes.Error, otelunprocessableErr)
-		app.badRequestResponse(w, r, err)
-		return
-	}
-	err = app.models.Users.Delete(ctx, uuid)
+	uuid := app.uuidParam(r, "id")
+	err := app.models.Users.Delete(ctx, uuid)
 	if err != nil {
 		span.RecordError(err)
 		switch {
@@ -191,7 +167,7 @@ func (app *application) DeleteUserHandler(w http.ResponseWriter, r *http.Request
 			return
 		}
 	}
-	err = app.writeJson(w, http.StatusOK, envelope{"result": "user deleted successfully"}, nil)
+	err = app.writeJson(w, r, http.StatusOK, envelope{"result": "user deleted successfully"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -0,0 +1,173 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// versions of the api. handlers registered through versionedRouter are served under the prefix of every enabled version.
+// v1 is frozen, the changes of the responses are only applied from v2 onwards.
+const (
+	apiV1 = 1
+	apiV2 = 2
+)
+
+// APIV2Enabled serves the routes under the /v2 prefix with the v2 response envelope. check v2Envelope
+var APIV2Enabled bool
+
+// latestEnabledVersion is the highest version the routes are served by
+func latestEnabledVersion() int {
+	if APIV2Enabled {
+		return apiV2
+	}
+	return apiV1
+}
+
+const apiVersionContextKey = contextKey("apiVersion")
+
+func (app *application) SetAPIVersionContext(r *http.Request, version int) *http.Request {
+	ctx := context.WithValue(r.Context(), apiVersionContextKey, version)
+	return r.WithContext(ctx)
+}
+
+// GetAPIVersionContext returns v1 for the requests of the unversioned routes
+func (app *application) GetAPIVersionContext(r *http.Request) int {
+	version, ok := r.Context().Value(apiVersionContextKey).(int)
+	if !ok {
+		return apiV1
+	}
+	return version
+}
+
+// versionedRouter registers a handler under the prefixes of the enabled versions. The handler finds out the version it's
+// serving by GetAPIVersionContext.
+type versionedRouter struct {
+	app    *application
+	router chi.Router
+}
+
+func (vr versionedRouter) HandlerFunc(method, path string, handler http.HandlerFunc) {
+	for version := apiV1; version <= latestEnabledVersion(); version++ {
+		vr.router.MethodFunc(method, chiPattern(fmt.Sprintf("/v%d%s", version, path)), vr.app.withAPIVersion(version, handler))
+	}
+}
+
+func (app *application) withAPIVersion(version int, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, app.SetAPIVersionContext(r, version))
+	}
+}
+
+// apiVersionPrefix returns the version in the /vN/ prefix of the path, 0 if the path isn't versioned
+func apiVersionPrefix(path string) int {
+	if !strings.HasPrefix(path, "/v") {
+		return 0
+	}
+	prefix, _, found := strings.Cut(path[2:], "/")
+	if !found {
+		return 0
+	}
+	version, err := strconv.Atoi(prefix)
+	if err != nil || version < apiV1 || version > latestEnabledVersion() {
+		return 0
+	}
+	return version
+}
+
+// negotiateAPIVersion serves the request by the version asked in the version parameter of the Accept media type,
+// like "application/json; version=2", so the clients can switch versions without changing their urls.
+// The requests without the parameter are served by the version of their path.
+func (app *application) negotiateAPIVersion(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pathVersion := apiVersionPrefix(r.URL.Path)
+		if pathVersion == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Accept")
+
+		accepted, ok := acceptedAPIVersion(r.Header.Get("Accept"))
+		if !ok {
+			app.notAcceptableVersionResponse(w, r)
+			return
+		}
+		if accepted != 0 && accepted != pathVersion {
+			// the route is looked up by the path so the prefix is replaced by the negotiated version
+			r.URL.Path = fmt.Sprintf("/v%d%s", accepted, strings.TrimPrefix(r.URL.Path, fmt.Sprintf("/v%d", pathVersion)))
+			r.URL.RawPath = ""
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// acceptedAPIVersion returns the version parameter of the first json media type of the Accept header.
+// It's 0 when there's no version parameter and not ok when the version isn't supported.
+func acceptedAPIVersion(accept string) (int, bool) {
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil || (mediaType != "application/json" && mediaType != "*/*") {
+			continue
+		}
+		value, found := params["version"]
+		if !found {
+			return 0, true
+		}
+		version, err := strconv.Atoi(strings.TrimPrefix(value, "v"))
+		if err != nil || version < apiV1 || version > latestEnabledVersion() {
+			return 0, false
+		}
+		return version, true
+	}
+	return 0, true
+}
+
+// v2Envelope reshapes the envelope of a handler to the v2 response contract. Errors are kept under "error" along with their code,
+// the pagination metadata goes to "metadata" and the rest goes to "data". A single payload key is unwrapped so
+// data is the resource itself, while several keys are kept as an object with snake case keys.
+func (e envelope) v2Envelope() envelope {
+	ne := envelope{}
+	payload := make(map[string]interface{}, len(e))
+	for key, value := range e {
+		switch key {
+		case "error", "code", "documentation_url", "_links":
+			ne[key] = value
+		case "Metadata":
+			ne["metadata"] = value
+		default:
+			payload[toSnakeCase(key)] = value
+		}
+	}
+	switch len(payload) {
+	case 0:
+	case 1:
+		for _, value := range payload {
+			ne["data"] = value
+		}
+	default:
+		ne["data"] = payload
+	}
+	return ne
+}
+
+func toSnakeCase(s string) string {
+	runes := []rune(s)
+	var b strings.Builder
+	for i, c := range runes {
+		if unicode.IsUpper(c) {
+			// an upper case letter starts a new word unless it continues an acronym like the URL of ShortLinkURL
+			if i > 0 && (unicode.IsLower(runes[i-1]) || (i+1 < len(runes) && unicode.IsLower(runes[i+1]) && unicode.IsUpper(runes[i-1]))) {
+				b.WriteByte('_')
+			}
+			c = unicode.ToLower(c)
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
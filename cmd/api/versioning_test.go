@@ -0,0 +1,99 @@
+package api
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestV2Envelope(t *testing.T) {
+	tests := []struct {
+		name     string
+		envelope envelope
+		expected string
+	}{
+		{
+			name:     "Single payload key is unwrapped into data",
+			envelope: envelope{"result": map[string]int{"id": 1}},
+			expected: `{"data":{"id":1}}`,
+		},
+		{
+			name:     "Metadata is moved next to data",
+			envelope: envelope{"Metadata": map[string]int{"total_records": 2}, "Movies": []int{1, 2}},
+			expected: `{"data":[1,2],"metadata":{"total_records":2}}`,
+		},
+		{
+			name:     "Several payload keys are kept under data in snake case",
+			envelope: envelope{"ShortLink": "abc", "URL": "/s/abc"},
+			expected: `{"data":{"short_link":"abc","url":"/s/abc"}}`,
+		},
+		{
+			name:     "Error is kept without data",
+			envelope: envelope{"error": "record not found"},
+			expected: `{"error":"record not found"}`,
+		},
+		{
+			name:     "Error code and documentation url are kept next to the error",
+			envelope: envelope{"error": "record not found", "code": codeNotFound, "documentation_url": "https://example.com/errors#not_found"},
+			expected: `{"error":"record not found","code":"not_found","documentation_url":"https://example.com/errors#not_found"}`,
+		},
+		{
+			name:     "Validation errors are kept as they are",
+			envelope: envelope{"error": map[string]string{"title": "must be provided"}},
+			expected: `{"error":{"title":"must be provided"}}`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			js, err := json.Marshal(tc.envelope.v2Envelope())
+			assert.NoError(t, err)
+			assert.JSONEq(t, tc.expected, string(js))
+		})
+	}
+}
+
+func TestToSnakeCase(t *testing.T) {
+	tests := map[string]string{
+		"result":       "result",
+		"Metadata":     "metadata",
+		"ShortLink":    "short_link",
+		"URL":          "url",
+		"ShortLinkURL": "short_link_url",
+		"URLCount":     "url_count",
+	}
+
+	for input, expected := range tests {
+		t.Run(input, func(t *testing.T) {
+			assert.Equal(t, expected, toSnakeCase(input))
+		})
+	}
+}
+
+func TestAcceptedAPIVersion(t *testing.T) {
+	APIV2Enabled = true
+	defer func() { APIV2Enabled = false }()
+
+	tests := []struct {
+		name     string
+		accept   string
+		version  int
+		expected bool
+	}{
+		{name: "No Accept header", accept: "", version: 0, expected: true},
+		{name: "Json without version", accept: "application/json", version: 0, expected: true},
+		{name: "Json with version", accept: "application/json; version=2", version: 2, expected: true},
+		{name: "Version with v prefix", accept: "application/json;version=v1", version: 1, expected: true},
+		{name: "First json media type wins", accept: "text/html, application/json; version=2, */*; version=1", version: 2, expected: true},
+		{name: "Unsupported version", accept: "application/json; version=3", version: 0, expected: false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			version, ok := acceptedAPIVersion(tc.accept)
+			assert.Equal(t, tc.version, version)
+			assert.Equal(t, tc.expected, ok)
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/cybrarymin/greenlight/internal/data"
+	"go.opentelemetry.io/otel/codes"
+	"golang.org/x/net/websocket"
+)
+
+// wsWriteTimeout disconnects the dashboards which don't read their events
+const wsWriteTimeout = 10 * time.Second
+
+var errUntrustedOrigin = errors.New("websocket origin isn't trusted")
+
+// wsQueryToken moves the access_token query parameter into the Authorization header. browsers can't set headers
+// on the websocket handshake, so the dashboards pass their jwt in the url instead.
+func (app *application) wsQueryToken(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if token := r.URL.Query().Get("access_token"); token != "" && r.Header.Get("Authorization") == "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		next(w, r)
+	}
+}
+
+func validLiveTopics(v *data.Validator, topics []string) {
+	for _, topic := range topics {
+		v.Check(slices.Contains(liveTopics, topic), "topics", "must be a comma separated list of counters and movies")
+	}
+}
+
+// liveEventsHandler upgrades the request to a websocket pushing the live events of the topics to the admin dashboards.
+// The topics are given by the topics query parameter, all of them by default, and can be changed by sending
+// {"action":"subscribe","topics":[...]} messages on the websocket.
+func (app *application) liveEventsHandler(w http.ResponseWriter, r *http.Request) {
+	_, span := app.startSpan(r, "liveEvents")
+	defer span.End()
+
+	topics := app.readCSV(r.URL.Query(), "topics", liveTopics)
+	nValidator := data.NewValidator()
+	validLiveTopics(nValidator, topics)
+	if !nValidator.Valid() {
+		span.RecordError(errors.New(createKeyValuePairs(nValidator.Errors)))
+		span.SetStatus(codes.Error, otelunprocessableErr)
+		app.failedValidationResponse(w, r, nValidator)
+		return
+	}
+
+	srv := websocket.Server{
+		// the token may be sent along with the cross site requests of the browsers, so the origin must be trusted
+		Handshake: func(cfg *websocket.Config, r *http.Request) error {
+			origin := r.Header.Get("Origin")
+			origins := app.runtimeCfg().CORSTrustedOrigins
+			if origin != "" && !slices.Contains(origins, "*") && !slices.Contains(origins, origin) {
+				return errUntrustedOrigin
+			}
+			return nil
+		},
+		Handler: func(ws *websocket.Conn) {
+			app.serveLiveEvents(ws, topics)
+		},
+	}
+	srv.ServeHTTP(w, r)
+}
+
+func (app *application) serveLiveEvents(ws *websocket.Conn, topics []string) {
+	defer ws.Close()
+	// the write timeout of the server would close the hijacked connection, so the deadline is set per event instead
+	ws.SetDeadline(time.Time{})
+
+	sub := app.hub.subscribe(topics)
+	defer app.hub.unsubscribe(sub)
+
+	// the messages of the dashboard are read until it closes the connection
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			var msg struct {
+				Action string   `json:"action"`
+				Topics []string `json:"topics"`
+			}
+			err := websocket.JSON.Receive(ws, &msg)
+			if err != nil {
+				return
+			}
+			nValidator := data.NewValidator()
+			validLiveTopics(nValidator, msg.Topics)
+			if msg.Action == "subscribe" && nValidator.Valid() {
+				app.hub.setTopics(sub, msg.Topics)
+			}
+		}
+	}()
+
+	for {
+		select {
+		case event, ok := <-sub.send:
+			if !ok {
+				app.events(ws.Request().Context()).Warn("disconnected a live events subscriber falling behind", nil)
+				return
+			}
+			ws.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			err := websocket.JSON.Send(ws, event)
+			if err != nil {
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"github.com/cybrarymin/greenlight/cmd/api"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var demoOpts api.DemoOptions
+
+// demoCmd runs the api without any infrastructure for the frontend development
+var demoCmd = &cobra.Command{
+	Use:   "demo",
+	Short: "Run the api on an embedded postgres with a seeded catalog",
+	Long: `Run the api on an embedded postgres seeded with fake movies and users, so the api can be run without any
+infrastructure. The emails are written to stdout instead of being sent and an admin token is printed on start.
+The database is thrown away on exit unless --data-dir is set.
+
+The model layer is bound to postgres, so the demo runs a real postgres instead of an in-memory store. Its binaries,
+around 30MB, are downloaded from Maven Central on the first run and cached in ~/.embedded-postgres-go, so the later
+runs work offline. Behind a firewall, point --postgres-repository-url at a Maven mirror, or copy the cached archive
+of another machine into --postgres-cache-dir.`,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if demoOpts.Movies < 0 || demoOpts.Users < 0 {
+			return errors.Errorf("--movies and --users must not be negative")
+		}
+		if len(demoOpts.Password) < 8 {
+			return errors.Errorf("--password must be at least 8 characters long")
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return api.Demo(demoOpts)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(demoCmd)
+
+	demoCmd.Flags().IntVar(&api.ListenPort, "port", 8080, "port to listen on")
+	demoCmd.Flags().Uint32Var(&demoOpts.DBPort, "db-port", 5433, "port of the embedded postgres")
+	demoCmd.Flags().StringVar(&demoOpts.DataDir, "data-dir", "", "directory keeping the database between the runs. the database is thrown away on exit when it's empty")
+	demoCmd.Flags().IntVar(&demoOpts.Movies, "movies", 200, "number of the movies to seed")
+	demoCmd.Flags().IntVar(&demoOpts.Users, "users", 20, "number of the users to seed")
+	demoCmd.Flags().Int64Var(&demoOpts.Seed, "seed", 1, "seed of the generated catalog")
+	demoCmd.Flags().StringVar(&demoOpts.PostgresCacheDir, "postgres-cache-dir", "", "directory caching the downloaded postgres binaries. ~/.embedded-postgres-go when it's empty")
+	demoCmd.Flags().StringVar(&demoOpts.PostgresRepositoryURL, "postgres-repository-url", "https://repo1.maven.org/maven2", "maven repository the postgres binaries are downloaded from")
+	demoCmd.Flags().StringVar(&demoOpts.Password, "password", "demo-password", "password of the demo admin and the seeded users")
+}
@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"github.com/cybrarymin/greenlight/cmd/api"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var loadGenOpts api.LoadGenOptions
+
+// loadgenCmd fills the database with fake data for the load tests
+var loadgenCmd = &cobra.Command{
+	Use:   "loadgen",
+	Short: "Generate fake movies, users and watch progress for load testing",
+	Long: `Generate fake movies, users and watch progress directly through the data layer in batched inserts,
+so the pagination, the search and the indexes can be tested against a realistically sized database.
+The same --seed generates the same data. Every generated user has the --password password.`,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if api.DBDSN == "" {
+			return errors.Errorf("--db-connection-string option is required.")
+		}
+		switch api.DBDriver {
+		case api.DBDriverPg, api.DBDriverPgx:
+		default:
+			return errors.Errorf("--db-driver must be one of %s or %s", api.DBDriverPg, api.DBDriverPgx)
+		}
+		if loadGenOpts.Movies < 0 || loadGenOpts.Users < 0 || loadGenOpts.ProgressPerUser < 0 {
+			return errors.Errorf("--movies, --users and --progress-per-user must not be negative")
+		}
+		if loadGenOpts.BatchSize <= 0 {
+			return errors.Errorf("--batch-size must be greater than 0")
+		}
+		if len(loadGenOpts.Password) < 8 {
+			return errors.Errorf("--password must be at least 8 characters long")
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return api.LoadGen(loadGenOpts)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(loadgenCmd)
+
+	loadgenCmd.Flags().StringVar(&api.DBDSN, "db-connection-string", "", "postgres database connection string")
+	loadgenCmd.Flags().StringVar(&api.DBDriver, "db-driver", api.DBDriverPg, "postgres driver (pgdriver|pgx)")
+	loadgenCmd.Flags().IntVar(&loadGenOpts.Movies, "movies", 10000, "number of the movies to generate")
+	loadgenCmd.Flags().IntVar(&loadGenOpts.Users, "users", 1000, "number of the users to generate")
+	loadgenCmd.Flags().IntVar(&loadGenOpts.ProgressPerUser, "progress-per-user", 5, "number of the movies each generated user has watched or started watching")
+	loadgenCmd.Flags().Int64Var(&loadGenOpts.Seed, "seed", 1, "seed of the generator. the same seed generates the same data")
+	loadgenCmd.Flags().IntVar(&loadGenOpts.BatchSize, "batch-size", 5000, "number of the rows inserted in each transaction")
+	loadgenCmd.Flags().StringVar(&loadGenOpts.Password, "password", "loadgen-password", "password of every generated user")
+}
@@ -4,11 +4,17 @@ Copyright © 2024 NAME HERE <EMAIL ADDRESS>
 package cmd
 
 import (
-	"fmt"
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"net/url"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/cybrarymin/greenlight/cmd/api"
+	"github.com/cybrarymin/greenlight/internal/data"
+	mailer "github.com/cybrarymin/greenlight/internal/mailter"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 )
@@ -27,19 +33,243 @@ to quickly create a Cobra application.`,
 	// has an action associated with it:
 	Run: func(cmd *cobra.Command, args []string) {
 		if api.VersionDisplay {
-			fmt.Printf("Version:   %s \nBuild time:   %v\n", api.Version, api.BuildTime)
+			api.PrintBuildInfo(os.Stdout)
 			return
 		}
 		api.Api()
 	},
 	PreRunE: func(cmd *cobra.Command, args []string) error {
+		profile, ok := api.EnvProfiles[api.Env]
+		if !ok {
+			return errors.Errorf("--env must be one of %s, %s or %s", api.EnvDevelopment, api.EnvStaging, api.EnvProduction)
+		}
+		// the defaults of the environment don't override the flags which are set explicitly
+		for name, value := range profile {
+			if cmd.Flags().Changed(name) {
+				continue
+			}
+			if err := cmd.Flags().Set(name, value); err != nil {
+				return errors.Wrapf(err, "failed to apply the %s default of --%s", api.Env, name)
+			}
+		}
+		if api.LogFormat != api.LogFormatJSON && api.LogFormat != api.LogFormatConsole {
+			return errors.Errorf("--log-format must be one of %s or %s", api.LogFormatJSON, api.LogFormatConsole)
+		}
+		if api.MailerDriver != api.MailerSMTP && api.MailerDriver != api.MailerLog {
+			return errors.Errorf("--mailer must be one of %s or %s", api.MailerSMTP, api.MailerLog)
+		}
+		// the secret flags are read from the files named by their *_FILE environment variables
+		for _, file := range api.SecretFiles {
+			path := os.Getenv(file.Env)
+			if path == "" {
+				continue
+			}
+			if cmd.Flags().Changed(file.Flag) {
+				return errors.Errorf("--%s and %s can't be set together", file.Flag, file.Env)
+			}
+			value, err := api.ReadSecretFile(path)
+			if err != nil {
+				return errors.Wrapf(err, "failed to read %s", file.Env)
+			}
+			if err := cmd.Flags().Set(file.Flag, value); err != nil {
+				return errors.Wrapf(err, "invalid value of --%s in %s", file.Flag, file.Env)
+			}
+		}
+		if api.SecretFilesWatchInterval < 0 {
+			return errors.Errorf("--secret-files-watch-interval must not be negative")
+		}
+		// the secrets are fetched before the checks so they're validated like the flags
+		if api.SecretsBackend != "" && !api.VersionDisplay {
+			if api.SecretsBackend != api.SecretsVault && api.SecretsBackend != api.SecretsAWS {
+				return errors.Errorf("--secrets-backend must be one of %s or %s", api.SecretsVault, api.SecretsAWS)
+			}
+			if api.SecretsPath == "" {
+				return errors.Errorf("--secrets-path option is required when --secrets-backend is set")
+			}
+			if api.SecretsRefreshInterval < 0 {
+				return errors.Errorf("--secrets-refresh-interval must not be negative")
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+			defer cancel()
+			if err := api.LoadSecrets(ctx); err != nil {
+				return errors.Wrapf(err, "failed to fetch the secrets from %s", api.SecretsBackend)
+			}
+		}
 		if !api.VersionDisplay && api.DBDSN == "" {
 			return errors.Errorf("--db-connection-string option is required.")
 		}
 		if api.JWTKEY == "" {
 			return errors.Errorf("--jwt-key option is required")
 		}
-		return nil
+		if api.JWTIssuer == "" || api.JWTAudience == "" {
+			return errors.Errorf("--jwt-issuer and --jwt-audience must not be empty")
+		}
+		if api.JWTLeeway < 0 {
+			return errors.Errorf("--jwt-leeway must not be negative")
+		}
+		if len(api.TokenTypes) == 0 {
+			return errors.Errorf("--token-types must allow at least one token type")
+		}
+		for _, tokenType := range api.TokenTypes {
+			switch tokenType {
+			case api.TokenOpaque, api.TokenJWT:
+			case api.TokenPASETO:
+				if seed, err := hex.DecodeString(api.PASETOKey); err != nil || len(seed) != ed25519.SeedSize {
+					return errors.Errorf("--paseto-key must be a hex encoded %d bytes seed when --token-types allows %s", ed25519.SeedSize, api.TokenPASETO)
+				}
+			default:
+				return errors.Errorf("--token-types must contain %s, %s or %s", api.TokenOpaque, api.TokenJWT, api.TokenPASETO)
+			}
+		}
+		switch api.MetricsExportMode {
+		case api.MetricsExportPrometheus, api.MetricsExportOTLP, api.MetricsExportBoth:
+		default:
+			return errors.Errorf("--metrics-export-mode must be one of %s, %s or %s", api.MetricsExportPrometheus, api.MetricsExportOTLP, api.MetricsExportBoth)
+		}
+		if (api.MetricsBasicAuthUsername == "") != (api.MetricsBasicAuthPassword == "") {
+			return errors.Errorf("--metrics-basic-auth-username and --metrics-basic-auth-password options must be provided together")
+		}
+		switch api.ChallengeProvider {
+		case "", api.ChallengeHCaptcha, api.ChallengeTurnstile, api.ChallengeProofOfWork:
+		default:
+			return errors.Errorf("--challenge-provider must be one of %s, %s or %s", api.ChallengeHCaptcha, api.ChallengeTurnstile, api.ChallengeProofOfWork)
+		}
+		if api.ChallengeProvider != "" && api.ChallengeSecret == "" {
+			return errors.Errorf("--challenge-secret option is required when --challenge-provider is set")
+		}
+		if api.PoWDifficulty < 1 || api.PoWDifficulty > 32 {
+			return errors.Errorf("--pow-difficulty must be between 1 and 32")
+		}
+		switch api.RegistrationMode {
+		case api.RegistrationOpen, api.RegistrationInvite, api.RegistrationClosed:
+		default:
+			return errors.Errorf("--registration-mode must be one of %s, %s or %s", api.RegistrationOpen, api.RegistrationInvite, api.RegistrationClosed)
+		}
+		if api.RegistrationMode == api.RegistrationInvite && api.InvitationSigningKey == "" {
+			return errors.Errorf("--invitation-signing-key option is required in invite registration mode")
+		}
+		switch api.DBDriver {
+		case api.DBDriverPg, api.DBDriverPgx:
+		default:
+			return errors.Errorf("--db-driver must be one of %s or %s", api.DBDriverPg, api.DBDriverPgx)
+		}
+		if api.DBExplainSampleRate < 0 || api.DBExplainSampleRate > 1 {
+			return errors.Errorf("--db-explain-sample-rate must be between 0 and 1")
+		}
+		if api.DBSlowQueryThreshold <= 0 {
+			return errors.Errorf("--db-slow-query-threshold must be greater than 0")
+		}
+		if api.PublicBaseURL != "" {
+			u, err := url.Parse(api.PublicBaseURL)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return errors.Errorf("--public-base-url must be an absolute http or https url")
+			}
+		}
+		if api.PanicAlertWebhook != "" {
+			u, err := url.Parse(api.PanicAlertWebhook)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return errors.Errorf("--panic-alert-webhook must be an absolute http or https url")
+			}
+		}
+		if api.ErrorDocsURL != "" {
+			u, err := url.Parse(api.ErrorDocsURL)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return errors.Errorf("--error-docs-url must be an absolute http or https url")
+			}
+		}
+		if api.CDNPurgeURL != "" {
+			u, err := url.Parse(api.CDNPurgeURL)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return errors.Errorf("--cdn-purge-url must be an absolute http or https url")
+			}
+		}
+		if api.AnonymousRateLimit <= 0 {
+			return errors.Errorf("--anonymous-rate-limit must be greater than 0")
+		}
+		if api.ServerReadTimeout < 0 || api.ServerReadHeaderTimeout < 0 || api.ServerWriteTimeout < 0 || api.ServerIdleTimeout < 0 {
+			return errors.Errorf("--server-read-timeout, --server-read-header-timeout, --server-write-timeout and --server-idle-timeout must not be negative")
+		}
+		if api.ServerMaxHeaderBytes <= 0 {
+			return errors.Errorf("--server-max-header-bytes must be greater than 0")
+		}
+		if api.CookieSameSite != api.SameSiteStrict && api.CookieSameSite != api.SameSiteLax && api.CookieSameSite != api.SameSiteNone {
+			return errors.Errorf("--cookie-same-site must be one of %s, %s or %s", api.SameSiteStrict, api.SameSiteLax, api.SameSiteNone)
+		}
+		if api.CookieSameSite == api.SameSiteNone && !api.CookieSecure {
+			return errors.Errorf("--cookie-same-site none requires --cookie-secure")
+		}
+		if api.CookieSessions && api.CSRFSigningKey == "" {
+			return errors.Errorf("--csrf-signing-key option is required with --cookie-sessions")
+		}
+		if api.SessionIdleTimeout <= 0 || api.SessionMaxLifetime < api.SessionIdleTimeout {
+			return errors.Errorf("--session-idle-timeout must be greater than 0 and not greater than --session-max-lifetime")
+		}
+		if api.RetentionInactiveUserAction != data.RetentionAnonymize && api.RetentionInactiveUserAction != data.RetentionPurge {
+			return errors.Errorf("--retention-inactive-user-action must be one of %s or %s", data.RetentionAnonymize, data.RetentionPurge)
+		}
+		if api.RatingsProviderURL != "" {
+			u, err := url.Parse(api.RatingsProviderURL)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return errors.Errorf("--ratings-provider-url must be an absolute http or https url")
+			}
+		}
+		if api.RatingsRefreshInterval < 0 || api.RatingsImportLimit <= 0 {
+			return errors.Errorf("--ratings-refresh-interval must not be negative and --ratings-import-limit must be greater than 0")
+		}
+		if api.RetentionInterval < 0 || api.RetentionInactiveUserDays < 0 || api.RetentionAuditLogDays < 0 || api.RetentionEmailLogDays < 0 {
+			return errors.Errorf("--retention-interval and the retention days must not be negative")
+		}
+		if api.SearchAlertInterval < 0 {
+			return errors.Errorf("--search-alert-interval must not be negative")
+		}
+		if api.MailMaxRetries < 0 || api.MailRetryBackoff <= 0 || api.MailMaxPending < 0 {
+			return errors.Errorf("--mail-max-retries and --mail-max-pending must not be negative and --mail-retry-backoff must be greater than 0")
+		}
+		if api.OutboxPollInterval <= 0 || api.OutboxMaxAttempts <= 0 {
+			return errors.Errorf("--outbox-poll-interval and --outbox-max-attempts must be greater than 0")
+		}
+		if api.ActivationMaxAttempts <= 0 || api.ActivationAttemptWindow <= 0 {
+			return errors.Errorf("--activation-max-attempts and --activation-attempt-window must be greater than 0")
+		}
+		if api.MaxBodySize <= 0 {
+			return errors.Errorf("--max-body-size must be greater than 0")
+		}
+		for route, limit := range api.MaxBodySizeRoutes {
+			if _, _, found := strings.Cut(route, " /"); !found || limit <= 0 {
+				return errors.Errorf("--max-body-size-routes must contain method and path routes like \"POST /users/import\" with sizes greater than 0")
+			}
+		}
+		for mediaType, limit := range api.MaxBodySizeContentTypes {
+			if limit <= 0 {
+				return errors.Errorf("--max-body-size-content-types size of %s must be greater than 0", mediaType)
+			}
+		}
+		if api.ChaosPercentage < 0 || api.ChaosPercentage > 100 {
+			return errors.Errorf("--chaos-percentage must be between 0 and 100")
+		}
+		for route, percentage := range api.ChaosRoutes {
+			if _, _, found := strings.Cut(route, " /"); !found || percentage < 0 || percentage > 100 {
+				return errors.Errorf("--chaos-routes must contain method and path routes like \"GET /movies/:id\" with percentages between 0 and 100")
+			}
+		}
+		for _, fault := range api.ChaosFaults {
+			if fault != api.ChaosLatency && fault != api.ChaosError && fault != api.ChaosDrop {
+				return errors.Errorf("--chaos-faults must contain %s, %s or %s", api.ChaosLatency, api.ChaosError, api.ChaosDrop)
+			}
+		}
+		if api.ChaosMaxLatency < 0 {
+			return errors.Errorf("--chaos-max-latency must not be negative")
+		}
+		if api.ShutdownDrainDelay < 0 || api.ShutdownTimeout <= 0 {
+			return errors.Errorf("--shutdown-drain-delay must not be negative and --shutdown-timeout must be greater than 0")
+		}
+		if api.MTLSListenPort != 0 && (api.TLSCertFile == "" || api.TLSKeyFile == "" || api.MTLSClientCAFile == "") {
+			return errors.Errorf("--tls-cert-file, --tls-key-file and --mtls-client-ca-file options are required when --mtls-port is set")
+		}
+		if api.VersionDisplay {
+			return nil
+		}
+		return api.CheckConfig()
 	},
 }
 
@@ -62,24 +292,147 @@ func init() {
 	// Cobra also supports local flags, which will only run
 	// when this action is called directly.
 	rootCmd.Flags().IntVar(&api.ListenPort, "port", 8080, "port to listen on")
-	rootCmd.Flags().StringVar(&api.Env, "env", "development", "environment (development|staging|production)")
+	rootCmd.Flags().StringVar(&api.Env, "env", api.EnvDevelopment, "environment (development|staging|production). it sets the defaults of --log-format, --mailer, --cors-trusted-origins, --enable-rate-limit, --cookie-secure, --require-tls and --validate-responses which aren't set explicitly")
 	rootCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
 	rootCmd.Flags().StringVar(&api.DBDSN, "db-connection-string", "", "postgres database connection string")
+	rootCmd.Flags().StringVar(&api.DBDriver, "db-driver", api.DBDriverPg, "postgres driver (pgdriver|pgx)")
 	rootCmd.Flags().IntVar(&api.DBMaxConnCount, "db-max-conn", 25, "maximum idle and active connection client can have to the database")
 	rootCmd.Flags().IntVar(&api.DBMaxIdleConnCount, "db-idle-max-conn", 25, "maximum idle connection client can have to the database")
 	rootCmd.Flags().DurationVar(&api.DBMaxIdleConnTimeout, "db-idle-conn-timeout", time.Minute*15, "maximum amount of time an idle connection will exist")
 	rootCmd.Flags().BoolVar(&api.DBLogs, "db-enable-log", false, "enable database interaction logs")
+	rootCmd.Flags().DurationVar(&api.DBSlowQueryThreshold, "db-slow-query-threshold", 3*time.Second, "duration after which a database query is considered slow")
+	rootCmd.Flags().Float64Var(&api.DBExplainSampleRate, "db-explain-sample-rate", 0, "fraction of the slow select queries whose plan is captured by EXPLAIN (ANALYZE, BUFFERS) and attached to the trace. 0 disables it")
+	rootCmd.Flags().DurationVar(&api.DBWriteGracePeriod, "db-write-grace-period", 5*time.Second, "amount of time the database statements of a write request keep running after its client hangs up. 0 cancels them immediately")
 	rootCmd.Flags().Int8Var(&api.LogLevel, "log-level", 1, "loglevel of the application - debug:0 info:1 warn:2 error:3 fatal:4 panic:5 trace:-1")
 	rootCmd.Flags().Int64Var(&api.GlobalRateLimit, "global-request-rate-limit", 100, "used to apply rate limiting to total number of requests coming to the api server. 10% of the specified value will be considered as the burst limit for total number of requests")
 	rootCmd.Flags().Int64Var(&api.PerClientRateLimit, "per-client-rate-limit", 100, "used to apply rate limiting to per client number of requests coming to the api server. 10% of the specified value will be considered as the burst limit for total number of requests")
 	rootCmd.Flags().BoolVar(&api.EnableRateLimit, "enable-rate-limit", false, "enable rate limiting")
+	rootCmd.Flags().Int64Var(&api.AnonymousRateLimit, "anonymous-rate-limit", 10, "used to apply rate limiting to per client number of anonymous requests to the public catalog. it applies on top of the per client rate limit")
+	rootCmd.Flags().BoolVar(&api.APIV2Enabled, "api-v2", false, "serve the api routes under the /v2 prefix as well, with the snake case data, metadata and error response envelope")
+	rootCmd.Flags().BoolVar(&api.PublicCatalog, "public-catalog", false, "expose listing and showing the movies to the anonymous users with a restricted set of fields. write endpoints keep requiring activated users with permissions")
+	rootCmd.Flags().Int64Var(&api.MonthlyRequestQuota, "monthly-request-quota", 0, "maximum number of requests each user can send in a calendar month. requests beyond it are rejected with 429. disabled when it's 0")
+	rootCmd.Flags().StringSliceVar(&api.CORSTrustedOrigins, "cors-trusted-origins", []string{"*"}, "comma separated list of origins allowed to access the api from browsers")
+	rootCmd.Flags().StringSliceVar(&api.EmailDomainAllowlist, "email-domain-allowlist", nil, "comma separated list of email domains allowed to register. every domain is allowed when it's empty")
+	rootCmd.Flags().StringSliceVar(&api.EmailDomainDenylist, "email-domain-denylist", nil, "comma separated list of email domains not allowed to register")
+	rootCmd.Flags().BoolVar(&api.BlockDisposableEmails, "block-disposable-emails", false, "reject the registrations using the bundled list of disposable email domains")
+	rootCmd.Flags().BoolVar(&api.MaintenanceMode, "maintenance-mode", false, "start the server in maintenance mode. all the requests except admin, healthcheck and metrics will be rejected")
 	rootCmd.Flags().StringVar(&api.SMTPServer, "smtp-server-addr", "smptserver.test.com", "smtp server to send the email for user after registration")
 	rootCmd.Flags().IntVar(&api.SMTPPort, "smtp-server-port", 2525, "smtp server port that you want your emails to")
 	rootCmd.Flags().StringVar(&api.SMTPUserName, "smtp-username", "", "smtp-username")
 	rootCmd.Flags().StringVar(&api.SMTPPassword, "smtp-password", "", "smtp-pass")
 	rootCmd.Flags().StringVar(&api.EmailSender, "smtp-sender-address", "no-reply@greenlight.com", "sender email information to be represented to the email receiver")
+	rootCmd.Flags().IntVar(&api.SMTPMaxInFlight, "smtp-max-in-flight", 4, "maximum number of concurrent email sends, which is also the maximum number of warm smtp connections")
+	rootCmd.Flags().DurationVar(&api.SMTPIdleTimeout, "smtp-idle-timeout", 30*time.Second, "amount of time an unused smtp connection is kept open")
+	rootCmd.Flags().IntVar(&api.SMTPMaxAttachment, "smtp-max-attachment-size", mailer.DefaultMaxAttachmentBytes, "maximum total size of the attachments of an email in bytes")
+	rootCmd.Flags().IntVar(&api.MailMaxRetries, "mail-max-retries", 3, "number of retries after the first failed attempt of the emails sent in the background, like the password reset and the new sign-in emails")
+	rootCmd.Flags().DurationVar(&api.MailRetryBackoff, "mail-retry-backoff", 2*time.Second, "base delay between the retries of an email. it doubles on each retry with added jitter")
+	rootCmd.Flags().Int64Var(&api.MailMaxPending, "mail-max-pending", 1000, "maximum number of emails sending or waiting for a retry in the background. the emails beyond it are dropped. disabled when it's 0")
+	rootCmd.Flags().StringVar(&api.MailTemplateDir, "mail-template-dir", "", "directory of mail templates overriding the embedded templates with the same name")
+	rootCmd.Flags().StringVar(&api.EmailWebhookSecret, "email-webhook-secret", "", "secret used to verify the hmac-sha256 signature of the mail provider event callbacks. the webhook is disabled when it's empty")
+	rootCmd.Flags().StringVar(&api.SCIMBearerToken, "scim-bearer-token", "", "bearer token of the identity provider to access the scim provisioning endpoints. scim is disabled when it's empty")
+	rootCmd.Flags().StringVar(&api.ChallengeProvider, "challenge-provider", "", "challenge required on the registration and token endpoints (hcaptcha|turnstile|pow). challenges are disabled when it's empty")
+	rootCmd.Flags().StringVar(&api.ChallengeSecret, "challenge-secret", "", "secret key of hcaptcha or turnstile, or the hmac key used to sign the proof of work challenges")
+	rootCmd.Flags().StringVar(&api.ChallengeSiteKey, "challenge-site-key", "", "site key of hcaptcha or turnstile to verify the captcha tokens were issued for this site")
+	rootCmd.Flags().IntVar(&api.PoWDifficulty, "pow-difficulty", 20, "number of leading zero bits required in the proof of work solutions")
+	rootCmd.Flags().StringSliceVar(&api.ChallengeAPIKeys, "challenge-trusted-api-keys", nil, "comma separated list of api keys which skip the challenge when provided in X-API-Key header")
+	rootCmd.Flags().StringVar(&api.RegistrationMode, "registration-mode", api.RegistrationOpen, "self registration mode of the users (open|invite|closed). invite mode requires an invitation created by the admins")
+	rootCmd.Flags().StringVar(&api.InvitationSigningKey, "invitation-signing-key", "", "hmac key used to sign the invitation tokens. required in invite registration mode")
+	rootCmd.Flags().StringVar(&api.ShareSigningKey, "share-signing-key", "", "hmac key used to sign the temporary share urls of the movies. movie sharing is disabled when it's empty")
+	rootCmd.Flags().StringVar(&api.PublicBaseURL, "public-base-url", "", "base url of the public website the movie short links redirect to, like https://greenlight.example.com. short links are disabled when it's empty")
+	rootCmd.Flags().DurationVar(&api.ServerReadTimeout, "server-read-timeout", 10*time.Second, "maximum duration for reading a whole request including its body. 0 means no timeout")
+	rootCmd.Flags().DurationVar(&api.ServerReadHeaderTimeout, "server-read-header-timeout", 5*time.Second, "maximum duration for reading the headers of a request. 0 falls back to --server-read-timeout")
+	rootCmd.Flags().DurationVar(&api.ServerWriteTimeout, "server-write-timeout", 30*time.Second, "maximum duration before timing out writing a response. 0 means no timeout")
+	rootCmd.Flags().DurationVar(&api.ServerIdleTimeout, "server-idle-timeout", time.Minute, "amount of time an idle keep-alive connection is kept open. 0 falls back to --server-read-timeout")
+	rootCmd.Flags().IntVar(&api.ServerMaxHeaderBytes, "server-max-header-bytes", 1<<20, "maximum size of the request headers in bytes")
+	rootCmd.Flags().Int64Var(&api.MaxBodySize, "max-body-size", 1<<20, "maximum size of the request bodies in bytes. requests with larger bodies get 413")
+	rootCmd.Flags().StringToInt64Var(&api.MaxBodySizeRoutes, "max-body-size-routes", map[string]int64{"POST /users/import": 5 << 20}, "comma separated list of route=bytes pairs overriding --max-body-size for the routes, like \"POST /users/import=5242880\". routes are given without the version prefix and :name segments match any path segment")
+	rootCmd.Flags().StringToInt64Var(&api.MaxBodySizeContentTypes, "max-body-size-content-types", nil, "comma separated list of media type=bytes pairs overriding --max-body-size for the request bodies of the media types, like \"text/csv=5242880\". route overrides take precedence")
+	rootCmd.Flags().BoolVar(&api.ServerKeepAlives, "server-keep-alives", true, "reuse the client connections for multiple requests")
+	rootCmd.Flags().BoolVar(&api.H2C, "h2c", false, "serve HTTP/2 without TLS on the http port for the internal callers. only enable it behind a trusted network")
+	rootCmd.Flags().DurationVar(&api.ShutdownDrainDelay, "shutdown-drain-delay", 0, "amount of time the server keeps serving with a failing readiness check after the shutdown signal, so the load balancers stop sending requests before the listeners are closed")
+	rootCmd.Flags().DurationVar(&api.ShutdownTimeout, "shutdown-timeout", 20*time.Second, "amount of time the shutdown waits for the active requests to finish")
+	rootCmd.Flags().BoolVar(&api.GracefulRestart, "graceful-restart", false, "restart the server without dropping requests on SIGHUP. a new process takes over the listeners once it's ready, then the old one drains and exits")
+	rootCmd.Flags().BoolVar(&api.ReusePort, "reuse-port", false, "set SO_REUSEPORT on the listeners so a new server can listen on the same ports while the old one is draining. not needed with systemd socket activation")
+	rootCmd.Flags().StringVar(&api.CookieSameSite, "cookie-same-site", api.SameSiteLax, "SameSite attribute of the session and csrf cookies (strict|lax|none). none requires --cookie-secure")
+	rootCmd.Flags().BoolVar(&api.CookieSecure, "cookie-secure", true, "only send the session and csrf cookies over https. disable it only in development")
+	rootCmd.Flags().BoolVar(&api.CookieSessions, "cookie-sessions", false, "let the web frontend sign in by an HttpOnly session cookie on /v1/sessions instead of the tokens. unsafe requests authenticated by the cookie require the csrf token of /v1/csrf")
+	rootCmd.Flags().StringVar(&api.CSRFSigningKey, "csrf-signing-key", "", "hmac key binding the csrf tokens to the cookie session. required with --cookie-sessions")
+	rootCmd.Flags().DurationVar(&api.SessionIdleTimeout, "session-idle-timeout", 24*time.Hour, "amount of time a cookie session lives without any request. every request slides its expiry")
+	rootCmd.Flags().DurationVar(&api.SessionMaxLifetime, "session-max-lifetime", 30*24*time.Hour, "amount of time a cookie session lives at most regardless of its activity")
+	rootCmd.Flags().StringVar(&api.RatingsProviderURL, "ratings-provider-url", "https://www.omdbapi.com/", "omdb compatible api the imdb and rotten tomatoes scores of the imdb movies are imported from")
+	rootCmd.Flags().StringVar(&api.RatingsAPIKey, "ratings-api-key", "", "api key of the ratings provider. the ratings import is disabled when it's empty")
+	rootCmd.Flags().DurationVar(&api.RatingsRefreshInterval, "ratings-refresh-interval", 24*time.Hour, "how often the external ratings are imported and how old they get before they're imported again. 0 disables the schedule, admins can still run it on /v1/admin/ratings/import")
+	rootCmd.Flags().IntVar(&api.RatingsImportLimit, "ratings-import-limit", 500, "maximum number of movies whose ratings are imported by a run")
+	rootCmd.Flags().DurationVar(&api.RetentionInterval, "retention-interval", 0, "how often the data retention job runs. 0 disables the schedule, admins can still run it on /v1/admin/retention")
+	rootCmd.Flags().BoolVar(&api.RetentionDryRun, "retention-dry-run", false, "only count the rows the scheduled data retention would affect")
+	rootCmd.Flags().IntVar(&api.RetentionInactiveUserDays, "retention-inactive-user-days", 0, "anonymize or purge the users who haven't signed in for the days. admins are kept. 0 disables it")
+	rootCmd.Flags().StringVar(&api.RetentionInactiveUserAction, "retention-inactive-user-action", data.RetentionAnonymize, "what the data retention does to the inactive users (anonymize|purge)")
+	rootCmd.Flags().IntVar(&api.RetentionAuditLogDays, "retention-audit-log-days", 0, "delete the audit events older than the days. 0 disables it")
+	rootCmd.Flags().IntVar(&api.RetentionEmailLogDays, "retention-email-log-days", 0, "delete the email logs older than the days. 0 disables it")
+	rootCmd.Flags().DurationVar(&api.SearchAlertInterval, "search-alert-interval", time.Hour, "how often the users are emailed the new movies matching their saved searches with alerts. 0 disables the alerts")
+	rootCmd.Flags().StringVar(&api.ErrorDocsURL, "error-docs-url", "https://github.com/cybrarymin/greenlight/blob/main/docs/errors.md", "page documenting the error codes. the error responses link to it with the code as the anchor. empty disables the links")
+	rootCmd.Flags().BoolVar(&api.SMTPProbe, "smtp-probe", false, "connect to the smtp server with the smtp credentials on startup and refuse to start when it fails")
+	rootCmd.Flags().StringVar(&api.LogFormat, "log-format", api.LogFormatJSON, "format of the logs (json|console). defaults by --env")
+	rootCmd.Flags().StringVar(&api.LogFile, "log-file", "", "file the logs are written to instead of stdout. it's rotated by --log-file-max-size and --log-file-rotate-interval")
+	rootCmd.Flags().IntVar(&api.LogFileMaxSize, "log-file-max-size", 100, "size in megabytes the log file is rotated at")
+	rootCmd.Flags().IntVar(&api.LogFileMaxBackups, "log-file-max-backups", 10, "number of the rotated log files which are kept. 0 keeps all of them")
+	rootCmd.Flags().IntVar(&api.LogFileMaxAge, "log-file-max-age", 0, "days the rotated log files are kept for. 0 keeps them regardless of their age")
+	rootCmd.Flags().DurationVar(&api.LogFileRotateInterval, "log-file-rotate-interval", 0, "rotates the log file periodically on top of its size, e.g. 24h. disabled when it's 0")
+	rootCmd.Flags().Uint32Var(&api.LogWarnBurst, "log-warn-burst", 0, "number of warnings logged per --log-warn-sample-period, the rest of them are dropped. it keeps the noisy warnings like the rejected requests of an attack from flooding the logs. disabled when it's 0")
+	rootCmd.Flags().DurationVar(&api.LogWarnSamplePeriod, "log-warn-sample-period", time.Second, "period --log-warn-burst applies to")
+	rootCmd.Flags().StringVar(&api.MailerDriver, "mailer", api.MailerSMTP, "how the emails are delivered (smtp|log). log writes them to the standard output instead of sending them. defaults by --env")
+	rootCmd.Flags().BoolVar(&api.ValidateResponses, "validate-responses", false, "check the responses of the documented operations against the swagger document and replace the mismatching ones by 500. it buffers the responses so it's meant for the development. defaults by --env")
+	rootCmd.Flags().BoolVar(&api.RequireTLS, "require-tls", false, "reject the requests which aren't sent over https or forwarded from https by the proxy. defaults by --env")
+	rootCmd.Flags().BoolVar(&api.ChaosMode, "chaos", false, "inject faults into the requests to test the clients against a flaky server. not allowed in production")
+	rootCmd.Flags().Int64Var(&api.ChaosPercentage, "chaos-percentage", 10, "percentage of the requests getting a fault in chaos mode")
+	rootCmd.Flags().StringToInt64Var(&api.ChaosRoutes, "chaos-routes", map[string]int64{}, "comma separated list of route=percentage pairs overriding --chaos-percentage for the routes, like \"GET /movies/:id=50\". routes are given without the version prefix and :name segments match any path segment")
+	rootCmd.Flags().StringSliceVar(&api.ChaosFaults, "chaos-faults", []string{api.ChaosLatency, api.ChaosError, api.ChaosDrop}, "comma separated list of the faults injected in chaos mode (latency|error|drop)")
+	rootCmd.Flags().DurationVar(&api.ChaosMaxLatency, "chaos-max-latency", 2*time.Second, "upper bound of the latency injected in chaos mode")
+	rootCmd.Flags().StringVar(&api.CatalogCacheControl, "cache-control-catalog", "", "Cache-Control of the movie list and details served to the anonymous users, like \"public, max-age=60\". the authenticated users get private. disabled when it's empty")
+	rootCmd.Flags().StringVar(&api.CatalogSurrogateControl, "surrogate-control-catalog", "", "Surrogate-Control of the movie list and details served to the anonymous users, like \"max-age=3600\". it's read by the cdn only")
+	rootCmd.Flags().StringVar(&api.FeedsCacheControl, "cache-control-feeds", "", "Cache-Control of the rss and atom feeds and the sitemap. they keep their own when it's empty")
+	rootCmd.Flags().StringVar(&api.FeedsSurrogateControl, "surrogate-control-feeds", "", "Surrogate-Control of the rss and atom feeds and the sitemap")
+	rootCmd.Flags().StringVar(&api.CDNPurgeURL, "cdn-purge-url", "", "url of the cdn purge api receiving the surrogate keys of the changed movies. it enables POST /admin/cache/purge as well")
+	rootCmd.Flags().StringVar(&api.CDNPurgeToken, "cdn-purge-token", "", "bearer token of the cdn purge api")
+	rootCmd.Flags().DurationVar(&api.OutboxPollInterval, "outbox-poll-interval", 2*time.Second, "how often the pending outbox events, like the welcome mails of the new users, are dispatched")
+	rootCmd.Flags().IntVar(&api.OutboxMaxAttempts, "outbox-max-attempts", 10, "number of times an outbox event is tried before it's given up on")
+	rootCmd.Flags().IntVar(&api.ActivationMaxAttempts, "activation-max-attempts", 5, "number of invalid activation tokens an account can send within --activation-attempt-window before its activation is locked")
+	rootCmd.Flags().DurationVar(&api.ActivationAttemptWindow, "activation-attempt-window", 15*time.Minute, "how long the invalid activation attempts of an account are counted and the account is locked for")
+	rootCmd.Flags().StringSliceVar(&api.TokenTypes, "token-types", []string{api.TokenOpaque, api.TokenJWT}, "types of the tokens issued on /v1/tokens (opaque|jwt|paseto). the first one is issued when the client doesn't request a type")
+	rootCmd.Flags().StringVar(&api.PASETOKey, "paseto-key", "", "hex encoded 32 bytes Ed25519 seed signing the paseto tokens. required when --token-types allows paseto")
+	rootCmd.Flags().StringVar(&api.JWTIssuer, "jwt-issuer", "greenlight.example.com", "issuer set on the issued jwts. the jwts of other issuers are rejected")
+	rootCmd.Flags().StringVar(&api.JWTAudience, "jwt-audience", "greenlight.example.com", "audience set on the issued jwts. the jwts of other audiences are rejected")
+	rootCmd.Flags().DurationVar(&api.JWTLeeway, "jwt-leeway", 30*time.Second, "clock skew allowed when the expiry and the not before times of the jwts are verified")
+	rootCmd.Flags().StringVar(&api.SecretsBackend, "secrets-backend", "", "store the db dsn, the smtp credentials and the jwt and paseto keys are fetched from on startup (vault|aws-secrets-manager). vault is reached by VAULT_ADDR and VAULT_TOKEN, aws secrets manager by AWS_REGION and the AWS_* credentials. the fetched secrets override the flags")
+	rootCmd.Flags().StringVar(&api.SecretsPath, "secrets-path", "greenlight", "path of the secret in the vault kv mount or the name of the aws secret. the secret holds db_dsn, smtp_username, smtp_password, jwt_key and paseto_key")
+	rootCmd.Flags().StringVar(&api.VaultMount, "vault-mount", "secret", "mount path of the vault kv version 2 secrets engine")
+	rootCmd.Flags().StringVar(&api.AWSSecretsEndpoint, "aws-secrets-endpoint", "", "endpoint of aws secrets manager overriding the regional one, e.g. a vpc endpoint")
+	rootCmd.Flags().DurationVar(&api.SecretsRefreshInterval, "secrets-refresh-interval", 0, "how often the secrets are fetched again to pick up their rotation. the database and the smtp connections are dialed again by the rotated credentials and the tokens signed by a rotated key are rejected. disabled when it's 0")
+	rootCmd.Flags().DurationVar(&api.SecretFilesWatchInterval, "secret-files-watch-interval", 0, "how often the files of GREENLIGHT_DB_DSN_FILE, GREENLIGHT_SMTP_USERNAME_FILE, GREENLIGHT_SMTP_PASSWORD_FILE, GREENLIGHT_JWT_KEY_FILE and GREENLIGHT_PASETO_KEY_FILE are read again to pick up their rotation. the other *_FILE secrets are only read on startup. disabled when it's 0")
+	rootCmd.Flags().StringSliceVar(&api.IPAllowlist, "ip-allowlist", nil, "comma separated list of cidrs allowed to reach the api. every address is allowed when it's empty")
+	rootCmd.Flags().StringSliceVar(&api.IPDenylist, "ip-denylist", nil, "comma separated list of cidrs kept from reaching the api. it wins over the allowlists")
+	rootCmd.Flags().StringSliceVar(&api.AdminIPAllowlist, "admin-ip-allowlist", nil, "comma separated list of cidrs allowed to reach the routes requiring the admin permission, e.g. the office ranges. every address is allowed when it's empty")
+	rootCmd.Flags().StringSliceVar(&api.MetricsIPAllowlist, "metrics-ip-allowlist", nil, "comma separated list of cidrs allowed to reach /metrics. every address is allowed when it's empty")
+	rootCmd.Flags().StringVar(&api.GeoIPDatabase, "geoip-database", "", "path of the maxmind country database, e.g. GeoLite2-Country.mmdb. required by --geo-blocked-countries")
+	rootCmd.Flags().StringSliceVar(&api.GeoBlockedCountries, "geo-blocked-countries", nil, "comma separated list of the ISO 3166-1 alpha-2 codes of the countries kept from reaching the api")
+	rootCmd.Flags().IntVar(&api.MaxInflightPerClient, "max-inflight-per-client", 0, "number of requests a client address or a user can have in flight at once. the websockets and the event streams are limited by --max-streams-per-client instead. disabled when it's 0")
+	rootCmd.Flags().IntVar(&api.MaxStreamsPerClient, "max-streams-per-client", 0, "number of websockets and event streams a client address or a user can keep open at once. disabled when it's 0")
+	rootCmd.Flags().Int64Var(&api.MinUploadRate, "min-upload-rate", 0, "minimum rate in bytes per second the bodies of --min-upload-rate-routes must be sent at. the slower uploads are cut off and the faster ones may outlive --server-read-timeout. disabled when it's 0")
+	rootCmd.Flags().DurationVar(&api.MinUploadRateGrace, "min-upload-rate-grace", 5*time.Second, "how long an upload may be sent slower than --min-upload-rate before it's enforced")
+	rootCmd.Flags().StringSliceVar(&api.MinUploadRateRoutes, "min-upload-rate-routes", []string{"POST /users/import"}, "comma separated list of the upload routes --min-upload-rate applies to, like \"POST /users/import\". routes are given without the version prefix and :name segments match any path segment")
+	rootCmd.Flags().StringVar(&api.RoutePoliciesFile, "route-policies", "", "yaml file declaring the cache ttl, the rate tier, the timeout and the body size limit of the routes matched by their pattern, like \"GET /movies/:id\". the first policy matching the request applies")
+	rootCmd.Flags().IntVar(&api.MTLSListenPort, "mtls-port", 0, "port of the listener requiring client certificates. clients are authenticated by their certificate. disabled when it's 0")
+	rootCmd.Flags().StringVar(&api.TLSCertFile, "tls-cert-file", "", "certificate file of the server used by the mtls listener")
+	rootCmd.Flags().StringVar(&api.TLSKeyFile, "tls-key-file", "", "private key file of the server used by the mtls listener")
+	rootCmd.Flags().StringVar(&api.MTLSClientCAFile, "mtls-client-ca-file", "", "pem file of the CAs used to verify the client certificates on the mtls listener")
+	rootCmd.Flags().StringToStringVar(&api.MTLSIdentityMap, "mtls-identity-map", nil, "comma separated list of certificate name=user email pairs mapping the common name or SANs of the client certificates to users or service accounts. email SANs are mapped to the user with the same email by default")
 	rootCmd.Flags().BoolVar(&api.VersionDisplay, "version", false, "show the version of the application")
 	rootCmd.Flags().StringVar(&api.JWTKEY, "jwt-key", "", "defining jwt key string to be used for issuing jwt token")
+	rootCmd.Flags().StringVar(&api.MetricsExportMode, "metrics-export-mode", api.MetricsExportBoth, "where to export the application metrics (prometheus|otlp|both). prometheus exposes them on /metrics and otlp pushes them to the otlp metric endpoint")
+	rootCmd.Flags().StringVar(&api.MetricsBasicAuthUsername, "metrics-basic-auth-username", "", "username required to scrape the /metrics endpoint using basic authentication")
+	rootCmd.Flags().StringVar(&api.MetricsBasicAuthPassword, "metrics-basic-auth-password", "", "password required to scrape the /metrics endpoint using basic authentication")
+	rootCmd.Flags().StringVar(&api.PanicAlertWebhook, "panic-alert-webhook", "", "url the panics recovered while serving the requests are posted to as json, at most once a minute. alerts are disabled when it's empty")
+	rootCmd.Flags().StringVar(&api.MetricsBearerToken, "metrics-bearer-token", "", "static bearer token required to scrape the /metrics endpoint")
 	rootCmd.Flags().StringVar(&api.OtlpTraceHost, "otlp-trace-host", "localhost", "opentelemetry protocol jaeger endpoint")
 	rootCmd.Flags().StringVar(&api.OtlpHTTPTracePort, "otlp-trace-http-port", "4318", "opentelemetry protocol jaeger port ")
 	rootCmd.Flags().StringVar(&api.OtlpMetriceHost, "otlp-metric-host", "localhost", "opentelemetry protocol for prometheus host ")
@@ -57,6 +57,42 @@ const docTemplate = `{
                         "name": "genres",
                         "in": "query"
                     },
+                    {
+                        "type": "string",
+                        "description": "only the movies updated at or after the RFC 3339 timestamp",
+                        "name": "updated_since",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "only the movies made in or after the year",
+                        "name": "year_from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "only the movies made in or before the year",
+                        "name": "year_to",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "only the movies not longer than the runtime in minutes",
+                        "name": "runtime_max",
+                        "in": "query"
+                    },
+                    {
+                        "type": "number",
+                        "description": "only the movies rated at least as much on imdb, out of 10",
+                        "name": "imdb_rating_min",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "only the movies with at least the rotten tomatoes score in percent",
+                        "name": "rotten_tomatoes_score_min",
+                        "in": "query"
+                    },
                     {
                         "type": "integer",
                         "default": 1,
@@ -74,9 +110,26 @@ const docTemplate = `{
                     {
                         "type": "string",
                         "default": "id",
-                        "description": "sort options: id, title, year, runtime, -id, -title, -year, -runtim",
+                        "description": "sort options: id, title, year, runtime, updated_at, imdb_rating, rotten_tomatoes_score. prefixed by - for descending. unrated movies come last",
                         "name": "sort",
                         "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "default": "exact",
+                        "description": "total count mode: none, estimate, exact",
+                        "name": "count",
+                        "in": "query"
+                    },
+                    {
+                        "type": "array",
+                        "items": {
+                            "type": "string"
+                        },
+                        "collectionFormat": "csv",
+                        "description": "facets to count the matching movies by: genres, year_decade",
+                        "name": "facets",
+                        "in": "query"
                     }
                 ],
                 "responses": {
@@ -207,6 +260,182 @@ const docTemplate = `{
                 }
             }
         },
+        "/movies/autocomplete": {
+            "get": {
+                "description": "suggest the movies whose titles are the closest to what's been typed so far.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "movie",
+                    "list"
+                ],
+                "summary": "autocomplete movie titles",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "jwt token",
+                        "name": "Authorization",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "start of the title, at least 2 bytes",
+                        "name": "q",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "maximum": 20,
+                        "type": "integer",
+                        "default": 10,
+                        "description": "number of suggestions",
+                        "name": "limit",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "successfull response",
+                        "schema": {
+                            "$ref": "#/definitions/api.SwaggerAutocompleteResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "invalid, expired or wrong token ",
+                        "schema": {
+                            "$ref": "#/definitions/api.SwaggerUnauthorizaed"
+                        }
+                    },
+                    "403": {
+                        "description": "permission denied",
+                        "schema": {
+                            "$ref": "#/definitions/api.SwaggerNotPermitted"
+                        }
+                    },
+                    "422": {
+                        "description": "invalid input provided",
+                        "schema": {
+                            "$ref": "#/definitions/api.SwaggerFailedValidationResponse"
+                        }
+                    },
+                    "429": {
+                        "description": "request rate limit reached",
+                        "schema": {
+                            "$ref": "#/definitions/api.SwaggerRateLimitExceedResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "server couldn't process the request",
+                        "schema": {
+                            "$ref": "#/definitions/api.SwaggerServerErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/movies/by-external-id/{source}/{id}": {
+            "put": {
+                "description": "create the movie of an imdb or tmdb id or update the existing one. repeating the same request doesn't change the movie.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "movie",
+                    "create"
+                ],
+                "summary": "create or update a movie by its external id",
+                "parameters": [
+                    {
+                        "description": "movie data as body",
+                        "name": "movie",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/api.SwaggerCreateMovieInput"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "jwt token",
+                        "name": "authorization",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "external catalog: imdb or tmdb",
+                        "name": "source",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "movie id in the external catalog",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "movie updated",
+                        "schema": {
+                            "$ref": "#/definitions/api.SwaggerCreateResponse"
+                        }
+                    },
+                    "201": {
+                        "description": "movie created",
+                        "schema": {
+                            "$ref": "#/definitions/api.SwaggerCreateResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "bad requet and malformed input",
+                        "schema": {
+                            "$ref": "#/definitions/api.SwaggerBadRequestResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "invalid, expired or wrong token ",
+                        "schema": {
+                            "$ref": "#/definitions/api.SwaggerUnauthorizaed"
+                        }
+                    },
+                    "403": {
+                        "description": "permission denied",
+                        "schema": {
+                            "$ref": "#/definitions/api.SwaggerNotPermitted"
+                        }
+                    },
+                    "422": {
+                        "description": "invalid input provided",
+                        "schema": {
+                            "$ref": "#/definitions/api.SwaggerFailedValidationResponse"
+                        }
+                    },
+                    "429": {
+                        "description": "request rate limit reached",
+                        "schema": {
+                            "$ref": "#/definitions/api.SwaggerRateLimitExceedResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "server couldn't process the request",
+                        "schema": {
+                            "$ref": "#/definitions/api.SwaggerServerErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
         "/movies/{id}": {
             "get": {
                 "description": "Get movie detail.",
@@ -244,6 +473,18 @@ const docTemplate = `{
                             "$ref": "#/definitions/api.SwaggerGetResponse"
                         }
                     },
+                    "308": {
+                        "description": "the movie has been merged or re-imported as the movie of the Location header",
+                        "schema": {
+                            "$ref": "#/definitions/api.SwaggerRedirectResponse"
+                        },
+                        "headers": {
+                            "Location": {
+                                "type": "string",
+                                "description": "url of the movie which has replaced it"
+                            }
+                        }
+                    },
                     "401": {
                         "description": "invalid, expired or wrong token ",
                         "schema": {
@@ -262,6 +503,12 @@ const docTemplate = `{
                             "$ref": "#/definitions/api.SwaggerNotFound"
                         }
                     },
+                    "422": {
+                        "description": "invalid input provided",
+                        "schema": {
+                            "$ref": "#/definitions/api.SwaggerFailedValidationResponse"
+                        }
+                    },
                     "429": {
                         "description": "request rate limit reached",
                         "schema": {
@@ -330,6 +577,12 @@ const docTemplate = `{
                             "$ref": "#/definitions/api.SwaggerNotFound"
                         }
                     },
+                    "422": {
+                        "description": "invalid input provided",
+                        "schema": {
+                            "$ref": "#/definitions/api.SwaggerFailedValidationResponse"
+                        }
+                    },
                     "429": {
                         "description": "request rate limit reached",
                         "schema": {
@@ -345,7 +598,7 @@ const docTemplate = `{
                 }
             },
             "patch": {
-                "description": "update movie",
+                "description": "update movie. the optional version in the body makes the update fail with 409 when the movie has changed since the client read it",
                 "consumes": [
                     "application/json"
                 ],
@@ -419,6 +672,12 @@ const docTemplate = `{
                             "$ref": "#/definitions/api.SwaggerEditConflictResponse"
                         }
                     },
+                    "422": {
+                        "description": "invalid input provided",
+                        "schema": {
+                            "$ref": "#/definitions/api.SwaggerFailedValidationResponse"
+                        }
+                    },
                     "429": {
                         "description": "request rate limit reached",
                         "schema": {
@@ -436,9 +695,39 @@ const docTemplate = `{
         }
     },
     "definitions": {
+        "api.SwaggerAuthLinks": {
+            "type": "object",
+            "properties": {
+                "register": {
+                    "$ref": "#/definitions/api.SwaggerLink"
+                },
+                "token": {
+                    "$ref": "#/definitions/api.SwaggerLink"
+                }
+            }
+        },
+        "api.SwaggerAutocompleteResponse": {
+            "type": "object",
+            "properties": {
+                "Movies": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/data.MovieSuggestion"
+                    }
+                }
+            }
+        },
         "api.SwaggerBadRequestResponse": {
             "type": "object",
             "properties": {
+                "code": {
+                    "type": "string",
+                    "example": "bad_request"
+                },
+                "documentation_url": {
+                    "type": "string",
+                    "example": "https://github.com/cybrarymin/greenlight/blob/main/docs/errors.md#bad_request"
+                },
                 "error": {
                     "type": "string",
                     "example": "bad request error"
@@ -493,6 +782,14 @@ const docTemplate = `{
         "api.SwaggerEditConflictResponse": {
             "type": "object",
             "properties": {
+                "code": {
+                    "type": "string",
+                    "example": "edit_conflict"
+                },
+                "documentation_url": {
+                    "type": "string",
+                    "example": "https://github.com/cybrarymin/greenlight/blob/main/docs/errors.md#edit_conflict"
+                },
                 "error": {
                     "type": "string",
                     "example": "unable to update the record due to an edit conflict, please try again"
@@ -502,9 +799,23 @@ const docTemplate = `{
         "api.SwaggerFailedValidationResponse": {
             "type": "object",
             "properties": {
-                "error": {
+                "code": {
+                    "type": "string",
+                    "example": "failed_validation"
+                },
+                "documentation_url": {
                     "type": "string",
-                    "example": "unprocessable input error"
+                    "example": "https://github.com/cybrarymin/greenlight/blob/main/docs/errors.md#failed_validation"
+                },
+                "error": {
+                    "description": "Error maps the invalid fields to their error messages",
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    },
+                    "example": {
+                        "title": "must be provided"
+                    }
                 }
             }
         },
@@ -514,16 +825,35 @@ const docTemplate = `{
             "properties": {
                 "Movie": {
                     "$ref": "#/definitions/data.Movie"
+                },
+                "Progress": {
+                    "$ref": "#/definitions/data.WatchProgress"
+                }
+            }
+        },
+        "api.SwaggerLink": {
+            "type": "object",
+            "properties": {
+                "href": {
+                    "type": "string",
+                    "example": "/v1/users"
+                },
+                "method": {
+                    "type": "string",
+                    "example": "POST"
                 }
             }
         },
         "api.SwaggerListResponse": {
             "type": "object",
             "properties": {
-                "metadata": {
+                "Facets": {
+                    "$ref": "#/definitions/data.MovieFacets"
+                },
+                "Metadata": {
                     "$ref": "#/definitions/data.PaginationMeta"
                 },
-                "movies": {
+                "Movies": {
                     "type": "array",
                     "items": {
                         "$ref": "#/definitions/data.Movie"
@@ -534,6 +864,14 @@ const docTemplate = `{
         "api.SwaggerNotFound": {
             "type": "object",
             "properties": {
+                "code": {
+                    "type": "string",
+                    "example": "not_found"
+                },
+                "documentation_url": {
+                    "type": "string",
+                    "example": "https://github.com/cybrarymin/greenlight/blob/main/docs/errors.md#not_found"
+                },
                 "error": {
                     "type": "string",
                     "example": "the requested resource couldn't be found"
@@ -543,6 +881,14 @@ const docTemplate = `{
         "api.SwaggerNotPermitted": {
             "type": "object",
             "properties": {
+                "code": {
+                    "type": "string",
+                    "example": "not_permitted"
+                },
+                "documentation_url": {
+                    "type": "string",
+                    "example": "https://github.com/cybrarymin/greenlight/blob/main/docs/errors.md#not_permitted"
+                },
                 "error": {
                     "type": "string",
                     "example": "permission denied"
@@ -552,15 +898,40 @@ const docTemplate = `{
         "api.SwaggerRateLimitExceedResponse": {
             "type": "object",
             "properties": {
+                "code": {
+                    "type": "string",
+                    "example": "rate_limited"
+                },
+                "documentation_url": {
+                    "type": "string",
+                    "example": "https://github.com/cybrarymin/greenlight/blob/main/docs/errors.md#rate_limited"
+                },
                 "error": {
                     "type": "string",
                     "example": "request rate limit reached, please try again later"
                 }
             }
         },
+        "api.SwaggerRedirectResponse": {
+            "type": "object",
+            "properties": {
+                "location": {
+                    "type": "string",
+                    "example": "/v1/movies/2"
+                }
+            }
+        },
         "api.SwaggerServerErrorResponse": {
             "type": "object",
             "properties": {
+                "code": {
+                    "type": "string",
+                    "example": "server_error"
+                },
+                "documentation_url": {
+                    "type": "string",
+                    "example": "https://github.com/cybrarymin/greenlight/blob/main/docs/errors.md#server_error"
+                },
                 "error": {
                     "type": "string",
                     "example": "the server encountered an error to process the request"
@@ -570,15 +941,54 @@ const docTemplate = `{
         "api.SwaggerUnauthorizaed": {
             "type": "object",
             "properties": {
+                "_links": {
+                    "$ref": "#/definitions/api.SwaggerAuthLinks"
+                },
+                "code": {
+                    "type": "string",
+                    "example": "authentication_required"
+                },
+                "documentation_url": {
+                    "type": "string",
+                    "example": "https://github.com/cybrarymin/greenlight/blob/main/docs/errors.md#authentication_required"
+                },
                 "error": {
                     "type": "string",
                     "example": "unauthorized request"
                 }
             }
         },
+        "data.FacetCount": {
+            "type": "object",
+            "properties": {
+                "count": {
+                    "type": "integer",
+                    "example": 12
+                },
+                "value": {
+                    "type": "string",
+                    "example": "action"
+                }
+            }
+        },
         "data.Movie": {
             "type": "object",
             "properties": {
+                "external_id": {
+                    "description": "ExternalID is the identifier of the movie in its external catalog.",
+                    "type": "string",
+                    "example": "tt4154756"
+                },
+                "external_ratings_updated_at": {
+                    "description": "ExternalRatingsUpdatedAt is when the external ratings were last imported. The movies never imported lack it.",
+                    "type": "string",
+                    "example": "2024-01-02T15:04:05Z"
+                },
+                "external_source": {
+                    "description": "ExternalSource is the catalog the movie is synced from, imdb or tmdb.",
+                    "type": "string",
+                    "example": "imdb"
+                },
                 "genres": {
                     "description": "Genres is a list of categories.\nRequired: true",
                     "type": "array",
@@ -595,6 +1005,16 @@ const docTemplate = `{
                     "type": "integer",
                     "example": 1
                 },
+                "imdb_rating": {
+                    "description": "IMDbRating is the score of the movie on imdb out of 10. It's imported from the ratings provider, it isn't\nrated by the users of the api. check ExternalRatings",
+                    "type": "number",
+                    "example": 8.4
+                },
+                "rotten_tomatoes_score": {
+                    "description": "RottenTomatoesScore is the tomatometer of the movie in percent, imported along with IMDbRating",
+                    "type": "integer",
+                    "example": 85
+                },
                 "runtime": {
                     "description": "Runtime in minutes.\nRequired: true",
                     "type": "string",
@@ -605,6 +1025,11 @@ const docTemplate = `{
                     "type": "string",
                     "example": "avengers"
                 },
+                "updated_at": {
+                    "description": "UpdatedAt is bumped on every write of the movie. check BeforeAppendModel",
+                    "type": "string",
+                    "example": "2024-01-02T15:04:05Z"
+                },
                 "version": {
                     "description": "Version number will be increased each time the movies is updated",
                     "type": "integer",
@@ -617,6 +1042,40 @@ const docTemplate = `{
                 }
             }
         },
+        "data.MovieFacets": {
+            "type": "object",
+            "properties": {
+                "genres": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/data.FacetCount"
+                    }
+                },
+                "year_decade": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/data.FacetCount"
+                    }
+                }
+            }
+        },
+        "data.MovieSuggestion": {
+            "type": "object",
+            "properties": {
+                "id": {
+                    "type": "integer",
+                    "example": 1
+                },
+                "title": {
+                    "type": "string",
+                    "example": "avengers"
+                },
+                "year": {
+                    "type": "integer",
+                    "example": 2018
+                }
+            }
+        },
         "data.PaginationMeta": {
             "type": "object",
             "properties": {
@@ -636,11 +1095,35 @@ const docTemplate = `{
                     "type": "integer",
                     "example": 10
                 },
+                "total_estimated": {
+                    "type": "boolean",
+                    "example": false
+                },
                 "total_records": {
                     "type": "integer",
                     "example": 30
                 }
             }
+        },
+        "data.WatchProgress": {
+            "type": "object",
+            "properties": {
+                "completed": {
+                    "type": "boolean"
+                },
+                "movie": {
+                    "$ref": "#/definitions/data.Movie"
+                },
+                "movie_id": {
+                    "type": "integer"
+                },
+                "position_seconds": {
+                    "type": "integer"
+                },
+                "updated_at": {
+                    "type": "string"
+                }
+            }
         }
     }
 }`
@@ -0,0 +1,134 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/url"
+	"regexp"
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+// types of the movie assets
+const (
+	AssetTrailer = "trailer"
+	AssetClip    = "clip"
+	AssetFull    = "full"
+)
+
+var (
+	AssetQualities = []string{"480p", "720p", "1080p", "2160p"}
+	// LanguageRX matches the language tags like en or pt-BR
+	LanguageRX = regexp.MustCompile(`^[a-z]{2,3}(-[A-Z]{2})?$`)
+	// BlobKeyRX matches the object keys of the storage bucket. keys are relative so they can't start with a slash.
+	// Their length is checked apart since regexp doesn't allow repeats over 1000.
+	BlobKeyRX = regexp.MustCompile(`^[A-Za-z0-9!_.*'()-][A-Za-z0-9!_.*'()/-]*$`)
+)
+
+type MovieAssetModel struct {
+	db *bun.DB
+}
+
+// MovieAsset is a playable media of a movie. It's either served from an external URL or from the storage bucket by its blob key.
+type MovieAsset struct {
+	bun.BaseModel `bun:"table:movie_assets"`
+	ID            int64     `json:"id" bun:",pk,autoincrement,notnull,type:bigserial"`
+	MovieID       int64     `json:"movie_id" bun:",notnull"`
+	Type          string    `json:"type" bun:",notnull"`
+	URL           string    `json:"url,omitempty" bun:",nullzero"`
+	BlobKey       string    `json:"blob_key,omitempty" bun:",nullzero"`
+	Quality       string    `json:"quality" bun:",notnull"`
+	Language      string    `json:"language" bun:",notnull"`
+	CreatedAt     time.Time `json:"created_at" bun:",type:timestamptz,notnull,default:current_timestamp"`
+	Version       int32     `json:"version" bun:",notnull,default:1"`
+}
+
+func ValidateMovieAsset(v *Validator, asset *MovieAsset) {
+	v.Apply("type", OneOf(asset.Type, AssetTrailer, AssetClip, AssetFull))
+	v.Apply("quality", OneOf(asset.Quality, AssetQualities...))
+	v.Apply("language", Pattern(asset.Language, LanguageRX, "must be a language tag like en or pt-BR"))
+	v.Check((asset.URL == "") != (asset.BlobKey == ""), "url", "exactly one of url and blob_key must be provided")
+	if asset.URL != "" {
+		u, err := url.Parse(asset.URL)
+		v.Check(err == nil && (u.Scheme == "https" || u.Scheme == "http") && u.Host != "", "url", "must be an absolute http or https url")
+		v.Apply("url", MaxLen(asset.URL, 2048))
+	}
+	if asset.BlobKey != "" {
+		v.Apply("blob_key", Pattern(asset.BlobKey, BlobKeyRX, "must be a relative object key"), MaxLen(asset.BlobKey, 1024))
+	}
+}
+
+func (a *MovieAssetModel) Insert(ctx context.Context, asset *MovieAsset) error {
+	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*3)
+	defer cancelFunc()
+	err := a.db.NewInsert().Model(asset).Returning("id, created_at, version").Scan(timeoutCtx, &asset.ID, &asset.CreatedAt, &asset.Version)
+	return mapPgError(err)
+}
+
+func (a *MovieAssetModel) Get(ctx context.Context, movieID int64, assetID int64) (*MovieAsset, error) {
+	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*3)
+	defer cancelFunc()
+	asset := &MovieAsset{}
+	err := a.db.NewSelect().Model(asset).Where("id = ? AND movie_id = ?", assetID, movieID).Scan(timeoutCtx)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrorRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return asset, nil
+}
+
+// ListForMovie returns the assets of the movie. An empty assetType matches every type.
+func (a *MovieAssetModel) ListForMovie(ctx context.Context, movieID int64, assetType string) ([]MovieAsset, error) {
+	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*3)
+	defer cancelFunc()
+	assets := []MovieAsset{}
+	err := a.db.NewSelect().Model(&assets).
+		Where("movie_id = ?", movieID).
+		Where("(type = ? OR ? = '')", assetType, assetType).
+		OrderExpr("type ASC, id ASC").Scan(timeoutCtx)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	return assets, nil
+}
+
+// Update writes the asset only if its row still has the version the asset was read with. check MovieModel.Update
+func (a *MovieAssetModel) Update(ctx context.Context, asset *MovieAsset) error {
+	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*3)
+	defer cancelFunc()
+	var version int32
+	err := a.db.NewUpdate().Model(asset).
+		ExcludeColumn("id", "movie_id", "created_at").
+		Value("version", "version + 1").
+		Where("id = ? AND movie_id = ? AND version = ?", asset.ID, asset.MovieID, asset.Version).
+		Returning("version").Scan(timeoutCtx, &version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return mapPgError(err)
+		}
+	}
+	asset.Version = version
+	return nil
+}
+
+func (a *MovieAssetModel) Delete(ctx context.Context, movieID int64, assetID int64) error {
+	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*3)
+	defer cancelFunc()
+	result, err := a.db.NewDelete().Model((*MovieAsset)(nil)).Where("id = ? AND movie_id = ?", assetID, movieID).Exec(timeoutCtx)
+	if err != nil {
+		return mapPgError(err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrorRecordNotFound
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+const AuditActionImpersonationStart = "impersonation.start"
+
+type AuditLogModel struct {
+	db *bun.DB
+}
+
+// AuditLog records an action taken by the actor on behalf of the subject.
+// Actor and subject are the same user unless the action was taken during an impersonation.
+type AuditLog struct {
+	bun.BaseModel   `bun:"table:audit_logs"`
+	ID              int64     `json:"id" bun:",pk,autoincrement,notnull,type:bigserial"`
+	ActorID         uuid.UUID `json:"actor_id" bun:",type:uuid,notnull"`
+	SubjectID       uuid.UUID `json:"subject_id" bun:",type:uuid,notnull"`
+	ImpersonationID string    `json:"impersonation_id,omitempty" bun:",notnull"`
+	Action          string    `json:"action" bun:",notnull"`
+	Status          int       `json:"status,omitempty" bun:",notnull"`
+	RemoteAddr      string    `json:"remote_addr" bun:",notnull"`
+	CreatedAt       time.Time `json:"created_at" bun:",type:timestamptz,notnull,default:current_timestamp"`
+}
+
+func (a *AuditLogModel) Insert(ctx context.Context, entry *AuditLog) error {
+	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*3)
+	defer cancelFunc()
+	err := a.db.NewInsert().Model(entry).Returning("id, created_at").Scan(timeoutCtx, &entry.ID, &entry.CreatedAt)
+	return mapPgError(err)
+}
+
+// List returns the audit entries of the actor and the subject. The nil uuid matches every user.
+func (a *AuditLogModel) List(ctx context.Context, actorID uuid.UUID, subjectID uuid.UUID, filters *Filters) ([]AuditLog, int, error) {
+	entries := []AuditLog{}
+	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*5)
+	defer cancelFunc()
+
+	query := a.db.NewSelect().Model(&entries).
+		Where("(actor_id = ? OR ? = ?)", actorID, actorID, uuid.Nil).
+		Where("(subject_id = ? OR ? = ?)", subjectID, subjectID, uuid.Nil)
+	count, err := paginate(timeoutCtx, query, filters)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, 0, ErrorRecordNotFound
+		default:
+			return nil, 0, err
+		}
+	}
+	return entries, count, nil
+}
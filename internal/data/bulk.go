@@ -0,0 +1,68 @@
+package data
+
+import (
+	"context"
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+// Conflict handling of the bulk inserts. fail aborts the whole insert on the first conflicting row,
+// skip leaves the existing rows untouched and update overwrites them with the inserted values.
+const (
+	ConflictFail   = "fail"
+	ConflictSkip   = "skip"
+	ConflictUpdate = "update"
+)
+
+const DefaultInsertChunkSize = 500
+
+type BulkInsertOptions struct {
+	// ChunkSize is the number of rows sent in each insert statement. Zero means DefaultInsertChunkSize
+	ChunkSize int
+	// OnConflict is one of the conflict handling modes. Empty means ConflictFail
+	OnConflict string
+}
+
+func (o BulkInsertOptions) chunkSize() int {
+	if o.ChunkSize <= 0 {
+		return DefaultInsertChunkSize
+	}
+	return o.ChunkSize
+}
+
+func (o BulkInsertOptions) onConflict() string {
+	if o.OnConflict == "" {
+		return ConflictFail
+	}
+	return o.OnConflict
+}
+
+func ValidateBulkInsertOptions(v *Validator, opts BulkInsertOptions) {
+	v.Apply("on_conflict", OneOf(opts.onConflict(), ConflictFail, ConflictSkip, ConflictUpdate))
+	v.Apply("chunk_size", Between(opts.ChunkSize, 0, 5000))
+}
+
+// insertChunks runs insert for each chunk of rows in a single transaction so a failing chunk rolls back the ones before it.
+// It's a savepoint when db is a transaction already. insert returns the number of rows written by the chunk.
+// Postgres errors are returned as the domain errors of mapPgError.
+func insertChunks[T any](ctx context.Context, db bun.IDB, rows []*T, chunkSize int, insert func(ctx context.Context, tx bun.Tx, chunk []*T) (int, error)) (int, error) {
+	written := 0
+	err := db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		for start := 0; start < len(rows); start += chunkSize {
+			chunk := rows[start:min(start+chunkSize, len(rows))]
+			timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*10)
+			n, err := insert(timeoutCtx, tx, chunk)
+			cancelFunc()
+			if err != nil {
+				return err
+			}
+			written += n
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, mapPgError(err)
+	}
+	return written, nil
+}
@@ -0,0 +1,146 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+// operations of the movie changes
+const (
+	ChangeCreated = "created"
+	ChangeUpdated = "updated"
+	ChangeDeleted = "deleted"
+)
+
+// nextChangeSeq hands out the change sequence numbers. Every write of a movie takes the next number, so the
+// sync clients can ask for the changes after the last number they've seen.
+// The numbers are handed out under a lock held until the transaction ends, so a number can't be committed after
+// a later one has been served. Movie writes are serialized as a result.
+const nextChangeSeq = "next_movie_change_seq()"
+
+// lockChangeSeq takes the lock of the change sequence numbers up front. The transactions locking the rows of the movies
+// before taking a number need it, otherwise they'd deadlock with the writes waiting for those rows under the lock.
+func lockChangeSeq(ctx context.Context, tx bun.Tx) error {
+	_, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock(hashtext('movie_change_seq'))")
+	return err
+}
+
+// MovieTombstone records a deleted movie so the sync clients can remove it as well
+type MovieTombstone struct {
+	bun.BaseModel `bun:"table:movie_tombstones"`
+	MovieID       int64     `bun:",pk"`
+	ChangeSeq     int64     `bun:",nullzero,notnull"`
+	DeletedAt     time.Time `bun:",type:timestamptz,notnull,default:current_timestamp"`
+	// MergedInto is the movie the deleted one has been merged into. check Merge
+	MergedInto *int64 `bun:"merged_into"`
+	// ExternalSource and ExternalID are the external id of the deleted movie, so the movie created again by
+	// a later import of the same external id takes over its old id. check UpsertByExternalID
+	ExternalSource string `bun:",nullzero"`
+	ExternalID     string `bun:",nullzero"`
+}
+
+// MovieChange is a write of a movie. Movie is nil for the deleted movies.
+type MovieChange struct {
+	Seq       int64      `json:"seq"`
+	Op        string     `json:"op"`
+	MovieID   int64      `json:"movie_id"`
+	Movie     *Movie     `json:"movie,omitempty"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	// MergedInto is set on the deleted movies which have been merged into another one
+	MergedInto *int64 `json:"merged_into,omitempty"`
+}
+
+// movieChangeRow is a movie along with the sequence number of its last write
+type movieChangeRow struct {
+	Movie     `bun:",extend"`
+	ChangeSeq int64 `bun:"change_seq"`
+}
+
+// Changes returns the latest change of every movie written after the since sequence number in the order of the writes,
+// at most limit of them. It reports whether there are more changes after the returned ones.
+// Movies which have never been updated are reported as created since their version is still the initial one.
+func (m *MovieModel) Changes(ctx context.Context, since int64, limit int) ([]MovieChange, bool, error) {
+	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*10)
+	defer cancelFunc()
+
+	// each side is read up to one more than the limit, which is enough to fill the merged page and tell whether there's more.
+	// Both are read from the same snapshot so a change committed in between can't be skipped by the other side.
+	rows := []movieChangeRow{}
+	tombstones := []MovieTombstone{}
+	err := m.db.RunInTx(timeoutCtx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true}, func(ctx context.Context, tx bun.Tx) error {
+		err := tx.NewSelect().Model(&rows).
+			Where("change_seq > ?", since).
+			OrderExpr("change_seq ASC").
+			Limit(limit + 1).Scan(ctx)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		// a tombstone is stale once a movie with the same id has been inserted again
+		err = tx.NewSelect().Model(&tombstones).
+			Where("change_seq > ?", since).
+			Where("NOT EXISTS (SELECT 1 FROM movies WHERE movies.id = ?TableAlias.movie_id)").
+			OrderExpr("change_seq ASC").
+			Limit(limit + 1).Scan(ctx)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	changes := make([]MovieChange, 0, min(len(rows)+len(tombstones), limit+1))
+	i, j := 0, 0
+	for len(changes) <= limit && (i < len(rows) || j < len(tombstones)) {
+		if j >= len(tombstones) || (i < len(rows) && rows[i].ChangeSeq < tombstones[j].ChangeSeq) {
+			op := ChangeUpdated
+			if rows[i].Version == 1 {
+				op = ChangeCreated
+			}
+			changes = append(changes, MovieChange{Seq: rows[i].ChangeSeq, Op: op, MovieID: rows[i].ID, Movie: &rows[i].Movie})
+			i++
+			continue
+		}
+		changes = append(changes, MovieChange{Seq: tombstones[j].ChangeSeq, Op: ChangeDeleted, MovieID: tombstones[j].MovieID, DeletedAt: &tombstones[j].DeletedAt, MergedInto: tombstones[j].MergedInto})
+		j++
+	}
+	if len(changes) > limit {
+		return changes[:limit], true, nil
+	}
+	return changes, false, nil
+}
+
+// LastChangeSeq returns the sequence number of the last committed write of a movie. The numbers become visible in
+// order, so no write committed later can take a number up to it.
+func (m *MovieModel) LastChangeSeq(ctx context.Context) (int64, error) {
+	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*3)
+	defer cancelFunc()
+	var seq int64
+	err := m.db.NewSelect().Model((*Movie)(nil)).ColumnExpr("COALESCE(max(change_seq), 0)").Scan(timeoutCtx, &seq)
+	if err != nil {
+		return 0, err
+	}
+	return seq, nil
+}
+
+// CreatedBetween returns the movies matching the filter which have been created after the since sequence number and
+// up to the until one, at most limit of them in the order of their inserts. It returns the number of them as well.
+// The movies are found by the sequence number of their insert, so the ones updated since are still returned.
+func (m *MovieModel) CreatedBetween(ctx context.Context, filter MovieListFilter, since int64, until int64, limit int) ([]Movie, int, error) {
+	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*5)
+	defer cancelFunc()
+	nMovies := []Movie{}
+	count, err := filter.apply(m.db.NewSelect().Model(&nMovies)).
+		Where("created_seq > ? AND created_seq <= ?", since, until).
+		OrderExpr("created_seq ASC").
+		Limit(limit).ScanAndCount(timeoutCtx)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, 0, err
+	}
+	return nMovies, count, nil
+}
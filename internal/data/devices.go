@@ -0,0 +1,98 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+type DeviceModel struct {
+	db *bun.DB
+}
+
+// Device is a client holding an authentication token issued to the user, e.g. "CI runner" or "Living room TV".
+// The devices of the opaque tokens are bound to the token by its hash and go away along with it. The jwts and the
+// pasetos are stateless so their devices are bound to their token id and are only marked as revoked.
+type Device struct {
+	bun.BaseModel `bun:"table:devices"`
+	ID            int64      `json:"id" bun:",pk,autoincrement,notnull,type:bigserial"`
+	UserID        uuid.UUID  `json:"-" bun:",type:uuid,notnull"`
+	Name          string     `json:"name" bun:",notnull"`
+	TokenType     string     `json:"token_type" bun:",notnull"`
+	TokenHash     []byte     `json:"-" bun:",type:bytea,nullzero"`
+	TokenID       string     `json:"-" bun:",nullzero"`
+	RemoteAddr    string     `json:"remote_addr" bun:",notnull"`
+	UserAgent     string     `json:"user_agent" bun:",notnull"`
+	CreatedAt     time.Time  `json:"created_at" bun:",type:timestamptz,notnull,default:current_timestamp"`
+	Expiry        time.Time  `json:"expiry" bun:",type:timestamptz,notnull"`
+	RevokedAt     *time.Time `json:"-" bun:",type:timestamptz,nullzero"`
+}
+
+func ValidateDeviceName(v *Validator, name string) {
+	v.Check(len(name) <= 100, "device_name", "must not be more than 100 bytes long")
+}
+
+// Insert stores the device of a newly issued token
+func (d *DeviceModel) Insert(ctx context.Context, device *Device) error {
+	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*3)
+	defer cancelFunc()
+	err := d.db.NewInsert().Model(device).
+		Column("user_id", "name", "token_type", "token_hash", "token_id", "remote_addr", "user_agent", "expiry").
+		Returning("id, created_at").Scan(timeoutCtx, &device.ID, &device.CreatedAt)
+	return mapPgError(err)
+}
+
+// ListForUser returns the devices of the user whose tokens are neither expired nor revoked, the latest first
+func (d *DeviceModel) ListForUser(ctx context.Context, userID uuid.UUID) ([]Device, error) {
+	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*3)
+	defer cancelFunc()
+	devices := []Device{}
+	err := d.db.NewSelect().Model(&devices).
+		Where("user_id = ? AND revoked_at IS NULL AND expiry > now()", userID).
+		Order("id DESC").Scan(timeoutCtx)
+	if err != nil {
+		return nil, err
+	}
+	return devices, nil
+}
+
+// Revoke signs the device of the user out. The opaque token of the device is deleted, which removes the device as
+// well, and the stateless tokens are rejected from then on. check Revoked
+func (d *DeviceModel) Revoke(ctx context.Context, userID uuid.UUID, id int64) error {
+	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*5)
+	defer cancelFunc()
+	err := d.db.RunInTx(timeoutCtx, nil, func(ctx context.Context, tx bun.Tx) error {
+		var tokenHash []byte
+		err := tx.NewUpdate().Model((*Device)(nil)).Set("revoked_at = now()").
+			Where("id = ? AND user_id = ? AND revoked_at IS NULL AND expiry > now()", id, userID).
+			Returning("token_hash").Scan(ctx, &tokenHash)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrorRecordNotFound
+			default:
+				return err
+			}
+		}
+		if tokenHash == nil {
+			return nil
+		}
+		_, err = tx.NewDelete().Model((*Token)(nil)).Where("hash = ?", tokenHash).Exec(ctx)
+		return err
+	})
+	return mapPgError(err)
+}
+
+// Revoked reports whether the device of the stateless token with the token id has been revoked.
+// The tokens without a device, e.g. the ones issued before the devices, aren't revoked.
+func (d *DeviceModel) Revoked(ctx context.Context, tokenID string) (bool, error) {
+	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*3)
+	defer cancelFunc()
+	return d.db.NewSelect().Model((*Device)(nil)).
+		Where("token_id = ? AND revoked_at IS NOT NULL", tokenID).
+		Exists(timeoutCtx)
+}
@@ -0,0 +1,81 @@
+package data
+
+import (
+	_ "embed"
+	"slices"
+	"strings"
+)
+
+//go:embed disposable_domains.txt
+var disposableDomainsFile string
+
+var disposableDomains = parseDomainList(disposableDomainsFile)
+
+func parseDomainList(list string) []string {
+	var domains []string
+	for _, line := range strings.Split(list, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		domains = append(domains, strings.ToLower(line))
+	}
+	return domains
+}
+
+// EmailDomainPolicy restricts the email domains which can be used to register.
+// A rule matches the domain itself and all of its subdomains. The denylist wins over the allowlist
+// and an empty allowlist allows every domain.
+type EmailDomainPolicy struct {
+	Allowlist       []string `json:"allowlist"`
+	Denylist        []string `json:"denylist"`
+	BlockDisposable bool     `json:"block_disposable"`
+}
+
+func (p EmailDomainPolicy) Clone() EmailDomainPolicy {
+	p.Allowlist = slices.Clone(p.Allowlist)
+	p.Denylist = slices.Clone(p.Denylist)
+	return p
+}
+
+// Allowed reports whether the domain of the email is allowed by the policy
+func (p *EmailDomainPolicy) Allowed(email string) bool {
+	_, domain, found := strings.Cut(email, "@")
+	if !found {
+		return false
+	}
+	domain = strings.ToLower(domain)
+	if matchesDomain(domain, p.Denylist) {
+		return false
+	}
+	if p.BlockDisposable && matchesDomain(domain, disposableDomains) {
+		return false
+	}
+	return len(p.Allowlist) == 0 || matchesDomain(domain, p.Allowlist)
+}
+
+func matchesDomain(domain string, rules []string) bool {
+	for _, rule := range rules {
+		rule = strings.ToLower(rule)
+		if domain == rule || strings.HasSuffix(domain, "."+rule) {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateEmailDomain reports the disallowed email domains under their own email_domain key,
+// so the clients can tell them apart from the malformed email addresses.
+func ValidateEmailDomain(v *Validator, email string, policy *EmailDomainPolicy) {
+	v.CheckCode(policy.Allowed(email), "email_domain", CodeNotAllowed, "registration with this email domain isn't allowed")
+}
+
+// ValidateEmailDomainPolicy reports the invalid rules by their index, like allowlist[2]
+func ValidateEmailDomainPolicy(v *Validator, policy *EmailDomainPolicy) {
+	for key, rules := range map[string][]string{"allowlist": policy.Allowlist, "denylist": policy.Denylist} {
+		v.Apply(key, UniqueItems(rules))
+		for i, rule := range rules {
+			v.Check(rule != "" && !strings.ContainsAny(rule, "@ "), Path(key, i), "must only contain domain names like example.com")
+		}
+	}
+}
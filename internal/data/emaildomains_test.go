@@ -0,0 +1,65 @@
+package data
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEmailDomainPolicyAllowed(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   EmailDomainPolicy
+		email    string
+		expected bool
+	}{
+		{
+			name:     "Empty policy allows every domain",
+			policy:   EmailDomainPolicy{},
+			email:    "john@example.com",
+			expected: true,
+		},
+		{
+			name:     "Allowlisted domain",
+			policy:   EmailDomainPolicy{Allowlist: []string{"ourcompany.com"}},
+			email:    "john@OurCompany.com",
+			expected: true,
+		},
+		{
+			name:     "Subdomain of allowlisted domain",
+			policy:   EmailDomainPolicy{Allowlist: []string{"ourcompany.com"}},
+			email:    "john@eu.ourcompany.com",
+			expected: true,
+		},
+		{
+			name:     "Domain outside of allowlist",
+			policy:   EmailDomainPolicy{Allowlist: []string{"ourcompany.com"}},
+			email:    "john@notourcompany.com",
+			expected: false,
+		},
+		{
+			name:     "Denylist wins over allowlist",
+			policy:   EmailDomainPolicy{Allowlist: []string{"ourcompany.com"}, Denylist: []string{"contractors.ourcompany.com"}},
+			email:    "john@contractors.ourcompany.com",
+			expected: false,
+		},
+		{
+			name:     "Disposable domain blocked",
+			policy:   EmailDomainPolicy{BlockDisposable: true},
+			email:    "john@mailinator.com",
+			expected: false,
+		},
+		{
+			name:     "Disposable domain allowed when blocking is disabled",
+			policy:   EmailDomainPolicy{},
+			email:    "john@mailinator.com",
+			expected: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, tc.policy.Allowed(tc.email))
+		})
+	}
+}
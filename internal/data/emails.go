@@ -0,0 +1,91 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+// email log statuses. sent and failed are recorded by the mailer, the rest are reported by the mail provider callbacks
+const (
+	EmailStatusSent       = "sent"
+	EmailStatusFailed     = "failed"
+	EmailStatusDelivered  = "delivered"
+	EmailStatusBounced    = "bounced"
+	EmailStatusComplained = "complained"
+)
+
+// user email statuses. addresses which bounced or complained shouldn't receive any more emails
+const (
+	UserEmailStatusOK         = "ok"
+	UserEmailStatusBounced    = "bounced"
+	UserEmailStatusComplained = "complained"
+)
+
+type EmailLogModel struct {
+	db *bun.DB
+}
+
+// EmailLog is a single send attempt of an email
+type EmailLog struct {
+	bun.BaseModel `bun:"table:email_log"`
+	ID            int64     `json:"id" bun:",pk,autoincrement,notnull,type:bigserial"`
+	MessageID     string    `json:"message_id" bun:",notnull"`
+	Recipient     string    `json:"recipient" bun:",type:citext,notnull"`
+	Template      string    `json:"template" bun:",notnull"`
+	Status        string    `json:"status" bun:",notnull"`
+	Error         string    `json:"error,omitempty" bun:",notnull"`
+	CreatedAt     time.Time `json:"created_at" bun:",type:timestamptz,notnull,default:current_timestamp"`
+	UpdatedAt     time.Time `json:"updated_at" bun:",type:timestamptz,notnull,default:current_timestamp"`
+}
+
+func (e *EmailLogModel) Insert(ctx context.Context, log *EmailLog) error {
+	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*3)
+	defer cancelFunc()
+	err := e.db.NewInsert().Model(log).Returning("id, created_at, updated_at").Scan(timeoutCtx, &log.ID, &log.CreatedAt, &log.UpdatedAt)
+	if err != nil {
+		return mapPgError(err)
+	}
+	return nil
+}
+
+// UpdateStatus updates the status of all the send attempts with the message id and returns their recipient
+func (e *EmailLogModel) UpdateStatus(ctx context.Context, messageID string, status string) (string, error) {
+	var recipients []string
+	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*3)
+	defer cancelFunc()
+	err := e.db.NewUpdate().Model((*EmailLog)(nil)).Set("status = ?", status).Set("updated_at = now()").Where("message_id = ?", messageID).Returning("recipient").Scan(timeoutCtx, &recipients)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return "", ErrorRecordNotFound
+		default:
+			return "", err
+		}
+	}
+	if len(recipients) == 0 {
+		return "", ErrorRecordNotFound
+	}
+	return recipients[0], nil
+}
+
+func (e *EmailLogModel) List(ctx context.Context, recipient string, status string, filters *Filters) ([]EmailLog, int, error) {
+	logs := []EmailLog{}
+	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*5)
+	defer cancelFunc()
+
+	query := e.db.NewSelect().Model(&logs).Where("(recipient = ? OR ? = '')", recipient, recipient).Where("(status = ? OR ? = '')", status, status)
+	count, err := paginate(timeoutCtx, query, filters)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, 0, ErrorRecordNotFound
+		default:
+			return nil, 0, err
+		}
+	}
+	return logs, count, nil
+}
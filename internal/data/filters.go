@@ -3,31 +3,54 @@ package data
 import (
 	"context"
 	"math"
+	"slices"
 	"strings"
 
+	"github.com/uptrace/bun"
 	"go.opentelemetry.io/otel"
 )
 
+// Count modes of the list queries. exact counts the matching records, estimate reads the planner
+// statistics of the whole table and none skips counting so the totals are omitted from the metadata.
+const (
+	CountExact    = "exact"
+	CountEstimate = "estimate"
+	CountNone     = "none"
+)
+
 type Filters struct {
 	Page         int
 	PageSize     int
 	Sort         string
 	SortSafeList []string
+	// SortNullsLast are the sort columns which may be null. Their nulls are sorted after the values in both directions.
+	SortNullsLast []string
+	// Count is one of the count modes. Empty means CountExact
+	Count string
 	PaginationMeta
 }
 
 type PaginationMeta struct {
-	FirstPage    int `json:"first_page,omitempty" example:"1"`
-	LastPage     int `json:"last_page,omitempty" example:"3"`
-	TotalRecords int `json:"total_records,omitempty" example:"30"`
-	PageSize     int `json:"page_size,omitempty" example:"10"`
-	CurrentPage  int `json:"current_page,omitempty" example:"1"`
+	FirstPage      int  `json:"first_page" example:"1"`
+	LastPage       *int `json:"last_page,omitempty" example:"3"`
+	TotalRecords   *int `json:"total_records,omitempty" example:"30"`
+	TotalEstimated bool `json:"total_estimated,omitempty" example:"false"`
+	PageSize       int  `json:"page_size" example:"10"`
+	CurrentPage    int  `json:"current_page" example:"1"`
 }
 
 func (f *Filters) ValidateFilters(v *Validator) {
-	v.Check(f.Page <= 10_000_000 && f.Page >= 1, "page", "page should be between 1 and 10,000,000")
-	v.Check(f.PageSize <= 100 && f.PageSize >= 1, "page_size", "page size should be between 1 and 100")
-	v.Check(In(f.Sort, f.SortSafeList...), "sort", "invalid sort value")
+	v.Apply("page", Between(f.Page, 1, 10_000_000))
+	v.Apply("page_size", Between(f.PageSize, 1, 100))
+	v.Apply("sort", OneOf(f.Sort, f.SortSafeList...))
+	v.Apply("count", OneOf(f.countMode(), CountNone, CountEstimate, CountExact))
+}
+
+func (f Filters) countMode() string {
+	if f.Count == "" {
+		return CountExact
+	}
+	return f.Count
 }
 
 func (f Filters) SortColumn() string {
@@ -46,6 +69,14 @@ func (f Filters) SortDirection() string {
 	return "ASC"
 }
 
+// sortNulls returns the placement of the nulls of the sort column, the default one of postgres when it's empty
+func (f Filters) sortNulls() string {
+	if slices.Contains(f.SortNullsLast, f.SortColumn()) {
+		return " NULLS LAST"
+	}
+	return ""
+}
+
 func (f Filters) limit() int {
 	return f.PageSize
 }
@@ -54,13 +85,70 @@ func (f Filters) offset() int {
 	return (f.Page - 1) * f.PageSize
 }
 
+// paginate scans the page of the query selected by the filters into dest and counts the records
+// as requested by the count mode. The returned count is 0 when counting is skipped.
+func paginate(ctx context.Context, query *bun.SelectQuery, filters *Filters, dest ...interface{}) (int, error) {
+	query = query.OrderExpr(filters.SortColumn() + " " + filters.SortDirection() + filters.sortNulls()).Limit(filters.limit()).Offset(filters.offset())
+	switch filters.countMode() {
+	case CountNone:
+		return 0, query.Scan(ctx, dest...)
+	case CountEstimate:
+		err := query.Scan(ctx, dest...)
+		if err != nil {
+			return 0, err
+		}
+		return estimateCount(ctx, query.DB(), query.GetTableName())
+	default:
+		return query.ScanAndCount(ctx, dest...)
+	}
+}
+
+// estimateCount returns the number of rows of the table from the planner statistics. It ignores the conditions of the query
+// and it's only as fresh as the last analyze of the table, which is good enough for paginating the big tables.
+func estimateCount(ctx context.Context, db *bun.DB, table string) (int, error) {
+	var estimate float64
+	err := db.NewSelect().ColumnExpr("reltuples").TableExpr("pg_class").Where("oid = to_regclass(?)", table).Scan(ctx, &estimate)
+	if err != nil {
+		return 0, err
+	}
+	// reltuples is -1 for the tables which have never been analyzed
+	return int(max(estimate, 0)), nil
+}
+
 func (f *Filters) PaginationMetaData(ctx context.Context, totalRecords int) PaginationMeta {
 	_, span := otel.Tracer("paginationMetaData.tracer").Start(ctx, "paginationMetaData.span")
 	defer span.End()
 	f.PaginationMeta.FirstPage = 1
 	f.PaginationMeta.CurrentPage = f.Page
-	f.PaginationMeta.LastPage = int(math.Ceil(float64(totalRecords) / float64(f.PageSize)))
-	f.PaginationMeta.TotalRecords = totalRecords
 	f.PaginationMeta.PageSize = f.PageSize
+	if f.countMode() == CountNone {
+		return f.PaginationMeta
+	}
+	lastPage := int(math.Ceil(float64(totalRecords) / float64(f.PageSize)))
+	f.PaginationMeta.LastPage = &lastPage
+	f.PaginationMeta.TotalRecords = &totalRecords
+	f.PaginationMeta.TotalEstimated = f.countMode() == CountEstimate
 	return f.PaginationMeta
 }
+
+// PageLink is a page related to the current one, rel is the relation type of the Link header like next
+type PageLink struct {
+	Rel  string
+	Page int
+}
+
+// Links returns the first, the previous, the next and the last pages of the current one. The next page is returned when
+// the last page isn't known since the total isn't counted, the page after the last one is empty.
+func (m PaginationMeta) Links() []PageLink {
+	links := []PageLink{{Rel: "first", Page: m.FirstPage}}
+	if m.CurrentPage > m.FirstPage {
+		links = append(links, PageLink{Rel: "prev", Page: m.CurrentPage - 1})
+	}
+	if m.LastPage == nil || m.CurrentPage < *m.LastPage {
+		links = append(links, PageLink{Rel: "next", Page: m.CurrentPage + 1})
+	}
+	if m.LastPage != nil {
+		links = append(links, PageLink{Rel: "last", Page: *m.LastPage})
+	}
+	return links
+}
@@ -0,0 +1,173 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+// statuses of the jobs. Jobs are queued when created, running once their worker picks them up
+// and either succeeded or failed when they're over.
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+)
+
+// types of the jobs
+const (
+	JobUserImport    = "user_import"
+	JobRetention     = "retention"
+	JobRatingsImport = "ratings_import"
+	JobSearchAlerts  = "search_alerts"
+)
+
+var JobStatuses = []string{JobQueued, JobRunning, JobSucceeded, JobFailed}
+var JobTypes = []string{JobUserImport, JobRetention, JobRatingsImport, JobSearchAlerts}
+
+type JobModel struct {
+	db *bun.DB
+}
+
+// Job tracks a long running operation executed in the background. The operation reports its progress on the job
+// and the items it fails to process in ItemErrors, so a failing item doesn't fail the whole job.
+type Job struct {
+	bun.BaseModel `bun:"table:jobs"`
+	ID            int64           `json:"id" bun:",pk,autoincrement,notnull,type:bigserial"`
+	Type          string          `json:"type" bun:",notnull"`
+	Status        string          `json:"status" bun:",notnull"`
+	CreatedBy     uuid.UUID       `json:"created_by" bun:",type:uuid,nullzero"`
+	Total         int             `json:"total" bun:",notnull"`
+	Processed     int             `json:"processed" bun:",notnull"`
+	Failed        int             `json:"failed" bun:",notnull"`
+	ItemErrors    []JobItemError  `json:"item_errors,omitempty" bun:",type:jsonb,notnull"`
+	Result        json.RawMessage `json:"result,omitempty" bun:",type:jsonb,nullzero"`
+	Error         string          `json:"error,omitempty" bun:",notnull"`
+	CreatedAt     time.Time       `json:"created_at" bun:",type:timestamptz,notnull,default:current_timestamp"`
+	StartedAt     *time.Time      `json:"started_at,omitempty" bun:",type:timestamptz,nullzero"`
+	FinishedAt    *time.Time      `json:"finished_at,omitempty" bun:",type:timestamptz,nullzero"`
+}
+
+// JobItemError is the failure of a single item of a job. Item identifies the item within the input of the job,
+// e.g. the row of an imported csv.
+type JobItemError struct {
+	Item   int               `json:"item"`
+	Key    string            `json:"key,omitempty"`
+	Errors map[string]string `json:"errors"`
+}
+
+// Insert creates the job in the queued status
+func (j *JobModel) Insert(ctx context.Context, job *Job) error {
+	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*3)
+	defer cancelFunc()
+	err := j.db.NewInsert().Model(job).
+		Column("type", "created_by", "total").
+		Returning("id, status, created_at").Scan(timeoutCtx, &job.ID, &job.Status, &job.CreatedAt)
+	return mapPgError(err)
+}
+
+func (j *JobModel) Get(ctx context.Context, id int64) (*Job, error) {
+	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*3)
+	defer cancelFunc()
+	job := &Job{}
+	err := j.db.NewSelect().Model(job).Where("id = ?", id).Scan(timeoutCtx)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrorRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return job, nil
+}
+
+// List returns the jobs created by the user, or by every user when createdBy is uuid.Nil. Empty jobType and status
+// don't filter. The item errors and the results are left out, they're only returned by Get.
+func (j *JobModel) List(ctx context.Context, createdBy uuid.UUID, jobType string, status string, filters *Filters) ([]Job, int, error) {
+	jobs := []Job{}
+	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*5)
+	defer cancelFunc()
+
+	query := j.db.NewSelect().Model(&jobs).ExcludeColumn("item_errors", "result")
+	if createdBy != uuid.Nil {
+		query = query.Where("created_by = ?", createdBy)
+	}
+	if jobType != "" {
+		query = query.Where("type = ?", jobType)
+	}
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	count, err := paginate(timeoutCtx, query, filters)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, 0, ErrorRecordNotFound
+		default:
+			return nil, 0, err
+		}
+	}
+	return jobs, count, nil
+}
+
+// Start moves the job to the running status with the number of items it's going to process
+func (j *JobModel) Start(ctx context.Context, id int64, total int) error {
+	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*3)
+	defer cancelFunc()
+	_, err := j.db.NewUpdate().Model((*Job)(nil)).
+		Set("status = ?", JobRunning).
+		Set("total = ?", total).
+		Set("started_at = now()").
+		Where("id = ?", id).Exec(timeoutCtx)
+	return mapPgError(err)
+}
+
+// Progress adds the processed and failed items to the counters of the job and appends the errors of the failed items
+func (j *JobModel) Progress(ctx context.Context, id int64, processed int, failed int, itemErrors []JobItemError) error {
+	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*3)
+	defer cancelFunc()
+	if itemErrors == nil {
+		itemErrors = []JobItemError{}
+	}
+	js, err := json.Marshal(itemErrors)
+	if err != nil {
+		return err
+	}
+	_, err = j.db.NewUpdate().Model((*Job)(nil)).
+		Set("processed = processed + ?", processed).
+		Set("failed = failed + ?", failed).
+		Set("item_errors = item_errors || ?::jsonb", string(js)).
+		Where("id = ?", id).Exec(timeoutCtx)
+	return mapPgError(err)
+}
+
+// Finish ends the job with its result. The job fails when jobErr isn't nil, its items may still have been processed partially.
+func (j *JobModel) Finish(ctx context.Context, id int64, result interface{}, jobErr error) error {
+	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*3)
+	defer cancelFunc()
+	status, errMsg := JobSucceeded, ""
+	if jobErr != nil {
+		status, errMsg = JobFailed, jobErr.Error()
+	}
+	query := j.db.NewUpdate().Model((*Job)(nil)).
+		Set("status = ?", status).
+		Set("error = ?", errMsg).
+		Set("finished_at = now()").
+		Where("id = ?", id)
+	if result != nil {
+		js, err := json.Marshal(result)
+		if err != nil {
+			return err
+		}
+		query = query.Set("result = ?::jsonb", string(js))
+	}
+	_, err := query.Exec(timeoutCtx)
+	return mapPgError(err)
+}
@@ -0,0 +1,145 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+const (
+	LoginTokenBearer  = "bearer"
+	LoginTokenJWT     = "jwt"
+	LoginTokenPASETO  = "paseto"
+	LoginTokenSession = "session"
+)
+
+type LoginEventModel struct {
+	db *bun.DB
+}
+
+// LoginEvent is the session metadata captured when an authentication token is issued to a user
+type LoginEvent struct {
+	bun.BaseModel `bun:"table:login_events"`
+	ID            int64      `json:"id" bun:",pk,autoincrement,notnull,type:bigserial"`
+	UserID        uuid.UUID  `json:"user_id" bun:",type:uuid,notnull"`
+	TokenType     string     `json:"token_type" bun:",notnull"`
+	RemoteAddr    string     `json:"remote_addr" bun:",notnull"`
+	UserAgent     string     `json:"user_agent" bun:",notnull"`
+	NewRemoteAddr bool       `json:"new_remote_addr" bun:",notnull"`
+	NewUserAgent  bool       `json:"new_user_agent" bun:",notnull"`
+	RevokeToken   string     `json:"-" bun:"-"`
+	RevokeHash    []byte     `json:"-" bun:",type:bytea,nullzero"`
+	RevokedAt     *time.Time `json:"revoked_at,omitempty" bun:",type:timestamptz,nullzero"`
+	CreatedAt     time.Time  `json:"created_at" bun:",type:timestamptz,notnull,default:current_timestamp"`
+}
+
+// Anomalous reports whether the login came from an address or a user agent which hasn't been seen for the user before
+func (e *LoginEvent) Anomalous() bool {
+	return e.NewRemoteAddr || e.NewUserAgent
+}
+
+// Record compares the login with the previous logins of the user and stores it.
+// The very first login of a user is never anomalous. Anomalous logins get a revoke token
+// which is returned in RevokeToken in plaintext and only its hash is stored.
+// The logins of a user are recorded one after another, so each of the concurrent logins from a new device is compared
+// with the ones recorded before it.
+func (l *LoginEventModel) Record(ctx context.Context, event *LoginEvent) error {
+	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*5)
+	defer cancelFunc()
+
+	err := l.db.RunInTx(timeoutCtx, nil, func(ctx context.Context, tx bun.Tx) error {
+		_, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock(hashtext(?))", "login_events:"+event.UserID.String())
+		if err != nil {
+			return err
+		}
+
+		var seen struct {
+			Total      int  `bun:"total"`
+			RemoteAddr bool `bun:"remote_addr"`
+			UserAgent  bool `bun:"user_agent"`
+		}
+		err = tx.NewSelect().Model((*LoginEvent)(nil)).
+			ColumnExpr("COUNT(*) AS total").
+			ColumnExpr("COALESCE(BOOL_OR(remote_addr = ?), FALSE) AS remote_addr", event.RemoteAddr).
+			ColumnExpr("COALESCE(BOOL_OR(user_agent = ?), FALSE) AS user_agent", event.UserAgent).
+			Where("user_id = ?", event.UserID).Scan(ctx, &seen)
+		if err != nil {
+			return err
+		}
+		if seen.Total > 0 {
+			event.NewRemoteAddr = !seen.RemoteAddr
+			event.NewUserAgent = !seen.UserAgent
+		}
+
+		if event.Anomalous() {
+			bs := make([]byte, 16)
+			_, err := rand.Read(bs)
+			if err != nil {
+				return err
+			}
+			event.RevokeToken = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(bs)
+			hash := sha256.Sum256([]byte(event.RevokeToken))
+			event.RevokeHash = hash[:]
+		}
+
+		return tx.NewInsert().Model(event).Returning("id, created_at").Scan(ctx, &event.ID, &event.CreatedAt)
+	})
+	return mapPgError(err)
+}
+
+// Revoke marks the login as revoked and deletes all the authentication tokens of its user.
+// JWTs are stateless and stay valid until they expire.
+func (l *LoginEventModel) Revoke(ctx context.Context, id int64, revokeToken string) error {
+	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*5)
+	defer cancelFunc()
+	hash := sha256.Sum256([]byte(revokeToken))
+	err := l.db.RunInTx(timeoutCtx, nil, func(ctx context.Context, tx bun.Tx) error {
+		var userID uuid.UUID
+		err := tx.NewUpdate().Model((*LoginEvent)(nil)).Set("revoked_at = now()").
+			Where("id = ? AND revoke_hash = ? AND revoked_at IS NULL", id, hash[:]).
+			Returning("user_id").Scan(ctx, &userID)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrorRecordNotFound
+			default:
+				return err
+			}
+		}
+		_, err = tx.NewDelete().Model((*Token)(nil)).Where("user_id = ? AND scope = ?", userID, AuthenticationScope).Exec(ctx)
+		return err
+	})
+	return mapPgError(err)
+}
+
+// List returns the login events of the user, or of every user when userID is uuid.Nil.
+func (l *LoginEventModel) List(ctx context.Context, userID uuid.UUID, anomalousOnly bool, filters *Filters) ([]LoginEvent, int, error) {
+	events := []LoginEvent{}
+	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*5)
+	defer cancelFunc()
+
+	query := l.db.NewSelect().Model(&events)
+	if userID != uuid.Nil {
+		query = query.Where("user_id = ?", userID)
+	}
+	if anomalousOnly {
+		query = query.Where("(new_remote_addr OR new_user_agent)")
+	}
+	count, err := paginate(timeoutCtx, query, filters)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, 0, ErrorRecordNotFound
+		default:
+			return nil, 0, err
+		}
+	}
+	return events, count, nil
+}
@@ -0,0 +1,155 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+// MovieMerge is the result of merging a duplicate movie into its canonical one. The counts are the rows moved to the canonical movie.
+type MovieMerge struct {
+	DuplicateID   int64 `json:"duplicate_id"`
+	CanonicalID   int64 `json:"canonical_id"`
+	WatchProgress int64 `json:"watch_progress"`
+	Assets        int64 `json:"assets"`
+	Shares        int64 `json:"shares"`
+	ShortLinks    int64 `json:"short_links"`
+}
+
+// Merge moves what refers to the duplicate movie over to the canonical one, then deletes the duplicate with a tombstone
+// pointing at the canonical movie and redirects its old id to the canonical movie. check RedirectModel
+//
+// The watch progress of a user who has watched both is the latest of the two. The short link of the duplicate is only kept
+// when the canonical movie has none, its code is redirected to the code of the canonical movie otherwise. The shares of
+// the duplicate keep working through the redirect of its id. check MovieShareModel.Access The external id and the external ratings of the duplicate fill in the missing ones of the canonical movie.
+// The canonical movie gets a new version. It returns ErrorRecordNotFound when any of the movies doesn't exist.
+func (m *MovieModel) Merge(ctx context.Context, duplicateID int64, canonicalID int64) (*Movie, *MovieMerge, error) {
+	if duplicateID < 1 || canonicalID < 1 {
+		return nil, nil, ErrorRecordNotFound
+	}
+	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*10)
+	defer cancelFunc()
+
+	merge := &MovieMerge{DuplicateID: duplicateID, CanonicalID: canonicalID}
+	canonical := &Movie{}
+	err := m.db.RunInTx(timeoutCtx, nil, func(ctx context.Context, tx bun.Tx) error {
+		err := lockChangeSeq(ctx, tx)
+		if err != nil {
+			return err
+		}
+		// both movies are locked in the order of their ids, so the concurrent merges of the same movies can't deadlock
+		movies := []Movie{}
+		err = tx.NewSelect().Model(&movies).Where("id IN (?, ?)", duplicateID, canonicalID).OrderExpr("id ASC").For("UPDATE").Scan(ctx)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		if len(movies) != 2 {
+			return ErrorRecordNotFound
+		}
+		duplicate := &movies[0]
+		*canonical = movies[1]
+		if duplicate.ID != duplicateID {
+			duplicate, *canonical = &movies[1], movies[0]
+		}
+
+		// the users who have watched both keep the progress they've made last
+		_, err = tx.NewRaw(`UPDATE watch_progress AS c SET position_seconds = d.position_seconds, completed = d.completed OR c.completed, updated_at = d.updated_at
+			FROM watch_progress AS d WHERE c.movie_id = ? AND d.movie_id = ? AND d.user_id = c.user_id AND d.updated_at > c.updated_at`, canonicalID, duplicateID).Exec(ctx)
+		if err != nil {
+			return err
+		}
+		merge.WatchProgress, err = moveRows(ctx, tx.NewUpdate().Table("watch_progress").Set("movie_id = ?", canonicalID).
+			Where("movie_id = ?", duplicateID).
+			Where("NOT EXISTS (SELECT 1 FROM watch_progress AS c WHERE c.movie_id = ? AND c.user_id = watch_progress.user_id)", canonicalID))
+		if err != nil {
+			return err
+		}
+		merge.Assets, err = moveRows(ctx, tx.NewUpdate().Table("movie_assets").Set("movie_id = ?", canonicalID).Where("movie_id = ?", duplicateID))
+		if err != nil {
+			return err
+		}
+		merge.Shares, err = moveRows(ctx, tx.NewUpdate().Table("movie_shares").Set("movie_id = ?", canonicalID).Where("movie_id = ?", duplicateID))
+		if err != nil {
+			return err
+		}
+		merge.ShortLinks, err = moveRows(ctx, tx.NewUpdate().Table("short_links").Set("movie_id = ?", canonicalID).
+			Where("movie_id = ?", duplicateID).
+			Where("NOT EXISTS (SELECT 1 FROM short_links AS c WHERE c.movie_id = ?)", canonicalID))
+		if err != nil {
+			return err
+		}
+		// the code of the duplicate which couldn't be moved is redirected to the code of the canonical movie. check Hit
+		links := []ShortLink{}
+		err = tx.NewSelect().Model(&links).Where("movie_id IN (?, ?)", duplicateID, canonicalID).Scan(ctx)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		if len(links) == 2 {
+			old, kept := links[0], links[1]
+			if old.MovieID != duplicateID {
+				old, kept = kept, old
+			}
+			err = recordRedirect(ctx, tx, RedirectShortLinks, old.Code, kept.Code, RedirectMerged)
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err = tx.NewDelete().Model((*Movie)(nil)).Where("id = ?", duplicateID).Exec(ctx)
+		if err != nil {
+			return err
+		}
+		_, err = tx.NewInsert().Model(&MovieTombstone{MovieID: duplicateID, MergedInto: &canonicalID}).
+			ExcludeColumn("change_seq", "deleted_at").
+			On("CONFLICT (movie_id) DO UPDATE").
+			Set("change_seq = " + nextChangeSeq).
+			Set("deleted_at = now()").
+			Set("merged_into = EXCLUDED.merged_into").
+			Set("external_source = NULL").
+			Set("external_id = NULL").
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+		// the movies merged into the duplicate before resolve to the canonical movie right away
+		_, err = tx.NewUpdate().Model((*MovieTombstone)(nil)).Set("merged_into = ?", canonicalID).Where("merged_into = ?", duplicateID).Exec(ctx)
+		if err != nil {
+			return err
+		}
+		err = recordRedirect(ctx, tx, RedirectMovies, strconv.FormatInt(duplicateID, 10), strconv.FormatInt(canonicalID, 10), RedirectMerged)
+		if err != nil {
+			return err
+		}
+
+		if canonical.ExternalSource == "" && duplicate.ExternalSource != "" {
+			canonical.ExternalSource, canonical.ExternalID = duplicate.ExternalSource, duplicate.ExternalID
+		}
+		if canonical.IMDbRating == nil && canonical.RottenTomatoesScore == nil {
+			canonical.IMDbRating, canonical.RottenTomatoesScore = duplicate.IMDbRating, duplicate.RottenTomatoesScore
+			canonical.ExternalRatingsUpdatedAt = duplicate.ExternalRatingsUpdatedAt
+		}
+		return tx.NewUpdate().Model(canonical).
+			Column("external_source", "external_id", "imdb_rating", "rotten_tomatoes_score", "external_ratings_updated_at", "updated_at", "version").
+			Value("version", "version + 1").
+			Value("change_seq", nextChangeSeq).
+			WherePK().
+			Returning("version").Scan(ctx, &canonical.Version)
+	})
+	if err != nil {
+		return nil, nil, mapPgError(err)
+	}
+	return canonical, merge, nil
+}
+
+// moveRows runs the update of the rows referring to a movie and returns the number of the moved rows
+func moveRows(ctx context.Context, query *bun.UpdateQuery) (int64, error) {
+	result, err := query.Exec(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -1,16 +1,43 @@
 package data
 
-import "github.com/uptrace/bun"
+import (
+	"context"
+	"errors"
+
+	"github.com/uptrace/bun"
+)
 
 type Models struct {
 	Movies      MovieModel
 	Users       UserModel
 	Tokens      TokenModel
 	Permissions PermissionModel
+	EmailLogs   EmailLogModel
+	MovieShares MovieShareModel
+	Usage       UsageModel
+	LoginEvents LoginEventModel
+	AuditLogs   AuditLogModel
+	Schema      SchemaModel
+	Progress    WatchProgressModel
+	MovieAssets MovieAssetModel
+	ShortLinks  ShortLinkModel
+	Jobs        JobModel
+	Sessions    SessionModel
+	Stats       StatsModel
+	Retention   RetentionModel
+	Outbox      OutboxModel
+	Devices     DeviceModel
+	Redirects   RedirectModel
+	Searches    SavedSearchModel
+	Teams       TeamModel
+
+	db *bun.DB
 }
 
 func NewModels(db *bun.DB) *Models {
 	db.RegisterModel((*UserPermission)(nil))
+	// the teams drop the cached permissions of their members when they change
+	perms := newPermsCache()
 	return &Models{
 		Movies: MovieModel{
 			db,
@@ -23,6 +50,79 @@ func NewModels(db *bun.DB) *Models {
 		},
 		Permissions: PermissionModel{
 			db,
+			perms,
+		},
+		EmailLogs: EmailLogModel{
+			db,
+		},
+		MovieShares: MovieShareModel{
+			db,
+		},
+		Usage: UsageModel{
+			db,
+		},
+		LoginEvents: LoginEventModel{
+			db,
+		},
+		AuditLogs: AuditLogModel{
+			db,
+		},
+		Schema: SchemaModel{
+			db,
+		},
+		Progress: WatchProgressModel{
+			db,
+		},
+		MovieAssets: MovieAssetModel{
+			db,
+		},
+		ShortLinks: ShortLinkModel{
+			db,
+		},
+		Jobs: JobModel{
+			db,
 		},
+		Sessions: SessionModel{
+			db,
+		},
+		Stats: StatsModel{
+			db,
+		},
+		Retention: RetentionModel{
+			db,
+		},
+		Outbox: OutboxModel{
+			db,
+		},
+		Devices: DeviceModel{
+			db,
+		},
+		Redirects: RedirectModel{
+			db,
+		},
+		Searches: SavedSearchModel{
+			db,
+		},
+		Teams: TeamModel{
+			db,
+			perms,
+		},
+		db: db,
+	}
+}
+
+// RunInTx runs fn in a single transaction, the writes of the models given tx by fn are rolled back together when it fails.
+// Postgres errors are returned as the domain errors of mapPgError.
+func (m *Models) RunInTx(ctx context.Context, fn func(ctx context.Context, tx bun.Tx) error) error {
+	return mapPgError(m.db.RunInTx(ctx, nil, fn))
+}
+
+// RetryOnEditConflict runs update once more when it fails with ErrEditConflict. update must read the row again
+// and reapply its changes on every run, otherwise the retry conflicts the same way.
+func RetryOnEditConflict(update func() error) error {
+	err := update()
+	if errors.Is(err, ErrEditConflict) {
+		err = update()
 	}
+	return err
 }
@@ -5,6 +5,7 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
@@ -40,6 +41,51 @@ type Movie struct {
 	Genres []string `json:"genres,omitempty" bun:"genres,array,notnull" example:"adventure,action"`
 	// Version number will be increased each time the movies is updated
 	Version int32 `json:"version" bun:",notnull,default:1" example:"1"`
+	// UpdatedAt is bumped on every write of the movie. check BeforeAppendModel
+	UpdatedAt time.Time `json:"updated_at" bun:",notnull,nullzero,default:current_timestamp,type:timestamp(0) with time zone" swaggertype:"string" example:"2024-01-02T15:04:05Z"`
+	// ExternalSource is the catalog the movie is synced from, imdb or tmdb.
+	ExternalSource string `json:"external_source,omitempty" bun:",nullzero" example:"imdb"`
+	// ExternalID is the identifier of the movie in its external catalog.
+	ExternalID string `json:"external_id,omitempty" bun:",nullzero" example:"tt4154756"`
+	// IMDbRating is the score of the movie on imdb out of 10. It's imported from the ratings provider, it isn't
+	// rated by the users of the api. check ExternalRatings
+	IMDbRating *float64 `json:"imdb_rating,omitempty" bun:"imdb_rating" example:"8.4"`
+	// RottenTomatoesScore is the tomatometer of the movie in percent, imported along with IMDbRating
+	RottenTomatoesScore *int32 `json:"rotten_tomatoes_score,omitempty" bun:"rotten_tomatoes_score" example:"85"`
+	// ExternalRatingsUpdatedAt is when the external ratings were last imported. The movies never imported lack it.
+	ExternalRatingsUpdatedAt *time.Time `json:"external_ratings_updated_at,omitempty" bun:"external_ratings_updated_at,type:timestamp(0) with time zone" swaggertype:"string" example:"2024-01-02T15:04:05Z"`
+}
+
+// ExternalRatings are the scores of a movie published by the third parties. They're only written by the ratings
+// import, the create and update of the movies leave them untouched. A nil score isn't published by its source.
+type ExternalRatings struct {
+	IMDbRating          *float64
+	RottenTomatoesScore *int32
+}
+
+// externalRatingColumns are owned by the ratings import. check ExternalRatings
+var externalRatingColumns = []string{"imdb_rating", "rotten_tomatoes_score", "external_ratings_updated_at"}
+
+const (
+	ExternalSourceIMDB = "imdb"
+	ExternalSourceTMDB = "tmdb"
+)
+
+var (
+	imdbIDRX = regexp.MustCompile(`^tt\d{7,10}$`)
+	tmdbIDRX = regexp.MustCompile(`^\d{1,10}$`)
+)
+
+var _ bun.BeforeAppendModelHook = (*Movie)(nil)
+
+// BeforeAppendModel bumps updated_at whenever the movie is inserted or updated through its model.
+// The column keeps whole seconds so the time is truncated to return the same value as the stored one.
+func (m *Movie) BeforeAppendModel(ctx context.Context, query bun.Query) error {
+	switch query.(type) {
+	case *bun.InsertQuery, *bun.UpdateQuery:
+		m.UpdatedAt = time.Now().Truncate(time.Second)
+	}
+	return nil
 }
 
 type MovieModel struct {
@@ -53,11 +99,72 @@ func (m *MovieModel) Insert(ctx context.Context, movie *Movie) error {
 	defer cancelFunc()
 	err := m.db.NewInsert().Model(movie).Returning("id, created_at, version").Scan(timeoutCtx, args...)
 	if err != nil {
-		return err
+		return mapPgError(err)
 	}
 	return nil
 }
 
+// InsertMany inserts the movies in chunks and fills in their generated columns. Only the movies inserted with
+// their id set can conflict. With ConflictSkip the existing movies are left untouched and reported by the returned count.
+func (m *MovieModel) InsertMany(ctx context.Context, movies []*Movie, opts BulkInsertOptions) (int, error) {
+	if len(movies) == 0 {
+		return 0, nil
+	}
+	return insertChunks(ctx, m.db, movies, opts.chunkSize(), func(ctx context.Context, tx bun.Tx, chunk []*Movie) (int, error) {
+		query := tx.NewInsert().Model(&chunk)
+		switch opts.onConflict() {
+		case ConflictSkip:
+			// skipped movies aren't returned. rows are returned in the insert order so the generated ids are
+			// handed out in order and the movies which already had their id are matched by it.
+			returned := []Movie{}
+			err := query.On("CONFLICT (id) DO NOTHING").Returning("id, created_at, version").Scan(ctx, &returned)
+			if err != nil && !errors.Is(err, sql.ErrNoRows) {
+				return 0, err
+			}
+			presetIDs := make(map[int64]bool, len(chunk))
+			for _, movie := range chunk {
+				if movie.ID != 0 {
+					presetIDs[movie.ID] = true
+				}
+			}
+			byID := make(map[int64]Movie, len(returned))
+			generated := []Movie{}
+			for _, movie := range returned {
+				if presetIDs[movie.ID] {
+					byID[movie.ID] = movie
+				} else {
+					generated = append(generated, movie)
+				}
+			}
+			for _, movie := range chunk {
+				inserted, found := byID[movie.ID]
+				if movie.ID == 0 && len(generated) > 0 {
+					inserted, generated, found = generated[0], generated[1:], true
+				}
+				if found {
+					movie.ID, movie.CreatedAt, movie.Version = inserted.ID, inserted.CreatedAt, inserted.Version
+				}
+			}
+			return len(returned), nil
+		case ConflictUpdate:
+			query = query.On("CONFLICT (id) DO UPDATE").
+				Set("title = EXCLUDED.title").
+				Set("year = EXCLUDED.year").
+				Set("runtime = EXCLUDED.runtime").
+				Set("genres = EXCLUDED.genres").
+				Set("updated_at = EXCLUDED.updated_at").
+				Set("change_seq = " + nextChangeSeq).
+				Set("version = ?TableAlias.version + 1")
+		}
+		result, err := query.Returning("id, created_at, version").Exec(ctx)
+		if err != nil {
+			return 0, err
+		}
+		n, _ := result.RowsAffected()
+		return int(n), nil
+	})
+}
+
 func (m *MovieModel) Delete(ctx context.Context, id int64) error {
 	if id < 1 {
 		return ErrorRecordNotFound
@@ -65,27 +172,129 @@ func (m *MovieModel) Delete(ctx context.Context, id int64) error {
 	// define the timeouts context exactly before the process that needs that context to make sure only that specific process uses the countdown
 	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*5)
 	defer cancelFunc()
-	result, err := m.db.NewDelete().Model((*Movie)(nil)).Where("id = ?", id).Exec(timeoutCtx)
-	if ok, _ := result.RowsAffected(); ok == 0 {
-		return ErrorRecordNotFound
-	}
-	if err != nil {
-		return err
-	}
-	return nil
+	// the tombstone is written along with the delete so the sync clients can't miss the deletion. check Changes
+	err := m.db.RunInTx(timeoutCtx, nil, func(ctx context.Context, tx bun.Tx) error {
+		err := lockChangeSeq(ctx, tx)
+		if err != nil {
+			return err
+		}
+		tombstone := &MovieTombstone{MovieID: id}
+		var externalSource, externalID sql.NullString
+		err = tx.NewDelete().Model((*Movie)(nil)).Where("id = ?", id).
+			Returning("external_source, external_id").
+			Scan(ctx, &externalSource, &externalID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrorRecordNotFound
+			}
+			return err
+		}
+		tombstone.ExternalSource, tombstone.ExternalID = externalSource.String, externalID.String
+		_, err = tx.NewInsert().Model(tombstone).
+			ExcludeColumn("change_seq", "deleted_at").
+			On("CONFLICT (movie_id) DO UPDATE").
+			Set("change_seq = " + nextChangeSeq).
+			Set("deleted_at = now()").
+			Set("merged_into = NULL").
+			Set("external_source = EXCLUDED.external_source").
+			Set("external_id = EXCLUDED.external_id").
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+		return dropRedirects(ctx, tx, RedirectMovies, strconv.FormatInt(id, 10))
+	})
+	return mapPgError(err)
 }
 
+// Update writes the movie only if its row still has the version the movie was read with and increases the version.
+// It returns ErrEditConflict when the movie has been changed in the meantime and ErrorRecordNotFound when it's been deleted.
+// movie is left untouched on failure so it can be read again and retried. check RetryOnEditConflict
 func (m *MovieModel) Update(ctx context.Context, id int64, movie *Movie) error {
-	args := []interface{}{&movie.CreatedAt, &movie.Version}
-	movie.Version += 1
 	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*5)
 	defer cancelFunc()
-	err := m.db.NewUpdate().Model(movie).Where("id = ?", id).Where("version = ?", movie.Version-1).Returning("created_at, version").Scan(timeoutCtx, args...)
+	var createdAt time.Time
+	var version int32
+	err := m.db.NewUpdate().Model(movie).
+		ExcludeColumn(append([]string{"id", "created_at"}, externalRatingColumns...)...).
+		Value("version", "version + 1").
+		Value("change_seq", nextChangeSeq).
+		Where("id = ? AND version = ?", id, movie.Version).
+		Returning("created_at, version").Scan(timeoutCtx, &createdAt, &version)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
+			exists, err := m.db.NewSelect().Model((*Movie)(nil)).Where("id = ?", id).Exists(timeoutCtx)
+			if err != nil {
+				return err
+			}
+			if !exists {
+				return ErrorRecordNotFound
+			}
 			return ErrEditConflict
 		default:
+			return mapPgError(err)
+		}
+	}
+	movie.CreatedAt, movie.Version = createdAt, version
+	return nil
+}
+
+// UpsertByExternalID creates the movie of the external id or updates the existing one in a single statement,
+// so concurrent syncs of the same movie can't race. It reports whether the movie has been created.
+// The version of an existing movie is only increased when its values actually change.
+// The deleted movies of the same external id are redirected to the created movie along with the insert. check RedirectModel
+func (m *MovieModel) UpsertByExternalID(ctx context.Context, movie *Movie) (bool, error) {
+	var created bool
+	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*5)
+	defer cancelFunc()
+	err := m.db.RunInTx(timeoutCtx, nil, func(ctx context.Context, tx bun.Tx) error {
+		err := tx.NewInsert().Model(movie).
+			ExcludeColumn("id", "created_at", "version").
+			On("CONFLICT (external_source, external_id) DO UPDATE").
+			Set("title = EXCLUDED.title").
+			Set("year = EXCLUDED.year").
+			Set("runtime = EXCLUDED.runtime").
+			Set("genres = EXCLUDED.genres").
+			Set("updated_at = EXCLUDED.updated_at").
+			Set("change_seq = "+nextChangeSeq).
+			Set("version = ?TableAlias.version + 1").
+			Where("(?TableAlias.title, ?TableAlias.year, ?TableAlias.runtime, ?TableAlias.genres) IS DISTINCT FROM (EXCLUDED.title, EXCLUDED.year, EXCLUDED.runtime, EXCLUDED.genres)").
+			Returning("id, created_at, version, (xmax = 0) AS created").
+			Scan(ctx, &movie.ID, &movie.CreatedAt, &movie.Version, &created)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				// nothing has changed so the existing movie hasn't been returned by the upsert
+				return tx.NewSelect().Model((*Movie)(nil)).Column("id", "created_at", "version").
+					Where("external_source = ? AND external_id = ?", movie.ExternalSource, movie.ExternalID).
+					Scan(ctx, &movie.ID, &movie.CreatedAt, &movie.Version)
+			}
+			return err
+		}
+		if !created {
+			return nil
+		}
+		return redirectReimported(ctx, tx, movie)
+	})
+	if err != nil {
+		return false, mapPgError(err)
+	}
+	return created, nil
+}
+
+// redirectReimported redirects the deleted movies of the same external id to the movie which has been imported again
+func redirectReimported(ctx context.Context, tx bun.Tx, movie *Movie) error {
+	oldIDs := []int64{}
+	err := tx.NewSelect().Model((*MovieTombstone)(nil)).Column("movie_id").
+		Where("external_source = ? AND external_id = ?", movie.ExternalSource, movie.ExternalID).
+		Where("movie_id <> ?", movie.ID).
+		Scan(ctx, &oldIDs)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	for _, oldID := range oldIDs {
+		err := recordRedirect(ctx, tx, RedirectMovies, strconv.FormatInt(oldID, 10), strconv.FormatInt(movie.ID, 10), RedirectReimported)
+		if err != nil {
 			return err
 		}
 	}
@@ -111,19 +320,62 @@ func (m *MovieModel) Select(ctx context.Context, id int64) (*Movie, error) {
 	return &nMovie, nil
 }
 
-func (m *MovieModel) List(ctx context.Context, title string, genres []string, filters *Filters) ([]Movie, int, error) {
-	args := []struct {
-		Count int
-		Movie
-	}{}
+// MovieListFilter narrows down the movie list. The zero value of a field doesn't filter.
+// It's stored along with the saved searches as json. check SavedSearch
+type MovieListFilter struct {
+	Title  string   `json:"title,omitempty"`
+	Genres []string `json:"genres,omitempty"`
+	// UpdatedSince limits the list to the movies updated at or after it, so sync clients can poll the changes
+	UpdatedSince time.Time `json:"-"`
+	// YearFrom and YearTo are inclusive
+	YearFrom   int `json:"year_from,omitempty"`
+	YearTo     int `json:"year_to,omitempty"`
+	RuntimeMax int `json:"runtime_max,omitempty"`
+	// IMDbRatingMin limits the list to the movies rated at least as much on imdb. check ExternalRatings
+	IMDbRatingMin float64 `json:"imdb_rating_min,omitempty"`
+	// RottenTomatoesScoreMin limits the list to the movies with at least the tomatometer in percent
+	RottenTomatoesScoreMin int `json:"rotten_tomatoes_score_min,omitempty"`
+}
+
+// apply narrows down the select query of the movies by the filter
+func (filter *MovieListFilter) apply(query *bun.SelectQuery) *bun.SelectQuery {
+	if filter.Title != "" {
+		query = query.Where("title_tsvector @@ to_tsquery('simple',?)", filter.Title)
+	}
+	if len(filter.Genres) > 0 {
+		query = query.Where("genres @> ?", pgdialect.Array(filter.Genres))
+	}
+	if !filter.UpdatedSince.IsZero() {
+		query = query.Where("updated_at >= ?", filter.UpdatedSince)
+	}
+	if filter.YearFrom != 0 {
+		query = query.Where("year >= ?", filter.YearFrom)
+	}
+	if filter.YearTo != 0 {
+		query = query.Where("year <= ?", filter.YearTo)
+	}
+	if filter.RuntimeMax != 0 {
+		query = query.Where("runtime <= ?", filter.RuntimeMax)
+	}
+	if filter.IMDbRatingMin != 0 {
+		query = query.Where("imdb_rating >= ?", filter.IMDbRatingMin)
+	}
+	if filter.RottenTomatoesScoreMin != 0 {
+		query = query.Where("rotten_tomatoes_score >= ?", filter.RottenTomatoesScoreMin)
+	}
+	return query
+}
+
+// List returns the movies matching the filter
+func (m *MovieModel) List(ctx context.Context, filter MovieListFilter, filters *Filters) ([]Movie, int, error) {
 	nMovies := []Movie{}
 
 	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*5)
 	defer cancelFunc()
 
-	orderQuery := filters.SortColumn() + " " + filters.SortDirection()
-	err := m.db.NewSelect().Model((*Movie)(nil)).ColumnExpr("COUNT(*) OVER(),*").Where("(title_tsvector @@ to_tsquery('simple',?)) OR (? = '')", title, title).Where("(genres @> ? OR ? = '{}')", pgdialect.Array(genres), pgdialect.Array(genres)).OrderExpr(orderQuery).Limit(filters.limit()).Offset(filters.offset()).Scan(timeoutCtx, &args)
-	if err != nil || len(args) == 0 {
+	query := filter.apply(m.db.NewSelect().Model(&nMovies))
+	count, err := paginate(timeoutCtx, query, filters)
+	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
 			return nil, 0, ErrorRecordNotFound
@@ -131,10 +383,151 @@ func (m *MovieModel) List(ctx context.Context, title string, genres []string, fi
 			return nil, 0, err
 		}
 	}
-	for _, v := range args {
-		nMovies = append(nMovies, v.Movie)
+	return nMovies, count, nil
+}
+
+// The facets of the movie list
+const (
+	FacetGenres     = "genres"
+	FacetYearDecade = "year_decade"
+)
+
+var Facets = []string{FacetGenres, FacetYearDecade}
+
+// FacetCount is the number of the movies sharing the value of a facet. The decades are valued by their first year.
+type FacetCount struct {
+	Value string `json:"value" bun:"value" example:"action"`
+	Count int64  `json:"count" bun:"count" example:"12"`
+}
+
+// MovieFacets holds the counts of the facets asked for
+type MovieFacets struct {
+	Genres     []FacetCount `json:"genres,omitempty"`
+	YearDecade []FacetCount `json:"year_decade,omitempty"`
+}
+
+// Facets counts the movies matching the filter by the values of each of the facets. The genres come by their number
+// of movies and the decades in their order.
+func (m *MovieModel) Facets(ctx context.Context, filter MovieListFilter, facets []string) (*MovieFacets, error) {
+	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*5)
+	defer cancelFunc()
+
+	result := &MovieFacets{}
+	for _, facet := range facets {
+		counts := []FacetCount{}
+		query := filter.apply(m.db.NewSelect().Model((*Movie)(nil))).ColumnExpr("COUNT(*) AS count").GroupExpr("value")
+		switch facet {
+		case FacetGenres:
+			query = query.TableExpr("unnest(movie.genres) AS value").ColumnExpr("value").OrderExpr("count DESC, value")
+		case FacetYearDecade:
+			query = query.ColumnExpr("(year / 10 * 10)::text AS value").OrderExpr("value")
+		default:
+			return nil, fmt.Errorf("unknown movie facet %q", facet)
+		}
+		err := query.Scan(timeoutCtx, &counts)
+		if err != nil {
+			return nil, err
+		}
+		switch facet {
+		case FacetGenres:
+			result.Genres = counts
+		case FacetYearDecade:
+			result.YearDecade = counts
+		}
+	}
+	return result, nil
+}
+
+// MovieSuggestion is the short form of a movie the titles are autocompleted with
+type MovieSuggestion struct {
+	ID    int64  `json:"id" bun:"id" example:"1"`
+	Title string `json:"title" bun:"title" example:"avengers"`
+	Year  int32  `json:"year" bun:"year" example:"2018"`
+}
+
+// Autocomplete returns the movies whose titles contain a word the most similar to the prefix, by their trigrams.
+// The closest titles come first.
+func (m *MovieModel) Autocomplete(ctx context.Context, prefix string, limit int) ([]MovieSuggestion, error) {
+	suggestions := []MovieSuggestion{}
+	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*3)
+	defer cancelFunc()
+	// <% is backed by the trigram index of the titles, unlike word_similarity() itself
+	err := m.db.NewSelect().Model((*Movie)(nil)).
+		Column("id", "title", "year").
+		Where("? <% title", prefix).
+		OrderExpr("word_similarity(?, title) DESC, title, id", prefix).
+		Limit(limit).
+		Scan(timeoutCtx, &suggestions)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
 	}
-	return nMovies, args[0].Count, nil
+	return suggestions, nil
+}
+
+// Recent returns the most recently added movies, newest first. An empty genre matches every movie.
+func (m *MovieModel) Recent(ctx context.Context, genre string, limit int) ([]Movie, error) {
+	nMovies := []Movie{}
+	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*5)
+	defer cancelFunc()
+	err := m.db.NewSelect().Model(&nMovies).
+		Where("(genres @> ? OR ? = '')", pgdialect.Array([]string{genre}), genre).
+		OrderExpr("created_at DESC, id DESC").
+		Limit(limit).Scan(timeoutCtx)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	return nMovies, nil
+}
+
+// StaleRatings returns the imdb movies whose external ratings haven't been imported since before, the never imported ones first.
+// The ratings provider only knows the movies by their imdb id.
+func (m *MovieModel) StaleRatings(ctx context.Context, before time.Time, limit int) ([]Movie, error) {
+	nMovies := []Movie{}
+	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*10)
+	defer cancelFunc()
+	err := m.db.NewSelect().Model(&nMovies).
+		Where("external_source = ?", ExternalSourceIMDB).
+		Where("(external_ratings_updated_at IS NULL OR external_ratings_updated_at < ?)", before).
+		OrderExpr("external_ratings_updated_at ASC NULLS FIRST, id ASC").
+		Limit(limit).Scan(timeoutCtx)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	return nMovies, nil
+}
+
+// UpdateRatings stores the external ratings of the movie. The version isn't increased since the ratings can't conflict
+// with the edits of the clients, but the movie is still reported to the sync clients as changed. check Changes
+func (m *MovieModel) UpdateRatings(ctx context.Context, id int64, ratings ExternalRatings) error {
+	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*5)
+	defer cancelFunc()
+	result, err := m.db.NewUpdate().Model((*Movie)(nil)).
+		Set("imdb_rating = ?", ratings.IMDbRating).
+		Set("rotten_tomatoes_score = ?", ratings.RottenTomatoesScore).
+		Set("external_ratings_updated_at = now()").
+		Set("updated_at = now()").
+		Set("change_seq = "+nextChangeSeq).
+		Where("id = ?", id).
+		Exec(timeoutCtx)
+	if err != nil {
+		return mapPgError(err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrorRecordNotFound
+	}
+	return nil
+}
+
+// IDs returns the ids of the movies in ascending order, at most limit of them
+func (m *MovieModel) IDs(ctx context.Context, limit int) ([]int64, error) {
+	ids := []int64{}
+	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*10)
+	defer cancelFunc()
+	err := m.db.NewSelect().Model((*Movie)(nil)).Column("id").OrderExpr("id ASC").Limit(limit).Scan(timeoutCtx, &ids)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	return ids, nil
 }
 
 type Runtime int32
@@ -164,15 +557,25 @@ func (r *Runtime) UnmarshalJSON(jsonValue []byte) error {
 }
 
 func (m Movie) Validator(nValidator *Validator) {
-	nValidator.Check(m.Title != "", "title", "must be provided")
-	nValidator.Check(len(m.Title) <= 500, "title", "must be less than 500 bytes long")
-	nValidator.Check(m.Year != 0, "year", "year should be specified")
-	nValidator.Check(m.Year >= 1888, "year", "year must be after 1888")
-	nValidator.Check(m.Year < int32(time.Now().Year()), "year", "year must be in future")
-	nValidator.Check(m.Runtime != 0, "runtime", "runtime should be specified")
-	nValidator.Check(m.Runtime > 0, "runtime", "runtime should be a positive integer")
-	nValidator.Check(m.Genres != nil, "genres", "genres should be specified")
-	nValidator.Check(len(m.Genres) >= 1, "genres", "genres must at least have one element")
-	nValidator.Check(len(m.Genres) <= 5, "genres", "must not contain more than 5 genres")
-	nValidator.Check(Unique(m.Genres), "genres", "duplicate value in genres")
+	nValidator.Apply("title", Required(m.Title), MaxLen(m.Title, 500))
+	nValidator.CheckCode(m.Year != 0, "year", CodeRequired, "must be provided")
+	// movies can't be from the future
+	nValidator.Apply("year", Between(int(m.Year), 1888, time.Now().Year()))
+	nValidator.CheckCode(m.Runtime != 0, "runtime", CodeRequired, "must be provided")
+	nValidator.CheckCode(m.Runtime > 0, "runtime", CodeOutOfRange, "must be a positive integer")
+	nValidator.CheckCode(m.Genres != nil, "genres", CodeRequired, "must be provided")
+	nValidator.Apply("genres", MinItems(1, len(m.Genres)), MaxItems(5, len(m.Genres)), UniqueItems(m.Genres))
+	for i, genre := range m.Genres {
+		nValidator.Apply(Path("genres", i), Required(genre), MaxLen(genre, 100))
+	}
+}
+
+func ValidateExternalID(v *Validator, source string, id string) {
+	v.Apply("source", OneOf(source, ExternalSourceIMDB, ExternalSourceTMDB))
+	switch source {
+	case ExternalSourceIMDB:
+		v.Apply("id", Pattern(id, imdbIDRX, "must be an imdb id like tt4154756"))
+	case ExternalSourceTMDB:
+		v.Apply("id", Pattern(id, tmdbIDRX, "must be a numeric tmdb id"))
+	}
 }
@@ -0,0 +1,99 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+// topics of the outbox events
+const (
+	// OutboxUserWelcome sends the welcome mail with the activation token to a newly registered user
+	OutboxUserWelcome = "user.welcome"
+)
+
+type OutboxModel struct {
+	db *bun.DB
+}
+
+// OutboxEvent is a side effect committed in the same transaction as the change causing it. The dispatcher processes
+// the pending events at least once, so their handlers must tolerate running again for the same event.
+type OutboxEvent struct {
+	bun.BaseModel `bun:"table:outbox"`
+	ID            int64           `json:"id" bun:",pk,autoincrement,notnull,type:bigserial"`
+	Topic         string          `json:"topic" bun:",notnull"`
+	Payload       json.RawMessage `json:"payload" bun:",type:jsonb,notnull"`
+	Attempts      int             `json:"attempts" bun:",notnull"`
+	LastError     string          `json:"last_error,omitempty" bun:",notnull"`
+	CreatedAt     time.Time       `json:"created_at" bun:",type:timestamptz,notnull,default:current_timestamp"`
+	AvailableAt   time.Time       `json:"available_at" bun:",type:timestamptz,notnull,default:current_timestamp"`
+	ProcessedAt   *time.Time      `json:"processed_at,omitempty" bun:",type:timestamptz,nullzero"`
+	FailedAt      *time.Time      `json:"failed_at,omitempty" bun:",type:timestamptz,nullzero"`
+}
+
+// UserWelcomePayload is the payload of the OutboxUserWelcome events
+type UserWelcomePayload struct {
+	UserID uuid.UUID `json:"user_id"`
+}
+
+// enqueueOutbox adds the event to the outbox through db, which is the transaction of the change the event belongs to
+func enqueueOutbox(ctx context.Context, db bun.IDB, topic string, payload interface{}) error {
+	js, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	_, err = db.NewInsert().Model(&OutboxEvent{Topic: topic, Payload: js}).Column("topic", "payload").Exec(ctx)
+	return err
+}
+
+// Claim returns up to limit pending events and hides them from the other dispatchers for lease, so the replicas
+// don't process the same events. The events of a dispatcher dying in the middle are claimed again once their lease is over.
+func (o *OutboxModel) Claim(ctx context.Context, limit int, lease time.Duration) ([]OutboxEvent, error) {
+	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*5)
+	defer cancelFunc()
+	events := []OutboxEvent{}
+	pending := o.db.NewSelect().Model((*OutboxEvent)(nil)).Column("id").
+		Where("processed_at IS NULL AND failed_at IS NULL AND available_at <= now()").
+		OrderExpr("id ASC").Limit(limit).For("UPDATE SKIP LOCKED")
+	err := o.db.NewUpdate().Model((*OutboxEvent)(nil)).
+		Set("attempts = attempts + 1").
+		Set("available_at = now() + make_interval(secs => ?)", lease.Seconds()).
+		Where("id IN (?)", pending).
+		Returning("*").Scan(timeoutCtx, &events)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, mapPgError(err)
+	}
+	return events, nil
+}
+
+// MarkProcessed records the event as processed so it's never dispatched again
+func (o *OutboxModel) MarkProcessed(ctx context.Context, id int64) error {
+	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*3)
+	defer cancelFunc()
+	_, err := o.db.NewUpdate().Model((*OutboxEvent)(nil)).
+		Set("processed_at = now()").
+		Set("last_error = ''").
+		Where("id = ?", id).Exec(timeoutCtx)
+	return mapPgError(err)
+}
+
+// MarkFailed records the error of the attempt. The event is retried after retryAfter, or never again when retryAfter is 0.
+func (o *OutboxModel) MarkFailed(ctx context.Context, id int64, eventErr error, retryAfter time.Duration) error {
+	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*3)
+	defer cancelFunc()
+	query := o.db.NewUpdate().Model((*OutboxEvent)(nil)).
+		Set("last_error = ?", eventErr.Error()).
+		Where("id = ?", id)
+	if retryAfter > 0 {
+		query = query.Set("available_at = now() + make_interval(secs => ?)", retryAfter.Seconds())
+	} else {
+		query = query.Set("failed_at = now()")
+	}
+	_, err := query.Exec(timeoutCtx)
+	return mapPgError(err)
+}
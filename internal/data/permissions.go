@@ -2,7 +2,10 @@ package data
 
 import (
 	"context"
+	"database/sql"
 	"errors"
+	"slices"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
@@ -10,7 +13,57 @@ import (
 )
 
 type PermissionModel struct {
-	db *bun.DB
+	db    *bun.DB
+	cache *permsCache
+}
+
+// permsCacheTTL bounds how long the permissions of a user are served from the cache. The changes made through the
+// models of a replica drop its cached permissions right away, the other replicas see them once their entries expire.
+const permsCacheTTL = 30 * time.Second
+
+// permsCache keeps the permissions of the users checked on every request by the permission middleware
+type permsCache struct {
+	mu      sync.Mutex
+	entries map[uuid.UUID]permsCacheEntry
+}
+
+type permsCacheEntry struct {
+	perms  Permissions
+	expiry time.Time
+}
+
+func newPermsCache() *permsCache {
+	return &permsCache{entries: make(map[uuid.UUID]permsCacheEntry)}
+}
+
+func (c *permsCache) get(userID uuid.UUID) (Permissions, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[userID]
+	if !ok || time.Now().After(entry.expiry) {
+		delete(c.entries, userID)
+		return nil, false
+	}
+	return slices.Clone(entry.perms), true
+}
+
+func (c *permsCache) set(userID uuid.UUID, perms Permissions) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[userID] = permsCacheEntry{perms: slices.Clone(perms), expiry: time.Now().Add(permsCacheTTL)}
+}
+
+// invalidate drops the cached permissions of the users, of every user when none is given
+func (c *permsCache) invalidate(userIDs ...uuid.UUID) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(userIDs) == 0 {
+		clear(c.entries)
+		return
+	}
+	for _, userID := range userIDs {
+		delete(c.entries, userID)
+	}
 }
 
 type Permissions []Permission
@@ -39,21 +92,24 @@ func (prems *Permissions) IncludesPrem(premCode string) bool {
 	return false
 }
 
+// GetAllPermsForUser returns the permissions granted to the user directly along with the ones inherited from their teams
 func (p *PermissionModel) GetAllPermsForUser(ctx context.Context, userID uuid.UUID) (*Permissions, error) {
-	nUser := &User{}
+	if perms, ok := p.cache.get(userID); ok {
+		return &perms, nil
+	}
+
+	perms := Permissions{}
 	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*3)
 	defer cancelFunc()
 
-	err := p.db.NewSelect().Model(nUser).Relation("Permission").Where("id = ?", userID).Scan(timeoutCtx)
-	if err != nil {
-		switch {
-		case errors.Is(err, ErrorRecordNotFound):
-			return nil, ErrorRecordNotFound
-		default:
-			return nil, err
-		}
+	err := p.db.NewSelect().Model(&perms).
+		Where("id IN (SELECT permission_id FROM user_effective_permissions WHERE user_id = ?)", userID).
+		Order("code ASC").Scan(timeoutCtx)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
 	}
-	return (*Permissions)(&nUser.Permission), nil
+	p.cache.set(userID, perms)
+	return &perms, nil
 }
 
 func (p *PermissionModel) AddPermForUser(ctx context.Context, userID uuid.UUID, perms ...string) error {
@@ -74,9 +130,45 @@ func (p *PermissionModel) AddPermForUser(ctx context.Context, userID uuid.UUID,
 	defer cancelFunc()
 
 	_, err = p.db.NewInsert().Model(&nUserPerm).Exec(timeoutCtx)
+	if err != nil {
+		return mapPgError(err)
+	}
+	p.cache.invalidate(userID)
+	return nil
+}
+
+// AddPermForUsers grants the permissions to all the users with a single insert
+func (p *PermissionModel) AddPermForUsers(ctx context.Context, userIDs []uuid.UUID, perms ...string) error {
+	return p.AddPermForUsersTx(ctx, p.db, userIDs, perms...)
+}
+
+// AddPermForUsersTx is AddPermForUsers within the transaction tx
+func (p *PermissionModel) AddPermForUsersTx(ctx context.Context, tx bun.IDB, userIDs []uuid.UUID, perms ...string) error {
+	if len(userIDs) == 0 {
+		return nil
+	}
+	permsObj, err := p.GetPermID(ctx, perms)
 	if err != nil {
 		return err
 	}
+
+	nUserPerm := make([]UserPermission, 0, len(userIDs)*len(*permsObj))
+	for _, userID := range userIDs {
+		for _, v := range *permsObj {
+			nUserPerm = append(nUserPerm, UserPermission{
+				UserID:       userID,
+				PermissionID: v.ID,
+			})
+		}
+	}
+	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*10)
+	defer cancelFunc()
+
+	_, err = tx.NewInsert().Model(&nUserPerm).Exec(timeoutCtx)
+	if err != nil {
+		return mapPgError(err)
+	}
+	p.cache.invalidate(userIDs...)
 	return nil
 }
 
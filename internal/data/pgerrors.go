@@ -0,0 +1,61 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/uptrace/bun/driver/pgdriver"
+)
+
+// SQLSTATE codes of the postgres errors the models handle. https://www.postgresql.org/docs/current/errcodes-appendix.html
+const (
+	pgForeignKeyViolation  = "23503"
+	pgUniqueViolation      = "23505"
+	pgCheckViolation       = "23514"
+	pgSerializationFailure = "40001"
+	pgDeadlockDetected     = "40P01"
+)
+
+// domain errors of the postgres errors. They wrap the original error so its detail remains in the logs
+var (
+	ErrUniqueViolation      = errors.New("record conflicts with an existing record")
+	ErrForeignKeyViolation  = errors.New("record references a record which doesn't exist")
+	ErrCheckViolation       = errors.New("record violates a check constraint")
+	ErrSerializationFailure = errors.New("transaction conflicted with a concurrent transaction")
+)
+
+// pgErrorFields returns the SQLSTATE code and the violated constraint of a postgres error returned by either
+// of the supported drivers. The code is empty when err isn't a postgres error.
+func pgErrorFields(err error) (code string, constraint string) {
+	var pgxErr *pgconn.PgError
+	if errors.As(err, &pgxErr) {
+		return pgxErr.Code, pgxErr.ConstraintName
+	}
+	var pgErr pgdriver.Error
+	if errors.As(err, &pgErr) {
+		return pgErr.Field('C'), pgErr.Field('n')
+	}
+	return "", ""
+}
+
+// mapPgError converts the postgres errors the handlers can act on into domain errors.
+// Unique violations of the user emails become ErrorDuplicateEmail. Every other error is returned untouched.
+func mapPgError(err error) error {
+	code, constraint := pgErrorFields(err)
+	switch code {
+	case pgUniqueViolation:
+		if constraint == "users_email_key" {
+			return fmt.Errorf("%w: %w", ErrorDuplicateEmail, err)
+		}
+		return fmt.Errorf("%w: %w", ErrUniqueViolation, err)
+	case pgForeignKeyViolation:
+		return fmt.Errorf("%w: %w", ErrForeignKeyViolation, err)
+	case pgCheckViolation:
+		return fmt.Errorf("%w: %w", ErrCheckViolation, err)
+	case pgSerializationFailure, pgDeadlockDetected:
+		return fmt.Errorf("%w: %w", ErrSerializationFailure, err)
+	default:
+		return err
+	}
+}
@@ -0,0 +1,105 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+// completionRatio is the share of the runtime after which a movie counts as watched, so the credits don't have to be watched
+const completionRatio = 0.95
+
+type WatchProgressModel struct {
+	db *bun.DB
+}
+
+// WatchProgress is the playback position of a movie for a user
+type WatchProgress struct {
+	bun.BaseModel   `bun:"table:watch_progress"`
+	UserID          uuid.UUID `json:"-" bun:",pk,type:uuid"`
+	MovieID         int64     `json:"movie_id" bun:",pk"`
+	PositionSeconds int32     `json:"position_seconds" bun:",notnull"`
+	Completed       bool      `json:"completed" bun:",notnull"`
+	UpdatedAt       time.Time `json:"updated_at" bun:",type:timestamptz,notnull,default:current_timestamp"`
+	Movie           *Movie    `json:"movie,omitempty" bun:"rel:belongs-to,join:movie_id=id"`
+}
+
+// ValidateWatchProgress checks the position is within the runtime of the movie
+func ValidateWatchProgress(v *Validator, progress *WatchProgress, runtime Runtime) {
+	v.CheckCode(progress.PositionSeconds >= 0, "position_seconds", CodeOutOfRange, "must not be negative")
+	v.CheckCode(progress.PositionSeconds <= int32(runtime)*60, "position_seconds", CodeOutOfRange, "must not be beyond the runtime of the movie")
+}
+
+// Watched reports whether the position is close enough to the end of the movie to count it as watched
+func Watched(positionSeconds int32, runtime Runtime) bool {
+	return float64(positionSeconds) >= float64(runtime)*60*completionRatio
+}
+
+// Upsert stores the latest position of the movie for the user
+func (p *WatchProgressModel) Upsert(ctx context.Context, progress *WatchProgress) error {
+	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*3)
+	defer cancelFunc()
+	err := p.db.NewInsert().Model(progress).
+		ExcludeColumn("updated_at").
+		On("CONFLICT (user_id, movie_id) DO UPDATE").
+		Set("position_seconds = EXCLUDED.position_seconds").
+		Set("completed = EXCLUDED.completed").
+		Set("updated_at = now()").
+		Returning("updated_at").Scan(timeoutCtx, &progress.UpdatedAt)
+	return mapPgError(err)
+}
+
+// InsertMany inserts the positions in chunks. The positions which already exist for the user and the movie are left untouched
+// and aren't counted by the returned number.
+func (p *WatchProgressModel) InsertMany(ctx context.Context, progress []*WatchProgress, opts BulkInsertOptions) (int, error) {
+	if len(progress) == 0 {
+		return 0, nil
+	}
+	return insertChunks(ctx, p.db, progress, opts.chunkSize(), func(ctx context.Context, tx bun.Tx, chunk []*WatchProgress) (int, error) {
+		result, err := tx.NewInsert().Model(&chunk).On("CONFLICT (user_id, movie_id) DO NOTHING").Exec(ctx)
+		if err != nil {
+			return 0, err
+		}
+		n, _ := result.RowsAffected()
+		return int(n), nil
+	})
+}
+
+func (p *WatchProgressModel) Get(ctx context.Context, userID uuid.UUID, movieID int64) (*WatchProgress, error) {
+	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*3)
+	defer cancelFunc()
+	progress := &WatchProgress{}
+	err := p.db.NewSelect().Model(progress).Where("user_id = ? AND movie_id = ?", userID, movieID).Scan(timeoutCtx)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrorRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return progress, nil
+}
+
+// History returns the watch progress of the user along with the movies, the most recently watched first by default
+func (p *WatchProgressModel) History(ctx context.Context, userID uuid.UUID, filters *Filters) ([]WatchProgress, int, error) {
+	history := []WatchProgress{}
+	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*5)
+	defer cancelFunc()
+
+	query := p.db.NewSelect().Model(&history).Relation("Movie").Where("user_id = ?", userID)
+	count, err := paginate(timeoutCtx, query, filters)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, 0, ErrorRecordNotFound
+		default:
+			return nil, 0, err
+		}
+	}
+	return history, count, nil
+}
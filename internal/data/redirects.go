@@ -0,0 +1,86 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+// resources of the redirects
+const (
+	RedirectMovies     = "movies"
+	RedirectShortLinks = "short_links"
+)
+
+// reasons of the redirects
+const (
+	RedirectMerged     = "merged"
+	RedirectReimported = "reimported"
+)
+
+// ResourceRedirect sends the clients of a resource which no longer exists to the one which has replaced it, so the links
+// the external consumers have kept don't break. The ids are kept as text to hold any kind of id.
+type ResourceRedirect struct {
+	bun.BaseModel `bun:"table:resource_redirects"`
+	Resource      string    `json:"resource" bun:",pk"`
+	OldID         string    `json:"old_id" bun:",pk"`
+	NewID         string    `json:"new_id" bun:",notnull"`
+	Reason        string    `json:"reason" bun:",notnull"`
+	CreatedAt     time.Time `json:"created_at" bun:",nullzero,notnull,default:current_timestamp,type:timestamp(0) with time zone"`
+}
+
+type RedirectModel struct {
+	db *bun.DB
+}
+
+// Lookup returns the id which has replaced the old id of the resource. It returns ErrorRecordNotFound when there's no redirect.
+func (m *RedirectModel) Lookup(ctx context.Context, resource string, oldID string) (string, error) {
+	var newID string
+	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*3)
+	defer cancelFunc()
+	err := m.db.NewSelect().Model((*ResourceRedirect)(nil)).Column("new_id").
+		Where("resource = ? AND old_id = ?", resource, oldID).
+		Scan(timeoutCtx, &newID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return "", ErrorRecordNotFound
+		default:
+			return "", err
+		}
+	}
+	return newID, nil
+}
+
+// recordRedirect redirects the old id of the resource to the new one. The redirects to the old id are pointed at the new id
+// as well, so the clients are never sent through a chain of redirects. The redirect of the new id is dropped since it
+// exists again.
+func recordRedirect(ctx context.Context, db bun.IDB, resource string, oldID string, newID string, reason string) error {
+	_, err := db.NewInsert().Model(&ResourceRedirect{Resource: resource, OldID: oldID, NewID: newID, Reason: reason}).
+		On("CONFLICT (resource, old_id) DO UPDATE").
+		Set("new_id = EXCLUDED.new_id").
+		Set("reason = EXCLUDED.reason").
+		Set("created_at = now()").
+		Exec(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = db.NewUpdate().Model((*ResourceRedirect)(nil)).
+		Set("new_id = ?", newID).
+		Where("resource = ? AND new_id = ?", resource, oldID).
+		Exec(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = db.NewDelete().Model((*ResourceRedirect)(nil)).Where("resource = ? AND old_id = ?", resource, newID).Exec(ctx)
+	return err
+}
+
+// dropRedirects removes the redirects to the deleted id of the resource, so its old ids aren't sent to a missing resource
+func dropRedirects(ctx context.Context, db bun.IDB, resource string, id string) error {
+	_, err := db.NewDelete().Model((*ResourceRedirect)(nil)).Where("resource = ? AND new_id = ?", resource, id).Exec(ctx)
+	return err
+}
@@ -0,0 +1,103 @@
+package data
+
+import (
+	"context"
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+// actions of the retention on the inactive users
+const (
+	RetentionAnonymize = "anonymize"
+	RetentionPurge     = "purge"
+)
+
+type RetentionModel struct {
+	db *bun.DB
+}
+
+// inactiveUsers selects the ids of the users who were created and haven't signed in since before.
+// The admins, by their own permissions or their teams, and the users already anonymized are left out.
+func (r *RetentionModel) inactiveUsers(db bun.IDB, before time.Time) *bun.SelectQuery {
+	return db.NewSelect().Model((*User)(nil)).Column("user.id").
+		Where("? < ?", bun.Ident("user.created_at"), before).
+		Where("? IS NULL", bun.Ident("user.anonymized_at")).
+		Where("NOT EXISTS (SELECT 1 FROM login_events AS l WHERE l.user_id = ? AND l.created_at >= ?)", bun.Ident("user.id"), before).
+		Where("NOT EXISTS (SELECT 1 FROM user_effective_permissions AS up JOIN permissions AS p ON p.id = up.permission_id WHERE up.user_id = ? AND p.code = 'admin')", bun.Ident("user.id"))
+}
+
+// InactiveUsers anonymizes or purges the users inactive since before and returns their number.
+// Anonymizing keeps the rows referenced by the catalog but drops the personal data and the credentials of the users.
+// Nothing is changed in dryRun, only the users which would be affected are counted.
+func (r *RetentionModel) InactiveUsers(ctx context.Context, before time.Time, action string, dryRun bool) (int64, error) {
+	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Minute)
+	defer cancelFunc()
+	if dryRun {
+		count, err := r.inactiveUsers(r.db, before).Count(timeoutCtx)
+		return int64(count), err
+	}
+
+	var affected int64
+	err := r.db.RunInTx(timeoutCtx, nil, func(ctx context.Context, tx bun.Tx) error {
+		ids := []string{}
+		err := r.inactiveUsers(tx, before).Scan(ctx, &ids)
+		if err != nil || len(ids) == 0 {
+			return err
+		}
+		affected = int64(len(ids))
+		if action == RetentionPurge {
+			_, err := tx.NewDelete().Model((*User)(nil)).Where("id IN (?)", bun.In(ids)).Exec(ctx)
+			return err
+		}
+
+		_, err = tx.NewUpdate().Model((*User)(nil)).
+			Set("name = 'Anonymized user'").
+			Set("email = 'anonymized-' || id || '@anonymized.invalid'").
+			Set("password_hash = ''::bytea").
+			Set("external_id = NULL").
+			Set("activated = FALSE").
+			Set("anonymized_at = now()").
+			Set("updated_at = now()").
+			Set("version = version + 1").
+			Where("id IN (?)", bun.In(ids)).Exec(ctx)
+		if err != nil {
+			return err
+		}
+		for _, model := range []interface{}{(*Token)(nil), (*Session)(nil), (*LoginEvent)(nil)} {
+			_, err := tx.NewDelete().Model(model).Where("user_id IN (?)", bun.In(ids)).Exec(ctx)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return affected, nil
+}
+
+// AuditLogs deletes the audit events created before and returns their number. Nothing is deleted in dryRun.
+func (r *RetentionModel) AuditLogs(ctx context.Context, before time.Time, dryRun bool) (int64, error) {
+	return r.deleteBefore(ctx, (*AuditLog)(nil), before, dryRun)
+}
+
+// EmailLogs deletes the email logs created before and returns their number. Nothing is deleted in dryRun.
+func (r *RetentionModel) EmailLogs(ctx context.Context, before time.Time, dryRun bool) (int64, error) {
+	return r.deleteBefore(ctx, (*EmailLog)(nil), before, dryRun)
+}
+
+func (r *RetentionModel) deleteBefore(ctx context.Context, model interface{}, before time.Time, dryRun bool) (int64, error) {
+	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Minute)
+	defer cancelFunc()
+	if dryRun {
+		count, err := r.db.NewSelect().Model(model).Where("created_at < ?", before).Count(timeoutCtx)
+		return int64(count), err
+	}
+	result, err := r.db.NewDelete().Model(model).Where("created_at < ?", before).Exec(timeoutCtx)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
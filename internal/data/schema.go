@@ -0,0 +1,94 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+// states of the live schema compared with the migrations the binary is built against
+const (
+	SchemaInSync = "in_sync"
+	// SchemaBehind means some of the migrations haven't been applied yet
+	SchemaBehind = "behind"
+	// SchemaAhead means the database has been migrated by a newer release. it's expected during rolling deployments
+	SchemaAhead = "ahead"
+	// SchemaDirty means a migration failed halfway and golang-migrate needs a manual force
+	SchemaDirty = "dirty"
+)
+
+// CriticalIndexes are the indexes the queries can't live without. Losing any of them turns the lookups
+// and the full text search into sequential scans or breaks the uniqueness the models rely on.
+var CriticalIndexes = []string{
+	"title_tsvector_idx",     // full text search of the movie titles
+	"movies_genres_idx",      // genre filter of the movie list
+	"movies_external_id_key", // upsert of the movies by their imdb or tmdb id
+	"users_email_key",        // unique user emails
+	"tokens_pkey",            // token lookup by its hash
+}
+
+type SchemaModel struct {
+	db *bun.DB
+}
+
+// SchemaReport is the result of comparing the live database with the expected schema
+type SchemaReport struct {
+	State           string    `json:"state"`
+	ExpectedVersion uint      `json:"expected_version"`
+	Version         uint      `json:"version"`
+	Dirty           bool      `json:"dirty"`
+	MissingIndexes  []string  `json:"missing_indexes,omitempty"`
+	CheckedAt       time.Time `json:"checked_at"`
+}
+
+// Drifted reports whether the database lacks something the binary depends on.
+// A database which is ahead of the binary isn't a drift since the migrations are expected to be backward compatible.
+func (s *SchemaReport) Drifted() bool {
+	return s.State == SchemaBehind || s.State == SchemaDirty || len(s.MissingIndexes) > 0
+}
+
+// Check reads the migration version recorded by golang-migrate and looks up the critical indexes.
+// Indexes left invalid by a failed concurrent build are reported as missing.
+func (s *SchemaModel) Check(ctx context.Context, expectedVersion uint) (*SchemaReport, error) {
+	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*3)
+	defer cancelFunc()
+
+	report := &SchemaReport{ExpectedVersion: expectedVersion, CheckedAt: time.Now()}
+	err := s.db.NewSelect().Table("schema_migrations").Column("version", "dirty").Limit(1).
+		Scan(timeoutCtx, &report.Version, &report.Dirty)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	var found []string
+	err = s.db.NewSelect().TableExpr("pg_class AS c").
+		Join("JOIN pg_index AS i ON i.indexrelid = c.oid").
+		ColumnExpr("c.relname").
+		Where("c.relname IN (?)", bun.In(CriticalIndexes)).
+		Where("i.indisvalid AND pg_table_is_visible(c.oid)").
+		Scan(timeoutCtx, &found)
+	if err != nil {
+		return nil, err
+	}
+	for _, index := range CriticalIndexes {
+		if !slices.Contains(found, index) {
+			report.MissingIndexes = append(report.MissingIndexes, index)
+		}
+	}
+
+	switch {
+	case report.Dirty:
+		report.State = SchemaDirty
+	case report.Version < expectedVersion:
+		report.State = SchemaBehind
+	case report.Version > expectedVersion:
+		report.State = SchemaAhead
+	default:
+		report.State = SchemaInSync
+	}
+	return report, nil
+}
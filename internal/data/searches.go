@@ -0,0 +1,122 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+type SavedSearchModel struct {
+	db *bun.DB
+}
+
+// SavedSearch is a named movie list filter of a user. The searches with Alert set notify their user of the movies
+// matching them which have been added to the catalog since AlertSeq, the change sequence number they've been checked up to.
+type SavedSearch struct {
+	bun.BaseModel `bun:"table:saved_searches"`
+	ID            int64           `json:"id" bun:",pk,autoincrement,notnull,type:bigserial"`
+	UserID        uuid.UUID       `json:"-" bun:",type:uuid,notnull"`
+	User          *User           `json:"-" bun:"rel:belongs-to,join:user_id=id"`
+	Name          string          `json:"name" bun:",notnull"`
+	Filter        MovieListFilter `json:"filter" bun:",type:jsonb,notnull"`
+	Alert         bool            `json:"alert" bun:",notnull"`
+	AlertSeq      int64           `json:"-" bun:",notnull"`
+	AlertedAt     *time.Time      `json:"alerted_at,omitempty" bun:",type:timestamptz,nullzero"`
+	CreatedAt     time.Time       `json:"created_at" bun:",type:timestamptz,notnull,default:current_timestamp"`
+}
+
+func ValidateSavedSearchName(v *Validator, name string) {
+	v.Check(name != "", "name", "must be provided")
+	v.Check(len(name) <= 100, "name", "must not be more than 100 bytes long")
+}
+
+// Insert stores the search of the user. Its alerts start from the movies added after it's been saved.
+func (s *SavedSearchModel) Insert(ctx context.Context, search *SavedSearch) error {
+	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*3)
+	defer cancelFunc()
+	lastChangeSeq := s.db.NewSelect().Model((*Movie)(nil)).ColumnExpr("COALESCE(max(change_seq), 0)")
+	err := s.db.NewInsert().Model(search).
+		Column("user_id", "name", "filter", "alert", "alert_seq").
+		Value("alert_seq", "(?)", lastChangeSeq).
+		Returning("id, alert_seq, created_at").Scan(timeoutCtx, &search.ID, &search.AlertSeq, &search.CreatedAt)
+	return mapPgError(err)
+}
+
+// Get returns the search of the user
+func (s *SavedSearchModel) Get(ctx context.Context, userID uuid.UUID, id int64) (*SavedSearch, error) {
+	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*3)
+	defer cancelFunc()
+	search := &SavedSearch{}
+	err := s.db.NewSelect().Model(search).Where("id = ? AND user_id = ?", id, userID).Scan(timeoutCtx)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrorRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return search, nil
+}
+
+// ListForUser returns the searches of the user by their names
+func (s *SavedSearchModel) ListForUser(ctx context.Context, userID uuid.UUID) ([]SavedSearch, error) {
+	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*3)
+	defer cancelFunc()
+	searches := []SavedSearch{}
+	err := s.db.NewSelect().Model(&searches).Where("user_id = ?", userID).Order("name ASC").Scan(timeoutCtx)
+	if err != nil {
+		return nil, err
+	}
+	return searches, nil
+}
+
+// Delete removes the search of the user
+func (s *SavedSearchModel) Delete(ctx context.Context, userID uuid.UUID, id int64) error {
+	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*3)
+	defer cancelFunc()
+	result, err := s.db.NewDelete().Model((*SavedSearch)(nil)).Where("id = ? AND user_id = ?", id, userID).Exec(timeoutCtx)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrorRecordNotFound
+	}
+	return nil
+}
+
+// ListAlerting returns the searches with alerts checked up to before the until sequence number along with their users.
+// The users who can't receive emails are left out.
+func (s *SavedSearchModel) ListAlerting(ctx context.Context, until int64) ([]SavedSearch, error) {
+	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*10)
+	defer cancelFunc()
+	searches := []SavedSearch{}
+	err := s.db.NewSelect().Model(&searches).Relation("User").
+		Where("saved_search.alert AND saved_search.alert_seq < ?", until).
+		Where(`"user".activated AND "user".email_status = ?`, UserEmailStatusOK).
+		Order("saved_search.id ASC").Scan(timeoutCtx)
+	if err != nil {
+		return nil, err
+	}
+	return searches, nil
+}
+
+// AdvanceAlert moves the alerts of the search from the since sequence number up to the until one. It reports false when
+// the alerts have moved from since in the meantime, so the replicas checking the same search only alert it once.
+func (s *SavedSearchModel) AdvanceAlert(ctx context.Context, id int64, since int64, until int64) (bool, error) {
+	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*3)
+	defer cancelFunc()
+	result, err := s.db.NewUpdate().Model((*SavedSearch)(nil)).
+		Set("alert_seq = ?", until).
+		Set("alerted_at = now()").
+		Where("id = ? AND alert_seq = ?", id, since).Exec(timeoutCtx)
+	if err != nil {
+		return false, err
+	}
+	n, _ := result.RowsAffected()
+	return n > 0, nil
+}
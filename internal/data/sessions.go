@@ -0,0 +1,124 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+type SessionModel struct {
+	db *bun.DB
+}
+
+// Session is a browser session of the web frontend authenticated by the session cookie.
+// Only the hash of the cookie value is stored, like the authentication tokens.
+type Session struct {
+	bun.BaseModel `bun:"table:sessions"`
+	PlainText     string    `json:"-" bun:"-"`
+	Hash          []byte    `json:"-" bun:",pk,notnull,type:bytea"`
+	UserID        uuid.UUID `json:"user_id" bun:",type:uuid,notnull"`
+	User          *User     `json:"-" bun:"rel:belongs-to,join:user_id=id"`
+	RemoteAddr    string    `json:"remote_addr" bun:",notnull"`
+	UserAgent     string    `json:"user_agent" bun:",notnull"`
+	CreatedAt     time.Time `json:"created_at" bun:",type:timestamptz,notnull,default:current_timestamp"`
+	LastSeenAt    time.Time `json:"last_seen_at" bun:",type:timestamptz,notnull,default:current_timestamp"`
+	Expiry        time.Time `json:"expiry" bun:",type:timestamptz,notnull"`
+}
+
+func hashSessionToken(plaintext string) []byte {
+	hash := sha256.Sum256([]byte(plaintext))
+	return hash[:]
+}
+
+// New creates the session with a random token in PlainText. The expired sessions of the user are removed along the way.
+func (s *SessionModel) New(ctx context.Context, session *Session) error {
+	bs := make([]byte, 16)
+	_, err := rand.Read(bs)
+	if err != nil {
+		return err
+	}
+	session.PlainText = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(bs)
+	session.Hash = hashSessionToken(session.PlainText)
+
+	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*5)
+	defer cancelFunc()
+	return s.db.RunInTx(timeoutCtx, nil, func(ctx context.Context, tx bun.Tx) error {
+		_, err := tx.NewDelete().Model((*Session)(nil)).Where("user_id = ? AND expiry <= now()", session.UserID).Exec(ctx)
+		if err != nil {
+			return err
+		}
+		err = tx.NewInsert().Model(session).
+			Column("hash", "user_id", "remote_addr", "user_agent", "expiry").
+			Returning("created_at, last_seen_at").Scan(ctx, &session.CreatedAt, &session.LastSeenAt)
+		return mapPgError(err)
+	})
+}
+
+// GetForToken returns the unexpired session of the token along with its user
+func (s *SessionModel) GetForToken(ctx context.Context, plaintext string) (*Session, error) {
+	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*3)
+	defer cancelFunc()
+	session := &Session{}
+	err := s.db.NewSelect().Model(session).Relation("User").
+		Where("session.hash = ? AND session.expiry > now()", hashSessionToken(plaintext)).
+		Scan(timeoutCtx)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrorRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return session, nil
+}
+
+// Touch records the activity of the session and slides its expiry
+func (s *SessionModel) Touch(ctx context.Context, session *Session, expiry time.Time) error {
+	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*3)
+	defer cancelFunc()
+	err := s.db.NewUpdate().Model((*Session)(nil)).
+		Set("last_seen_at = now()").
+		Set("expiry = ?", expiry).
+		Where("hash = ?", session.Hash).
+		Returning("last_seen_at").Scan(timeoutCtx, &session.LastSeenAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrorRecordNotFound
+		default:
+			return err
+		}
+	}
+	session.Expiry = expiry
+	return nil
+}
+
+// Delete ends the session of the token
+func (s *SessionModel) Delete(ctx context.Context, plaintext string) error {
+	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*3)
+	defer cancelFunc()
+	result, err := s.db.NewDelete().Model((*Session)(nil)).Where("hash = ?", hashSessionToken(plaintext)).Exec(timeoutCtx)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrorRecordNotFound
+	}
+	return nil
+}
+
+// DeleteAllForUser ends all the sessions of the user
+func (s *SessionModel) DeleteAllForUser(ctx context.Context, userID uuid.UUID) error {
+	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*3)
+	defer cancelFunc()
+	_, err := s.db.NewDelete().Model((*Session)(nil)).Where("user_id = ?", userID).Exec(timeoutCtx)
+	return err
+}
@@ -0,0 +1,132 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+var ErrShareRevoked = errors.New("share is revoked")
+
+type MovieShareModel struct {
+	db *bun.DB
+}
+
+// MovieShare is a temporary read-only access to a movie granted through a signed url
+type MovieShare struct {
+	bun.BaseModel `bun:"table:movie_shares"`
+	ID            int64      `json:"id" bun:",pk,autoincrement,notnull,type:bigserial"`
+	MovieID       int64      `json:"movie_id" bun:",notnull"`
+	CreatedBy     uuid.UUID  `json:"created_by" bun:",type:uuid,notnull"`
+	Expiry        time.Time  `json:"expiry" bun:",type:timestamptz,notnull"`
+	RevokedAt     *time.Time `json:"revoked_at,omitempty" bun:",type:timestamptz,nullzero"`
+	AccessCount   int        `json:"access_count" bun:",notnull,default:0"`
+	CreatedAt     time.Time  `json:"created_at" bun:",type:timestamptz,notnull,default:current_timestamp"`
+}
+
+// MovieShareAccess is the audit record of a single access through a share
+type MovieShareAccess struct {
+	bun.BaseModel `bun:"table:movie_share_accesses"`
+	ID            int64     `json:"id" bun:",pk,autoincrement,notnull,type:bigserial"`
+	ShareID       int64     `json:"share_id" bun:",notnull"`
+	RemoteAddr    string    `json:"remote_addr" bun:",notnull"`
+	UserAgent     string    `json:"user_agent" bun:",notnull"`
+	AccessedAt    time.Time `json:"accessed_at" bun:",type:timestamptz,notnull,default:current_timestamp"`
+}
+
+func (s *MovieShareModel) Insert(ctx context.Context, share *MovieShare) error {
+	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*3)
+	defer cancelFunc()
+	err := s.db.NewInsert().Model(share).Returning("id, access_count, created_at").Scan(timeoutCtx, &share.ID, &share.AccessCount, &share.CreatedAt)
+	if err != nil {
+		return mapPgError(err)
+	}
+	return nil
+}
+
+// Access checks the share still grants access to the movie and records the access in the audit log. It returns the share,
+// whose movie is the one the movie of the url has been merged into when it's been merged since. check Merge
+func (s *MovieShareModel) Access(ctx context.Context, movieID int64, shareID int64, access *MovieShareAccess) (*MovieShare, error) {
+	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*3)
+	defer cancelFunc()
+	share := &MovieShare{}
+	err := s.db.RunInTx(timeoutCtx, nil, func(ctx context.Context, tx bun.Tx) error {
+		redirect := tx.NewSelect().Model((*ResourceRedirect)(nil)).ColumnExpr("new_id::bigint").
+			Where("resource = ? AND old_id = ?", RedirectMovies, strconv.FormatInt(movieID, 10))
+		err := tx.NewSelect().Model(share).
+			Where("id = ?", shareID).
+			Where("movie_id = COALESCE((?), ?)", redirect, movieID).
+			For("UPDATE").Scan(ctx)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrorRecordNotFound
+			default:
+				return err
+			}
+		}
+		if share.RevokedAt != nil {
+			return ErrShareRevoked
+		}
+
+		access.ShareID = share.ID
+		err = tx.NewInsert().Model(access).Returning("id, accessed_at").Scan(ctx, &access.ID, &access.AccessedAt)
+		if err != nil {
+			return err
+		}
+		_, err = tx.NewUpdate().Model((*MovieShare)(nil)).Set("access_count = access_count + 1").Where("id = ?", share.ID).Exec(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, mapPgError(err)
+	}
+	return share, nil
+}
+
+func (s *MovieShareModel) Revoke(ctx context.Context, movieID int64, shareID int64) error {
+	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*3)
+	defer cancelFunc()
+	result, err := s.db.NewUpdate().Model((*MovieShare)(nil)).Set("revoked_at = now()").Where("id = ? AND movie_id = ? AND revoked_at IS NULL", shareID, movieID).Exec(timeoutCtx)
+	if err != nil {
+		return mapPgError(err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrorRecordNotFound
+	}
+	return nil
+}
+
+func (s *MovieShareModel) ListForMovie(ctx context.Context, movieID int64) ([]MovieShare, error) {
+	shares := []MovieShare{}
+	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*3)
+	defer cancelFunc()
+	err := s.db.NewSelect().Model(&shares).Where("movie_id = ?", movieID).OrderExpr("id DESC").Scan(timeoutCtx)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	return shares, nil
+}
+
+func (s *MovieShareModel) ListAccesses(ctx context.Context, movieID int64, shareID int64, filters *Filters) ([]MovieShareAccess, int, error) {
+	accesses := []MovieShareAccess{}
+	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*5)
+	defer cancelFunc()
+
+	query := s.db.NewSelect().Model(&accesses).
+		Where("share_id = (SELECT id FROM movie_shares WHERE id = ? AND movie_id = ?)", shareID, movieID)
+	count, err := paginate(timeoutCtx, query, filters)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, 0, ErrorRecordNotFound
+		default:
+			return nil, 0, err
+		}
+	}
+	return accesses, count, nil
+}
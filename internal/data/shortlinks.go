@@ -0,0 +1,116 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"regexp"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+const (
+	shortCodeAlphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	shortCodeLength   = 7
+	// shortCodeAttempts is the number of codes tried before giving up on the collisions. 62^7 codes make even one retry rare
+	shortCodeAttempts = 3
+)
+
+var ShortCodeRX = regexp.MustCompile(`^[0-9A-Za-z]{7}$`)
+
+type ShortLinkModel struct {
+	db *bun.DB
+}
+
+// ShortLink is the compact public code of a movie deep link. Each movie has a single short link.
+type ShortLink struct {
+	bun.BaseModel `bun:"table:short_links"`
+	ID            int64      `json:"id" bun:",pk,autoincrement,notnull,type:bigserial"`
+	Code          string     `json:"code" bun:",notnull"`
+	MovieID       int64      `json:"movie_id" bun:",notnull"`
+	CreatedBy     uuid.UUID  `json:"created_by" bun:",type:uuid,notnull"`
+	HitCount      int64      `json:"hit_count" bun:",notnull,default:0"`
+	LastHitAt     *time.Time `json:"last_hit_at,omitempty" bun:",type:timestamptz,nullzero"`
+	CreatedAt     time.Time  `json:"created_at" bun:",type:timestamptz,notnull,default:current_timestamp"`
+}
+
+func ValidateShortCode(v *Validator, code string) {
+	v.Apply("code", Pattern(code, ShortCodeRX, "must be 7 alphanumeric characters"))
+}
+
+// newShortCode returns a random base62 code. bytes beyond the largest multiple of 62 are dropped so every character is equally likely.
+func newShortCode() (string, error) {
+	code := make([]byte, 0, shortCodeLength)
+	buf := make([]byte, shortCodeLength*2)
+	for len(code) < shortCodeLength {
+		_, err := rand.Read(buf)
+		if err != nil {
+			return "", err
+		}
+		for _, b := range buf {
+			if b < 248 && len(code) < shortCodeLength {
+				code = append(code, shortCodeAlphabet[b%62])
+			}
+		}
+	}
+	return string(code), nil
+}
+
+// GetOrCreate returns the short link of the movie and creates it when the movie doesn't have one yet.
+// It reports whether the short link has been created.
+func (s *ShortLinkModel) GetOrCreate(ctx context.Context, link *ShortLink) (bool, error) {
+	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*5)
+	defer cancelFunc()
+
+	for attempt := 0; attempt < shortCodeAttempts; attempt++ {
+		code, err := newShortCode()
+		if err != nil {
+			return false, err
+		}
+		link.Code = code
+		// a concurrent request may create the link of the movie first, so conflicts on the movie are skipped
+		// and the existing link is read below
+		err = s.db.NewInsert().Model(link).
+			On("CONFLICT (movie_id) DO NOTHING").
+			Returning("id, hit_count, created_at").Scan(timeoutCtx, &link.ID, &link.HitCount, &link.CreatedAt)
+		switch {
+		case err == nil:
+			return true, nil
+		case errors.Is(err, sql.ErrNoRows):
+			err = s.db.NewSelect().Model(link).Where("movie_id = ?", link.MovieID).Scan(timeoutCtx)
+			return false, err
+		case errors.Is(mapPgError(err), ErrUniqueViolation):
+			// the code collided with the code of another movie
+			continue
+		default:
+			return false, mapPgError(err)
+		}
+	}
+	return false, errors.New("failed to generate a unique short code")
+}
+
+// Hit counts a visit of the short link and returns the movie it points to. The codes of the movies merged into another
+// one count as visits of the code of the movie they've been merged into. check Merge
+func (s *ShortLinkModel) Hit(ctx context.Context, code string) (int64, error) {
+	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*3)
+	defer cancelFunc()
+	var movieID int64
+	redirect := s.db.NewSelect().Model((*ResourceRedirect)(nil)).Column("new_id").Where("resource = ? AND old_id = ?", RedirectShortLinks, code)
+	err := s.db.NewUpdate().Model((*ShortLink)(nil)).
+		Set("hit_count = hit_count + 1").
+		Set("last_hit_at = now()").
+		Where("code = COALESCE((?), ?)", redirect, code).
+		Returning("movie_id").Scan(timeoutCtx, &movieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, ErrorRecordNotFound
+		default:
+			return 0, err
+		}
+	}
+	return movieID, nil
+}
@@ -0,0 +1,103 @@
+package data
+
+import (
+	"context"
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+type StatsModel struct {
+	db *bun.DB
+}
+
+type UserStats struct {
+	Registered int64 `json:"registered" bun:"registered"`
+	Activated  int64 `json:"activated" bun:"activated"`
+	// ActivationRate is the share of the registered users who have activated their account, between 0 and 1
+	ActivationRate float64 `json:"activation_rate" bun:"-"`
+}
+
+// PeriodCount is the number of records created in the day or the week starting at Period
+type PeriodCount struct {
+	Period time.Time `json:"period" bun:"period"`
+	Count  int64     `json:"count" bun:"count"`
+}
+
+type GenreCount struct {
+	Genre string `json:"genre" bun:"genre"`
+	Count int64  `json:"count" bun:"count"`
+}
+
+func (s *StatsModel) Users(ctx context.Context) (*UserStats, error) {
+	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*5)
+	defer cancelFunc()
+	stats := &UserStats{}
+	err := s.db.NewSelect().Model((*User)(nil)).
+		ColumnExpr("COUNT(*) AS registered").
+		ColumnExpr("COUNT(*) FILTER (WHERE activated) AS activated").
+		Scan(timeoutCtx, stats)
+	if err != nil {
+		return nil, err
+	}
+	if stats.Registered > 0 {
+		stats.ActivationRate = float64(stats.Activated) / float64(stats.Registered)
+	}
+	return stats, nil
+}
+
+// TokensPerDay returns the number of the tokens and the sessions issued on each of the last days. Every issued token
+// is recorded as a login event, so the days without any are left out.
+func (s *StatsModel) TokensPerDay(ctx context.Context, days int) ([]PeriodCount, error) {
+	counts := []PeriodCount{}
+	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*5)
+	defer cancelFunc()
+	err := s.db.NewSelect().Model((*LoginEvent)(nil)).
+		ColumnExpr("date_trunc('day', created_at) AS period").
+		ColumnExpr("COUNT(*) AS count").
+		Where("created_at >= date_trunc('day', now()) - make_interval(days => ?)", days-1).
+		GroupExpr("period").
+		OrderExpr("period").
+		Scan(timeoutCtx, &counts)
+	if err != nil {
+		return nil, err
+	}
+	return counts, nil
+}
+
+// MoviesPerWeek returns the number of the movies added on each of the last weeks. The weeks without any are left out.
+func (s *StatsModel) MoviesPerWeek(ctx context.Context, weeks int) ([]PeriodCount, error) {
+	counts := []PeriodCount{}
+	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*5)
+	defer cancelFunc()
+	err := s.db.NewSelect().Model((*Movie)(nil)).
+		ColumnExpr("date_trunc('week', created_at) AS period").
+		ColumnExpr("COUNT(*) AS count").
+		Where("created_at >= date_trunc('week', now()) - make_interval(weeks => ?)", weeks-1).
+		GroupExpr("period").
+		OrderExpr("period").
+		Scan(timeoutCtx, &counts)
+	if err != nil {
+		return nil, err
+	}
+	return counts, nil
+}
+
+// TopGenres returns the genres with the most movies
+func (s *StatsModel) TopGenres(ctx context.Context, limit int) ([]GenreCount, error) {
+	counts := []GenreCount{}
+	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*5)
+	defer cancelFunc()
+	err := s.db.NewSelect().
+		TableExpr("movies, unnest(movies.genres) AS genre").
+		ColumnExpr("genre").
+		ColumnExpr("COUNT(*) AS count").
+		GroupExpr("genre").
+		OrderExpr("count DESC, genre").
+		Limit(limit).
+		Scan(timeoutCtx, &counts)
+	if err != nil {
+		return nil, err
+	}
+	return counts, nil
+}
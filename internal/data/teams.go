@@ -0,0 +1,195 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+type TeamModel struct {
+	db    *bun.DB
+	perms *permsCache
+}
+
+// Team grants its permissions to all of its members on top of their own ones. check GetAllPermsForUser
+type Team struct {
+	bun.BaseModel `bun:"table:teams"`
+	ID            int64     `json:"id" bun:",pk,autoincrement,notnull,type:bigserial"`
+	Name          string    `json:"name" bun:",notnull"`
+	Permissions   []string  `json:"permissions" bun:",array,scanonly"`
+	Members       int       `json:"members" bun:",scanonly"`
+	CreatedAt     time.Time `json:"created_at" bun:",type:timestamptz,notnull,default:current_timestamp"`
+}
+
+type TeamMember struct {
+	bun.BaseModel `bun:"table:team_members"`
+	TeamID        int64     `json:"-" bun:",pk"`
+	UserID        uuid.UUID `json:"-" bun:",pk,type:uuid"`
+	User          *User     `json:"user" bun:"rel:belongs-to,join:user_id=id"`
+	CreatedAt     time.Time `json:"created_at" bun:",type:timestamptz,notnull,default:current_timestamp"`
+}
+
+type TeamPermission struct {
+	bun.BaseModel `bun:"table:team_permissions"`
+	TeamID        int64 `bun:",pk"`
+	PermissionID  int64 `bun:",pk"`
+}
+
+func ValidateTeam(v *Validator, team *Team) {
+	v.Apply("name", Required(team.Name), MaxLen(team.Name, 100))
+	v.Apply("permissions", UniqueItems(team.Permissions))
+}
+
+// selectTeams selects the teams along with their permission codes and their number of members
+func (t *TeamModel) selectTeams(teams interface{}) *bun.SelectQuery {
+	return t.db.NewSelect().Model(teams).
+		ColumnExpr("team.*").
+		ColumnExpr("ARRAY(SELECT p.code FROM team_permissions AS tp JOIN permissions AS p ON p.id = tp.permission_id WHERE tp.team_id = team.id ORDER BY p.code) AS permissions").
+		ColumnExpr("(SELECT count(*) FROM team_members AS tm WHERE tm.team_id = team.id) AS members")
+}
+
+// Insert creates the team along with its permissions. The permissions must exist. check GetPermID
+func (t *TeamModel) Insert(ctx context.Context, team *Team, perms *Permissions) error {
+	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*5)
+	defer cancelFunc()
+	return t.db.RunInTx(timeoutCtx, nil, func(ctx context.Context, tx bun.Tx) error {
+		err := tx.NewInsert().Model(team).Column("name").Returning("id, created_at").Scan(ctx, &team.ID, &team.CreatedAt)
+		if err != nil {
+			return mapPgError(err)
+		}
+		return insertTeamPermissions(ctx, tx, team.ID, perms)
+	})
+}
+
+func insertTeamPermissions(ctx context.Context, tx bun.Tx, teamID int64, perms *Permissions) error {
+	if len(*perms) == 0 {
+		return nil
+	}
+	teamPerms := make([]TeamPermission, 0, len(*perms))
+	for _, perm := range *perms {
+		teamPerms = append(teamPerms, TeamPermission{TeamID: teamID, PermissionID: perm.ID})
+	}
+	_, err := tx.NewInsert().Model(&teamPerms).Exec(ctx)
+	return mapPgError(err)
+}
+
+func (t *TeamModel) Get(ctx context.Context, id int64) (*Team, error) {
+	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*3)
+	defer cancelFunc()
+	team := &Team{}
+	err := t.selectTeams(team).Where("team.id = ?", id).Scan(timeoutCtx)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrorRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return team, nil
+}
+
+// List returns the teams by their names
+func (t *TeamModel) List(ctx context.Context) ([]Team, error) {
+	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*3)
+	defer cancelFunc()
+	teams := []Team{}
+	err := t.selectTeams(&teams).Order("team.name ASC").Scan(timeoutCtx)
+	if err != nil {
+		return nil, err
+	}
+	return teams, nil
+}
+
+// SetPermissions replaces the permissions of the team. Its members get the new ones right away.
+func (t *TeamModel) SetPermissions(ctx context.Context, id int64, perms *Permissions) error {
+	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*5)
+	defer cancelFunc()
+	err := t.db.RunInTx(timeoutCtx, nil, func(ctx context.Context, tx bun.Tx) error {
+		// the team row is locked so the concurrent updates don't interleave their permissions
+		var teamID int64
+		err := tx.NewSelect().Model((*Team)(nil)).Column("id").Where("id = ?", id).For("UPDATE").Scan(ctx, &teamID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrorRecordNotFound
+			}
+			return err
+		}
+		_, err = tx.NewDelete().Model((*TeamPermission)(nil)).Where("team_id = ?", id).Exec(ctx)
+		if err != nil {
+			return err
+		}
+		return insertTeamPermissions(ctx, tx, id, perms)
+	})
+	if err != nil {
+		return err
+	}
+	t.perms.invalidate()
+	return nil
+}
+
+// Delete removes the team, its members lose the permissions they've inherited from it
+func (t *TeamModel) Delete(ctx context.Context, id int64) error {
+	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*3)
+	defer cancelFunc()
+	result, err := t.db.NewDelete().Model((*Team)(nil)).Where("id = ?", id).Exec(timeoutCtx)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrorRecordNotFound
+	}
+	t.perms.invalidate()
+	return nil
+}
+
+// AddMembers adds the users to the team, the ones already in it are left as they are. It returns the number of the
+// users added. Unknown teams and users are reported by ErrForeignKeyViolation.
+func (t *TeamModel) AddMembers(ctx context.Context, id int64, userIDs []uuid.UUID) (int64, error) {
+	members := make([]TeamMember, 0, len(userIDs))
+	for _, userID := range userIDs {
+		members = append(members, TeamMember{TeamID: id, UserID: userID})
+	}
+	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*10)
+	defer cancelFunc()
+	result, err := t.db.NewInsert().Model(&members).Column("team_id", "user_id").On("CONFLICT DO NOTHING").Exec(timeoutCtx)
+	if err != nil {
+		return 0, mapPgError(err)
+	}
+	t.perms.invalidate(userIDs...)
+	n, _ := result.RowsAffected()
+	return n, nil
+}
+
+// RemoveMember removes the user from the team
+func (t *TeamModel) RemoveMember(ctx context.Context, id int64, userID uuid.UUID) error {
+	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*3)
+	defer cancelFunc()
+	result, err := t.db.NewDelete().Model((*TeamMember)(nil)).Where("team_id = ? AND user_id = ?", id, userID).Exec(timeoutCtx)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrorRecordNotFound
+	}
+	t.perms.invalidate(userID)
+	return nil
+}
+
+// ListMembers returns the members of the team along with their users, the latest added first
+func (t *TeamModel) ListMembers(ctx context.Context, id int64) ([]TeamMember, error) {
+	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*3)
+	defer cancelFunc()
+	members := []TeamMember{}
+	err := t.db.NewSelect().Model(&members).Relation("User").
+		Where("team_member.team_id = ?", id).
+		Order("team_member.created_at DESC", "team_member.user_id ASC").Scan(timeoutCtx)
+	if err != nil {
+		return nil, err
+	}
+	return members, nil
+}
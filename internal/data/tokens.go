@@ -1,10 +1,10 @@
 package data
 
 import (
-	"bytes"
 	"context"
 	"crypto/rand"
 	"crypto/sha256"
+	"crypto/subtle"
 	"database/sql"
 	"encoding/base32"
 	"errors"
@@ -14,9 +14,12 @@ import (
 	"github.com/uptrace/bun"
 )
 
+var ErrorInvalidToken = errors.New("token is invalid, expired or already used")
+
 const (
 	ActivationScope     = "activation"
 	AuthenticationScope = "BearerAuthentication"
+	PasswordResetScope  = "password-reset"
 )
 
 type TokenModel struct {
@@ -33,6 +36,8 @@ type Token struct {
 	User          *User     `json:"-" bun:"rel:belongs-to,join:user_id=id"`
 	Expiry        time.Time `json:"expiry" bun:",notnull,type:timestamptz"`
 	Scope         string    `json:"scope" bun:",type:text,notnull"`
+	// UsedAt is set once a single use token, like the activation tokens, has been consumed
+	UsedAt *time.Time `json:"-" bun:",type:timestamptz,nullzero"`
 }
 
 func generateToken(userID uuid.UUID, ttl time.Duration, scope string) (*Token, error) {
@@ -57,10 +62,11 @@ func generateToken(userID uuid.UUID, ttl time.Duration, scope string) (*Token, e
 	return nToken, nil
 }
 
+// Match returns the token whose hash matches the plaintext. The hashes are compared in constant time.
 func (t Tokens) Match(token string) (*Token, bool) {
 	hash := sha256.Sum256([]byte(token))
 	for _, v := range t {
-		if bytes.Equal(v.Hash, hash[:]) {
+		if subtle.ConstantTimeCompare(v.Hash, hash[:]) == 1 {
 			return v, true
 		}
 	}
@@ -68,11 +74,16 @@ func (t Tokens) Match(token string) (*Token, bool) {
 }
 
 func (tm TokenModel) New(ctx context.Context, ttl time.Duration, userID uuid.UUID, tokenScope string) (*Token, error) {
+	return tm.NewTx(ctx, tm.db, ttl, userID, tokenScope)
+}
+
+// NewTx is New within the transaction tx
+func (tm TokenModel) NewTx(ctx context.Context, tx bun.IDB, ttl time.Duration, userID uuid.UUID, tokenScope string) (*Token, error) {
 	nToken, err := generateToken(userID, ttl, tokenScope)
 	if err != nil {
 		return nil, err
 	}
-	err = tm.InsertToken(ctx, nToken)
+	err = insertToken(ctx, tx, nToken)
 	if err != nil {
 		return nil, err
 	}
@@ -81,11 +92,15 @@ func (tm TokenModel) New(ctx context.Context, ttl time.Duration, userID uuid.UUI
 }
 
 func (tm TokenModel) InsertToken(ctx context.Context, t *Token) error {
+	return insertToken(ctx, tm.db, t)
+}
+
+func insertToken(ctx context.Context, db bun.IDB, t *Token) error {
 	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*5)
 	defer cancelFunc()
-	_, err := tm.db.NewInsert().Model(t).Exec(timeoutCtx)
+	_, err := db.NewInsert().Model(t).Exec(timeoutCtx)
 	if err != nil {
-		return err
+		return mapPgError(err)
 	}
 	return nil
 }
@@ -120,6 +135,6 @@ func (tm TokenModel) DeleteAllForUser(ctx context.Context, userID uuid.UUID, sco
 }
 
 func ValidateTokenPlaintext(v *Validator, tokenPlaintext string) {
-	v.Check(tokenPlaintext != "", "token", "must be provided")
+	v.Apply("token", Required(tokenPlaintext))
 	v.Check(len(tokenPlaintext) == 26, "token", "must be 26 bytes long")
 }
@@ -0,0 +1,44 @@
+package data
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func BenchmarkGenerateToken(b *testing.B) {
+	userID := uuid.New()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := generateToken(userID, time.Hour, AuthenticationScope); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkTokensMatch(b *testing.B) {
+	userID := uuid.New()
+
+	// the tokens of a user are matched one by one, so the lookup grows with the number of the sessions of the user
+	for _, count := range []int{1, 10, 100} {
+		tokens := make(Tokens, count)
+		for i := range tokens {
+			token, err := generateToken(userID, time.Hour, AuthenticationScope)
+			if err != nil {
+				b.Fatal(err)
+			}
+			tokens[i] = token
+		}
+		last := tokens[count-1].PlainText
+		b.Run(fmt.Sprintf("%d tokens", count), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, found := tokens.Match(last); !found {
+					b.Fatal("token not found")
+				}
+			}
+		})
+	}
+}
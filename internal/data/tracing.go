@@ -0,0 +1,83 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"runtime"
+	"strings"
+
+	"github.com/uptrace/bun"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "database.tracer"
+
+// QueryTracer starts a span for every query of the models, named by the model method running it like
+// "MovieModel.Insert", so the database time shows up under the span of the handler. The span carries the operation,
+// the table, the rows affected and the error of the query. The statement itself is on the child span of otelsql.
+type QueryTracer struct{}
+
+type querySpanKey struct{}
+
+func (QueryTracer) BeforeQuery(ctx context.Context, event *bun.QueryEvent) context.Context {
+	operation := event.Operation()
+	table := ""
+	if q, ok := event.IQuery.(interface{ GetTableName() string }); ok {
+		table = strings.Trim(q.GetTableName(), `"`)
+	}
+	name := modelMethod()
+	if name == "" {
+		name = strings.TrimSpace(operation + " " + table)
+	}
+	ctx, span := otel.Tracer(tracerName).Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient))
+	span.SetAttributes(
+		attribute.String("db.operation", operation),
+		attribute.String("db.sql.table", table),
+	)
+	return context.WithValue(ctx, querySpanKey{}, span)
+}
+
+func (QueryTracer) AfterQuery(ctx context.Context, event *bun.QueryEvent) {
+	span, ok := ctx.Value(querySpanKey{}).(trace.Span)
+	if !ok {
+		return
+	}
+	defer span.End()
+	if event.Result != nil {
+		if n, err := event.Result.RowsAffected(); err == nil {
+			span.SetAttributes(attribute.Int64("db.rows_affected", n))
+		}
+	}
+	switch {
+	case event.Err == nil:
+	// a missing record is an expected outcome of the lookups rather than a failure of the database
+	case errors.Is(event.Err, sql.ErrNoRows):
+		span.RecordError(event.Err)
+	default:
+		span.RecordError(event.Err)
+		span.SetStatus(codes.Error, "error in interaction with database")
+	}
+}
+
+// modelMethod returns the model method the query is run by, like "MovieModel.Insert", or empty when it's run elsewhere
+func modelMethod() string {
+	pcs := make([]uintptr, 32)
+	n := runtime.Callers(3, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	for {
+		frame, more := frames.Next()
+		// github.com/cybrarymin/greenlight/internal/data.(*MovieModel).Insert.func1
+		if _, fn, found := strings.Cut(frame.Function, "/internal/data.("); found {
+			receiver, method, _ := strings.Cut(fn, ").")
+			method, _, _ = strings.Cut(method, ".")
+			return strings.TrimPrefix(receiver, "*") + "." + method
+		}
+		if !more {
+			return ""
+		}
+	}
+}
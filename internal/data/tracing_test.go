@@ -0,0 +1,64 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uptrace/bun"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+type tracedModel struct{}
+
+// run runs the hooks of a query like bun does for the queries of the model methods
+func (m *tracedModel) run(ctx context.Context, event *bun.QueryEvent) {
+	ctx = QueryTracer{}.BeforeQuery(ctx, event)
+	QueryTracer{}.AfterQuery(ctx, event)
+}
+
+type rowsResult int64
+
+func (r rowsResult) LastInsertId() (int64, error) { return 0, nil }
+func (r rowsResult) RowsAffected() (int64, error) { return int64(r), nil }
+
+func TestQueryTracer(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	prev := otel.GetTracerProvider()
+	defer otel.SetTracerProvider(prev)
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	tests := []struct {
+		name       string
+		event      *bun.QueryEvent
+		wantStatus codes.Code
+		wantRows   int64
+	}{
+		{name: "Rows affected", event: &bun.QueryEvent{Query: "UPDATE movies SET version = version + 1", Result: rowsResult(3)}, wantStatus: codes.Unset, wantRows: 3},
+		{name: "Missing record", event: &bun.QueryEvent{Query: "SELECT * FROM movies", Err: sql.ErrNoRows}, wantStatus: codes.Unset},
+		{name: "Failure", event: &bun.QueryEvent{Query: "SELECT * FROM movies", Err: errors.New("connection reset")}, wantStatus: codes.Error},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			(&tracedModel{}).run(context.Background(), tc.event)
+
+			spans := recorder.Ended()
+			require.NotEmpty(t, spans)
+			span := spans[len(spans)-1]
+			assert.Equal(t, "tracedModel.run", span.Name())
+			assert.Equal(t, tc.wantStatus, span.Status().Code)
+			assert.Contains(t, span.Attributes(), attribute.String("db.operation", tc.event.Operation()))
+			if tc.wantRows != 0 {
+				assert.Contains(t, span.Attributes(), attribute.Int64("db.rows_affected", tc.wantRows))
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package data
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+type UsageModel struct {
+	db *bun.DB
+}
+
+// RequestUsage is the number of requests a user has sent to an endpoint in a day
+type RequestUsage struct {
+	bun.BaseModel `bun:"table:request_usage"`
+	UserID        uuid.UUID `bun:",pk,type:uuid"`
+	Day           time.Time `bun:",pk,type:date"`
+	Endpoint      string    `bun:",pk"`
+	Count         int64     `bun:",notnull"`
+}
+
+// RequestQuota is the number of requests a user has sent in a month, counted against the monthly quota
+type RequestQuota struct {
+	bun.BaseModel `bun:"table:request_quota"`
+	UserID        uuid.UUID `bun:",pk,type:uuid"`
+	Month         time.Time `bun:",pk,type:date"`
+	Count         int64     `bun:",notnull"`
+}
+
+type EndpointUsage struct {
+	Endpoint string `json:"endpoint" bun:"endpoint"`
+	Count    int64  `json:"count" bun:"count"`
+}
+
+// Increment adds one request of the user to the counter of the endpoint for the day
+func (u *UsageModel) Increment(ctx context.Context, userID uuid.UUID, endpoint string, day time.Time) error {
+	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*3)
+	defer cancelFunc()
+	usage := &RequestUsage{
+		UserID:   userID,
+		Day:      day,
+		Endpoint: endpoint,
+		Count:    1,
+	}
+	_, err := u.db.NewInsert().Model(usage).On("CONFLICT (user_id, day, endpoint) DO UPDATE").Set("count = request_usage.count + 1").Exec(timeoutCtx)
+	return mapPgError(err)
+}
+
+// Consume counts a request of the user against the quota of the month starting at month and returns the number of
+// requests of the month including it. The concurrent requests of the user wait for each other on the row of the month,
+// so each of them gets its own count.
+func (u *UsageModel) Consume(ctx context.Context, userID uuid.UUID, month time.Time) (int64, error) {
+	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*3)
+	defer cancelFunc()
+	quota := &RequestQuota{
+		UserID: userID,
+		Month:  month,
+		Count:  1,
+	}
+	err := u.db.NewInsert().Model(quota).On("CONFLICT (user_id, month) DO UPDATE").Set("count = request_quota.count + 1").
+		Returning("count").Scan(timeoutCtx, &quota.Count)
+	if err != nil {
+		return 0, mapPgError(err)
+	}
+	return quota.Count, nil
+}
+
+// PerEndpoint returns the number of requests the user has sent to each endpoint between the from and to days, both inclusive
+func (u *UsageModel) PerEndpoint(ctx context.Context, userID uuid.UUID, from time.Time, to time.Time) ([]EndpointUsage, error) {
+	usages := []EndpointUsage{}
+	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*3)
+	defer cancelFunc()
+	err := u.db.NewSelect().Model((*RequestUsage)(nil)).ColumnExpr("endpoint, SUM(count) AS count").Where("user_id = ? AND day BETWEEN ? AND ?", userID, from, to).Group("endpoint").OrderExpr("count DESC").Scan(timeoutCtx, &usages)
+	if err != nil {
+		return nil, err
+	}
+	return usages, nil
+}
@@ -12,9 +12,6 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/uptrace/bun"
-	"go.opentelemetry.io/otel"
-	"go.opentelemetry.io/otel/attribute"
-	"go.opentelemetry.io/otel/codes"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -45,15 +42,43 @@ type User struct {
 	CreatedAt     time.Time    `json:"created_at,omitempty" bun:",type:timestamptz,notnull,default:current_timestamp()"`
 	Activated     bool         `json:"activated" bun:",notnull,type:bool"`
 	Email         string       `json:"email" bun:",type:ictext,unique"`
+	EmailStatus   string       `json:"email_status" bun:",notnull,default:'ok'"`
+	ExternalID    string       `json:"-" bun:",nullzero"`
 	Version       int          `json:"-" bun:",notnull,default:1"`
+	UpdatedAt     time.Time    `json:"updated_at" bun:",type:timestamptz,notnull,nullzero,default:current_timestamp()"`
 	Token         []*Token     `json:"-" bun:",rel:has-many,join:id=user_id"`
 	Permission    []Permission `json:"-" bun:",m2m:user_permissions,join:User=Permission"`
+
+	// SuspendedUntil and Banned keep the user from signing in and using the api. check Suspended
+	SuspendedUntil   *time.Time `json:"suspended_until,omitempty" bun:",type:timestamptz,nullzero"`
+	Banned           bool       `json:"banned" bun:",notnull"`
+	SuspensionReason string     `json:"suspension_reason,omitempty" bun:",notnull"`
+
+	// PasswordResetRequired invalidates the password of the user until it's reset by a password reset token.
+	// check UserModel.ForcePasswordReset
+	PasswordResetRequired bool `json:"password_reset_required" bun:",notnull"`
+}
+
+var _ bun.BeforeAppendModelHook = (*User)(nil)
+
+// BeforeAppendModel bumps updated_at whenever the user is inserted or updated through its model
+func (u *User) BeforeAppendModel(ctx context.Context, query bun.Query) error {
+	switch query.(type) {
+	case *bun.InsertQuery, *bun.UpdateQuery:
+		u.UpdatedAt = time.Now().Truncate(time.Second)
+	}
+	return nil
 }
 
 func (u *User) IsAnonymous() bool {
 	return u == AnonymousUser
 }
 
+// Suspended reports whether the user is banned or suspended at the moment. A suspension ends by itself once its time passes.
+func (u *User) Suspended() bool {
+	return u.Banned || (u.SuspendedUntil != nil && time.Now().Before(*u.SuspendedUntil))
+}
+
 type Password struct {
 	Plaintext *string
 	Hash      []byte
@@ -103,35 +128,260 @@ func (u *UserModel) Insert(ctx context.Context, user *User) error {
 	defer cancelFunc()
 	err := u.db.NewInsert().Model(user).Returning("id, activated, created_at, version").Scan(timeoutCtx, args...)
 	if err != nil {
-		switch {
-		case strings.Contains(err.Error(), "SQLSTATE=23505"):
-			return ErrorDuplicateEmail
-		default:
+		return mapPgError(err)
+	}
+	return nil
+}
+
+// Register inserts the user along with its permissions and the OutboxUserWelcome event in a single transaction,
+// so a registered user always gets the permissions and the welcome mail, or isn't registered at all.
+func (u *UserModel) Register(ctx context.Context, user *User, perms ...string) error {
+	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*5)
+	defer cancelFunc()
+	err := u.db.RunInTx(timeoutCtx, nil, func(ctx context.Context, tx bun.Tx) error {
+		err := tx.NewInsert().Model(user).Returning("id, activated, created_at, version").Scan(ctx, &user.ID, &user.Activated, &user.CreatedAt, &user.Version)
+		if err != nil {
 			return err
 		}
+		if len(perms) > 0 {
+			permsObj := Permissions{}
+			err = tx.NewSelect().Model(&permsObj).Where("code IN (?)", bun.In(perms)).Scan(ctx)
+			if err != nil {
+				return err
+			}
+			nUserPerm := make([]UserPermission, 0, len(permsObj))
+			for _, v := range permsObj {
+				nUserPerm = append(nUserPerm, UserPermission{UserID: user.ID, PermissionID: v.ID})
+			}
+			_, err = tx.NewInsert().Model(&nUserPerm).Exec(ctx)
+			if err != nil {
+				return err
+			}
+		}
+		return enqueueOutbox(ctx, tx, OutboxUserWelcome, UserWelcomePayload{UserID: user.ID})
+	})
+	return mapPgError(err)
+}
+
+// InsertMany inserts the users in chunks and fills in their generated columns. Emails are the conflict key.
+// With ConflictSkip the users whose email already exists keep the nil id, with ConflictUpdate only their name is overwritten.
+func (u *UserModel) InsertMany(ctx context.Context, users []*User, opts BulkInsertOptions) (int, error) {
+	return u.InsertManyTx(ctx, u.db, users, opts)
+}
+
+// InsertManyTx is InsertMany within the transaction tx
+func (u *UserModel) InsertManyTx(ctx context.Context, tx bun.IDB, users []*User, opts BulkInsertOptions) (int, error) {
+	if len(users) == 0 {
+		return 0, nil
 	}
-	return nil
+	return insertChunks(ctx, tx, users, opts.chunkSize(), func(ctx context.Context, tx bun.Tx, chunk []*User) (int, error) {
+		query := tx.NewInsert().Model(&chunk)
+		switch opts.onConflict() {
+		case ConflictSkip:
+			// skipped users aren't returned, so the returned rows are matched to the users by their email
+			returned := []User{}
+			err := query.On("CONFLICT (email) DO NOTHING").Returning("id, email, activated, created_at, version").Scan(ctx, &returned)
+			if err != nil && !errors.Is(err, sql.ErrNoRows) {
+				return 0, err
+			}
+			byEmail := make(map[string]User, len(returned))
+			for _, user := range returned {
+				byEmail[strings.ToLower(user.Email)] = user
+			}
+			for _, user := range chunk {
+				if inserted, found := byEmail[strings.ToLower(user.Email)]; found {
+					user.ID, user.Activated, user.CreatedAt, user.Version = inserted.ID, inserted.Activated, inserted.CreatedAt, inserted.Version
+					delete(byEmail, strings.ToLower(user.Email))
+				}
+			}
+			return len(returned), nil
+		case ConflictUpdate:
+			query = query.On("CONFLICT (email) DO UPDATE").Set("name = EXCLUDED.name").Set("updated_at = EXCLUDED.updated_at").Set("version = ?TableAlias.version + 1")
+		}
+		result, err := query.Returning("id, activated, created_at, version").Exec(ctx)
+		if err != nil {
+			return 0, err
+		}
+		n, _ := result.RowsAffected()
+		return int(n), nil
+	})
 }
 
+// Update writes the user only if its row still has the version the user was read with and increases the version.
+// It returns ErrEditConflict when the user has been changed in the meantime and ErrorRecordNotFound when it's been deleted.
+// user is left untouched on failure so it can be read again and retried. check RetryOnEditConflict
 func (u *UserModel) Update(id uuid.UUID, ctx context.Context, user *User) error {
-	args := []interface{}{&user.CreatedAt, &user.Version}
-	user.Version += 1
 	timeoutCtx, cancel := context.WithTimeout(ctx, time.Second*5)
 	defer cancel()
-	err := u.db.NewUpdate().Model(user).Where("id = ? and version = ?", id, user.Version-1).Returning("created_at, version").Scan(timeoutCtx, args...)
+	var createdAt time.Time
+	var version int
+	err := u.db.NewUpdate().Model(user).
+		ExcludeColumn("id", "created_at").
+		Value("version", "version + 1").
+		Where("id = ? AND version = ?", id, user.Version).
+		Returning("created_at, version").Scan(timeoutCtx, &createdAt, &version)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
-			return ErrorRecordNotFound
-		case errors.Is(err, ErrorDuplicateEmail):
-			return ErrorDuplicateEmail
+			exists, err := u.db.NewSelect().Model((*User)(nil)).Where("id = ?", id).Exists(timeoutCtx)
+			if err != nil {
+				return err
+			}
+			if !exists {
+				return ErrorRecordNotFound
+			}
+			return ErrEditConflict
 		default:
-			return err
+			return mapPgError(err)
 		}
 	}
+	user.CreatedAt, user.Version = createdAt, version
 	return nil
 }
 
+// Activate consumes the activation token of the user and activates the user in a single transaction. The token is
+// looked up by its hash, scope and expiry, so a token can only activate the user it was issued for and only once.
+// password is set as well when it's not nil. It returns ErrorInvalidToken when the token doesn't match an unused token of the user.
+func (u *UserModel) Activate(ctx context.Context, id uuid.UUID, tokenPlaintext string, password *Password) (*User, error) {
+	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*5)
+	defer cancelFunc()
+	hash := sha256.Sum256([]byte(tokenPlaintext))
+	user := &User{}
+	err := u.db.RunInTx(timeoutCtx, nil, func(ctx context.Context, tx bun.Tx) error {
+		// the row lock of the update keeps the concurrent activations from consuming the same token
+		result, err := tx.NewUpdate().Model((*Token)(nil)).Set("used_at = now()").
+			Where("hash = ? AND user_id = ? AND scope = ? AND expiry > now() AND used_at IS NULL", hash[:], id, ActivationScope).
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			return ErrorInvalidToken
+		}
+		query := tx.NewUpdate().Model(user).
+			Set("activated = true").
+			Set("version = version + 1").
+			Set("updated_at = now()").
+			Where("id = ?", id).
+			Returning("*")
+		if password != nil {
+			query = query.Set("password_hash = ?", password.Hash)
+		}
+		err = query.Scan(ctx)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrorRecordNotFound
+			default:
+				return err
+			}
+		}
+		// the other activation tokens of the user are of no use anymore
+		_, err = tx.NewUpdate().Model((*Token)(nil)).Set("used_at = now()").
+			Where("user_id = ? AND scope = ? AND used_at IS NULL", id, ActivationScope).
+			Exec(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, mapPgError(err)
+	}
+	return user, nil
+}
+
+// ForcePasswordReset invalidates the password of the user and signs the user out everywhere in a single transaction.
+// The authentication and the previous reset tokens and the sessions are deleted and the devices of the stateless
+// tokens are revoked. The returned token resets the password. check ResetPassword
+func (u *UserModel) ForcePasswordReset(ctx context.Context, id uuid.UUID, ttl time.Duration) (*User, *Token, error) {
+	nToken, err := generateToken(id, ttl, PasswordResetScope)
+	if err != nil {
+		return nil, nil, err
+	}
+	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*5)
+	defer cancelFunc()
+	user := &User{}
+	err = u.db.RunInTx(timeoutCtx, nil, func(ctx context.Context, tx bun.Tx) error {
+		err := tx.NewUpdate().Model(user).
+			Set("password_reset_required = true").
+			Set("version = version + 1").
+			Set("updated_at = now()").
+			Where("id = ?", id).
+			Returning("*").Scan(ctx)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrorRecordNotFound
+			default:
+				return err
+			}
+		}
+		_, err = tx.NewDelete().Model((*Token)(nil)).
+			Where("user_id = ? AND scope IN (?)", id, bun.In([]string{AuthenticationScope, PasswordResetScope})).
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+		_, err = tx.NewDelete().Model((*Session)(nil)).Where("user_id = ?", id).Exec(ctx)
+		if err != nil {
+			return err
+		}
+		_, err = tx.NewUpdate().Model((*Device)(nil)).Set("revoked_at = now()").
+			Where("user_id = ? AND revoked_at IS NULL", id).
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+		_, err = tx.NewInsert().Model(nToken).Exec(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, nil, mapPgError(err)
+	}
+	return user, nToken, nil
+}
+
+// ResetPassword consumes the password reset token of the user and sets the password in a single transaction.
+// It returns ErrorInvalidToken when the token isn't a live reset token of the user.
+func (u *UserModel) ResetPassword(ctx context.Context, id uuid.UUID, tokenPlaintext string, password *Password) (*User, error) {
+	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*5)
+	defer cancelFunc()
+	hash := sha256.Sum256([]byte(tokenPlaintext))
+	user := &User{}
+	err := u.db.RunInTx(timeoutCtx, nil, func(ctx context.Context, tx bun.Tx) error {
+		result, err := tx.NewUpdate().Model((*Token)(nil)).Set("used_at = now()").
+			Where("hash = ? AND user_id = ? AND scope = ? AND expiry > now() AND used_at IS NULL", hash[:], id, PasswordResetScope).
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			return ErrorInvalidToken
+		}
+		err = tx.NewUpdate().Model(user).
+			Set("password_hash = ?", password.Hash).
+			Set("password_reset_required = false").
+			Set("version = version + 1").
+			Set("updated_at = now()").
+			Where("id = ?", id).
+			Returning("*").Scan(ctx)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrorRecordNotFound
+			default:
+				return err
+			}
+		}
+		_, err = tx.NewUpdate().Model((*Token)(nil)).Set("used_at = now()").
+			Where("user_id = ? AND scope = ? AND used_at IS NULL", id, PasswordResetScope).
+			Exec(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, mapPgError(err)
+	}
+	return user, nil
+}
+
 func (u *UserModel) GetByEmail(email string, ctx context.Context) (*User, error) {
 	nUser := &User{}
 	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*5)
@@ -163,12 +413,16 @@ func (u *UserModel) GetByID(id uuid.UUID, ctx context.Context, user *User) error
 	return nil
 }
 
-func (u *UserModel) List(ctx context.Context, users *Users, name string, email string, filters *Filters) (int, error) {
+// List returns the users matching the name and email. A non zero updatedSince limits them to the users updated at or after it.
+func (u *UserModel) List(ctx context.Context, users *Users, name string, email string, updatedSince time.Time, filters *Filters) (int, error) {
 	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*5)
 	defer cancelFunc()
 
-	orderQuery := filters.SortColumn() + " " + filters.SortDirection()
-	count, err := u.db.NewSelect().Model(users).Where("((name LIKE ?) OR (? = '')) AND ((email LIKE ?) OR (? = ''))", fmt.Sprintf("%%%s%%", name), name, fmt.Sprintf("%%%s%%", email), email).Limit(filters.limit()).Offset(filters.offset()).OrderExpr(orderQuery).ScanAndCount(timeoutCtx)
+	query := u.db.NewSelect().Model(users).Where("((name LIKE ?) OR (? = '')) AND ((email LIKE ?) OR (? = ''))", fmt.Sprintf("%%%s%%", name), name, fmt.Sprintf("%%%s%%", email), email)
+	if !updatedSince.IsZero() {
+		query = query.Where("updated_at >= ?", updatedSince)
+	}
+	count, err := paginate(timeoutCtx, query, filters)
 
 	if err != nil {
 		switch {
@@ -182,6 +436,23 @@ func (u *UserModel) List(ctx context.Context, users *Users, name string, email s
 	return count, nil
 }
 
+// Find lists the users exactly matching the email and external id of the identity provider. Empty values are ignored.
+func (u *UserModel) Find(ctx context.Context, users *Users, email string, externalID string, offset int, limit int) (int, error) {
+	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*5)
+	defer cancelFunc()
+
+	count, err := u.db.NewSelect().Model(users).Where("(email = ? OR ? = '')", email, email).Where("(external_id = ? OR ? = '')", externalID, externalID).OrderExpr("created_at ASC, id ASC").Limit(limit).Offset(offset).ScanAndCount(timeoutCtx)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, ErrorRecordNotFound
+		default:
+			return 0, err
+		}
+	}
+	return count, nil
+}
+
 func (u *UserModel) Delete(ctx context.Context, id uuid.UUID) error {
 	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*5)
 	defer cancelFunc()
@@ -195,10 +466,22 @@ func (u *UserModel) Delete(ctx context.Context, id uuid.UUID) error {
 	return nil
 }
 
-func (u *UserModel) GetUserByToken(ctx context.Context, tokenPlaintext string, tokenScope string) (*User, error) {
-	ctx, span := otel.Tracer("database.tracer").Start(ctx, "database.getUserByToken.span")
-	defer span.End()
+// SetEmailStatus flags the email address of the user. it's used to stop sending emails to the addresses that bounced or complained
+func (u *UserModel) SetEmailStatus(ctx context.Context, email string, status string) error {
+	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*3)
+	defer cancelFunc()
+	// the version is increased so a concurrent update of the user doesn't overwrite the status
+	result, err := u.db.NewUpdate().Model((*User)(nil)).Set("email_status = ?", status).Set("version = version + 1").Set("updated_at = now()").Where("email = ?", email).Exec(timeoutCtx)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrorRecordNotFound
+	}
+	return nil
+}
 
+func (u *UserModel) GetUserByToken(ctx context.Context, tokenPlaintext string, tokenScope string) (*User, error) {
 	nToken := &Token{}
 	timeoutCtx, cancelFunc := context.WithTimeout(ctx, time.Second*3)
 	defer cancelFunc()
@@ -209,31 +492,22 @@ func (u *UserModel) GetUserByToken(ctx context.Context, tokenPlaintext string, t
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
-			span.RecordError(err)
 			return nil, ErrorRecordNotFound
 		default:
-			span.RecordError(err)
-			span.SetStatus(codes.Error, "error in interaction with database")
 			return nil, err
 		}
 	}
-	span.SetAttributes(attribute.String("user.name", nToken.User.Name))
-	span.SetAttributes(attribute.String("user.email", nToken.User.Email))
 	return nToken.User, nil
 }
 
 func ValidateEmail(v *Validator, email string) {
-	v.Check(email != "", "email", "must be provided")
-	v.Check(Matches(email, EmailRX), "email", "must be a valid email address")
+	v.Apply("email", Required(email), Pattern(email, EmailRX, "must be a valid email address"))
 }
 func ValidatePasswordPlaintext(v *Validator, password string) {
-	v.Check(password != "", "password", "must be provided")
-	v.Check(len(password) >= 8, "password", "must be at least 8 bytes long")
-	v.Check(len(password) <= 72, "password", "must not be more than 72 bytes long")
+	v.Apply("password", Required(password), MinLen(password, 8), MaxLen(password, 72))
 }
 func ValidateUser(v *Validator, user *User) {
-	v.Check(user.Name != "", "name", "must be provided")
-	v.Check(len(user.Name) <= 500, "name", "must not be more than 500 bytes long")
+	v.Apply("name", Required(user.Name), MaxLen(user.Name, 500))
 	// Call the standalone ValidateEmail() helper.
 	ValidateEmail(v, user.Email)
 	// If the plaintext password is not nil, call the standalone
@@ -1,18 +1,44 @@
 package data
 
-import "regexp"
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
 
 var (
 	EmailRX = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+\\/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
 )
 
+// codes of the validation errors. The messages are meant for humans, clients should rely on the codes instead.
+const (
+	CodeInvalid    = "invalid"
+	CodeRequired   = "required"
+	CodeTooShort   = "too_short"
+	CodeTooLong    = "too_long"
+	CodeOutOfRange = "out_of_range"
+	CodeNotAllowed = "not_allowed"
+	CodeDuplicate  = "duplicate"
+)
+
+// Validator collects the errors of the invalid fields. Keys are the paths of the fields, like title, genres[2]
+// or movie.title, and only the first error of each field is kept.
 type Validator struct {
 	Errors map[string]string
+	// Codes holds the code of each error in Errors
+	Codes map[string]string
+}
+
+// FieldError is a validation error along with its code
+type FieldError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
 }
 
 func NewValidator() *Validator {
 	return &Validator{
 		Errors: make(map[string]string),
+		Codes:  make(map[string]string),
 	}
 }
 
@@ -20,9 +46,15 @@ func (v *Validator) Valid() bool {
 	return len(v.Errors) == 0
 }
 
+// AddError adds the error with the invalid code. Use AddErrorCode for a more specific code.
 func (v *Validator) AddError(key, message string) {
+	v.AddErrorCode(key, CodeInvalid, message)
+}
+
+func (v *Validator) AddErrorCode(key, code, message string) {
 	if _, exists := v.Errors[key]; !exists {
 		v.Errors[key] = message
+		v.Codes[key] = code
 	}
 }
 
@@ -32,6 +64,164 @@ func (v *Validator) Check(ok bool, key, message string) {
 	}
 }
 
+func (v *Validator) CheckCode(ok bool, key, code, message string) {
+	if !ok {
+		v.AddErrorCode(key, code, message)
+	}
+}
+
+// Apply checks the rules of the field in order and adds the error of the first failing one
+func (v *Validator) Apply(key string, rules ...Rule) {
+	for _, rule := range rules {
+		if fieldErr := rule(); fieldErr != nil {
+			v.AddErrorCode(key, fieldErr.Code, fieldErr.Message)
+			return
+		}
+	}
+}
+
+// Merge adds the errors of the child validator with their keys nested under the prefix,
+// so the title error of a child validating a movie becomes movie.title
+func (v *Validator) Merge(prefix string, child *Validator) {
+	for key, message := range child.Errors {
+		code, found := child.Codes[key]
+		if !found {
+			code = CodeInvalid
+		}
+		v.AddErrorCode(Path(prefix, key), code, message)
+	}
+}
+
+// FieldErrors returns the errors along with their codes
+func (v *Validator) FieldErrors() map[string]FieldError {
+	fieldErrors := make(map[string]FieldError, len(v.Errors))
+	for key, message := range v.Errors {
+		code, found := v.Codes[key]
+		if !found {
+			code = CodeInvalid
+		}
+		fieldErrors[key] = FieldError{Code: code, Message: message}
+	}
+	return fieldErrors
+}
+
+// Path builds the key of a nested field. int elements are indexes and string elements are the names of the nested fields,
+// so Path("genres", 2) is genres[2] and Path("movie", "title") is movie.title.
+func Path(key string, elems ...interface{}) string {
+	var b strings.Builder
+	b.WriteString(key)
+	for _, elem := range elems {
+		switch e := elem.(type) {
+		case int:
+			fmt.Fprintf(&b, "[%d]", e)
+		case string:
+			// the keys of a child validator may start with an index
+			if b.Len() > 0 && !strings.HasPrefix(e, "[") {
+				b.WriteByte('.')
+			}
+			b.WriteString(e)
+		default:
+			fmt.Fprintf(&b, ".%v", e)
+		}
+	}
+	return b.String()
+}
+
+// Rule checks a single value. It returns nil when the value is valid.
+type Rule func() *FieldError
+
+func Required(value string) Rule {
+	return func() *FieldError {
+		if value == "" {
+			return &FieldError{Code: CodeRequired, Message: "must be provided"}
+		}
+		return nil
+	}
+}
+
+// MinLen and MaxLen count the bytes of the value
+func MinLen(value string, n int) Rule {
+	return func() *FieldError {
+		if len(value) < n {
+			return &FieldError{Code: CodeTooShort, Message: fmt.Sprintf("must be at least %d bytes long", n)}
+		}
+		return nil
+	}
+}
+
+func MaxLen(value string, n int) Rule {
+	return func() *FieldError {
+		if len(value) > n {
+			return &FieldError{Code: CodeTooLong, Message: fmt.Sprintf("must not be more than %d bytes long", n)}
+		}
+		return nil
+	}
+}
+
+func OneOf(value string, allowed ...string) Rule {
+	return func() *FieldError {
+		if !In(value, allowed...) {
+			return &FieldError{Code: CodeNotAllowed, Message: "must be one of " + JoinOr(allowed)}
+		}
+		return nil
+	}
+}
+
+// Between checks min <= value <= max
+func Between(value int, min int, max int) Rule {
+	return func() *FieldError {
+		if value < min || value > max {
+			return &FieldError{Code: CodeOutOfRange, Message: fmt.Sprintf("must be between %d and %d", min, max)}
+		}
+		return nil
+	}
+}
+
+// MinItems and MaxItems count the elements of a list
+func MinItems(n int, count int) Rule {
+	return func() *FieldError {
+		if count < n {
+			return &FieldError{Code: CodeTooShort, Message: fmt.Sprintf("must contain at least %d elements", n)}
+		}
+		return nil
+	}
+}
+
+func MaxItems(n int, count int) Rule {
+	return func() *FieldError {
+		if count > n {
+			return &FieldError{Code: CodeTooLong, Message: fmt.Sprintf("must not contain more than %d elements", n)}
+		}
+		return nil
+	}
+}
+
+func UniqueItems(values []string) Rule {
+	return func() *FieldError {
+		if !Unique(values) {
+			return &FieldError{Code: CodeDuplicate, Message: "must not contain duplicate values"}
+		}
+		return nil
+	}
+}
+
+func Pattern(value string, pattern *regexp.Regexp, message string) Rule {
+	return func() *FieldError {
+		if !pattern.MatchString(value) {
+			return &FieldError{Code: CodeInvalid, Message: message}
+		}
+		return nil
+	}
+}
+
+// JoinOr lists the values for the validation messages, like "a, b or c"
+func JoinOr(values []string) string {
+	if len(values) <= 1 {
+		return strings.Join(values, "")
+	}
+	return strings.Join(values[:len(values)-1], ", ") + " or " + values[len(values)-1]
+}
+
 func In(value string, list ...string) bool {
 	for i := range list {
 		if value == list[i] {
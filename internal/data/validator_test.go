@@ -0,0 +1,84 @@
+package data
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPath(t *testing.T) {
+	tests := []struct {
+		name     string
+		key      string
+		elems    []interface{}
+		expected string
+	}{
+		{name: "Key only", key: "title", expected: "title"},
+		{name: "Index", key: "genres", elems: []interface{}{2}, expected: "genres[2]"},
+		{name: "Nested field", key: "movie", elems: []interface{}{"title"}, expected: "movie.title"},
+		{name: "Nested index", key: "movies", elems: []interface{}{1, "genres", 0}, expected: "movies[1].genres[0]"},
+		{name: "Child key starting with an index", key: "genres", elems: []interface{}{"[2]"}, expected: "genres[2]"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, Path(tc.key, tc.elems...))
+		})
+	}
+}
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		name     string
+		rules    []Rule
+		expected *FieldError
+	}{
+		{name: "Valid value", rules: []Rule{Required("drama"), MaxLen("drama", 10)}, expected: nil},
+		{name: "First failing rule wins", rules: []Rule{Required(""), MinLen("", 3)}, expected: &FieldError{Code: CodeRequired, Message: "must be provided"}},
+		{name: "Too long", rules: []Rule{Required("comedy"), MaxLen("comedy", 3)}, expected: &FieldError{Code: CodeTooLong, Message: "must not be more than 3 bytes long"}},
+		{name: "Not allowed", rules: []Rule{OneOf("c", "a", "b")}, expected: &FieldError{Code: CodeNotAllowed, Message: "must be one of a or b"}},
+		{name: "Out of range", rules: []Rule{Between(0, 1, 100)}, expected: &FieldError{Code: CodeOutOfRange, Message: "must be between 1 and 100"}},
+		{name: "Duplicate items", rules: []Rule{UniqueItems([]string{"a", "a"})}, expected: &FieldError{Code: CodeDuplicate, Message: "must not contain duplicate values"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			v := NewValidator()
+			v.Apply("field", tc.rules...)
+			if tc.expected == nil {
+				assert.True(t, v.Valid())
+				return
+			}
+			assert.Equal(t, map[string]FieldError{"field": *tc.expected}, v.FieldErrors())
+		})
+	}
+}
+
+func TestMerge(t *testing.T) {
+	child := NewValidator()
+	child.Apply("title", Required(""))
+	child.AddError("[1]", "must be a domain name")
+
+	v := NewValidator()
+	v.AddError("movie.title", "already reported")
+	v.Merge("movie", child)
+	v.Merge("allowlist", child)
+
+	assert.Equal(t, map[string]FieldError{
+		"movie.title":     {Code: CodeInvalid, Message: "already reported"},
+		"movie[1]":        {Code: CodeInvalid, Message: "must be a domain name"},
+		"allowlist.title": {Code: CodeRequired, Message: "must be provided"},
+		"allowlist[1]":    {Code: CodeInvalid, Message: "must be a domain name"},
+	}, v.FieldErrors())
+}
+
+func TestMovieValidator(t *testing.T) {
+	movie := Movie{Title: "Moana", Year: 2016, Runtime: 107, Genres: []string{"animation", "", "animation"}}
+	v := NewValidator()
+	movie.Validator(v)
+
+	assert.Equal(t, map[string]FieldError{
+		"genres":    {Code: CodeDuplicate, Message: "must not contain duplicate values"},
+		"genres[1]": {Code: CodeRequired, Message: "must be provided"},
+	}, v.FieldErrors())
+}
@@ -0,0 +1,32 @@
+package httpclient
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBreaker(t *testing.T) {
+	now := time.Now()
+	b := newBreaker(2, time.Minute)
+	b.now = func() time.Time { return now }
+
+	assert.True(t, b.allow(), "expected closed breaker to allow calls")
+	b.failure()
+	assert.True(t, b.allow(), "expected breaker to stay closed below the threshold")
+	b.failure()
+	assert.False(t, b.allow(), "expected breaker to open after reaching the threshold")
+
+	now = now.Add(time.Minute)
+	assert.True(t, b.allow(), "expected a trial call after the cooldown")
+	assert.False(t, b.allow(), "expected only one trial call while half-open")
+
+	b.failure()
+	assert.False(t, b.allow(), "expected failed trial to restart the cooldown")
+
+	now = now.Add(time.Minute)
+	assert.True(t, b.allow(), "expected a trial call after the cooldown")
+	b.success()
+	assert.True(t, b.allow(), "expected successful trial to close the breaker")
+}
@@ -0,0 +1,192 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/propagation"
+	"golang.org/x/time/rate"
+)
+
+var (
+	ErrCircuitOpen = errors.New("circuit breaker is open for the host")
+)
+
+// Config defines the behavior of the client for outgoing calls to the external integrations.
+type Config struct {
+	// Timeout of each attempt, including reading the response body
+	Timeout time.Duration
+	// MaxRetries is the number of retries after the first attempt. only idempotent requests or requests with GetBody are retried
+	MaxRetries int
+	// RetryBackoff is the base delay between the retries. it doubles on each retry with added jitter
+	RetryBackoff time.Duration
+	// PerHostRateLimit is the number of requests per second allowed to each host. zero means no limit
+	PerHostRateLimit float64
+	// BreakerThreshold is the number of consecutive failures which opens the circuit breaker of a host. zero disables the breaker
+	BreakerThreshold int
+	// BreakerCooldown is the amount of time the breaker stays open before a trial request is let through
+	BreakerCooldown time.Duration
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Timeout:          10 * time.Second,
+		MaxRetries:       2,
+		RetryBackoff:     200 * time.Millisecond,
+		PerHostRateLimit: 10,
+		BreakerThreshold: 5,
+		BreakerCooldown:  30 * time.Second,
+	}
+}
+
+// Client is an http client for the calls to the external services.
+// Every call is traced by otelhttp, bounded by the per host rate limit and circuit breaker and retried on transient failures.
+type Client struct {
+	http  *http.Client
+	cfg   Config
+	mu    sync.Mutex
+	hosts map[string]*host
+}
+
+type host struct {
+	limiter *rate.Limiter
+	breaker *breaker
+}
+
+func New(cfg Config) *Client {
+	return &Client{
+		http: &http.Client{
+			// the baggage carries the id of the user, which isn't meant for the external integrations
+			Transport: otelhttp.NewTransport(http.DefaultTransport, otelhttp.WithPropagators(propagation.TraceContext{})),
+			Timeout:   cfg.Timeout,
+		},
+		cfg:   cfg,
+		hosts: make(map[string]*host),
+	}
+}
+
+func (c *Client) host(name string) *host {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	h, ok := c.hosts[name]
+	if !ok {
+		limit := rate.Inf
+		if c.cfg.PerHostRateLimit > 0 {
+			limit = rate.Limit(c.cfg.PerHostRateLimit)
+		}
+		h = &host{
+			limiter: rate.NewLimiter(limit, 1),
+			breaker: newBreaker(c.cfg.BreakerThreshold, c.cfg.BreakerCooldown),
+		}
+		c.hosts[name] = h
+	}
+	return h
+}
+
+// Do sends the request. Responses with status 429 or 5xx are retried like network errors,
+// the last response is returned to the caller in case all the attempts fail with one of them.
+func (c *Client) Do(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	h := c.host(req.URL.Host)
+	attempts := 1
+	if retryable(req) {
+		attempts += c.cfg.MaxRetries
+	}
+
+	var resp *http.Response
+	var err error
+	for i := 0; i < attempts; i++ {
+		if i > 0 {
+			if err := sleep(ctx, c.backoff(i)); err != nil {
+				return nil, err
+			}
+			if req, err = rewind(req); err != nil {
+				return nil, err
+			}
+		}
+		if !h.breaker.allow() {
+			return nil, fmt.Errorf("%w: %s", ErrCircuitOpen, req.URL.Host)
+		}
+		if err := h.limiter.Wait(ctx); err != nil {
+			return nil, err
+		}
+
+		resp, err = c.http.Do(req)
+		if err == nil && !transientStatus(resp.StatusCode) {
+			h.breaker.success()
+			return resp, nil
+		}
+		h.breaker.failure()
+		if i < attempts-1 && resp != nil {
+			// drain the body to let the connection be reused by the next attempt
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+	}
+	return resp, err
+}
+
+// Get is a shortcut for GET requests with the given context.
+func (c *Client) Get(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(req)
+}
+
+func (c *Client) backoff(retry int) time.Duration {
+	d := c.cfg.RetryBackoff << (retry - 1)
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d)))
+}
+
+func retryable(req *http.Request) bool {
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return false
+	}
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	default:
+		return req.Header.Get("Idempotency-Key") != ""
+	}
+}
+
+func transientStatus(code int) bool {
+	return code == http.StatusTooManyRequests || code >= http.StatusInternalServerError
+}
+
+// rewind returns a copy of the request with a fresh body for the next attempt.
+func rewind(req *http.Request) (*http.Request, error) {
+	if req.GetBody == nil {
+		return req, nil
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	nreq := req.Clone(req.Context())
+	nreq.Body = body
+	return nreq, nil
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
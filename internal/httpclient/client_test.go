@@ -0,0 +1,150 @@
+package httpclient
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newRequest returns the request of the method to the url. The body can't be rewound when rewindable is false.
+func newRequest(t *testing.T, method string, url string, body string, idempotencyKey string, rewindable bool) *http.Request {
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(context.Background(), method, url, reader)
+	require.NoError(t, err)
+	if !rewindable {
+		req.GetBody = nil
+	}
+	if idempotencyKey != "" {
+		req.Header.Set("Idempotency-Key", idempotencyKey)
+	}
+	return req
+}
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		name      string
+		req       *http.Request
+		retryable bool
+	}{
+		{name: "GET", req: newRequest(t, http.MethodGet, "http://example.com", "", "", true), retryable: true},
+		{name: "HEAD", req: newRequest(t, http.MethodHead, "http://example.com", "", "", true), retryable: true},
+		{name: "PUT", req: newRequest(t, http.MethodPut, "http://example.com", "{}", "", true), retryable: true},
+		{name: "DELETE", req: newRequest(t, http.MethodDelete, "http://example.com", "", "", true), retryable: true},
+		{name: "POST", req: newRequest(t, http.MethodPost, "http://example.com", "{}", "", true)},
+		{name: "PATCH", req: newRequest(t, http.MethodPatch, "http://example.com", "{}", "", true)},
+		{name: "POST with Idempotency-Key", req: newRequest(t, http.MethodPost, "http://example.com", "{}", "3f1c", true), retryable: true},
+		// the body can't be sent again
+		{name: "PUT without GetBody", req: newRequest(t, http.MethodPut, "http://example.com", "{}", "", false)},
+		{name: "POST with Idempotency-Key without GetBody", req: newRequest(t, http.MethodPost, "http://example.com", "{}", "3f1c", false)},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.retryable, retryable(tc.req))
+		})
+	}
+}
+
+// statusServer replies by the statuses in their order, repeating the last one, and records the bodies of the requests
+type statusServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	statuses []int
+	bodies   []string
+}
+
+func newStatusServer(t *testing.T, statuses ...int) *statusServer {
+	s := &statusServer{statuses: statuses}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		s.mu.Lock()
+		status := s.statuses[min(len(s.bodies), len(s.statuses)-1)]
+		s.bodies = append(s.bodies, string(body))
+		s.mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *statusServer) attempts() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.bodies...)
+}
+
+func TestClientDo(t *testing.T) {
+	tests := []struct {
+		name           string
+		method         string
+		body           string
+		idempotencyKey string
+		statuses       []int
+		attempts       int
+		status         int
+	}{
+		{name: "Succeeded", method: http.MethodGet, statuses: []int{http.StatusOK}, attempts: 1, status: http.StatusOK},
+		{name: "Server error retried", method: http.MethodGet, statuses: []int{http.StatusServiceUnavailable, http.StatusOK}, attempts: 2, status: http.StatusOK},
+		{name: "Too many requests retried", method: http.MethodGet, statuses: []int{http.StatusTooManyRequests, http.StatusOK}, attempts: 2, status: http.StatusOK},
+		// the last response is returned once all the attempts have failed
+		{name: "Retries used up", method: http.MethodGet, statuses: []int{http.StatusBadGateway}, attempts: 3, status: http.StatusBadGateway},
+		{name: "Client error not retried", method: http.MethodGet, statuses: []int{http.StatusNotFound}, attempts: 1, status: http.StatusNotFound},
+		{name: "POST never retried", method: http.MethodPost, body: `{"title":"Moana"}`, statuses: []int{http.StatusInternalServerError, http.StatusOK}, attempts: 1, status: http.StatusInternalServerError},
+		{name: "POST never retried on too many requests", method: http.MethodPost, body: `{"title":"Moana"}`, statuses: []int{http.StatusTooManyRequests, http.StatusOK}, attempts: 1, status: http.StatusTooManyRequests},
+		{name: "POST with Idempotency-Key retried", method: http.MethodPost, body: `{"title":"Moana"}`, idempotencyKey: "3f1c", statuses: []int{http.StatusInternalServerError, http.StatusOK}, attempts: 2, status: http.StatusOK},
+		{name: "PUT retried", method: http.MethodPut, body: `{"title":"Moana"}`, statuses: []int{http.StatusTooManyRequests, http.StatusOK}, attempts: 2, status: http.StatusOK},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			server := newStatusServer(t, tc.statuses...)
+			c := New(Config{Timeout: 5 * time.Second, MaxRetries: 2, RetryBackoff: time.Millisecond})
+			resp, err := c.Do(newRequest(t, tc.method, server.URL, tc.body, tc.idempotencyKey, true))
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			assert.Equal(t, tc.status, resp.StatusCode)
+			attempts := server.attempts()
+			require.Len(t, attempts, tc.attempts)
+			for _, body := range attempts {
+				assert.Equal(t, tc.body, body, "each attempt sends the whole body")
+			}
+		})
+	}
+}
+
+func TestClientDoCircuitOpen(t *testing.T) {
+	server := newStatusServer(t, http.StatusInternalServerError)
+	c := New(Config{Timeout: 5 * time.Second, MaxRetries: 2, RetryBackoff: time.Millisecond, BreakerThreshold: 2, BreakerCooldown: time.Minute})
+
+	_, err := c.Do(newRequest(t, http.MethodGet, server.URL, "", "", true))
+	assert.ErrorIs(t, err, ErrCircuitOpen, "the breaker opens during the retries")
+	assert.Len(t, server.attempts(), 2)
+
+	_, err = c.Do(newRequest(t, http.MethodGet, server.URL, "", "", true))
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+	assert.Len(t, server.attempts(), 2, "no request is sent while the breaker is open")
+}
+
+func TestClientDoCanceled(t *testing.T) {
+	server := newStatusServer(t, http.StatusServiceUnavailable)
+	c := New(Config{Timeout: 5 * time.Second, MaxRetries: 2, RetryBackoff: time.Hour})
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	req := newRequest(t, http.MethodGet, server.URL, "", "", true).WithContext(ctx)
+	_, err := c.Do(req)
+	assert.ErrorIs(t, err, context.DeadlineExceeded, "the backoff is cut short by the context")
+	assert.Len(t, server.attempts(), 1)
+}
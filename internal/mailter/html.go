@@ -0,0 +1,174 @@
+package mailer
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+var (
+	cssCommentRX = regexp.MustCompile(`(?s)/\*.*?\*/`)
+	blankLinesRX = regexp.MustCompile(`\n{3,}`)
+	spacesRX     = regexp.MustCompile(`[ \t\r\n\f]+`)
+)
+
+// htmlToText converts the rendered html body to a plain text body for the mail clients that don't display html.
+func htmlToText(body string) (string, error) {
+	doc, err := html.Parse(strings.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	buf := new(bytes.Buffer)
+	writeText(buf, doc)
+	lines := strings.Split(buf.String(), "\n")
+	for i := range lines {
+		lines[i] = strings.TrimSpace(lines[i])
+	}
+	text := blankLinesRX.ReplaceAllString(strings.Join(lines, "\n"), "\n\n")
+	return strings.TrimSpace(text) + "\n", nil
+}
+
+func writeText(buf *bytes.Buffer, n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		buf.WriteString(spacesRX.ReplaceAllString(n.Data, " "))
+		return
+	case html.ElementNode:
+		switch n.DataAtom {
+		case atom.Head, atom.Style, atom.Script, atom.Title:
+			return
+		case atom.Br:
+			buf.WriteString("\n")
+			return
+		}
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		writeText(buf, c)
+	}
+	if n.Type != html.ElementNode {
+		return
+	}
+	switch n.DataAtom {
+	case atom.A:
+		// keep the links usable in plain text
+		if href := attr(n, "href"); href != "" && !strings.HasPrefix(href, "#") {
+			buf.WriteString(" (" + href + ")")
+		}
+	case atom.P, atom.Div, atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6, atom.Table:
+		buf.WriteString("\n\n")
+	case atom.Li, atom.Tr:
+		buf.WriteString("\n")
+	case atom.Td, atom.Th:
+		buf.WriteString(" ")
+	}
+}
+
+type cssRule struct {
+	selector     string
+	declarations string
+}
+
+// inlineCSS moves the css rules into the style attribute of the matching elements since most of the mail clients ignore <style> elements.
+// Only simple selectors are supported: tag, .class, #id and tag.class. The existing style attributes win over the rules.
+func inlineCSS(body, css string) (string, error) {
+	rules := parseCSS(css)
+	if len(rules) == 0 {
+		return body, nil
+	}
+	doc, err := html.Parse(strings.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	applyCSS(doc, rules)
+	buf := new(bytes.Buffer)
+	err = html.Render(buf, doc)
+	if err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func parseCSS(css string) []cssRule {
+	var rules []cssRule
+	css = cssCommentRX.ReplaceAllString(css, "")
+	for _, block := range strings.Split(css, "}") {
+		selectors, declarations, found := strings.Cut(block, "{")
+		declarations = strings.TrimSpace(declarations)
+		if !found || declarations == "" {
+			continue
+		}
+		declarations = strings.TrimSuffix(declarations, ";")
+		for _, selector := range strings.Split(selectors, ",") {
+			selector = strings.TrimSpace(selector)
+			if selector != "" {
+				rules = append(rules, cssRule{selector: selector, declarations: declarations})
+			}
+		}
+	}
+	return rules
+}
+
+func applyCSS(n *html.Node, rules []cssRule) {
+	if n.Type == html.ElementNode {
+		var styles []string
+		for _, rule := range rules {
+			if matchSelector(n, rule.selector) {
+				styles = append(styles, rule.declarations)
+			}
+		}
+		if len(styles) > 0 {
+			if existing := strings.TrimSuffix(strings.TrimSpace(attr(n, "style")), ";"); existing != "" {
+				styles = append(styles, existing)
+			}
+			setAttr(n, "style", strings.Join(styles, "; "))
+		}
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		applyCSS(c, rules)
+	}
+}
+
+func matchSelector(n *html.Node, selector string) bool {
+	switch {
+	case strings.HasPrefix(selector, "#"):
+		return attr(n, "id") == selector[1:]
+	case strings.HasPrefix(selector, "."):
+		return hasClass(n, selector[1:])
+	case strings.Contains(selector, "."):
+		tag, class, _ := strings.Cut(selector, ".")
+		return n.Data == tag && hasClass(n, class)
+	default:
+		return n.Data == selector
+	}
+}
+
+func hasClass(n *html.Node, class string) bool {
+	for _, c := range strings.Fields(attr(n, "class")) {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func setAttr(n *html.Node, key, value string) {
+	for i := range n.Attr {
+		if n.Attr[i].Key == key {
+			n.Attr[i].Val = value
+			return
+		}
+	}
+	n.Attr = append(n.Attr, html.Attribute{Key: key, Val: value})
+}
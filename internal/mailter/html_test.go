@@ -0,0 +1,66 @@
+package mailer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTMLToText(t *testing.T) {
+	tests := []struct {
+		name string
+		html string
+		want string
+	}{
+		{name: "Paragraphs", html: `<p>Hi,</p><p>Welcome   to
+			Greenlight!</p>`, want: "Hi,\n\nWelcome to Greenlight!\n"},
+		{name: "Link", html: `<p>Activate it on <a href="https://greenlight.com/activate">greenlight</a></p>`, want: "Activate it on greenlight (https://greenlight.com/activate)\n"},
+		{name: "Anchor link", html: `<p><a href="#top">top</a></p>`, want: "top\n"},
+		{name: "Line break", html: `<p>Thanks,<br>The Greenlight Team</p>`, want: "Thanks,\nThe Greenlight Team\n"},
+		{name: "List", html: `<ul><li>one</li><li>two</li></ul>`, want: "one\ntwo\n"},
+		{name: "Table", html: `<table><tr><td>Title</td><td>Year</td></tr></table>`, want: "Title Year\n"},
+		{name: "Head, style and script", html: `<html><head><title>Welcome</title><style>p { color: red; }</style></head><body><script>alert(1)</script><p>Hi</p></body></html>`, want: "Hi\n"},
+		{name: "Blank lines", html: `<div><p>Hi</p></div><div></div><p>Bye</p>`, want: "Hi\n\nBye\n"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			text, err := htmlToText(tc.html)
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, text)
+		})
+	}
+}
+
+func TestInlineCSS(t *testing.T) {
+	tests := []struct {
+		name string
+		html string
+		css  string
+		want string
+	}{
+		{name: "Tag", html: `<p>Hi</p>`, css: `p { color: red; }`, want: `<p style="color: red">Hi</p>`},
+		{name: "Class", html: `<span class="big code">42</span>`, css: `.code { font-family: monospace; }`, want: `<span class="big code" style="font-family: monospace">42</span>`},
+		{name: "ID", html: `<div id="footer">Bye</div>`, css: `#footer { font-size: 12px }`, want: `<div id="footer" style="font-size: 12px">Bye</div>`},
+		{name: "Tag and class", html: `<p class="code">1</p><span class="code">2</span>`, css: `span.code { color: blue; }`, want: `<p class="code">1</p><span class="code" style="color: blue">2</span>`},
+		{name: "Selector list", html: `<h1>A</h1><h2>B</h2>`, css: `h1, h2 { margin: 0; }`, want: `<h1 style="margin: 0">A</h1><h2 style="margin: 0">B</h2>`},
+		// the style attribute is the last so its declarations win over the rules
+		{name: "Existing style", html: `<p style="color: blue;">Hi</p>`, css: `p { color: red; }`, want: `<p style="color: red; color: blue">Hi</p>`},
+		{name: "Rules in order", html: `<p class="code">Hi</p>`, css: `p { color: red; } .code { color: green; }`, want: `<p class="code" style="color: red; color: green">Hi</p>`},
+		{name: "Comments", html: `<p>Hi</p>`, css: `/* p { color: red; } */ p { margin: 0; }`, want: `<p style="margin: 0">Hi</p>`},
+		{name: "Unmatched", html: `<p>Hi</p>`, css: `div { color: red; }`, want: `<p>Hi</p>`},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			body, err := inlineCSS(tc.html, tc.css)
+			require.NoError(t, err)
+			assert.Equal(t, "<html><head></head><body>"+tc.want+"</body></html>", body)
+		})
+	}
+
+	body, err := inlineCSS(`<p>Hi</p>`, "  ")
+	require.NoError(t, err)
+	assert.Equal(t, `<p>Hi</p>`, body, "the body is left as it is without any rule")
+}
@@ -2,9 +2,23 @@ package mailer
 
 import (
 	"bytes"
+	"context"
 	"embed"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/mail"
+	"os"
+	"path"
+	"strings"
+	"sync"
 	"text/template"
+	"time"
 
+	"github.com/google/uuid"
 	"gopkg.in/gomail.v2"
 )
 
@@ -17,40 +31,260 @@ import (
 //go:embed "templates"
 var templateFS embed.FS
 
+var (
+	ErrTemplateNotFound   = errors.New("mail template not found")
+	ErrNoRecipient        = errors.New("email must at least have one recipient")
+	ErrAttachmentTooLarge = errors.New("email attachments are too large")
+)
+
+// DefaultMaxAttachmentBytes is the total size of attachments allowed on an email when no limit is configured.
+// most of the smtp servers reject emails bigger than 10MB and base64 encoding adds a third to the size.
+const DefaultMaxAttachmentBytes = 7 << 20
+
+// Config holds the smtp server information and the sending behavior of the mailer
+type Config struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	Sender   string
+	// TemplateDir is an optional directory of templates overriding the embedded templates with the same name
+	TemplateDir string
+	// MaxInFlight is the maximum number of concurrent sends, which is also the maximum number of warm smtp connections
+	MaxInFlight int
+	// IdleTimeout is the amount of time an unused smtp connection is kept open
+	IdleTimeout time.Duration
+	// MaxAttachmentBytes is the total size of attachments allowed on an email
+	MaxAttachmentBytes int
+	// LogWriter receives the emails instead of the smtp server when it's set, so they can be read in development
+	LogWriter io.Writer
+}
+
 type Mailer struct {
-	dialer *gomail.Dialer
-	sender string
+	pool               *pool
+	logWriter          io.Writer
+	logMu              sync.Mutex
+	sender             string
+	templates          fs.FS
+	maxAttachmentBytes int
+	// OnSend is called after each send attempt. it can be used to collect metrics and keep track of the delivery status
+	OnSend func(result SendResult)
 }
 
-func New(host string, port int, username, password, sender string) *Mailer {
-	ndialer := gomail.NewDialer(host, port, username, password)
-	return &Mailer{
-		dialer: ndialer,
-		sender: sender,
-	}
+// SendResult describes a single send attempt
+type SendResult struct {
+	MessageID    string
+	Recipient    string
+	TemplateFile string
+	Duration     time.Duration
+	Err          error
 }
 
-// Define a Send() method on the Mailer type. This takes the recipient email address
-// as the first parameter, the name of the file containing the templates, and any
-// dynamic data for the templates as an interface{} parameter.
-func (m Mailer) Send(recipient, templateFile string, data interface{}) error {
-	tpl := template.New("email")
-	parsedTpl, err := tpl.ParseFS(templateFS, "templates/"+templateFile)
+// Probe dials the smtp server of cfg and authenticates with its credentials, so a wrong address or wrong credentials
+// are caught at startup instead of the first send.
+func Probe(cfg Config) error {
+	sc, err := gomail.NewDialer(cfg.Host, cfg.Port, cfg.Username, cfg.Password).Dial()
 	if err != nil {
 		return err
 	}
-	subject := new(bytes.Buffer)
-	err = parsedTpl.ExecuteTemplate(subject, "subject", data)
+	return sc.Close()
+}
+
+// New creates the mailer. In case TemplateDir is provided, templates inside it override the embedded templates with the same name.
+// All the templates are parsed and validated so broken templates are caught at startup instead of the first send.
+func New(cfg Config) (*Mailer, error) {
+	ndialer := gomail.NewDialer(cfg.Host, cfg.Port, cfg.Username, cfg.Password)
+	embedded, err := fs.Sub(templateFS, "templates")
 	if err != nil {
-		return err
+		return nil, err
+	}
+	m := &Mailer{
+		pool:               newPool(ndialer, cfg.MaxInFlight, cfg.IdleTimeout),
+		sender:             cfg.Sender,
+		templates:          embedded,
+		maxAttachmentBytes: cfg.MaxAttachmentBytes,
+		logWriter:          cfg.LogWriter,
+	}
+	if m.maxAttachmentBytes <= 0 {
+		m.maxAttachmentBytes = DefaultMaxAttachmentBytes
 	}
-	plainBody := new(bytes.Buffer)
-	err = parsedTpl.ExecuteTemplate(plainBody, "plainBody", data)
+	if cfg.TemplateDir != "" {
+		info, err := os.Stat(cfg.TemplateDir)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			return nil, fmt.Errorf("mail template path %s is not a directory", cfg.TemplateDir)
+		}
+		m.templates = overlayFS{upper: os.DirFS(cfg.TemplateDir), lower: embedded}
+	}
+
+	err = m.validate()
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Close closes the idle smtp connections of the mailer
+func (m *Mailer) Close() {
+	m.pool.close()
+}
+
+// SetCredentials authenticates the smtp connections dialed from now on by the credentials, e.g. once they're rotated.
+// The warm connections of the old credentials are closed.
+func (m *Mailer) SetCredentials(username, password string) {
+	m.pool.setCredentials(username, password)
+}
+
+// Templates returns the name of all the available templates
+func (m *Mailer) Templates() ([]string, error) {
+	return fs.Glob(m.templates, "*.tpl")
+}
+
+// validate parses all the templates and makes sure each of them defines the required templates
+func (m *Mailer) validate() error {
+	names, err := m.Templates()
 	if err != nil {
 		return err
 	}
+	for _, name := range names {
+		_, err := m.parse(name)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mailTemplate holds the parsed template file.
+// html body is executed by html/template to escape the user provided data, the rest are executed by text/template.
+// Each template file must define "subject" and "htmlBody" templates.
+// "plainBody" is optional and will be generated from the html body when it's missing.
+// "style" is optional and its css rules will be inlined into the html body.
+type mailTemplate struct {
+	text *template.Template
+	html *htmltemplate.Template
+}
+
+func (m *Mailer) parse(templateFile string) (*mailTemplate, error) {
+	// templates are looked up only by their base name to avoid reading files outside of the template directory
+	if templateFile != path.Base(templateFile) || !strings.HasSuffix(templateFile, ".tpl") {
+		return nil, ErrTemplateNotFound
+	}
+	textTpl, err := template.New("email").ParseFS(m.templates, templateFile)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) || strings.Contains(err.Error(), "pattern matches no files") {
+			return nil, ErrTemplateNotFound
+		}
+		return nil, fmt.Errorf("failed to parse mail template %s: %w", templateFile, err)
+	}
+	htmlTpl, err := htmltemplate.New("email").ParseFS(m.templates, templateFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse mail template %s: %w", templateFile, err)
+	}
+	if textTpl.Lookup("subject") == nil {
+		return nil, fmt.Errorf("mail template %s doesn't define %q", templateFile, "subject")
+	}
+	if htmlTpl.Lookup("htmlBody") == nil {
+		return nil, fmt.Errorf("mail template %s doesn't define %q", templateFile, "htmlBody")
+	}
+	return &mailTemplate{text: textTpl, html: htmlTpl}, nil
+}
+
+// Rendered is the result of executing a mail template
+type Rendered struct {
+	Subject   string `json:"subject"`
+	PlainBody string `json:"plain_body"`
+	HTMLBody  string `json:"html_body"`
+}
+
+// Render executes the template with the provided data without sending any email
+func (m *Mailer) Render(templateFile string, data interface{}) (*Rendered, error) {
+	parsedTpl, err := m.parse(templateFile)
+	if err != nil {
+		return nil, err
+	}
+	subject := new(bytes.Buffer)
+	err = parsedTpl.text.ExecuteTemplate(subject, "subject", data)
+	if err != nil {
+		return nil, err
+	}
 	htmlBody := new(bytes.Buffer)
-	err = parsedTpl.ExecuteTemplate(htmlBody, "htmlBody", data)
+	err = parsedTpl.html.ExecuteTemplate(htmlBody, "htmlBody", data)
+	if err != nil {
+		return nil, err
+	}
+	rendered := &Rendered{
+		Subject:  strings.TrimSpace(subject.String()),
+		HTMLBody: htmlBody.String(),
+	}
+
+	if parsedTpl.text.Lookup("style") != nil {
+		style := new(bytes.Buffer)
+		err = parsedTpl.text.ExecuteTemplate(style, "style", data)
+		if err != nil {
+			return nil, err
+		}
+		rendered.HTMLBody, err = inlineCSS(rendered.HTMLBody, style.String())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if parsedTpl.text.Lookup("plainBody") != nil {
+		plainBody := new(bytes.Buffer)
+		err = parsedTpl.text.ExecuteTemplate(plainBody, "plainBody", data)
+		if err != nil {
+			return nil, err
+		}
+		rendered.PlainBody = plainBody.String()
+	} else {
+		rendered.PlainBody, err = htmlToText(rendered.HTMLBody)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return rendered, nil
+}
+
+// Attachment is a file attached to an email. ContentType will be detected from the data when it's empty.
+type Attachment struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
+// MailMessage describes an email to be rendered from TemplateFile using Data and sent to its recipients.
+type MailMessage struct {
+	To           []string
+	Cc           []string
+	Bcc          []string
+	ReplyTo      string
+	Headers      map[string]string
+	TemplateFile string
+	Data         interface{}
+	Attachments  []Attachment
+}
+
+func (msg *MailMessage) attachmentsSize() int {
+	size := 0
+	for _, a := range msg.Attachments {
+		size += len(a.Data)
+	}
+	return size
+}
+
+// Send renders the template of the message and sends it over a warm connection of the pool.
+// The context only bounds the wait for a free send slot, an email being transmitted is never interrupted.
+func (m *Mailer) Send(ctx context.Context, message MailMessage) error {
+	if len(message.To) == 0 {
+		return ErrNoRecipient
+	}
+	if size := message.attachmentsSize(); size > m.maxAttachmentBytes {
+		return fmt.Errorf("%w: %d bytes exceeds the limit of %d bytes", ErrAttachmentTooLarge, size, m.maxAttachmentBytes)
+	}
+	rendered, err := m.Render(message.TemplateFile, message.Data)
 	if err != nil {
 		return err
 	}
@@ -60,19 +294,142 @@ func (m Mailer) Send(recipient, templateFile string, data interface{}) error {
 	// headers, the SetBody() method to set the plain-text body, and the AddAlternative()
 	// method to set the HTML body. It's important to note that AddAlternative() should
 	// always be called *after* SetBody().
+	messageID := m.newMessageID()
 	msg := gomail.NewMessage()
+	// custom headers are set first so they can't override the ones below
+	for key, value := range message.Headers {
+		msg.SetHeader(key, value)
+	}
+	msg.SetHeader("Message-ID", messageID)
 	msg.SetHeader("From", m.sender)
-	msg.SetHeader("To", recipient)
-	msg.SetHeader("Subject", subject.String())
-	msg.SetBody("text/plain", plainBody.String())
-	msg.AddAlternative("text/html", htmlBody.String())
+	msg.SetHeader("To", message.To...)
+	if len(message.Cc) > 0 {
+		msg.SetHeader("Cc", message.Cc...)
+	}
+	// gomail uses Bcc only for the smtp envelope and doesn't write it in the email headers
+	if len(message.Bcc) > 0 {
+		msg.SetHeader("Bcc", message.Bcc...)
+	}
+	if message.ReplyTo != "" {
+		msg.SetHeader("Reply-To", message.ReplyTo)
+	}
+	msg.SetHeader("Subject", rendered.Subject)
+	msg.SetBody("text/plain", rendered.PlainBody)
+	msg.AddAlternative("text/html", rendered.HTMLBody)
 	msg.SetHeader("smtp-auth", "login")
 
-	// Authenticate , Send the message and close the connection
-	err = m.dialer.DialAndSend(msg)
+	for _, a := range message.Attachments {
+		data := a.Data
+		contentType := a.ContentType
+		if contentType == "" {
+			contentType = http.DetectContentType(data)
+		}
+		msg.Attach(a.Filename,
+			gomail.SetHeader(map[string][]string{"Content-Type": {contentType}}),
+			gomail.SetCopyFunc(func(w io.Writer) error {
+				_, err := w.Write(data)
+				return err
+			}),
+		)
+	}
+
+	start := time.Now()
+	if m.logWriter != nil {
+		err = m.writeLog(msg)
+	} else {
+		err = m.pool.send(ctx, msg)
+	}
+	if m.OnSend != nil {
+		m.OnSend(SendResult{
+			MessageID:    messageID,
+			Recipient:    strings.Join(message.To, ","),
+			TemplateFile: message.TemplateFile,
+			Duration:     time.Since(start),
+			Err:          err,
+		})
+	}
 	if err != nil {
 		return err
 	}
 
 	return nil
 }
+
+// writeLog writes the whole email to the log writer instead of sending it
+func (m *Mailer) writeLog(msg *gomail.Message) error {
+	m.logMu.Lock()
+	defer m.logMu.Unlock()
+	_, err := msg.WriteTo(m.logWriter)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(m.logWriter, "\n")
+	return err
+}
+
+// newMessageID generates a unique Message-ID header value using the domain of the sender address.
+// providers include it in their bounce and complaint callbacks so the events can be matched with the sent emails.
+func (m *Mailer) newMessageID() string {
+	domain := "greenlight"
+	if addr, err := mail.ParseAddress(m.sender); err == nil {
+		if _, d, found := strings.Cut(addr.Address, "@"); found {
+			domain = d
+		}
+	}
+	return fmt.Sprintf("<%s@%s>", uuid.New().String(), domain)
+}
+
+// SendBatch sends the emails concurrently over the pooled connections.
+// The returned errors are in the same order as the messages and nil for the successful sends.
+func (m *Mailer) SendBatch(ctx context.Context, messages []MailMessage) []error {
+	errs := make([]error, len(messages))
+	wg := sync.WaitGroup{}
+	for i, message := range messages {
+		wg.Add(1)
+		go func(i int, message MailMessage) {
+			defer wg.Done()
+			// the pool blocks the sends exceeding the max in flight setting
+			errs[i] = m.Send(ctx, message)
+		}(i, message)
+	}
+	wg.Wait()
+	return errs
+}
+
+// overlayFS serves the files from upper and falls back to lower for the files upper doesn't have.
+type overlayFS struct {
+	upper fs.FS
+	lower fs.FS
+}
+
+func (o overlayFS) Open(name string) (fs.File, error) {
+	f, err := o.upper.Open(name)
+	if err == nil {
+		return f, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	return o.lower.Open(name)
+}
+
+// Glob is required by fs.Glob to list the templates of both file systems.
+func (o overlayFS) Glob(pattern string) ([]string, error) {
+	upper, err := fs.Glob(o.upper, pattern)
+	if err != nil {
+		return nil, err
+	}
+	lower, err := fs.Glob(o.lower, pattern)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	var names []string
+	for _, name := range append(upper, lower...) {
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
@@ -0,0 +1,172 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeTemplates writes the templates into a new template directory
+func writeTemplates(t *testing.T, templates map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range templates {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600))
+	}
+	return dir
+}
+
+func TestRenderEscaping(t *testing.T) {
+	dir := writeTemplates(t, map[string]string{
+		"greeting.tpl": `{{define "subject"}}Hi {{.Name}}{{end}}
+{{define "htmlBody"}}<p>Hi {{.Name}}</p><a href="{{.Link}}">activate</a>{{end}}`,
+	})
+	m, err := New(Config{Sender: "no-reply@greenlight.com", TemplateDir: dir})
+	require.NoError(t, err)
+
+	rendered, err := m.Render("greeting.tpl", map[string]string{"Name": `<script>alert("x")</script>`, "Link": `javascript:alert(1)`})
+	require.NoError(t, err)
+	assert.Equal(t, `Hi <script>alert("x")</script>`, rendered.Subject, "the subject isn't html")
+	assert.Contains(t, rendered.HTMLBody, `<p>Hi &lt;script&gt;alert(&#34;x&#34;)&lt;/script&gt;</p>`)
+	assert.Contains(t, rendered.HTMLBody, `href="#ZgotmplZ"`, "unsafe urls are dropped")
+	assert.NotContains(t, rendered.HTMLBody, "<script>")
+	// the plain body is generated from the escaped html body
+	assert.Equal(t, "Hi <script>alert(\"x\")</script>\n\nactivate\n", rendered.PlainBody)
+}
+
+func TestRenderStyleAndPlainBody(t *testing.T) {
+	m, err := New(Config{Sender: "no-reply@greenlight.com"})
+	require.NoError(t, err)
+
+	rendered, err := m.Render("user_welcome.tpl", map[string]string{"ID": "42", "Code": "ABCDEF"})
+	require.NoError(t, err)
+	assert.Equal(t, "Welcome to Greenlight!", rendered.Subject)
+	assert.Contains(t, rendered.HTMLBody, `<span class="code" style="font-family: monospace; font-size: 16px; font-weight: bold">ABCDEF</span>`)
+	assert.Contains(t, rendered.PlainBody, "Activation Code: ABCDEF", "the plainBody of the template is used when it's defined")
+}
+
+func TestTemplateOverlay(t *testing.T) {
+	dir := writeTemplates(t, map[string]string{
+		"user_welcome.tpl": `{{define "subject"}}Welcome aboard{{end}}{{define "htmlBody"}}<p>{{.Code}}</p>{{end}}`,
+		"newsletter.tpl":   `{{define "subject"}}News{{end}}{{define "htmlBody"}}<p>news</p>{{end}}`,
+	})
+	m, err := New(Config{Sender: "no-reply@greenlight.com", TemplateDir: dir})
+	require.NoError(t, err)
+
+	names, err := m.Templates()
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"new_signin.tpl", "newsletter.tpl", "password_reset.tpl", "registration_invitation.tpl",
+		"saved_search_alert.tpl", "user_invitation.tpl", "user_welcome.tpl"}, names, "each template is listed once")
+
+	tests := []struct {
+		name     string
+		template string
+		subject  string
+		err      error
+	}{
+		{name: "Overridden", template: "user_welcome.tpl", subject: "Welcome aboard"},
+		{name: "Added", template: "newsletter.tpl", subject: "News"},
+		{name: "Embedded", template: "password_reset.tpl"},
+		{name: "Missing", template: "missing.tpl", err: ErrTemplateNotFound},
+		{name: "Outside of the directory", template: "../user_welcome.tpl", err: ErrTemplateNotFound},
+		{name: "Not a template", template: "user_welcome.txt", err: ErrTemplateNotFound},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rendered, err := m.Render(tc.template, map[string]string{"Code": "ABCDEF"})
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			if tc.subject != "" {
+				assert.Equal(t, tc.subject, rendered.Subject)
+			}
+		})
+	}
+}
+
+func TestNewRejectsBrokenTemplates(t *testing.T) {
+	tests := []struct {
+		name      string
+		templates map[string]string
+	}{
+		{name: "Syntax error", templates: map[string]string{"broken.tpl": `{{define "subject"}}Hi{{end}}{{define "htmlBody"}}{{.Name}{{end}}`}},
+		{name: "Missing subject", templates: map[string]string{"broken.tpl": `{{define "htmlBody"}}<p>Hi</p>{{end}}`}},
+		{name: "Missing html body", templates: map[string]string{"broken.tpl": `{{define "subject"}}Hi{{end}}`}},
+		// an override is validated the same way as the other templates
+		{name: "Broken override", templates: map[string]string{"user_welcome.tpl": `{{define "subject"}}Hi{{end}}`}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := New(Config{Sender: "no-reply@greenlight.com", TemplateDir: writeTemplates(t, tc.templates)})
+			assert.Error(t, err)
+		})
+	}
+
+	_, err := New(Config{Sender: "no-reply@greenlight.com", TemplateDir: filepath.Join(t.TempDir(), "missing")})
+	assert.Error(t, err)
+}
+
+func TestSendAttachments(t *testing.T) {
+	pdf := []byte("%PDF-1.4 greenlight")
+	tests := []struct {
+		name        string
+		attachments []Attachment
+		maxBytes    int
+		contains    []string
+		err         error
+	}{
+		{name: "Detected content type", attachments: []Attachment{{Filename: "ticket.pdf", Data: pdf}}, contains: []string{
+			`Content-Type: application/pdf`, `filename="ticket.pdf"`, base64.StdEncoding.EncodeToString(pdf),
+		}},
+		{name: "Given content type", attachments: []Attachment{{Filename: "movies.csv", ContentType: "text/csv", Data: []byte("title,year\n")}}, contains: []string{
+			`Content-Type: text/csv`, `filename="movies.csv"`,
+		}},
+		{name: "Too large", attachments: []Attachment{{Filename: "a.bin", Data: make([]byte, 8)}, {Filename: "b.bin", Data: make([]byte, 8)}}, maxBytes: 10, err: ErrAttachmentTooLarge},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var mails bytes.Buffer
+			m, err := New(Config{Sender: "no-reply@greenlight.com", LogWriter: &mails, MaxAttachmentBytes: tc.maxBytes})
+			require.NoError(t, err)
+			var results []SendResult
+			m.OnSend = func(result SendResult) { results = append(results, result) }
+
+			err = m.Send(context.Background(), MailMessage{
+				To:           []string{"john@example.com"},
+				TemplateFile: "user_welcome.tpl",
+				Data:         map[string]string{"ID": "42", "Code": "ABCDEF"},
+				Attachments:  tc.attachments,
+			})
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+				assert.Empty(t, mails.String())
+				assert.Empty(t, results, "the rejected emails aren't sent")
+				return
+			}
+			require.NoError(t, err)
+			for _, s := range tc.contains {
+				assert.Contains(t, mails.String(), s)
+			}
+			require.Len(t, results, 1)
+			assert.Equal(t, "john@example.com", results[0].Recipient)
+			assert.Contains(t, mails.String(), "Message-ID: "+results[0].MessageID)
+		})
+	}
+}
+
+func TestSendWithoutRecipient(t *testing.T) {
+	m, err := New(Config{Sender: "no-reply@greenlight.com", LogWriter: &bytes.Buffer{}})
+	require.NoError(t, err)
+	err = m.Send(context.Background(), MailMessage{TemplateFile: "user_welcome.tpl"})
+	assert.ErrorIs(t, err, ErrNoRecipient)
+}
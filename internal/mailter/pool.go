@@ -0,0 +1,147 @@
+package mailer
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"gopkg.in/gomail.v2"
+)
+
+// pool keeps authenticated smtp connections warm so each email doesn't need to dial and authenticate again.
+// Number of concurrent sends is limited to the capacity of sem, which is also the maximum number of idle connections.
+type pool struct {
+	dialer      *gomail.Dialer
+	idle        chan *pooledConn
+	sem         chan struct{}
+	idleTimeout time.Duration
+	mu          sync.Mutex
+	closed      bool
+	// generation is increased whenever the credentials change so the connections of the old ones aren't reused
+	generation int
+}
+
+type pooledConn struct {
+	sc         gomail.SendCloser
+	lastUsed   time.Time
+	generation int
+}
+
+func newPool(dialer *gomail.Dialer, maxInFlight int, idleTimeout time.Duration) *pool {
+	if maxInFlight < 1 {
+		maxInFlight = 1
+	}
+	return &pool{
+		dialer:      dialer,
+		idle:        make(chan *pooledConn, maxInFlight),
+		sem:         make(chan struct{}, maxInFlight),
+		idleTimeout: idleTimeout,
+	}
+}
+
+// send sends the message over a pooled connection.
+// A reused connection might have been closed by the server, so the message is retried once over a fresh connection.
+func (p *pool) send(ctx context.Context, msg *gomail.Message) error {
+	select {
+	case p.sem <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-p.sem }()
+
+	conn, reused, err := p.get()
+	if err != nil {
+		return err
+	}
+	err = gomail.Send(conn.sc, msg)
+	if err != nil && reused {
+		conn.sc.Close()
+		conn, err = p.dial()
+		if err != nil {
+			return err
+		}
+		err = gomail.Send(conn.sc, msg)
+	}
+	if err != nil {
+		conn.sc.Close()
+		return err
+	}
+	p.put(conn)
+	return nil
+}
+
+func (p *pool) get() (*pooledConn, bool, error) {
+	for {
+		select {
+		case conn := <-p.idle:
+			if time.Since(conn.lastUsed) > p.idleTimeout {
+				conn.sc.Close()
+				continue
+			}
+			return conn, true, nil
+		default:
+			conn, err := p.dial()
+			return conn, false, err
+		}
+	}
+}
+
+func (p *pool) dial() (*pooledConn, error) {
+	p.mu.Lock()
+	dialer, generation := p.dialer, p.generation
+	p.mu.Unlock()
+	sc, err := dialer.Dial()
+	if err != nil {
+		return nil, err
+	}
+	return &pooledConn{sc: sc, generation: generation}, nil
+}
+
+func (p *pool) put(conn *pooledConn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed || conn.generation != p.generation {
+		conn.sc.Close()
+		return
+	}
+	conn.lastUsed = time.Now()
+	select {
+	case p.idle <- conn:
+	default:
+		conn.sc.Close()
+	}
+}
+
+// setCredentials dials the new connections by the credentials and closes the idle connections of the old ones.
+// connections in use will be closed once their send is finished.
+func (p *pool) setCredentials(username, password string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	dialer := *p.dialer
+	dialer.Username, dialer.Password = username, password
+	p.dialer = &dialer
+	p.generation++
+	for {
+		select {
+		case conn := <-p.idle:
+			conn.sc.Close()
+		default:
+			return
+		}
+	}
+}
+
+// close closes all the idle connections. connections in use will be closed once their send is finished.
+func (p *pool) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	for {
+		select {
+		case conn := <-p.idle:
+			conn.sc.Close()
+		default:
+			return
+		}
+	}
+}
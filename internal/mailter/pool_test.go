@@ -0,0 +1,190 @@
+package mailer
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/gomail.v2"
+)
+
+// smtpServer is a minimal smtp server counting the connections dialed to it and the emails sent over them
+type smtpServer struct {
+	ln net.Listener
+	// closeAfterMessage closes the connection once an email is sent over it, like a server timing the idle connections out
+	closeAfterMessage bool
+
+	mu       sync.Mutex
+	dials    int
+	messages []string
+}
+
+func newSMTPServer(t *testing.T, closeAfterMessage bool) *smtpServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := &smtpServer{ln: ln, closeAfterMessage: closeAfterMessage}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.dials++
+			s.mu.Unlock()
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *smtpServer) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+	reply("220 localhost ESMTP")
+	sent := false
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		if sent && s.closeAfterMessage {
+			reply("421 4.4.2 idle timeout")
+			return
+		}
+		switch command := strings.ToUpper(strings.Fields(line + " ")[0]); command {
+		case "EHLO", "HELO":
+			reply("250 localhost")
+		case "DATA":
+			reply("354 end data with <CR><LF>.<CR><LF>")
+			var message strings.Builder
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				message.WriteString(line)
+			}
+			s.mu.Lock()
+			s.messages = append(s.messages, message.String())
+			s.mu.Unlock()
+			sent = true
+			reply("250 OK")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
+
+func (s *smtpServer) stats() (int, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dials, len(s.messages)
+}
+
+func (s *smtpServer) pool(t *testing.T, maxInFlight int, idleTimeout time.Duration) *pool {
+	host, port, err := net.SplitHostPort(s.ln.Addr().String())
+	require.NoError(t, err)
+	portNumber, err := strconv.Atoi(port)
+	require.NoError(t, err)
+	p := newPool(gomail.NewDialer(host, portNumber, "", ""), maxInFlight, idleTimeout)
+	t.Cleanup(p.close)
+	return p
+}
+
+func testMessage() *gomail.Message {
+	msg := gomail.NewMessage()
+	msg.SetHeader("From", "no-reply@greenlight.com")
+	msg.SetHeader("To", "john@example.com")
+	msg.SetHeader("Subject", "Welcome to Greenlight!")
+	msg.SetBody("text/plain", "Hi")
+	return msg
+}
+
+func TestPoolSend(t *testing.T) {
+	tests := []struct {
+		name              string
+		idleTimeout       time.Duration
+		closeAfterMessage bool
+		dials             int
+	}{
+		{name: "Warm connection", idleTimeout: time.Minute, dials: 1},
+		{name: "Idle connection timed out", idleTimeout: time.Nanosecond, dials: 3},
+		// the reused connection fails the send, so the email is sent again over a fresh connection
+		{name: "Connection closed by the server", idleTimeout: time.Minute, closeAfterMessage: true, dials: 3},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			server := newSMTPServer(t, tc.closeAfterMessage)
+			p := server.pool(t, 1, tc.idleTimeout)
+			for i := 0; i < 3; i++ {
+				require.NoError(t, p.send(context.Background(), testMessage()))
+			}
+			dials, messages := server.stats()
+			assert.Equal(t, tc.dials, dials)
+			assert.Equal(t, 3, messages)
+		})
+	}
+}
+
+func TestPoolSetCredentials(t *testing.T) {
+	server := newSMTPServer(t, false)
+	p := server.pool(t, 1, time.Minute)
+	require.NoError(t, p.send(context.Background(), testMessage()))
+
+	p.setCredentials("greenlight", "rotated")
+	assert.Empty(t, p.idle, "the connections of the old credentials are closed")
+	assert.Equal(t, "greenlight", p.dialer.Username)
+	assert.Equal(t, "rotated", p.dialer.Password)
+
+	require.NoError(t, p.send(context.Background(), testMessage()))
+	require.NoError(t, p.send(context.Background(), testMessage()))
+	dials, messages := server.stats()
+	assert.Equal(t, 2, dials, "the connection of the new credentials is reused")
+	assert.Equal(t, 3, messages)
+}
+
+func TestPoolMaxInFlight(t *testing.T) {
+	server := newSMTPServer(t, false)
+	p := server.pool(t, 2, time.Minute)
+	p.sem <- struct{}{}
+	p.sem <- struct{}{}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := p.send(ctx, testMessage())
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	dials, _ := server.stats()
+	assert.Zero(t, dials, "the send waits for a free slot before dialing")
+
+	<-p.sem
+	require.NoError(t, p.send(context.Background(), testMessage()))
+}
+
+func TestPoolClose(t *testing.T) {
+	server := newSMTPServer(t, false)
+	p := server.pool(t, 1, time.Minute)
+	require.NoError(t, p.send(context.Background(), testMessage()))
+	require.Len(t, p.idle, 1)
+
+	p.close()
+	assert.Empty(t, p.idle)
+	require.NoError(t, p.send(context.Background(), testMessage()))
+	assert.Empty(t, p.idle, "the connections aren't kept once the pool is closed")
+}
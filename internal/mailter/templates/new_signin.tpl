@@ -0,0 +1,48 @@
+{{define "subject"}}
+New sign-in to your Greenlight account
+{{end}}
+
+{{define "plainBody"}}
+Hi {{.Name}},
+
+We noticed a new sign-in to your Greenlight account.
+
+Time: {{.Time}}
+IP address: {{.RemoteAddr}}
+Device: {{.UserAgent}}
+
+If this was you, you can ignore this email.
+If you don't recognize this sign-in pls send a POST request to the below link to sign out all of your sessions and change your password.
+greenlight.com/v1/logins/{{.EventID}}/revoke?token={{.RevokeToken}}
+Thanks,
+
+The Greenlight Team
+{{end}}
+
+{{define "style"}}
+p { font-family: Helvetica, Arial, sans-serif; font-size: 14px; color: #333333; }
+.code { font-family: monospace; font-size: 16px; font-weight: bold; }
+{{end}}
+
+{{define "htmlBody"}}
+<!doctype html>
+<html>
+
+<head>
+  <meta name="viewport" content="width=device-width" />
+  <meta http-equiv="Content-Type" content="text/html; charset=UTF-8" />
+</head>
+
+<body>
+  <p>Hi {{.Name}},</p>
+  <p>We noticed a new sign-in to your Greenlight account.</p>
+  <p>Time: {{.Time}}<br>IP address: {{.RemoteAddr}}<br>Device: {{.UserAgent}}</p>
+  <p>If this was you, you can ignore this email.</p>
+  <p>If you don't recognize this sign-in pls send a POST request to the below link to sign out all of your sessions and change your password.</p>
+  <p><span class="code">greenlight.com/v1/logins/{{.EventID}}/revoke?token={{.RevokeToken}}</span></p>
+  <p>Thanks,</p>
+
+  <p>The Greenlight Team</p>
+</body>
+</html>
+{{end}}
@@ -0,0 +1,44 @@
+{{define "subject"}}
+Reset your Greenlight password
+{{end}}
+
+{{define "plainBody"}}
+Hi {{.Name}},
+
+An administrator has reset the password of your Greenlight account as a security precaution and signed you out of all of your devices.
+You can't sign in until you choose a new password. pls send a PUT request with the below token and your new password to greenlight.com/v1/users/{{.ID}}/password
+
+Reset Token: {{.Token}}
+
+The token is valid until {{.Expiry}}.
+Thanks,
+
+The Greenlight Team
+{{end}}
+
+{{define "style"}}
+p { font-family: Helvetica, Arial, sans-serif; font-size: 14px; color: #333333; }
+.code { font-family: monospace; font-size: 16px; font-weight: bold; }
+{{end}}
+
+{{define "htmlBody"}}
+<!doctype html>
+<html>
+
+<head>
+  <meta name="viewport" content="width=device-width" />
+  <meta http-equiv="Content-Type" content="text/html; charset=UTF-8" />
+</head>
+
+<body>
+  <p>Hi {{.Name}},</p>
+  <p>An administrator has reset the password of your Greenlight account as a security precaution and signed you out of all of your devices.</p>
+  <p>You can't sign in until you choose a new password. pls send a PUT request with the below token and your new password to greenlight.com/v1/users/{{.ID}}/password</p>
+  <p>Reset Token: <span class="code">{{.Token}}</span></p>
+  <p>The token is valid until {{.Expiry}}.</p>
+  <p>Thanks,</p>
+
+  <p>The Greenlight Team</p>
+</body>
+</html>
+{{end}}
@@ -0,0 +1,43 @@
+{{define "subject"}}
+You're invited to join Greenlight!
+{{end}}
+
+{{define "plainBody"}}
+Hi,
+
+You have been invited to create a Greenlight account.
+To sign up pls provide the below invitation token with your registration on greenlight.com/v1/users
+The invitation is valid until {{.Expiry}}.
+Thanks,
+
+Invitation Token: {{.Token}}
+
+The Greenlight Team
+{{end}}
+
+{{define "style"}}
+p { font-family: Helvetica, Arial, sans-serif; font-size: 14px; color: #333333; }
+.code { font-family: monospace; font-size: 14px; font-weight: bold; word-break: break-all; }
+{{end}}
+
+{{define "htmlBody"}}
+<!doctype html>
+<html>
+
+<head>
+  <meta name="viewport" content="width=device-width" />
+  <meta http-equiv="Content-Type" content="text/html; charset=UTF-8" />
+</head>
+
+<body>
+  <p>Hi,</p>
+  <p>You have been invited to create a Greenlight account.</p>
+  <p>To sign up pls provide the below invitation token with your registration on greenlight.com/v1/users</p>
+  <p>The invitation is valid until {{.Expiry}}.</p>
+  <p>Thanks,</p>
+  <p>Invitation Token: <span class="code">{{.Token}}</span></p>
+
+  <p>The Greenlight Team</p>
+</body>
+</html>
+{{end}}
@@ -0,0 +1,54 @@
+{{define "subject"}}
+New movies matching your search "{{.Search}}"
+{{end}}
+
+{{define "plainBody"}}
+Hi {{.Name}},
+
+These movies matching your saved search "{{.Search}}" have been added to Greenlight:
+{{range .Movies}}
+- {{.Title}} ({{.Year}})
+{{- end}}
+{{if .More}}
+and {{.More}} more.
+{{end}}
+You can see all the movies matching your search by sending a GET request to the below link.
+greenlight.com/v1/users/me/searches/{{.SearchID}}/results
+
+To stop these emails delete the search by sending a DELETE request to greenlight.com/v1/users/me/searches/{{.SearchID}}
+
+Thanks,
+
+The Greenlight Team
+{{end}}
+
+{{define "style"}}
+p { font-family: Helvetica, Arial, sans-serif; font-size: 14px; color: #333333; }
+.code { font-family: monospace; font-size: 16px; font-weight: bold; }
+{{end}}
+
+{{define "htmlBody"}}
+<!doctype html>
+<html>
+
+<head>
+  <meta name="viewport" content="width=device-width" />
+  <meta http-equiv="Content-Type" content="text/html; charset=UTF-8" />
+</head>
+
+<body>
+  <p>Hi {{.Name}},</p>
+  <p>These movies matching your saved search "{{.Search}}" have been added to Greenlight:</p>
+  <ul>
+    {{range .Movies}}<li>{{.Title}} ({{.Year}})</li>{{end}}
+  </ul>
+  {{if .More}}<p>and {{.More}} more.</p>{{end}}
+  <p>You can see all the movies matching your search by sending a GET request to the below link.</p>
+  <p><span class="code">greenlight.com/v1/users/me/searches/{{.SearchID}}/results</span></p>
+  <p>To stop these emails delete the search by sending a DELETE request to <span class="code">greenlight.com/v1/users/me/searches/{{.SearchID}}</span></p>
+  <p>Thanks,</p>
+
+  <p>The Greenlight Team</p>
+</body>
+</html>
+{{end}}
@@ -0,0 +1,43 @@
+{{define "subject"}}
+You're invited to Greenlight!
+{{end}}
+
+{{define "plainBody"}}
+Hi {{.Name}},
+
+An account has been created for you on Greenlight.
+To activate your account pls use the below activation code and choose your password on greenlight.com/v1/users/{{.ID}}/activated
+The activation code is valid for 7 days.
+Thanks,
+
+Activation Code: {{.Code}}
+
+The Greenlight Team
+{{end}}
+
+{{define "style"}}
+p { font-family: Helvetica, Arial, sans-serif; font-size: 14px; color: #333333; }
+.code { font-family: monospace; font-size: 16px; font-weight: bold; }
+{{end}}
+
+{{define "htmlBody"}}
+<!doctype html>
+<html>
+
+<head>
+  <meta name="viewport" content="width=device-width" />
+  <meta http-equiv="Content-Type" content="text/html; charset=UTF-8" />
+</head>
+
+<body>
+  <p>Hi {{.Name}},</p>
+  <p>An account has been created for you on Greenlight.</p>
+  <p>To activate your account pls use the below activation code and choose your password on greenlight.com/v1/users/{{.ID}}/activated</p>
+  <p>The activation code is valid for 7 days.</p>
+  <p>Thanks,</p>
+  <p>Activation Code: <span class="code">{{.Code}}</span></p>
+
+  <p>The Greenlight Team</p>
+</body>
+</html>
+{{end}}
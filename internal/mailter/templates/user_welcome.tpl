@@ -14,6 +14,11 @@ Activation Code: {{.Code}}
 The Greenlight Team
 {{end}}
 
+{{define "style"}}
+p { font-family: Helvetica, Arial, sans-serif; font-size: 14px; color: #333333; }
+.code { font-family: monospace; font-size: 16px; font-weight: bold; }
+{{end}}
+
 {{define "htmlBody"}}
 <!doctype html>
 <html>
@@ -28,7 +33,7 @@ The Greenlight Team
   <p>Thanks for signing up for a Greenlight account. We're excited to have you on board!</p>
   <p>To activate your account pls use the below activation code on greenlight.com/v1/users/{{.ID}}/activated</p>
   <p>Thanks,</p>
-  <p>Activation Code: {{.Code}}</p>
+  <p>Activation Code: <span class="code">{{.Code}}</span></p>
   
   <p>The Greenlight Team</p>
 </body>
@@ -0,0 +1,52 @@
+package paseto
+
+import (
+	"crypto/ed25519"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignVerify(t *testing.T) {
+	key := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+	otherKey := ed25519.NewKeyFromSeed([]byte(strings.Repeat("k", ed25519.SeedSize)))
+	now := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+	claims := Claims{Subject: "user", Expiration: now.Add(time.Hour), NotBefore: now, IssuedAt: now}
+
+	token, err := Sign(key, claims, []byte("implicit"))
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(token, V4PublicHeader))
+
+	tests := []struct {
+		name     string
+		key      ed25519.PublicKey
+		token    string
+		implicit string
+		err      error
+	}{
+		{name: "Valid token", key: key.Public().(ed25519.PublicKey), token: token, implicit: "implicit"},
+		{name: "Other key", key: otherKey.Public().(ed25519.PublicKey), token: token, implicit: "implicit", err: ErrInvalidToken},
+		{name: "Other implicit", key: key.Public().(ed25519.PublicKey), token: token, implicit: "other", err: ErrInvalidToken},
+		{name: "Tampered payload", key: key.Public().(ed25519.PublicKey), token: token[:len(V4PublicHeader)] + "A" + token[len(V4PublicHeader)+1:], implicit: "implicit", err: ErrInvalidToken},
+		{name: "Unexpected footer", key: key.Public().(ed25519.PublicKey), token: token + ".Zm9vdGVy", implicit: "implicit", err: ErrInvalidToken},
+		{name: "Other version", key: key.Public().(ed25519.PublicKey), token: strings.Replace(token, "v4.", "v3.", 1), implicit: "implicit", err: ErrInvalidToken},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := Claims{}
+			err := Verify(tc.key, tc.token, []byte(tc.implicit), &got)
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, claims.Subject, got.Subject)
+			assert.NoError(t, got.Valid(now))
+			assert.ErrorIs(t, got.Valid(now.Add(time.Hour)), ErrExpiredToken)
+		})
+	}
+}
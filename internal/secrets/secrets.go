@@ -0,0 +1,174 @@
+// Package secrets fetches the secrets of the server, like the database dsn and the signing keys, from a central secret
+// store so they don't have to be passed on the command line where they leak into the process listings.
+// A store holds all the secrets of the server in a single secret of key value pairs named by the constants below.
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// names of the secrets within the secret of the server
+const (
+	DBDSN        = "db_dsn"
+	SMTPUsername = "smtp_username"
+	SMTPPassword = "smtp_password"
+	JWTKey       = "jwt_key"
+	PASETOKey    = "paseto_key"
+)
+
+var ErrNotFound = errors.New("secret not found")
+
+// Doer sends the requests to the store, e.g. *http.Client
+type Doer interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// Store returns the secrets of the server by their name
+type Store interface {
+	Fetch(ctx context.Context) (map[string]string, error)
+}
+
+// Vault reads the secrets from a kv version 2 secrets engine of HashiCorp Vault
+type Vault struct {
+	// Addr is the address of the vault server, e.g. https://vault.example.com:8200
+	Addr string
+	// Token authenticates the requests to vault
+	Token string
+	// Mount is the path the kv engine is mounted on, e.g. secret
+	Mount string
+	// Path is the path of the secret within the mount
+	Path   string
+	Client Doer
+}
+
+func (v *Vault) Fetch(ctx context.Context) (map[string]string, error) {
+	endpoint := strings.TrimSuffix(v.Addr, "/") + "/v1/" + strings.Trim(v.Mount, "/") + "/data/" + strings.Trim(v.Path, "/")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", v.Token)
+
+	var body struct {
+		Data struct {
+			Data map[string]interface{} `json:"data"`
+		} `json:"data"`
+		Errors []string `json:"errors"`
+	}
+	status, err := doJSON(v.Client, req, &body)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case status == http.StatusNotFound:
+		return nil, fmt.Errorf("%w: %s in vault", ErrNotFound, v.Path)
+	case status != http.StatusOK:
+		return nil, fmt.Errorf("vault responded %d: %s", status, strings.Join(body.Errors, ", "))
+	}
+	return stringValues(body.Data.Data)
+}
+
+// AWSCredentials sign the requests to aws
+type AWSCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	// SessionToken is set for the temporary credentials only
+	SessionToken string
+}
+
+// AWSSecretsManager reads the secrets from a secret of AWS Secrets Manager whose value is a json object
+type AWSSecretsManager struct {
+	Region string
+	// SecretID is the name or the arn of the secret
+	SecretID    string
+	Credentials AWSCredentials
+	// Endpoint overrides the regional endpoint of secrets manager, e.g. for a vpc endpoint
+	Endpoint string
+	Client   Doer
+	// now is replaced by the tests
+	now func() time.Time
+}
+
+func (a *AWSSecretsManager) Fetch(ctx context.Context) (map[string]string, error) {
+	endpoint := a.Endpoint
+	if endpoint == "" {
+		endpoint = "https://secretsmanager." + a.Region + ".amazonaws.com/"
+	}
+	payload, err := json.Marshal(map[string]string{"SecretId": a.SecretID})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(string(payload)))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+	now := time.Now
+	if a.now != nil {
+		now = a.now
+	}
+	signV4(req, payload, a.Credentials, a.Region, "secretsmanager", now())
+
+	var body struct {
+		SecretString string `json:"SecretString"`
+		Type         string `json:"__type"`
+		Message      string `json:"message"`
+	}
+	status, err := doJSON(a.Client, req, &body)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case status == http.StatusBadRequest && strings.HasSuffix(body.Type, "ResourceNotFoundException"):
+		return nil, fmt.Errorf("%w: %s in secrets manager", ErrNotFound, a.SecretID)
+	case status != http.StatusOK:
+		return nil, fmt.Errorf("secrets manager responded %d: %s %s", status, body.Type, body.Message)
+	}
+	values := map[string]interface{}{}
+	err = json.Unmarshal([]byte(body.SecretString), &values)
+	if err != nil {
+		return nil, fmt.Errorf("secret %s must be a json object: %w", a.SecretID, err)
+	}
+	return stringValues(values)
+}
+
+// doJSON sends the request and decodes the json body of the response whatever its status is
+func doJSON(client Doer, req *http.Request, body interface{}) (int, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	b, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return 0, err
+	}
+	if len(b) > 0 && json.Unmarshal(b, body) != nil && res.StatusCode == http.StatusOK {
+		return 0, fmt.Errorf("invalid response from %s", (&url.URL{Scheme: req.URL.Scheme, Host: req.URL.Host}).String())
+	}
+	return res.StatusCode, nil
+}
+
+func stringValues(values map[string]interface{}) (map[string]string, error) {
+	secrets := make(map[string]string, len(values))
+	for name, value := range values {
+		s, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("secret %s must be a string", name)
+		}
+		secrets[name] = s
+	}
+	return secrets, nil
+}
@@ -0,0 +1,109 @@
+package secrets
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignV4(t *testing.T) {
+	// the example request of the aws documentation
+	req, err := http.NewRequest(http.MethodGet, "https://iam.amazonaws.com/?Action=ListUsers&Version=2010-05-08", nil)
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	creds := AWSCredentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+
+	signV4(req, nil, creds, "us-east-1", "iam", time.Date(2015, time.August, 30, 12, 36, 0, 0, time.UTC))
+
+	assert.Equal(t, "20150830T123600Z", req.Header.Get("X-Amz-Date"))
+	assert.Equal(t, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/iam/aws4_request, "+
+		"SignedHeaders=content-type;host;x-amz-date, Signature=5d672d79c15b13162d9279b0855cfba6789a8edb4c82c400e06b5924a6f2b5d7",
+		req.Header.Get("Authorization"))
+}
+
+func TestVaultFetch(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "token" {
+			w.WriteHeader(http.StatusForbidden)
+			io.WriteString(w, `{"errors":["permission denied"]}`)
+			return
+		}
+		if r.URL.Path != "/v1/secret/data/greenlight" {
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, `{"errors":[]}`)
+			return
+		}
+		io.WriteString(w, `{"data":{"data":{"db_dsn":"postgres://localhost/greenlight","jwt_key":"key"},"metadata":{"version":3}}}`)
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name  string
+		token string
+		path  string
+		want  map[string]string
+		err   string
+	}{
+		{name: "Secret", token: "token", path: "greenlight", want: map[string]string{DBDSN: "postgres://localhost/greenlight", JWTKey: "key"}},
+		{name: "Missing secret", token: "token", path: "other", err: ErrNotFound.Error()},
+		{name: "Invalid token", token: "other", path: "greenlight", err: "permission denied"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			v := &Vault{Addr: srv.URL, Token: tc.token, Mount: "secret", Path: tc.path, Client: srv.Client()}
+			got, err := v.Fetch(context.Background())
+			if tc.err != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestAWSSecretsManagerFetch(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/20240101/eu-west-1/secretsmanager/aws4_request") ||
+			r.Header.Get("X-Amz-Target") != "secretsmanager.GetSecretValue" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if string(body) != `{"SecretId":"greenlight"}` {
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, `{"__type":"ResourceNotFoundException","message":"Secrets Manager can't find the specified secret."}`)
+			return
+		}
+		io.WriteString(w, `{"Name":"greenlight","SecretString":"{\"smtp_username\":\"mailer\",\"smtp_password\":\"pass\"}"}`)
+	}))
+	defer srv.Close()
+
+	fetch := func(secretID string) (map[string]string, error) {
+		a := &AWSSecretsManager{
+			Region:      "eu-west-1",
+			SecretID:    secretID,
+			Credentials: AWSCredentials{AccessKeyID: "AKID", SecretAccessKey: "secret"},
+			Endpoint:    srv.URL,
+			Client:      srv.Client(),
+			now:         func() time.Time { return time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC) },
+		}
+		return a.Fetch(context.Background())
+	}
+
+	got, err := fetch("greenlight")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{SMTPUsername: "mailer", SMTPPassword: "pass"}, got)
+
+	_, err = fetch("other")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
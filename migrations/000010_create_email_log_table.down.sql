@@ -0,0 +1,2 @@
+ALTER TABLE users DROP COLUMN IF EXISTS email_status;
+DROP TABLE IF EXISTS email_log;
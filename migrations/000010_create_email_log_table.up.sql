@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS email_log (
+    id BIGSERIAL PRIMARY KEY NOT NULL,
+    message_id TEXT NOT NULL,
+    recipient CITEXT NOT NULL,
+    template TEXT NOT NULL,
+    status TEXT NOT NULL,
+    error TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS email_log_message_id_idx ON email_log USING btree(message_id);
+CREATE INDEX IF NOT EXISTS email_log_recipient_idx ON email_log USING btree(recipient);
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_status TEXT NOT NULL DEFAULT 'ok';
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS movie_share_accesses;
+DROP TABLE IF EXISTS movie_shares;
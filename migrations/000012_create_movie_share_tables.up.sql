@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS movie_shares (
+    id BIGSERIAL PRIMARY KEY NOT NULL,
+    movie_id BIGINT NOT NULL REFERENCES movies ON DELETE CASCADE,
+    created_by UUID NOT NULL REFERENCES users ON DELETE CASCADE,
+    expiry TIMESTAMP(0) WITH TIME ZONE NOT NULL,
+    revoked_at TIMESTAMP(0) WITH TIME ZONE,
+    access_count INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS movie_shares_movie_id_idx ON movie_shares USING btree(movie_id);
+
+CREATE TABLE IF NOT EXISTS movie_share_accesses (
+    id BIGSERIAL PRIMARY KEY NOT NULL,
+    share_id BIGINT NOT NULL REFERENCES movie_shares ON DELETE CASCADE,
+    remote_addr TEXT NOT NULL,
+    user_agent TEXT NOT NULL DEFAULT '',
+    accessed_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS movie_share_accesses_share_id_idx ON movie_share_accesses USING btree(share_id);
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS request_usage (
+    user_id UUID NOT NULL REFERENCES users ON DELETE CASCADE,
+    day DATE NOT NULL,
+    endpoint TEXT NOT NULL,
+    count BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (user_id, day, endpoint)
+);
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS login_events (
+    id BIGSERIAL PRIMARY KEY NOT NULL,
+    user_id UUID NOT NULL REFERENCES users ON DELETE CASCADE,
+    token_type TEXT NOT NULL,
+    remote_addr TEXT NOT NULL,
+    user_agent TEXT NOT NULL DEFAULT '',
+    new_remote_addr BOOLEAN NOT NULL DEFAULT FALSE,
+    new_user_agent BOOLEAN NOT NULL DEFAULT FALSE,
+    revoke_hash BYTEA,
+    revoked_at TIMESTAMP(0) WITH TIME ZONE,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS login_events_user_id_idx ON login_events USING btree(user_id);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS audit_logs;
+DELETE FROM permissions WHERE code = 'users:impersonate';
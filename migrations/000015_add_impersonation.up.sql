@@ -0,0 +1,16 @@
+INSERT INTO permissions (code)
+VALUES
+('users:impersonate');
+
+CREATE TABLE IF NOT EXISTS audit_logs (
+    id BIGSERIAL PRIMARY KEY NOT NULL,
+    actor_id UUID NOT NULL REFERENCES users ON DELETE CASCADE,
+    subject_id UUID NOT NULL REFERENCES users ON DELETE CASCADE,
+    impersonation_id TEXT NOT NULL DEFAULT '',
+    action TEXT NOT NULL,
+    status INTEGER NOT NULL DEFAULT 0,
+    remote_addr TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS audit_logs_actor_id_idx ON audit_logs USING btree(actor_id);
+CREATE INDEX IF NOT EXISTS audit_logs_subject_id_idx ON audit_logs USING btree(subject_id);
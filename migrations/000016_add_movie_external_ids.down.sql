@@ -0,0 +1,3 @@
+ALTER TABLE movies DROP CONSTRAINT IF EXISTS movies_external_id_key;
+ALTER TABLE movies DROP COLUMN IF EXISTS external_id;
+ALTER TABLE movies DROP COLUMN IF EXISTS external_source;
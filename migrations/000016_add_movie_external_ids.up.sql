@@ -0,0 +1,3 @@
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS external_source TEXT;
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS external_id TEXT;
+ALTER TABLE movies ADD CONSTRAINT movies_external_id_key UNIQUE (external_source, external_id);
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS watch_progress (
+    user_id UUID NOT NULL REFERENCES users ON DELETE CASCADE,
+    movie_id BIGINT NOT NULL REFERENCES movies ON DELETE CASCADE,
+    position_seconds INTEGER NOT NULL DEFAULT 0 CHECK (position_seconds >= 0),
+    completed BOOL NOT NULL DEFAULT FALSE,
+    updated_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, movie_id)
+);
+CREATE INDEX IF NOT EXISTS watch_progress_user_id_updated_at_idx ON watch_progress USING btree(user_id, updated_at);
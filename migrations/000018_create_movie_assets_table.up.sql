@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS movie_assets (
+    id BIGSERIAL PRIMARY KEY NOT NULL,
+    movie_id BIGINT NOT NULL REFERENCES movies ON DELETE CASCADE,
+    type TEXT NOT NULL,
+    url TEXT,
+    blob_key TEXT,
+    quality TEXT NOT NULL,
+    language TEXT NOT NULL,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    version INTEGER NOT NULL DEFAULT 1,
+    CONSTRAINT movie_assets_type_check CHECK (type IN ('trailer', 'clip', 'full')),
+    CONSTRAINT movie_assets_source_check CHECK ((url IS NULL) <> (blob_key IS NULL))
+);
+CREATE INDEX IF NOT EXISTS movie_assets_movie_id_idx ON movie_assets USING btree(movie_id);
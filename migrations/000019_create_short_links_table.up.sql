@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS short_links (
+    id BIGSERIAL PRIMARY KEY NOT NULL,
+    code TEXT NOT NULL,
+    movie_id BIGINT NOT NULL REFERENCES movies ON DELETE CASCADE,
+    created_by UUID NOT NULL REFERENCES users ON DELETE CASCADE,
+    hit_count BIGINT NOT NULL DEFAULT 0,
+    last_hit_at TIMESTAMP(0) WITH TIME ZONE,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    CONSTRAINT short_links_code_key UNIQUE (code),
+    CONSTRAINT short_links_movie_id_key UNIQUE (movie_id)
+);
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS users_updated_at_idx;
+ALTER TABLE users DROP COLUMN IF EXISTS updated_at;
+
+DROP INDEX IF EXISTS movies_updated_at_idx;
+ALTER TABLE movies DROP COLUMN IF EXISTS updated_at;
@@ -0,0 +1,9 @@
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP(0) WITH TIME ZONE;
+UPDATE movies SET updated_at = created_at WHERE updated_at IS NULL;
+ALTER TABLE movies ALTER COLUMN updated_at SET NOT NULL, ALTER COLUMN updated_at SET DEFAULT NOW();
+CREATE INDEX IF NOT EXISTS movies_updated_at_idx ON movies (updated_at);
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP(0) WITH TIME ZONE;
+UPDATE users SET updated_at = created_at WHERE updated_at IS NULL;
+ALTER TABLE users ALTER COLUMN updated_at SET NOT NULL, ALTER COLUMN updated_at SET DEFAULT NOW();
+CREATE INDEX IF NOT EXISTS users_updated_at_idx ON users (updated_at);
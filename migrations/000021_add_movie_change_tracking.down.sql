@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS movie_tombstones;
+DROP INDEX IF EXISTS movies_change_seq_idx;
+ALTER TABLE movies DROP COLUMN IF EXISTS change_seq;
+DROP SEQUENCE IF EXISTS movie_change_seq;
@@ -0,0 +1,11 @@
+CREATE SEQUENCE IF NOT EXISTS movie_change_seq;
+
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS change_seq BIGINT NOT NULL DEFAULT nextval('movie_change_seq');
+CREATE INDEX IF NOT EXISTS movies_change_seq_idx ON movies (change_seq);
+
+CREATE TABLE IF NOT EXISTS movie_tombstones (
+    movie_id BIGINT PRIMARY KEY NOT NULL,
+    change_seq BIGINT NOT NULL DEFAULT nextval('movie_change_seq'),
+    deleted_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS movie_tombstones_change_seq_idx ON movie_tombstones (change_seq);
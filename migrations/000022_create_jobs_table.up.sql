@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS jobs (
+    id BIGSERIAL PRIMARY KEY NOT NULL,
+    type TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'queued',
+    created_by UUID REFERENCES users ON DELETE SET NULL,
+    total INTEGER NOT NULL DEFAULT 0,
+    processed INTEGER NOT NULL DEFAULT 0,
+    failed INTEGER NOT NULL DEFAULT 0,
+    item_errors JSONB NOT NULL DEFAULT '[]',
+    result JSONB,
+    error TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    started_at TIMESTAMP(0) WITH TIME ZONE,
+    finished_at TIMESTAMP(0) WITH TIME ZONE,
+    CONSTRAINT jobs_status_check CHECK (status IN ('queued', 'running', 'succeeded', 'failed'))
+);
+CREATE INDEX IF NOT EXISTS jobs_created_by_idx ON jobs USING btree(created_by);
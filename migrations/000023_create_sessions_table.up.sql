@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS sessions (
+    hash BYTEA PRIMARY KEY NOT NULL,
+    user_id UUID NOT NULL REFERENCES users ON DELETE CASCADE,
+    remote_addr TEXT NOT NULL DEFAULT '',
+    user_agent TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    last_seen_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    expiry TIMESTAMP(0) WITH TIME ZONE NOT NULL
+);
+CREATE INDEX IF NOT EXISTS sessions_user_id_idx ON sessions USING btree(user_id);
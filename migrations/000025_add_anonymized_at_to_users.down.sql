@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS login_events_user_id_created_at_idx;
+ALTER TABLE users DROP COLUMN IF EXISTS anonymized_at;
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS outbox (
+    id BIGSERIAL PRIMARY KEY NOT NULL,
+    topic TEXT NOT NULL,
+    payload JSONB NOT NULL DEFAULT '{}',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    available_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    processed_at TIMESTAMP(0) WITH TIME ZONE,
+    failed_at TIMESTAMP(0) WITH TIME ZONE
+);
+CREATE INDEX IF NOT EXISTS outbox_pending_idx ON outbox USING btree(available_at) WHERE processed_at IS NULL AND failed_at IS NULL;
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS devices (
+    id BIGSERIAL PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users ON DELETE CASCADE,
+    name TEXT NOT NULL DEFAULT '',
+    token_type TEXT NOT NULL,
+    token_hash BYTEA REFERENCES tokens (hash) ON DELETE CASCADE,
+    token_id TEXT,
+    remote_addr TEXT NOT NULL DEFAULT '',
+    user_agent TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    expiry TIMESTAMP(0) WITH TIME ZONE NOT NULL,
+    revoked_at TIMESTAMP(0) WITH TIME ZONE
+);
+CREATE INDEX IF NOT EXISTS devices_user_id_idx ON devices USING btree(user_id);
+CREATE UNIQUE INDEX IF NOT EXISTS devices_token_id_idx ON devices (token_id) WHERE token_id IS NOT NULL;
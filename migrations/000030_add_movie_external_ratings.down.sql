@@ -0,0 +1,8 @@
+DROP INDEX IF EXISTS movies_external_ratings_updated_at_idx;
+DROP INDEX IF EXISTS movies_rotten_tomatoes_score_idx;
+DROP INDEX IF EXISTS movies_imdb_rating_idx;
+ALTER TABLE movies DROP CONSTRAINT IF EXISTS movies_rotten_tomatoes_score_check;
+ALTER TABLE movies DROP CONSTRAINT IF EXISTS movies_imdb_rating_check;
+ALTER TABLE movies DROP COLUMN IF EXISTS external_ratings_updated_at;
+ALTER TABLE movies DROP COLUMN IF EXISTS rotten_tomatoes_score;
+ALTER TABLE movies DROP COLUMN IF EXISTS imdb_rating;
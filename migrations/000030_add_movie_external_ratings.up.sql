@@ -0,0 +1,8 @@
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS imdb_rating NUMERIC(3, 1);
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS rotten_tomatoes_score SMALLINT;
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS external_ratings_updated_at TIMESTAMP(0) WITH TIME ZONE;
+ALTER TABLE movies ADD CONSTRAINT movies_imdb_rating_check CHECK (imdb_rating BETWEEN 0 AND 10);
+ALTER TABLE movies ADD CONSTRAINT movies_rotten_tomatoes_score_check CHECK (rotten_tomatoes_score BETWEEN 0 AND 100);
+CREATE INDEX IF NOT EXISTS movies_imdb_rating_idx ON movies USING btree(imdb_rating DESC NULLS LAST);
+CREATE INDEX IF NOT EXISTS movies_rotten_tomatoes_score_idx ON movies USING btree(rotten_tomatoes_score DESC NULLS LAST);
+CREATE INDEX IF NOT EXISTS movies_external_ratings_updated_at_idx ON movies USING btree(external_ratings_updated_at NULLS FIRST) WHERE external_source = 'imdb';
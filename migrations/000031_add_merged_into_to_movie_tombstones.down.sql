@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS movie_tombstones_merged_into_idx;
+ALTER TABLE movie_tombstones DROP COLUMN IF EXISTS merged_into;
@@ -0,0 +1,2 @@
+ALTER TABLE movie_tombstones ADD COLUMN IF NOT EXISTS merged_into BIGINT REFERENCES movies ON DELETE SET NULL;
+CREATE INDEX IF NOT EXISTS movie_tombstones_merged_into_idx ON movie_tombstones USING btree(merged_into) WHERE merged_into IS NOT NULL;
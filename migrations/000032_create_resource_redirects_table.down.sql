@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS movie_tombstones_external_id_idx;
+ALTER TABLE movie_tombstones DROP COLUMN IF EXISTS external_id;
+ALTER TABLE movie_tombstones DROP COLUMN IF EXISTS external_source;
+DROP TABLE IF EXISTS resource_redirects;
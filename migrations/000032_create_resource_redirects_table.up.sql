@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS resource_redirects (
+    resource TEXT NOT NULL,
+    old_id TEXT NOT NULL,
+    new_id TEXT NOT NULL,
+    reason TEXT NOT NULL,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (resource, old_id)
+);
+CREATE INDEX IF NOT EXISTS resource_redirects_new_id_idx ON resource_redirects USING btree(resource, new_id);
+
+INSERT INTO resource_redirects (resource, old_id, new_id, reason, created_at)
+SELECT 'movies', movie_id::TEXT, merged_into::TEXT, 'merged', deleted_at FROM movie_tombstones WHERE merged_into IS NOT NULL
+ON CONFLICT (resource, old_id) DO NOTHING;
+
+ALTER TABLE movie_tombstones ADD COLUMN IF NOT EXISTS external_source TEXT;
+ALTER TABLE movie_tombstones ADD COLUMN IF NOT EXISTS external_id TEXT;
+CREATE INDEX IF NOT EXISTS movie_tombstones_external_id_idx ON movie_tombstones USING btree(external_source, external_id) WHERE external_id IS NOT NULL;
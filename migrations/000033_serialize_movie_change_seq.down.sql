@@ -0,0 +1,3 @@
+ALTER TABLE movie_tombstones ALTER COLUMN change_seq SET DEFAULT nextval('movie_change_seq');
+ALTER TABLE movies ALTER COLUMN change_seq SET DEFAULT nextval('movie_change_seq');
+DROP FUNCTION IF EXISTS next_movie_change_seq();
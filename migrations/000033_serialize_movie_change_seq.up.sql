@@ -0,0 +1,11 @@
+-- the change sequence numbers are handed out under a lock held until the writing transaction ends, so they become
+-- visible in the order they've been handed out and the sync clients can't skip a number committed after a later one
+CREATE OR REPLACE FUNCTION next_movie_change_seq() RETURNS BIGINT AS $$
+BEGIN
+    PERFORM pg_advisory_xact_lock(hashtext('movie_change_seq'));
+    RETURN nextval('movie_change_seq');
+END;
+$$ LANGUAGE plpgsql;
+
+ALTER TABLE movies ALTER COLUMN change_seq SET DEFAULT next_movie_change_seq();
+ALTER TABLE movie_tombstones ALTER COLUMN change_seq SET DEFAULT next_movie_change_seq();
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS saved_searches (
+    id BIGSERIAL PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    filter JSONB NOT NULL DEFAULT '{}',
+    alert BOOLEAN NOT NULL DEFAULT false,
+    alert_seq BIGINT NOT NULL DEFAULT 0,
+    alerted_at TIMESTAMP(0) WITH TIME ZONE,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    CONSTRAINT saved_searches_user_id_name_key UNIQUE (user_id, name)
+);
+-- the alert job only reads the searches with alerts
+CREATE INDEX IF NOT EXISTS saved_searches_alert_idx ON saved_searches (alert_seq) WHERE alert;
@@ -0,0 +1,4 @@
+DROP VIEW IF EXISTS user_effective_permissions;
+DROP TABLE IF EXISTS team_permissions;
+DROP TABLE IF EXISTS team_members;
+DROP TABLE IF EXISTS teams;
@@ -0,0 +1,27 @@
+CREATE TABLE IF NOT EXISTS teams (
+    id BIGSERIAL PRIMARY KEY,
+    name TEXT NOT NULL,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    CONSTRAINT teams_name_key UNIQUE (name)
+);
+
+CREATE TABLE IF NOT EXISTS team_members (
+    team_id BIGINT NOT NULL REFERENCES teams ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users ON DELETE CASCADE,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (team_id, user_id)
+);
+-- the permissions of a user are looked up by their teams
+CREATE INDEX IF NOT EXISTS team_members_user_id_idx ON team_members USING btree(user_id);
+
+CREATE TABLE IF NOT EXISTS team_permissions (
+    team_id BIGINT NOT NULL REFERENCES teams ON DELETE CASCADE,
+    permission_id BIGINT NOT NULL REFERENCES permissions ON DELETE CASCADE,
+    PRIMARY KEY (team_id, permission_id)
+);
+
+-- the permissions granted to the users directly along with the ones they inherit from their teams
+CREATE OR REPLACE VIEW user_effective_permissions AS
+SELECT user_id, permission_id FROM user_permissions
+UNION
+SELECT tm.user_id, tp.permission_id FROM team_members AS tm JOIN team_permissions AS tp ON tp.team_id = tm.team_id;
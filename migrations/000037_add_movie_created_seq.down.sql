@@ -0,0 +1,4 @@
+DROP TRIGGER IF EXISTS movies_created_seq ON movies;
+DROP FUNCTION IF EXISTS set_movie_created_seq();
+DROP INDEX IF EXISTS movies_created_seq_idx;
+ALTER TABLE movies DROP COLUMN IF EXISTS created_seq;
@@ -0,0 +1,17 @@
+-- created_seq keeps the change sequence number of the insert of a movie, so the movies created within a range of the
+-- sequence numbers are still found once they've been updated
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS created_seq BIGINT;
+UPDATE movies SET created_seq = change_seq WHERE created_seq IS NULL;
+ALTER TABLE movies ALTER COLUMN created_seq SET NOT NULL;
+CREATE INDEX IF NOT EXISTS movies_created_seq_idx ON movies (created_seq);
+
+-- the defaults are applied before the triggers run, so change_seq is already the number of the insert
+CREATE OR REPLACE FUNCTION set_movie_created_seq() RETURNS TRIGGER AS $$
+BEGIN
+    NEW.created_seq := NEW.change_seq;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS movies_created_seq ON movies;
+CREATE TRIGGER movies_created_seq BEFORE INSERT ON movies FOR EACH ROW EXECUTE FUNCTION set_movie_created_seq();
@@ -0,0 +1,12 @@
+-- request_quota counts the requests of each user in a month against the monthly quota by a single row, so the concurrent
+-- requests are counted one after another by its lock
+CREATE TABLE IF NOT EXISTS request_quota (
+    user_id UUID NOT NULL REFERENCES users ON DELETE CASCADE,
+    month DATE NOT NULL,
+    count BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (user_id, month)
+);
+
+INSERT INTO request_quota (user_id, month, count)
+SELECT user_id, date_trunc('month', day)::date, SUM(count) FROM request_usage GROUP BY 1, 2
+ON CONFLICT (user_id, month) DO NOTHING;
@@ -0,0 +1,69 @@
+// Package migrations embeds the sql migrations so the api knows which schema version it's built against.
+// The migrations themselves are still applied by golang-migrate. check db/migrations/up in the Makefile
+// The throwaway database of the demo command is the exception, it's migrated by the statements of Up.
+package migrations
+
+import (
+	"embed"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+//go:embed *.sql
+var files embed.FS
+
+// LatestVersion returns the highest version of the embedded migrations.
+// It's the version golang-migrate records in schema_migrations once all of them are applied.
+func LatestVersion() uint {
+	entries, err := files.ReadDir(".")
+	if err != nil {
+		return 0
+	}
+	var latest uint
+	for _, entry := range entries {
+		prefix, _, found := strings.Cut(entry.Name(), "_")
+		if !found {
+			continue
+		}
+		version, err := strconv.ParseUint(prefix, 10, 64)
+		if err != nil {
+			continue
+		}
+		latest = max(latest, uint(version))
+	}
+	return latest
+}
+
+// Migration is the up statements of a migration
+type Migration struct {
+	Version uint
+	Name    string
+	SQL     string
+}
+
+// Up returns the up migrations ordered by their version
+func Up() ([]Migration, error) {
+	entries, err := files.ReadDir(".")
+	if err != nil {
+		return nil, err
+	}
+	var ups []Migration
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), ".up.sql") {
+			continue
+		}
+		prefix, _, _ := strings.Cut(entry.Name(), "_")
+		version, err := strconv.ParseUint(prefix, 10, 64)
+		if err != nil {
+			continue
+		}
+		b, err := files.ReadFile(entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		ups = append(ups, Migration{Version: uint(version), Name: entry.Name(), SQL: string(b)})
+	}
+	slices.SortFunc(ups, func(a, b Migration) int { return int(a.Version) - int(b.Version) })
+	return ups, nil
+}
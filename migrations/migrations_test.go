@@ -0,0 +1,18 @@
+package migrations
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUp(t *testing.T) {
+	ups, err := Up()
+	require.NoError(t, err)
+	require.Len(t, ups, int(LatestVersion()))
+	for i, up := range ups {
+		assert.Equal(t, uint(i+1), up.Version, up.Name)
+		assert.NotEmpty(t, up.SQL, up.Name)
+	}
+}